@@ -0,0 +1,71 @@
+// Package env reads the settings of the services from environment variables. Every helper returns def when the
+// variable is unset, and also when it is malformed, logging it: a typo in a tuning knob must not keep a service from
+// starting.
+package env
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+func Int(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func Bool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+func Duration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+func Float(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("invalid %s=%q, using default %g", key, v, def)
+		return def
+	}
+	return f
+}
+
+// String returns def for an empty variable as well
+func String(key string, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
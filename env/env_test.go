@@ -0,0 +1,21 @@
+package env
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMalformedValuesFallBackToTheDefault(t *testing.T) {
+	t.Setenv("ENV_TEST_INT", "12")
+	t.Setenv("ENV_TEST_DURATION", "twelve")
+	t.Setenv("ENV_TEST_BOOL", "")
+	if got := Int("ENV_TEST_INT", 3); got != 12 {
+		t.Errorf("Int = %d, want 12", got)
+	}
+	if got := Duration("ENV_TEST_DURATION", time.Second); got != time.Second {
+		t.Errorf("Duration = %s, want the default", got)
+	}
+	if got := Bool("ENV_TEST_BOOL", true); !got {
+		t.Errorf("Bool = %t, want the default", got)
+	}
+}
@@ -0,0 +1,3 @@
+module geostreamdb/env
+
+go 1.25.4
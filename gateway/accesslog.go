@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+
+	"geostreamdb/env"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// structured (JSON lines, stdout) access log of the HTTP API, sampled: ACCESS_LOG_SAMPLE is the fraction of requests
+// logged (server errors are always logged). /pingArea requests slower than SLOW_QUERY_THRESHOLD are also written to the
+// slow query log with their plan (bbox, precisions, cover size) and per-shard timings
+var ACCESS_LOG_SAMPLE = env.Float("ACCESS_LOG_SAMPLE", 0)          // 0 = disabled, 1 = every request
+var SLOW_QUERY_THRESHOLD = env.Duration("SLOW_QUERY_THRESHOLD", 0) // 0 = disabled
+
+var accessLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("log", "access")
+var slowQueryLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("log", "slow_query")
+
+func logAccess(r *http.Request, route string, m httpsnoop.Metrics) {
+	if ACCESS_LOG_SAMPLE <= 0 || (m.Code < 500 && ACCESS_LOG_SAMPLE < 1 && rand.Float64() >= ACCESS_LOG_SAMPLE) {
+		return
+	}
+	accessLogger.Info("request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"query", r.URL.RawQuery,
+		"route", route,
+		"status", m.Code,
+		"bytes", m.Written,
+		"durationMs", float64(m.Duration.Microseconds())/1000,
+		"remote", r.RemoteAddr,
+		"tenant", tenantFor(r).name,
+		"userAgent", r.UserAgent(),
+		"requestId", traceFrom(r.Context()).requestID,
+		"traceId", traceFrom(r.Context()).traceID,
+	)
+}
+
+func logSlowQuery(r *http.Request, plan *QueryPlan) {
+	if SLOW_QUERY_THRESHOLD <= 0 || plan.Took < float64(SLOW_QUERY_THRESHOLD.Microseconds())/1000 {
+		return
+	}
+	q := plan.query
+	slowQueryLogger.Warn("slow query",
+		"path", r.URL.Path,
+		"tenant", tenantFor(r).name,
+		"requestId", traceFrom(r.Context()).requestID,
+		"traceId", traceFrom(r.Context()).traceID,
+		"minLat", q.minLat,
+		"maxLat", q.maxLat,
+		"minLng", q.minLng,
+		"maxLng", q.maxLng,
+		"plan", plan.explain(),
+	)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand/v2"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// accuracy radius: a ping may carry the accuracy of its fix ("accuracy", meters, as reported by GPS and the browser
+// geolocation API). ACCURACY_MODE decides what a poor fix does to the heatmap:
+//   - point (default): nothing, the ping counts in the cell of its reported location
+//   - spread: the ping is stored at a uniformly random point of its uncertainty circle, so that over many pings each
+//     cell intersecting the circle gets its share of them by area (fractional counts in expectation; the counts
+//     themselves stay whole)
+//   - snap: the ping is stored at the center of the finest geohash cell at least as large as the circle's diameter,
+//     so that it only counts at precisions its fix supports
+//
+// applied after the ingest hooks and the fence, before the tenant's privacy coarsening and ACL
+var ACCURACY_MODE = parseAccuracyMode(env.String("ACCURACY_MODE", accuracyPoint))
+
+const (
+	accuracyPoint  = "point"
+	accuracySpread = "spread"
+	accuracySnap   = "snap"
+	maxAccuracy    = 100_000 // meters, beyond which a fix locates nothing
+)
+
+func parseAccuracyMode(v string) string {
+	switch v {
+	case accuracyPoint, accuracySpread, accuracySnap:
+		return v
+	}
+	log.Fatalf("invalid ACCURACY_MODE=%q (point, spread or snap)", v)
+	return ""
+}
+
+// checkAccuracy returns the error message for an invalid accuracy, "" if it is absent or valid
+func checkAccuracy(accuracy *float64) string {
+	if accuracy != nil && (math.IsNaN(*accuracy) || *accuracy < 0 || *accuracy > maxAccuracy) {
+		return "Invalid accuracy (0 to 100000 meters)"
+	}
+	return ""
+}
+
+// applyAccuracy returns the location to store a ping with that accuracy at, following ACCURACY_MODE
+func applyAccuracy(lat, lng float64, accuracy *float64) (float64, float64) {
+	if accuracy == nil || *accuracy <= 0 {
+		return lat, lng
+	}
+	switch ACCURACY_MODE {
+	case accuracySpread:
+		r, theta := *accuracy/1000*math.Sqrt(rand.Float64()), 2*math.Pi*rand.Float64()
+		dLat, dLng := kmToDegrees(r*math.Cos(theta), r*math.Sin(theta), lat)
+		return clampLat(lat + dLat), wrapLng(lng + dLng)
+	case accuracySnap:
+		cell, ok := geo.Decode(geo.Encode(lat, lng, snapPrecision(lat, *accuracy)))
+		if !ok {
+			return lat, lng
+		}
+		return cell.Center()
+	}
+	return lat, lng
+}
+
+// snapPrecision is the finest precision whose cells at lat are at least 2*accuracy meters wide and high (1 at least)
+func snapPrecision(lat, accuracy float64) int {
+	for p := MAX_GH_PRECISION; p > 1; p-- {
+		if width, height := geo.CellDimsMeters(p, lat); width >= 2*accuracy && height >= 2*accuracy {
+			return p
+		}
+	}
+	return 1
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+func withAccuracyMode(t *testing.T, mode string) {
+	previous := ACCURACY_MODE
+	ACCURACY_MODE = mode
+	t.Cleanup(func() { ACCURACY_MODE = previous })
+}
+
+func TestAccuracySpreadStaysWithinTheCircle(t *testing.T) {
+	withAccuracyMode(t, accuracySpread)
+	accuracy := 250.0
+	var sumLat, sumLng float64
+	const n = 2000
+	for i := 0; i < n; i++ {
+		lat, lng := applyAccuracy(42.23, -8.72, &accuracy)
+		if d := geo.HaversineMeters(42.23, -8.72, lat, lng); d > accuracy*1.01 {
+			t.Fatalf("stored %.0f m away from the fix", d)
+		}
+		sumLat, sumLng = sumLat+lat, sumLng+lng
+	}
+	if d := geo.HaversineMeters(42.23, -8.72, sumLat/n, sumLng/n); d > 25 {
+		t.Errorf("spread centered %.0f m away from the fix", d)
+	}
+}
+
+func TestAccuracySnapsToACellAsLargeAsTheCircle(t *testing.T) {
+	withAccuracyMode(t, accuracySnap)
+	for _, tc := range []struct {
+		accuracy float64
+		want     int
+	}{{5, 8}, {10, 7}, {50, 7}, {100, 6}, {5000, 4}, {100_000, 2}} {
+		if p := snapPrecision(42.23, tc.accuracy); p != tc.want {
+			t.Errorf("%v m: got precision %d, want %d", tc.accuracy, p, tc.want)
+		}
+	}
+	accuracy := 100.0
+	lat, lng := applyAccuracy(42.23, -8.72, &accuracy)
+	cell, _ := geo.Decode(geo.Encode(42.23, -8.72, 6))
+	if centerLat, centerLng := cell.Center(); lat != centerLat || lng != centerLng {
+		t.Errorf("got %v,%v, want the center of the precision 6 cell", lat, lng)
+	}
+}
+
+func TestAccuracyPointKeepsTheFix(t *testing.T) {
+	withAccuracyMode(t, accuracyPoint)
+	accuracy := 500.0
+	if lat, lng := applyAccuracy(42.23, -8.72, &accuracy); lat != 42.23 || lng != -8.72 {
+		t.Errorf("got %v,%v", lat, lng)
+	}
+	withAccuracyMode(t, accuracySpread)
+	if lat, lng := applyAccuracy(42.23, -8.72, nil); lat != 42.23 || lng != -8.72 {
+		t.Errorf("without accuracy: got %v,%v", lat, lng)
+	}
+}
+
+func TestPostPingRejectsInvalidAccuracy(t *testing.T) {
+	for _, accuracy := range []string{"-1", "1e6"} {
+		w := httptest.NewRecorder()
+		postPing(w, httptest.NewRequest("POST", "/ping", strings.NewReader(`{"lat": 42.2, "lng": -8.7, "accuracy": `+accuracy+`}`)))
+		if w.Code != 400 || !strings.Contains(w.Body.String(), "accuracy") {
+			t.Errorf("%s: got %d %s", accuracy, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"geostreamdb/geo"
+	"github.com/go-chi/chi/v5"
+)
+
+// per-tenant access control lists. ACL_FILE (JSON) ties tenants (by name, see TENANTS; "anonymous" for requests
+// without a known key, CoAP and UDP) to the regions they may ingest into and query, as geohash prefixes and/or polygons:
+//
+//	{"acme": {"prefixes": ["u33d", "u33e"], "polygons": [[[lat, lng], ...], ...]}}
+//
+// tenants without an entry are unrestricted. the gateway checks before routing: a ping must fall in one of the regions
+// and a query may only read cells lying entirely within a single region (the cells it would return, so a coarse
+// precision can't read around the region), otherwise it is rejected with 403. device tracks are filtered instead.
+// changed at runtime with
+//
+//	GET /admin/acls, GET /admin/acls/{tenant}, DELETE /admin/acls/{tenant}
+//	PUT /admin/acls/{tenant} {"prefixes": [...], "polygons": [...]}
+//
+// and persisted in STORE_FILE if set (seeded from ACL_FILE, which is never written), in memory only otherwise
+var ACL_FILE = os.Getenv("ACL_FILE")
+
+type tenantACL struct {
+	prefixes []string
+	polygons []*zone
+	raw      aclJSON
+}
+
+// aclJSON is an ACL entry as in ACL_FILE
+type aclJSON struct {
+	Prefixes []string       `json:"prefixes,omitempty"`
+	Polygons [][][2]float64 `json:"polygons,omitempty"`
+}
+
+var acls = struct {
+	sync.RWMutex
+	byTenant map[string]*tenantACL
+}{byTenant: make(map[string]*tenantACL)}
+
+// newTenantACL validates an entry, returning why it is invalid if it is
+func newTenantACL(v aclJSON) (*tenantACL, string) {
+	acl := &tenantACL{raw: v}
+	for _, prefix := range v.Prefixes {
+		if !geo.Valid(prefix) || len(prefix) > 12 {
+			return nil, "Invalid prefix " + strconv.Quote(prefix)
+		}
+		acl.prefixes = append(acl.prefixes, prefix)
+	}
+	if len(v.Polygons) > 0 {
+		// validated like a zone set, one zone per polygon
+		polygons := make(map[string][][2]float64, len(v.Polygons))
+		for i, vertices := range v.Polygons {
+			polygons[strconv.Itoa(i)] = vertices
+		}
+		set, msg := newZoneSet(polygons)
+		if set == nil {
+			return nil, "Invalid polygons: " + msg
+		}
+		acl.polygons = set.zones
+	}
+	return acl, ""
+}
+
+// loadACLs reads the ACLs at startup from STORE_FILE, or ACL_FILE. an invalid entry is fatal: ignoring it would leave
+// the tenant unrestricted
+func loadACLs() {
+	raw, source, seed := loadObjects[aclJSON](storeACLs, ACL_FILE, "ACL_FILE")
+	if ACL_FILE != "" && raw == nil && source == ACL_FILE {
+		log.Fatalf("failed to read ACL_FILE: %s does not exist", ACL_FILE)
+	}
+	acls.Lock()
+	defer acls.Unlock()
+	for name, v := range raw {
+		acl, msg := newTenantACL(v)
+		if acl == nil {
+			log.Fatalf("invalid ACL for %s in %s: %s", name, source, msg)
+		}
+		if len(acl.prefixes) == 0 && len(acl.polygons) == 0 {
+			log.Printf("ACL entry for %s has no regions: every location is denied", name)
+		}
+		acls.byTenant[name] = acl
+	}
+	if raw != nil {
+		log.Printf("loaded ACLs for %d tenants from %s", len(acls.byTenant), source)
+	}
+	if seed {
+		if err := saveACLsLocked(); err != nil {
+			log.Fatalf("failed to save ACLs to STORE_FILE: %v", err)
+		}
+	}
+}
+
+// saveACLsLocked saves the ACLs to STORE_FILE if set. acls must be locked
+func saveACLsLocked() error {
+	if store == nil {
+		return nil
+	}
+	return storeReplace(storeACLs, aclsSnapshotLocked())
+}
+
+func aclsSnapshotLocked() map[string]aclJSON {
+	out := make(map[string]aclJSON, len(acls.byTenant))
+	for name, acl := range acls.byTenant {
+		out[name] = acl.raw
+	}
+	return out
+}
+
+// aclFor returns the tenant's regions, nil if unrestricted
+func aclFor(t *tenant) *tenantACL {
+	acls.RLock()
+	defer acls.RUnlock()
+	return acls.byTenant[t.name]
+}
+
+// allowsPoint reports whether a ping at the point may be stored
+func (a *tenantACL) allowsPoint(lat, lng float64) bool {
+	if a == nil {
+		return true
+	}
+	for _, prefix := range a.prefixes {
+		if geo.Encode(lat, lng, len(prefix)) == prefix {
+			return true
+		}
+	}
+	for _, z := range a.polygons {
+		if z.contains(lat, lng) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsBbox reports whether the box lies within a single region
+func (a *tenantACL) allowsBbox(b geo.Bbox) bool {
+	if a == nil {
+		return true
+	}
+	for _, prefix := range a.prefixes {
+		cell, _ := geo.Decode(prefix)
+		if b.MinLat >= cell.MinLat && b.MaxLat <= cell.MaxLat && b.MinLng >= cell.MinLng && b.MaxLng <= cell.MaxLng {
+			return true
+		}
+	}
+	for _, z := range a.polygons {
+		if z.containsBbox(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsArea reports whether every cell at precision an area query over the box returns lies within a single region
+func (a *tenantACL) allowsArea(b geo.Bbox, precision int) bool {
+	return a == nil || a.allowsBbox(b.Snapped(precision))
+}
+
+// denyACL writes the response for a request outside the tenant's regions
+func denyACL(w http.ResponseWriter, t *tenant) {
+	Metrics.aclDeniedTotal.WithLabelValues(t.name).Inc()
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("Location not allowed for this API key"))
+}
+
+func getACLs(w http.ResponseWriter, r *http.Request) {
+	acls.RLock()
+	out := aclsSnapshotLocked()
+	acls.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+}
+
+func getACL(w http.ResponseWriter, r *http.Request) {
+	acls.RLock()
+	acl, ok := acls.byTenant[chi.URLParam(r, "tenant")]
+	acls.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No ACL for this tenant"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(acl.raw)
+}
+
+func putACL(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tenant")
+	var v aclJSON
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxZoneSetBody)).Decode(&v); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+	acl, msg := newTenantACL(v)
+	if acl == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(msg))
+		return
+	}
+
+	acls.Lock()
+	defer acls.Unlock()
+	prev, existed := acls.byTenant[name]
+	acls.byTenant[name] = acl
+	if err := saveACLsLocked(); err != nil {
+		if existed {
+			acls.byTenant[name] = prev
+		} else {
+			delete(acls.byTenant, name)
+		}
+		log.Printf("failed to save ACLs: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to save ACL"))
+		return
+	}
+	if existed {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func deleteACL(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tenant")
+	acls.Lock()
+	defer acls.Unlock()
+	prev, ok := acls.byTenant[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No ACL for this tenant"))
+		return
+	}
+	delete(acls.byTenant, name)
+	if err := saveACLsLocked(); err != nil {
+		acls.byTenant[name] = prev
+		log.Printf("failed to save ACLs: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to save ACLs"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// adaptive area query limit: MAX_PINGAREA_GEOHASHES bounds the cells of every query, but how many cells a worker can
+// answer within a latency target depends on its load and data. the gateway keeps a moving average of the time per cell
+// of every worker's GetPingArea answers and predicts the latency of a planned query (its slowest call, they run in
+// parallel). queries predicted over AREA_LATENCY_BUDGET are coarsened to the finest precision that fits, or rejected with
+// AREA_BUDGET_MODE=reject. the precision applied is returned in the X-Precision-Used header
+var AREA_LATENCY_BUDGET = env.Duration("AREA_LATENCY_BUDGET", 0) // 0 = disabled (static cap only)
+var AREA_BUDGET_MODE = env.String("AREA_BUDGET_MODE", "coarsen") // or "reject"
+
+const (
+	cellCostAlpha      = 0.2 // weight of a new sample
+	cellCostMinSamples = 64  // cells: smaller answers are dominated by the round trip and are not sampled
+)
+
+var cellCosts = struct {
+	mu      sync.Mutex
+	workers map[string]float64 // seconds per cell at the requested precision
+}{workers: make(map[string]float64)}
+
+// observeCellCost records the latency of a successful call that covered cells (at the requested precision)
+func observeCellCost(worker string, cells int64, d time.Duration) {
+	if cells < cellCostMinSamples {
+		return
+	}
+	sample := d.Seconds() / float64(cells)
+
+	cellCosts.mu.Lock()
+	cost, ok := cellCosts.workers[worker]
+	if ok {
+		cost += cellCostAlpha * (sample - cost)
+	} else {
+		cost = sample
+	}
+	cellCosts.workers[worker] = cost
+	cellCosts.mu.Unlock()
+
+	Metrics.workerCellCost.WithLabelValues(worker).Set(cost)
+}
+
+// callCells is the share of the query's cells (at the requested precision) a planned call covers
+func (plan *QueryPlan) callCells(call *PlannedCall) int64 {
+	if len(plan.cover) == 0 {
+		return 0
+	}
+	return plan.query.estimated * int64(call.Geohashes) / int64(len(plan.cover))
+}
+
+// predictedLatency is the latency of the slowest planned call, by the recent cost per cell of its primary worker
+// (workers without samples yet are assumed free)
+func (plan *QueryPlan) predictedLatency() time.Duration {
+	cellCosts.mu.Lock()
+	defer cellCosts.mu.Unlock()
+
+	slowest := 0.0
+	for _, call := range plan.Shards {
+		if call.Pruned {
+			continue
+		}
+		slowest = max(slowest, cellCosts.workers[call.Workers[0]]*float64(plan.callCells(call)))
+	}
+	return time.Duration(slowest * float64(time.Second))
+}
+
+// PlanWithinBudget plans a query, coarsening (or rejecting with errAreaTooExpensive) it if it is predicted to exceed
+// AREA_LATENCY_BUDGET
+func (p QueryPlanner) PlanWithinBudget(q pingAreaQuery) (*QueryPlan, error) {
+	plan := p.Plan(q)
+	if AREA_LATENCY_BUDGET <= 0 || plan.predictedLatency() <= AREA_LATENCY_BUDGET {
+		return plan, nil
+	}
+	if AREA_BUDGET_MODE == "reject" {
+		Metrics.areaBudgetTotal.WithLabelValues("rejected").Inc()
+		return nil, errAreaTooExpensive
+	}
+
+	for precision := q.precision - 1; precision >= 1; precision-- {
+		coarser, err := p.Query(q.minLat, q.maxLat, q.minLng, q.maxLng, precision)
+		if err != nil {
+			continue
+		}
+		coarser.smooth, coarser.window, coarser.historyGranularity, coarser.movement = q.smooth, q.window, q.historyGranularity, q.movement
+		coarser.hasFloor, coarser.floor = q.hasFloor, q.floor
+		plan = p.Plan(coarser)
+		if plan.predictedLatency() <= AREA_LATENCY_BUDGET {
+			Metrics.areaBudgetTotal.WithLabelValues("coarsened").Inc()
+			return plan, nil
+		}
+	}
+	Metrics.areaBudgetTotal.WithLabelValues("rejected").Inc()
+	return nil, errAreaTooExpensive // at any precision
+}
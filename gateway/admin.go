@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+
+	"geostreamdb/env"
+)
+
+// /admin endpoints require "Authorization: Bearer <ADMIN_TOKEN>". without ADMIN_TOKEN they answer 403, unless
+// ADMIN_INSECURE opts in to leaving them open (never in DEMO_MODE): they set API keys, ACLs and the runtime state
+var ADMIN_TOKEN = os.Getenv("ADMIN_TOKEN")
+var ADMIN_INSECURE = env.Bool("ADMIN_INSECURE", false)
+
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ADMIN_TOKEN == "" {
+			if DEMO_MODE {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("The admin API needs ADMIN_TOKEN in demo mode"))
+				return
+			}
+			if !ADMIN_INSECURE {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("The admin API needs ADMIN_TOKEN (or ADMIN_INSECURE=true)"))
+				return
+			}
+		} else if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+ADMIN_TOKEN)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
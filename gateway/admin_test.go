@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	previousToken, previousInsecure, previousDemo := ADMIN_TOKEN, ADMIN_INSECURE, DEMO_MODE
+	t.Cleanup(func() { ADMIN_TOKEN, ADMIN_INSECURE, DEMO_MODE = previousToken, previousInsecure, previousDemo })
+	handler := adminAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name          string
+		token         string
+		insecure      bool
+		demo          bool
+		authorization string
+		want          int
+	}{
+		{"no token", "", false, false, "", http.StatusForbidden},
+		{"no token, insecure", "", true, false, "", http.StatusNoContent},
+		{"no token, insecure in demo mode", "", true, true, "", http.StatusForbidden},
+		{"token, missing header", "s3cr3t", false, false, "", http.StatusUnauthorized},
+		{"token, wrong header", "s3cr3t", true, false, "Bearer other", http.StatusUnauthorized},
+		{"token", "s3cr3t", false, false, "Bearer s3cr3t", http.StatusNoContent},
+		{"token in demo mode", "s3cr3t", false, true, "Bearer s3cr3t", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ADMIN_TOKEN, ADMIN_INSECURE, DEMO_MODE = tt.token, tt.insecure, tt.demo
+			r := httptest.NewRequest(http.MethodPut, "/admin/tenants/acme", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceDeletionIsAnAdminRoute(t *testing.T) {
+	previousToken, previousInsecure := ADMIN_TOKEN, ADMIN_INSECURE
+	t.Cleanup(func() { ADMIN_TOKEN, ADMIN_INSECURE = previousToken, previousInsecure })
+	ADMIN_TOKEN, ADMIN_INSECURE = "s3cr3t", false
+	router := setup_router()
+
+	for path, want := range map[string]int{
+		"/device/truck-42":       http.StatusNotFound, // not served with the ingest routes any more
+		"/admin/device/truck-42": http.StatusUnauthorized,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, path, nil))
+		if rec.Code != want {
+			t.Errorf("DELETE %s: got %d, want %d", path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/status"
+)
+
+// GET /anomalies?since=<unix ms>&kind=spike|drop&prefix=<geohash>: the spikes and drops the workers flagged per prefix
+// (see ANOMALY_INTERVAL in the worker), in intervals ending after since (all the workers keep), oldest first. each
+// worker flags the prefixes of the pings it holds as primary, so a prefix split across workers (coarser than the
+// sharding) is judged per worker. prefix keeps the anomalies within it; the tenant's ACL those of the prefixes it may
+// read. workers without detection are listed with enabled false, those that didn't answer with their error
+type workerAnomalies struct {
+	Enabled         bool   `json:"enabled"`
+	IntervalSeconds int64  `json:"intervalSeconds,omitempty"`
+	Precision       int32  `json:"precision,omitempty"`
+	Baseline        string `json:"baseline,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+type areaAnomaly struct {
+	Prefix   string  `json:"prefix"`
+	Kind     string  `json:"kind"`
+	Start    int64   `json:"start"` // unix ms
+	End      int64   `json:"end"`
+	Observed int64   `json:"observed"`
+	Expected float64 `json:"expected"`
+	Score    float64 `json:"score"`
+	Worker   string  `json:"worker"`
+}
+
+func getAnomalies(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since int64
+	if sinceQ := query.Get("since"); sinceQ != "" {
+		var err error
+		if since, err = strconv.ParseInt(sinceQ, 10, 64); err != nil || since < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid since (unix ms)"))
+			return
+		}
+	}
+	kind := query.Get("kind")
+	if kind != "" && kind != "spike" && kind != "drop" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid kind (spike or drop)"))
+		return
+	}
+	prefix := query.Get("prefix")
+	if prefix != "" && (!geo.Valid(prefix) || len(prefix) > MAX_GH_PRECISION) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid prefix"))
+		return
+	}
+	t := tenantFor(r)
+	if !admitUsage(w, r, unitCells, 1) {
+		return
+	}
+
+	workers := make(map[string]*workerAnomalies)
+	found := []areaAnomaly{}
+	var mu sync.Mutex
+	err := service.Broadcast(r.Context(), "GetAnomalies", func(ctx context.Context, addr string, client pb.WorkerClient) error {
+		if !state.supports(addr, pb.FeatureAnomalies) {
+			mu.Lock()
+			workers[addr] = &workerAnomalies{}
+			mu.Unlock()
+			return nil
+		}
+		v, err := client.GetAnomalies(ctx, &pb.GetAnomaliesRequest{Since: since, ApiVersion: state.apiVersion(addr)})
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			workers[addr] = &workerAnomalies{Error: status.Convert(err).Message()}
+			return err
+		}
+		workers[addr] = &workerAnomalies{Enabled: v.Enabled, IntervalSeconds: v.IntervalSeconds, Precision: v.Precision, Baseline: v.Baseline}
+		for _, a := range v.Anomalies {
+			if (kind != "" && a.Kind != kind) || !strings.HasPrefix(a.Prefix, prefix) && !strings.HasPrefix(prefix, a.Prefix) {
+				continue
+			}
+			found = append(found, areaAnomaly{Prefix: a.Prefix, Kind: a.Kind, Start: a.Start, End: a.End, Observed: a.Observed, Expected: a.Expected, Score: a.Score, Worker: addr})
+		}
+		return nil
+	})
+	if errors.Is(err, errNoWorkers) {
+		writeError(w, err)
+		return
+	}
+
+	acl, privacy := aclFor(t), privacyFor(t)
+	visible := found[:0]
+	for _, a := range found {
+		if cell, ok := geo.Decode(a.Prefix); !ok || !acl.allowsArea(cell, len(a.Prefix)) {
+			continue
+		}
+		a.Observed = privacy.suppressCount(t, a.Observed)
+		visible = append(visible, a)
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		if visible[i].Start != visible[j].Start {
+			return visible[i].Start < visible[j].Start
+		}
+		if visible[i].Prefix != visible[j].Prefix {
+			return visible[i].Prefix < visible[j].Prefix
+		}
+		return visible[i].Worker < visible[j].Worker
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"anomalies": visible, "workers": workers, "complete": err == nil})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc"
+)
+
+type anomalousWorker struct {
+	fakeWorker
+	since     int64
+	anomalies []*pb.Anomaly
+}
+
+func (w *anomalousWorker) GetAnomalies(ctx context.Context, in *pb.GetAnomaliesRequest, opts ...grpc.CallOption) (*pb.GetAnomaliesResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.since = in.Since
+	if w.anomalies == nil {
+		return &pb.GetAnomaliesResponse{}, nil
+	}
+	return &pb.GetAnomaliesResponse{Enabled: true, IntervalSeconds: 60, Precision: 4, Baseline: "ewma", Anomalies: w.anomalies}, nil
+}
+
+func TestGetAnomaliesMergesWorkers(t *testing.T) {
+	a := &anomalousWorker{anomalies: []*pb.Anomaly{
+		{Prefix: "u4pr", Kind: "drop", Start: 60_000, End: 120_000, Observed: 0, Expected: 50, Score: -7.07},
+		{Prefix: "ezjm", Kind: "spike", Start: 60_000, End: 120_000, Observed: 400, Expected: 100, Score: 30},
+		{Prefix: "ezjq", Kind: "spike", Start: 0, End: 60_000, Observed: 90, Expected: 10, Score: 25.3},
+	}}
+	b := &anomalousWorker{}
+	previous := service
+	service = newGatewayService(fakeRing{servers: []string{"a", "b"}}, fakeWorkersOf{"a": a, "b": b})
+	t.Cleanup(func() { service = previous })
+
+	var resp struct {
+		Anomalies []areaAnomaly               `json:"anomalies"`
+		Workers   map[string]*workerAnomalies `json:"workers"`
+		Complete  bool                        `json:"complete"`
+	}
+	w := httptest.NewRecorder()
+	getAnomalies(w, httptest.NewRequest("GET", "/anomalies?since=1000", nil))
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != 200 || a.since != 1000 || !resp.Complete || len(resp.Anomalies) != 3 {
+		t.Fatalf("got %d %+v", w.Code, resp)
+	}
+	if resp.Anomalies[0].Prefix != "ezjq" || resp.Anomalies[1].Prefix != "ezjm" || resp.Anomalies[2].Prefix != "u4pr" || resp.Anomalies[2].Worker != "a" {
+		t.Errorf("got %+v, want oldest first, then by prefix", resp.Anomalies)
+	}
+	if !resp.Workers["a"].Enabled || resp.Workers["a"].IntervalSeconds != 60 || resp.Workers["b"].Enabled {
+		t.Errorf("got workers %+v %+v", resp.Workers["a"], resp.Workers["b"])
+	}
+
+	resp.Anomalies = nil
+	w = httptest.NewRecorder()
+	getAnomalies(w, httptest.NewRequest("GET", "/anomalies?kind=spike&prefix=ezjm", nil))
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Anomalies) != 1 || resp.Anomalies[0].Prefix != "ezjm" {
+		t.Errorf("filtered: got %+v", resp.Anomalies)
+	}
+}
+
+func TestGetAnomaliesValidates(t *testing.T) {
+	for _, q := range []string{"since=yesterday", "since=-1", "kind=dip", "prefix=ezjm!"} {
+		w := httptest.NewRecorder()
+		getAnomalies(w, httptest.NewRequest("GET", "/anomalies?"+q, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: got %d", q, w.Code)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+// protocol versions negotiated with the workers (see proto/version.go). every request to a worker carries the version
+// negotiated from its latest heartbeat; workers whose range doesn't overlap the gateway's are refused at heartbeat
+
+func (g *GatewayState) setAPIVersion(address string, version uint32) {
+	g.versionsMutex.Lock()
+	g.versions[address] = version
+	g.versionsMutex.Unlock()
+	Metrics.workerAPIVersion.WithLabelValues(address).Set(float64(version))
+}
+
+func (g *GatewayState) deleteAPIVersion(address string) {
+	g.versionsMutex.Lock()
+	delete(g.versions, address)
+	g.versionsMutex.Unlock()
+	Metrics.workerAPIVersion.DeleteLabelValues(address)
+}
+
+// apiVersion is the version to send requests to a worker at (0 before its first heartbeat, as with pre-versioning peers)
+func (g *GatewayState) apiVersion(address string) uint32 {
+	g.versionsMutex.RLock()
+	defer g.versionsMutex.RUnlock()
+	return g.versions[address]
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// write acknowledgment modes for POST /ping: ?ack=leader (the default) answers once the primary stored the ping, like
+// consistency ONE, and ack=all once every replica did, like consistency ALL. ack=none is fire-and-forget for
+// high-volume ingesters: the ping is queued on the gateway and answered with 202 right away, then routed in the
+// background by ASYNC_INGEST_WORKERS senders. queued pings are lost if the gateway stops or their worker fails; a
+// full queue rejects the ping with 503 so clients can back off
+var ASYNC_INGEST_QUEUE = env.Int("ASYNC_INGEST_QUEUE", 65536) // pings
+var ASYNC_INGEST_WORKERS = env.Int("ASYNC_INGEST_WORKERS", 64)
+
+const (
+	ackNone   = "none"
+	ackLeader = "leader"
+	ackAll    = "all"
+)
+
+type asyncPing struct {
+	gh         string
+	ingestedAt int64
+	tenant     *tenant // that ingested it, kept with its device (see withTenant)
+	deviceID   string
+	seq        uint64
+	sentAt     int64      // client send time (see freshness.go)
+	motion     *pb.Motion // speed and heading (see movement.go)
+	floor      *int32     // see floors.go
+	retries    int        // left after a failed send (pings over the write budget, see writebudget.go)
+}
+
+var asyncQueue chan asyncPing
+
+// parseAck reads the acknowledgment mode and folds it into the consistency level: ack=leader is ONE and ack=all is
+// ALL, and an explicit consistency must agree with it. ack=none takes no consistency level
+func parseAck(r *http.Request) (ack string, level string, ok bool) {
+	level, ok = parseConsistency(r)
+	if !ok {
+		return "", "", false
+	}
+	explicit := r.URL.Query().Get("consistency") != "" || r.Header.Get("X-Consistency") != ""
+	switch ack = r.URL.Query().Get("ack"); ack {
+	case "":
+		return ackLeader, level, true
+	case ackLeader:
+		return ack, consistencyOne, !explicit || level == consistencyOne
+	case ackAll:
+		return ack, consistencyAll, !explicit || level == consistencyAll
+	case ackNone:
+		return ack, "", !explicit
+	}
+	return "", "", false
+}
+
+// startAsyncIngest starts the senders draining the ack=none queue
+func startAsyncIngest() {
+	asyncQueue = make(chan asyncPing, max(1, ASYNC_INGEST_QUEUE))
+	for i := 0; i < max(1, ASYNC_INGEST_WORKERS); i++ {
+		go func() {
+			for p := range asyncQueue {
+				_, err := service.RoutePing(withTenant(withFloor(withMotion(withSentAt(context.Background(), p.sentAt), p.motion), p.floor), p.tenant), p.gh, p.ingestedAt, p.deviceID, p.seq)
+				if errors.Is(err, errDuplicatePing) {
+					Metrics.asyncPingsTotal.WithLabelValues("duplicate").Inc()
+					continue
+				}
+				if err != nil {
+					if retryAsync(p, err) {
+						Metrics.asyncPingsTotal.WithLabelValues("retried").Inc()
+						continue
+					}
+					Metrics.asyncPingsTotal.WithLabelValues("failed").Inc()
+					continue
+				}
+				Metrics.asyncPingsTotal.WithLabelValues("sent").Inc()
+			}
+		}()
+	}
+}
+
+// enqueuePing queues an ack=none ping, false if the queue is full
+func enqueuePing(gh string, ingestedAt int64, t *tenant, deviceID string, seq uint64, sentAt int64, motion *pb.Motion, floor *int32) bool {
+	return queuePing(asyncPing{gh: gh, ingestedAt: ingestedAt, tenant: t, deviceID: deviceID, seq: seq, sentAt: sentAt, motion: motion, floor: floor})
+}
+
+// queuePing queues a ping for the senders, false if the queue is full
+func queuePing(p asyncPing) bool {
+	select {
+	case asyncQueue <- p:
+		return true
+	default:
+		Metrics.asyncPingsTotal.WithLabelValues("rejected").Inc()
+		return false
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/status"
+)
+
+// GET /pings?points=lat,lng;lat,lng;...: the GET /ping count of many points in one request, for dashboards tracking
+// individual coordinates. points are grouped by the workers holding them and every group is resolved by one
+// GetPingsBatch call (hedged like GET /ping), which looks each trie slot up once for the whole group. points whose
+// worker failed carry an error and the response is marked incomplete
+var MAX_BATCH_POINTS = env.Int("MAX_BATCH_POINTS", 1000)
+
+type batchPoint struct {
+	Lat     float64 `json:"lat"`
+	Lng     float64 `json:"lng"`
+	Geohash string  `json:"geohash"`
+	Count   int64   `json:"count"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func getPings(w http.ResponseWriter, r *http.Request) {
+	latLngs, ok := parseLatLngs(r.URL.Query().Get("points"), 1, MAX_BATCH_POINTS)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid points (expected 1 to " + strconv.Itoa(MAX_BATCH_POINTS) + " lat,lng pairs separated by ';')"))
+		return
+	}
+
+	t := tenantFor(r)
+	acl := aclFor(t)
+	points := make([]batchPoint, len(latLngs))
+	for i, p := range latLngs {
+		if !acl.allowsArea(geo.Bbox{MinLat: p.Lat, MaxLat: p.Lat, MinLng: p.Lng, MaxLng: p.Lng}, MAX_GH_PRECISION) {
+			denyACL(w, t)
+			return
+		}
+		points[i] = batchPoint{Lat: p.Lat, Lng: p.Lng, Geohash: geo.Encode(p.Lat, p.Lng, MAX_GH_PRECISION)}
+	}
+	if !admitUsage(w, r, unitCells, int64(len(points))) {
+		return
+	}
+
+	timestamp, err := queryPoints(r.Context(), points)
+	if errors.Is(err, errNoWorkers) {
+		writeError(w, err)
+		return
+	}
+
+	complete := true
+	privacy := privacyFor(t)
+	for i := range points {
+		if points[i].Error != "" {
+			complete = false
+			continue
+		}
+		points[i].Count = privacy.suppressCount(t, points[i].Count)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"points": points, "timestamp": timestamp, "complete": complete})
+}
+
+// queryPoints fills in the count of every point, one GetPingsBatch per group of points sharing their workers, and
+// returns the latest worker timestamp. errNoWorkers if the ring is empty
+func queryPoints(ctx context.Context, points []batchPoint) (int64, error) {
+	type group struct {
+		addrs   []string
+		indices []int
+	}
+	groups := make(map[string]*group)
+	for i, p := range points {
+		chains := ownerChains(p.Geohash) // two during a sharding migration, whose counts are added up
+		if len(chains) == 0 {
+			return 0, errNoWorkers
+		}
+		for _, addrs := range chains {
+			key := strings.Join(addrs, ",")
+			g, ok := groups[key]
+			if !ok {
+				g = &group{addrs: addrs}
+				groups[key] = g
+				Metrics.geohashRequestsTotal.WithLabelValues(addrs[0], "routed", reasonShardOwner).Inc()
+			}
+			g.indices = append(g.indices, i)
+		}
+	}
+
+	var timestamp int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, g := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			geohashes := make([]string, len(g.indices))
+			for j, i := range g.indices {
+				geohashes[j] = points[i].Geohash
+			}
+			v, _, err := hedgedCall(ctx, "GetPingsBatch", g.addrs, func(ctx context.Context, addr string, replica bool) (*pb.GetPingsBatchResponse, error) {
+				conn, err := state.GetConn(addr)
+				if err != nil {
+					return nil, err
+				}
+				start := time.Now()
+				v, err := pb.NewWorkerClient(conn).GetPingsBatch(ctx, &pb.GetPingsBatchRequest{Geohashes: geohashes, Replica: replica, ApiVersion: state.apiVersion(addr)})
+				observeGRPC("GetPingsBatch", addr, err, start)
+				return v, err
+			})
+			if err == nil && len(v.Counts) != len(geohashes) {
+				err = errors.New("worker answered for another number of points")
+			}
+
+			mu.Lock() // a point is in two groups during a sharding migration, and timestamp is shared
+			defer mu.Unlock()
+			for j, i := range g.indices {
+				if err != nil {
+					points[i].Error = status.Convert(err).Message()
+					continue
+				}
+				points[i].Count += v.Counts[j]
+			}
+			if err == nil {
+				timestamp = max(timestamp, v.Timestamp)
+			}
+		}()
+	}
+	wg.Wait()
+	return timestamp, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// build identification, set at link time:
+//
+//	go build -ldflags "-X main.buildVersion=v1.2.0 -X main.buildCommit=$(git rev-parse --short HEAD)"
+//
+// without it the version reads "dev" and the commit is taken from the VCS stamp of builds inside a git checkout.
+// served on /version (PORT), sent in registry heartbeats and exposed as gateway_build_info.
+//
+// workers send their version in heartbeats. a worker release whose major version differs from the gateway's, or whose
+// minor version is more than VERSION_MAX_MINOR_SKEW apart, is logged and flagged in gateway_worker_build_incompatible
+// (it is still used: the protocol versions decide what is refused, see apiversion.go). "dev" and other versions that
+// aren't vMAJOR.MINOR[.PATCH] are never flagged
+var buildVersion = "dev"
+var buildCommit = ""
+
+var VERSION_MAX_MINOR_SKEW = env.Int("VERSION_MAX_MINOR_SKEW", 1)
+
+type buildInfo struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	GoVersion     string `json:"goVersion"`
+	APIVersion    uint32 `json:"apiVersion"`
+	MinAPIVersion uint32 `json:"minApiVersion"`
+}
+
+var build = readBuildInfo()
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: buildVersion, Commit: buildCommit, GoVersion: runtime.Version(), APIVersion: pb.API_VERSION, MinAPIVersion: pb.MIN_API_VERSION}
+	if bi, ok := debug.ReadBuildInfo(); ok && info.Commit == "" {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				info.Commit = s.Value
+			}
+		}
+	}
+	Metrics.buildInfo.WithLabelValues(info.Version, info.Commit, info.GoVersion).Set(1)
+	return info
+}
+
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(build)
+}
+
+// parseRelease reads vMAJOR.MINOR[.PATCH][-suffix]. ok is false for anything else
+func parseRelease(v string) (major, minor int, ok bool) {
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, 0, false
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	return major, minor, err1 == nil && err2 == nil
+}
+
+// buildsCompatible reports whether a peer release may run alongside ours (unknown releases are assumed to)
+func buildsCompatible(ours, theirs string) bool {
+	major, minor, ok1 := parseRelease(ours)
+	peerMajor, peerMinor, ok2 := parseRelease(theirs)
+	if !ok1 || !ok2 {
+		return true
+	}
+	return major == peerMajor && max(minor-peerMinor, peerMinor-minor) <= VERSION_MAX_MINOR_SKEW
+}
+
+// setBuildVersion records the release a worker announced, warning when it changes to an incompatible one
+func (g *GatewayState) setBuildVersion(address string, version string) {
+	g.versionsMutex.Lock()
+	previous, known := g.builds[address]
+	g.builds[address] = version
+	g.versionsMutex.Unlock()
+	if known && previous == version {
+		return
+	}
+
+	if buildsCompatible(build.Version, version) {
+		Metrics.workerBuildMismatch.WithLabelValues(address).Set(0)
+		return
+	}
+	Metrics.workerBuildMismatch.WithLabelValues(address).Set(1)
+	log.Printf("worker %s runs %s, incompatible with this gateway's %s: upgrade one of them", address, version, build.Version)
+}
+
+func (g *GatewayState) deleteBuildVersion(address string) {
+	g.versionsMutex.Lock()
+	delete(g.builds, address)
+	g.versionsMutex.Unlock()
+	Metrics.workerBuildMismatch.DeleteLabelValues(address)
+}
+
+// buildVersionOf returns the release a worker announced ("" before its first heartbeat or from older builds)
+func (g *GatewayState) buildVersionOf(address string) string {
+	g.versionsMutex.RLock()
+	defer g.versionsMutex.RUnlock()
+	return g.builds[address]
+}
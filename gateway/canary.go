@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// synthetic canary: every CANARY_INTERVAL the gateway writes a ping to each worker and reads it back through the same
+// path as POST /ping and GET /ping (routing, replicas, read token), so a worker that takes pings but doesn't serve them,
+// or a route that went wrong, shows up before users notice. the pings land in the reserved CANARY_GEOHASH cell (Point
+// Nemo by default, where no device should be), under the first shard key of it each worker is primary for: area queries
+// over that cell count them. results are exported as gateway_canary_probes_total / gateway_canary_duration_seconds per
+// worker and listed in GET /healthz, which fails (503) once no worker passes
+var CANARY_INTERVAL = env.Duration("CANARY_INTERVAL", 30*time.Second) // 0 = disabled
+var CANARY_GEOHASH = env.String("CANARY_GEOHASH", "1r23")
+
+const maxCanaryCandidates = 1 << 16 // shard keys tried to find one per worker
+
+type canaryResult struct {
+	Geohash     string  `json:"geohash,omitempty"`
+	OK          bool    `json:"ok"`
+	DurationMs  float64 `json:"durationMs,omitempty"`
+	CheckedAt   int64   `json:"checkedAt"`             // unix ms
+	LastSuccess int64   `json:"lastSuccess,omitempty"` // unix ms
+	Error       string  `json:"error,omitempty"`
+}
+
+var canary = struct {
+	sync.Mutex
+	results map[string]*canaryResult // worker address -> last probe
+}{results: make(map[string]*canaryResult)}
+
+func startCanary() {
+	if CANARY_INTERVAL <= 0 {
+		return
+	}
+	if CANARY_GEOHASH == "" || len(CANARY_GEOHASH) > MAX_GH_PRECISION {
+		log.Fatalf("invalid CANARY_GEOHASH %q", CANARY_GEOHASH)
+	}
+	for i := 0; i < len(CANARY_GEOHASH); i++ {
+		if geo.CharIndex(CANARY_GEOHASH[i]) < 0 {
+			log.Fatalf("invalid CANARY_GEOHASH %q", CANARY_GEOHASH)
+		}
+	}
+	go func() {
+		for range time.Tick(CANARY_INTERVAL) {
+			probeWorkers()
+		}
+	}()
+}
+
+// canaryGeohashes returns a max precision geohash of the canary cell per worker it has a shard key for as primary,
+// stopping once it has one for each of the servers
+func canaryGeohashes(servers int) map[string]string {
+	prefix := strings.ToLower(CANARY_GEOHASH)
+	precision := shardingPrecision()
+	out := make(map[string]string, servers)
+	if precision <= len(prefix) {
+		precision = len(prefix) // a single key
+	}
+	suffix := make([]byte, precision-len(prefix))
+	for i := 0; i < maxCanaryCandidates && len(out) < servers; i++ {
+		n := i
+		for j := len(suffix) - 1; j >= 0; j-- {
+			suffix[j] = geo.Base32[n%32]
+			n /= 32
+		}
+		if n > 0 {
+			break // every key of the cell tried
+		}
+		gh := prefix + string(suffix) + strings.Repeat("0", MAX_GH_PRECISION-precision)
+		if addrs := state.GetNodeAddresses(shardKey(gh), 1); len(addrs) > 0 {
+			if _, ok := out[addrs[0]]; !ok {
+				out[addrs[0]] = gh
+			}
+		}
+	}
+	return out
+}
+
+// probeWorkers runs a canary round, one probe per worker in parallel
+func probeWorkers() {
+	servers := slices.DeleteFunc(state.workerServers(), state.isDraining) // not routed to, left to the rolling restart
+	geohashes := canaryGeohashes(len(servers))
+
+	var wg sync.WaitGroup
+	for _, addr := range servers {
+		gh, ok := geohashes[addr]
+		if !ok {
+			recordCanary(addr, &canaryResult{Error: "no canary shard key routes to this worker"})
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := probe(gh)
+			res := &canaryResult{Geohash: gh, OK: err == nil, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				res.Error = err.Error()
+				Metrics.canaryProbesTotal.WithLabelValues(addr, "failed").Inc()
+			} else {
+				Metrics.canaryProbesTotal.WithLabelValues(addr, "ok").Inc()
+				Metrics.canaryDuration.WithLabelValues(addr).Observe(time.Since(start).Seconds())
+			}
+			recordCanary(addr, res)
+		}()
+	}
+	wg.Wait()
+
+	// forget the workers that left the ring (or are draining)
+	canary.Lock()
+	defer canary.Unlock()
+	for addr := range canary.results {
+		if !slices.Contains(servers, addr) {
+			delete(canary.results, addr)
+		}
+	}
+}
+
+// probe writes a ping of gh and reads it back, through its read token if the primary gave one
+func probe(gh string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), CANARY_INTERVAL)
+	defer cancel()
+	v, err := service.RoutePing(ctx, gh, time.Now().UnixMilli(), "", 0)
+	if err != nil {
+		return err
+	}
+	if token, ok := tokenOf(v); ok {
+		_, err = readAfter(ctx, gh, token)
+		return err
+	}
+	read, err := service.QueryPoint(ctx, gh)
+	if err == nil && read.Count == 0 {
+		return errTokenNotReached
+	}
+	return err
+}
+
+func recordCanary(addr string, res *canaryResult) {
+	res.CheckedAt = time.Now().UnixMilli()
+	canary.Lock()
+	defer canary.Unlock()
+	if res.OK {
+		res.LastSuccess = res.CheckedAt
+	} else if prev, ok := canary.results[addr]; ok {
+		res.LastSuccess = prev.LastSuccess
+	}
+	canary.results[addr] = res
+}
+
+// GET /healthz: 200 while the ring has workers and, with the canary enabled, one of them passed its last probe
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	workers := len(state.workerServers())
+	canary.Lock()
+	results := make(map[string]canaryResult, len(canary.results))
+	passing := 0
+	for addr, res := range canary.results {
+		results[addr] = *res
+		if res.OK {
+			passing++
+		}
+	}
+	canary.Unlock()
+
+	status, code := "ok", http.StatusOK
+	switch {
+	case workers == 0:
+		status, code = "no workers", http.StatusServiceUnavailable
+	case CANARY_INTERVAL > 0 && len(results) > 0 && passing == 0:
+		status, code = "canary failing", http.StatusServiceUnavailable
+	case passing < len(results):
+		status = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"status": status, "workers": workers, "canary": results})
+}
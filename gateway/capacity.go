@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// cluster capacity: workers send their latest resource sample in heartbeats (see worker-node/capacity.go). the gateway
+// exports it as gateway_worker_capacity{worker_node,resource} and GET /admin/capacity lists it per worker of the ring,
+// with the cluster totals: memory and CPU used against the workers' limits, and how much of it the time buffers take
+type workerCapacity struct {
+	SampledAt          int64   `json:"sampledAt"` // unix ms, worker clock
+	ResidentBytes      uint64  `json:"residentBytes"`
+	HeapBytes          uint64  `json:"heapBytes"`
+	MemoryLimitBytes   uint64  `json:"memoryLimitBytes,omitempty"` // GOMEMLIMIT
+	CPUCores           float64 `json:"cpuCores"`
+	MaxProcs           uint32  `json:"maxProcs"`
+	Goroutines         uint32  `json:"goroutines"`
+	BufferBytes        uint64  `json:"bufferBytes"` // estimated, time buffers
+	ReplicaBufferBytes uint64  `json:"replicaBufferBytes"`
+	RingShare          float64 `json:"ringShare"`
+}
+
+type clusterCapacity struct {
+	Workers            int     `json:"workers"`
+	Reporting          int     `json:"reporting"` // workers that sent a sample (older builds don't)
+	ResidentBytes      uint64  `json:"residentBytes"`
+	HeapBytes          uint64  `json:"heapBytes"`
+	MemoryLimitBytes   uint64  `json:"memoryLimitBytes,omitempty"` // only once every reporting worker has one
+	MemoryUtilization  float64 `json:"memoryUtilization,omitempty"`
+	CPUCores           float64 `json:"cpuCores"`
+	MaxProcs           uint32  `json:"maxProcs"`
+	CPUUtilization     float64 `json:"cpuUtilization"`
+	BufferBytes        uint64  `json:"bufferBytes"`
+	ReplicaBufferBytes uint64  `json:"replicaBufferBytes"`
+	Goroutines         uint32  `json:"goroutines"`
+}
+
+func (g *GatewayState) setCapacity(address string, c *pb.WorkerCapacity) {
+	if c == nil {
+		return // not sampled yet, or an older worker
+	}
+	g.capacityMutex.Lock()
+	g.capacity[address] = c
+	g.capacityMutex.Unlock()
+	for resource, v := range map[string]float64{
+		"resident_bytes":       float64(c.ResidentBytes),
+		"heap_bytes":           float64(c.HeapBytes),
+		"memory_limit_bytes":   float64(c.MemoryLimitBytes),
+		"cpu_cores":            c.CpuCores,
+		"max_procs":            float64(c.MaxProcs),
+		"goroutines":           float64(c.Goroutines),
+		"buffer_bytes":         float64(c.BufferBytes),
+		"replica_buffer_bytes": float64(c.ReplicaBufferBytes),
+	} {
+		Metrics.workerCapacity.WithLabelValues(address, resource).Set(v)
+	}
+}
+
+func (g *GatewayState) deleteCapacity(address string) {
+	g.capacityMutex.Lock()
+	delete(g.capacity, address)
+	g.capacityMutex.Unlock()
+	Metrics.workerCapacity.DeletePartialMatch(map[string]string{"worker_node": address})
+}
+
+// capacityOf returns the latest sample of a worker, nil if it sent none
+func (g *GatewayState) capacityOf(address string) *pb.WorkerCapacity {
+	g.capacityMutex.RLock()
+	defer g.capacityMutex.RUnlock()
+	return g.capacity[address]
+}
+
+func getCapacity(w http.ResponseWriter, r *http.Request) {
+	servers := state.workerServers()
+	shares := state.ringShares()
+	workers := make(map[string]*workerCapacity, len(servers))
+	cluster := clusterCapacity{Workers: len(servers)}
+	limited := 0
+	for _, addr := range servers {
+		c := state.capacityOf(addr)
+		if c == nil {
+			workers[addr] = nil
+			continue
+		}
+		workers[addr] = &workerCapacity{
+			SampledAt:          c.SampledAt,
+			ResidentBytes:      c.ResidentBytes,
+			HeapBytes:          c.HeapBytes,
+			MemoryLimitBytes:   c.MemoryLimitBytes,
+			CPUCores:           c.CpuCores,
+			MaxProcs:           c.MaxProcs,
+			Goroutines:         c.Goroutines,
+			BufferBytes:        c.BufferBytes,
+			ReplicaBufferBytes: c.ReplicaBufferBytes,
+			RingShare:          shares[addr],
+		}
+		cluster.Reporting++
+		cluster.ResidentBytes += c.ResidentBytes
+		cluster.HeapBytes += c.HeapBytes
+		cluster.CPUCores += c.CpuCores
+		cluster.MaxProcs += c.MaxProcs
+		cluster.Goroutines += c.Goroutines
+		cluster.BufferBytes += c.BufferBytes
+		cluster.ReplicaBufferBytes += c.ReplicaBufferBytes
+		if c.MemoryLimitBytes > 0 {
+			cluster.MemoryLimitBytes += c.MemoryLimitBytes
+			limited++
+		}
+	}
+	if limited == 0 || limited < cluster.Reporting {
+		cluster.MemoryLimitBytes = 0 // a worker without a limit: no meaningful total
+	} else {
+		cluster.MemoryUtilization = float64(cluster.ResidentBytes) / float64(cluster.MemoryLimitBytes)
+	}
+	if cluster.MaxProcs > 0 {
+		cluster.CPUUtilization = cluster.CPUCores / float64(cluster.MaxProcs)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"at": time.Now().UnixMilli(), "cluster": cluster, "workers": workers})
+}
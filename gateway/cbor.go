@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// minimal CBOR (RFC 8949) support for the CoAP endpoint: definite-length maps with text keys and numeric values.
+// anything else is rejected rather than half-parsed
+
+var errCBOR = errors.New("unsupported or malformed CBOR")
+
+type cborReader struct {
+	b []byte
+}
+
+// head reads an item head: major type and argument (length, value or float bits)
+func (r *cborReader) head() (major byte, ai byte, arg uint64, err error) {
+	if len(r.b) < 1 {
+		return 0, 0, 0, errCBOR
+	}
+	major, ai = r.b[0]>>5, r.b[0]&0x1f
+	r.b = r.b[1:]
+	size := 0
+	switch {
+	case ai < 24:
+		return major, ai, uint64(ai), nil
+	case ai == 24:
+		size = 1
+	case ai == 25:
+		size = 2
+	case ai == 26:
+		size = 4
+	case ai == 27:
+		size = 8
+	default:
+		return 0, 0, 0, errCBOR // indefinite lengths and reserved values
+	}
+	if len(r.b) < size {
+		return 0, 0, 0, errCBOR
+	}
+	for _, c := range r.b[:size] {
+		arg = arg<<8 | uint64(c)
+	}
+	r.b = r.b[size:]
+	return major, ai, arg, nil
+}
+
+func (r *cborReader) text() (string, error) {
+	major, _, n, err := r.head()
+	if err != nil || major != 3 || uint64(len(r.b)) < n {
+		return "", errCBOR
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s, nil
+}
+
+// number reads an integer or float item as float64
+func (r *cborReader) number() (float64, error) {
+	major, ai, arg, err := r.head()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case major == 0:
+		return float64(arg), nil
+	case major == 1:
+		return -1 - float64(arg), nil
+	case major == 7 && ai == 25:
+		return halfToFloat(uint16(arg)), nil
+	case major == 7 && ai == 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case major == 7 && ai == 27:
+		return math.Float64frombits(arg), nil
+	}
+	return 0, errCBOR
+}
+
+// cborDecodeNumberMap decodes a map of text keys to numbers
+func cborDecodeNumberMap(b []byte) (map[string]float64, error) {
+	r := &cborReader{b: b}
+	major, _, n, err := r.head()
+	if err != nil || major != 5 || n > 64 {
+		return nil, errCBOR
+	}
+	out := make(map[string]float64, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := r.text()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.number()
+		if err != nil {
+			return nil, err
+		}
+		out[key] = v
+	}
+	if len(r.b) != 0 {
+		return nil, errCBOR
+	}
+	return out, nil
+}
+
+func halfToFloat(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp, frac := int(h>>10)&0x1f, float64(h&0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
+
+func cborAppendHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major<<5|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major<<5|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major<<5|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major<<5|27), n)
+}
+
+// cborEncodeCounts encodes geohash -> count as a CBOR map (keys sorted, so equal maps encode identically)
+func cborEncodeCounts(counts map[string]int64) []byte {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := cborAppendHead(nil, 5, uint64(len(keys)))
+	for _, k := range keys {
+		b = cborAppendHead(b, 3, uint64(len(k)))
+		b = append(b, k...)
+		b = cborAppendHead(b, 0, uint64(max(counts[k], 0)))
+	}
+	return b
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ingest timestamps, ring membership (workers expire when their heartbeats stop), the age of coverage hints, the load
+// shedder's queue times and the gateway id go through clock and ids, so that tests can drive them with a fake clock. same as worker-node/clock.go
+type Clock interface {
+	Now() time.Time
+}
+
+type IDGen interface {
+	NewID() string
+}
+
+var (
+	clock Clock = newMonotonicClock()
+	ids   IDGen = uuidGen{}
+)
+
+// wall clock anchored at startup and advanced with the monotonic clock, so ingest timestamps never jump when the system
+// clock is stepped. drift against the other nodes shows up in gateway_worker_clock_skew_seconds
+type monotonicClock struct {
+	base time.Time
+	wall time.Time // wall reading only
+}
+
+func newMonotonicClock() monotonicClock {
+	base := time.Now()
+	return monotonicClock{base: base, wall: base.Round(0)}
+}
+
+func (c monotonicClock) Now() time.Time {
+	return c.wall.Add(time.Since(c.base))
+}
+
+func monotonicNow() time.Time {
+	return clock.Now()
+}
+
+type uuidGen struct{}
+
+func (uuidGen) NewID() string {
+	return uuid.New().String()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc"
+)
+
+// fakeClock only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func withFakeClock(t *testing.T) *fakeClock {
+	previous := clock
+	fake := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	clock = fake
+	t.Cleanup(func() { clock = previous })
+	return fake
+}
+
+// newTestGatewayState returns an empty state whose workers aren't dialed as they join
+func newTestGatewayState(t *testing.T) *GatewayState {
+	previous := WARM_CONNS
+	WARM_CONNS = false
+	t.Cleanup(func() { WARM_CONNS = previous })
+	return &GatewayState{
+		ring:     make(HashRing, 0),
+		clients:  make(map[string]*grpc.ClientConn),
+		lastSeen: make(map[string]int64),
+		coverage: make(map[string]coverageHint),
+		written:  make(map[string]*writtenCells),
+		versions: make(map[string]uint32),
+		builds:   make(map[string]string),
+		draining: make(map[string]bool),
+		capacity: make(map[string]*pb.WorkerCapacity),
+		skews:    make(map[string]time.Duration),
+		features: make(map[string][]string),
+	}
+}
+
+func TestWorkersLeaveTheRingWhenTheirHeartbeatsStop(t *testing.T) {
+	fake := withFakeClock(t)
+	g := newTestGatewayState(t)
+	const ttl = 10 * time.Second
+
+	g.addNode("worker-a", "a:50051")
+	g.addNode("worker-b", "b:50051")
+	fake.advance(6 * time.Second)
+	g.addNode("worker-b", "b:50051") // b keeps heartbeating, a stopped
+	fake.advance(5 * time.Second)
+
+	g.expireNodes(ttl)
+	if servers := g.workerServers(); !slices.Equal(servers, []string{"b:50051"}) {
+		t.Fatalf("got %v in the ring, want only b (a unseen for 11s, ttl %s)", servers, ttl)
+	}
+	fake.advance(ttl)
+	g.expireNodes(ttl)
+	if servers := g.workerServers(); len(servers) != 0 {
+		t.Fatalf("got %v in the ring, want none", servers)
+	}
+}
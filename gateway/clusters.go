@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"geostreamdb/geo"
+)
+
+// GET /clusters?minLat=..&maxLat=..&minLng=..&maxLng=..&precision=..&eps=..&minCount=..: hotspots instead of a grid.
+// the /pingArea counts are clustered with DBSCAN over the cells: a cell is a core cell if the pings of the cells whose
+// center lies within eps meters of its own (itself included) reach minCount. core cells within eps of each other form a
+// cluster, and non-core cells within eps of a core cell join it as border cells. everything else is noise
+const clustersMaxEps = 50000 // meters
+
+type clusterCell struct {
+	geohash  string
+	lat, lng float64 // cell center
+	bbox     geo.Bbox
+	count    int64
+	cluster  int // 0 = unassigned
+}
+
+type clusterExtent struct {
+	MinLat float64 `json:"minLat"`
+	MaxLat float64 `json:"maxLat"`
+	MinLng float64 `json:"minLng"`
+	MaxLng float64 `json:"maxLng"`
+}
+
+type cluster struct {
+	Latitude  float64       `json:"lat"` // count weighted centroid of the cell centers
+	Longitude float64       `json:"lng"`
+	Extent    clusterExtent `json:"extent"` // union of the cluster cells
+	Count     int64         `json:"count"`
+	Cells     int           `json:"cells"`
+}
+
+type clustersResponse struct {
+	Clusters []cluster `json:"clusters"` // largest count first
+	Noise    int64     `json:"noise"`    // pings in cells outside every cluster
+}
+
+func getClusters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q, status, msg := parsePingAreaQuery(query)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+		return
+	}
+	eps, err := strconv.ParseFloat(query.Get("eps"), 64)
+	if err != nil || !(eps > 0 && eps <= clustersMaxEps) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid eps (meters, up to " + strconv.Itoa(clustersMaxEps) + ")"))
+		return
+	}
+	minCount, err := strconv.ParseInt(query.Get("minCount"), 10, 64)
+	if err != nil || minCount < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid minCount"))
+		return
+	}
+
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(q.bbox(), q.precision) {
+		denyACL(w, t)
+		return
+	}
+	if !admitUsage(w, r, unitCells, q.estimated) {
+		return
+	}
+
+	cells := make([]*clusterCell, 0)
+	for gh, c := range privacyFor(t).suppress(t, queryPingArea(r.Context(), retentionFor(t).limit(q))) {
+		bbox, ok := geo.Decode(gh)
+		if !ok || c.Count <= 0 {
+			continue
+		}
+		cells = append(cells, &clusterCell{geohash: gh, lat: (bbox.MinLat + bbox.MaxLat) / 2, lng: (bbox.MinLng + bbox.MaxLng) / 2, bbox: bbox, count: c.Count})
+	}
+	sort.Slice(cells, func(i, j int) bool { return cells[i].geohash < cells[j].geohash }) // deterministic border assignment
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dbscanCells(cells, eps, minCount, q.minLat, q.maxLat))
+}
+
+func dbscanCells(cells []*clusterCell, eps float64, minCount int64, minLat, maxLat float64) clustersResponse {
+	// bucket cells in a grid of eps-sized squares so neighbors are found in the 3x3 surrounding buckets. buckets are
+	// sized at the most poleward latitude (where eps spans the most longitude) and doubled in longitude, as great-circle
+	// distances are shorter than the arc along the parallel
+	latStep := eps / (geo.Deg2Rad(1) * geo.EarthRadiusMeters)
+	lngStep := 360.0
+	if cos := math.Cos(geo.Deg2Rad(min(89.9, math.Abs(geo.LatForMinWidth(minLat, maxLat))))); 2*latStep/cos < 360 {
+		lngStep = 2 * latStep / cos
+	}
+	type bucketKey struct{ lat, lng int64 }
+	buckets := make(map[bucketKey][]int)
+	keyOf := func(c *clusterCell) bucketKey {
+		return bucketKey{int64(math.Floor(c.lat / latStep)), int64(math.Floor(c.lng / lngStep))}
+	}
+	for i, c := range cells {
+		k := keyOf(c)
+		buckets[k] = append(buckets[k], i)
+	}
+	neighbors := func(i int) []int {
+		c, k := cells[i], keyOf(cells[i])
+		var out []int
+		for dLat := int64(-1); dLat <= 1; dLat++ {
+			for dLng := int64(-1); dLng <= 1; dLng++ {
+				for _, j := range buckets[bucketKey{k.lat + dLat, k.lng + dLng}] {
+					if geo.HaversineMeters(c.lat, c.lng, cells[j].lat, cells[j].lng) <= eps {
+						out = append(out, j)
+					}
+				}
+			}
+		}
+		return out
+	}
+
+	core := make([]bool, len(cells))
+	neighborhoods := make([][]int, len(cells))
+	for i := range cells {
+		neighborhoods[i] = neighbors(i)
+		sum := int64(0)
+		for _, j := range neighborhoods[i] {
+			sum += cells[j].count
+		}
+		core[i] = sum >= minCount
+	}
+
+	// expand clusters from every unassigned core cell
+	clusters := 0
+	for i := range cells {
+		if !core[i] || cells[i].cluster != 0 {
+			continue
+		}
+		clusters++
+		cells[i].cluster = clusters
+		queue := []int{i}
+		for len(queue) > 0 {
+			j := queue[0]
+			queue = queue[1:]
+			for _, n := range neighborhoods[j] {
+				if cells[n].cluster != 0 {
+					continue
+				}
+				cells[n].cluster = clusters
+				if core[n] {
+					queue = append(queue, n) // border cells join but don't expand
+				}
+			}
+		}
+	}
+
+	out := clustersResponse{Clusters: make([]cluster, clusters)}
+	for i := range out.Clusters {
+		out.Clusters[i].Extent = clusterExtent{MinLat: 90, MaxLat: -90, MinLng: 180, MaxLng: -180}
+	}
+	for _, c := range cells {
+		if c.cluster == 0 {
+			out.Noise += c.count
+			continue
+		}
+		cl := &out.Clusters[c.cluster-1]
+		cl.Count += c.count
+		cl.Cells++
+		cl.Latitude += c.lat * float64(c.count)
+		cl.Longitude += c.lng * float64(c.count)
+		cl.Extent.MinLat, cl.Extent.MaxLat = min(cl.Extent.MinLat, c.bbox.MinLat), max(cl.Extent.MaxLat, c.bbox.MaxLat)
+		cl.Extent.MinLng, cl.Extent.MaxLng = min(cl.Extent.MinLng, c.bbox.MinLng), max(cl.Extent.MaxLng, c.bbox.MaxLng)
+	}
+	for i := range out.Clusters {
+		out.Clusters[i].Latitude /= float64(out.Clusters[i].Count)
+		out.Clusters[i].Longitude /= float64(out.Clusters[i].Count)
+	}
+	sort.SliceStable(out.Clusters, func(i, j int) bool { return out.Clusters[i].Count > out.Clusters[j].Count })
+	return out
+}
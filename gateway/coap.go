@@ -0,0 +1,682 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+	"github.com/zeebo/xxh3"
+)
+
+// optional CoAP (RFC 7252, UDP) endpoint for LPWAN-class devices:
+//   - POST /ping with a CBOR map payload {"lat": <number>, "lng": <number>} -> 2.01 Created
+//   - GET /pingArea?minLat=...&maxLat=...&minLng=...&maxLng=...&precision=... -> 2.05 Content, CBOR map geohash -> count.
+//     with Observe: 0 (RFC 7641) the client is subscribed and notified (NON) whenever the result changes, checked every
+//     COAP_OBSERVE_INTERVAL, until it deregisters, resets a notification or the subscription expires (COAP_OBSERVE_TTL)
+//
+// requests share the ingest/query rate limits and are accounted to the anonymous tenant (CoAP has no API key header).
+// UDP source addresses can be spoofed, so the endpoint must not amplify traffic towards third parties: a response
+// larger than one CoAP message is refused with 4.13 (no block-wise transfer), a subscription only gets notifications
+// once its client acknowledged the CON response to its registration, and every source address is limited to
+// COAP_PEER_RATE_LIMIT messages a second
+var COAP_PORT = os.Getenv("COAP_PORT") // unset = disabled
+var COAP_OBSERVE_INTERVAL = env.Duration("COAP_OBSERVE_INTERVAL", 5*time.Second)
+var COAP_OBSERVE_TTL = env.Duration("COAP_OBSERVE_TTL", 10*time.Minute)
+var COAP_MAX_OBSERVERS = env.Int("COAP_MAX_OBSERVERS", 256)
+var COAP_PEER_RATE_LIMIT = env.Int("COAP_PEER_RATE_LIMIT", 10) // 0 = unlimited
+
+const (
+	coapCON = 0
+	coapNON = 1
+	coapACK = 2
+	coapRST = 3
+
+	coapGET  = 0x01
+	coapPOST = 0x02
+
+	coapCreated             = 0x41 // 2.01
+	coapContent             = 0x45 // 2.05
+	coapBadRequest          = 0x80 // 4.00
+	coapForbidden           = 0x83 // 4.03
+	coapNotFound            = 0x84 // 4.04
+	coapMethodNotAllowed    = 0x85 // 4.05
+	coapEntityTooLarge      = 0x8d // 4.13
+	coapUnsupportedFormat   = 0x8f // 4.15
+	coapTooManyRequests     = 0x9d // 4.29 (RFC 8516)
+	coapInternalServerError = 0xa0 // 5.00
+	coapServiceUnavailable  = 0xa3 // 5.03
+
+	coapOptionObserve       = 6
+	coapOptionUriPath       = 11
+	coapOptionContentFormat = 12
+	coapOptionUriQuery      = 15
+
+	coapFormatCBOR = 60
+
+	coapMaxMessageSize   = 1152              // RFC 7252 §4.6, for requests and responses alike
+	coapExchangeLifetime = 247 * time.Second // window in which a CON retransmission must be answered from the dedup cache
+	coapMaxTransmitWait  = 93 * time.Second  // for the client to acknowledge a registration (RFC 7252 MAX_TRANSMIT_WAIT)
+	coapMaxDedup         = 65536
+	coapMaxPeers         = 65536
+)
+
+type coapOption struct {
+	num   uint16
+	value []byte
+}
+
+type coapMessage struct {
+	typ     byte
+	code    byte
+	id      uint16
+	token   []byte
+	options []coapOption // in ascending option number
+	payload []byte
+
+	separate bool // response sent as a CON of its own rather than piggybacked (RFC 7252 §5.2.2)
+}
+
+var errCoAPFormat = errors.New("malformed CoAP message")
+
+func parseCoAP(b []byte) (*coapMessage, error) {
+	if len(b) < 4 || b[0]>>6 != 1 {
+		return nil, errCoAPFormat
+	}
+	m := &coapMessage{typ: (b[0] >> 4) & 0x3, code: b[1], id: binary.BigEndian.Uint16(b[2:4])}
+	tkl := int(b[0] & 0xf)
+	if tkl > 8 || len(b) < 4+tkl {
+		return nil, errCoAPFormat
+	}
+	m.token = b[4 : 4+tkl]
+	b = b[4+tkl:]
+
+	num := uint16(0)
+	for len(b) > 0 {
+		if b[0] == 0xff {
+			if len(b) == 1 {
+				return nil, errCoAPFormat // payload marker with no payload
+			}
+			m.payload = b[1:]
+			break
+		}
+		delta, length := int(b[0]>>4), int(b[0]&0xf)
+		b = b[1:]
+		var err error
+		if delta, b, err = coapExtended(delta, b); err != nil {
+			return nil, err
+		}
+		if length, b, err = coapExtended(length, b); err != nil {
+			return nil, err
+		}
+		if len(b) < length || int(num)+delta > math.MaxUint16 {
+			return nil, errCoAPFormat
+		}
+		num += uint16(delta)
+		m.options = append(m.options, coapOption{num: num, value: b[:length]})
+		b = b[length:]
+	}
+	return m, nil
+}
+
+func coapExtended(v int, b []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, errCoAPFormat
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, errCoAPFormat
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, errCoAPFormat
+	}
+	return v, b, nil
+}
+
+func (m *coapMessage) marshal() []byte {
+	b := []byte{1<<6 | m.typ<<4 | byte(len(m.token)), m.code, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], m.id)
+	b = append(b, m.token...)
+	prev := uint16(0)
+	for _, o := range m.options {
+		b = coapAppendOptionHeader(b, int(o.num-prev), len(o.value))
+		b = append(b, o.value...)
+		prev = o.num
+	}
+	if len(m.payload) > 0 {
+		b = append(append(b, 0xff), m.payload...)
+	}
+	return b
+}
+
+func coapAppendOptionHeader(b []byte, delta int, length int) []byte {
+	nibble := func(v int) (byte, []byte) {
+		switch {
+		case v < 13:
+			return byte(v), nil
+		case v < 269:
+			return 13, []byte{byte(v - 13)}
+		}
+		return 14, binary.BigEndian.AppendUint16(nil, uint16(v-269))
+	}
+	d, dExt := nibble(delta)
+	l, lExt := nibble(length)
+	b = append(b, d<<4|l)
+	return append(append(b, dExt...), lExt...)
+}
+
+func (m *coapMessage) option(num uint16) ([]byte, bool) {
+	for _, o := range m.options {
+		if o.num == num {
+			return o.value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *coapMessage) path() string {
+	var parts []string
+	for _, o := range m.options {
+		if o.num == coapOptionUriPath {
+			parts = append(parts, string(o.value))
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+func (m *coapMessage) query() url.Values {
+	q := url.Values{}
+	for _, o := range m.options {
+		if o.num == coapOptionUriQuery {
+			k, v, _ := strings.Cut(string(o.value), "=")
+			q.Add(k, v)
+		}
+	}
+	return q
+}
+
+func coapUint(v uint32) []byte { // minimal-length unsigned option value
+	b := binary.BigEndian.AppendUint32(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+func coapDecodeUint(b []byte) uint32 {
+	v := uint32(0)
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+type coapServer struct {
+	conn net.PacketConn
+
+	dedupMu sync.Mutex
+	dedup   map[string]coapDedupEntry // sender + message id -> response (nil while in progress)
+
+	observersMu sync.Mutex
+	observers   map[string]*coapObserver // sender + token
+	nextID      uint16
+
+	peersMu sync.Mutex
+	peers   map[netip.Addr]*rateLimiter // source IP -> COAP_PEER_RATE_LIMIT bucket
+}
+
+type coapDedupEntry struct {
+	response []byte
+	at       time.Time
+}
+
+type coapObserver struct {
+	addr     net.Addr
+	token    []byte
+	query    pingAreaQuery
+	seq      uint32 // Observe option value of the last notification (24 bits)
+	hash     uint64 // of the last payload sent
+	notifyID uint16 // message id of the last notification (a RST for it cancels the subscription)
+	expires  time.Time
+
+	// false until the client acknowledged the CON response to its registration, proving it is at addr: until then
+	// it gets no notification, and the subscription expires after coapMaxTransmitWait
+	confirmed bool
+}
+
+func setup_coap_listener() {
+	if COAP_PORT == "" {
+		return
+	}
+	conn, err := net.ListenPacket("udp", ":"+COAP_PORT)
+	if err != nil {
+		log.Fatalf("failed to listen on coap: %v", err)
+	}
+	log.Printf("CoAP listening at %v", conn.LocalAddr())
+
+	s := newCoAPServer(conn)
+	go s.notifyLoop()
+
+	sem := make(chan struct{}, max(UDP_WORKERS, 1))
+	for {
+		buf := make([]byte, coapMaxMessageSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("coap read error: %v", err)
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			Metrics.coapRequestsTotal.WithLabelValues("dropped").Inc()
+			continue
+		}
+		go func() {
+			defer func() { <-sem }()
+			s.handle(buf[:n], addr)
+		}()
+	}
+}
+
+func newCoAPServer(conn net.PacketConn) *coapServer {
+	return &coapServer{
+		conn:      conn,
+		dedup:     make(map[string]coapDedupEntry),
+		observers: make(map[string]*coapObserver),
+		nextID:    uint16(rand.UintN(math.MaxUint16)),
+		peers:     make(map[netip.Addr]*rateLimiter),
+	}
+}
+
+// allowPeer applies COAP_PEER_RATE_LIMIT to the messages exchanged with a source address
+func (s *coapServer) allowPeer(addr net.Addr) bool {
+	if COAP_PEER_RATE_LIMIT <= 0 {
+		return true
+	}
+	ip := netAddrIP(addr)
+	s.peersMu.Lock()
+	l := s.peers[ip]
+	if l == nil {
+		if len(s.peers) >= coapMaxPeers {
+			s.forgetIdlePeersLocked(time.Now())
+		}
+		if len(s.peers) >= coapMaxPeers {
+			s.peersMu.Unlock()
+			return false
+		}
+		l = newRateLimiter(COAP_PEER_RATE_LIMIT)
+		s.peers[ip] = l
+	}
+	s.peersMu.Unlock()
+	return l.allow()
+}
+
+// forgetIdlePeersLocked forgets the peers whose bucket has refilled (a new one is the same). peersMu must be held
+func (s *coapServer) forgetIdlePeersLocked(now time.Time) {
+	for ip, l := range s.peers {
+		l.mu.Lock()
+		idle := now.Sub(l.last) >= time.Second
+		l.mu.Unlock()
+		if idle {
+			delete(s.peers, ip)
+		}
+	}
+}
+
+func (s *coapServer) newID() uint16 {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+	s.nextID++
+	return s.nextID
+}
+
+func (s *coapServer) handle(b []byte, addr net.Addr) {
+	req, err := parseCoAP(b)
+	if err != nil {
+		Metrics.coapRequestsTotal.WithLabelValues("malformed").Inc()
+		return // unparseable: silently ignored, as RFC 7252 allows
+	}
+
+	switch req.typ {
+	case coapRST:
+		s.resetObserver(addr, req.id)
+		return
+	case coapACK:
+		s.confirmObserver(addr, req.id)
+		return
+	}
+	if !s.allowPeer(addr) {
+		Metrics.coapRequestsTotal.WithLabelValues("peer_limited").Inc()
+		return // not even answered: a spoofed source must not get a response per request
+	}
+	if req.code == 0 {
+		if req.typ == coapCON { // CoAP ping
+			s.conn.WriteTo((&coapMessage{typ: coapRST, id: req.id}).marshal(), addr)
+		}
+		return
+	}
+
+	// CON retransmissions are answered with the original response instead of being processed twice
+	dedupKey := addr.String() + "/" + string(binary.BigEndian.AppendUint16(nil, req.id))
+	if req.typ == coapCON {
+		s.dedupMu.Lock()
+		entry, seen := s.dedup[dedupKey]
+		if !seen && len(s.dedup) < coapMaxDedup {
+			s.dedup[dedupKey] = coapDedupEntry{at: time.Now()}
+		}
+		s.dedupMu.Unlock()
+		if seen {
+			if entry.response != nil {
+				s.conn.WriteTo(entry.response, addr)
+			}
+			Metrics.coapRequestsTotal.WithLabelValues("duplicate").Inc()
+			return
+		}
+	}
+
+	resp := s.serve(req, addr)
+	resp.token = req.token
+	var out []byte
+	switch {
+	case resp.separate:
+		// its CON id set by register: the client's ACK confirms the subscription
+		if req.typ == coapCON {
+			out = (&coapMessage{typ: coapACK, id: req.id}).marshal() // empty ACK, the response follows
+			s.conn.WriteTo(out, addr)
+		}
+		resp.typ = coapCON
+		s.conn.WriteTo(resp.marshal(), addr)
+	case req.typ == coapCON:
+		resp.typ, resp.id = coapACK, req.id // piggybacked response
+		out = resp.marshal()
+		s.conn.WriteTo(out, addr)
+	default:
+		resp.typ, resp.id = coapNON, s.newID()
+		out = resp.marshal()
+		s.conn.WriteTo(out, addr)
+	}
+
+	if req.typ == coapCON {
+		s.dedupMu.Lock()
+		if _, ok := s.dedup[dedupKey]; ok {
+			s.dedup[dedupKey] = coapDedupEntry{response: out, at: time.Now()}
+		}
+		s.dedupMu.Unlock()
+	}
+}
+
+func (s *coapServer) serve(req *coapMessage, addr net.Addr) *coapMessage {
+	switch req.path() {
+	case "/ping":
+		if req.code != coapPOST {
+			return &coapMessage{code: coapMethodNotAllowed}
+		}
+		return s.postPing(req, addr)
+	case "/pingArea":
+		if req.code != coapGET {
+			return &coapMessage{code: coapMethodNotAllowed}
+		}
+		return s.getPingArea(req, addr)
+	}
+	Metrics.coapRequestsTotal.WithLabelValues("not_found").Inc()
+	return &coapMessage{code: coapNotFound}
+}
+
+func coapError(code byte, result string, msg string) *coapMessage {
+	Metrics.coapRequestsTotal.WithLabelValues(result).Inc()
+	return &coapMessage{code: code, payload: []byte(msg)} // diagnostic payload
+}
+
+func (s *coapServer) postPing(req *coapMessage, addr net.Addr) *coapMessage {
+	if reason := filterIngestAddr(netAddrIP(addr)); reason != "" {
+		return coapError(coapForbidden, "forbidden", countFiltered(reason))
+	}
+	if format, ok := req.option(coapOptionContentFormat); ok && coapDecodeUint(format) != coapFormatCBOR {
+		return coapError(coapUnsupportedFormat, "bad_request", "CBOR payload expected")
+	}
+	fields, err := cborDecodeNumberMap(req.payload)
+	if err != nil {
+		return coapError(coapBadRequest, "bad_request", "Invalid CBOR payload")
+	}
+	lat, okLat := fields["lat"]
+	lng, okLng := fields["lng"]
+	if !okLat || !okLng {
+		return coapError(coapBadRequest, "bad_request", "Missing lat or lng")
+	}
+	if math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return coapError(coapBadRequest, "bad_request", "Latitude or longitude out of bounds")
+	}
+	deviceID := ""
+	if len(hooksFor(anonymous)) > 0 {
+		p := newHookPing(anonymous, "coap", netAddrIP(addr), lat, lng, deviceID)
+		dropped, msg := runIngestHooks(anonymous, p)
+		if dropped {
+			Metrics.coapRequestsTotal.WithLabelValues("hook_dropped").Inc()
+			return &coapMessage{code: coapCreated}
+		}
+		if msg != "" {
+			return coapError(coapBadRequest, "bad_request", msg)
+		}
+		lat, lng, deviceID = p.Lat, p.Lng, p.DeviceID
+	}
+	if reason := filterIngestPoint(lat, lng); reason != "" {
+		return coapError(coapForbidden, "forbidden", countFiltered(reason))
+	}
+	if !unsignedPingAllowed(deviceID) {
+		return coapError(coapForbidden, "forbidden", "Signed pings required (use POST /ping)")
+	}
+	lat, lng = privacyFor(anonymous).coarsen(lat, lng)
+	if !aclFor(anonymous).allowsPoint(lat, lng) {
+		Metrics.aclDeniedTotal.WithLabelValues(anonymous.name).Inc()
+		return coapError(coapForbidden, "forbidden", "Location not allowed")
+	}
+
+	if !ingestGroup.limiter.allow() {
+		return coapError(coapTooManyRequests, "rate_limited", "Rate limit exceeded")
+	}
+	reservedAt := time.Now()
+	if !reserveUsage(anonymous, "COAP POST /ping", unitPings, 1) {
+		return coapError(coapTooManyRequests, "quota_exceeded", "Monthly pings quota exceeded")
+	}
+
+	if _, err := service.RoutePing(context.Background(), geo.Encode(lat, lng, MAX_GH_PRECISION), monotonicNow().UnixMilli(), deviceID, 0); err != nil {
+		refundUsage(anonymous, "COAP POST /ping", unitPings, 1, reservedAt)
+		return coapError(coapServiceUnavailable, "failed", "Failed to store ping")
+	}
+	Metrics.coapRequestsTotal.WithLabelValues("created").Inc()
+	return &coapMessage{code: coapCreated}
+}
+
+func (s *coapServer) getPingArea(req *coapMessage, addr net.Addr) *coapMessage {
+	q, status, msg := parsePingAreaQuery(req.query())
+	if status != http.StatusOK {
+		if status == http.StatusRequestEntityTooLarge {
+			return coapError(coapEntityTooLarge, "bad_request", msg)
+		}
+		return coapError(coapBadRequest, "bad_request", msg)
+	}
+	q = retentionFor(anonymous).limit(q)
+	if !aclFor(anonymous).allowsArea(q.bbox(), q.precision) {
+		Metrics.aclDeniedTotal.WithLabelValues(anonymous.name).Inc()
+		return coapError(coapForbidden, "forbidden", "Area not allowed")
+	}
+	if !queryGroup.limiter.allow() {
+		return coapError(coapTooManyRequests, "rate_limited", "Rate limit exceeded")
+	}
+	reservedAt := time.Now()
+	if !reserveUsage(anonymous, "COAP GET /pingArea", unitCells, q.estimated) {
+		return coapError(coapTooManyRequests, "quota_exceeded", "Monthly cells quota exceeded")
+	}
+
+	payload := cborEncodeCounts(areaCounts(privacyFor(anonymous).suppress(anonymous, queryPingArea(context.Background(), q))))
+	if !coapFits(req.token, payload) {
+		refundUsage(anonymous, "COAP GET /pingArea", unitCells, q.estimated, reservedAt)
+		return coapError(coapEntityTooLarge, "too_large", "Result too large for a CoAP message (narrow the area or lower the precision)")
+	}
+	resp := &coapMessage{code: coapContent, options: []coapOption{{num: coapOptionContentFormat, value: coapUint(coapFormatCBOR)}}, payload: payload}
+
+	observe, hasObserve := req.option(coapOptionObserve)
+	key := addr.String() + "/" + string(req.token)
+	switch {
+	case hasObserve && coapDecodeUint(observe) == 0:
+		o := &coapObserver{addr: addr, token: req.token, query: q, hash: xxh3.Hash(payload), expires: time.Now().Add(coapMaxTransmitWait)}
+		if seq, ok := s.register(key, o); ok {
+			// Observe sorts before Content-Format
+			resp.options = append([]coapOption{{num: coapOptionObserve, value: coapUint(seq)}}, resp.options...)
+			resp.separate, resp.id = true, o.notifyID
+		}
+	case hasObserve && coapDecodeUint(observe) == 1:
+		s.deregister(key)
+	}
+	Metrics.coapRequestsTotal.WithLabelValues("content").Inc()
+	return resp
+}
+
+// coapFits tells whether a response with that token and payload fits in one message (the header and options take
+// at most 16 bytes beyond the token)
+func coapFits(token []byte, payload []byte) bool {
+	return 4+len(token)+16+1+len(payload) <= coapMaxMessageSize
+}
+
+func areaCounts(combined map[string]*ExtendedPingAreaCount) map[string]int64 {
+	out := make(map[string]int64, len(combined))
+	for gh, c := range combined {
+		out[gh] = c.Count
+	}
+	return out
+}
+
+// register adds (or refreshes) an unconfirmed subscription, returning its current sequence number and setting the id of
+// the CON response to send it in o.notifyID. false if the observer limit is reached, in which case the request is
+// answered without subscribing (RFC 7641 §4.1)
+func (s *coapServer) register(key string, o *coapObserver) (uint32, bool) {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+	if existing, ok := s.observers[key]; ok {
+		o.seq = existing.seq + 1
+	} else if len(s.observers) >= COAP_MAX_OBSERVERS {
+		return 0, false
+	}
+	s.nextID++
+	o.notifyID = s.nextID
+	s.observers[key] = o
+	Metrics.coapObservers.Set(float64(len(s.observers)))
+	return o.seq & 0xffffff, true
+}
+
+func (s *coapServer) deregister(key string) {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+	delete(s.observers, key)
+	Metrics.coapObservers.Set(float64(len(s.observers)))
+}
+
+func (s *coapServer) resetObserver(addr net.Addr, id uint16) {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+	for key, o := range s.observers {
+		if o.notifyID == id && o.addr.String() == addr.String() {
+			delete(s.observers, key)
+		}
+	}
+	Metrics.coapObservers.Set(float64(len(s.observers)))
+}
+
+// confirmObserver confirms the subscription whose registration response an ACK from addr acknowledges
+func (s *coapServer) confirmObserver(addr net.Addr, id uint16) {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+	for _, o := range s.observers {
+		if !o.confirmed && o.notifyID == id && o.addr.String() == addr.String() {
+			o.confirmed = true
+			o.expires = time.Now().Add(COAP_OBSERVE_TTL)
+		}
+	}
+}
+
+// notifyLoop re-runs every subscribed query and notifies observers whose result changed. it also expires
+// subscriptions and old dedup entries
+func (s *coapServer) notifyLoop() {
+	ticker := time.NewTicker(COAP_OBSERVE_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		s.dedupMu.Lock()
+		for key, e := range s.dedup {
+			if now.Sub(e.at) > coapExchangeLifetime {
+				delete(s.dedup, key)
+			}
+		}
+		s.dedupMu.Unlock()
+
+		s.observersMu.Lock()
+		active := make(map[string]*coapObserver, len(s.observers))
+		for key, o := range s.observers {
+			if now.After(o.expires) {
+				delete(s.observers, key)
+				continue
+			}
+			if o.confirmed {
+				active[key] = o
+			}
+		}
+		Metrics.coapObservers.Set(float64(len(s.observers)))
+		s.observersMu.Unlock()
+
+		for key, o := range active {
+			if !reserveUsage(anonymous, "COAP GET /pingArea", unitCells, o.query.estimated) {
+				s.deregister(key) // quota used up: end the subscription
+				continue
+			}
+			payload := cborEncodeCounts(areaCounts(privacyFor(anonymous).suppress(anonymous, queryPingArea(context.Background(), o.query))))
+			hash := xxh3.Hash(payload)
+
+			s.observersMu.Lock()
+			if s.observers[key] != o || o.hash == hash {
+				s.observersMu.Unlock()
+				continue
+			}
+			if !s.allowPeer(o.addr) {
+				s.observersMu.Unlock()
+				Metrics.coapRequestsTotal.WithLabelValues("peer_limited").Inc()
+				continue // the change is notified at the next tick
+			}
+			o.hash = hash
+			o.seq++
+			s.nextID++
+			o.notifyID = s.nextID
+			msg := &coapMessage{typ: coapNON, code: coapContent, id: o.notifyID, token: o.token, options: []coapOption{
+				{num: coapOptionObserve, value: coapUint(o.seq & 0xffffff)},
+				{num: coapOptionContentFormat, value: coapUint(coapFormatCBOR)},
+			}, payload: payload}
+			if !coapFits(o.token, payload) {
+				// grown too large: a 4.13 ends the subscription (RFC 7641 §3.2)
+				msg.code, msg.options, msg.payload = coapEntityTooLarge, nil, []byte("Result too large for a CoAP message")
+				delete(s.observers, key)
+				Metrics.coapObservers.Set(float64(len(s.observers)))
+			}
+			s.observersMu.Unlock()
+
+			s.conn.WriteTo(msg.marshal(), o.addr)
+			Metrics.coapRequestsTotal.WithLabelValues("notification").Inc()
+		}
+	}
+}
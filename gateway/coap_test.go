@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestCoAPServer(t *testing.T) (*coapServer, net.PacketConn) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close(); client.Close() })
+	return newCoAPServer(server), client
+}
+
+func TestCoAPObserverIsConfirmedByTheAckOfItsRegistration(t *testing.T) {
+	s, client := newTestCoAPServer(t)
+	addr := client.LocalAddr()
+	o := &coapObserver{addr: addr, token: []byte{1}, expires: time.Now().Add(coapMaxTransmitWait)}
+	if _, ok := s.register(addr.String()+"/\x01", o); !ok {
+		t.Fatal("registration refused")
+	}
+
+	spoofed := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: addr.(*net.UDPAddr).Port}
+	s.handle((&coapMessage{typ: coapACK, id: o.notifyID}).marshal(), spoofed)
+	s.handle((&coapMessage{typ: coapACK, id: o.notifyID + 1}).marshal(), addr)
+	if o.confirmed {
+		t.Fatal("confirmed by an ACK from another address or for another message")
+	}
+	s.handle((&coapMessage{typ: coapACK, id: o.notifyID}).marshal(), addr)
+	if !o.confirmed || time.Until(o.expires) < COAP_OBSERVE_TTL-time.Minute {
+		t.Fatalf("not confirmed for COAP_OBSERVE_TTL: confirmed=%v, expires in %v", o.confirmed, time.Until(o.expires))
+	}
+}
+
+func TestCoAPPeerRateLimitLeavesExcessMessagesUnanswered(t *testing.T) {
+	previous := COAP_PEER_RATE_LIMIT
+	COAP_PEER_RATE_LIMIT = 1
+	t.Cleanup(func() { COAP_PEER_RATE_LIMIT = previous })
+	s, client := newTestCoAPServer(t)
+
+	for id := uint16(1); id <= 2; id++ {
+		s.handle((&coapMessage{typ: coapCON, id: id}).marshal(), client.LocalAddr()) // CoAP ping
+	}
+	buf := make([]byte, coapMaxMessageSize)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := parseCoAP(buf[:n]); err != nil || m.typ != coapRST || m.id != 1 {
+		t.Fatalf("got %+v (%v), want the RST of the first ping", m, err)
+	}
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := client.ReadFrom(buf); err == nil {
+		t.Fatal("the second ping was answered")
+	}
+
+	if !s.allowPeer(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 5683}) {
+		t.Fatal("another address was limited")
+	}
+}
+
+func TestCoAPFits(t *testing.T) {
+	token := make([]byte, 8)
+	largest := make([]byte, coapMaxMessageSize-4-len(token)-16-1)
+	if !coapFits(token, largest) || coapFits(token, append(largest, 0)) {
+		t.Fatalf("wrong limit around a %d byte payload", len(largest))
+	}
+	resp := &coapMessage{typ: coapCON, code: coapContent, token: token, options: []coapOption{
+		{num: coapOptionObserve, value: coapUint(0xffffff)},
+		{num: coapOptionContentFormat, value: coapUint(coapFormatCBOR)},
+	}, payload: largest}
+	if n := len(resp.marshal()); n > coapMaxMessageSize {
+		t.Fatalf("a response that fits marshals to %d bytes", n)
+	}
+}
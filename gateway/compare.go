@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/url"
+)
+
+// comparative area queries: GET /pingArea?compare=1d|1w runs the query a second time against the workers' history tier
+// (HISTORY_RETENTION), for the TTL window that ended a day / a week ago, and answers per cell the current count, that
+// baseline and the change in percent, for "busier than usual" maps. a worker without history that far back fails its
+// shard of the baseline, which is then partial like any area query
+var compareOffsets = map[string]int64{
+	"1d": 24 * 60 * 60,
+	"1w": 7 * 24 * 60 * 60,
+}
+
+type comparedCount struct {
+	Count    int64    `json:"count"`
+	Baseline int64    `json:"baseline"`
+	Change   *float64 `json:"change"` // percent, null without baseline
+}
+
+// parseCompare returns the history offset (seconds) of the compare parameter, 0 if absent
+func parseCompare(query url.Values) (int64, bool) {
+	v := query.Get("compare")
+	if v == "" {
+		return 0, true
+	}
+	offset, ok := compareOffsets[v]
+	return offset, ok
+}
+
+// baselinePlan plans the query of a plan for the window that ended offset seconds ago. the coverage hints describe the
+// live window, so no shard is pruned by them
+func (p QueryPlanner) baselinePlan(plan *QueryPlan, offset int64) *QueryPlan {
+	q := plan.query
+	q.historyOffset = offset
+	baseline := p.Plan(q)
+	for _, call := range baseline.Shards {
+		call.Pruned = false
+	}
+	return baseline
+}
+
+// compareCounts merges current and baseline counts, over the cells in either
+func compareCounts(current, baseline map[string]*ExtendedPingAreaCount) map[string]comparedCount {
+	out := make(map[string]comparedCount, len(current))
+	for gh, v := range current {
+		out[gh] = comparedCount{Count: v.Count}
+	}
+	for gh, v := range baseline {
+		c := out[gh]
+		c.Baseline = v.Count
+		if c.Baseline > 0 {
+			change := float64(c.Count-c.Baseline) / float64(c.Baseline) * 100
+			c.Change = &change
+		}
+		out[gh] = c
+	}
+	return out
+}
+
+// queryBaseline executes the baseline of a plan (see baselinePlan), suppressed like the current counts
+func queryBaseline(ctx context.Context, t *tenant, plan *QueryPlan, offset int64) (*QueryPlan, map[string]*ExtendedPingAreaCount) {
+	baseline := planner.baselinePlan(plan, offset)
+	return baseline, privacyFor(t).suppress(t, baseline.Execute(ctx))
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// tunable consistency for POST /ping and GET /ping with replication: ?consistency= (or the X-Consistency header) ONE,
+// QUORUM or ALL of the REPLICATION_FACTOR workers holding the shard. ONE (the default) keeps the usual path: the write
+// is acknowledged by the primary (replicas best-effort) and the read answered by the first replica (hedged). QUORUM and
+// ALL send to every replica in parallel and answer once enough of them acknowledged (the rest finish in the background);
+// reads return the highest count among the answers. if the level can't be reached the request fails with 503, and a
+// failed write may still have been stored by some replicas. the acknowledgments and the level they amount to are
+// returned in X-Consistency-Acks (e.g. 2/3) and X-Consistency-Achieved
+const (
+	consistencyOne    = "ONE"
+	consistencyQuorum = "QUORUM"
+	consistencyAll    = "ALL"
+)
+
+var errConsistency = errors.New("consistency level not achieved")
+
+// parseConsistency reads the requested level (ONE if unset)
+func parseConsistency(r *http.Request) (string, bool) {
+	level := r.URL.Query().Get("consistency")
+	if level == "" {
+		level = r.Header.Get("X-Consistency")
+	}
+	switch level = strings.ToUpper(level); level {
+	case "":
+		return consistencyOne, true
+	case consistencyOne, consistencyQuorum, consistencyAll:
+		return level, true
+	}
+	return "", false
+}
+
+// consistencyRequired is the acknowledgments a level needs, out of REPLICATION_FACTOR (even if fewer workers exist)
+func consistencyRequired(level string) int {
+	switch level {
+	case consistencyAll:
+		return REPLICATION_FACTOR
+	case consistencyQuorum:
+		return REPLICATION_FACTOR/2 + 1
+	}
+	return 1
+}
+
+func consistencyAchieved(acks int) string {
+	switch {
+	case acks >= consistencyRequired(consistencyAll):
+		return consistencyAll
+	case acks >= consistencyRequired(consistencyQuorum):
+		return consistencyQuorum
+	case acks >= 1:
+		return consistencyOne
+	}
+	return "NONE"
+}
+
+func writeConsistencyHeaders(w http.ResponseWriter, acks int) {
+	w.Header().Set("X-Consistency-Acks", strconv.Itoa(acks)+"/"+strconv.Itoa(REPLICATION_FACTOR))
+	w.Header().Set("X-Consistency-Achieved", consistencyAchieved(acks))
+}
+
+// quorumCall calls every target in parallel (the primary first, replicas with replica=true) and returns once required
+// calls succeeded, or as soon as too many failed to get there. calls still running then finish in the background
+func quorumCall[T any](ctx context.Context, method string, targets []string, required int, call func(ctx context.Context, addr string, replica bool) (T, error)) ([]T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	if len(targets) < required {
+		Metrics.consistencyTotal.WithLabelValues(method, "failed").Inc()
+		return nil, errConsistency // fewer workers than the level needs, nothing is sent
+	}
+
+	results := make(chan result, len(targets)) // buffered so calls finishing late never block
+	ctx = context.WithoutCancel(ctx)
+	for i, addr := range targets {
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			v, err := call(ctx, addr, i > 0)
+			results <- result{v, err}
+		}()
+	}
+
+	var acks []T
+	var lastErr error
+	for failed := 0; len(acks) < required && len(targets)-failed >= required; {
+		res := <-results
+		if res.err != nil {
+			failed++
+			lastErr = res.err
+			continue
+		}
+		acks = append(acks, res.v)
+	}
+	if len(acks) < required {
+		Metrics.consistencyTotal.WithLabelValues(method, "failed").Inc()
+		return acks, errors.Join(errConsistency, lastErr)
+	}
+	Metrics.consistencyTotal.WithLabelValues(method, "achieved").Inc()
+	return acks, nil
+}
+
+// writePing stores a ping at a consistency level, returning the acknowledgments received and the primary's, if it
+// acknowledged (for its read token). a repeated (device id, seq) is acknowledged with errDuplicatePing
+func writePing(ctx context.Context, gh string, ingestedAt int64, deviceID string, seq uint64, level string) (int, *pb.PingResponse, error) {
+	if level == consistencyOne {
+		v, err := service.RoutePing(ctx, gh, ingestedAt, deviceID, seq)
+		if err != nil && !errors.Is(err, errDuplicatePing) {
+			return 0, nil, err
+		}
+		return 1, v, err
+	}
+
+	key := shardKey(gh)
+	targetAddrs := state.GetNodeAddresses(key, REPLICATION_FACTOR)
+	if len(targetAddrs) == 0 {
+		return 0, nil, errNoWorkers
+	}
+	teleport, err := checkTeleport(ctx, gh, ingestedAt, deviceID)
+	if err != nil {
+		return 0, nil, err
+	}
+	Metrics.geohashRequestsTotal.WithLabelValues(targetAddrs[0], "routed", reasonShardOwner).Inc()
+	shadowPing(gh, ingestedAt, deviceID, seq)
+	enrichPing(gh)
+
+	acks, err := quorumCall(ctx, "SendPing", targetAddrs, consistencyRequired(level), func(ctx context.Context, addr string, replica bool) (*pb.PingResponse, error) {
+		conn, err := state.GetConn(addr)
+		if err != nil {
+			return nil, errWorkerConnect
+		}
+		req := &pb.PingRequest{Geohash: gh, Replica: replica, Timestamp: ingestedAt, Seq: seq, Teleport: teleport, Motion: motionOf(ctx), ApiVersion: state.apiVersion(addr)}
+		setFloor(ctx, req)
+		if !replica || seq != 0 {
+			req.DeviceId = deviceID // replicas don't retain raw pings, only dedup them
+		}
+		if !replica {
+			req.Tenant, req.ClientSentAt = tenantOf(ctx), state.sentAtFor(ctx, addr)
+			state.wroteTo(addr, key)
+		}
+		start := time.Now()
+		v, err := pb.NewWorkerClient(conn).SendPing(ctx, req)
+		observeGRPC("SendPing", addr, err, start)
+		return v, err
+	})
+	var primary *pb.PingResponse
+	for _, v := range acks {
+		if _, ok := tokenOf(v); ok {
+			primary = v
+		}
+	}
+	if err != nil {
+		return len(acks), primary, err
+	}
+	for _, v := range acks {
+		if v.Duplicate {
+			return len(acks), primary, errDuplicatePing
+		}
+	}
+	return len(acks), primary, nil
+}
+
+// readPoint gets the count of a max precision geohash at a consistency level, returning the acknowledgments received
+// (the fewest of the two replica chains during a sharding migration, whose counts are added up)
+func readPoint(ctx context.Context, gh string, level string) (*pb.GetPingsResponse, int, error) {
+	if level == consistencyOne {
+		v, err := service.QueryPoint(ctx, gh)
+		if err != nil {
+			return nil, 0, err
+		}
+		return v, 1, nil
+	}
+
+	chains := ownerChains(gh)
+	if len(chains) == 0 {
+		return nil, 0, errNoWorkers
+	}
+	var out *pb.GetPingsResponse
+	received := 0
+	for i, targetAddrs := range chains {
+		Metrics.geohashRequestsTotal.WithLabelValues(targetAddrs[0], "routed", reasonShardOwner).Inc()
+
+		acks, err := quorumCall(ctx, "GetPings", targetAddrs, consistencyRequired(level), service.pingsFrom(gh))
+		if i == 0 || len(acks) < received {
+			received = len(acks)
+		}
+		if err != nil {
+			return nil, received, err
+		}
+		// replicas only miss writes, so the highest count is the most complete
+		best := acks[0]
+		for _, v := range acks[1:] {
+			if v.Count > best.Count {
+				best = v
+			}
+		}
+		out = addPings(out, best)
+	}
+	return out, received, nil
+}
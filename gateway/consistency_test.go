@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quorumWorkers answers quorumCall's calls per address: with an error, after release is closed if blocked, or right away
+type quorumWorkers struct {
+	errs    map[string]error
+	blocked map[string]bool
+	release chan struct{}
+
+	mu      sync.Mutex
+	calls   []string
+	replica map[string]bool
+	done    chan string // addresses whose call returned
+}
+
+func newQuorumWorkers() *quorumWorkers {
+	return &quorumWorkers{errs: map[string]error{}, blocked: map[string]bool{}, release: make(chan struct{}), replica: map[string]bool{}, done: make(chan string, 8)}
+}
+
+func (w *quorumWorkers) call(ctx context.Context, addr string, replica bool) (string, error) {
+	w.mu.Lock()
+	w.calls = append(w.calls, addr)
+	w.replica[addr] = replica
+	w.mu.Unlock()
+	defer func() { w.done <- addr }()
+
+	if w.blocked[addr] {
+		select {
+		case <-w.release:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	if err := w.errs[addr]; err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+func TestQuorumCallReturnsOnceRequiredAcked(t *testing.T) {
+	w := newQuorumWorkers()
+	w.blocked["c"] = true
+	acks, err := quorumCall(context.Background(), "GetPings", []string{"a", "b", "c"}, 2, w.call)
+	if err != nil {
+		t.Fatalf("quorumCall: %v", err)
+	}
+	slices.Sort(acks)
+	if !slices.Equal(acks, []string{"a", "b"}) {
+		t.Errorf("got acks %q, want a and b", acks)
+	}
+
+	// the slow replica was still called, and finishes in the background
+	close(w.release)
+	waitDone(t, w, 3)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.calls) != 3 || w.replica["a"] || !w.replica["b"] || !w.replica["c"] {
+		t.Errorf("got calls %q, replica %v: want all three, the primary first", w.calls, w.replica)
+	}
+}
+
+func TestQuorumCallFailsAsSoonAsTheQuorumIsOutOfReach(t *testing.T) {
+	w := newQuorumWorkers()
+	down := errors.New("connection refused")
+	w.errs["a"], w.errs["b"] = down, down
+	w.blocked["c"] = true
+	defer close(w.release)
+
+	start := time.Now()
+	acks, err := quorumCall(context.Background(), "SendPing", []string{"a", "b", "c"}, 2, w.call)
+	if !errors.Is(err, errConsistency) || !errors.Is(err, down) {
+		t.Fatalf("got %v, want errConsistency with the last error", err)
+	}
+	if len(acks) != 0 {
+		t.Errorf("got acks %q", acks)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("waited %v for a call that couldn't make the quorum", time.Since(start))
+	}
+}
+
+func TestQuorumCallReturnsPartialAcks(t *testing.T) {
+	withReplicationFactor(t, 3)
+	w := newQuorumWorkers()
+	w.errs["b"] = errWorkerConnect
+	w.blocked["b"] = true // fails once the others acknowledged
+	go func() {
+		waitDone(t, w, 2)
+		close(w.release)
+	}()
+	acks, err := quorumCall(context.Background(), "SendPing", []string{"a", "b", "c"}, 3, w.call)
+	if !errors.Is(err, errConsistency) || !errors.Is(err, errWorkerConnect) {
+		t.Fatalf("got %v, want errConsistency", err)
+	}
+	slices.Sort(acks)
+	if !slices.Equal(acks, []string{"a", "c"}) {
+		t.Errorf("got acks %q, want the two that succeeded", acks)
+	}
+	if got := consistencyAchieved(len(acks)); got != consistencyQuorum {
+		t.Errorf("%d acks achieve %s, want QUORUM", len(acks), got)
+	}
+}
+
+func TestQuorumCallSendsNothingWithoutEnoughWorkers(t *testing.T) {
+	w := newQuorumWorkers()
+	acks, err := quorumCall(context.Background(), "SendPing", []string{"a", "b"}, 3, w.call)
+	if !errors.Is(err, errConsistency) || acks != nil {
+		t.Fatalf("got %q, %v", acks, err)
+	}
+	if len(w.calls) != 0 {
+		t.Errorf("called %q", w.calls)
+	}
+}
+
+func TestQuorumCallOutlivesTheRequest(t *testing.T) {
+	w := newQuorumWorkers()
+	w.blocked["b"] = true
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := quorumCall(ctx, "SendPing", []string{"a", "b"}, 1, w.call); err != nil {
+		t.Fatalf("quorumCall: %v", err)
+	}
+	cancel() // the client went away: the replica write goes on
+	close(w.release)
+	waitDone(t, w, 2)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.calls) != 2 {
+		t.Errorf("got calls %q", w.calls)
+	}
+}
+
+func TestConsistencyLevels(t *testing.T) {
+	withReplicationFactor(t, 3)
+	for level, want := range map[string]int{consistencyOne: 1, consistencyQuorum: 2, consistencyAll: 3} {
+		if got := consistencyRequired(level); got != want {
+			t.Errorf("%s requires %d acks, want %d", level, got, want)
+		}
+	}
+	for acks, want := range []string{"NONE", consistencyOne, consistencyQuorum, consistencyAll} {
+		if got := consistencyAchieved(acks); got != want {
+			t.Errorf("%d acks achieve %s, want %s", acks, got, want)
+		}
+	}
+}
+
+// waitDone waits for n calls to return
+func waitDone(t *testing.T, w *quorumWorkers, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-w.done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("calls still running")
+		}
+	}
+}
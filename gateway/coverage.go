@@ -0,0 +1,152 @@
+package main
+
+import (
+	"hash/fnv"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// broadcast pruning: workers send a bloom filter of the geohash prefixes they hold data for with every heartbeat.
+// for broadcast queries, workers whose filter contains none of the covered geohashes are skipped.
+// hints are up to one heartbeat interval old, so they can miss a worker's newest data. a worker is not skipped:
+//   - for cells written to it through this gateway since its hint before last (the latest may have been built before)
+//   - at all if keys moved between workers (ring change, draining, sharding switch) since its latest hint
+//
+// writes through other gateways to a worker not skipped for these reasons can still be missed for a heartbeat interval
+var BROADCAST_PRUNING = env.Bool("BROADCAST_PRUNING", false)
+var COVERAGE_MAX_AGE = env.Duration("COVERAGE_MAX_AGE", 10*time.Second) // older hints are ignored (worker is not skipped)
+
+var maxWrittenCells = 1 << 16 // per worker and hint interval; beyond, the worker is not skipped until its next hints
+
+type coverageHint struct {
+	bloom      []byte
+	hashes     uint32
+	receivedAt time.Time
+}
+
+func (c coverageHint) mayContain(key string) bool {
+	// must match the worker implementation (FNV-1a 64 with double hashing)
+	m := uint64(len(c.bloom) * 8)
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1 := sum & 0xFFFFFFFF
+	h2 := (sum >> 32) | 1
+	for i := uint32(0); i < c.hashes; i++ {
+		pos := (h1 + uint64(i)*h2) % m
+		if c.bloom[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// writtenCells are the cells (every prefix of the shard keys) written to a worker in the current and previous hint
+// intervals
+type writtenCells struct {
+	current, previous map[string]struct{} // nil once full (previous: or before the second hint, writes weren't tracked)
+}
+
+func (w *writtenCells) add(key string) {
+	if w.current == nil {
+		return
+	}
+	if _, ok := w.current[key]; ok {
+		return
+	}
+	for i := len(key); i > 0; i-- {
+		if len(w.current) >= maxWrittenCells {
+			w.current = nil
+			return
+		}
+		w.current[key[:i]] = struct{}{}
+	}
+}
+
+func (w *writtenCells) mayHoldAny(geohashes []string) bool {
+	if w.current == nil || w.previous == nil {
+		return true
+	}
+	s := sharding.Load()
+	for _, gh := range geohashes {
+		for _, cells := range []map[string]struct{}{w.current, w.previous} {
+			// gh itself, or the shard key holding it if it is finer than the shard keys (at both precisions of a
+			// sharding migration)
+			for _, cell := range []string{gh, gh[:min(len(gh), s.precision)], gh[:min(len(gh), s.previous)]} {
+				if _, ok := cells[cell]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (g *GatewayState) setCoverage(address string, bloom []byte, hashes uint32) {
+	g.coverageMutex.Lock()
+	defer g.coverageMutex.Unlock()
+
+	if len(bloom) == 0 || hashes == 0 {
+		delete(g.coverage, address) // worker doesn't send hints (disabled or older version)
+		delete(g.written, address)
+		return
+	}
+	g.coverage[address] = coverageHint{bloom: bloom, hashes: hashes, receivedAt: clock.Now()}
+	if w := g.written[address]; w != nil {
+		w.previous, w.current = w.current, map[string]struct{}{}
+	} else {
+		// writes before the first hint weren't tracked: the worker isn't skipped until its second
+		g.written[address] = &writtenCells{current: map[string]struct{}{}}
+	}
+}
+
+func (g *GatewayState) deleteCoverage(address string) {
+	g.coverageMutex.Lock()
+	defer g.coverageMutex.Unlock()
+	delete(g.coverage, address)
+	delete(g.written, address)
+}
+
+// wroteTo records a ping written to the worker at address as primary, under its shard key
+func (g *GatewayState) wroteTo(address string, key string) {
+	if !BROADCAST_PRUNING {
+		return
+	}
+	g.coverageMutex.Lock()
+	defer g.coverageMutex.Unlock()
+	if w := g.written[address]; w != nil {
+		w.add(key)
+	}
+}
+
+// mayHoldAny reports whether the worker at address may hold data for any of the geohashes.
+// without a fresh hint the answer is always true
+func (g *GatewayState) mayHoldAny(address string, geohashes []string) bool {
+	g.coverageMutex.RLock()
+	hint, ok := g.coverage[address]
+	written := ok && g.written[address].mayHoldAny(geohashes)
+	g.coverageMutex.RUnlock()
+
+	now := clock.Now()
+	if !ok || now.Sub(hint.receivedAt) > COVERAGE_MAX_AGE || written || !hint.receivedAt.After(g.ownershipChangedAt(now)) {
+		return true
+	}
+	for _, gh := range geohashes {
+		if hint.mayContain(gh) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownershipChangedAt returns the last time keys moved between workers: in the ring, or at a sharding migration's switch
+func (g *GatewayState) ownershipChangedAt(now time.Time) time.Time {
+	g.ringMutex.RLock()
+	changed := g.ringChanged
+	g.ringMutex.RUnlock()
+	if switchAt := time.UnixMilli(sharding.Load().switchAt); switchAt.After(changed) && !switchAt.After(now) {
+		return switchAt
+	}
+	return changed
+}
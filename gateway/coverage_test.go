@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"testing"
+	"time"
+)
+
+// sendHint stores a coverage hint of worker a holding data under the prefixes, its bloom filter built like workers do
+func sendHint(g *GatewayState, prefixes ...string) {
+	const hashes = 4
+	bloom := make([]byte, 1024)
+	m := uint64(len(bloom) * 8)
+	for _, p := range prefixes {
+		h := fnv.New64a()
+		h.Write([]byte(p))
+		sum := h.Sum64()
+		h1, h2 := sum&0xFFFFFFFF, (sum>>32)|1
+		for i := uint64(0); i < hashes; i++ {
+			pos := (h1 + i*h2) % m
+			bloom[pos/8] |= 1 << (pos % 8)
+		}
+	}
+	g.setCoverage("a", bloom, hashes)
+}
+
+// withPrunedWorker returns a state holding worker a, whose hints (of data under "u4" only) were received twice
+func withPrunedWorker(t *testing.T) (*GatewayState, *fakeClock) {
+	previous := BROADCAST_PRUNING
+	BROADCAST_PRUNING = true
+	t.Cleanup(func() { BROADCAST_PRUNING = previous })
+	withSharding(t, &shardingState{precision: 5, previous: 5})
+	fake := withFakeClock(t)
+	g := newTestGatewayState(t)
+	g.addNode("worker-a", "a")
+	fake.advance(time.Second)
+	sendHint(g, "u", "u4")
+	fake.advance(time.Second)
+	sendHint(g, "u", "u4")
+	fake.advance(time.Second)
+	return g, fake
+}
+
+func TestCoverageHintPrunesWorkersWithoutTheCells(t *testing.T) {
+	g, fake := withPrunedWorker(t)
+	if !g.mayHoldAny("a", []string{"ez", "u4"}) {
+		t.Errorf("worker skipped for cells of its hint")
+	}
+	if g.mayHoldAny("a", []string{"ez", "ezj"}) {
+		t.Errorf("worker not skipped for cells outside its hint")
+	}
+	if !g.mayHoldAny("b", []string{"ez"}) {
+		t.Errorf("worker without hints skipped")
+	}
+
+	fake.advance(COVERAGE_MAX_AGE)
+	if !g.mayHoldAny("a", []string{"ez"}) {
+		t.Errorf("worker skipped on a hint older than COVERAGE_MAX_AGE")
+	}
+	g.setCoverage("a", nil, 0) // hints disabled
+	if !g.mayHoldAny("a", []string{"ez"}) {
+		t.Errorf("worker skipped without hints")
+	}
+}
+
+func TestCoverageKeepsWorkersUntilTheirSecondHint(t *testing.T) {
+	previous := BROADCAST_PRUNING
+	BROADCAST_PRUNING = true
+	t.Cleanup(func() { BROADCAST_PRUNING = previous })
+	fake := withFakeClock(t)
+	g := newTestGatewayState(t)
+	g.addNode("worker-a", "a")
+	fake.advance(time.Second)
+
+	// writes before the first hint weren't tracked, and it may have been built before them
+	sendHint(g, "u")
+	if !g.mayHoldAny("a", []string{"ez"}) {
+		t.Errorf("worker skipped on its first hint")
+	}
+	sendHint(g, "u")
+	if g.mayHoldAny("a", []string{"ez"}) {
+		t.Errorf("worker not skipped on its second hint")
+	}
+}
+
+func TestCoverageKeepsWorkersWrittenToSinceTheirHintBeforeLast(t *testing.T) {
+	g, fake := withPrunedWorker(t)
+	g.wroteTo("a", "ezjmg")
+	for _, cells := range [][]string{{"ez"}, {"ezj"}, {"ezjmg"}, {"ezjmgt"}} {
+		if !g.mayHoldAny("a", cells) {
+			t.Errorf("worker skipped for %q, written to since its latest hint", cells)
+		}
+	}
+	if g.mayHoldAny("a", []string{"ezk"}) || g.mayHoldAny("a", []string{"ezjmh"}) {
+		t.Errorf("worker not skipped for cells next to the written ones")
+	}
+
+	// the next hint may have been built before the write, the one after can't have been
+	fake.advance(time.Second)
+	sendHint(g, "u", "u4")
+	if !g.mayHoldAny("a", []string{"ezj"}) {
+		t.Errorf("worker skipped on the hint following the write")
+	}
+	fake.advance(time.Second)
+	sendHint(g, "u", "u4")
+	if g.mayHoldAny("a", []string{"ezj"}) {
+		t.Errorf("worker still not skipped two hints after the write (its data left the window)")
+	}
+
+	// writes to the previous layout's keys during a sharding migration
+	sharding.Store(&shardingState{precision: 5, previous: 3, switchAt: 1, until: 2})
+	g.wroteTo("a", "ezj")
+	if !g.mayHoldAny("a", []string{"ezjmgt"}) {
+		t.Errorf("worker skipped for a cell under a key of the previous precision")
+	}
+}
+
+func TestCoverageKeepsWorkersWithTooManyWritesToTrack(t *testing.T) {
+	g, fake := withPrunedWorker(t)
+	previous := maxWrittenCells
+	maxWrittenCells = 8
+	t.Cleanup(func() { maxWrittenCells = previous })
+
+	for _, key := range []string{"s0000", "s0001", "s0002", "s0003"} {
+		g.wroteTo("a", key)
+	}
+	if !g.mayHoldAny("a", []string{"ez"}) {
+		t.Errorf("worker skipped once its writes couldn't be tracked")
+	}
+	fake.advance(time.Second)
+	sendHint(g, "u", "u4")
+	if !g.mayHoldAny("a", []string{"ez"}) {
+		t.Errorf("worker skipped on a hint that may have been built before the untracked writes")
+	}
+	fake.advance(time.Second)
+	sendHint(g, "u", "u4")
+	if g.mayHoldAny("a", []string{"ez"}) {
+		t.Errorf("worker still not skipped on a hint built after the untracked writes")
+	}
+}
+
+func TestCoverageKeepsWorkersAfterKeysMoved(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		move func(g *GatewayState, now time.Time)
+	}{
+		{"worker joined", func(g *GatewayState, now time.Time) { g.addNode("worker-b", "b") }},
+		{"worker left", func(g *GatewayState, now time.Time) {
+			g.addNode("worker-b", "b")
+			g.ringChanged = time.Time{}
+			g.removeNode("worker-b")
+		}},
+		{"worker draining", func(g *GatewayState, now time.Time) { g.setDraining("a", true) }},
+		{"sharding switch", func(g *GatewayState, now time.Time) {
+			sharding.Store(&shardingState{precision: 5, previous: 4, switchAt: now.UnixMilli(), until: now.Add(time.Minute).UnixMilli()})
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			g, fake := withPrunedWorker(t)
+			tt.move(g, fake.Now())
+			fake.advance(time.Second)
+			if !g.mayHoldAny("a", []string{"ez"}) {
+				t.Errorf("worker skipped on a hint older than the last move of keys")
+			}
+			sendHint(g, "u", "u4")
+			if g.mayHoldAny("a", []string{"ez"}) {
+				t.Errorf("worker not skipped on a hint newer than the last move of keys")
+			}
+		})
+	}
+
+	// a sharding switch still to come doesn't stop the pruning
+	g, fake := withPrunedWorker(t)
+	sharding.Store(&shardingState{precision: 5, previous: 4, switchAt: fake.Now().Add(time.Minute).UnixMilli(), until: fake.Now().Add(2 * time.Minute).UnixMilli()})
+	if g.mayHoldAny("a", []string{"ez"}) {
+		t.Errorf("worker not skipped before the sharding switch")
+	}
+}
+
+func TestRoutePingKeepsItsPrimaryFromBeingPruned(t *testing.T) {
+	g, _ := withPrunedWorker(t)
+	workers := fakeWorkers{"a": {}}
+	s := newGatewayService(g, workers)
+	if _, err := s.RoutePing(context.Background(), "ezjmgtwyz", 1000, "", 0); err != nil {
+		t.Fatalf("RoutePing: %v", err)
+	}
+	if !g.mayHoldAny("a", []string{"ezj"}) {
+		t.Errorf("worker skipped for the cell just written to it")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// public playground: DEMO_MODE=true turns a gateway into a self-contained demo anyone may use without credentials.
+//   - anonymous access: INGEST_TOKEN and QUERY_TOKEN are ignored, and /admin is closed unless ADMIN_TOKEN is set
+//   - every client address (see INGEST_TRUSTED_PROXIES) gets DEMO_RATE_LIMIT requests per second over the ingest and
+//     query routes, on top of their group limits (429 beyond)
+//   - the demo map is served at /ui (UI_ENABLED)
+//   - DEMO_SEED_RATE synthetic pings per second are written around DEMO_CITY (a city below or "lat,lng"), within
+//     DEMO_RADIUS km: most of them around a few hotspots whose activity rises and falls over minutes, the rest anywhere,
+//     so the map has something to show. they go through the ack=none queue like any fire-and-forget ping
+var DEMO_MODE = env.Bool("DEMO_MODE", false)
+var DEMO_RATE_LIMIT = env.Int("DEMO_RATE_LIMIT", 10)
+var DEMO_CITY = env.String("DEMO_CITY", "vigo")
+var DEMO_RADIUS = env.Float("DEMO_RADIUS", 5)      // km
+var DEMO_SEED_RATE = env.Int("DEMO_SEED_RATE", 50) // 0 = no seeding
+
+var demoCities = map[string][2]float64{
+	"vigo":     {42.2406, -8.7207},
+	"madrid":   {40.4168, -3.7038},
+	"paris":    {48.8566, 2.3522},
+	"london":   {51.5072, -0.1276},
+	"new-york": {40.7128, -74.0060},
+	"tokyo":    {35.6762, 139.6503},
+}
+
+const (
+	maxDemoClients   = 1 << 16 // client addresses tracked at once
+	demoHotspots     = 8
+	demoHotspotShare = 0.8 // of the seeded pings
+	demoSeedTick     = 100 * time.Millisecond
+)
+
+var demoClients = struct {
+	sync.Mutex
+	byAddr map[netip.Addr]*rateLimiter
+}{byAddr: make(map[netip.Addr]*rateLimiter)}
+
+// applyDemoMode adjusts the configuration for DEMO_MODE, before any route is set up
+func applyDemoMode() {
+	if !DEMO_MODE {
+		return
+	}
+	if ingestGroup.token != "" || queryGroup.token != "" {
+		log.Printf("DEMO_MODE: ignoring INGEST_TOKEN and QUERY_TOKEN")
+	}
+	ingestGroup.token, queryGroup.token = "", ""
+	UI_ENABLED = true
+	admin := "behind ADMIN_TOKEN"
+	if ADMIN_TOKEN == "" {
+		admin = "closed"
+	}
+	log.Printf("DEMO_MODE: anonymous access, %d requests per second per client, /admin %s", DEMO_RATE_LIMIT, admin)
+}
+
+// demoCenter returns the center of DEMO_CITY
+func demoCenter() (float64, float64, bool) {
+	if c, ok := demoCities[strings.ToLower(DEMO_CITY)]; ok {
+		return c[0], c[1], true
+	}
+	lat, lng, found := strings.Cut(DEMO_CITY, ",")
+	if !found {
+		return 0, 0, false
+	}
+	la, err1 := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	ln, err2 := strconv.ParseFloat(strings.TrimSpace(lng), 64)
+	if err1 != nil || err2 != nil || math.Abs(la) > 90 || math.Abs(ln) > 180 {
+		return 0, 0, false
+	}
+	return la, ln, true
+}
+
+// allowDemoClient applies DEMO_RATE_LIMIT to the client of a request
+func allowDemoClient(r *http.Request) bool {
+	if DEMO_RATE_LIMIT <= 0 {
+		return true
+	}
+	addr := requestClientIP(r)
+	demoClients.Lock()
+	l := demoClients.byAddr[addr]
+	if l == nil {
+		if len(demoClients.byAddr) >= maxDemoClients {
+			forgetIdleDemoClientsLocked(time.Now())
+		}
+		if len(demoClients.byAddr) >= maxDemoClients {
+			demoClients.Unlock()
+			return false
+		}
+		l = newRateLimiter(DEMO_RATE_LIMIT)
+		demoClients.byAddr[addr] = l
+	}
+	demoClients.Unlock()
+	return l.allow()
+}
+
+// forgetIdleDemoClientsLocked forgets the clients whose bucket has refilled (a new one is the same). demoClients must be
+// locked
+func forgetIdleDemoClientsLocked(now time.Time) {
+	for addr, l := range demoClients.byAddr {
+		l.mu.Lock()
+		idle := now.Sub(l.last) >= time.Second
+		l.mu.Unlock()
+		if idle {
+			delete(demoClients.byAddr, addr)
+		}
+	}
+}
+
+// GET /ui/demo.json: where the demo map starts, over DEMO_CITY
+func getDemoView(w http.ResponseWriter, r *http.Request) {
+	lat, lng, ok := demoCenter()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	zoom := int(math.Round(math.Log2(40075 / max(DEMO_RADIUS, 0.01)))) // the radius about half the width of the map
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"center": []float64{lat, lng}, "zoom": min(max(zoom, 2), 18)})
+}
+
+// startDemoSeeding starts writing the synthetic pings of DEMO_MODE, once the ack=none queue is up
+func startDemoSeeding() {
+	if !DEMO_MODE || DEMO_SEED_RATE <= 0 {
+		return
+	}
+	lat, lng, ok := demoCenter()
+	if !ok {
+		log.Fatalf("invalid DEMO_CITY %q (one of vigo, madrid, paris, london, new-york, tokyo, or \"lat,lng\")", DEMO_CITY)
+	}
+	if DEMO_RADIUS <= 0 || DEMO_RADIUS > 1000 {
+		log.Fatalf("invalid DEMO_RADIUS %g (km)", DEMO_RADIUS)
+	}
+	seeder := newDemoSeeder(lat, lng, DEMO_RADIUS)
+	log.Printf("DEMO_MODE: seeding %d pings per second within %g km of %.4f,%.4f", DEMO_SEED_RATE, DEMO_RADIUS, lat, lng)
+
+	go func() {
+		perTick := float64(DEMO_SEED_RATE) * demoSeedTick.Seconds()
+		owed := 0.0
+		for now := range time.Tick(demoSeedTick) {
+			for owed += perTick; owed >= 1; owed-- {
+				lat, lng := seeder.next(now)
+				ingestedAt := monotonicNow().UnixMilli()
+				if !enqueuePing(geo.Encode(lat, lng, MAX_GH_PRECISION), ingestedAt, anonymous, "", 0, 0, nil, nil) {
+					owed = 0 // the queue is full: skip the tick
+					break
+				}
+			}
+		}
+	}()
+}
+
+type demoHotspot struct {
+	lat, lng float64
+	spread   float64       // km
+	period   time.Duration // of its activity
+	phase    float64
+}
+
+// demoSeeder picks the locations of synthetic pings
+type demoSeeder struct {
+	lat, lng, radius float64
+	hotspots         []demoHotspot
+	rng              *rand.Rand
+}
+
+func newDemoSeeder(lat, lng, radius float64) *demoSeeder {
+	s := &demoSeeder{lat: lat, lng: lng, radius: radius, rng: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))}
+	for i := 0; i < demoHotspots; i++ {
+		hLat, hLng := s.within(lat, lng, radius*0.7)
+		s.hotspots = append(s.hotspots, demoHotspot{
+			lat: hLat, lng: hLng,
+			spread: radius * (0.03 + 0.07*s.rng.Float64()),
+			period: time.Duration(2+s.rng.IntN(8)) * time.Minute,
+			phase:  2 * math.Pi * s.rng.Float64(),
+		})
+	}
+	return s
+}
+
+// next returns the location of the next ping at now
+func (s *demoSeeder) next(now time.Time) (float64, float64) {
+	if s.rng.Float64() >= demoHotspotShare {
+		return s.within(s.lat, s.lng, s.radius)
+	}
+	// a hotspot, weighted by its current activity (0.1 to 1)
+	weights := make([]float64, len(s.hotspots))
+	total := 0.0
+	for i, h := range s.hotspots {
+		t := float64(now.UnixNano()) / float64(h.period)
+		weights[i] = 0.55 + 0.45*math.Sin(2*math.Pi*t+h.phase)
+		total += weights[i]
+	}
+	pick := s.rng.Float64() * total
+	h := s.hotspots[len(s.hotspots)-1]
+	for i, w := range weights {
+		if pick -= w; pick < 0 {
+			h = s.hotspots[i]
+			break
+		}
+	}
+	dLat, dLng := kmToDegrees(s.rng.NormFloat64()*h.spread, s.rng.NormFloat64()*h.spread, h.lat)
+	return clampLat(h.lat + dLat), wrapLng(h.lng + dLng)
+}
+
+// within returns a uniformly random location within radius km of lat,lng
+func (s *demoSeeder) within(lat, lng, radius float64) (float64, float64) {
+	r, theta := radius*math.Sqrt(s.rng.Float64()), 2*math.Pi*s.rng.Float64()
+	dLat, dLng := kmToDegrees(r*math.Cos(theta), r*math.Sin(theta), lat)
+	return clampLat(lat + dLat), wrapLng(lng + dLng)
+}
+
+func kmToDegrees(north, east, lat float64) (float64, float64) {
+	const kmPerDegree = 111.32
+	return north / kmPerDegree, east / (kmPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+}
+
+func clampLat(lat float64) float64 {
+	return math.Max(-90, math.Min(90, lat))
+}
+
+func wrapLng(lng float64) float64 {
+	for lng > 180 {
+		lng -= 360
+	}
+	for lng < -180 {
+		lng += 360
+	}
+	return lng
+}
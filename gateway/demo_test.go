@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDemoClientsAreLimitedByAddress(t *testing.T) {
+	previous := DEMO_RATE_LIMIT
+	DEMO_RATE_LIMIT = 2
+	t.Cleanup(func() { DEMO_RATE_LIMIT = previous })
+	demoClients.Lock()
+	clear(demoClients.byAddr)
+	demoClients.Unlock()
+
+	request := func(addr string) bool {
+		r := httptest.NewRequest("GET", "/pingArea", nil)
+		r.RemoteAddr = addr
+		return allowDemoClient(r)
+	}
+	if !request("192.0.2.1:1000") || !request("192.0.2.1:1001") {
+		t.Fatalf("burst of a client rejected")
+	}
+	if request("192.0.2.1:1002") {
+		t.Fatalf("client allowed beyond DEMO_RATE_LIMIT")
+	}
+	if !request("192.0.2.2:1000") {
+		t.Fatalf("another client rejected")
+	}
+}
+
+func TestDemoSeederStaysAroundTheCity(t *testing.T) {
+	s := newDemoSeeder(42.2406, -8.7207, 5)
+	now := time.Now()
+	far := 0
+	for i := 0; i < 10000; i++ {
+		lat, lng := s.next(now)
+		dLat, dLng := (lat-42.2406)*111.32, (lng+8.7207)*111.32*math.Cos(42.2406*math.Pi/180)
+		if d := math.Hypot(dLat, dLng); d > 5*1.5 {
+			far++
+		}
+	}
+	if far > 0 {
+		t.Fatalf("%d of 10000 pings more than 1.5 radius away", far)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errors returned by the routing code behind the handlers (other sentinels live next to their feature: errConsistency,
+// errTeleport, errFeatureUnsupported, the read token ones), and the single place they are mapped to what clients see:
+// statusOf gives the HTTP status, gRPC code and message of any of them, so that a failure is answered the same way by
+// every endpoint and clients can tell a retryable one (503/504, with Retry-After) from one that won't succeed as is
+var (
+	errNoWorkers        = errors.New("no workers available")
+	errWorkerConnect    = errors.New("failed to connect to worker")
+	errShardTimeout     = errors.New("worker timed out") // see shardError
+	errDuplicatePing    = errors.New("duplicate ping")   // the worker already stored this (device id, seq)
+	errAreaTooLarge     = errors.New("requested area too large for precision")
+	errAreaTooSmall     = errors.New("bounding box too small for available precisions")
+	errAreaTooExpensive = errors.New("requested area too expensive (predicted over the latency budget)")
+)
+
+type errorStatus struct {
+	http    int
+	grpc    codes.Code
+	message string
+}
+
+// by sentinel, matched in order with errors.Is
+var errorStatuses = []struct {
+	err error
+	errorStatus
+}{
+	{errDuplicatePing, errorStatus{http.StatusOK, codes.AlreadyExists, "Duplicate ping ignored"}},
+	{errConsistency, errorStatus{http.StatusServiceUnavailable, codes.Unavailable, "Consistency level not achieved"}},
+	{errNoWorkers, errorStatus{http.StatusServiceUnavailable, codes.Unavailable, "No workers available"}},
+	{errWorkerConnect, errorStatus{http.StatusServiceUnavailable, codes.Unavailable, "Failed to connect to worker"}},
+	{errShardTimeout, errorStatus{http.StatusGatewayTimeout, codes.DeadlineExceeded, "Worker timed out"}},
+	{errTokenWorkerGone, errorStatus{http.StatusGone, codes.DataLoss, "The read token's worker left the cluster (its writes are lost)"}},
+	{errTokenNotReached, errorStatus{http.StatusServiceUnavailable, codes.Unavailable, "The read token's write is not visible yet"}},
+	{errInvalidReadToken, errorStatus{http.StatusBadRequest, codes.InvalidArgument, "Invalid read token"}},
+	{errTeleport, errorStatus{http.StatusUnprocessableEntity, codes.FailedPrecondition, "Impossible jump from the device's last position (teleport), ping dropped"}},
+	{errAreaTooLarge, errorStatus{http.StatusRequestEntityTooLarge, codes.InvalidArgument, "Requested area too large for precision"}},
+	{errAreaTooSmall, errorStatus{http.StatusBadRequest, codes.InvalidArgument, "Bounding box too small for available precisions"}},
+	{errAreaTooExpensive, errorStatus{http.StatusRequestEntityTooLarge, codes.InvalidArgument, "Requested area too expensive for precision (predicted over the latency budget)"}},
+	{errFeatureUnsupported, errorStatus{http.StatusNotImplemented, codes.Unimplemented, "Not supported by the workers' build or configuration"}},
+}
+
+// statusOf maps an error to the status to answer with. worker errors without a sentinel go by their gRPC code, anything
+// else is an internal error
+func statusOf(err error) errorStatus {
+	for _, s := range errorStatuses {
+		if errors.Is(err, s.err) {
+			return s.errorStatus
+		}
+	}
+	switch status.Code(err) {
+	case codes.ResourceExhausted: // a worker queue (see limiter.go)
+		return errorStatus{http.StatusServiceUnavailable, codes.ResourceExhausted, "Worker overloaded"}
+	case codes.Unavailable:
+		return errorStatus{http.StatusServiceUnavailable, codes.Unavailable, "Worker unavailable"}
+	}
+	return errorStatus{http.StatusInternalServerError, codes.Internal, "Failed to contact worker"}
+}
+
+// retryable reports whether the same request may succeed later
+func (s errorStatus) retryable() bool {
+	return s.http == http.StatusServiceUnavailable || s.http == http.StatusGatewayTimeout
+}
+
+// writeError answers a request with the status of err
+func writeError(w http.ResponseWriter, err error) {
+	s := statusOf(err)
+	if s.retryable() {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.WriteHeader(s.http)
+	w.Write([]byte(s.message))
+}
+
+// shardError wraps the error of a worker call that ran out of time in errShardTimeout
+func shardError(err error) error {
+	if err != nil && (status.Code(err) == codes.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded)) {
+		return fmt.Errorf("%w: %w", errShardTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// download formats: format=csv on GET /pingArea (with compare too), GET /pingArea/frames and GET /jobs/{id}/result,
+// and format=parquet on the latter, answer the counts as a table, one row per cell (and frame), instead of JSON, so
+// that they go straight into a spreadsheet or a warehouse. only the counts are exported: not with explain, staleOk,
+// places=true or metrics.
+//
+// parquet is written by the minimal writer below, like the CBOR of the CoAP endpoint: one row group of required
+// columns (UTF8 byte arrays and int64s), one PLAIN uncompressed data page per column, and the footer in the thrift
+// compact protocol
+
+const (
+	formatJSON    = "json"
+	formatCSV     = "csv"
+	formatParquet = "parquet"
+)
+
+// parseFormat returns the format parameter of a request (json if absent), false if it isn't one of formats
+func parseFormat(query url.Values, formats ...string) (string, bool) {
+	v := query.Get("format")
+	if v == "" || v == formatJSON {
+		return formatJSON, true
+	}
+	for _, f := range formats {
+		if v == f {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// exportable reports whether the parameters of a request leave only counts to export
+func exportable(query url.Values) bool {
+	return query.Get("explain") != "true" && query.Get("staleOk") != "true" && query.Get("places") != "true" && query.Get("metrics") == ""
+}
+
+// exportColumn is a column of an exported table: text, or ints if not nil
+type exportColumn struct {
+	name string
+	text []string
+	ints []int64
+}
+
+type exportTable struct {
+	columns []*exportColumn
+	rows    int
+}
+
+func (c *exportColumn) cell(row int) string {
+	if c.ints != nil {
+		return strconv.FormatInt(c.ints[row], 10)
+	}
+	return c.text[row]
+}
+
+func sortedGeohashes[T any](counts map[string]T) []string {
+	keys := make([]string, 0, len(counts))
+	for gh := range counts {
+		keys = append(keys, gh)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countsTable is the table of area counts: geohash, count
+func countsTable(counts map[string]*ExtendedPingAreaCount) *exportTable {
+	geohash, count := &exportColumn{name: "geohash"}, &exportColumn{name: "count", ints: make([]int64, 0, len(counts))}
+	for _, gh := range sortedGeohashes(counts) {
+		geohash.text = append(geohash.text, gh)
+		count.ints = append(count.ints, counts[gh].Count)
+	}
+	return &exportTable{columns: []*exportColumn{geohash, count}, rows: len(counts)}
+}
+
+// comparedTable is the table of compared counts: geohash, count, baseline, change (empty without baseline)
+func comparedTable(counts map[string]comparedCount) *exportTable {
+	geohash, change := &exportColumn{name: "geohash"}, &exportColumn{name: "change"}
+	count := &exportColumn{name: "count", ints: make([]int64, 0, len(counts))}
+	baseline := &exportColumn{name: "baseline", ints: make([]int64, 0, len(counts))}
+	for _, gh := range sortedGeohashes(counts) {
+		c := counts[gh]
+		geohash.text = append(geohash.text, gh)
+		count.ints = append(count.ints, c.Count)
+		baseline.ints = append(baseline.ints, c.Baseline)
+		if c.Change != nil {
+			change.text = append(change.text, strconv.FormatFloat(*c.Change, 'f', -1, 64))
+		} else {
+			change.text = append(change.text, "")
+		}
+	}
+	return &exportTable{columns: []*exportColumn{geohash, count, baseline, change}, rows: len(counts)}
+}
+
+// framesTable is the table of frames, oldest first: start, end (unix ms), geohash, count
+func framesTable(frames []*areaFrame) *exportTable {
+	start, end := &exportColumn{name: "start", ints: []int64{}}, &exportColumn{name: "end", ints: []int64{}}
+	geohash, count := &exportColumn{name: "geohash"}, &exportColumn{name: "count", ints: []int64{}}
+	rows := 0
+	for _, f := range frames {
+		for _, gh := range sortedGeohashes(f.Counts) {
+			start.ints = append(start.ints, f.Start)
+			end.ints = append(end.ints, f.End)
+			geohash.text = append(geohash.text, gh)
+			count.ints = append(count.ints, f.Counts[gh].Count)
+			rows++
+		}
+	}
+	return &exportTable{columns: []*exportColumn{start, end, geohash, count}, rows: rows}
+}
+
+// writeTable answers a table in a download format, as an attachment named name.<format>
+func writeTable(w http.ResponseWriter, format, name string, t *exportTable) {
+	var body bytes.Buffer
+	switch format {
+	case formatCSV:
+		cw := csv.NewWriter(&body)
+		header := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			header[i] = c.name
+		}
+		cw.Write(header)
+		record := make([]string, len(t.columns))
+		for row := 0; row < t.rows; row++ {
+			for i, c := range t.columns {
+				record[i] = c.cell(row)
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case formatParquet:
+		writeParquet(&body, t)
+		w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+// parquet enums (see parquet.thrift)
+const (
+	parquetInt64         = 2
+	parquetByteArray     = 6
+	parquetRequired      = 0
+	parquetUTF8          = 0 // converted type
+	parquetPlain         = 0
+	parquetRLE           = 3
+	parquetUncompressed  = 0
+	parquetDataPage      = 0
+	parquetFormatVersion = 1
+)
+
+var parquetMagic = []byte("PAR1")
+
+// writeParquet writes a table as a parquet file
+func writeParquet(out *bytes.Buffer, t *exportTable) {
+	out.Write(parquetMagic)
+
+	type chunk struct {
+		offset, size int64
+		typ          int32
+	}
+	chunks := make([]chunk, len(t.columns))
+	for i, c := range t.columns {
+		var page []byte
+		typ := int32(parquetByteArray)
+		if c.ints != nil {
+			typ = parquetInt64
+			for _, v := range c.ints {
+				page = binary.LittleEndian.AppendUint64(page, uint64(v))
+			}
+		} else {
+			for _, v := range c.text {
+				page = binary.LittleEndian.AppendUint32(page, uint32(len(v)))
+				page = append(page, v...)
+			}
+		}
+
+		var header thriftWriter
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.begin(5) // data_page_header
+		header.i32(1, int32(t.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.stop()
+
+		chunks[i] = chunk{offset: int64(out.Len()), size: int64(len(header.b) + len(page)), typ: typ}
+		out.Write(header.b)
+		out.Write(page)
+	}
+
+	var meta thriftWriter
+	meta.i32(1, parquetFormatVersion)
+	meta.list(2, thriftStruct, len(t.columns)+1) // schema: the root, then the columns
+	meta.element()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(t.columns)))
+	meta.end()
+	for i, c := range t.columns {
+		meta.element()
+		meta.i32(1, chunks[i].typ)
+		meta.i32(3, parquetRequired)
+		meta.binary(4, c.name)
+		if c.ints == nil {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(t.rows))
+	meta.list(4, thriftStruct, 1) // row groups
+	meta.element()
+	meta.list(1, thriftStruct, len(t.columns))
+	var total int64
+	for i, c := range t.columns {
+		meta.element()
+		meta.i64(2, chunks[i].offset)
+		meta.begin(3) // meta_data
+		meta.i32(1, chunks[i].typ)
+		meta.list(2, thriftI32, 1)
+		meta.varint(zigzag(parquetPlain))
+		meta.list(3, thriftBinary, 1)
+		meta.string(c.name)
+		meta.i32(4, parquetUncompressed)
+		meta.i64(5, int64(t.rows))
+		meta.i64(6, chunks[i].size)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.end()
+		meta.end()
+		total += chunks[i].size
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(t.rows))
+	meta.end()
+	meta.binary(6, "geostreamdb gateway "+build.Version)
+	meta.stop()
+
+	out.Write(meta.b)
+	out.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta.b))))
+	out.Write(parquetMagic)
+}
+
+// thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes a struct in the thrift compact protocol. nested structs are opened with begin (a field) or
+// element (of a list) and closed with end; the outermost one with stop
+type thriftWriter struct {
+	b     []byte
+	last  int16   // id of the previous field of the current struct
+	outer []int16 // those of the enclosing structs
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.b = binary.AppendUvarint(t.b, v)
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) string(v string) {
+	t.varint(uint64(len(v)))
+	t.b = append(t.b, v...)
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.string(v)
+}
+
+// list starts a list field of n elements, written next
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elem)
+		return
+	}
+	t.b = append(t.b, 0xf0|elem)
+	t.varint(uint64(n))
+}
+
+// begin opens a struct field
+func (t *thriftWriter) begin(id int16) {
+	t.field(id, thriftStruct)
+	t.element()
+}
+
+// element opens a struct element of a list
+func (t *thriftWriter) element() {
+	t.outer = append(t.outer, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) end() {
+	t.stop()
+	t.last, t.outer = t.outer[len(t.outer)-1], t.outer[:len(t.outer)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.b = append(t.b, 0)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteTableCSV(t *testing.T) {
+	change := 50.0
+	rec := httptest.NewRecorder()
+	writeTable(rec, formatCSV, "pingArea", comparedTable(map[string]comparedCount{
+		"ezjmg": {Count: 3, Baseline: 2, Change: &change},
+		"ez9b1": {Count: 1},
+	}))
+	want := "geohash,count,baseline,change\nez9b1,1,0,\nezjmg,3,2,50\n"
+	if got := rec.Body.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="pingArea.csv"` {
+		t.Fatalf("got Content-Disposition %q", got)
+	}
+}
+
+func TestThriftCompactEncoding(t *testing.T) {
+	var w thriftWriter
+	w.i32(1, 1)       // short form: delta 1, i32, zigzag 2
+	w.i64(20, -1)     // long form: i64, zigzag id 40, zigzag 1
+	w.begin(21)       // struct: delta 1
+	w.binary(4, "ab") // nested ids start over: delta 4
+	w.end()
+	w.stop()
+	want := []byte{0x15, 0x02, 0x06, 0x28, 0x01, 0x1c, 0x48, 0x02, 'a', 'b', 0x00, 0x00}
+	if !bytes.Equal(w.b, want) {
+		t.Fatalf("got % x, want % x", w.b, want)
+	}
+}
+
+func TestWriteParquetFraming(t *testing.T) {
+	var b bytes.Buffer
+	writeParquet(&b, countsTable(map[string]*ExtendedPingAreaCount{"ez9b1": {Count: 3}, "ezjmg": {Count: -1}}))
+	file := b.Bytes()
+	if !bytes.HasPrefix(file, parquetMagic) || !bytes.HasSuffix(file, parquetMagic) {
+		t.Fatalf("missing magic")
+	}
+	n := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	if n <= 0 || n > len(file)-12 {
+		t.Fatalf("footer length %d of a %d bytes file", n, len(file))
+	}
+	footer := file[len(file)-8-n : len(file)-8]
+	for _, name := range []string{"schema", "geohash", "count"} {
+		if !bytes.Contains(footer, []byte(name)) {
+			t.Errorf("footer lacks %q", name)
+		}
+	}
+	// the count page ends the data: PLAIN int64s
+	data := file[:len(file)-8-n]
+	if got := int64(binary.LittleEndian.Uint64(data[len(data)-8:])); got != -1 {
+		t.Errorf("last count %d, want -1", got)
+	}
+	if !bytes.Contains(data, []byte("\x05\x00\x00\x00ez9b1\x05\x00\x00\x00ezjmg")) {
+		t.Errorf("geohash page not found")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"slices"
+
+	pb "geostreamdb/proto"
+)
+
+// worker features (see the proto's features.go): the capabilities each worker listed in its latest heartbeat. requests
+// needing one skip the workers without it instead of sending them RPCs they would fail: area queries go to a replica
+// that has it (a shard where none does is left out, the answer being partial), broadcasts record those workers as not
+// supporting it. a worker that listed nothing (older builds) is assumed to support everything
+var errFeatureUnsupported = errors.New("not supported by the worker's build or configuration")
+
+// setFeatures records the features a worker announced (nil: an older build, that lists none)
+func (g *GatewayState) setFeatures(address string, features []string) {
+	g.featuresMutex.Lock()
+	defer g.featuresMutex.Unlock()
+	if len(features) == 0 {
+		delete(g.features, address)
+		return
+	}
+	g.features[address] = features
+}
+
+func (g *GatewayState) deleteFeatures(address string) {
+	g.featuresMutex.Lock()
+	delete(g.features, address)
+	g.featuresMutex.Unlock()
+}
+
+// featuresOf returns the features a worker announced, nil if it lists none
+func (g *GatewayState) featuresOf(address string) []string {
+	g.featuresMutex.RLock()
+	defer g.featuresMutex.RUnlock()
+	return g.features[address]
+}
+
+// supports reports whether a worker has every one of the features
+func (g *GatewayState) supports(address string, features ...string) bool {
+	g.featuresMutex.RLock()
+	defer g.featuresMutex.RUnlock()
+	listed, known := g.features[address]
+	if !known {
+		return true
+	}
+	for _, f := range features {
+		if !slices.Contains(listed, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// features returns the worker features an area query needs
+func (q pingAreaQuery) features() []string {
+	var features []string
+	if q.frameSeconds > 0 || q.smooth > 1 {
+		features = append(features, pb.FeatureFrames)
+	}
+	if q.historyOffset > 0 {
+		features = append(features, pb.FeatureHistory)
+	}
+	if q.movement {
+		features = append(features, pb.FeatureMovement)
+	}
+	if q.hasFloor {
+		features = append(features, pb.FeatureFloors)
+	}
+	return features
+}
+
+// supporting returns the workers that have the features, in order
+func (g *GatewayState) supporting(workers []string, features []string) []string {
+	if len(features) == 0 {
+		return workers
+	}
+	var out []string
+	for _, addr := range workers {
+		if g.supports(addr, features...) {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
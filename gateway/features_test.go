@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	pb "geostreamdb/proto"
+)
+
+func TestWorkersWithoutFeatureListsSupportEverything(t *testing.T) {
+	g := newTestGatewayState(t)
+	if !g.supports("old:50051", pb.FeatureHistory, pb.FeatureRaw) {
+		t.Error("a worker that listed no features doesn't support them")
+	}
+	g.setFeatures("new:50051", []string{pb.FeatureFrames, pb.FeatureHistory})
+	if !g.supports("new:50051", pb.FeatureHistory) || g.supports("new:50051", pb.FeatureHistory, pb.FeatureRaw) {
+		t.Errorf("features %v: history and raw misjudged", g.featuresOf("new:50051"))
+	}
+	g.setFeatures("new:50051", nil) // downgraded to an older build
+	if !g.supports("new:50051", pb.FeatureRaw) {
+		t.Error("a worker that stopped listing features is still judged by its former list")
+	}
+}
+
+func TestPlanRoutesAroundWorkersWithoutTheQuerysFeatures(t *testing.T) {
+	withReplicationFactor(t, 2)
+	workers := fakeWorkers{"a": {count: 1}, "b": {count: 1}}
+	s := newGatewayService(fakeRing{owners: []string{"a", "b"}, servers: []string{"a", "b"}}, workers)
+	state.setFeatures("a", []string{pb.FeatureFrames}) // an engine without movement
+	state.setFeatures("b", []string{pb.FeatureFrames, pb.FeatureMovement})
+	t.Cleanup(func() {
+		state.deleteFeatures("a")
+		state.deleteFeatures("b")
+	})
+
+	q, err := s.planner.Query(42.23, 42.231, -8.73, -8.729, MAX_GH_PRECISION)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	q.precUsed, q.movement = SHARDING_PRECISION, true
+	plan := s.planner.Plan(q)
+	plan.Execute(context.Background())
+	if plan.partial() || workers["a"].areaCalls != 0 || plan.Shards[0].Worker != "b" {
+		t.Fatalf("shards %+v, calls to a: %d; want every shard answered by the replica b", plan.Shards, workers["a"].areaCalls)
+	}
+
+	q.hasFloor = true // neither keeps floors
+	plan = s.planner.Plan(q)
+	plan.Execute(context.Background())
+	if !plan.partial() || plan.Shards[0].Error != errFeatureUnsupported.Error() || workers["b"].areaCalls != 1 {
+		t.Fatalf("shards %+v; want the shard left out without calls", plan.Shards)
+	}
+}
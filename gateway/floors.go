@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"math"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// floors: POST /ping takes an optional "floor" (a venue's level, an integer) or "altitude" (meters, bucketed into
+// floors of ALTITUDE_BUCKET from 0: 0 to 3 m is floor 0, -3 to 0 m floor -1...), the vertical bucket of the ping. it
+// goes along with the ping to its primary and replicas, which count it in its 2D cell as usual and in that cell of its
+// floor (see worker-node/floors.go). the /pingArea parameters take floor=N to only count the pings of a floor, for
+// indoor and venue analytics where every level of a building would otherwise stack onto the same cell. only the live
+// window has them, so not with compare or metrics=speed
+var ALTITUDE_BUCKET = env.Float("ALTITUDE_BUCKET", 3) // meters
+
+// maxFloor bounds floors either way (altitudes up to about 30 km with the default bucket)
+const maxFloor = 10000
+
+type floorContextKey struct{}
+
+// parseFloor checks the floor or altitude of a ping, returning its floor (nil if it has neither)
+func parseFloor(floor *int32, altitude *float64) (*int32, string) {
+	switch {
+	case floor != nil && altitude != nil:
+		return nil, "floor or altitude, not both"
+	case altitude != nil:
+		if math.IsNaN(*altitude) || math.IsInf(*altitude, 0) || math.Abs(*altitude/ALTITUDE_BUCKET) > maxFloor {
+			return nil, "Invalid altitude"
+		}
+		f := int32(math.Floor(*altitude / ALTITUDE_BUCKET))
+		return &f, ""
+	case floor != nil && (*floor > maxFloor || *floor < -maxFloor):
+		return nil, "Invalid floor"
+	}
+	return floor, ""
+}
+
+// withFloor carries the floor of a ping to its SendPing calls
+func withFloor(ctx context.Context, floor *int32) context.Context {
+	if floor == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, floorContextKey{}, *floor)
+}
+
+// setFloor sets the floor carried by ctx, if any, on a SendPing request
+func setFloor(ctx context.Context, req *pb.PingRequest) {
+	if floor, ok := ctx.Value(floorContextKey{}).(int32); ok {
+		req.HasFloor, req.Floor = true, floor
+	}
+}
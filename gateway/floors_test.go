@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	pb "geostreamdb/proto"
+)
+
+func TestParseFloorBucketsAltitudes(t *testing.T) {
+	i := func(v int32) *int32 { return &v }
+	f := func(v float64) *float64 { return &v }
+	for _, tc := range []struct {
+		floor    *int32
+		altitude *float64
+		want     int32
+	}{{i(3), nil, 3}, {i(-2), nil, -2}, {nil, f(0), 0}, {nil, f(2.9), 0}, {nil, f(9.5), 3}, {nil, f(-0.5), -1}} {
+		got, msg := parseFloor(tc.floor, tc.altitude)
+		if msg != "" || got == nil || *got != tc.want {
+			t.Errorf("floor %v altitude %v: got %v (%q), want %d", tc.floor, tc.altitude, got, msg, tc.want)
+		}
+	}
+	for _, bad := range []struct {
+		floor    *int32
+		altitude *float64
+	}{{i(1), f(3)}, {i(maxFloor + 1), nil}, {nil, f(math.NaN())}, {nil, f(math.Inf(1))}, {nil, f(1e9)}} {
+		if _, msg := parseFloor(bad.floor, bad.altitude); msg == "" {
+			t.Errorf("floor %v altitude %v accepted", bad.floor, bad.altitude)
+		}
+	}
+
+	req := &pb.PingRequest{}
+	setFloor(withFloor(context.Background(), i(-1)), req)
+	if !req.HasFloor || req.Floor != -1 {
+		t.Errorf("floor not carried: %v", req)
+	}
+	req = &pb.PingRequest{}
+	if setFloor(withFloor(context.Background(), nil), req); req.HasFloor {
+		t.Errorf("floor set without one: %v", req)
+	}
+}
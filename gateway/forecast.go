@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"geostreamdb/geo"
+)
+
+// GET /forecast?geohash=<cell>&horizon=15m&step=5m&history=6h&season=<duration>: a short-term forecast of the pings of
+// a cell. the cell's counts per step over the last `history` are read like /pingArea/frames (the workers' history
+// tier, so HISTORY_RETENTION must reach that far and HISTORY_PRECISION be as fine as the cell), and extrapolated over
+// `horizon` by Holt-Winters exponential smoothing: level and trend (Holt's linear method), plus an additive seasonal
+// component of `season` steps when given (e.g. 24h, with a history of two seasons at least). the smoothing factors are
+// those that best predicted the history one step ahead; the forecast comes with a ~95% band from that error. counts
+// are suppressed by the tenant's k-anonymity before fitting. accounted as one cell per step of history
+const (
+	maxForecastSteps = 3600 // steps of history (the workers' frame limit)
+	minForecastStep  = time.Minute
+	maxForecastStep  = 24 * time.Hour
+)
+
+var (
+	forecastAlphas = []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+	forecastBetas  = []float64{0, 0.05, 0.1, 0.2, 0.3}
+	forecastGammas = []float64{0.05, 0.1, 0.2, 0.3, 0.5}
+)
+
+type forecastPoint struct {
+	Start int64   `json:"start"` // unix ms
+	End   int64   `json:"end"`
+	Count float64 `json:"count"`
+	Low   float64 `json:"low"`
+	High  float64 `json:"high"`
+}
+
+type historyPoint struct {
+	Start int64 `json:"start"` // unix ms
+	End   int64 `json:"end"`
+	Count int64 `json:"count"`
+}
+
+// holtWintersFit is the outcome of fitting a series: the smoothing factors (gamma 0 without season) and the root mean
+// squared error of their one-step predictions
+type holtWintersFit struct {
+	Alpha float64 `json:"alpha"`
+	Beta  float64 `json:"beta"`
+	Gamma float64 `json:"gamma"`
+	RMSE  float64 `json:"rmse"`
+}
+
+func getForecast(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	gh := strings.ToLower(query.Get("geohash"))
+	cell, ok := geo.Decode(gh)
+	if gh == "" || !ok || len(gh) > MAX_GH_PRECISION {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid geohash"))
+		return
+	}
+	step, ok := forecastDuration(query.Get("step"), 5*time.Minute)
+	if !ok || step < minForecastStep || step > maxForecastStep {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid step (whole seconds, 1m to 24h)"))
+		return
+	}
+	horizon, ok := forecastDuration(query.Get("horizon"), 15*time.Minute)
+	if !ok || horizon < step {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid horizon (whole seconds, one step at least)"))
+		return
+	}
+	span, ok := forecastDuration(query.Get("history"), 6*time.Hour)
+	if !ok || span < 3*step || span/step > maxForecastSteps {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Invalid history (whole seconds, 3 to %d steps)", maxForecastSteps)))
+		return
+	}
+	season, ok := forecastDuration(query.Get("season"), 0)
+	if !ok || season%step != 0 || season == step || 2*season > span {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid season (a multiple of step, half the history at most)"))
+		return
+	}
+	steps, horizonSteps, seasonSteps := int(span/step), int((horizon+step-1)/step), int(season/step)
+	if horizonSteps > steps {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("horizon can't exceed history"))
+		return
+	}
+
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(cell, len(gh)) {
+		denyACL(w, t)
+		return
+	}
+	if !retentionFor(t).allowsHistory(int64(span.Seconds())) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Forecast history beyond the history retention of this API key"))
+		return
+	}
+	q, err := planner.Query(cell.MinLat, cell.MaxLat, cell.MinLng, cell.MaxLng, len(gh))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !admitUsage(w, r, unitCells, int64(steps)) {
+		return
+	}
+
+	q = retentionFor(t).limit(q)
+	q.frameSeconds, q.frames, q.framesHistory = int64(step.Seconds()), steps, true
+	plan := planner.Plan(q)
+	frames := plan.executeFrames(r.Context())
+	logSlowQuery(r, plan)
+
+	past := make([]historyPoint, len(frames))
+	series := make([]float64, len(frames))
+	for i, f := range frames {
+		var count int64
+		for cellGh, c := range f.Counts {
+			if len(cellGh) < len(gh) && strings.HasPrefix(gh, cellGh) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("geohash finer than the workers' history (HISTORY_PRECISION)"))
+				return
+			}
+			if strings.HasPrefix(cellGh, gh) {
+				count += c.Count
+			}
+		}
+		count = privacyFor(t).suppressCount(t, count)
+		past[i] = historyPoint{Start: f.Start, End: f.End, Count: count}
+		series[i] = float64(count)
+	}
+
+	fit, predicted := fitHoltWinters(series, seasonSteps, horizonSteps)
+	end := past[len(past)-1].End
+	future := make([]forecastPoint, len(predicted))
+	for h, v := range predicted {
+		band := 1.96 * fit.RMSE * math.Sqrt(float64(h+1))
+		start := end + int64(h)*step.Milliseconds()
+		future[h] = forecastPoint{Start: start, End: start + step.Milliseconds(), Count: round2(max(v, 0)), Low: round2(max(v-band, 0)), High: round2(max(v+band, 0))}
+	}
+	fit.Alpha, fit.Beta, fit.Gamma, fit.RMSE = round2(fit.Alpha), round2(fit.Beta), round2(fit.Gamma), round2(fit.RMSE)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"geohash":  gh,
+		"step":     step.String(),
+		"season":   season.String(),
+		"history":  past,
+		"forecast": future,
+		"model":    fit,
+		"complete": !plan.partial(),
+	})
+}
+
+// forecastDuration parses a whole number of seconds, def if empty
+func forecastDuration(v string, def time.Duration) (time.Duration, bool) {
+	if v == "" {
+		return def, true
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d >= 0 && d%time.Second == 0
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// fitHoltWinters picks the smoothing factors with the smallest one-step error over the series (season 0: no seasonal
+// component) and returns the next horizon values they predict
+func fitHoltWinters(series []float64, season, horizon int) (holtWintersFit, []float64) {
+	gammas := forecastGammas
+	if season == 0 {
+		gammas = []float64{0}
+	}
+	best := holtWintersFit{RMSE: math.Inf(1)}
+	var forecast []float64
+	for _, alpha := range forecastAlphas {
+		for _, beta := range forecastBetas {
+			for _, gamma := range gammas {
+				rmse, predicted := holtWinters(series, season, alpha, beta, gamma, horizon)
+				if rmse < best.RMSE {
+					best, forecast = holtWintersFit{Alpha: alpha, Beta: beta, Gamma: gamma, RMSE: rmse}, predicted
+				}
+			}
+		}
+	}
+	return best, forecast
+}
+
+// holtWinters runs additive Holt-Winters smoothing over the series (at least two seasons of it, or two values without
+// season), returning the root mean squared error of its one-step predictions and the next horizon values
+func holtWinters(series []float64, season int, alpha, beta, gamma float64, horizon int) (float64, []float64) {
+	var level, trend float64
+	seasonal := make([]float64, max(season, 1))
+	first := 1
+	if season > 0 {
+		// the first season sets the level and the seasonal offsets, the change to the second one the trend
+		var firstMean, secondMean float64
+		for i := 0; i < season; i++ {
+			firstMean += series[i] / float64(season)
+			secondMean += series[season+i] / float64(season)
+		}
+		level, trend = firstMean, (secondMean-firstMean)/float64(season)
+		for i := 0; i < season; i++ {
+			seasonal[i] = series[i] - firstMean
+		}
+		first = season
+	} else {
+		level, trend = series[0], series[1]-series[0]
+	}
+
+	var sse float64
+	for t := first; t < len(series); t++ {
+		s := seasonal[t%len(seasonal)]
+		if season == 0 {
+			s = 0
+		}
+		errPredicted := series[t] - (level + trend + s)
+		sse += errPredicted * errPredicted
+		previous := level
+		level = alpha*(series[t]-s) + (1-alpha)*(level+trend)
+		trend = beta*(level-previous) + (1-beta)*trend
+		if season > 0 {
+			seasonal[t%season] = gamma*(series[t]-level) + (1-gamma)*s
+		}
+	}
+
+	out := make([]float64, horizon)
+	for h := range out {
+		out[h] = level + float64(h+1)*trend
+		if season > 0 {
+			out[h] += seasonal[(len(series)+h)%season]
+		}
+	}
+	return math.Sqrt(sse / float64(len(series)-first)), out
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+func TestHoltWintersFollowsTrendAndSeason(t *testing.T) {
+	var linear []float64
+	for i := 0; i < 30; i++ {
+		linear = append(linear, 10+2*float64(i))
+	}
+	fit, predicted := fitHoltWinters(linear, 0, 3)
+	for h, v := range predicted {
+		if want := 10 + 2*float64(30+h); math.Abs(v-want) > 0.01 {
+			t.Errorf("step %d: got %v, want %v", h, v, want)
+		}
+	}
+	if fit.RMSE > 0.01 {
+		t.Errorf("got rmse %v for a line", fit.RMSE)
+	}
+
+	var seasonal []float64
+	pattern := []float64{5, 20, 40, 20}
+	for i := 0; i < 24; i++ {
+		seasonal = append(seasonal, pattern[i%4]+float64(i)/4)
+	}
+	fit, predicted = fitHoltWinters(seasonal, 4, 4)
+	for h, v := range predicted {
+		if want := pattern[(24+h)%4] + float64(24+h)/4; math.Abs(v-want) > 1 {
+			t.Errorf("step %d: got %v, want %v (fit %+v)", h, v, want, fit)
+		}
+	}
+}
+
+func TestGetForecast(t *testing.T) {
+	previous := service
+	service = newGatewayService(fakeRing{owners: []string{"a"}, servers: []string{"a"}}, fakeWorkers{"a": {count: 7, now: 1_000_000}})
+	t.Cleanup(func() { service = previous })
+	planner = service.planner
+	t.Cleanup(func() { planner = previous.planner })
+
+	w := httptest.NewRecorder()
+	getForecast(w, httptest.NewRequest("GET", "/forecast?geohash=ezjmg&step=1m&history=1h&horizon=3m", nil))
+	var resp struct {
+		History  []historyPoint  `json:"history"`
+		Forecast []forecastPoint `json:"forecast"`
+		Complete bool            `json:"complete"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != 200 || len(resp.History) != 60 || len(resp.Forecast) != 3 || !resp.Complete {
+		t.Fatalf("got %d, %d history points, %d forecast", w.Code, len(resp.History), len(resp.Forecast))
+	}
+	// the fake worker counts 7 per cell of the cover, finer than the cell
+	cell, _ := geo.Decode("ezjmg")
+	q, _ := planner.Query(cell.MinLat, cell.MaxLat, cell.MinLng, cell.MaxLng, 5)
+	want := 7 * int64(len(planner.Plan(q).cover))
+	last := resp.History[59]
+	if last.Count != want || last.End != 1_000_001_000 {
+		t.Errorf("got last history point %+v", last)
+	}
+	if f := resp.Forecast[0]; f.Count != float64(want) || f.Start != last.End || f.End != last.End+60_000 {
+		t.Errorf("got first forecast %+v", f)
+	}
+}
+
+func TestGetForecastValidates(t *testing.T) {
+	for _, q := range []string{
+		"", "geohash=ezjm!", "geohash=ezjm&step=10s", "geohash=ezjm&step=1m&horizon=30s",
+		"geohash=ezjm&step=1m&history=2m", "geohash=ezjm&step=1m&history=1000h",
+		"geohash=ezjm&history=1h&season=45m", "geohash=ezjm&step=5m&season=90s",
+		"geohash=ezjm&history=1h&horizon=2h",
+	} {
+		w := httptest.NewRecorder()
+		getForecast(w, httptest.NewRequest("GET", "/forecast?"+q, nil))
+		if w.Code != 400 {
+			t.Errorf("%s: got %d", q, w.Code)
+		}
+	}
+}
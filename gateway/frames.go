@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// GET /pingArea/frames?<the /pingArea parameters>&window=1s&frames=10: the pings that arrived in each of the last
+// `frames` windows of `window` (whole seconds), oldest first, {"window", "usedPrecision", "frames": [{"start", "end",
+// "counts"}], "complete"}, so that clients can animate a heatmap of the last seconds with one query instead of one per
+// frame. workers add up the seconds of their live window (trie engine) and take older frames from their history tier
+// (HISTORY_RETENTION, prorated from its buckets, at HISTORY_PRECISION at most for the whole request) if the tenant's
+// retention allows history that far back; otherwise frames beyond the live window fail their shards. accounted as the
+// query's cells times the frames
+var MAX_FRAMES = env.Int("MAX_FRAMES", 60)
+
+const maxFrameWindow = time.Hour
+
+type areaFrame struct {
+	Start  int64                             `json:"start"` // unix ms, beginning of the frame's first second
+	End    int64                             `json:"end"`   // unix ms, end of its last second
+	Counts map[string]*ExtendedPingAreaCount `json:"counts"`
+}
+
+func getPingAreaFrames(w http.ResponseWriter, r *http.Request) {
+	q, status, msg := parsePingAreaQuery(r.URL.Query())
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+		return
+	}
+	if q.smooth > 0 || q.movement {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Frames take neither smooth nor metrics"))
+		return
+	}
+	format, ok := parseFormat(r.URL.Query(), formatCSV)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid format (json or csv)"))
+		return
+	}
+
+	window := time.Second
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second || d > maxFrameWindow || d%time.Second != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid window (whole seconds, 1s to 1h)"))
+			return
+		}
+		window = d
+	}
+	frames := 10
+	if v := r.URL.Query().Get("frames"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MAX_FRAMES {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Invalid frames (1 to %d)", MAX_FRAMES)))
+			return
+		}
+		frames = n
+	}
+
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(q.bbox(), q.precision) {
+		denyACL(w, t)
+		return
+	}
+	if !admitUsage(w, r, unitCells, q.estimated*int64(frames)) {
+		return
+	}
+
+	q = retentionFor(t).limit(q)
+	q.frameSeconds, q.frames = int64(window.Seconds()), frames
+	q.framesHistory = retentionFor(t).allowsHistory(q.frameSeconds * int64(frames))
+	plan := planner.Plan(q)
+	out := plan.executeFrames(r.Context())
+	logSlowQuery(r, plan)
+	for _, f := range out {
+		f.Counts = privacyFor(t).suppress(t, f.Counts)
+	}
+
+	w.Header().Set("X-Precision-Used", strconv.Itoa(q.precision))
+	if format == formatCSV {
+		writeTable(w, format, "pingAreaFrames", framesTable(out))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"window": window.String(), "usedPrecision": q.precision, "frames": out, "complete": !plan.partial()})
+}
+
+// executeFrames makes the planned calls of a frames query and merges the frames of the workers, by position (their
+// clocks may be a second apart: a frame ends at the latest of its ends)
+func (plan *QueryPlan) executeFrames(ctx context.Context) []*areaFrame {
+	start := time.Now()
+	results := plan.call(ctx)
+
+	size := plan.query.frameSeconds
+	out := make([]*areaFrame, plan.query.frames)
+	for i := range out {
+		out[i] = &areaFrame{Counts: make(map[string]*ExtendedPingAreaCount)}
+	}
+	for _, result := range results {
+		for i, f := range result.Frames {
+			if i >= len(out) {
+				break
+			}
+			out[i].End = max(out[i].End, (f.End+1)*1000)
+			for _, count := range f.Counts {
+				c := out[i].Counts[count.Geohash]
+				if c == nil {
+					c = &ExtendedPingAreaCount{Server: result.Server}
+					out[i].Counts[count.Geohash] = c
+				}
+				c.Count += count.Count
+			}
+		}
+	}
+	for i, f := range out {
+		if f.End == 0 { // no worker answered: the gateway's clock
+			f.End = (start.Unix() - int64(len(out)-1-i)*size + 1) * 1000
+		}
+		f.Start = f.End - size*1000
+	}
+
+	plan.Took = float64(time.Since(start).Microseconds()) / 1000
+	return out
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExecuteFramesAddsUpTheWorkersByPosition(t *testing.T) {
+	previous := BROADCAST_PRUNING
+	BROADCAST_PRUNING = false
+	t.Cleanup(func() { BROADCAST_PRUNING = previous })
+	// b's clock is a second ahead
+	workers := fakeWorkers{"a": {count: 2, now: 1000}, "b": {count: 5, now: 1001}}
+	s := newGatewayService(fakeRing{owners: []string{"a"}, servers: []string{"a", "b"}}, workers)
+
+	q, err := s.planner.Query(42, 43, -9, -8, 3)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	q.frameSeconds, q.frames = 2, 3
+	plan := s.planner.Plan(q)
+	frames := plan.executeFrames(context.Background())
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+	for i, f := range frames {
+		end := int64(1002-2*(2-i)) * 1000
+		if f.End != end || f.Start != end-2000 {
+			t.Errorf("frame %d: got %d to %d, want %d to %d", i, f.Start, f.End, end-2000, end)
+		}
+		if len(f.Counts) != len(plan.cover) {
+			t.Fatalf("frame %d: got %d cells for a cover of %d", i, len(f.Counts), len(plan.cover))
+		}
+		for gh, c := range f.Counts {
+			if c.Count != 7 {
+				t.Errorf("frame %d, %s: got %d, want 7", i, gh, c.Count)
+			}
+		}
+	}
+	if plan.partial() {
+		t.Errorf("partial plan with every worker answering")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// end-to-end ingest latency: POST /ping takes an optional "sentAt", the client's send time (unix ms, client clock). the
+// gateway moves it to the clock of the primary the ping is written to, correcting by the skew measured from that
+// worker's heartbeats (gateway_worker_clock_skew_seconds), and the worker observes its commit time minus it as
+// worker_ingest_e2e_latency_seconds: the freshness clients actually get, not just the RPC latency. the client's own
+// clock can't be corrected, so a sentAt further ahead of the gateway clock than MAX_CLIENT_CLOCK_SKEW, or older than
+// MAX_SENT_AT_AGE, is ignored (gateway_sent_at_ignored_total)
+var MAX_CLIENT_CLOCK_SKEW = env.Duration("MAX_CLIENT_CLOCK_SKEW", time.Second)
+var MAX_SENT_AT_AGE = env.Duration("MAX_SENT_AT_AGE", 5*time.Minute)
+
+type sentAtContextKey struct{}
+
+// checkSentAt returns the client send time of a ping ingested at ingestedAt (unix ms), 0 if none or implausible
+func checkSentAt(sentAt int64, ingestedAt int64) int64 {
+	switch {
+	case sentAt <= 0:
+		return 0
+	case sentAt > ingestedAt+MAX_CLIENT_CLOCK_SKEW.Milliseconds():
+		Metrics.sentAtIgnoredTotal.WithLabelValues("future").Inc()
+		return 0
+	case sentAt < ingestedAt-MAX_SENT_AT_AGE.Milliseconds():
+		Metrics.sentAtIgnoredTotal.WithLabelValues("too_old").Inc()
+		return 0
+	}
+	return sentAt
+}
+
+// withSentAt carries the (checked) client send time of a ping to its primary's SendPing call
+func withSentAt(ctx context.Context, sentAt int64) context.Context {
+	if sentAt <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, sentAtContextKey{}, sentAt)
+}
+
+// sentAtFor returns the client send time carried by ctx on the clock of a worker, 0 if none
+func (g *GatewayState) sentAtFor(ctx context.Context, address string) int64 {
+	sentAt, _ := ctx.Value(sentAtContextKey{}).(int64)
+	if sentAt == 0 {
+		return 0
+	}
+	return sentAt + g.clockSkewOf(address).Milliseconds()
+}
+
+func (g *GatewayState) setClockSkew(address string, skew time.Duration) {
+	g.skewsMutex.Lock()
+	g.skews[address] = skew
+	g.skewsMutex.Unlock()
+	Metrics.workerClockSkew.WithLabelValues(address).Set(skew.Seconds())
+}
+
+func (g *GatewayState) deleteClockSkew(address string) {
+	g.skewsMutex.Lock()
+	delete(g.skews, address)
+	g.skewsMutex.Unlock()
+}
+
+// clockSkewOf returns the worker clock minus the gateway clock from a worker's last heartbeat (0 if unknown)
+func (g *GatewayState) clockSkewOf(address string) time.Duration {
+	g.skewsMutex.RLock()
+	defer g.skewsMutex.RUnlock()
+	return g.skews[address]
+}
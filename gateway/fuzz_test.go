@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// go test -fuzz FuzzXxx -fuzztime 30s (the seed corpus runs as part of go test)
+
+func FuzzPostPingBody(f *testing.F) {
+	for _, seed := range []string{
+		`{"lat": 42.2317, "lng": -8.7263}`,
+		`{"lat": 90, "lng": 180}`,
+		`{"lat": -90.0000001, "lng": 0}`,
+		`{"lat": 1e308, "lng": -1e308}`,
+		`{"lat": null, "lng": 1}`,
+		`{"lat": "1", "lng": 1}`,
+		`{}`,
+		`[]`,
+		``,
+	} {
+		f.Add(seed)
+	}
+	router := setup_router()
+
+	f.Fuzz(func(t *testing.T, body string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ping", strings.NewReader(body)))
+		// no workers in the fuzz process: a valid ping can at most reach routing
+		if w.Code != http.StatusBadRequest && w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("body %q: unexpected status %d", body, w.Code)
+		}
+	})
+}
+
+func FuzzGetPingAreaQuery(f *testing.F) {
+	f.Add("42.15", "42.30", "-8.80", "-8.65", "5")
+	f.Add("-90", "90", "-180", "180", "1")
+	f.Add("0", "0", "0", "0", "8")
+	f.Add("NaN", "1", "0", "1", "3")
+	f.Add("-Inf", "+Inf", "-180", "180", "2")
+	f.Add("1", "-1", "0", "1", "9")
+	router := setup_router()
+
+	f.Fuzz(func(t *testing.T, minLat, maxLat, minLng, maxLng, precision string) {
+		q := url.Values{"minLat": {minLat}, "maxLat": {maxLat}, "minLng": {minLng}, "maxLng": {maxLng}, "precision": {precision}}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pingArea?"+q.Encode(), nil))
+		if w.Code >= 500 {
+			t.Fatalf("query %v: status %d", q, w.Code)
+		}
+		for _, v := range q {
+			if strings.EqualFold(strings.TrimSpace(v[0]), "nan") && w.Code != http.StatusBadRequest {
+				t.Fatalf("query %v: NaN accepted (status %d)", q, w.Code)
+			}
+		}
+	})
+}
+
+func FuzzGetPingQuery(f *testing.F) {
+	f.Add("42.2317", "-8.7263")
+	f.Add("90", "180")
+	f.Add("NaN", "0")
+	f.Add("1e400", "-Inf")
+	f.Add("-90.5", "0x1p-2")
+	router := setup_router()
+
+	f.Fuzz(func(t *testing.T, lat, lng string) {
+		q := url.Values{"lat": {lat}, "lng": {lng}}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping?"+q.Encode(), nil))
+		if w.Code != http.StatusBadRequest && w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("query %v: unexpected status %d", q, w.Code)
+		}
+	})
+}
+
+func FuzzReadRESPCommand(f *testing.F) {
+	for _, seed := range []string{
+		"*3\r\n$6\r\nGEOADD\r\n$1\r\nk\r\n$0\r\n\r\n",
+		"*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n",
+		"GEOCOUNT k 2.35 48.85 2.36 48.86 PRECISION 5\r\n",
+		"*-1\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\r\n$2\r\nPING\r\n",
+		"*99999999999999999999\r\n",
+		"*1\r\n$4\r\nPI",
+		"\r\n",
+		"",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		args, err := readRESPCommand(bufio.NewReader(strings.NewReader(input)))
+		if err != nil {
+			if !errors.Is(err, errRESPProtocol) && err != io.EOF && err != io.ErrUnexpectedEOF {
+				t.Fatalf("%q: unexpected error %v", input, err)
+			}
+			return
+		}
+		if len(args) > respMaxArgs {
+			t.Fatalf("%q: %d arguments read", input, len(args))
+		}
+		// read back once encoded as a multibulk command
+		var encoded strings.Builder
+		fmt.Fprintf(&encoded, "*%d\r\n", len(args))
+		for _, arg := range args {
+			if len(arg) > respMaxArgBytes {
+				t.Fatalf("%q: %d bytes argument read", input, len(arg))
+			}
+			fmt.Fprintf(&encoded, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+		again, err := readRESPCommand(bufio.NewReader(strings.NewReader(encoded.String())))
+		if err != nil || !slices.Equal(again, args) {
+			t.Fatalf("%q: read %q, then %q (%v) once encoded", input, args, again, err)
+		}
+	})
+}
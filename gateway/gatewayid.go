@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"geostreamdb/env"
+)
+
+// gateway identity: the registry keeps gateways by the gateway id they heartbeat with, a random one per start by
+// default, so a restarted gateway registers as a new one (and workers with WORKER_AUTH only accept its calls once the
+// registry listed the new id). GATEWAY_ID sets the id; with GATEWAY_ID_FILE (on a volume that survives restarts, one
+// file per gateway) the id generated at the first start is saved there and registered again by the next ones. the
+// starts under the saved id are counted in gateway_restarts. same as the workers' WORKER_ID_FILE
+var GATEWAY_ID = strings.TrimSpace(env.String("GATEWAY_ID", ""))
+var GATEWAY_ID_FILE = env.String("GATEWAY_ID_FILE", "")
+
+type savedGatewayId struct {
+	GatewayId string `json:"gatewayId"`
+	Starts    int64  `json:"starts"`
+}
+
+// loadGatewayId returns the id this gateway registers with: GATEWAY_ID, the one saved in GATEWAY_ID_FILE or a new one
+// (saved)
+func loadGatewayId() string {
+	if GATEWAY_ID != "" {
+		return GATEWAY_ID
+	}
+	if GATEWAY_ID_FILE == "" {
+		return ids.NewID()
+	}
+
+	var saved savedGatewayId
+	b, err := os.ReadFile(GATEWAY_ID_FILE)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		log.Fatalf("failed to read GATEWAY_ID_FILE: %v", err)
+	default:
+		if err := json.Unmarshal(b, &saved); err != nil {
+			log.Fatalf("failed to parse GATEWAY_ID_FILE: %v", err)
+		}
+	}
+	if saved.GatewayId == "" {
+		saved = savedGatewayId{GatewayId: ids.NewID()}
+	} else {
+		log.Printf("restarting as gateway id %s (saved in %s)", saved.GatewayId, GATEWAY_ID_FILE)
+	}
+	saved.Starts++
+	if err := saveGatewayId(saved); err != nil {
+		log.Fatalf("failed to write GATEWAY_ID_FILE: %v", err)
+	}
+	Metrics.restarts.Set(float64(saved.Starts - 1))
+	return saved.GatewayId
+}
+
+// saveGatewayId replaces GATEWAY_ID_FILE atomically
+func saveGatewayId(saved savedGatewayId) error {
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp := GATEWAY_ID_FILE + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, GATEWAY_ID_FILE)
+}
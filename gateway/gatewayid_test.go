@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGatewayIdIsKeptAcrossRestarts(t *testing.T) {
+	previousId, previousFile := GATEWAY_ID, GATEWAY_ID_FILE
+	t.Cleanup(func() { GATEWAY_ID, GATEWAY_ID_FILE = previousId, previousFile })
+	GATEWAY_ID, GATEWAY_ID_FILE = "", filepath.Join(t.TempDir(), "gateway_id")
+
+	first := loadGatewayId()
+	if second := loadGatewayId(); second != first || first == "" {
+		t.Fatalf("restarted as %q, first started as %q", second, first)
+	}
+	GATEWAY_ID_FILE = filepath.Join(t.TempDir(), "gateway_id")
+	if other := loadGatewayId(); other == first {
+		t.Fatal("a gateway without a saved id got another's")
+	}
+	GATEWAY_ID = "gateway-2"
+	if got := loadGatewayId(); got != "gateway-2" {
+		t.Fatalf("got %q, want GATEWAY_ID", got)
+	}
+}
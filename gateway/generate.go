@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// synthetic data for benchmarks: POST /admin/generate starts the generator of every worker (see Generate in the
+// worker), each writing rate pings per second in the area for durationSeconds, following the distribution (uniform,
+// hotspots or front). the workers share the seed, picked here if not given, so they draw the same hotspots or front.
+// the pings skip the gateway (no routing, replicas, usage or ingest hooks): a worker counts them where they fall, so use
+// broadcast queries (or a single worker) to see them all, and its anomaly detection sees them like any other.
+// DELETE /admin/generate stops the generators
+type generateRequest struct {
+	Distribution    string  `json:"distribution"`
+	MinLat          float64 `json:"minLat"`
+	MaxLat          float64 `json:"maxLat"`
+	MinLng          float64 `json:"minLng"`
+	MaxLng          float64 `json:"maxLng"`
+	Rate            int64   `json:"rate"` // per worker
+	DurationSeconds int64   `json:"durationSeconds"`
+	Hotspots        int32   `json:"hotspots,omitempty"`
+	PeriodSeconds   int64   `json:"periodSeconds,omitempty"` // of a front's crossing (durationSeconds if 0)
+	Seed            uint64  `json:"seed,omitempty"`
+}
+
+const maxGenerateBody = 1 << 12
+
+type workerGeneration struct {
+	Running      bool   `json:"running"`
+	Distribution string `json:"distribution,omitempty"`
+	Generated    int64  `json:"generated"`
+	EndsAt       int64  `json:"endsAt,omitempty"` // unix ms
+	Error        string `json:"error,omitempty"`
+}
+
+func postGenerate(w http.ResponseWriter, r *http.Request) {
+	var req generateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGenerateBody)).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+	if req.Seed == 0 {
+		req.Seed = rand.Uint64()
+	}
+	broadcastGenerate(w, r, req.Seed, func(apiVersion uint32) *pb.GenerateRequest {
+		return &pb.GenerateRequest{
+			ApiVersion: apiVersion, Distribution: req.Distribution,
+			MinLat: req.MinLat, MaxLat: req.MaxLat, MinLng: req.MinLng, MaxLng: req.MaxLng,
+			Rate: req.Rate, DurationSeconds: req.DurationSeconds, Hotspots: req.Hotspots, PeriodSeconds: req.PeriodSeconds,
+			Seed: req.Seed,
+		}
+	})
+}
+
+func deleteGenerate(w http.ResponseWriter, r *http.Request) {
+	broadcastGenerate(w, r, 0, func(apiVersion uint32) *pb.GenerateRequest {
+		return &pb.GenerateRequest{ApiVersion: apiVersion, Stop: true}
+	})
+}
+
+// broadcastGenerate sends a Generate request to every worker and answers their status. a request every worker rejects
+// as invalid is a 400
+func broadcastGenerate(w http.ResponseWriter, r *http.Request, seed uint64, request func(apiVersion uint32) *pb.GenerateRequest) {
+	workers := make(map[string]*workerGeneration)
+	var mu sync.Mutex
+	invalid := ""
+	err := service.Broadcast(r.Context(), "Generate", func(ctx context.Context, addr string, client pb.WorkerClient) error {
+		v, err := client.Generate(ctx, request(state.apiVersion(addr)))
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			workers[addr] = &workerGeneration{Error: status.Convert(err).Message()}
+			if status.Code(err) == codes.InvalidArgument {
+				invalid = status.Convert(err).Message()
+			}
+			return err
+		}
+		workers[addr] = &workerGeneration{Running: v.Running, Distribution: v.Distribution, Generated: v.Generated, EndsAt: v.EndsAt}
+		return nil
+	})
+	if errors.Is(err, errNoWorkers) {
+		writeError(w, err)
+		return
+	}
+	rejected := invalid != ""
+	for _, g := range workers {
+		rejected = rejected && g.Error != ""
+	}
+	if rejected {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(invalid))
+		return
+	}
+
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusServiceUnavailable
+	}
+	body := map[string]any{"workers": workers}
+	if seed != 0 {
+		body["seed"] = seed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
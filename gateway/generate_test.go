@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type generatingWorker struct {
+	fakeWorker
+	got *pb.GenerateRequest
+}
+
+func (w *generatingWorker) Generate(ctx context.Context, in *pb.GenerateRequest, opts ...grpc.CallOption) (*pb.GenerateResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.got = in
+	if in.Rate < 1 && !in.Stop {
+		return nil, status.Error(codes.InvalidArgument, "rate must be 1 to 1000000 pings per second")
+	}
+	return &pb.GenerateResponse{Running: !in.Stop, Distribution: in.Distribution, Generated: 3}, nil
+}
+
+func withGeneratingWorkers(t *testing.T) (a, b *generatingWorker) {
+	a, b = &generatingWorker{}, &generatingWorker{}
+	previous := service
+	service = newGatewayService(fakeRing{servers: []string{"a", "b"}}, fakeWorkersOf{"a": a, "b": b})
+	t.Cleanup(func() { service = previous })
+	return a, b
+}
+
+type fakeWorkersOf map[string]pb.WorkerClient
+
+func (f fakeWorkersOf) WorkerClient(address string) (pb.WorkerClient, error) {
+	return f[address], nil
+}
+
+func TestPostGenerateSharesTheSeed(t *testing.T) {
+	a, b := withGeneratingWorkers(t)
+	body := `{"distribution":"hotspots","minLat":42.13,"maxLat":42.33,"minLng":-8.82,"maxLng":-8.62,"rate":100,"durationSeconds":60}`
+	w := httptest.NewRecorder()
+	postGenerate(w, httptest.NewRequest("POST", "/admin/generate", strings.NewReader(body)))
+	if w.Code != 200 {
+		t.Fatalf("got %d: %s", w.Code, w.Body)
+	}
+	if a.got.Seed == 0 || a.got.Seed != b.got.Seed || a.got.Distribution != "hotspots" || b.got.Rate != 100 {
+		t.Errorf("workers got %v and %v", a.got, b.got)
+	}
+	var resp struct {
+		Seed    uint64                       `json:"seed"`
+		Workers map[string]*workerGeneration `json:"workers"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Seed != a.got.Seed || len(resp.Workers) != 2 || !resp.Workers["a"].Running {
+		t.Errorf("got %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	deleteGenerate(w, httptest.NewRequest("DELETE", "/admin/generate", nil))
+	if w.Code != 200 || !a.got.Stop || !b.got.Stop {
+		t.Errorf("stop: got %d, workers got %v and %v", w.Code, a.got, b.got)
+	}
+}
+
+func TestPostGenerateRejectedByEveryWorker(t *testing.T) {
+	withGeneratingWorkers(t)
+	w := httptest.NewRecorder()
+	postGenerate(w, httptest.NewRequest("POST", "/admin/generate", strings.NewReader(`{"distribution":"uniform","rate":0}`)))
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "rate") {
+		t.Errorf("got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// reverse geocoding: with REVERSE_GEOCODER set, every ping written goes through an enrichment stage that tags the cell
+// of GEOCODE_PRECISION it was sent from with its place (name, locality, region, country), as resolved by a pluggable
+// ReverseGeocoder ("nominatim": the OpenStreetMap Nominatim API at NOMINATIM_URL). lookups happen off the ingest path:
+// cells not known yet are queued (GEOCODE_QUEUE, dropped beyond) and resolved by one background loop at GEOCODE_RATE
+// per second (the public Nominatim allows 1), and the places are kept in an LRU of GEOCODE_CACHE_SIZE cells. workers
+// only store counts, so the places are the gateway's: GET /pingArea?places=true adds the place of every cell at
+// GEOCODE_PRECISION or finer, and place=<name> only keeps the cells whose locality, region, country or country code is
+// that name (case-insensitive). cells whose place isn't known yet are queued too, and left out of place= filters
+var REVERSE_GEOCODER = env.String("REVERSE_GEOCODER", "") // "" = disabled
+var NOMINATIM_URL = env.String("NOMINATIM_URL", "https://nominatim.openstreetmap.org")
+var GEOCODE_PRECISION = env.Int("GEOCODE_PRECISION", 5) // cells of about 4.9 x 4.9 km
+var GEOCODE_CACHE_SIZE = env.Int("GEOCODE_CACHE_SIZE", 65536)
+var GEOCODE_QUEUE = env.Int("GEOCODE_QUEUE", 1024)
+var GEOCODE_RATE = env.Float("GEOCODE_RATE", 1) // lookups per second
+
+const geocodeTimeout = 5 * time.Second
+
+type Place struct {
+	Name        string `json:"name"`
+	Locality    string `json:"locality,omitempty"`    // city, town or village
+	Region      string `json:"region,omitempty"`      // state, province...
+	Country     string `json:"country,omitempty"`     //
+	CountryCode string `json:"countryCode,omitempty"` // ISO 3166-1 alpha-2, upper case
+}
+
+// matches reports whether the place is called name (locality, region, country or country code, case-insensitive)
+func (p *Place) matches(name string) bool {
+	for _, n := range []string{p.Locality, p.Region, p.Country, p.CountryCode} {
+		if n != "" && strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type ReverseGeocoder interface {
+	// ReverseGeocode returns the place at a location, nil if there is none (e.g. at sea)
+	ReverseGeocode(ctx context.Context, lat, lng float64) (*Place, error)
+	Name() string
+}
+
+// newReverseGeocoder returns a reverse geocoder by name
+func newReverseGeocoder(name string) (ReverseGeocoder, error) {
+	switch name {
+	case "nominatim":
+		return &nominatimGeocoder{url: strings.TrimSuffix(NOMINATIM_URL, "/"), client: &http.Client{Timeout: geocodeTimeout}}, nil
+	}
+	return nil, fmt.Errorf("unknown reverse geocoder %q (nominatim)", name)
+}
+
+var geocoder ReverseGeocoder // nil: disabled
+var geocodeQueue chan string // cells to resolve
+var places = newPlaceCache(GEOCODE_CACHE_SIZE)
+
+// startGeocoding starts the loop resolving the queued cells, if REVERSE_GEOCODER is set
+func startGeocoding() {
+	if REVERSE_GEOCODER == "" {
+		return
+	}
+	g, err := newReverseGeocoder(REVERSE_GEOCODER)
+	if err != nil {
+		log.Fatalf("invalid REVERSE_GEOCODER: %v", err)
+	}
+	if GEOCODE_RATE <= 0 || GEOCODE_PRECISION < 1 || GEOCODE_PRECISION > MAX_GH_PRECISION {
+		log.Fatalf("invalid GEOCODE_RATE (> 0) or GEOCODE_PRECISION (1 to %d)", MAX_GH_PRECISION)
+	}
+	geocoder = g
+	geocodeQueue = make(chan string, max(1, GEOCODE_QUEUE))
+	log.Printf("reverse geocoding cells of precision %d with %s, %g lookups per second", GEOCODE_PRECISION, g.Name(), GEOCODE_RATE)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / GEOCODE_RATE))
+		defer ticker.Stop()
+		for cell := range geocodeQueue {
+			resolvePlace(cell)
+			<-ticker.C
+		}
+	}()
+}
+
+// enrichPing queues the cell of a ping (any precision from GEOCODE_PRECISION) for a lookup, unless its place is known
+func enrichPing(gh string) {
+	if geocodeQueue == nil || len(gh) < GEOCODE_PRECISION {
+		return
+	}
+	cell := gh[:GEOCODE_PRECISION]
+	if !places.reserve(cell) {
+		Metrics.geocodeLookups.WithLabelValues("cached").Inc()
+		return
+	}
+	select {
+	case geocodeQueue <- cell:
+		Metrics.geocodeLookups.WithLabelValues("queued").Inc()
+	default:
+		places.release(cell)
+		Metrics.geocodeLookups.WithLabelValues("dropped").Inc()
+	}
+}
+
+func resolvePlace(cell string) {
+	box, _ := geo.Decode(cell)
+	lat, lng := box.Center()
+	ctx, cancel := context.WithTimeout(context.Background(), geocodeTimeout)
+	defer cancel()
+
+	p, err := geocoder.ReverseGeocode(ctx, lat, lng)
+	if err != nil {
+		places.release(cell) // retried with the next ping there
+		Metrics.geocodeRequests.WithLabelValues("failed").Inc()
+		log.Printf("reverse geocoding %s failed: %v", cell, err)
+		return
+	}
+	places.put(cell, p)
+	if p == nil {
+		Metrics.geocodeRequests.WithLabelValues("no_place").Inc()
+		return
+	}
+	Metrics.geocodeRequests.WithLabelValues("place").Inc()
+}
+
+// withPlaces tags the cells of area counts with their place and, with a filter, drops the cells of other places
+func withPlaces(counts map[string]*ExtendedPingAreaCount, filter string) map[string]*ExtendedPingAreaCount {
+	for gh, c := range counts {
+		if len(gh) >= GEOCODE_PRECISION {
+			p, ok := places.get(gh[:GEOCODE_PRECISION])
+			if !ok {
+				enrichPing(gh)
+			}
+			c.Place = p
+		}
+		if filter != "" && (c.Place == nil || !c.Place.matches(filter)) {
+			delete(counts, gh)
+		}
+	}
+	return counts
+}
+
+// LRU of cell -> place (nil: resolved to no place), with the cells queued for a lookup
+type placeCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // front = most recently used
+	items    map[string]*list.Element
+	pending  map[string]bool
+}
+
+type placeEntry struct {
+	cell  string
+	place *Place
+}
+
+func newPlaceCache(capacity int) *placeCache {
+	return &placeCache{capacity: capacity, ll: list.New(), items: make(map[string]*list.Element), pending: make(map[string]bool)}
+}
+
+// reserve marks a cell as queued, false if its place is known or it is already queued
+func (c *placeCache) reserve(cell string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[cell]; ok {
+		c.ll.MoveToFront(el)
+		return false
+	}
+	if c.pending[cell] {
+		return false
+	}
+	c.pending[cell] = true
+	return true
+}
+
+func (c *placeCache) release(cell string) {
+	c.mu.Lock()
+	delete(c.pending, cell)
+	c.mu.Unlock()
+}
+
+func (c *placeCache) put(cell string, p *Place) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, cell)
+	if c.capacity <= 0 {
+		return
+	}
+	if el, ok := c.items[cell]; ok {
+		el.Value.(*placeEntry).place = p
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[cell] = c.ll.PushFront(&placeEntry{cell: cell, place: p})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*placeEntry).cell)
+	}
+}
+
+// get returns the place of a cell, false if not resolved yet
+func (c *placeCache) get(cell string) (*Place, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[cell]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*placeEntry).place, true
+}
+
+// nominatimGeocoder calls the reverse endpoint of a Nominatim server, at city zoom
+type nominatimGeocoder struct {
+	url    string
+	client *http.Client
+}
+
+func (n *nominatimGeocoder) Name() string { return "nominatim" }
+
+func (n *nominatimGeocoder) ReverseGeocode(ctx context.Context, lat, lng float64) (*Place, error) {
+	params := url.Values{
+		"format":          {"jsonv2"},
+		"lat":             {strconv.FormatFloat(lat, 'f', 6, 64)},
+		"lon":             {strconv.FormatFloat(lng, 'f', 6, 64)},
+		"zoom":            {"10"},
+		"accept-language": {"en"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.url+"/reverse?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "geostreamdb-gateway/"+build.Version) // required by the Nominatim usage policy
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+
+	var body struct {
+		Error       string            `json:"error"` // "Unable to geocode": nothing there
+		Name        string            `json:"name"`
+		DisplayName string            `json:"display_name"`
+		Address     map[string]string `json:"address"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Error != "" {
+		return nil, nil
+	}
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := body.Address[k]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	p := &Place{
+		Name:        body.Name,
+		Locality:    first("city", "town", "village", "municipality", "hamlet"),
+		Region:      first("state", "province", "region", "state_district"),
+		Country:     body.Address["country"],
+		CountryCode: strings.ToUpper(body.Address["country_code"]),
+	}
+	if p.Name == "" {
+		p.Name = body.DisplayName
+	}
+	return p, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+func TestNominatimGeocoderReadsTheAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/reverse" || r.URL.Query().Get("format") != "jsonv2" || r.Header.Get("User-Agent") == "" {
+			t.Errorf("unexpected request %s (User-Agent %q)", r.URL, r.Header.Get("User-Agent"))
+		}
+		if r.URL.Query().Get("lat") == "0.000000" {
+			w.Write([]byte(`{"error":"Unable to geocode"}`))
+			return
+		}
+		w.Write([]byte(`{"name":"Paris","display_name":"Paris, Ile-de-France, France","address":{"city":"Paris","state":"Ile-de-France","country":"France","country_code":"fr"}}`))
+	}))
+	defer server.Close()
+	g := &nominatimGeocoder{url: server.URL, client: server.Client()}
+
+	p, err := g.ReverseGeocode(context.Background(), 48.85, 2.35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Place{Name: "Paris", Locality: "Paris", Region: "Ile-de-France", Country: "France", CountryCode: "FR"}
+	if p == nil || *p != want {
+		t.Fatalf("got %+v, want %+v", p, want)
+	}
+	if p, err := g.ReverseGeocode(context.Background(), 0, 0); p != nil || err != nil {
+		t.Fatalf("at sea: got %+v (%v), want no place", p, err)
+	}
+}
+
+type fakeGeocoder struct{ calls int }
+
+func (f *fakeGeocoder) Name() string { return "fake" }
+
+func (f *fakeGeocoder) ReverseGeocode(ctx context.Context, lat, lng float64) (*Place, error) {
+	f.calls++
+	if lng > 0 {
+		return &Place{Name: "Paris", Locality: "Paris", Country: "France", CountryCode: "FR"}, nil
+	}
+	return nil, nil
+}
+
+func TestEnrichmentTagsAndFiltersAreaCells(t *testing.T) {
+	fake := &fakeGeocoder{}
+	previousGeocoder, previousQueue, previousPlaces := geocoder, geocodeQueue, places
+	geocoder, geocodeQueue, places = fake, make(chan string, 4), newPlaceCache(8)
+	t.Cleanup(func() { geocoder, geocodeQueue, places = previousGeocoder, previousQueue, previousPlaces })
+
+	paris, sea := geo.Encode(48.85, 2.35, 7), geo.Encode(30, -40, 7)
+	enrichPing(paris)
+	enrichPing(paris) // already queued
+	enrichPing(sea)
+	enrichPing(paris[:GEOCODE_PRECISION-1]) // too coarse to have a place
+	if len(geocodeQueue) != 2 {
+		t.Fatalf("%d cells queued, want 2", len(geocodeQueue))
+	}
+	for len(geocodeQueue) > 0 {
+		resolvePlace(<-geocodeQueue)
+	}
+	enrichPing(paris) // resolved
+	if len(geocodeQueue) != 0 || fake.calls != 2 {
+		t.Fatalf("%d cells queued and %d lookups after resolving, want 0 and 2", len(geocodeQueue), fake.calls)
+	}
+
+	counts := func() map[string]*ExtendedPingAreaCount {
+		return map[string]*ExtendedPingAreaCount{paris[:6]: {Count: 3}, sea[:6]: {Count: 1}, paris[:4]: {Count: 3}}
+	}
+	tagged := withPlaces(counts(), "")
+	if p := tagged[paris[:6]].Place; p == nil || p.Locality != "Paris" {
+		t.Errorf("Paris tagged %+v", p)
+	}
+	if tagged[sea[:6]].Place != nil || tagged[paris[:4]].Place != nil {
+		t.Errorf("the sea or a coarse cell got a place")
+	}
+	filtered := withPlaces(counts(), "fr")
+	if len(filtered) != 1 || filtered[paris[:6]] == nil {
+		t.Errorf("place=fr kept %v, want the Paris cell", filtered)
+	}
+}
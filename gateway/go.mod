@@ -5,30 +5,42 @@ go 1.25.4
 require github.com/go-chi/chi/v5 v5.2.3
 
 require (
+	geostreamdb/env v0.0.0
+	geostreamdb/geo v0.0.0
+	geostreamdb/ingesthook v0.0.0
 	geostreamdb/proto v0.0.0
+	geostreamdb/rpc v0.0.0
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/felixge/httpsnoop v1.0.4
+	github.com/golang/snappy v1.0.0
 	github.com/google/uuid v1.6.0
-	github.com/mmcloughlin/geohash v0.10.0
 	github.com/prometheus/client_golang v1.23.2
 	github.com/zeebo/xxh3 v1.0.2
+	go.etcd.io/bbolt v1.5.0
 	google.golang.org/grpc v1.77.0
+	google.golang.org/protobuf v1.36.10
 )
 
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
-	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/kr/text v0.2.0 // indirect
+	github.com/mmcloughlin/geohash v0.10.0 // indirect
 	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	github.com/prometheus/client_model v0.6.2 // indirect
 	github.com/prometheus/common v0.66.1 // indirect
 	github.com/prometheus/procfs v0.16.1 // indirect
 	go.yaml.in/yaml/v2 v2.4.2 // indirect
 	golang.org/x/net v0.47.0 // indirect
-	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/sys v0.45.0 // indirect
 	golang.org/x/text v0.31.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251022142026-3a174f9686a8 // indirect
-	google.golang.org/protobuf v1.36.10 // indirect
 )
 
-replace geostreamdb/proto => ../proto
+replace (
+	geostreamdb/env => ../env
+	geostreamdb/geo => ../geo
+	geostreamdb/ingesthook => ../ingesthook
+	geostreamdb/proto => ../proto
+	geostreamdb/rpc => ../rpc
+)
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"geostreamdb/geo"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Grafana JSON datasource (the SimpleJSON contract, e.g. the simpod-json-datasource plugin) under /grafana, with the
+// query routes (QUERY_PORT/QUERY_TOKEN; the tenant from an X-API-Key custom header). targets are written like the
+// HTTP API's query strings:
+//
+//	area?minLat=..&maxLat=..&minLng=..&maxLng=..&precision=..           cells: geohash, latitude, longitude, count
+//	hotspots?minLat=..&maxLat=..&minLng=..&maxLng=..&precision=..&limit=N  the N (10) busiest cells, same columns
+//	total?minLat=..&maxLat=..&minLng=..&maxLng=..&precision=..          the area's total count
+//
+// as table panels (area/hotspots feed a Geomap panel directly) or time series (the total for area/total, a series per
+// cell for hotspots). only the live TTL window is held, so a time series is a single point at the end of the dashboard
+// range (or now, if earlier): panels build history by refreshing. every target is accounted, checked against the ACLs
+// and suppressed like GET /pingArea
+const grafanaDefaultHotspots = 10
+
+var grafanaTargets = []string{"area", "hotspots", "total"}
+
+type grafanaQueryRequest struct {
+	Range struct {
+		To time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Type   string `json:"type"` // timeserie (default) or table
+	} `json:"targets"`
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"` // "table"
+	RefID   string          `json:"refId,omitempty"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	RefID      string       `json:"refId,omitempty"`
+	Datapoints [][2]float64 `json:"datapoints"` // [value, unix ms]
+}
+
+type grafanaCell struct {
+	geohash string
+	count   int64
+}
+
+func grafanaRoutes(r chi.Router) {
+	r.Route("/grafana", func(g chi.Router) {
+		g.Get("/", grafanaHealth)
+		g.Post("/search", grafanaSearch)
+		g.Post("/query", grafanaQuery)
+		g.Post("/annotations", grafanaAnnotations)
+	})
+}
+
+// grafanaHealth answers the datasource's "Save & test"
+func grafanaHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// grafanaSearch lists the target kinds (with an example query string) for the query editor
+func grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	out := make([]string, 0, len(grafanaTargets))
+	for _, kind := range grafanaTargets {
+		out = append(out, kind+"?minLat=-90&maxLat=90&minLng=-180&maxLng=180&precision=2")
+	}
+	writeGrafanaJSON(w, out)
+}
+
+func grafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("[]\n"))
+}
+
+func grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req grafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+	at := time.Now()
+	if !req.Range.To.IsZero() && req.Range.To.Before(at) {
+		at = req.Range.To
+	}
+
+	t := tenantFor(r)
+	endpoint := r.Method + " " + chi.RouteContext(r.Context()).RoutePattern()
+	out := make([]any, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		kind, rawQuery, _ := strings.Cut(target.Target, "?")
+		params, err := url.ParseQuery(rawQuery)
+		if err != nil || (kind != "area" && kind != "hotspots" && kind != "total") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid target " + strconv.Quote(target.Target) + " (area, hotspots or total with /pingArea parameters)"))
+			return
+		}
+		limit := grafanaDefaultHotspots
+		if limitQ := params.Get("limit"); kind == "hotspots" && limitQ != "" {
+			if limit, err = strconv.Atoi(limitQ); err != nil || limit < 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Invalid limit in target " + strconv.Quote(target.Target)))
+				return
+			}
+		}
+		q, status, msg := parsePingAreaQuery(params)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			w.Write([]byte(msg + " in target " + strconv.Quote(target.Target)))
+			return
+		}
+		if !aclFor(t).allowsArea(q.bbox(), q.precision) {
+			denyACL(w, t)
+			return
+		}
+		if !reserveUsage(t, endpoint, unitCells, q.estimated) {
+			w.WriteHeader(QUOTA_EXCEEDED_STATUS)
+			w.Write([]byte("Monthly " + string(unitCells) + " quota exceeded"))
+			return
+		}
+
+		cells := make([]grafanaCell, 0)
+		total := int64(0)
+		for gh, c := range privacyFor(t).suppress(t, queryPingArea(r.Context(), retentionFor(t).limit(q))) {
+			if c.Count > 0 {
+				cells = append(cells, grafanaCell{geohash: gh, count: c.Count})
+				total += c.Count
+			}
+		}
+		sort.Slice(cells, func(i, j int) bool {
+			if cells[i].count != cells[j].count {
+				return cells[i].count > cells[j].count
+			}
+			return cells[i].geohash < cells[j].geohash
+		})
+		if kind == "hotspots" && len(cells) > limit {
+			cells = cells[:limit]
+		}
+
+		if target.Type != "table" {
+			if kind != "hotspots" {
+				out = append(out, grafanaSeries{Target: target.Target, RefID: target.RefID, Datapoints: [][2]float64{{float64(total), float64(at.UnixMilli())}}})
+				continue
+			}
+			for _, c := range cells { // a series per busiest cell
+				out = append(out, grafanaSeries{Target: c.geohash, RefID: target.RefID, Datapoints: [][2]float64{{float64(c.count), float64(at.UnixMilli())}}})
+			}
+			continue
+		}
+		if kind == "total" {
+			out = append(out, grafanaTable{Type: "table", RefID: target.RefID, Columns: []grafanaColumn{{"count", "number"}}, Rows: [][]any{{total}}})
+			continue
+		}
+		table := grafanaTable{Type: "table", RefID: target.RefID, Rows: make([][]any, 0, len(cells)), Columns: []grafanaColumn{
+			{"geohash", "string"}, {"latitude", "number"}, {"longitude", "number"}, {"count", "number"},
+		}}
+		for _, c := range cells {
+			cell, _ := geo.Decode(c.geohash)
+			lat, lng := cell.Center()
+			table.Rows = append(table.Rows, []any{c.geohash, lat, lng, c.count})
+		}
+		out = append(out, table)
+	}
+
+	writeGrafanaJSON(w, out)
+}
+
+// writeGrafanaJSON encodes without HTML escaping, so targets keep their '&'
+func writeGrafanaJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
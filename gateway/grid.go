@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// GET /pingArea?...&grid=NxM: the counts re-binned into a uniform grid of N latitude by M longitude bins over the
+// query's bounding box, for consumers that want lat/lng bins rather than geohash cells. the query runs at its
+// precision as usual, then each cell's count is split among the bins it overlaps, by the share of the cell's area
+// (in degrees) in each, the share outside the bounding box being left out. bins hold fractional counts, rows from
+// south to north and columns from west to east. a finer precision gives more accurate bins for the same cost in
+// cells. k-anonymity applies to the cells before they are split. not combined with compare, metrics, places or csv
+var MAX_GRID_BINS = env.Int("MAX_GRID_BINS", 10000)
+
+type areaGrid struct {
+	Rows    int     `json:"rows"`
+	Cols    int     `json:"cols"`
+	MinLat  float64 `json:"minLat"`
+	MaxLat  float64 `json:"maxLat"`
+	MinLng  float64 `json:"minLng"`
+	MaxLng  float64 `json:"maxLng"`
+	LatStep float64 `json:"latStep"` // degrees per row
+	LngStep float64 `json:"lngStep"` // degrees per column
+}
+
+// parseGrid returns the grid of the grid parameter over the query's bounding box, nil if absent
+func parseGrid(query url.Values, b geo.Bbox) (*areaGrid, int, string) {
+	v := query.Get("grid")
+	if v == "" {
+		return nil, http.StatusOK, ""
+	}
+	rowsQ, colsQ, found := strings.Cut(strings.ToLower(v), "x")
+	rows, rowsErr := strconv.Atoi(rowsQ)
+	cols, colsErr := strconv.Atoi(colsQ)
+	if !found || rowsErr != nil || colsErr != nil || rows < 1 || cols < 1 || rows*cols > MAX_GRID_BINS {
+		return nil, http.StatusBadRequest, fmt.Sprintf("Invalid grid (NxM, %d bins at most)", MAX_GRID_BINS)
+	}
+	if b.MaxLat <= b.MinLat || b.MaxLng <= b.MinLng {
+		return nil, http.StatusBadRequest, "grid needs a bounding box with an area"
+	}
+	if query.Get("compare") != "" || query.Get("metrics") != "" || query.Get("place") != "" || query.Get("places") == "true" || query.Get("format") == formatCSV {
+		return nil, http.StatusBadRequest, "grid is not combined with compare, metrics, places or csv"
+	}
+	return &areaGrid{
+		Rows: rows, Cols: cols,
+		MinLat: b.MinLat, MaxLat: b.MaxLat, MinLng: b.MinLng, MaxLng: b.MaxLng,
+		LatStep: (b.MaxLat - b.MinLat) / float64(rows),
+		LngStep: (b.MaxLng - b.MinLng) / float64(cols),
+	}, http.StatusOK, ""
+}
+
+// bin splits the cell counts among the grid's bins by overlapping area, [row][col]
+func (g *areaGrid) bin(counts map[string]*ExtendedPingAreaCount) [][]float64 {
+	out := make([][]float64, g.Rows)
+	for i := range out {
+		out[i] = make([]float64, g.Cols)
+	}
+	for gh, c := range counts {
+		cell, ok := geo.Decode(gh)
+		if !ok || c.Count == 0 {
+			continue
+		}
+		area := (cell.MaxLat - cell.MinLat) * (cell.MaxLng - cell.MinLng)
+		firstRow, lastRow := gridSpan(cell.MinLat, cell.MaxLat, g.MinLat, g.LatStep, g.Rows)
+		firstCol, lastCol := gridSpan(cell.MinLng, cell.MaxLng, g.MinLng, g.LngStep, g.Cols)
+		for row := firstRow; row <= lastRow; row++ {
+			binMinLat := g.MinLat + float64(row)*g.LatStep
+			latOverlap := min(cell.MaxLat, binMinLat+g.LatStep) - max(cell.MinLat, binMinLat)
+			if latOverlap <= 0 {
+				continue
+			}
+			for col := firstCol; col <= lastCol; col++ {
+				binMinLng := g.MinLng + float64(col)*g.LngStep
+				lngOverlap := min(cell.MaxLng, binMinLng+g.LngStep) - max(cell.MinLng, binMinLng)
+				if lngOverlap > 0 {
+					out[row][col] += float64(c.Count) * latOverlap * lngOverlap / area
+				}
+			}
+		}
+	}
+	for _, row := range out {
+		for i, v := range row {
+			row[i] = math.Round(v*100) / 100
+		}
+	}
+	return out
+}
+
+// gridSpan returns the first and last bins (of n of size step from origin) overlapping [lo, hi], clamped to the grid
+func gridSpan(lo, hi, origin, step float64, n int) (int, int) {
+	first := int(math.Floor((lo - origin) / step))
+	last := int(math.Floor((hi - origin) / step))
+	return max(first, 0), min(last, n-1)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+func TestGridSplitsCellsByArea(t *testing.T) {
+	cell, _ := geo.Decode("ezjm")
+	g, status, msg := parseGrid(url.Values{"grid": {"2x2"}}, cell)
+	if g == nil {
+		t.Fatalf("got %d %s", status, msg)
+	}
+	bins := g.bin(map[string]*ExtendedPingAreaCount{"ezjm": {Count: 100}})
+	for row := range bins {
+		for col, v := range bins[row] {
+			if v != 25 {
+				t.Errorf("bin %d,%d: got %v, want 25", row, col, v)
+			}
+		}
+	}
+
+	// the western half of the cell, cut in three columns: the eastern half's share is left out
+	west := cell
+	west.MaxLng = (cell.MinLng + cell.MaxLng) / 2
+	g, _, _ = parseGrid(url.Values{"grid": {"1x3"}}, west)
+	bins = g.bin(map[string]*ExtendedPingAreaCount{"ezjm": {Count: 90}, "ezjq": {Count: 1000}})
+	for col, v := range bins[0] {
+		if v != 15 {
+			t.Errorf("column %d: got %v, want 15", col, v)
+		}
+	}
+}
+
+func TestGridRowsRunSouthToNorth(t *testing.T) {
+	b := geo.Bbox{MinLat: 0, MaxLat: 10, MinLng: 0, MaxLng: 10}
+	g, _, _ := parseGrid(url.Values{"grid": {"2x1"}}, b)
+	north := geo.Encode(9, 5, 3)
+	bins := g.bin(map[string]*ExtendedPingAreaCount{north: {Count: 4}})
+	if bins[0][0] != 0 || bins[1][0] != 4 {
+		t.Errorf("got %v, want the count in the northern row", bins)
+	}
+}
+
+func TestParseGridValidates(t *testing.T) {
+	b := geo.Bbox{MinLat: 42, MaxLat: 43, MinLng: -9, MaxLng: -8}
+	for _, v := range []url.Values{
+		{"grid": {"10"}}, {"grid": {"0x4"}}, {"grid": {"ax4"}}, {"grid": {"1000x1000"}},
+		{"grid": {"4x4"}, "compare": {"1d"}}, {"grid": {"4x4"}, "format": {"csv"}},
+	} {
+		if g, status, _ := parseGrid(v, b); g != nil || status != 400 {
+			t.Errorf("%v: got %d", v, status)
+		}
+	}
+	if _, status, _ := parseGrid(url.Values{"grid": {"4x4"}}, geo.Bbox{MinLat: 42, MaxLat: 42, MinLng: -9, MaxLng: -8}); status != 400 {
+		t.Errorf("flat bounding box: got %d", status)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// blue/green handoff: GET /admin/state dumps the runtime state of a gateway, and POST /admin/state loads such a dump
+// into its replacement, so that it routes from its first request instead of waiting for worker heartbeats (forwarded by
+// the registry once it has registered) and serves the objects created through the admin API:
+//   - ring: every worker with its negotiated api version, build and draining flag. imported workers are dropped like
+//     any other if their heartbeats don't follow within the dead node timeout
+//   - zones (/admin/zones) and retention policies (/admin/retention): replace the importer's, and are persisted to its
+//     STORE_FILE or ZONES_FILE / RETENTION_FILE
+//   - usage: the current month's usage per tenant, added to the importer's so quotas carry on
+//
+// open streams and CoAP observations belong to their connections and are not part of it: clients subscribe again
+const stateFormatVersion = 1
+
+const maxStateBody = 64 << 20 // bytes
+
+type gatewayStateDump struct {
+	Version    int                                `json:"version"`
+	ExportedAt int64                              `json:"exportedAt"` // unix ms
+	Ring       []ringWorkerDump                   `json:"ring"`
+	Zones      map[string]map[string][][2]float64 `json:"zones"`
+	Retention  map[string]retentionJSON           `json:"retention"`
+	Usage      usageSnapshot                      `json:"usage"`
+}
+
+type ringWorkerDump struct {
+	WorkerId   string   `json:"workerId"`
+	Address    string   `json:"address"`
+	ApiVersion uint32   `json:"apiVersion"`
+	Build      string   `json:"build,omitempty"`
+	Draining   bool     `json:"draining,omitempty"`
+	Features   []string `json:"features,omitempty"`
+}
+
+// ringWorkers lists the workers of the ring
+func (g *GatewayState) ringWorkers() []ringWorkerDump {
+	g.ringMutex.RLock()
+	out := make([]ringWorkerDump, 0, len(g.lastSeen))
+	for workerId := range g.lastSeen {
+		if address := g.serverOfLocked(workerId); address != "" {
+			out = append(out, ringWorkerDump{WorkerId: workerId, Address: address, Draining: g.draining[address]})
+		}
+	}
+	g.ringMutex.RUnlock()
+
+	for i := range out {
+		out[i].ApiVersion = g.apiVersion(out[i].Address)
+		out[i].Build = g.buildVersionOf(out[i].Address)
+		out[i].Features = g.featuresOf(out[i].Address)
+	}
+	return out
+}
+
+func getGatewayState(w http.ResponseWriter, r *http.Request) {
+	dump := gatewayStateDump{
+		Version:    stateFormatVersion,
+		ExportedAt: time.Now().UnixMilli(),
+		Ring:       state.ringWorkers(),
+		Zones:      make(map[string]map[string][][2]float64),
+		Usage:      usage.snapshot(),
+	}
+	zones.mu.RLock()
+	for name, set := range zones.sets {
+		dump.Zones[name] = set.raw()
+	}
+	zones.mu.RUnlock()
+	retention.RLock()
+	dump.Retention = retentionSnapshotLocked()
+	retention.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dump)
+}
+
+func postGatewayState(w http.ResponseWriter, r *http.Request) {
+	var dump gatewayStateDump
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStateBody)).Decode(&dump); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+	if dump.Version != stateFormatVersion {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Unsupported state version"))
+		return
+	}
+
+	// everything is validated before anything is applied
+	sets := make(map[string]*zoneSet, len(dump.Zones))
+	for name, raw := range dump.Zones {
+		set, msg := newZoneSet(raw)
+		if set == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Zone set " + name + ": " + msg))
+			return
+		}
+		sets[name] = set
+	}
+	policies := make(map[string]*retentionPolicy, len(dump.Retention))
+	for name, v := range dump.Retention {
+		p, err := newRetentionPolicy(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Retention policy " + name + ": " + err.Error()))
+			return
+		}
+		policies[name] = p
+	}
+
+	workers := 0
+	for _, wd := range dump.Ring {
+		if wd.WorkerId == "" || wd.Address == "" || !pb.SupportsAPIVersion(wd.ApiVersion) {
+			continue // left to its heartbeats
+		}
+		state.setAPIVersion(wd.Address, wd.ApiVersion)
+		state.setBuildVersion(wd.Address, wd.Build)
+		state.setFeatures(wd.Address, wd.Features)
+		state.setDraining(wd.Address, wd.Draining)
+		state.addNode(wd.WorkerId, wd.Address)
+		workers++
+	}
+
+	zones.mu.Lock()
+	zones.sets = sets
+	zonesErr := saveZonesLocked()
+	zones.mu.Unlock()
+	retention.Lock()
+	retention.policies = policies
+	retentionErr := saveRetentionLocked()
+	retention.Unlock()
+	usage.merge(dump.Usage)
+
+	if zonesErr != nil || retentionErr != nil {
+		log.Printf("imported state not persisted: zones %v, retention %v", zonesErr, retentionErr)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("State imported but not persisted"))
+		return
+	}
+	log.Printf("imported gateway state: %d workers, %d zone sets, %d retention policies, usage of %d tenants", workers, len(sets), len(policies), len(dump.Usage.Tenants))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,25 +3,27 @@ package main
 import (
 	"context"
 	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
 	"log"
 	"os"
 	"time"
 
-	"github.com/google/uuid"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 )
 
 func new_grpc_client(registryAddress string) (*grpc.ClientConn, pb.RegistryClient) {
-	conn, err := grpc.NewClient(registryAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(registryAddress, rpc.DialOptions()...)
 	if err != nil {
 		log.Fatalf("failed to dial: %v", err)
 	}
 	return conn, pb.NewRegistryClient(conn)
 }
 
+// the id this gateway registers with, also sent to the workers (WORKER_AUTH), see gatewayid.go
+var gatewayId = loadGatewayId()
+
 func send_heartbeat(client pb.RegistryClient, registryAddress string) {
-	gatewayId := uuid.New().String()
 	// use pod IP if available (Kubernetes), otherwise use hostname (Docker Compose)
 	address := os.Getenv("GATEWAY_ADDRESS")
 	if address == "" {
@@ -37,16 +39,27 @@ func send_heartbeat(client pb.RegistryClient, registryAddress string) {
 	ticker := time.NewTicker(3 * time.Second)
 	defer ticker.Stop()
 
+	epoch, seq := time.Now().UnixMilli(), uint64(0)
 	for ; ; <-ticker.C {
+		seq++
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		start := time.Now()
-		_, err := client.Heartbeat(ctx, &pb.RegistryHeartbeatRequest{GatewayId: gatewayId, Address: fullAddress})
+		resp, err := client.Heartbeat(ctx, &pb.RegistryHeartbeatRequest{GatewayId: gatewayId, Address: fullAddress, ApiVersion: pb.API_VERSION, MinApiVersion: pb.MIN_API_VERSION, BuildVersion: build.Version, SettingsVersion: settingsVersion, Seq: seq, SeqEpoch: epoch})
 		cancel()
 		observeGRPC("Registry.Heartbeat", registryAddress, err, start)
 
 		if err != nil {
 			log.Printf("failed to send heartbeat to registry: %v", err)
+		} else if _, ok := pb.NegotiateAPIVersion(resp.MinApiVersion, resp.ApiVersion); !ok {
+			log.Printf("registry speaks api versions %d to %d, this gateway %d to %d: upgrade one of them", resp.MinApiVersion, resp.ApiVersion, pb.MIN_API_VERSION, pb.API_VERSION)
+		} else {
+			checkClusterSettings(resp.Settings)
 		}
 		// log.Printf("heartbeat sent to registry: %s (gateway id: %s)", fullAddress, gatewayId)
 	}
 }
+
+// gatewayIdUnaryInterceptor identifies the gateway to the workers as x-gateway-id metadata
+func gatewayIdUnaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(metadata.AppendToOutgoingContext(ctx, "x-gateway-id", gatewayId), method, req, reply, cc, opts...)
+}
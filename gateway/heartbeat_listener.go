@@ -8,8 +8,11 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
 )
 
 type grpcServer struct {
@@ -17,15 +20,26 @@ type grpcServer struct {
 }
 
 func (s *grpcServer) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
-	start := time.Now()
-	var err error
-
-	defer func() {
-		observeGRPC("Gateway.Heartbeat", req.Address, err, start)
-	}()
+	// an incompatible worker isn't (re)added, and leaves the ring once its last heartbeat expires
+	version, ok := pb.NegotiateAPIVersion(req.MinApiVersion, req.ApiVersion)
+	if !ok {
+		Metrics.incompatibleWorkers.WithLabelValues(req.Address).Inc()
+		return nil, status.Errorf(codes.FailedPrecondition, "unsupported api versions %d to %d (gateway supports %d to %d)", req.MinApiVersion, req.ApiVersion, pb.MIN_API_VERSION, pb.API_VERSION)
+	}
+	state.setAPIVersion(req.Address, version)
+	state.setBuildVersion(req.Address, req.BuildVersion)
+	state.setFeatures(req.Address, req.Features)
 
+	state.setDraining(req.Address, req.Draining)
 	state.addNode(req.WorkerId, req.Address)
-	return &pb.HeartbeatResponse{Acknowledged: true}, nil
+	state.setCoverage(req.Address, req.CoverageBloom, req.CoverageHashes)
+	state.setCapacity(req.Address, req.Capacity)
+	observeWorkerHeartbeat(req.Address, req.SeqEpoch, req.Seq)
+	if req.SentAt > 0 {
+		skew := time.UnixMilli(req.SentAt).Sub(monotonicNow())
+		state.setClockSkew(req.Address, skew)
+	}
+	return &pb.HeartbeatResponse{Acknowledged: true, ApiVersion: pb.API_VERSION, MinApiVersion: pb.MIN_API_VERSION}, nil
 }
 
 func setup_heartbeat_listener() {
@@ -38,8 +52,9 @@ func setup_heartbeat_listener() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpcInterceptors.ServerOptions()...)
 	pb.RegisterGatewayServer(s, &grpcServer{})
+	rpc.RegisterDebugServices(s)
 	log.Printf("grpc server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
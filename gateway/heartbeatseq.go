@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// heartbeat sequence numbers: workers number their heartbeats (restarting at 1 with a new epoch when the process
+// restarts), so that the gateway sees beats lost on the way (worker -> registry -> gateway) or reordered as they happen
+// instead of only when the TTL reaper drops a worker. gaps are logged, and counted with the reorderings in
+// gateway_worker_heartbeats_missed_total/_out_of_order_total; gateway_worker_heartbeat_loss_ratio is the share missed
+// over about the last heartbeatLossWindow beats. same tracker as registry/heartbeatseq.go
+const heartbeatLossWindow = 100 // beats
+
+type heartbeatSeqs struct {
+	mu      sync.Mutex
+	senders map[string]*heartbeatSeq // by address
+}
+
+type heartbeatSeq struct {
+	epoch    int64
+	last     uint64
+	expected float64 // beats expected and missed, decayed past heartbeatLossWindow
+	missed   float64
+}
+
+type heartbeatGap struct {
+	missed     uint64 // beats skipped just before this one
+	outOfOrder bool   // at or below the last seq seen (late or repeated)
+	lossRatio  float64
+}
+
+func newHeartbeatSeqs() *heartbeatSeqs {
+	return &heartbeatSeqs{senders: make(map[string]*heartbeatSeq)}
+}
+
+// observe records beat seq of epoch from a sender, false for unsequenced beats (older builds)
+func (t *heartbeatSeqs) observe(sender string, epoch int64, seq uint64) (heartbeatGap, bool) {
+	if seq == 0 {
+		return heartbeatGap{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.senders[sender]
+	if !ok || s.epoch != epoch { // first beat seen, or the sender restarted: nothing to compare with
+		t.senders[sender] = &heartbeatSeq{epoch: epoch, last: seq, expected: 1}
+		return heartbeatGap{}, true
+	}
+	var gap heartbeatGap
+	if seq <= s.last {
+		gap.outOfOrder = true
+	} else {
+		gap.missed = seq - s.last - 1
+		s.last = seq
+		s.expected += float64(gap.missed + 1)
+		s.missed += float64(gap.missed)
+		if s.expected > heartbeatLossWindow {
+			scale := heartbeatLossWindow / s.expected
+			s.expected, s.missed = heartbeatLossWindow, s.missed*scale
+		}
+	}
+	gap.lossRatio = s.missed / s.expected
+	return gap, true
+}
+
+func (t *heartbeatSeqs) forget(sender string) {
+	t.mu.Lock()
+	delete(t.senders, sender)
+	t.mu.Unlock()
+}
+
+var workerHeartbeatSeqs = newHeartbeatSeqs()
+
+// observeWorkerHeartbeat checks the sequence of a worker heartbeat forwarded by the registry
+func observeWorkerHeartbeat(address string, epoch int64, seq uint64) {
+	gap, ok := workerHeartbeatSeqs.observe(address, epoch, seq)
+	if !ok {
+		return
+	}
+	if gap.missed > 0 {
+		Metrics.workerHeartbeatsMissed.WithLabelValues(address).Add(float64(gap.missed))
+		log.Printf("missed %d heartbeat(s) of worker %s before seq %d", gap.missed, address, seq)
+	}
+	if gap.outOfOrder {
+		Metrics.workerHeartbeatsOutOfOrder.WithLabelValues(address).Inc()
+		log.Printf("out of order heartbeat of worker %s: seq %d", address, seq)
+	}
+	Metrics.workerHeartbeatLoss.WithLabelValues(address).Set(gap.lossRatio)
+}
+
+func forgetWorkerHeartbeats(address string) {
+	workerHeartbeatSeqs.forget(address)
+	Metrics.workerHeartbeatsMissed.DeleteLabelValues(address)
+	Metrics.workerHeartbeatsOutOfOrder.DeleteLabelValues(address)
+	Metrics.workerHeartbeatLoss.DeleteLabelValues(address)
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestHeartbeatSeqsDetectGapsReorderingAndRestarts(t *testing.T) {
+	seqs := newHeartbeatSeqs()
+	for _, beat := range []struct {
+		epoch      int64
+		seq        uint64
+		missed     uint64
+		outOfOrder bool
+	}{
+		{1, 1, 0, false},
+		{1, 2, 0, false},
+		{1, 5, 2, false}, // 3 and 4 lost
+		{1, 4, 0, true},  // late
+		{1, 6, 0, false},
+		{2, 1, 0, false}, // restarted
+		{2, 3, 1, false},
+	} {
+		gap, ok := seqs.observe("a", beat.epoch, beat.seq)
+		if !ok || gap.missed != beat.missed || gap.outOfOrder != beat.outOfOrder {
+			t.Fatalf("epoch %d seq %d: got %+v, want %d missed (out of order: %v)", beat.epoch, beat.seq, gap, beat.missed, beat.outOfOrder)
+		}
+	}
+	if _, ok := seqs.observe("b", 1, 0); ok {
+		t.Fatal("unsequenced beat observed")
+	}
+}
+
+func TestHeartbeatLossRatioDecays(t *testing.T) {
+	seqs := newHeartbeatSeqs()
+	seqs.observe("a", 1, 1)
+	gap, _ := seqs.observe("a", 1, 11) // 9 of 11 lost
+	if gap.lossRatio != 9.0/11 {
+		t.Fatalf("got a loss ratio of %v, want 9/11", gap.lossRatio)
+	}
+	for seq := uint64(12); seq < 12+10*heartbeatLossWindow; seq++ {
+		gap, _ = seqs.observe("a", 1, seq)
+	}
+	if gap.lossRatio > 0.01 {
+		t.Fatalf("got a loss ratio of %v after %d beats in a row, want it decayed", gap.lossRatio, 10*heartbeatLossWindow)
+	}
+}
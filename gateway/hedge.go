@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// hedged reads: if the primary hasn't answered within the recent p95 latency, the same read is sent to the next replica
+// and whichever answers first wins. only has an effect with REPLICATION_FACTOR > 1
+var HEDGE_ENABLED = env.Bool("HEDGE_ENABLED", false)
+var HEDGE_MIN_DELAY = env.Duration("HEDGE_MIN_DELAY", 5*time.Millisecond) // floor so a very low p95 doesn't hedge every request
+
+const latencyWindow = 256 // recent samples kept per method
+
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencyWindow]time.Duration
+	n       int // total samples observed
+	p95     time.Duration
+}
+
+func (l *latencyTracker) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.samples[l.n%latencyWindow] = d
+	l.n++
+
+	// recompute periodically instead of on every sample (sorting the window is not free)
+	if l.n%32 == 0 || l.n < 32 {
+		size := min(l.n, latencyWindow)
+		sorted := make([]time.Duration, size)
+		copy(sorted, l.samples[:size])
+		slices.Sort(sorted)
+		l.p95 = sorted[(size*95)/100]
+	}
+}
+
+func (l *latencyTracker) P95() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.p95
+}
+
+var hedgeLatency = map[string]*latencyTracker{
+	"GetPings":      {},
+	"GetPingsBatch": {},
+	"GetPingArea":   {},
+}
+
+func hedgeDelay(method string) time.Duration {
+	delay := HEDGE_MIN_DELAY
+	if tracker, ok := hedgeLatency[method]; ok {
+		delay = max(delay, tracker.P95())
+	}
+	return delay
+}
+
+type hedgeResult[T any] struct {
+	value T
+	addr  string
+	err   error
+	hedge bool
+}
+
+// hedgedCall runs call against addrs[0] (primary) and, if hedging is enabled, against the next replica once the hedge delay
+// elapses or the previous attempt fails. returns the first successful response and the address that served it
+func hedgedCall[T any](ctx context.Context, method string, addrs []string, call func(ctx context.Context, addr string, replica bool) (T, error)) (T, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel() // cancels the losing attempt
+
+	tracker := hedgeLatency[method]
+	results := make(chan hedgeResult[T], len(addrs)) // buffered so losing attempts never block
+
+	launched := 0
+	launch := func() {
+		i := launched
+		launched++
+		go func() {
+			start := time.Now()
+			v, err := call(ctx, addrs[i], i > 0)
+			if err == nil && tracker != nil {
+				tracker.observe(time.Since(start))
+			}
+			results <- hedgeResult[T]{value: v, addr: addrs[i], err: err, hedge: i > 0}
+		}()
+	}
+
+	canHedge := HEDGE_ENABLED && len(addrs) > 1
+	var timerC <-chan time.Time
+	if canHedge {
+		timer := time.NewTimer(hedgeDelay(method))
+		defer timer.Stop()
+		timerC = timer.C
+	}
+
+	launch()
+	pending := 1
+	var lastErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.hedge {
+					Metrics.hedgedRequestsTotal.WithLabelValues(method, "won").Inc()
+				}
+				return r.value, r.addr, nil
+			}
+			lastErr = r.err
+			// failed attempt: move on to the next replica right away instead of waiting for the timer
+			if canHedge && launched < len(addrs) {
+				Metrics.hedgedRequestsTotal.WithLabelValues(method, "sent").Inc()
+				launch()
+				pending++
+			}
+		case <-timerC:
+			timerC = nil
+			if launched < len(addrs) {
+				Metrics.hedgedRequestsTotal.WithLabelValues(method, "sent").Inc()
+				launch()
+				pending++
+			}
+		}
+	}
+
+	var zero T
+	return zero, "", shardError(lastErr)
+}
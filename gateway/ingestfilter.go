@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ingest filters, checked on every listener (POST /ping, UDP, CoAP, RESP) before anything else is done with a ping:
+//   - INGEST_DENY_CIDRS / INGEST_ALLOW_CIDRS (comma-separated, e.g. "10.0.0.0/8,192.0.2.7/32"): client addresses in a
+//     denied range are refused, and with an allow list only its ranges are accepted (deny wins). behind a proxy, list
+//     it in INGEST_TRUSTED_PROXIES: the client is then the last X-Forwarded-For address not itself a trusted proxy
+//   - INGEST_FENCE_FILE (JSON, fence name -> polygon, like a zone set: {"europe": [[lat, lng], ...]}): pings outside
+//     every fence are refused, to keep garbage coordinates (0,0, swapped lat/lng, emulators) out of the dataset. checked
+//     once the ping is parsed, on its location as the ingest hooks left it (see ingesthooks.go)
+//
+// refused pings get 403 with a JSON error ({"error": "ip_denied"|"ip_not_allowed"|"outside_fence", "message": ...})
+// over HTTP, NOPERM over RESP, 4.03 over CoAP, and are counted in gateway_ingest_filtered_total
+var INGEST_ALLOW_CIDRS = parsePrefixes("INGEST_ALLOW_CIDRS")
+var INGEST_DENY_CIDRS = parsePrefixes("INGEST_DENY_CIDRS")
+var INGEST_TRUSTED_PROXIES = parsePrefixes("INGEST_TRUSTED_PROXIES")
+var INGEST_FENCE_FILE = os.Getenv("INGEST_FENCE_FILE")
+
+var ingestFences = loadIngestFences(INGEST_FENCE_FILE) // nil = no fence
+
+const (
+	filterIPDenied     = "ip_denied"
+	filterIPNotAllowed = "ip_not_allowed"
+	filterOutsideFence = "outside_fence"
+)
+
+var filterMessages = map[string]string{
+	filterIPDenied:     "Client address is denied",
+	filterIPNotAllowed: "Client address is not allowed",
+	filterOutsideFence: "Location outside the accepted regions",
+}
+
+func parsePrefixes(key string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, s := range strings.Split(os.Getenv(key), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if addr, addrErr := netip.ParseAddr(s); addrErr == nil {
+			prefix, err = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil // a single address
+		}
+		if err != nil {
+			log.Fatalf("invalid %s entry %q: %v", key, s, err)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96) // ::ffff:a.b.c.d/n: addresses are unmapped
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out
+}
+
+func loadIngestFences(path string) *zoneSet {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("failed to read INGEST_FENCE_FILE: %v", err)
+	}
+	var raw map[string][][2]float64
+	if err := json.Unmarshal(data, &raw); err != nil {
+		log.Fatalf("failed to parse INGEST_FENCE_FILE: %v", err)
+	}
+	set, msg := newZoneSet(raw)
+	if set == nil {
+		log.Fatalf("invalid INGEST_FENCE_FILE: %s", msg)
+	}
+	log.Printf("loaded %d ingest fences", len(set.zones))
+	return set
+}
+
+// prefixesContain reports whether addr, unmapped and without its zone (fe80::1%eth0), is in one of the prefixes
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIngestAddr returns why pings from the address are refused, "" if they aren't. unparsable addresses only pass
+// without an allow list
+func filterIngestAddr(addr netip.Addr) string {
+	addr = addr.Unmap()
+	if addr.IsValid() && prefixesContain(INGEST_DENY_CIDRS, addr) {
+		return filterIPDenied
+	}
+	if len(INGEST_ALLOW_CIDRS) > 0 && (!addr.IsValid() || !prefixesContain(INGEST_ALLOW_CIDRS, addr)) {
+		return filterIPNotAllowed
+	}
+	return ""
+}
+
+// filterIngestPoint returns why a ping at the point is refused, "" if it isn't
+func filterIngestPoint(lat, lng float64) string {
+	if ingestFences == nil {
+		return ""
+	}
+	for _, z := range ingestFences.zones {
+		if z.contains(lat, lng) {
+			return ""
+		}
+	}
+	return filterOutsideFence
+}
+
+// netAddrIP is the IP of a UDP/TCP peer
+func netAddrIP(a net.Addr) netip.Addr {
+	if ap, err := netip.ParseAddrPort(a.String()); err == nil {
+		return ap.Addr()
+	}
+	return netip.Addr{}
+}
+
+// requestClientIP is the address of the HTTP client, looked up through INGEST_TRUSTED_PROXIES
+func requestClientIP(r *http.Request) netip.Addr {
+	addr := netip.Addr{}
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		addr = ap.Addr().Unmap()
+	}
+	if len(INGEST_TRUSTED_PROXIES) == 0 || !prefixesContain(INGEST_TRUSTED_PROXIES, addr) {
+		return addr
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return addr // the proxy's own request
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{} // can't tell who the client is
+		}
+		if addr = hop.Unmap(); !prefixesContain(INGEST_TRUSTED_PROXIES, addr) {
+			return addr
+		}
+	}
+	return addr
+}
+
+// countFiltered counts a refused ping and returns the error message for it
+func countFiltered(reason string) string {
+	Metrics.ingestFilteredTotal.WithLabelValues(reason).Inc()
+	return filterMessages[reason]
+}
+
+// denyIngest writes the structured 403 of a filtered ping
+func denyIngest(w http.ResponseWriter, reason string) {
+	msg := countFiltered(reason)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{"error": reason, "message": msg})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// withIngestCIDRs filters ingest on the given INGEST_ALLOW_CIDRS and INGEST_DENY_CIDRS values
+func withIngestCIDRs(t *testing.T, allow, deny string) {
+	previousAllow, previousDeny := INGEST_ALLOW_CIDRS, INGEST_DENY_CIDRS
+	t.Cleanup(func() { INGEST_ALLOW_CIDRS, INGEST_DENY_CIDRS = previousAllow, previousDeny })
+	t.Setenv("INGEST_ALLOW_CIDRS", allow)
+	t.Setenv("INGEST_DENY_CIDRS", deny)
+	INGEST_ALLOW_CIDRS, INGEST_DENY_CIDRS = parsePrefixes("INGEST_ALLOW_CIDRS"), parsePrefixes("INGEST_DENY_CIDRS")
+}
+
+func TestParsePrefixes(t *testing.T) {
+	t.Setenv("TEST_CIDRS", " 10.1.2.3/8,,192.0.2.7, 2001:db8::1 ,2001:db8:1::/48,::ffff:198.51.100.9,::ffff:172.16.0.0/108")
+	var got []string
+	for _, p := range parsePrefixes("TEST_CIDRS") {
+		got = append(got, p.String())
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "2001:db8::1/128", "2001:db8:1::/48", "198.51.100.9/32", "172.16.0.0/12"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFilterIngestAddr(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow string
+		deny  string
+		addr  string // "" = unparsable
+		want  string
+	}{
+		{"no filter", "", "", "203.0.113.5", ""},
+		{"no filter, unparsable", "", "", "", ""},
+		{"denied", "", "203.0.113.0/24", "203.0.113.5", filterIPDenied},
+		{"not denied", "", "203.0.113.0/24", "203.0.114.5", ""},
+		{"allowed", "10.0.0.0/8", "", "10.200.0.1", ""},
+		{"not allowed", "10.0.0.0/8", "", "11.0.0.1", filterIPNotAllowed},
+		{"unparsable with an allow list", "10.0.0.0/8", "", "", filterIPNotAllowed},
+		{"single address allowed", "192.0.2.7", "", "192.0.2.7", ""},
+		{"next to a single address", "192.0.2.7", "", "192.0.2.8", filterIPNotAllowed},
+		{"deny wins over allow", "10.0.0.0/8", "10.66.0.0/16", "10.66.1.1", filterIPDenied},
+		{"allowed outside the denied range", "10.0.0.0/8", "10.66.0.0/16", "10.67.1.1", ""},
+		{"IPv6 denied", "", "2001:db8::/32", "2001:db8:42::1", filterIPDenied},
+		{"IPv6 allowed", "2001:db8::/32", "", "2001:db8:42::1", ""},
+		{"IPv6 not allowed", "2001:db8::/32", "", "2001:db9::1", filterIPNotAllowed},
+		{"IPv6 single address", "2001:db8::1", "", "2001:db8::1", ""},
+		{"IPv6 zoned address denied", "", "fe80::/10", "fe80::1%eth0", filterIPDenied},
+		{"IPv4 range doesn't match IPv6", "10.0.0.0/8", "", "::a00:1", filterIPNotAllowed},
+		{"IPv6 range doesn't match IPv4", "::/0", "", "10.0.0.1", filterIPNotAllowed},
+		{"IPv4-mapped client denied by an IPv4 range", "", "203.0.113.0/24", "::ffff:203.0.113.5", filterIPDenied},
+		{"IPv4-mapped client allowed by an IPv4 range", "10.0.0.0/8", "", "::ffff:10.0.0.1", ""},
+		{"IPv4-mapped range denies the IPv4 client", "", "::ffff:203.0.113.0/120", "203.0.113.5", filterIPDenied},
+		{"IPv4-mapped range allows the IPv4 client", "::ffff:10.0.0.0/104", "", "10.1.2.3", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withIngestCIDRs(t, tt.allow, tt.deny)
+			var addr netip.Addr
+			if tt.addr != "" {
+				addr = netip.MustParseAddr(tt.addr)
+			}
+			if got := filterIngestAddr(addr); got != tt.want {
+				t.Errorf("filterIngestAddr(%s) = %q, want %q", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestClientIPThroughTrustedProxies(t *testing.T) {
+	previous := INGEST_TRUSTED_PROXIES
+	INGEST_TRUSTED_PROXIES = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+	t.Cleanup(func() { INGEST_TRUSTED_PROXIES = previous })
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string // "" = unknown
+	}{
+		{"direct client", "203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"through a proxy", "10.0.0.2:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"through proxies", "10.0.0.2:4000", []string{"192.0.2.1, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"split headers", "10.0.0.2:4000", []string{"198.51.100.1", "10.0.0.3"}, "198.51.100.1"},
+		{"IPv6 proxy and client", "[fd00::2]:4000", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"IPv4-mapped proxy", "[::ffff:10.0.0.2]:4000", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"the proxy's own request", "10.0.0.2:4000", nil, "10.0.0.2"},
+		{"garbage hop", "10.0.0.2:4000", []string{"198.51.100.1, unknown"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/ping", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			got := requestClientIP(r)
+			if (tt.want == "" && got.IsValid()) || (tt.want != "" && got != netip.MustParseAddr(tt.want)) {
+				t.Errorf("got %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"os"
+	"plugin"
+	"regexp"
+	"runtime/debug"
+	"strings"
+
+	"geostreamdb/geo"
+	"geostreamdb/ingesthook"
+)
+
+// ingest hooks: filters and transforms run on every incoming ping (POST /ping, UDP, CoAP, RESP) of a tenant once it is
+// parsed, before the fences, the ACL and the quotas, which see the ping as the hooks left it. hooks are registered by
+// name through geostreamdb/ingesthook, compiled in (like the ones below) or from the Go plugins (.so, built with
+// -buildmode=plugin and the gateway's toolchain, which must be a cgo build) listed in INGEST_PLUGINS, comma-separated.
+// INGEST_HOOKS_FILE (JSON) chains them per tenant, "*" for the tenants without an entry:
+//
+//	{"acme": [{"hook": "require-device", "config": {"pattern": "^acme-"}}, {"hook": "drop-null-island"}], "*": []}
+//
+// a hook dropping a ping has it answered as stored (200 over HTTP, 2.01 over CoAP, not counted by GEOADD); one
+// rejecting it has it answered 400 (ERR over RESP, 4.00 over CoAP) with its message. a hook that panics rejects the
+// ping. each hook's results are counted in gateway_ingest_hooks_total
+var INGEST_PLUGINS = os.Getenv("INGEST_PLUGINS")
+var INGEST_HOOKS_FILE = os.Getenv("INGEST_HOOKS_FILE")
+
+const defaultHookChain = "*"
+
+type namedHook struct {
+	name string
+	hook ingesthook.Hook
+}
+
+var ingestHooks map[string][]namedHook // tenant -> chain, set at startup
+
+// hookEntry is an entry of a chain in INGEST_HOOKS_FILE
+type hookEntry struct {
+	Hook   string          `json:"hook"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// loadIngestHooks opens INGEST_PLUGINS and builds the chains of INGEST_HOOKS_FILE. any failure is fatal: a missing
+// hook would let through the pings it was meant to stop
+func loadIngestHooks() {
+	for _, path := range strings.Split(INGEST_PLUGINS, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if err := openIngestPlugin(path); err != nil {
+			log.Fatalf("failed to open ingest plugin %s: %v", path, err)
+		}
+		log.Printf("opened ingest plugin %s", path)
+	}
+	if INGEST_HOOKS_FILE == "" {
+		return
+	}
+	data, err := os.ReadFile(INGEST_HOOKS_FILE)
+	if err != nil {
+		log.Fatalf("failed to read INGEST_HOOKS_FILE: %v", err)
+	}
+	chains, err := parseIngestHooks(data)
+	if err != nil {
+		log.Fatalf("invalid INGEST_HOOKS_FILE: %v", err)
+	}
+	ingestHooks = chains
+	log.Printf("loaded ingest hooks for %d tenants (registered: %s)", len(chains), strings.Join(ingesthook.Names(), ", "))
+}
+
+// openIngestPlugin opens a Go plugin, whose init functions register its hooks
+func openIngestPlugin(path string) error {
+	if !builtWithCgo() {
+		return errors.New("Go plugins need a cgo build of the gateway: build it with CGO_ENABLED=1 (the image with --build-arg CGO_ENABLED=1)")
+	}
+	_, err := plugin.Open(path)
+	return err
+}
+
+// builtWithCgo is whether the gateway was built with CGO_ENABLED=1, without which plugin.Open is not implemented
+func builtWithCgo() bool {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return true // unknown, let plugin.Open tell
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "CGO_ENABLED" {
+			return setting.Value == "1"
+		}
+	}
+	return true
+}
+
+func parseIngestHooks(data []byte) (map[string][]namedHook, error) {
+	var raw map[string][]hookEntry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	chains := make(map[string][]namedHook, len(raw))
+	for tenant, entries := range raw {
+		chain := make([]namedHook, 0, len(entries))
+		for _, e := range entries {
+			factory, ok := ingesthook.Lookup(e.Hook)
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown hook %q", tenant, e.Hook)
+			}
+			hook, err := factory(e.Config)
+			if err != nil {
+				return nil, fmt.Errorf("%s: hook %s: %v", tenant, e.Hook, err)
+			}
+			chain = append(chain, namedHook{name: e.Hook, hook: hook})
+		}
+		chains[tenant] = chain
+	}
+	return chains, nil
+}
+
+// hooksFor returns the chain of a tenant, nil if it has none
+func hooksFor(t *tenant) []namedHook {
+	if chain, ok := ingestHooks[t.name]; ok {
+		return chain
+	}
+	return ingestHooks[defaultHookChain]
+}
+
+// runIngestHooks runs the chain of a tenant on a ping: dropped if a hook dropped it, otherwise msg is why it is
+// rejected (by a hook, or invalid as the hooks left it), "" if it is kept
+func runIngestHooks(t *tenant, p *ingesthook.Ping) (dropped bool, msg string) {
+	for _, h := range hooksFor(t) {
+		err := applyHook(h.hook, p)
+		switch {
+		case err == nil:
+			Metrics.ingestHooksTotal.WithLabelValues(h.name, "kept").Inc()
+		case errors.Is(err, ingesthook.ErrDrop):
+			Metrics.ingestHooksTotal.WithLabelValues(h.name, "dropped").Inc()
+			return true, ""
+		default:
+			Metrics.ingestHooksTotal.WithLabelValues(h.name, "rejected").Inc()
+			return false, err.Error()
+		}
+	}
+	return false, checkHookedPing(p)
+}
+
+func applyHook(h ingesthook.Hook, p *ingesthook.Ping) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ingest hook panicked: %v", r)
+			err = errors.New("ingest hook failed")
+		}
+	}()
+	return h.Apply(p)
+}
+
+// checkHookedPing validates the fields hooks may have changed, as the listeners validate those of the client
+func checkHookedPing(p *ingesthook.Ping) string {
+	switch {
+	case math.IsNaN(p.Lat) || math.IsNaN(p.Lng) || p.Lat < -90 || p.Lat > 90 || p.Lng < -180 || p.Lng > 180:
+		return "Latitude or longitude out of bounds"
+	case len(p.DeviceID) > MAX_DEVICE_ID_LENGTH:
+		return "Device id too long"
+	case p.Seq != 0 && p.DeviceID == "":
+		return "seq requires a deviceId"
+	}
+	if _, msg := parseMotion(p.Speed, p.Heading); msg != "" {
+		return msg
+	}
+	if msg := checkAccuracy(p.Accuracy); msg != "" {
+		return msg
+	}
+	_, msg := parseFloor(p.Floor, nil)
+	return msg
+}
+
+// newHookPing is a ping of a tenant over a protocol, for its hooks
+func newHookPing(t *tenant, protocol string, client netip.Addr, lat, lng float64, deviceID string) *ingesthook.Ping {
+	return &ingesthook.Ping{Tenant: t.name, Protocol: protocol, Client: client, Lat: lat, Lng: lng, DeviceID: deviceID}
+}
+
+// the compiled-in hooks:
+//   - require-device: rejects anonymous pings, and with {"pattern": "<regexp>"} the device ids not matching it
+//   - drop-null-island: drops the pings within {"radiusMeters": 1000} of 0,0, where broken GPS fixes land
+func init() {
+	ingesthook.Register("require-device", func(config json.RawMessage) (ingesthook.Hook, error) {
+		var c struct {
+			Pattern string `json:"pattern"`
+		}
+		if err := unmarshalHookConfig(config, &c); err != nil {
+			return nil, err
+		}
+		pattern, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		return ingesthook.HookFunc(func(p *ingesthook.Ping) error {
+			if p.DeviceID == "" {
+				return errors.New("A deviceId is required")
+			}
+			if !pattern.MatchString(p.DeviceID) {
+				return errors.New("Device id not accepted")
+			}
+			return nil
+		}), nil
+	})
+	ingesthook.Register("drop-null-island", func(config json.RawMessage) (ingesthook.Hook, error) {
+		c := struct {
+			RadiusMeters float64 `json:"radiusMeters"`
+		}{RadiusMeters: 1000}
+		if err := unmarshalHookConfig(config, &c); err != nil {
+			return nil, err
+		}
+		if !(c.RadiusMeters > 0) {
+			return nil, errors.New("radiusMeters must be positive")
+		}
+		return ingesthook.HookFunc(func(p *ingesthook.Ping) error {
+			if geo.HaversineMeters(p.Lat, p.Lng, 0, 0) <= c.RadiusMeters {
+				return ingesthook.ErrDrop
+			}
+			return nil
+		}), nil
+	})
+}
+
+func unmarshalHookConfig(config json.RawMessage, v any) error {
+	if len(config) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(config, v); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/netip"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"geostreamdb/ingesthook"
+)
+
+func init() {
+	ingesthook.Register("test-shift", func(config json.RawMessage) (ingesthook.Hook, error) {
+		var c struct{ Lat float64 }
+		if err := unmarshalHookConfig(config, &c); err != nil {
+			return nil, err
+		}
+		return ingesthook.HookFunc(func(p *ingesthook.Ping) error {
+			p.Lat += c.Lat
+			p.DeviceID = p.Tenant + "-" + p.DeviceID
+			return nil
+		}), nil
+	})
+	ingesthook.Register("test-panic", func(json.RawMessage) (ingesthook.Hook, error) {
+		return ingesthook.HookFunc(func(p *ingesthook.Ping) error { panic("broken") }), nil
+	})
+	ingesthook.Register("test-drop", func(json.RawMessage) (ingesthook.Hook, error) {
+		return ingesthook.HookFunc(func(p *ingesthook.Ping) error { return fmt.Errorf("stale fix: %w", ingesthook.ErrDrop) }), nil
+	})
+}
+
+func withIngestHooks(t *testing.T, config string) {
+	chains, err := parseIngestHooks([]byte(config))
+	if err != nil {
+		t.Fatalf("parseIngestHooks: %v", err)
+	}
+	previous := ingestHooks
+	ingestHooks = chains
+	t.Cleanup(func() { ingestHooks = previous })
+}
+
+func TestParseIngestHooksRejectsUnknownHooksAndBadConfigs(t *testing.T) {
+	for _, config := range []string{
+		`{"acme": [{"hook": "no-such-hook"}]}`,
+		`{"acme": [{"hook": "require-device", "config": {"pattern": "("}}]}`,
+		`{"acme": [{"hook": "drop-null-island", "config": {"radiusMeters": -1}}]}`,
+		`{"acme": {"hook": "require-device"}}`,
+	} {
+		if _, err := parseIngestHooks([]byte(config)); err == nil {
+			t.Errorf("%s: accepted", config)
+		}
+	}
+}
+
+func TestIngestHooksRunInOrderPerTenant(t *testing.T) {
+	withIngestHooks(t, `{"anonymous": [{"hook": "test-shift", "config": {"lat": 1}}, {"hook": "require-device", "config": {"pattern": "^anonymous-"}}], "*": [{"hook": "drop-null-island"}]}`)
+
+	p := newHookPing(anonymous, "http", netip.MustParseAddr("192.0.2.1"), 10, 20, "d1")
+	if dropped, msg := runIngestHooks(anonymous, p); dropped || msg != "" {
+		t.Fatalf("got dropped %v, %q", dropped, msg)
+	}
+	if p.Lat != 11 || p.DeviceID != "anonymous-d1" {
+		t.Errorf("got %+v, want the shifted ping", p)
+	}
+
+	// other tenants get the default chain
+	acme := &tenant{name: "acme"}
+	if dropped, _ := runIngestHooks(acme, newHookPing(acme, "udp", netip.Addr{}, 0.001, 0.001, "")); !dropped {
+		t.Errorf("null island ping kept")
+	}
+	if dropped, msg := runIngestHooks(acme, newHookPing(acme, "udp", netip.Addr{}, 42, -8, "")); dropped || msg != "" {
+		t.Errorf("got dropped %v, %q for a ping elsewhere", dropped, msg)
+	}
+}
+
+func TestIngestHooksRejections(t *testing.T) {
+	for _, tc := range []struct {
+		config string
+		lat    float64
+		want   string
+	}{
+		{`{"*": [{"hook": "require-device"}]}`, 10, "A deviceId is required"},
+		{`{"*": [{"hook": "test-panic"}]}`, 10, "ingest hook failed"},
+		{`{"*": [{"hook": "test-shift", "config": {"lat": 100}}]}`, 10, "Latitude or longitude out of bounds"}, // left invalid
+	} {
+		withIngestHooks(t, tc.config)
+		if dropped, msg := runIngestHooks(anonymous, newHookPing(anonymous, "http", netip.Addr{}, tc.lat, 0, "")); dropped || msg != tc.want {
+			t.Errorf("%s: got dropped %v, %q, want %q", tc.config, dropped, msg, tc.want)
+		}
+	}
+}
+
+func TestPostPingAnswersHookedPings(t *testing.T) {
+	withIngestHooks(t, `{"*": [{"hook": "test-drop"}]}`)
+	w := httptest.NewRecorder()
+	postPing(w, httptest.NewRequest("POST", "/ping", strings.NewReader(`{"lat": 42.2, "lng": -8.7}`)))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "dropped") {
+		t.Errorf("dropped: got %d %s", w.Code, w.Body)
+	}
+
+	withIngestHooks(t, `{"*": [{"hook": "require-device"}]}`)
+	w = httptest.NewRecorder()
+	postPing(w, httptest.NewRequest("POST", "/ping", strings.NewReader(`{"lat": 42.2, "lng": -8.7}`)))
+	if w.Code != 400 || w.Body.String() != "A deviceId is required" {
+		t.Errorf("rejected: got %d %s", w.Code, w.Body)
+	}
+}
+
+// a hook from a plugin built by the test (testdata/hookplugin), not compiled into the gateway
+func TestIngestPluginHooksRegisterWhenOpened(t *testing.T) {
+	if !builtWithCgo() {
+		t.Skip("Go plugins need cgo")
+	}
+	if _, ok := ingesthook.Lookup("test-plugin-fleet-only"); ok {
+		t.Fatalf("the plugin's hook is registered before opening it")
+	}
+	path := filepath.Join(t.TempDir(), "hook.so")
+	args := []string{"build", "-buildmode=plugin", "-o", path}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "-race" && setting.Value == "true" {
+				args = append(args, "-race") // the plugin's packages must be built like the gateway's
+			}
+		}
+	}
+	build := exec.Command("go", append(args, "./testdata/hookplugin")...)
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building the plugin: %v\n%s", err, out)
+	}
+	if err := openIngestPlugin(path); err != nil {
+		t.Fatalf("openIngestPlugin: %v", err)
+	}
+
+	withIngestHooks(t, `{"*": [{"hook": "test-plugin-fleet-only"}]}`)
+	p := newHookPing(anonymous, "http", netip.Addr{}, 42, -8, "truck-42")
+	if dropped, msg := runIngestHooks(anonymous, p); dropped || msg != "" || p.DeviceID != "fleet-truck-42" {
+		t.Errorf("got dropped %v, %q, device %q", dropped, msg, p.DeviceID)
+	}
+	if _, msg := runIngestHooks(anonymous, newHookPing(anonymous, "http", netip.Addr{}, 42, -8, "")); msg != "fleet devices only" {
+		t.Errorf("got %q, want the plugin's rejection", msg)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// async jobs: POST /jobs/areaQuery?<the /pingArea parameters> runs an area query too large or too slow for an
+// interactive request in the background, answering 202 with the job's ID right away. the cover set is split into
+// chunks of JOB_CHUNK_GEOHASHES cells queried one after the other (each fanned out like a /pingArea query), so that a
+// job never holds more of the workers than an interactive query. bounded by JOB_MAX_GEOHASHES cells at the requested
+// precision instead of MAX_PINGAREA_GEOHASHES, and accounted as those cells at submission. historyOffset=<duration>
+// scans the window that ended that long ago in the workers' history tier instead of the live one (within the tenant's
+// retention). GET /jobs/{id} follows its progress, GET /jobs/{id}/result fetches its counts once done
+// (download=true as a file, or format=csv|parquet, see export.go) and DELETE /jobs/{id} cancels or forgets it.
+//
+// jobs are held in memory by the gateway they were submitted to (at most JOB_MAX, queued, running or done), and only
+// visible to the tenant that submitted them. JOB_CONCURRENCY of them run at once; done ones are forgotten after JOB_TTL
+var (
+	JOB_MAX_GEOHASHES   = int64(env.Int("JOB_MAX_GEOHASHES", 1<<20))
+	JOB_CHUNK_GEOHASHES = env.Int("JOB_CHUNK_GEOHASHES", 1024) // of the cover set
+	JOB_MAX             = env.Int("JOB_MAX", 64)
+	JOB_CONCURRENCY     = env.Int("JOB_CONCURRENCY", 1)
+	JOB_TTL             = env.Duration("JOB_TTL", time.Hour)
+)
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+)
+
+type areaJob struct {
+	id     string
+	tenant string
+	query  pingAreaQuery
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu                           sync.Mutex
+	state                        string
+	chunks, chunksDone           int
+	partial                      bool // a shard of a chunk failed
+	submitted, started, finished time.Time
+	counts                       map[string]*ExtendedPingAreaCount
+}
+
+// jobStatus is the JSON of GET /jobs/{id}
+type jobStatus struct {
+	ID          string  `json:"id"`
+	State       string  `json:"state"`
+	Progress    float64 `json:"progress"` // 0 to 1, of the chunks
+	Chunks      int     `json:"chunks"`
+	ChunksDone  int     `json:"chunksDone"`
+	Cells       int64   `json:"cells"` // at the requested precision
+	Complete    bool    `json:"complete"`
+	SubmittedAt int64   `json:"submittedAt"` // unix ms
+	StartedAt   int64   `json:"startedAt,omitempty"`
+	FinishedAt  int64   `json:"finishedAt,omitempty"`
+}
+
+var jobs = struct {
+	sync.Mutex
+	byID  map[string]*areaJob
+	queue chan *areaJob
+}{byID: make(map[string]*areaJob)}
+
+// startJobs starts the runners of the job queue and the expiry of done jobs
+func startJobs() {
+	jobs.queue = make(chan *areaJob, max(1, JOB_MAX))
+	for i := 0; i < max(1, JOB_CONCURRENCY); i++ {
+		go func() {
+			for job := range jobs.queue {
+				job.run()
+			}
+		}()
+	}
+	go func() {
+		for range time.Tick(max(time.Second, JOB_TTL/10)) {
+			expireJobs(time.Now())
+		}
+	}()
+}
+
+// expireJobs forgets the jobs done more than JOB_TTL ago
+func expireJobs(now time.Time) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	for id, job := range jobs.byID {
+		job.mu.Lock()
+		expired := job.state == jobDone && now.Sub(job.finished) > JOB_TTL
+		job.mu.Unlock()
+		if expired {
+			delete(jobs.byID, id)
+			Metrics.jobsHeld.WithLabelValues(jobDone).Dec()
+		}
+	}
+}
+
+// submitJob holds a job and queues it, false if JOB_MAX are held already
+func submitJob(t *tenant, q pingAreaQuery) (*areaJob, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &areaJob{id: randomHex(16), tenant: t.name, query: q, ctx: ctx, cancel: cancel, state: jobQueued, submitted: time.Now()}
+
+	jobs.Lock()
+	defer jobs.Unlock()
+	if len(jobs.byID) >= JOB_MAX {
+		cancel()
+		Metrics.jobsTotal.WithLabelValues("rejected").Inc()
+		return nil, false
+	}
+	select {
+	case jobs.queue <- job:
+	default: // the queue still holds jobs deleted while queued
+		cancel()
+		Metrics.jobsTotal.WithLabelValues("rejected").Inc()
+		return nil, false
+	}
+	jobs.byID[job.id] = job
+	Metrics.jobsHeld.WithLabelValues(jobQueued).Inc()
+	return job, true
+}
+
+// run queries the chunks of a job's cover set one after the other, until they are done or the job is cancelled
+func (job *areaJob) run() {
+	cover := job.query.bbox().Cover(job.query.precUsed)
+	chunk := max(1, JOB_CHUNK_GEOHASHES)
+	job.mu.Lock()
+	if job.ctx.Err() != nil { // deleted while queued
+		job.mu.Unlock()
+		return
+	}
+	job.state, job.started = jobRunning, time.Now()
+	job.chunks = (len(cover) + chunk - 1) / chunk
+	job.mu.Unlock()
+	Metrics.jobsHeld.WithLabelValues(jobQueued).Dec()
+	Metrics.jobsHeld.WithLabelValues(jobRunning).Inc()
+
+	counts := make(map[string]*ExtendedPingAreaCount)
+	for from := 0; from < len(cover) && job.ctx.Err() == nil; from += chunk {
+		plan := planner.planCover(job.query, cover[from:min(from+chunk, len(cover))])
+		if job.query.historyOffset > 0 {
+			for _, call := range plan.Shards {
+				call.Pruned = false // the coverage hints describe the live window (see baselinePlan)
+			}
+		}
+		for gh, c := range plan.execute(job.ctx) {
+			counts[gh] = c // the cells of the chunks are disjoint
+		}
+
+		job.mu.Lock()
+		job.chunksDone++
+		job.partial = job.partial || plan.partial()
+		job.mu.Unlock()
+	}
+
+	Metrics.jobsHeld.WithLabelValues(jobRunning).Dec()
+	job.mu.Lock()
+	if job.ctx.Err() != nil { // deleted while running
+		job.mu.Unlock()
+		return
+	}
+	job.state, job.finished, job.counts = jobDone, time.Now(), counts
+	job.mu.Unlock()
+	Metrics.jobsHeld.WithLabelValues(jobDone).Inc()
+	Metrics.jobsTotal.WithLabelValues("done").Inc()
+	log.Printf("job %s done: %d chunks, %d cells in %s", job.id, job.chunks, len(counts), job.finished.Sub(job.started))
+}
+
+func (job *areaJob) status() jobStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	s := jobStatus{
+		ID: job.id, State: job.state, Chunks: job.chunks, ChunksDone: job.chunksDone, Cells: job.query.estimated,
+		Complete: !job.partial, SubmittedAt: job.submitted.UnixMilli(),
+	}
+	if job.chunks > 0 {
+		s.Progress = float64(job.chunksDone) / float64(job.chunks)
+	}
+	if !job.started.IsZero() {
+		s.StartedAt = job.started.UnixMilli()
+	}
+	if !job.finished.IsZero() {
+		s.FinishedAt = job.finished.UnixMilli()
+	}
+	return s
+}
+
+// jobFor returns the job of a request's {id}, nil (and answered 404) if it has none or the job is another tenant's
+func jobFor(w http.ResponseWriter, r *http.Request) *areaJob {
+	jobs.Lock()
+	job := jobs.byID[chi.URLParam(r, "id")]
+	jobs.Unlock()
+	if job == nil || job.tenant != tenantFor(r).name {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Job not found"))
+		return nil
+	}
+	return job
+}
+
+func postAreaQueryJob(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q, status, msg := parsePingAreaQueryWithin(query, JOB_MAX_GEOHASHES)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+		return
+	}
+	if query.Get("compare") != "" || query.Get("staleOk") != "" || query.Get("explain") != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Jobs take neither compare, staleOk nor explain"))
+		return
+	}
+	var offset int64
+	if v := query.Get("historyOffset"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second || d%time.Second != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid historyOffset (whole seconds)"))
+			return
+		}
+		if q.smooth > 0 || q.movement || q.hasFloor {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("historyOffset only counts pings (not with smooth, metrics or floor)"))
+			return
+		}
+		offset = int64(d.Seconds())
+	}
+
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(q.bbox(), q.precision) {
+		denyACL(w, t)
+		return
+	}
+	if !retentionFor(t).allowsHistory(offset) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("historyOffset beyond the history retention of this API key"))
+		return
+	}
+	if !admitUsage(w, r, unitCells, q.estimated) {
+		return
+	}
+
+	q = retentionFor(t).limit(q)
+	q.historyOffset = offset
+	job, ok := submitJob(t, q)
+	if !ok {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Too many jobs held by this gateway (JOB_MAX)"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.status())
+}
+
+func getJob(w http.ResponseWriter, r *http.Request) {
+	job := jobFor(w, r)
+	if job == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.status())
+}
+
+func getJobResult(w http.ResponseWriter, r *http.Request) {
+	job := jobFor(w, r)
+	if job == nil {
+		return
+	}
+	format, ok := parseFormat(r.URL.Query(), formatCSV, formatParquet)
+	if !ok || (format != formatJSON && job.query.movement) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid format (json, csv or parquet, which only export counts)"))
+		return
+	}
+	s := job.status()
+	if s.State != jobDone {
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("Job %s (%.0f%%)", s.State, s.Progress*100)))
+		return
+	}
+
+	t := tenantFor(r)
+	counts := privacyFor(t).suppress(t, copyCounts(job.counts))
+	w.Header().Set("X-Precision-Used", strconv.Itoa(job.query.precision))
+	if format != formatJSON {
+		writeTable(w, format, "areaQuery-"+job.id, countsTable(counts))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("download") == "true" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="areaQuery-%s.json"`, job.id))
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"id": job.id, "usedPrecision": job.query.precision, "counts": counts, "complete": s.Complete})
+}
+
+func deleteJob(w http.ResponseWriter, r *http.Request) {
+	job := jobFor(w, r)
+	if job == nil {
+		return
+	}
+	jobs.Lock()
+	_, held := jobs.byID[job.id]
+	delete(jobs.byID, job.id)
+	jobs.Unlock()
+	if !held {
+		w.WriteHeader(http.StatusNoContent) // deleted concurrently
+		return
+	}
+
+	job.mu.Lock()
+	job.cancel()
+	state := job.state
+	job.mu.Unlock()
+	if state == jobDone {
+		Metrics.jobsHeld.WithLabelValues(jobDone).Dec()
+	} else {
+		if state == jobQueued {
+			Metrics.jobsHeld.WithLabelValues(jobQueued).Dec()
+		}
+		Metrics.jobsTotal.WithLabelValues("cancelled").Inc()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJobQueriesTheCoverInChunks(t *testing.T) {
+	previous, previousChunk := planner, JOB_CHUNK_GEOHASHES
+	t.Cleanup(func() { planner, JOB_CHUNK_GEOHASHES = previous, previousChunk })
+	workers := fakeWorkers{"a": {count: 2}, "b": {count: 5}}
+	planner = newGatewayService(fakeRing{owners: []string{"a"}, servers: []string{"a", "b"}}, workers).planner
+	JOB_CHUNK_GEOHASHES = 2
+
+	q, err := planner.QueryWithin(42, 43, -9, -8, 3, JOB_MAX_GEOHASHES)
+	if err != nil {
+		t.Fatalf("QueryWithin: %v", err)
+	}
+	cover := q.bbox().Cover(q.precUsed)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := &areaJob{id: "j", query: q, ctx: ctx, cancel: cancel, state: jobQueued, submitted: time.Now()}
+	job.run()
+
+	s := job.status()
+	chunks := (len(cover) + 1) / 2
+	if s.State != jobDone || s.Chunks != chunks || s.ChunksDone != chunks || s.Progress != 1 || !s.Complete {
+		t.Fatalf("got %+v, want %d chunks done", s, chunks)
+	}
+	if workers["a"].areaCalls != chunks || workers["b"].areaCalls != chunks {
+		t.Fatalf("workers asked %d and %d times, want %d", workers["a"].areaCalls, workers["b"].areaCalls, chunks)
+	}
+	if len(job.counts) != len(cover) {
+		t.Fatalf("got %d cells for a cover of %d", len(job.counts), len(cover))
+	}
+	for gh, c := range job.counts {
+		if c.Count != 7 {
+			t.Errorf("%s: got %d, want 7", gh, c.Count)
+		}
+	}
+}
+
+func TestJobDeletedWhileQueuedDoesNotRun(t *testing.T) {
+	previous := planner
+	t.Cleanup(func() { planner = previous })
+	workers := fakeWorkers{"a": {count: 2}}
+	planner = newGatewayService(fakeRing{owners: []string{"a"}, servers: []string{"a"}}, workers).planner
+
+	q, err := planner.QueryWithin(42, 43, -9, -8, 3, JOB_MAX_GEOHASHES)
+	if err != nil {
+		t.Fatalf("QueryWithin: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &areaJob{id: "j", query: q, ctx: ctx, cancel: cancel, state: jobQueued, submitted: time.Now()}
+	cancel()
+	job.run()
+	if s := job.status(); s.State != jobQueued || workers["a"].areaCalls != 0 {
+		t.Fatalf("cancelled job ran: %+v, %d calls", s, workers["a"].areaCalls)
+	}
+}
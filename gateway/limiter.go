@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"geostreamdb/env"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// per-worker concurrency limit: at most WORKER_MAX_INFLIGHT RPCs in flight per worker connection, with up to
+// WORKER_MAX_QUEUE callers waiting for a slot (bounded by their own deadline). beyond that, calls fail fast so a single
+// slow worker can't absorb every gateway goroutine during a broadcast query
+var WORKER_MAX_INFLIGHT = env.Int("WORKER_MAX_INFLIGHT", 128)
+var WORKER_MAX_QUEUE = env.Int("WORKER_MAX_QUEUE", 64)
+
+type workerLimiter struct {
+	worker string
+	slots  chan struct{} // buffered semaphore (capacity = max in-flight)
+	queued atomic.Int64
+}
+
+func newWorkerLimiter(worker string) *workerLimiter {
+	return &workerLimiter{worker: worker, slots: make(chan struct{}, max(WORKER_MAX_INFLIGHT, 1))}
+}
+
+func (l *workerLimiter) acquire(ctx context.Context) error {
+	// fast path: free slot
+	select {
+	case l.slots <- struct{}{}:
+		Metrics.workerInflight.WithLabelValues(l.worker).Inc()
+		return nil
+	default:
+	}
+
+	if l.queued.Add(1) > int64(WORKER_MAX_QUEUE) {
+		l.queued.Add(-1)
+		Metrics.workerRejectedTotal.WithLabelValues(l.worker).Inc()
+		return status.Error(codes.ResourceExhausted, "worker queue full")
+	}
+	Metrics.workerQueued.WithLabelValues(l.worker).Inc()
+	defer func() {
+		l.queued.Add(-1)
+		Metrics.workerQueued.WithLabelValues(l.worker).Dec()
+	}()
+
+	select {
+	case l.slots <- struct{}{}:
+		Metrics.workerInflight.WithLabelValues(l.worker).Inc()
+		return nil
+	case <-ctx.Done():
+		Metrics.workerRejectedTotal.WithLabelValues(l.worker).Inc()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func (l *workerLimiter) release() {
+	<-l.slots
+	Metrics.workerInflight.WithLabelValues(l.worker).Dec()
+}
+
+func (l *workerLimiter) unaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+	defer l.release()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func deleteWorkerLimiterMetrics(worker string) {
+	Metrics.workerInflight.DeleteLabelValues(worker)
+	Metrics.workerQueued.DeleteLabelValues(worker)
+	Metrics.workerRejectedTotal.DeleteLabelValues(worker)
+	Metrics.workerClockSkew.DeleteLabelValues(worker)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ingest (POST /ping) and query (GET /ping, GET /pingArea) routes can each be served on a dedicated port with their own
+// bearer token and rate limit, so public device ingest is isolated from internal analytics queries. routes without a
+// dedicated port stay on PORT (which always serves /metrics and /admin); tokens and limits apply either way
+var ingestGroup = &routeGroup{
+	name:    "ingest",
+	port:    os.Getenv("INGEST_PORT"),
+	token:   os.Getenv("INGEST_TOKEN"),
+	limiter: newRateLimiter(env.Int("INGEST_RATE_LIMIT", 0)),
+	routes:  ingestRoutes,
+}
+
+var queryGroup = &routeGroup{
+	name:    "query",
+	port:    os.Getenv("QUERY_PORT"),
+	token:   os.Getenv("QUERY_TOKEN"),
+	limiter: newRateLimiter(env.Int("QUERY_RATE_LIMIT", 0)),
+	routes:  queryRoutes,
+}
+
+type routeGroup struct {
+	name    string
+	port    string       // dedicated listener port ("" = served on PORT)
+	token   string       // required as "Authorization: Bearer <token>" ("" = open)
+	limiter *rateLimiter // nil = unlimited
+	routes  func(chi.Router)
+}
+
+func (g *routeGroup) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+g.token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+		if !g.limiter.allow() || (DEMO_MODE && !allowDemoClient(r)) {
+			Metrics.rateLimitedTotal.WithLabelValues(g.name).Inc()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte("Rate limit exceeded"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveDedicatedListeners starts a listener for every route group with its own port
+func serveDedicatedListeners() {
+	for _, g := range []*routeGroup{ingestGroup, queryGroup} {
+		if g.port == "" {
+			continue
+		}
+		go serveHTTP(g.name+" listener", g.port, newRouter(g))
+	}
+}
+
+// token bucket shared by every request of a route group (burst = one second worth of requests)
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(perSecond), tokens: float64(perSecond), last: time.Now()}
+}
+
+func (l *rateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
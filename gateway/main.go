@@ -2,12 +2,13 @@ package main
 
 import (
 	"log"
-	"net/http"
 	"os"
 	"time"
 )
 
 func main() {
+	log.Printf("gateway %s (commit %s)", build.Version, build.Commit)
+
 	// (grpc client) heartbeats to registry for service discovery
 	registryAddress := os.Getenv("REGISTRY_ADDRESS")
 	if registryAddress == "" {
@@ -15,6 +16,7 @@ func main() {
 	}
 	conn, client := new_grpc_client(registryAddress)
 	defer conn.Close()
+	fetchClusterSettings(client)
 	go send_heartbeat(client, registryAddress)
 
 	// (grpc server) heartbeat communication
@@ -23,15 +25,34 @@ func main() {
 	cleanup_ttl := 10 * time.Second
 	go state.cleanupDeadNodes(cleanup_ttl, cleanup_ttl/2)
 
+	openStore()
+	loadTenants()
+	loadACLs()
+	loadIngestHooks()
+	loadZones()
+	loadRetention()
+	loadRegions()
+	startSLO() // before any request is served
+	applyDemoMode()
+
 	// (http server) ping reception -> (grpc client) forwarding to worker nodes
+	serveDedicatedListeners()
+	startAsyncIngest()
+	startShadowing()
+	startGeocoding()
+	startJobs()
+	startDemoSeeding()
+	startPublishing()
+	startCanary()
+	go setup_udp_listener()
+	go setup_coap_listener()
+	go setup_resp_listener()
 	router := setup_router()
 
 	httpPort := os.Getenv("PORT")
 	if httpPort == "" {
 		httpPort = "8080"
 	}
-	log.Printf("HTTP server listening on port %s", httpPort)
-	if err := http.ListenAndServe(":"+httpPort, router); err != nil {
-		log.Fatalf("failed to serve: %v", err)
-	}
+	go serveHTTP("server", httpPort, router)
+	waitForShutdown()
 }
@@ -1,17 +1,82 @@
 package main
 
 import (
+	"geostreamdb/rpc"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type metrics struct {
-	httpRequestsTotal    *prometheus.CounterVec   // per endpoint and status
-	httpLatency          *prometheus.HistogramVec // per endpoint
-	workerNodesTotal     prometheus.Gauge
-	gRPCRequestsTotal    *prometheus.CounterVec   // per worker node and result (success/failure)
-	gRPCLatency          *prometheus.HistogramVec // per worker node and method
-	geohashRequestsTotal *prometheus.CounterVec   // per worker node
+	httpRequestsTotal          *prometheus.CounterVec   // per endpoint and status
+	httpLatency                *prometheus.HistogramVec // per endpoint
+	workerNodesTotal           prometheus.Gauge
+	gRPCRequestsTotal          *prometheus.CounterVec   // per worker node and result (success/failure)
+	gRPCLatency                *prometheus.HistogramVec // per worker node and method
+	geohashRequestsTotal       *prometheus.CounterVec   // per worker node, type and reason
+	hedgedRequestsTotal        *prometheus.CounterVec   // per method and outcome (sent/won)
+	workerInflight             *prometheus.GaugeVec     // per worker node
+	workerQueued               *prometheus.GaugeVec     // per worker node
+	workerRejectedTotal        *prometheus.CounterVec   // per worker node
+	workerClockSkew            *prometheus.GaugeVec     // per worker node
+	tenantUsageTotal           *prometheus.CounterVec   // per tenant, endpoint and unit (pings/cells)
+	tenantRefundedTotal        *prometheus.CounterVec   // per tenant, endpoint and unit
+	quotaRejectedTotal         *prometheus.CounterVec   // per tenant and endpoint
+	rateLimitedTotal           *prometheus.CounterVec   // per route group (ingest/query)
+	udpPingsTotal              *prometheus.CounterVec   // per result
+	coapRequestsTotal          *prometheus.CounterVec   // per result
+	coapObservers              prometheus.Gauge
+	respCommandsTotal          *prometheus.CounterVec // per command, result
+	respClients                prometheus.Gauge
+	workerCellCost             *prometheus.GaugeVec   // per worker node
+	areaBudgetTotal            *prometheus.CounterVec // per action (coarsened/rejected)
+	workerAPIVersion           *prometheus.GaugeVec   // per worker node
+	incompatibleWorkers        *prometheus.CounterVec // per worker node
+	consistencyTotal           *prometheus.CounterVec // per method and result (achieved/failed)
+	asyncPingsTotal            *prometheus.CounterVec // per result
+	aclDeniedTotal             *prometheus.CounterVec // per tenant
+	privacySuppressed          *prometheus.CounterVec // per tenant
+	deviceDeletionsTotal       *prometheus.CounterVec // per complete (true/false)
+	publishTotal               *prometheus.CounterVec // per result
+	areaSingleflight           *prometheus.CounterVec // per result (executed/shared)
+	readTokenTotal             *prometheus.CounterVec // per result (reached/waited/gone/not_reached)
+	shadowPingsTotal           *prometheus.CounterVec // per shadow worker and result (sent/failed/dropped)
+	signedPingsTotal           *prometheus.CounterVec // per result (valid/unsigned/missing/expired/invalid/replayed/unknown_device)
+	ingestFilteredTotal        *prometheus.CounterVec // per reason (ip_denied/ip_not_allowed/outside_fence)
+	teleportChecksTotal        *prometheus.CounterVec // per result (plausible/drop/flag/tag/unchecked)
+	streamClients              prometheus.Gauge
+	buildInfo                  *prometheus.GaugeVec // per version, commit and go version (always 1)
+	workerBuildMismatch        *prometheus.GaugeVec // per worker node
+	workerDraining             *prometheus.GaugeVec // per worker node
+	settingsStale              prometheus.Gauge
+	shardingMigration          prometheus.Gauge         // 1 while reads go to the shards at both precisions
+	canaryProbesTotal          *prometheus.CounterVec   // per worker node and result (ok/failed)
+	canaryDuration             *prometheus.HistogramVec // per worker node, successful probes
+	sloBurnRate                *prometheus.GaugeVec     // per objective and window
+	sloErrorBudget             *prometheus.GaugeVec     // per objective
+	shedTotal                  *prometheus.CounterVec   // per class (ingest/point/area) and tier (keyed/anonymous)
+	shedInflight               prometheus.Gauge
+	shedQueued                 prometheus.Gauge
+	shedLevel                  prometheus.Gauge         // priorities being shed
+	routingDecisions           *prometheus.CounterVec   // per mode and reason, area queries and broadcasts
+	routingFanout              *prometheus.HistogramVec // per mode
+	workerCapacity             *prometheus.GaugeVec     // per worker node and resource, from heartbeats
+	sentAtIgnoredTotal         *prometheus.CounterVec   // per reason (future/too_old)
+	workerHeartbeatsMissed     *prometheus.CounterVec   // per worker node
+	workerHeartbeatsOutOfOrder *prometheus.CounterVec   // per worker node
+	workerHeartbeatLoss        *prometheus.GaugeVec     // per worker node
+	warmConnsTotal             *prometheus.CounterVec   // per result (ready/timeout/failed)
+	workerChannels             *prometheus.GaugeVec     // per connectivity state
+	staleReadsTotal            *prometheus.CounterVec   // per endpoint and result (fresh/stale/miss)
+	globalStatsTotal           *prometheus.CounterVec   // per result (cached/refreshed/failed)
+	geocodeLookups             *prometheus.CounterVec   // per result (cached/queued/dropped)
+	geocodeRequests            *prometheus.CounterVec   // per result (place/no_place/failed)
+	jobsTotal                  *prometheus.CounterVec   // async jobs per result (done/cancelled/rejected)
+	jobsHeld                   *prometheus.GaugeVec     // per state (queued/running/done)
+	ingestHooksTotal           *prometheus.CounterVec   // per hook and result (kept/dropped/rejected)
+	writeBudgetTotal           *prometheus.CounterVec   // per result (within/exceeded/late/requeued/failed)
+	restarts                   prometheus.Gauge
 }
 
 var Metrics = metrics{
@@ -39,6 +104,266 @@ var Metrics = metrics{
 	}, []string{"method", "worker_node"}),
 	geohashRequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "gateway_geohash_requests_total",
-		Help: "Requests routed per worker node and type (routed/broadcast)",
-	}, []string{"worker_node", "type"}),
+		Help: "Requests routed per worker node, type (routed/broadcast/pruned) and reason (shard_owner/read_token, agg_precision/no_owner/unsharded, coverage_hint)",
+	}, []string{"worker_node", "type", "reason"}),
+	hedgedRequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_hedged_requests_total",
+		Help: "Hedged read requests per method and outcome (sent: hedge issued, won: hedge answered first)",
+	}, []string{"method", "outcome"}),
+	workerInflight: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_inflight_requests",
+		Help: "In-flight gRPC requests per worker node",
+	}, []string{"worker_node"}),
+	workerQueued: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_queued_requests",
+		Help: "gRPC requests waiting for a concurrency slot per worker node",
+	}, []string{"worker_node"}),
+	workerRejectedTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_worker_rejected_requests_total",
+		Help: "gRPC requests rejected by the per-worker concurrency limiter (queue full or deadline exceeded while queued)",
+	}, []string{"worker_node"}),
+	workerClockSkew: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_clock_skew_seconds",
+		Help: "Worker clock minus gateway clock per worker node, from the last heartbeat (includes the heartbeat's one-way delay through the registry)",
+	}, []string{"worker_node"}),
+	tenantUsageTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_tenant_usage_total",
+		Help: "Usage accounted per tenant, endpoint and unit (pings: pings ingested, cells: cells queried)",
+	}, []string{"tenant", "endpoint", "unit"}),
+	tenantRefundedTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_tenant_usage_refunded_total",
+		Help: "Usage accounted per tenant, endpoint and unit then given back because the request failed (subtract from gateway_tenant_usage_total)",
+	}, []string{"tenant", "endpoint", "unit"}),
+	quotaRejectedTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_quota_rejected_requests_total",
+		Help: "Requests rejected because the tenant's monthly quota was exceeded, per tenant and endpoint",
+	}, []string{"tenant", "endpoint"}),
+	rateLimitedTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_rate_limited_requests_total",
+		Help: "Requests rejected by the route group rate limit, per group (ingest/query)",
+	}, []string{"group"}),
+	udpPingsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_udp_pings_total",
+		Help: "UDP ingest records per result (accepted/failed/malformed/bad_checksum/out_of_bounds/hooked_out/forbidden/unsigned/rate_limited/quota_exceeded/dropped)",
+	}, []string{"result"}),
+	coapRequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_coap_messages_total",
+		Help: "CoAP messages per result (created/content/notification/bad_request/forbidden/not_found/rate_limited/peer_limited/quota_exceeded/too_large/failed/malformed/duplicate/dropped/hook_dropped)",
+	}, []string{"result"}),
+	coapObservers: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_coap_observers",
+		Help: "Active CoAP /pingArea subscriptions (Observe)",
+	}),
+	respCommandsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_resp_commands_total",
+		Help: "Commands received on the RESP (Redis protocol) listener per command and result",
+	}, []string{"command", "result"}),
+	respClients: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_resp_clients",
+		Help: "Open RESP (Redis protocol) connections",
+	}),
+	workerCellCost: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_cell_cost_seconds",
+		Help: "Moving average of the GetPingArea time per cell (at the requested precision) per worker node",
+	}, []string{"worker_node"}),
+	areaBudgetTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_area_budget_total",
+		Help: "Area queries predicted over AREA_LATENCY_BUDGET by action (coarsened/rejected)",
+	}, []string{"action"}),
+	workerAPIVersion: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_api_version",
+		Help: "Protocol version negotiated with each worker node from its heartbeats",
+	}, []string{"worker_node"}),
+	incompatibleWorkers: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_incompatible_heartbeats_total",
+		Help: "Worker heartbeats refused for an api version range not overlapping the gateway's, per worker node",
+	}, []string{"worker_node"}),
+	consistencyTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_consistency_requests_total",
+		Help: "QUORUM/ALL consistency requests per method (SendPing/GetPings) and result (achieved/failed)",
+	}, []string{"method", "result"}),
+	asyncPingsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_async_pings_total",
+		Help: "ack=none pings per result (sent/duplicate/retried/failed in the background, rejected with a full queue)",
+	}, []string{"result"}),
+	aclDeniedTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_acl_denied_total",
+		Help: "Requests rejected for falling outside the tenant's ACL regions (ACL_FILE), per tenant",
+	}, []string{"tenant"}),
+	privacySuppressed: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_privacy_suppressed_cells_total",
+		Help: "Cells (or point/polygon counts) left out of responses for counting fewer than the tenant's k (PRIVACY), per tenant",
+	}, []string{"tenant"}),
+	deviceDeletionsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_device_deletions_total",
+		Help: "DELETE /admin/device/{id} requests per outcome (complete true/false)",
+	}, []string{"complete"}),
+	publishTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_publish_rounds_total",
+		Help: "Published cell count rounds (PUBLISH_PREFIXES) per result (written/failed remote writes, truncated to PUBLISH_MAX_SERIES)",
+	}, []string{"result"}),
+	streamClients: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_stream_clients",
+		Help: "Open GET /pingArea/stream server-sent event streams",
+	}),
+	buildInfo: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_build_info",
+		Help: "Build of this gateway (always 1)",
+	}, []string{"version", "commit", "goversion"}),
+	workerBuildMismatch: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_build_incompatible",
+		Help: "1 if the release a worker node announces is incompatible with the gateway's (VERSION_MAX_MINOR_SKEW)",
+	}, []string{"worker_node"}),
+	workerDraining: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_draining",
+		Help: "1 while a worker node is drained by a rolling restart (keys routed to the next worker)",
+	}, []string{"worker_node"}),
+	shadowPingsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_shadow_pings_total",
+		Help: "Pings mirrored to the canary workers (SHADOW_WORKERS) per worker node and result (sent/failed/dropped)",
+	}, []string{"worker_node", "result"}),
+	readTokenTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_read_token_reads_total",
+		Help: "GET /ping reads with a read token per result (reached at once, waited for, gone worker, not_reached within READ_TOKEN_WAIT)",
+	}, []string{"result"}),
+	areaSingleflight: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_area_singleflight_total",
+		Help: "Area query executions per result (executed against the workers, shared: joined an identical query in flight)",
+	}, []string{"result"}),
+	signedPingsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_signed_pings_total",
+		Help: "POST /ping signature checks per result (valid, unsigned: unlisted device, others refused with 401)",
+	}, []string{"result"}),
+	ingestFilteredTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_ingest_filtered_total",
+		Help: "Pings refused by the ingest filters (INGEST_*_CIDRS, INGEST_FENCE_FILE) per reason, on every listener",
+	}, []string{"reason"}),
+	teleportChecksTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_teleport_checks_total",
+		Help: "Teleport checks of pings with a device id (TELEPORT_ACTION) per result (plausible, drop/flag/tag: a teleport handled so, unchecked: owner unreachable)",
+	}, []string{"result"}),
+	settingsStale: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_cluster_settings_stale",
+		Help: "1 while the registry distributes other cluster settings than the ones this gateway started with (restart to apply)",
+	}),
+	shardingMigration: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_sharding_migration_active",
+		Help: "1 while a sharding migration is running (reads go to the shards at both precisions)",
+	}),
+	canaryProbesTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_canary_probes_total",
+		Help: "Canary probes (a ping written to the worker and read back) per worker node and result (ok/failed)",
+	}, []string{"worker_node", "result"}),
+	canaryDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_canary_duration_seconds",
+		Help:    "End-to-end duration of successful canary probes (write and read back) per worker node",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"worker_node"}),
+	sloBurnRate: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_slo_burn_rate",
+		Help: "Error budget burn rate per objective (availability/latency) and window (1 = spending the budget exactly over SLO_PERIOD)",
+	}, []string{"slo", "window"}),
+	sloErrorBudget: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_slo_error_budget_remaining",
+		Help: "Share of the error budget of SLO_PERIOD left per objective (negative once overspent)",
+	}, []string{"slo"}),
+	shedTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_shed_requests_total",
+		Help: "Requests answered 503 by the load shedder per class (ingest/point/area) and tier (keyed/anonymous)",
+	}, []string{"class", "tier"}),
+	shedInflight: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_shed_inflight",
+		Help: "Requests admitted by the load shedder and running",
+	}),
+	shedQueued: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_shed_queued",
+		Help: "Requests waiting for a slot of the load shedder",
+	}),
+	shedLevel: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_shed_level",
+		Help: "Priorities the load shedder is shedding (0 = none, 1 = anonymous area queries, up to 5 = all but keyed ingest)",
+	}),
+	routingDecisions: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_routing_decisions_total",
+		Help: "Area queries and broadcasts per mode (routed/broadcast) and reason (shard_owner, agg_precision/no_owner/ring_empty/unsharded)",
+	}, []string{"mode", "reason"}),
+	routingFanout: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_routing_fanout_workers",
+		Help:    "Workers (replica chains when routed) asked per area query or broadcast, pruned ones excluded, per mode",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"mode"}),
+	workerCapacity: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_capacity",
+		Help: "Latest resource sample a worker sent in its heartbeats, per worker node and resource (resident_bytes/heap_bytes/memory_limit_bytes/cpu_cores/max_procs/goroutines/buffer_bytes/replica_buffer_bytes)",
+	}, []string{"worker_node", "resource"}),
+	sentAtIgnoredTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_sent_at_ignored_total",
+		Help: "Client send times (sentAt) of POST /ping ignored for end-to-end latency per reason (future/too_old), the ping itself is stored",
+	}, []string{"reason"}),
+	workerHeartbeatsMissed: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_worker_heartbeats_missed_total",
+		Help: "Worker heartbeats lost on the way to this gateway (gaps in their sequence numbers), per worker node",
+	}, []string{"worker_node"}),
+	workerHeartbeatsOutOfOrder: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_worker_heartbeats_out_of_order_total",
+		Help: "Worker heartbeats received after a later one (or repeated), per worker node",
+	}, []string{"worker_node"}),
+	workerHeartbeatLoss: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_heartbeat_loss_ratio",
+		Help: "Share of a worker's heartbeats missed over about its last 100, per worker node",
+	}, []string{"worker_node"}),
+	warmConnsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_warm_connections_total",
+		Help: "Connections dialed to workers as they joined the ring, per result (ready/timeout/failed within WARM_CONN_TIMEOUT)",
+	}, []string{"result"}),
+	workerChannels: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_worker_channels",
+		Help: "Pooled worker connections per connectivity state (ready/connecting/idle/transient_failure)",
+	}, []string{"state"}),
+	staleReadsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_stale_reads_total",
+		Help: "staleOk reads per endpoint and result (fresh: answered by the workers, stale: last answer served, miss: none within MAX_STALENESS)",
+	}, []string{"endpoint", "result"}),
+	globalStatsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_global_stats_total",
+		Help: "GET /stats/global requests per result (cached: served the total of the last GLOBAL_STATS_TTL, refreshed: asked the workers, failed)",
+	}, []string{"result"}),
+	geocodeLookups: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_geocode_lookups_total",
+		Help: "Place lookups of ingested pings per result (cached: cell resolved or queued already, queued, dropped: GEOCODE_QUEUE full)",
+	}, []string{"result"}),
+	geocodeRequests: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_geocode_requests_total",
+		Help: "Reverse geocoder requests per result (place, no_place: nothing there, failed)",
+	}, []string{"result"}),
+	jobsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_jobs_total",
+		Help: "Async area query jobs per result (done, cancelled: deleted before done, rejected: JOB_MAX held already)",
+	}, []string{"result"}),
+	jobsHeld: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_jobs",
+		Help: "Async area query jobs held per state (queued, running, done: kept for JOB_TTL)",
+	}, []string{"state"}),
+	ingestHooksTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_ingest_hooks_total",
+		Help: "Pings through each ingest hook, per hook and result (kept/dropped/rejected)",
+	}, []string{"hook", "result"}),
+	writeBudgetTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_write_budget_total",
+		Help: "POST /ping writes under WRITE_BUDGET per result (within, exceeded: answered 202, then late, requeued or failed in the background)",
+	}, []string{"result"}),
+	restarts: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_restarts",
+		Help: "Starts of this gateway under its saved gateway id (GATEWAY_ID_FILE) after the first",
+	}),
+}
+
+// the calls served (gateway_grpc_server_requests_total, gateway_grpc_server_request_duration_seconds per service and
+// method). the calls a gateway makes are gateway_grpc_requests_total (see observeGRPC)
+var grpcInterceptors = rpc.NewInterceptors("gateway_grpc_server", rpc.ServiceMethod)
+
+// Go scheduler and GC runtime metrics, as in the other services (see worker-node/metrics.go)
+func init() {
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsScheduler, collectors.MetricsGC)))
 }
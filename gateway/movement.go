@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+
+	pb "geostreamdb/proto"
+)
+
+// movement: POST /ping takes an optional "speed" (meters per second, as reported by the device) and, with it, a
+// "heading" (degrees clockwise from north). they go along with the ping to its primary and replicas, which aggregate
+// them per cell next to the counts (see worker-node/movement.go): moving and stationary pings (STATIONARY_SPEED on the
+// workers) and the sums of their speeds and headings. GET /pingArea?metrics=speed adds them to every cell holding pings
+// with a speed, as {"moving", "stationary", "avgSpeed", "heading"}: the average speed of those pings and the mean
+// heading of the moving ones (left out if none had one). only the live window has them, so not with smooth or compare
+
+// maxPingSpeed bounds a reported speed (meters per second): anything faster is a broken sensor
+const maxPingSpeed = 1000
+
+type motionContextKey struct{}
+
+// parseMotion checks the speed and heading of a ping (nil if it has none)
+func parseMotion(speed, heading *float64) (*pb.Motion, string) {
+	if speed == nil {
+		if heading != nil {
+			return nil, "heading requires a speed"
+		}
+		return nil, ""
+	}
+	if math.IsNaN(*speed) || *speed < 0 || *speed > maxPingSpeed {
+		return nil, "Invalid speed"
+	}
+	m := &pb.Motion{Speed: *speed}
+	if heading != nil {
+		if math.IsNaN(*heading) || *heading < 0 || *heading >= 360 {
+			return nil, "Invalid heading (0 to 360)"
+		}
+		m.HasHeading, m.Heading = true, *heading
+	}
+	return m, ""
+}
+
+// withMotion carries the speed and heading of a ping to its SendPing calls
+func withMotion(ctx context.Context, m *pb.Motion) context.Context {
+	if m == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, motionContextKey{}, m)
+}
+
+// motionOf returns the speed and heading carried by ctx, nil if none
+func motionOf(ctx context.Context) *pb.Motion {
+	m, _ := ctx.Value(motionContextKey{}).(*pb.Motion)
+	return m
+}
+
+// cellMovement adds up the movement of a cell from the workers, encoded as averages
+type cellMovement struct {
+	moving, stationary, headed   int64
+	speedSum, headingX, headingY float64
+}
+
+func (m *cellMovement) add(v *pb.Movement) *cellMovement {
+	if m == nil {
+		m = &cellMovement{}
+	}
+	m.moving += v.Moving
+	m.stationary += v.Stationary
+	m.headed += v.Headed
+	m.speedSum += v.SpeedSum
+	m.headingX += v.HeadingX
+	m.headingY += v.HeadingY
+	return m
+}
+
+func (m *cellMovement) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Moving     int64    `json:"moving"`
+		Stationary int64    `json:"stationary"`
+		AvgSpeed   float64  `json:"avgSpeed"`          // meters per second
+		Heading    *float64 `json:"heading,omitempty"` // degrees clockwise from north
+	}{Moving: m.moving, Stationary: m.stationary}
+	if n := m.moving + m.stationary; n > 0 {
+		out.AvgSpeed = math.Round(m.speedSum/float64(n)*100) / 100
+	}
+	if m.headed > 0 && (m.headingX != 0 || m.headingY != 0) {
+		heading := math.Atan2(m.headingX, m.headingY)*180/math.Pi + 360
+		heading = math.Mod(math.Round(heading*10)/10, 360)
+		out.Heading = &heading
+	}
+	return json.Marshal(out)
+}
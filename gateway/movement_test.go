@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	pb "geostreamdb/proto"
+)
+
+func TestCellMovementAveragesAcrossWorkers(t *testing.T) {
+	// two workers: headings of 350 and 10 degrees average to north, not south
+	var m *cellMovement
+	for _, heading := range []float64{350, 10} {
+		sin, cos := math.Sincos(heading * math.Pi / 180)
+		m = m.add(&pb.Movement{Moving: 1, Stationary: 1, Headed: 1, SpeedSum: 10, HeadingX: sin, HeadingY: cos})
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"moving":2,"stationary":2,"avgSpeed":5,"heading":0}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+
+	b, _ = json.Marshal((*cellMovement)(nil).add(&pb.Movement{Stationary: 3, SpeedSum: 0.3}))
+	if want := `{"moving":0,"stationary":3,"avgSpeed":0.1}`; string(b) != want {
+		t.Errorf("without headings: got %s, want %s", b, want)
+	}
+}
+
+func TestParseMotion(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	if m, msg := parseMotion(f(12.5), f(90)); msg != "" || m.Speed != 12.5 || !m.HasHeading || m.Heading != 90 {
+		t.Errorf("got %v (%q)", m, msg)
+	}
+	if m, msg := parseMotion(nil, nil); m != nil || msg != "" {
+		t.Errorf("no motion: got %v (%q)", m, msg)
+	}
+	for _, bad := range [][2]*float64{{nil, f(90)}, {f(-1), nil}, {f(math.NaN()), nil}, {f(1), f(360)}, {f(1), f(-0.5)}} {
+		if _, msg := parseMotion(bad[0], bad[1]); msg == "" {
+			t.Errorf("speed %v heading %v accepted", bad[0], bad[1])
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// GET /nearest?lat=..&lng=..&k=..[&precision=..]: the k nearest non-empty cells around a point. the search starts with
+// the cells around the point's cell and doubles the searched square (as a /pingArea query, so only the owning workers
+// are asked) until the k-th nearest cell is closer than any cell left outside it, or the square reaches
+// MAX_PINGAREA_GEOHASHES cells. the square is clamped at the antimeridian (cells across it are not searched)
+var NEAREST_MAX_K = env.Int("NEAREST_MAX_K", 100)
+
+const nearestDefaultK = 10
+const nearestDefaultPrecision = 7
+
+type nearestCell struct {
+	Geohash   string  `json:"geohash"`
+	Count     int64   `json:"count"`
+	Latitude  float64 `json:"lat"` // cell center
+	Longitude float64 `json:"lng"`
+	Distance  float64 `json:"distanceMeters"` // to the cell center
+}
+
+type nearestResponse struct {
+	Cells        []nearestCell `json:"cells"`
+	SearchRadius float64       `json:"searchRadiusMeters"` // every cell within it was searched
+	Exhaustive   bool          `json:"exhaustive"`         // false if the search stopped at the size limit with fewer than k cells proven nearest
+}
+
+func getNearest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	lat, err1 := strconv.ParseFloat(query.Get("lat"), 64)
+	lng, err2 := strconv.ParseFloat(query.Get("lng"), 64)
+	if err1 != nil || err2 != nil || math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid latitude or longitude"))
+		return
+	}
+	k := nearestDefaultK
+	if kQ := query.Get("k"); kQ != "" {
+		n, err := strconv.Atoi(kQ)
+		if err != nil || n < 1 || n > NEAREST_MAX_K {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid k (1 to " + strconv.Itoa(NEAREST_MAX_K) + ")"))
+			return
+		}
+		k = n
+	}
+	precision := min(nearestDefaultPrecision, MAX_GH_PRECISION)
+	if precisionQ := query.Get("precision"); precisionQ != "" {
+		n, err := strconv.Atoi(precisionQ)
+		if err != nil || n < 1 || n > MAX_GH_PRECISION {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid precision"))
+			return
+		}
+		precision = n
+	}
+
+	t := tenantFor(r)
+	lonStep, latStep := geo.CellDimsDegrees(precision)
+	cells := make([]nearestCell, 0)
+	var radius float64
+	exhaustive := false
+	for rings := 1; ; rings *= 2 {
+		span := float64(rings) + 0.5
+		q, err := planner.Query(max(-90, lat-span*latStep), min(90, lat+span*latStep), max(-180, lng-span*lonStep), min(180, lng+span*lonStep), precision)
+		if err != nil {
+			break // too large: keep the last (complete) round
+		}
+		if !aclFor(t).allowsArea(q.bbox(), q.precision) {
+			if rings == 1 {
+				denyACL(w, t)
+				return
+			}
+			break // leaving the tenant's region: keep the last round too
+		}
+
+		if !admitUsage(w, r, unitCells, q.estimated) {
+			return
+		}
+
+		cells = cells[:0]
+		for gh, c := range privacyFor(t).suppress(t, queryPingArea(r.Context(), retentionFor(t).limit(q))) {
+			if c.Count <= 0 {
+				continue
+			}
+			cell, ok := geo.Decode(gh)
+			if !ok {
+				continue
+			}
+			cLat, cLng := cell.Center()
+			cells = append(cells, nearestCell{Geohash: gh, Count: c.Count, Latitude: cLat, Longitude: cLng, Distance: geo.HaversineMeters(lat, lng, cLat, cLng)})
+		}
+		sort.Slice(cells, func(i, j int) bool {
+			if cells[i].Distance != cells[j].Distance {
+				return cells[i].Distance < cells[j].Distance
+			}
+			return cells[i].Geohash < cells[j].Geohash
+		})
+
+		radius = searchedRadiusMeters(lat, lng, q)
+		if len(cells) >= k && cells[k-1].Distance <= radius {
+			exhaustive = true
+			break
+		}
+		if q.minLat == -90 && q.maxLat == 90 && q.minLng == -180 && q.maxLng == 180 {
+			exhaustive = true // searched everything
+			break
+		}
+	}
+
+	if len(cells) > k {
+		cells = cells[:k]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(nearestResponse{Cells: cells, SearchRadius: radius, Exhaustive: exhaustive})
+}
+
+// searchedRadiusMeters is the distance from the point to the closest edge of the searched box, ignoring the poles
+// (nothing lies beyond them). cells whose center is within it were all searched
+func searchedRadiusMeters(lat, lng float64, q pingAreaQuery) float64 {
+	radius := math.Inf(1)
+	if q.minLat > -90 {
+		radius = min(radius, geo.HaversineMeters(lat, lng, q.minLat, lng))
+	}
+	if q.maxLat < 90 {
+		radius = min(radius, geo.HaversineMeters(lat, lng, q.maxLat, lng))
+	}
+	// closest point of a meridian: sin(d/R) = cos(lat) * sin(dLng)
+	for _, edgeLng := range []float64{q.minLng, q.maxLng} {
+		dLng := math.Abs(edgeLng - lng)
+		if dLng < 90 {
+			radius = min(radius, geo.EarthRadiusMeters*math.Asin(math.Cos(geo.Deg2Rad(lat))*math.Sin(geo.Deg2Rad(dLng))))
+		}
+	}
+	if math.IsInf(radius, 1) {
+		return math.Pi * geo.EarthRadiusMeters // the whole globe
+	}
+	return radius
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+)
+
+// area queries go through three steps, each owned by the QueryPlanner:
+//   - Query: bbox + requested precision -> the aggregated precision the cover set is computed at (bounded in size)
+//   - Plan: cover set -> the shards to ask (grouped by replica chain when the cover is finer than the sharding precision,
+//     otherwise a broadcast to every worker not ruled out by its coverage hint)
+//   - Execute: the calls, merged into a single geohash -> count map. every shard's timing and error is kept in the plan,
+//     which is what GET /pingArea?explain=true and the slow query log show
+//
+// the planner of the GatewayService (see service.go) plans from its ring, and its plans call its workers
+type QueryPlanner struct {
+	ring    Ring
+	workers WorkerClients
+}
+
+const (
+	planRouted    = "routed"
+	planBroadcast = "broadcast"
+)
+
+// why a request went where it did (reason label of gateway_geohash_requests_total and gateway_routing_decisions_total)
+const (
+	reasonShardOwner   = "shard_owner"   // routed to the owners of the shard key
+	reasonReadToken    = "read_token"    // routed to the worker holding a read token's write
+	reasonAggPrecision = "agg_precision" // broadcast: the cells are coarser than the sharding precision, so span shards
+	reasonNoOwner      = "no_owner"      // broadcast: a cell had no shard owner to route to
+	reasonRingEmpty    = "ring_empty"    // no worker to ask at all
+	reasonUnsharded    = "unsharded"     // broadcast: the data isn't sharded by location (devices, worker info)
+	reasonCoverageHint = "coverage_hint" // pruned: the worker's coverage hint rules the cells out
+)
+
+type pingAreaQuery struct {
+	minLat, maxLat, minLng, maxLng float64
+	precision                      int   // requested precision
+	precUsed                       int   // aggregated precision the cover set is computed at
+	estimated                      int64 // cells at the requested precision
+	smooth                         int   // windows averaged by the workers (smooth=N, 0 = none)
+	historyOffset                  int64 // > 0: the window that ended that many seconds ago (compare baseline)
+	window                         int64 // > 0: newest seconds of the live window counted (tenant retention)
+	historyGranularity             int64 // > 0: span baselines are averaged over (tenant retention)
+	movement                       bool  // metrics=speed: the movement of each cell too (see movement.go)
+	hasFloor                       bool  // floor=N: only the pings of floor (see floors.go)
+	floor                          int32
+	frameSeconds                   int64 // > 0: the counts of frames of that many seconds instead (see frames.go)
+	frames                         int
+	framesHistory                  bool // frames may reach into the history tier
+}
+
+func (q pingAreaQuery) bbox() geo.Bbox {
+	return geo.Bbox{MinLat: q.minLat, MaxLat: q.maxLat, MinLng: q.minLng, MaxLng: q.maxLng}
+}
+
+// QueryPlan is the outcome of planning an area query, completed by Execute
+type QueryPlan struct {
+	query   pingAreaQuery
+	cover   []string
+	workers WorkerClients
+	Mode    string         `json:"mode"`   // routed/broadcast
+	Reason  string         `json:"reason"` // why (see reasonShardOwner...)
+	Shards  []*PlannedCall `json:"shards"`
+	Took    float64        `json:"durationMs"` // of Execute
+}
+
+// PlannedCall is a GetPingArea call to one shard (routed: a replica chain, broadcast: a worker)
+type PlannedCall struct {
+	Workers    []string `json:"workers"` // primary first
+	geohashes  []string
+	Geohashes  int     `json:"geohashes"`
+	Pruned     bool    `json:"pruned,omitempty"`     // skipped by its coverage hint
+	Worker     string  `json:"worker,omitempty"`     // that answered
+	DurationMs float64 `json:"durationMs,omitempty"` // including hedged attempts
+	Error      string  `json:"error,omitempty"`
+}
+
+// Query bounds the cells of a valid bbox at the requested precision and picks the aggregated precision.
+// errAreaTooLarge or errAreaTooSmall if there is none
+func (p QueryPlanner) Query(minLat, maxLat, minLng, maxLng float64, precision int) (pingAreaQuery, error) {
+	return p.QueryWithin(minLat, maxLat, minLng, maxLng, precision, MAX_PINGAREA_GEOHASHES)
+}
+
+// QueryWithin is Query with another bound than MAX_PINGAREA_GEOHASHES on the cells, for routes that only return an
+// aggregate of them
+func (QueryPlanner) QueryWithin(minLat, maxLat, minLng, maxLng float64, precision int, maxCells int64) (pingAreaQuery, error) {
+	// safety check: bound how many cells the query precision would create for this bbox
+	bbox := geo.Bbox{MinLat: minLat, MaxLat: maxLat, MinLng: minLng, MaxLng: maxLng}
+	estimated, _, _ := bbox.CoverCount(precision)
+	if estimated > maxCells {
+		return pingAreaQuery{}, errAreaTooLarge
+	}
+
+	precUsed, _, _, ok := bbox.AggregatedPrecision(precision, MAX_GH_PRECISION)
+	if !ok {
+		return pingAreaQuery{}, errAreaTooSmall
+	}
+
+	return pingAreaQuery{minLat: minLat, maxLat: maxLat, minLng: minLng, maxLng: maxLng, precision: precision, precUsed: precUsed, estimated: estimated}, nil
+}
+
+// QueryFinestFitting is QueryWithin, downgrading the precision to the finest one within maxCells instead of failing
+// with errAreaTooLarge (autoPrecision=true)
+func (p QueryPlanner) QueryFinestFitting(minLat, maxLat, minLng, maxLng float64, precision int, maxCells int64) (pingAreaQuery, error) {
+	q, err := p.QueryWithin(minLat, maxLat, minLng, maxLng, precision, maxCells)
+	for precision > 1 && errors.Is(err, errAreaTooLarge) {
+		precision--
+		q, err = p.QueryWithin(minLat, maxLat, minLng, maxLng, precision, maxCells)
+	}
+	return q, err
+}
+
+// Plan computes the cover set of a query and the shards to ask for it
+func (p QueryPlanner) Plan(q pingAreaQuery) *QueryPlan {
+	return p.planCover(q, q.bbox().Cover(q.precUsed))
+}
+
+// planCover plans a query over part of its cover set (see jobs.go)
+func (p QueryPlanner) planCover(q pingAreaQuery, cover []string) *QueryPlan {
+	plan := &QueryPlan{query: q, cover: cover, workers: p.workers, Shards: make([]*PlannedCall, 0)}
+
+	s, now := sharding.Load(), time.Now().UnixMilli()
+	plan.Reason = reasonAggPrecision
+	if q.precUsed >= s.routedPrecision(now) {
+		// we can find shards responsible for these geohashes. find and group them
+		// (by replica chain, so that every group can be hedged to the same replica. during a sharding migration, a
+		// geohash goes to the shards at both precisions, their counts are added up)
+		plan.Mode, plan.Reason = planRouted, reasonShardOwner
+		byChain := make(map[string]*PlannedCall)
+		for _, geohash := range plan.cover {
+			chains := s.ownerChains(p.ring, geohash, now)
+			if len(chains) == 0 {
+				plan.Reason = reasonNoOwner
+				break
+			}
+			for _, targetAddrs := range chains {
+				key := strings.Join(targetAddrs, ",")
+				call := byChain[key]
+				if call == nil {
+					call = &PlannedCall{Workers: targetAddrs}
+					byChain[key] = call
+					plan.Shards = append(plan.Shards, call)
+				}
+				call.geohashes = append(call.geohashes, geohash)
+				call.Geohashes++
+			}
+		}
+		if plan.Reason == reasonShardOwner {
+			return plan
+		}
+		plan.Shards = plan.Shards[:0]
+	}
+
+	// geohashes will be spread across multiple shards (or some have no owner to route to). broadcast query to all nodes
+	// (minus those whose coverage hints rule them out). primary data only: every worker answers for its own shards, so
+	// there is nothing to hedge to
+	plan.Mode = planBroadcast
+	servers := p.ring.workerServers()
+	if len(servers) == 0 {
+		plan.Reason = reasonRingEmpty
+	}
+	for _, server := range servers {
+		plan.Shards = append(plan.Shards, &PlannedCall{
+			Workers:   []string{server},
+			geohashes: plan.cover,
+			Geohashes: len(plan.cover),
+			Pruned:    BROADCAST_PRUNING && !p.ring.mayHoldAny(server, plan.cover),
+		})
+	}
+	return plan
+}
+
+type ExtendedPingAreaCount struct {
+	Count    int64
+	Server   string
+	Place    *Place        `json:",omitempty"` // with places=true, see geocode.go
+	Movement *cellMovement `json:",omitempty"` // with metrics=speed, see movement.go
+}
+
+// ExtendedGetPingAreaResponse is the answer of one shard
+type ExtendedGetPingAreaResponse struct {
+	*pb.GetPingAreaResponse
+	Server string // TEST: to color geohash by server
+}
+
+// execute makes the planned calls in parallel and merges their counts (partial if some workers fail)
+func (plan *QueryPlan) execute(ctx context.Context) map[string]*ExtendedPingAreaCount {
+	start := time.Now()
+	results := plan.call(ctx)
+
+	// combine all results into a single map of geohash -> count
+	combined := make(map[string]*ExtendedPingAreaCount)
+	for _, result := range results {
+		for _, count := range result.Counts {
+			if _, exists := combined[count.Geohash]; !exists {
+				combined[count.Geohash] = &ExtendedPingAreaCount{Count: 0, Server: result.Server}
+			}
+			combined[count.Geohash].Count += count.Count
+			if count.Movement != nil {
+				combined[count.Geohash].Movement = combined[count.Geohash].Movement.add(count.Movement)
+			}
+		}
+	}
+
+	plan.Took = float64(time.Since(start).Microseconds()) / 1000
+	return combined
+}
+
+// call makes the planned calls in parallel, returning the answers of the shards that answered (the others have their
+// error in the plan)
+func (plan *QueryPlan) call(ctx context.Context) []*ExtendedGetPingAreaResponse {
+	q := plan.query
+	var results []*ExtendedGetPingAreaResponse
+	var resultsMu sync.Mutex
+
+	var wg sync.WaitGroup
+	fanout := 0
+	features := q.features()
+	for _, call := range plan.Shards {
+		if call.Pruned {
+			Metrics.geohashRequestsTotal.WithLabelValues(call.Workers[0], "pruned", reasonCoverageHint).Inc()
+			continue
+		}
+		workers := state.supporting(call.Workers, features)
+		if len(workers) == 0 {
+			call.Error = errFeatureUnsupported.Error()
+			continue
+		}
+		fanout++
+		if plan.Mode == planRouted {
+			Metrics.geohashRequestsTotal.WithLabelValues(call.Workers[0], plan.Mode, plan.Reason).Add(float64(call.Geohashes))
+		} else {
+			Metrics.geohashRequestsTotal.WithLabelValues(call.Workers[0], plan.Mode, plan.Reason).Inc()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			callStart := time.Now()
+
+			request := func(ctx context.Context, addr string, _ bool) (*pb.GetPingAreaResponse, error) {
+				replica := addr != call.Workers[0] // the primary may have been left out by its features
+				client, err := plan.workers.WorkerClient(addr)
+				if err != nil {
+					return nil, err
+				}
+
+				start := time.Now()
+				v, err := client.GetPingArea(ctx, &pb.GetPingAreaRequest{
+					Precision:          int32(q.precision),
+					AggPrecision:       int32(q.precUsed),
+					MinLat:             q.minLat,
+					MaxLat:             q.maxLat,
+					MinLng:             q.minLng,
+					MaxLng:             q.maxLng,
+					Geohashes:          call.geohashes,
+					Replica:            replica,
+					ApiVersion:         state.apiVersion(addr),
+					Smooth:             int32(q.smooth),
+					HistoryOffset:      q.historyOffset,
+					Window:             q.window,
+					HistoryGranularity: q.historyGranularity,
+					Movement:           q.movement,
+					HasFloor:           q.hasFloor,
+					Floor:              q.floor,
+					FrameSeconds:       q.frameSeconds,
+					Frames:             int32(q.frames),
+					FramesHistory:      q.framesHistory,
+				})
+				observeGRPC("GetPingArea", addr, err, start)
+				return v, err
+			}
+
+			var v *pb.GetPingAreaResponse
+			var addr string
+			var err error
+			if plan.Mode == planRouted {
+				v, addr, err = hedgedCall(ctx, "GetPingArea", workers, request)
+			} else {
+				ctx, cancel := context.WithTimeout(ctx, time.Second)
+				defer cancel()
+				addr = call.Workers[0]
+				v, err = request(ctx, addr, false)
+			}
+
+			// each call only writes its own entry
+			call.DurationMs = float64(time.Since(callStart).Microseconds()) / 1000
+			if err != nil {
+				call.Error = err.Error()
+				return // skip failed worker, return partial response
+			}
+			call.Worker = addr
+			observeCellCost(addr, plan.callCells(call), time.Since(callStart))
+
+			resultsMu.Lock()
+			results = append(results, &ExtendedGetPingAreaResponse{GetPingAreaResponse: v, Server: addr})
+			resultsMu.Unlock()
+		}()
+	}
+	observeRouting(plan.Mode, plan.Reason, fanout)
+	wg.Wait()
+	return results
+}
+
+// queryPingArea plans and executes an area query
+func queryPingArea(ctx context.Context, q pingAreaQuery) map[string]*ExtendedPingAreaCount {
+	return planner.Plan(q).Execute(ctx)
+}
+
+// explainPlan is the plan of GET /pingArea?explain=true
+type explainPlan struct {
+	Precision      int   `json:"precision"`
+	AggPrecision   int   `json:"aggPrecision"`
+	EstimatedCells int64 `json:"estimatedCells"`
+	Cover          int   `json:"cover"`
+	*QueryPlan
+}
+
+func (plan *QueryPlan) explain() explainPlan {
+	return explainPlan{
+		Precision:      plan.query.precision,
+		AggPrecision:   plan.query.precUsed,
+		EstimatedCells: plan.query.estimated,
+		Cover:          len(plan.cover),
+		QueryPlan:      plan,
+	}
+}
+
+// observeRouting counts a routing decision and the workers it fans out to
+func observeRouting(mode, reason string, workers int) {
+	Metrics.routingDecisions.WithLabelValues(mode, reason).Inc()
+	Metrics.routingFanout.WithLabelValues(mode).Observe(float64(workers))
+}
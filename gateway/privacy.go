@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+
+	"geostreamdb/geo"
+)
+
+// per-tenant privacy mode. PRIVACY="name:precision:k[:jitter],..." (tenant names as in TENANTS, "anonymous" also
+// covering CoAP and UDP):
+//   - precision (0 = off): pings are stored at the center of their geohash cell at this precision, or with jitter at a
+//     uniformly random point in it, so no finer location than the cell is ever kept
+//   - k (0 = off): cells counting fewer than k pings are left out of area responses (point and polygon counts below k
+//     read as 0), and device tracks are not served
+//
+// ACLs (see acl.go) are checked on the stored location
+var PRIVACY = parsePrivacy(os.Getenv("PRIVACY"))
+
+type tenantPrivacy struct {
+	precision int
+	jitter    bool
+	k         int64
+}
+
+func parsePrivacy(v string) map[string]*tenantPrivacy { // tenant name -> settings
+	out := make(map[string]*tenantPrivacy)
+	for _, entry := range strings.Split(v, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || (len(parts) == 4 && parts[3] != "jitter") {
+			log.Printf("invalid PRIVACY entry %q, ignoring", entry)
+			continue
+		}
+		precision, err1 := strconv.Atoi(parts[1])
+		k, err2 := strconv.ParseInt(parts[2], 10, 64)
+		if err1 != nil || err2 != nil || precision < 0 || precision > 12 || k < 0 {
+			log.Printf("invalid PRIVACY values in %q, ignoring", entry)
+			continue
+		}
+		out[parts[0]] = &tenantPrivacy{precision: precision, jitter: len(parts) == 4, k: k}
+	}
+	return out
+}
+
+// privacyFor returns the tenant's privacy settings, nil if none
+func privacyFor(t *tenant) *tenantPrivacy {
+	return PRIVACY[t.name]
+}
+
+// coarsen returns the location to store a ping at
+func (p *tenantPrivacy) coarsen(lat, lng float64) (float64, float64) {
+	if p == nil || p.precision == 0 || p.precision >= MAX_GH_PRECISION {
+		return lat, lng
+	}
+	cell, ok := geo.Decode(geo.Encode(lat, lng, p.precision))
+	if !ok {
+		return lat, lng
+	}
+	if p.jitter {
+		return cell.MinLat + rand.Float64()*(cell.MaxLat-cell.MinLat), cell.MinLng + rand.Float64()*(cell.MaxLng-cell.MinLng)
+	}
+	return cell.Center()
+}
+
+// suppress drops the cells counting fewer than k pings
+func (p *tenantPrivacy) suppress(t *tenant, counts map[string]*ExtendedPingAreaCount) map[string]*ExtendedPingAreaCount {
+	if p == nil || p.k <= 1 {
+		return counts
+	}
+	suppressed := 0
+	for gh, c := range counts {
+		if c.Count < p.k {
+			delete(counts, gh)
+			if c.Count > 0 {
+				suppressed++
+			}
+		}
+	}
+	Metrics.privacySuppressed.WithLabelValues(t.name).Add(float64(suppressed))
+	return counts
+}
+
+// suppressCount reads a count below k as 0
+func (p *tenantPrivacy) suppressCount(t *tenant, count int64) int64 {
+	if p == nil || count >= p.k {
+		return count
+	}
+	if count > 0 {
+		Metrics.privacySuppressed.WithLabelValues(t.name).Inc()
+	}
+	return 0
+}
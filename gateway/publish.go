@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// publishing of regional counts as Prometheus time series, so Grafana setups can chart activity without the HTTP API.
+// every PUBLISH_INTERVAL the gateway counts the pings in the TTL window of each PUBLISH_PREFIXES cell (or of its
+// subcells PUBLISH_DEPTH characters finer) and exposes them as geostreamdb_cell_pings{cell} on /metrics and, with
+// PUBLISH_REMOTE_WRITE_URL, pushes them with the Prometheus remote write protocol (1.0). cardinality is bounded by the
+// configured prefixes: PUBLISH_MAX_SERIES caps the cells published. every gateway publishes the same counts, so enable
+// it on one of them (or aggregate with max)
+var PUBLISH_PREFIXES = parsePublishPrefixes(os.Getenv("PUBLISH_PREFIXES")) // unset = disabled
+var PUBLISH_DEPTH = env.Int("PUBLISH_DEPTH", 0)
+var PUBLISH_MAX_SERIES = env.Int("PUBLISH_MAX_SERIES", 1024)
+var PUBLISH_INTERVAL = env.Duration("PUBLISH_INTERVAL", 15*time.Second)
+var PUBLISH_REMOTE_WRITE_URL = os.Getenv("PUBLISH_REMOTE_WRITE_URL") // unset = /metrics only
+
+const publishMetricName = "geostreamdb_cell_pings"
+
+func parsePublishPrefixes(v string) []string {
+	var prefixes []string
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !geo.Valid(p) || len(p) > 12 {
+			log.Printf("invalid PUBLISH_PREFIXES entry %q, ignoring", p)
+			continue
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
+
+// publishedCells holds the counts of the last round, exposed on /metrics
+type publishedCells struct {
+	mu     sync.RWMutex
+	counts map[string]int64
+	desc   *prometheus.Desc
+}
+
+var published = &publishedCells{desc: prometheus.NewDesc(publishMetricName, "Pings in the TTL window per published geohash cell (PUBLISH_PREFIXES)", []string{"cell"}, nil)}
+
+func (p *publishedCells) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.desc
+}
+
+func (p *publishedCells) Collect(ch chan<- prometheus.Metric) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for cell, count := range p.counts {
+		ch <- prometheus.MustNewConstMetric(p.desc, prometheus.GaugeValue, float64(count), cell)
+	}
+}
+
+func startPublishing() {
+	if len(PUBLISH_PREFIXES) == 0 {
+		return
+	}
+	prometheus.MustRegister(published)
+	log.Printf("publishing counts of %d prefixes every %s", len(PUBLISH_PREFIXES), PUBLISH_INTERVAL)
+	go func() {
+		ticker := time.NewTicker(PUBLISH_INTERVAL)
+		defer ticker.Stop()
+		for {
+			publishRound()
+			<-ticker.C
+		}
+	}()
+}
+
+// publishRound counts every published cell, then exposes and (if configured) pushes them
+func publishRound() {
+	counts := make(map[string]int64)
+	for _, prefix := range PUBLISH_PREFIXES {
+		cell, _ := geo.Decode(prefix)
+		precision := min(len(prefix)+max(PUBLISH_DEPTH, 0), MAX_GH_PRECISION)
+		q, err := planner.Query(cell.MinLat, cell.MaxLat, cell.MinLng, cell.MaxLng, precision)
+		if err != nil {
+			log.Printf("publish: prefix %s at precision %d: %v", prefix, precision, err)
+			continue
+		}
+		// every subcell is published (0 if empty) so series don't come and go
+		for _, gh := range cell.Cover(precision) {
+			counts[gh] = 0
+		}
+		for gh, c := range queryPingArea(context.Background(), q) {
+			if strings.HasPrefix(gh, prefix) {
+				counts[gh] += c.Count
+			}
+		}
+	}
+	if len(counts) > PUBLISH_MAX_SERIES {
+		cells := make([]string, 0, len(counts))
+		for gh := range counts {
+			cells = append(cells, gh)
+		}
+		sort.Strings(cells)
+		for _, gh := range cells[PUBLISH_MAX_SERIES:] {
+			delete(counts, gh)
+		}
+		Metrics.publishTotal.WithLabelValues("truncated").Inc()
+	}
+
+	published.mu.Lock()
+	published.counts = counts
+	published.mu.Unlock()
+
+	if PUBLISH_REMOTE_WRITE_URL == "" {
+		return
+	}
+	if err := remoteWrite(counts, time.Now().UnixMilli()); err != nil {
+		log.Printf("publish: remote write failed: %v", err)
+		Metrics.publishTotal.WithLabelValues("failed").Inc()
+		return
+	}
+	Metrics.publishTotal.WithLabelValues("written").Inc()
+}
+
+// remoteWrite pushes one sample per cell as a snappy-compressed prometheus.WriteRequest
+func remoteWrite(counts map[string]int64, timestampMs int64) error {
+	body := snappy.Encode(nil, encodeWriteRequest(counts, timestampMs))
+	ctx, cancel := context.WithTimeout(context.Background(), PUBLISH_INTERVAL)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, PUBLISH_REMOTE_WRITE_URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// encodeWriteRequest encodes the remote write 1.0 protobuf by hand (prometheus/prompb):
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(counts map[string]int64, timestampMs int64) []byte {
+	label := func(b []byte, name, value string) []byte {
+		var l []byte
+		l = protowire.AppendTag(l, 1, protowire.BytesType)
+		l = protowire.AppendString(l, name)
+		l = protowire.AppendTag(l, 2, protowire.BytesType)
+		l = protowire.AppendString(l, value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		return protowire.AppendBytes(b, l)
+	}
+
+	cells := make([]string, 0, len(counts))
+	for gh := range counts {
+		cells = append(cells, gh)
+	}
+	sort.Strings(cells)
+
+	var out []byte
+	for _, gh := range cells {
+		var ts []byte
+		ts = label(ts, "__name__", publishMetricName) // labels sorted by name
+		ts = label(ts, "cell", gh)
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+		s = protowire.AppendFixed64(s, math.Float64bits(float64(counts[gh])))
+		s = protowire.AppendTag(s, 2, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(timestampMs))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, s)
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, ts)
+	}
+	return out
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "geostreamdb/proto"
+
+	"geostreamdb/geo"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// queries over the raw pings retained by workers with RAW_RETENTION (see worker-node/raw.go). both are broadcast to
+// every worker (not sent to those whose heartbeats list no raw retention) and only answered if all of them respond, as a
+// partial count or track would silently be wrong:
+//   - GET /pingPolygon?polygon=lat,lng;lat,lng;... -> pings in the polygon (exact point-in-polygon on each ping's cell)
+//   - GET /device/{id}/pings?limit=N -> the device's most recent pings in the TTL window, oldest first. only those
+//     ingested by the caller's tenant (workers keep the tenant with the device, see withTenant): a device id names a
+//     device within a tenant, and anonymous callers only see anonymously ingested tracks
+//
+// DELETE /admin/device/{id} purges a device (GDPR erasure), as ingested by any tenant, on every worker and returns a
+// per-worker report, with 503 if any worker didn't confirm (retry: deletion is idempotent)
+const MAX_DEVICE_ID_LENGTH = 128 // bytes (workers reject longer ids)
+const maxPolygonVertices = 1024
+
+type tenantContextKey struct{}
+
+// withTenant carries the tenant a ping is ingested for down to its SendPing requests
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// tenantOf returns the tenant carried by ctx as workers know it: "" for the anonymous one, or none
+func tenantOf(ctx context.Context) string {
+	t, _ := ctx.Value(tenantContextKey{}).(*tenant)
+	return workerTenant(t)
+}
+
+func workerTenant(t *tenant) string {
+	if t == nil || t.name == anonymousTenant {
+		return ""
+	}
+	return t.name
+}
+
+type rawPing struct {
+	Geohash   string  `json:"geohash"`
+	Latitude  float64 `json:"lat"` // cell center
+	Longitude float64 `json:"lng"`
+	Timestamp int64   `json:"timestamp"`          // unix ms
+	Teleport  bool    `json:"teleport,omitempty"` // TELEPORT_ACTION=tag
+}
+
+func writeRawError(w http.ResponseWriter, err error) {
+	switch {
+	case status.Code(err) == codes.FailedPrecondition || errors.Is(err, errFeatureUnsupported):
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte("Raw retention is not enabled on every worker"))
+	case status.Code(err) == codes.InvalidArgument:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(status.Convert(err).Message()))
+	case statusOf(err).http == http.StatusInternalServerError:
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Not every worker answered"))
+	default:
+		writeError(w, err)
+	}
+}
+
+func parsePolygon(v string) ([]*pb.LatLng, bool) {
+	return parseLatLngs(v, 3, maxPolygonVertices)
+}
+
+// parseLatLngs parses "lat,lng;lat,lng;..." holding between minPoints and maxPoints points
+func parseLatLngs(v string, minPoints int, maxPoints int) ([]*pb.LatLng, bool) {
+	points := strings.Split(v, ";")
+	if len(points) < minPoints || len(points) > maxPoints {
+		return nil, false
+	}
+	vertices := make([]*pb.LatLng, 0, len(points))
+	for _, p := range points {
+		latQ, lngQ, ok := strings.Cut(p, ",")
+		if !ok {
+			return nil, false
+		}
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(latQ), 64)
+		lng, err2 := strconv.ParseFloat(strings.TrimSpace(lngQ), 64)
+		if err1 != nil || err2 != nil || math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			return nil, false
+		}
+		vertices = append(vertices, &pb.LatLng{Lat: lat, Lng: lng})
+	}
+	return vertices, true
+}
+
+func getPingPolygon(w http.ResponseWriter, r *http.Request) {
+	vertices, ok := parsePolygon(r.URL.Query().Get("polygon"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid polygon (expected 3 to 1024 lat,lng pairs separated by ';')"))
+		return
+	}
+
+	bbox := geo.Bbox{MinLat: 90, MaxLat: -90, MinLng: 180, MaxLng: -180}
+	for _, v := range vertices {
+		bbox.MinLat, bbox.MaxLat = min(bbox.MinLat, v.Lat), max(bbox.MaxLat, v.Lat)
+		bbox.MinLng, bbox.MaxLng = min(bbox.MinLng, v.Lng), max(bbox.MaxLng, v.Lng)
+	}
+	t := tenantFor(r)
+	if !aclFor(t).allowsBbox(bbox) {
+		denyACL(w, t)
+		return
+	}
+	if !admitUsage(w, r, unitCells, 1) {
+		return
+	}
+
+	var total int64
+	var mu sync.Mutex
+	err := service.Broadcast(r.Context(), "CountInPolygon", func(ctx context.Context, addr string, client pb.WorkerClient) error {
+		if !state.supports(addr, pb.FeatureRaw) {
+			return errFeatureUnsupported
+		}
+		v, err := client.CountInPolygon(ctx, &pb.CountInPolygonRequest{Vertices: vertices, ApiVersion: state.apiVersion(addr)})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		total += v.Count
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		writeRawError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int64{"count": privacyFor(t).suppressCount(t, total)})
+}
+
+func getDevicePings(w http.ResponseWriter, r *http.Request) {
+	deviceID := chi.URLParam(r, "id")
+	if deviceID == "" || len(deviceID) > MAX_DEVICE_ID_LENGTH {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid device id"))
+		return
+	}
+	limit := 0 // worker default
+	if limitQ := r.URL.Query().Get("limit"); limitQ != "" {
+		n, err := strconv.Atoi(limitQ)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid limit"))
+			return
+		}
+		limit = n
+	}
+
+	t := tenantFor(r)
+	if p := privacyFor(t); p != nil && p.k > 1 {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Device tracks are not available in privacy mode"))
+		return
+	}
+	if !admitUsage(w, r, unitCells, 1) {
+		return
+	}
+
+	var pings []*pb.RawPing
+	var mu sync.Mutex
+	err := service.Broadcast(r.Context(), "GetDevicePings", func(ctx context.Context, addr string, client pb.WorkerClient) error {
+		if !state.supports(addr, pb.FeatureRaw) {
+			return errFeatureUnsupported
+		}
+		v, err := client.GetDevicePings(ctx, &pb.GetDevicePingsRequest{DeviceId: deviceID, Tenant: workerTenant(t), Limit: int32(min(limit, math.MaxInt32)), ApiVersion: state.apiVersion(addr)})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		pings = append(pings, v.Pings...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		writeRawError(w, err)
+		return
+	}
+
+	if acl := aclFor(t); acl != nil {
+		kept := pings[:0]
+		for _, p := range pings {
+			if cell, ok := geo.Decode(p.Geohash); ok && acl.allowsBbox(cell) {
+				kept = append(kept, p) // only the part of the track within the tenant's regions
+			}
+		}
+		pings = kept
+	}
+
+	// every worker returned its most recent pings: keep the most recent overall
+	sort.Slice(pings, func(i, j int) bool { return pings[i].Timestamp < pings[j].Timestamp })
+	if limit > 0 && len(pings) > limit {
+		pings = pings[len(pings)-limit:]
+	}
+	out := make([]rawPing, 0, len(pings))
+	for _, p := range pings {
+		cell, _ := geo.Decode(p.Geohash)
+		out = append(out, rawPing{Geohash: p.Geohash, Latitude: (cell.MinLat + cell.MaxLat) / 2, Longitude: (cell.MinLng + cell.MaxLng) / 2, Timestamp: p.Timestamp, Teleport: p.Teleport})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+}
+
+type deviceDeletion struct {
+	RawPings        int64  `json:"rawPings"` // retained pings unlinked from the device
+	DedupWindows    int64  `json:"dedupWindows"`
+	TombstonedUntil int64  `json:"tombstonedUntil,omitempty"` // unix seconds
+	Standby         string `json:"standby,omitempty"`         // warm standby's purge ("purged" or the error)
+	Error           string `json:"error,omitempty"`
+}
+
+type deviceDeletionReport struct {
+	DeviceID string                     `json:"deviceId"`
+	Complete bool                       `json:"complete"` // every worker (and standby) purged the device
+	RawPings int64                      `json:"rawPings"`
+	Workers  map[string]*deviceDeletion `json:"workers"`
+}
+
+func deleteDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID := chi.URLParam(r, "id")
+	if deviceID == "" || len(deviceID) > MAX_DEVICE_ID_LENGTH {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid device id"))
+		return
+	}
+
+	report := deviceDeletionReport{DeviceID: deviceID, Complete: true, Workers: make(map[string]*deviceDeletion)}
+	var mu sync.Mutex
+	err := service.Broadcast(r.Context(), "DeleteDevice", func(ctx context.Context, addr string, client pb.WorkerClient) error {
+		v, err := client.DeleteDevice(ctx, &pb.DeleteDeviceRequest{DeviceId: deviceID, ApiVersion: state.apiVersion(addr)})
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			report.Workers[addr] = &deviceDeletion{Error: status.Convert(err).Message()}
+			return err
+		}
+		report.Workers[addr] = &deviceDeletion{RawPings: v.RawPings, DedupWindows: v.DedupWindows, TombstonedUntil: v.TombstonedUntil, Standby: v.Standby}
+		report.RawPings += v.RawPings
+		if v.Standby != "" && v.Standby != "purged" {
+			report.Complete = false
+		}
+		return nil
+	})
+	if errors.Is(err, errNoWorkers) {
+		writeError(w, err)
+		return
+	}
+	code := http.StatusOK
+	if err != nil || !report.Complete {
+		report.Complete = false
+		code = http.StatusServiceUnavailable
+	}
+	Metrics.deviceDeletionsTotal.WithLabelValues(strconv.FormatBool(report.Complete)).Inc()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// read-your-writes: POST /ping answers with an X-Read-Token naming the write on its primary (worker id, the second
+// it is bucketed in, the worker's write sequence number). GET /ping?token= (or an X-Read-Token header) then reads the
+// point from that worker, asking it whether the write is counted, and retries for up to READ_TOKEN_WAIT if not, e.g.
+// while this gateway's ring hasn't caught up with the one that routed the write. a write that left the TTL window
+// counts as visible. ack=none writes get no token
+var READ_TOKEN_WAIT = env.Duration("READ_TOKEN_WAIT", time.Second)
+
+const readTokenPoll = 50 * time.Millisecond
+
+var (
+	errTokenWorkerGone  = errors.New("read token worker not in the ring")
+	errTokenNotReached  = errors.New("read token write not visible")
+	errInvalidReadToken = errors.New("invalid read token")
+)
+
+type readToken struct {
+	workerID string
+	slot     int64
+	seq      uint64
+}
+
+func (t readToken) String() string {
+	return t.workerID + "." + strconv.FormatInt(t.slot, 10) + "." + strconv.FormatUint(t.seq, 10)
+}
+
+// tokenOf returns the read token of a primary write acknowledgment, false for replica acknowledgments (or old workers)
+func tokenOf(v *pb.PingResponse) (readToken, bool) {
+	if v == nil || v.WorkerId == "" {
+		return readToken{}, false
+	}
+	return readToken{workerID: v.WorkerId, slot: v.Slot, seq: v.WriteSeq}, true
+}
+
+// requestReadToken reads the token of a GET /ping, false if it has none
+func requestReadToken(r *http.Request) (readToken, bool, error) {
+	s := r.URL.Query().Get("token")
+	if s == "" {
+		s = r.Header.Get("X-Read-Token")
+	}
+	if s == "" {
+		return readToken{}, false, nil
+	}
+	rest, seqS, ok := cutLast(s, ".")
+	if !ok {
+		return readToken{}, false, errInvalidReadToken
+	}
+	workerID, slotS, ok := cutLast(rest, ".")
+	if !ok || workerID == "" {
+		return readToken{}, false, errInvalidReadToken
+	}
+	slot, err := strconv.ParseInt(slotS, 10, 64)
+	if err != nil {
+		return readToken{}, false, errInvalidReadToken
+	}
+	seq, err := strconv.ParseUint(seqS, 10, 64)
+	if err != nil {
+		return readToken{}, false, errInvalidReadToken
+	}
+	return readToken{workerID: workerID, slot: slot, seq: seq}, true, nil
+}
+
+func cutLast(s string, sep string) (before string, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// readAfter gets the count of a max precision geohash from the worker holding the token's write, once it is counted
+func readAfter(ctx context.Context, gh string, t readToken) (*pb.GetPingsResponse, error) {
+	deadline := time.Now().Add(READ_TOKEN_WAIT)
+	for attempt := 0; ; attempt++ {
+		addr := state.serverOf(t.workerID)
+		if addr != "" {
+			if attempt == 0 {
+				Metrics.geohashRequestsTotal.WithLabelValues(addr, "routed", reasonReadToken).Inc()
+			}
+			conn, err := state.GetConn(addr)
+			if err != nil {
+				return nil, errWorkerConnect
+			}
+			callCtx, cancel := context.WithTimeout(ctx, time.Second)
+			start := time.Now()
+			v, err := pb.NewWorkerClient(conn).GetPings(callCtx, &pb.GetPingsRequest{
+				Geohash:       gh,
+				ApiVersion:    state.apiVersion(addr),
+				TokenWorkerId: t.workerID,
+				TokenSlot:     t.slot,
+				TokenWriteSeq: t.seq,
+			})
+			cancel()
+			observeGRPC("GetPings", addr, err, start)
+			if err != nil {
+				return nil, err
+			}
+			if v.TokenReached {
+				if attempt == 0 {
+					Metrics.readTokenTotal.WithLabelValues("reached").Inc()
+				} else {
+					Metrics.readTokenTotal.WithLabelValues("waited").Inc()
+				}
+				return v, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			if addr == "" {
+				Metrics.readTokenTotal.WithLabelValues("gone").Inc()
+				return nil, errTokenWorkerGone
+			}
+			Metrics.readTokenTotal.WithLabelValues("not_reached").Inc()
+			return nil, errTokenNotReached
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(readTokenPoll):
+		}
+	}
+}
+
+// serverOf returns the address of a worker in the ring, "" if not there
+func (g *GatewayState) serverOf(workerId string) string {
+	g.ringMutex.RLock()
+	defer g.ringMutex.RUnlock()
+	return g.serverOfLocked(workerId)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"geostreamdb/geo"
+)
+
+// GET /stats/byRegion?level=country|admin1: the live counts rolled up to countries (the default) or to their first
+// level subdivisions (states, provinces), {"level", "regions": {"<ISO 3166 code>": {"name", "count"}}, "unassigned",
+// "complete"}, so that users get a human-meaningful summary without joining cells to boundaries themselves. the whole
+// world is queried at regionPrecision and every cell counts towards the region of its longest matching prefix in the
+// region index (unassigned: cells of no region, e.g. at sea). the built-in index (regions.txt) is coarse, see its
+// header; REGIONS_FILE replaces it with one in the same format. countries without subdivisions in the index are listed
+// by country at both levels
+var REGIONS_FILE = os.Getenv("REGIONS_FILE")
+
+const (
+	regionPrecision = 3       // cells of about 156 x 156 km
+	regionMaxCells  = 1 << 15 // the whole world at regionPrecision
+)
+
+//go:embed regions.txt
+var builtinRegions string
+
+type regionIndex struct {
+	prefixes map[string]string // geohash prefix -> region code
+	names    map[string]string // region code -> name
+	depth    int               // longest prefix
+}
+
+var regions *regionIndex
+
+func loadRegions() {
+	source, r := "the built-in index", io.Reader(strings.NewReader(builtinRegions))
+	if REGIONS_FILE != "" {
+		f, err := os.Open(REGIONS_FILE)
+		if err != nil {
+			log.Fatalf("failed to read REGIONS_FILE: %v", err)
+		}
+		defer f.Close()
+		source, r = REGIONS_FILE, f
+	}
+	index, err := parseRegions(r)
+	if err != nil {
+		log.Fatalf("invalid region index in %s: %v", source, err)
+	}
+	regions = index
+	log.Printf("loaded %d regions (%d prefixes) from %s", len(index.names), len(index.prefixes), source)
+}
+
+// parseRegions reads a region index: "code<TAB>name[<TAB>prefix prefix ...]" lines, # comments
+func parseRegions(r io.Reader) (*regionIndex, error) {
+	index := &regionIndex{prefixes: make(map[string]string), names: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("line %d: expected code, name and prefixes separated by tabs", n)
+		}
+		code := fields[0]
+		if _, ok := index.names[code]; ok {
+			return nil, fmt.Errorf("line %d: region %s listed twice", n, code)
+		}
+		index.names[code] = fields[1]
+		if len(fields) < 3 {
+			continue
+		}
+		for _, prefix := range strings.Fields(fields[2]) {
+			if !geo.Valid(prefix) || len(prefix) > MAX_GH_PRECISION {
+				return nil, fmt.Errorf("line %d: invalid geohash prefix %q", n, prefix)
+			}
+			if other, ok := index.prefixes[prefix]; ok {
+				return nil, fmt.Errorf("line %d: prefix %s already belongs to %s", n, prefix, other)
+			}
+			index.prefixes[prefix] = code
+			index.depth = max(index.depth, len(prefix))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// regionOf returns the region of a cell, "" if none. admin1 keeps subdivisions, otherwise they roll up to their country
+func (x *regionIndex) regionOf(gh string, admin1 bool) string {
+	for n := min(len(gh), x.depth); n > 0; n-- {
+		if code, ok := x.prefixes[gh[:n]]; ok {
+			if country, _, ok := strings.Cut(code, "-"); ok && !admin1 {
+				return country
+			}
+			return code
+		}
+	}
+	return ""
+}
+
+// nameOf returns the name of a region, its code if the index has none
+func (x *regionIndex) nameOf(code string) string {
+	if name, ok := x.names[code]; ok {
+		return name
+	}
+	return code
+}
+
+type regionCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+func getStatsByRegion(w http.ResponseWriter, r *http.Request) {
+	level := r.URL.Query().Get("level")
+	if level == "" {
+		level = "country"
+	}
+	if level != "country" && level != "admin1" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid level (country or admin1)"))
+		return
+	}
+
+	// only the rollup is returned, so the whole world is allowed past MAX_PINGAREA_GEOHASHES
+	q, err := planner.QueryWithin(-90, 90, -180, 180, regionPrecision, regionMaxCells)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(q.bbox(), q.precision) {
+		denyACL(w, t)
+		return
+	}
+	if !admitUsage(w, r, unitCells, q.estimated) {
+		return
+	}
+
+	plan := planner.Plan(retentionFor(t).limit(q))
+	counts := privacyFor(t).suppress(t, plan.Execute(r.Context()))
+
+	byRegion := make(map[string]*regionCount)
+	unassigned := int64(0)
+	for gh, c := range counts {
+		code := regions.regionOf(gh, level == "admin1")
+		if code == "" {
+			unassigned += c.Count
+			continue
+		}
+		rc := byRegion[code]
+		if rc == nil {
+			rc = &regionCount{Name: regions.nameOf(code)}
+			byRegion[code] = rc
+		}
+		rc.Count += c.Count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"level": level, "regions": byRegion, "unassigned": unassigned, "complete": !plan.partial()})
+}
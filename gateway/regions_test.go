@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+func TestBuiltinRegionsPlaceCities(t *testing.T) {
+	index, err := parseRegions(strings.NewReader(builtinRegions))
+	if err != nil {
+		t.Fatalf("built-in index: %v", err)
+	}
+	for _, tc := range []struct {
+		city     string
+		lat, lng float64
+		country  string
+		admin1   string
+	}{
+		{"Paris", 48.85, 2.35, "FR", "FR"},
+		{"Denver", 39.74, -104.99, "US", "US-CO"},
+		{"Montreal", 45.5, -73.57, "CA", "CA-QC"},
+		{"Melbourne", -37.81, 144.96, "AU", "AU-VIC"},
+		{"Tokyo", 35.68, 139.7, "JP", "JP"},
+		{"Sao Paulo", -23.55, -46.6, "BR", "BR"},
+		{"Mid-Atlantic", 30, -40, "", ""},
+	} {
+		gh := geo.Encode(tc.lat, tc.lng, regionPrecision)
+		if got := index.regionOf(gh, false); got != tc.country {
+			t.Errorf("%s: country %q, want %q", tc.city, got, tc.country)
+		}
+		if got := index.regionOf(gh, true); got != tc.admin1 {
+			t.Errorf("%s: admin1 %q, want %q", tc.city, got, tc.admin1)
+		}
+	}
+	if index.nameOf("US") != "United States" || index.nameOf("US-CO") != "Colorado" {
+		t.Errorf("names: %q, %q", index.nameOf("US"), index.nameOf("US-CO"))
+	}
+}
+
+func TestParseRegionsLongestPrefixWins(t *testing.T) {
+	index, err := parseRegions(strings.NewReader("# comment\nAA\tOuter\tu0\nAA-X\tInner\tu0n u0m\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := index.regionOf("u0nd", true); got != "AA-X" {
+		t.Errorf("got %q, want AA-X", got)
+	}
+	if got := index.regionOf("u0qd", true); got != "AA" {
+		t.Errorf("got %q, want AA", got)
+	}
+	if got := index.regionOf("u0nd", false); got != "AA" {
+		t.Errorf("got %q, want the country AA", got)
+	}
+
+	for _, bad := range []string{"AA\n", "AA\tA\tu0 !!\n", "AA\tA\tu0\nBB\tB\tu0\n", "AA\tA\nAA\tA\n"} {
+		if _, err := parseRegions(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+	"google.golang.org/grpc/codes"
+)
+
+// optional Redis protocol (RESP2) listener so existing Redis geo clients can push pings without a new SDK:
+//   - GEOADD key [NX|XX] [CH] lng lat member [lng lat member ...] -> integer (pings stored). key is ignored, member is
+//     the device id
+//   - GEOCOUNT key lng lat -> integer (count at the max precision geohash, like GET /ping)
+//   - GEOCOUNT key minLng minLat maxLng maxLat PRECISION p -> flat array geohash, count, ... (like GET /pingArea)
+//   - AUTH [username] password, PING, ECHO, SELECT, QUIT and no-op CLIENT/COMMAND for client handshakes
+//
+// AUTH takes the ingest/query token (INGEST_TOKEN/QUERY_TOKEN) or a tenant API key (both, if they are the same value);
+// requests share the ingest/query rate limits
+var RESP_PORT = os.Getenv("RESP_PORT") // unset = disabled
+var RESP_MAX_CLIENTS = env.Int("RESP_MAX_CLIENTS", 1024)
+var RESP_IDLE_TIMEOUT = env.Duration("RESP_IDLE_TIMEOUT", 5*time.Minute)
+
+const (
+	respMaxArgs     = 3 * 1024  // GEOADD with up to ~1000 points
+	respMaxArgBytes = 64 * 1024 // bulk string limit
+)
+
+var errRESPProtocol = errors.New("Protocol error")
+
+func setup_resp_listener() {
+	if RESP_PORT == "" {
+		return
+	}
+	l, err := net.Listen("tcp", ":"+RESP_PORT)
+	if err != nil {
+		log.Fatalf("failed to listen on resp: %v", err)
+	}
+	log.Printf("RESP listening at %v", l.Addr())
+
+	var clients atomic.Int64
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("resp accept error: %v", err)
+			continue
+		}
+		if clients.Add(1) > int64(RESP_MAX_CLIENTS) {
+			clients.Add(-1)
+			conn.Write([]byte("-ERR max number of clients reached\r\n"))
+			conn.Close()
+			continue
+		}
+		Metrics.respClients.Inc()
+		go func() {
+			defer func() {
+				conn.Close()
+				clients.Add(-1)
+				Metrics.respClients.Dec()
+			}()
+			(&respSession{conn: conn, tenant: anonymous}).serve()
+		}()
+	}
+}
+
+type respSession struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	tenant *tenant
+	token  string // password given with AUTH
+}
+
+func (s *respSession) serve() {
+	s.r = bufio.NewReader(s.conn)
+	s.w = bufio.NewWriter(s.conn)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(RESP_IDLE_TIMEOUT))
+		args, err := readRESPCommand(s.r)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				s.w.WriteString("-ERR " + err.Error() + "\r\n")
+				s.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if quit := s.dispatch(args); quit {
+			s.w.Flush()
+			return
+		}
+		// pipelined commands are answered in one write
+		if s.r.Buffered() == 0 {
+			if err := s.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readRESPCommand reads a command as an array of bulk strings, or as an inline command (telnet, redis-cli)
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$'", errRESPProtocol)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > respMaxArgBytes {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: expected CRLF", errRESPProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("%w: too big inline request", errRESPProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (s *respSession) writeError(msg string)  { s.w.WriteString("-" + msg + "\r\n") }
+func (s *respSession) writeSimple(msg string) { s.w.WriteString("+" + msg + "\r\n") }
+func (s *respSession) writeInt(n int64)       { s.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n") }
+func (s *respSession) writeBulk(v string) {
+	s.w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+}
+func (s *respSession) writeArrayHeader(n int) { s.w.WriteString("*" + strconv.Itoa(n) + "\r\n") }
+
+func (s *respSession) authorized(g *routeGroup) bool {
+	return g.token == "" || subtle.ConstantTimeCompare([]byte(s.token), []byte(g.token)) == 1
+}
+
+// dispatch runs a command and writes its reply. returns true if the connection should be closed
+func (s *respSession) dispatch(args []string) bool {
+	cmd := strings.ToUpper(args[0])
+	result := "ok"
+	defer func() { Metrics.respCommandsTotal.WithLabelValues(respCommandLabel(cmd), result).Inc() }()
+
+	switch cmd {
+	case "PING":
+		if len(args) > 1 {
+			s.writeBulk(args[1])
+		} else {
+			s.writeSimple("PONG")
+		}
+	case "ECHO":
+		if len(args) != 2 {
+			result = "error"
+			s.writeError("ERR wrong number of arguments for 'echo' command")
+			return false
+		}
+		s.writeBulk(args[1])
+	case "QUIT":
+		s.writeSimple("OK")
+		return true
+	case "SELECT", "CLIENT":
+		s.writeSimple("OK")
+	case "COMMAND":
+		s.writeArrayHeader(0)
+	case "AUTH":
+		if len(args) != 2 && len(args) != 3 {
+			result = "error"
+			s.writeError("ERR wrong number of arguments for 'auth' command")
+			return false
+		}
+		password := args[len(args)-1]
+		t, isTenant := tenantByKey(password)
+		s.token = password
+		if !isTenant && !s.authorized(ingestGroup) && !s.authorized(queryGroup) {
+			s.token = ""
+			result = "error"
+			s.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+			return false
+		}
+		if isTenant {
+			s.tenant = t
+		}
+		s.writeSimple("OK")
+	case "GEOADD":
+		result = s.geoadd(args[1:])
+	case "GEOCOUNT":
+		result = s.geocount(args[1:])
+	default:
+		result = "error"
+		s.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	return false
+}
+
+func respCommandLabel(cmd string) string {
+	switch cmd {
+	case "PING", "ECHO", "QUIT", "SELECT", "CLIENT", "COMMAND", "AUTH", "GEOADD", "GEOCOUNT":
+		return cmd
+	}
+	return "unknown" // bounded label cardinality
+}
+
+func parseRESPCoords(lngArg, latArg string) (float64, float64, bool) {
+	lng, err1 := strconv.ParseFloat(lngArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil || math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return 0, 0, false
+	}
+	return lng, lat, true
+}
+
+func (s *respSession) geoadd(args []string) string {
+	if !s.authorized(ingestGroup) {
+		s.writeError("NOAUTH Authentication required.")
+		return "unauthorized"
+	}
+	if len(args) < 1 {
+		s.writeError("ERR wrong number of arguments for 'geoadd' command")
+		return "error"
+	}
+	args = args[1:] // key
+	for len(args) > 0 {
+		if opt := strings.ToUpper(args[0]); opt != "NX" && opt != "XX" && opt != "CH" {
+			break
+		}
+		args = args[1:] // accepted for compatibility, meaningless for counts
+	}
+	if len(args) == 0 || len(args)%3 != 0 {
+		s.writeError("ERR wrong number of arguments for 'geoadd' command")
+		return "error"
+	}
+
+	if reason := filterIngestAddr(netAddrIP(s.conn.RemoteAddr())); reason != "" {
+		s.writeError("NOPERM " + countFiltered(reason))
+		return "forbidden"
+	}
+
+	// the whole command is rejected if any pair is invalid, as Redis does
+	ghs := make([]string, 0, len(args)/3)
+	devices := make([]string, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		lng, lat, ok := parseRESPCoords(args[i], args[i+1])
+		if !ok {
+			s.writeError(fmt.Sprintf("ERR invalid longitude,latitude pair %s,%s", args[i], args[i+1]))
+			return "error"
+		}
+		if len(args[i+2]) > MAX_DEVICE_ID_LENGTH {
+			s.writeError("ERR member too long")
+			return "error"
+		}
+		deviceID := args[i+2]
+		if len(hooksFor(s.tenant)) > 0 {
+			p := newHookPing(s.tenant, "resp", netAddrIP(s.conn.RemoteAddr()), lat, lng, deviceID)
+			dropped, msg := runIngestHooks(s.tenant, p)
+			if dropped {
+				continue // answered as stored, but not counted
+			}
+			if msg != "" {
+				s.writeError(fmt.Sprintf("ERR %s: %s,%s", msg, args[i], args[i+1]))
+				return "error"
+			}
+			lat, lng, deviceID = p.Lat, p.Lng, p.DeviceID
+		}
+		if reason := filterIngestPoint(lat, lng); reason != "" {
+			s.writeError(fmt.Sprintf("NOPERM %s: %s,%s", countFiltered(reason), args[i], args[i+1]))
+			return "forbidden"
+		}
+		if !unsignedPingAllowed(deviceID) {
+			s.writeError("NOPERM signed pings required for this member (use POST /ping)")
+			return "forbidden"
+		}
+		lat, lng = privacyFor(s.tenant).coarsen(lat, lng)
+		if !aclFor(s.tenant).allowsPoint(lat, lng) {
+			Metrics.aclDeniedTotal.WithLabelValues(s.tenant.name).Inc()
+			s.writeError("NOPERM location not allowed for this API key")
+			return "forbidden"
+		}
+		ghs = append(ghs, geo.Encode(lat, lng, MAX_GH_PRECISION))
+		devices = append(devices, deviceID)
+	}
+
+	if !ingestGroup.limiter.allow() {
+		Metrics.rateLimitedTotal.WithLabelValues(ingestGroup.name).Inc()
+		s.writeError("ERR rate limit exceeded")
+		return "rate_limited"
+	}
+	reservedAt := time.Now()
+	if !reserveUsage(s.tenant, "RESP GEOADD", unitPings, int64(len(ghs))) {
+		s.writeError("ERR monthly pings quota exceeded")
+		return "quota_exceeded"
+	}
+
+	ingestedAt := monotonicNow().UnixMilli()
+	stored := int64(0)
+	var lastErr error
+	for i, gh := range ghs {
+		if _, err := service.RoutePing(withTenant(context.Background(), s.tenant), gh, ingestedAt, devices[i], 0); err != nil {
+			lastErr = err
+			continue
+		}
+		stored++
+	}
+	refundUsage(s.tenant, "RESP GEOADD", unitPings, int64(len(ghs))-stored, reservedAt) // the pings not stored
+	if stored == 0 && lastErr != nil {
+		s.writeError(respErrorOf(lastErr))
+		return "failed"
+	}
+	s.writeInt(stored) // partial failures show up as a count lower than the number of points sent
+	return "ok"
+}
+
+func (s *respSession) geocount(args []string) string {
+	if !s.authorized(queryGroup) {
+		s.writeError("NOAUTH Authentication required.")
+		return "unauthorized"
+	}
+	switch len(args) {
+	case 3:
+		return s.geocountPoint(args[1], args[2])
+	case 7:
+		return s.geocountArea(args[1:])
+	}
+	s.writeError("ERR wrong number of arguments for 'geocount' command")
+	return "error"
+}
+
+func (s *respSession) geocountPoint(lngArg, latArg string) string {
+	lng, lat, ok := parseRESPCoords(lngArg, latArg)
+	if !ok {
+		s.writeError(fmt.Sprintf("ERR invalid longitude,latitude pair %s,%s", lngArg, latArg))
+		return "error"
+	}
+	if !aclFor(s.tenant).allowsArea(geo.Bbox{MinLat: lat, MaxLat: lat, MinLng: lng, MaxLng: lng}, MAX_GH_PRECISION) {
+		Metrics.aclDeniedTotal.WithLabelValues(s.tenant.name).Inc()
+		s.writeError("NOPERM location not allowed for this API key")
+		return "forbidden"
+	}
+	if !queryGroup.limiter.allow() {
+		Metrics.rateLimitedTotal.WithLabelValues(queryGroup.name).Inc()
+		s.writeError("ERR rate limit exceeded")
+		return "rate_limited"
+	}
+	reservedAt := time.Now()
+	if !reserveUsage(s.tenant, "RESP GEOCOUNT", unitCells, 1) {
+		s.writeError("ERR monthly cells quota exceeded")
+		return "quota_exceeded"
+	}
+
+	v, err := service.QueryPoint(context.Background(), geo.Encode(lat, lng, MAX_GH_PRECISION))
+	if err != nil {
+		refundUsage(s.tenant, "RESP GEOCOUNT", unitCells, 1, reservedAt)
+		s.writeError(respErrorOf(err))
+		return "failed"
+	}
+	s.writeInt(privacyFor(s.tenant).suppressCount(s.tenant, v.Count))
+	return "ok"
+}
+
+// respErrorOf answers an error of the routing layer (see statusOf) with the error prefix Redis clients retry on: BUSY
+// for an overloaded worker, TRYAGAIN for the other retryable ones, ERR otherwise
+func respErrorOf(err error) string {
+	s := statusOf(err)
+	prefix := "ERR"
+	switch {
+	case s.grpc == codes.ResourceExhausted:
+		prefix = "BUSY"
+	case s.retryable():
+		prefix = "TRYAGAIN"
+	}
+	return prefix + " " + strings.ToLower(s.message[:1]) + s.message[1:]
+}
+
+func (s *respSession) geocountArea(args []string) string {
+	if strings.ToUpper(args[4]) != "PRECISION" {
+		s.writeError("ERR syntax error")
+		return "error"
+	}
+	query := map[string][]string{"minLng": {args[0]}, "minLat": {args[1]}, "maxLng": {args[2]}, "maxLat": {args[3]}, "precision": {args[5]}}
+	q, _, msg := parsePingAreaQuery(query)
+	if msg != "" {
+		s.writeError("ERR " + msg)
+		return "error"
+	}
+	if !aclFor(s.tenant).allowsArea(q.bbox(), q.precision) {
+		Metrics.aclDeniedTotal.WithLabelValues(s.tenant.name).Inc()
+		s.writeError("NOPERM area not allowed for this API key")
+		return "forbidden"
+	}
+	if !queryGroup.limiter.allow() {
+		Metrics.rateLimitedTotal.WithLabelValues(queryGroup.name).Inc()
+		s.writeError("ERR rate limit exceeded")
+		return "rate_limited"
+	}
+	if !reserveUsage(s.tenant, "RESP GEOCOUNT", unitCells, q.estimated) {
+		s.writeError("ERR monthly cells quota exceeded")
+		return "quota_exceeded"
+	}
+
+	combined := privacyFor(s.tenant).suppress(s.tenant, queryPingArea(context.Background(), retentionFor(s.tenant).limit(q)))
+	ghs := make([]string, 0, len(combined))
+	for gh := range combined {
+		ghs = append(ghs, gh)
+	}
+	sort.Strings(ghs)
+	s.writeArrayHeader(2 * len(ghs))
+	for _, gh := range ghs {
+		s.writeBulk(gh)
+		s.writeInt(combined[gh].Count)
+	}
+	return "ok"
+}
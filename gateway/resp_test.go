@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRESPCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		err   error // errRESPProtocol, io.EOF or io.ErrUnexpectedEOF
+	}{
+		{"multibulk", "*3\r\n$6\r\nGEOADD\r\n$1\r\nk\r\n$0\r\n\r\n", []string{"GEOADD", "k", ""}, nil},
+		{"binary-safe argument", "*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n", []string{"ECHO", "a\r\nb"}, nil},
+		{"inline", "GEOCOUNT  k 2.35 48.85\r\n", []string{"GEOCOUNT", "k", "2.35", "48.85"}, nil},
+		{"inline without CR", "PING\n", []string{"PING"}, nil},
+		{"empty inline", "\r\n", []string{}, nil},
+		{"empty multibulk", "*0\r\n", []string{}, nil},
+		{"null multibulk", "*-1\r\n", []string{}, nil},
+		{"invalid multibulk length", "*x\r\n", nil, errRESPProtocol},
+		{"too many arguments", "*3073\r\n", nil, errRESPProtocol},
+		{"missing '$'", "*1\r\n+PING\r\n", nil, errRESPProtocol},
+		{"negative bulk length", "*1\r\n$-1\r\n", nil, errRESPProtocol},
+		{"bulk too long", "*1\r\n$65537\r\n", nil, errRESPProtocol},
+		{"bulk longer than announced", "*1\r\n$2\r\nPING\r\n", nil, errRESPProtocol},
+		{"inline too big", strings.Repeat("a", 8192) + "\r\n", nil, errRESPProtocol},
+		{"closed before a command", "", nil, io.EOF},
+		{"closed mid line", "PIN", nil, io.EOF},
+		{"closed mid command", "*2\r\n$4\r\nPING\r\n", nil, io.EOF},
+		{"closed mid bulk", "*1\r\n$4\r\nPI", nil, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRESPCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got %q, %v, want %v", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRESPCommandPipelined(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*1\r\n$4\r\nPING\r\nECHO hi\r\n*2\r\n$4\r\nECHO\r\n$2\r\nho\r\n"))
+	for _, want := range [][]string{{"PING"}, {"ECHO", "hi"}, {"ECHO", "ho"}} {
+		got, err := readRESPCommand(r)
+		if err != nil || !slices.Equal(got, want) {
+			t.Fatalf("got %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := readRESPCommand(r); err != io.EOF {
+		t.Fatalf("got %v after the last command, want EOF", err)
+	}
+}
+
+// respClient talks to a session served over an in-memory connection
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newRESPClient(t *testing.T) *respClient {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer server.Close()
+		(&respSession{conn: server, tenant: anonymous}).serve()
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return &respClient{conn: client, r: bufio.NewReader(client)}
+}
+
+// do sends raw and returns the reply's lines (n of them)
+func (c *respClient) do(t *testing.T, raw string, n int) []string {
+	t.Helper()
+	if _, err := c.conn.Write([]byte(raw)); err != nil {
+		t.Fatalf("write %q: %v", raw, err)
+	}
+	lines := make([]string, n)
+	for i := range lines {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reply to %q: %v", raw, err)
+		}
+		lines[i] = strings.TrimSuffix(line, "\r\n")
+	}
+	return lines
+}
+
+func TestRESPSessionReplies(t *testing.T) {
+	withWriteBudget(t, WRITE_BUDGET, &fakeWorker{})
+	c := newRESPClient(t)
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"PING\r\n", []string{"+PONG"}},
+		{"*2\r\n$4\r\nping\r\n$2\r\nhi\r\n", []string{"$2", "hi"}},
+		{"ECHO\r\n", []string{"-ERR wrong number of arguments for 'echo' command"}},
+		{"SELECT 0\r\n", []string{"+OK"}},
+		{"COMMAND DOCS\r\n", []string{"*0"}},
+		{"FLUSHALL\r\n", []string{"-ERR unknown command 'FLUSHALL'"}},
+		{"GEOADD\r\n", []string{"-ERR wrong number of arguments for 'geoadd' command"}},
+		{"GEOADD k NX CH 2.35 48.85\r\n", []string{"-ERR wrong number of arguments for 'geoadd' command"}},
+		{"GEOADD k 2.35 91 truck-42\r\n", []string{"-ERR invalid longitude,latitude pair 2.35,91"}},
+		{"GEOADD k 2.35 48.85 truck-42 NaN 1 truck-43\r\n", []string{"-ERR invalid longitude,latitude pair NaN,1"}},
+		{"GEOADD k XX 2.35 48.85 truck-42 -8.72 42.23 truck-43\r\n", []string{":2"}},
+		{"GEOCOUNT k 2.35\r\n", []string{"-ERR wrong number of arguments for 'geocount' command"}},
+		{"GEOCOUNT k 2.35 48.85 2.36 48.86 PRECISE 5\r\n", []string{"-ERR syntax error"}},
+	}
+	for _, tt := range tests {
+		if got := c.do(t, tt.command, len(tt.want)); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.command, got, tt.want)
+		}
+	}
+
+	// pipelined commands are all answered
+	if got := c.do(t, "PING\r\nECHO a\r\nPING\r\n", 4); !slices.Equal(got, []string{"+PONG", "$1", "a", "+PONG"}) {
+		t.Errorf("pipelined: got %q", got)
+	}
+	// a protocol error is answered, then the connection closed
+	if got := c.do(t, "*1\r\n$x\r\n", 1); got[0] != "-ERR Protocol error: invalid bulk length" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("connection still open after a protocol error: %v", err)
+	}
+}
+
+func TestRESPSessionQuit(t *testing.T) {
+	c := newRESPClient(t)
+	if got := c.do(t, "QUIT\r\n", 1); got[0] != "+OK" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := c.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("connection still open after QUIT: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// per-tenant retention. workers keep one dataset for all tenants (PING_TTL live window, HISTORY_RETENTION history
+// tier): that is what is actually stored, and expired, for everyone. a tenant's policy bounds what the workers count
+// for it out of that:
+//   - window: only the newest pings of the live window are counted in its area queries
+//   - historyGranularity: its compare baselines are averaged over spans of that size (if coarser than the workers')
+//   - historyDuration: how far back its compare baselines may reach (0 = no history at all)
+//
+// RETENTION="name:window:historyGranularity:historyDuration,..." (Go durations, e.g. "acme:5s:1h:168h". 0 window or
+// granularity = the cluster's). tenants without a policy get the cluster's retention. changed at runtime with
+//
+//	GET /admin/retention, GET /admin/retention/{tenant}, DELETE /admin/retention/{tenant}
+//	PUT /admin/retention/{tenant} {"window": "5s", "historyGranularity": "1h", "historyDuration": "168h"}
+//
+// and persisted in STORE_FILE or RETENTION_FILE if set (which then replace RETENTION). kept per gateway, like zone sets
+var RETENTION_FILE = os.Getenv("RETENTION_FILE")
+
+type retentionPolicy struct {
+	window             time.Duration
+	historyGranularity time.Duration
+	historyDuration    time.Duration
+}
+
+// retentionJSON is a policy as served and accepted by /admin/retention
+type retentionJSON struct {
+	Window             string `json:"window"`
+	HistoryGranularity string `json:"historyGranularity"`
+	HistoryDuration    string `json:"historyDuration"`
+}
+
+var retention = struct {
+	sync.RWMutex
+	policies map[string]*retentionPolicy // tenant name -> policy
+}{policies: parseRetention(os.Getenv("RETENTION"))}
+
+func parseRetention(v string) map[string]*retentionPolicy {
+	out := make(map[string]*retentionPolicy)
+	for _, entry := range strings.Split(v, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 4 || parts[0] == "" {
+			log.Printf("invalid RETENTION entry %q, ignoring", entry)
+			continue
+		}
+		p, err := newRetentionPolicy(retentionJSON{Window: parts[1], HistoryGranularity: parts[2], HistoryDuration: parts[3]})
+		if err != nil {
+			log.Printf("invalid RETENTION values in %q, ignoring: %v", entry, err)
+			continue
+		}
+		out[parts[0]] = p
+	}
+	return out
+}
+
+func newRetentionPolicy(v retentionJSON) (*retentionPolicy, error) {
+	var p retentionPolicy
+	for _, f := range []struct {
+		name  string
+		value string
+		out   *time.Duration
+	}{
+		{"window", v.Window, &p.window},
+		{"historyGranularity", v.HistoryGranularity, &p.historyGranularity},
+		{"historyDuration", v.HistoryDuration, &p.historyDuration},
+	} {
+		if f.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.value)
+		if err != nil || d < 0 || d%time.Second != 0 {
+			return nil, fmt.Errorf("%s must be a whole number of seconds", f.name)
+		}
+		*f.out = d
+	}
+	return &p, nil
+}
+
+func (p *retentionPolicy) json() retentionJSON {
+	return retentionJSON{Window: p.window.String(), HistoryGranularity: p.historyGranularity.String(), HistoryDuration: p.historyDuration.String()}
+}
+
+// retentionFor returns the tenant's retention policy, nil if it has the cluster's
+func retentionFor(t *tenant) *retentionPolicy {
+	retention.RLock()
+	defer retention.RUnlock()
+	return retention.policies[t.name]
+}
+
+// limit applies the policy to an area query
+func (p *retentionPolicy) limit(q pingAreaQuery) pingAreaQuery {
+	if p == nil {
+		return q
+	}
+	q.window = int64(p.window.Seconds())
+	q.historyGranularity = int64(p.historyGranularity.Seconds())
+	return q
+}
+
+// allowsHistory reports whether the policy lets a baseline reach offset seconds back
+func (p *retentionPolicy) allowsHistory(offset int64) bool {
+	return p == nil || offset == 0 || offset <= int64(p.historyDuration.Seconds())
+}
+
+// loadRetention reads the policies at startup from STORE_FILE, or RETENTION_FILE (a missing file keeps RETENTION)
+func loadRetention() {
+	raw, source, seed := loadObjects[retentionJSON](storeRetention, RETENTION_FILE, "RETENTION_FILE")
+	retention.Lock()
+	defer retention.Unlock()
+	if raw != nil {
+		policies := make(map[string]*retentionPolicy, len(raw))
+		for name, v := range raw {
+			p, err := newRetentionPolicy(v)
+			if err != nil {
+				log.Printf("invalid retention policy %q in %s, ignoring: %v", name, source, err)
+				continue
+			}
+			policies[name] = p
+		}
+		retention.policies = policies
+		log.Printf("loaded %d retention policies from %s", len(policies), source)
+	}
+	if seed {
+		if err := saveRetentionLocked(); err != nil {
+			log.Fatalf("failed to save retention policies to STORE_FILE: %v", err)
+		}
+	}
+}
+
+// saveRetentionLocked saves the policies to STORE_FILE, or rewrites RETENTION_FILE (write + rename). retention must be
+// locked
+func saveRetentionLocked() error {
+	if store != nil {
+		return storeReplace(storeRetention, retentionSnapshotLocked())
+	}
+	if RETENTION_FILE == "" {
+		return nil
+	}
+	data, err := json.Marshal(retentionSnapshotLocked())
+	if err != nil {
+		return err
+	}
+	tmp := RETENTION_FILE + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, RETENTION_FILE)
+}
+
+func retentionSnapshotLocked() map[string]retentionJSON {
+	out := make(map[string]retentionJSON, len(retention.policies))
+	for name, p := range retention.policies {
+		out[name] = p.json()
+	}
+	return out
+}
+
+func getRetentionPolicies(w http.ResponseWriter, r *http.Request) {
+	retention.RLock()
+	out := retentionSnapshotLocked()
+	retention.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+}
+
+func getRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	retention.RLock()
+	p, ok := retention.policies[chi.URLParam(r, "tenant")]
+	retention.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No retention policy for this tenant"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(p.json())
+}
+
+func putRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tenant")
+	var v retentionJSON
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&v); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+	p, err := newRetentionPolicy(v)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	retention.Lock()
+	defer retention.Unlock()
+	prev, existed := retention.policies[name]
+	retention.policies[name] = p
+	if err := saveRetentionLocked(); err != nil {
+		if existed {
+			retention.policies[name] = prev
+		} else {
+			delete(retention.policies, name)
+		}
+		log.Printf("failed to save retention policies: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to save retention policy"))
+		return
+	}
+	if existed {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func deleteRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "tenant")
+	retention.Lock()
+	defer retention.Unlock()
+	prev, ok := retention.policies[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No retention policy for this tenant"))
+		return
+	}
+	delete(retention.policies, name)
+	if err := saveRetentionLocked(); err != nil {
+		retention.policies[name] = prev
+		log.Printf("failed to save retention policies: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to save retention policies"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
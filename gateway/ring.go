@@ -2,24 +2,36 @@ package main
 
 import (
 	"log"
+	"slices"
 	"sort"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/zeebo/xxh3"
-
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
 )
 
 var NUM_VIRTUAL_NODES = 256 // per physical node
 // TODO: implement power of two choices of consistent hashing with bounded loads to improve distribution even further (but with added costs)
 
+var REPLICATION_FACTOR = env.Int("REPLICATION_FACTOR", 1) // physical nodes holding each shard (primary + replicas)
+
 var state = &GatewayState{
 	ring:     make(HashRing, 0),
 	clients:  make(map[string]*grpc.ClientConn),
 	lastSeen: make(map[string]int64),
+	coverage: make(map[string]coverageHint),
+	written:  make(map[string]*writtenCells),
+	versions: make(map[string]uint32),
+	builds:   make(map[string]string),
+	draining: make(map[string]bool),
+	capacity: make(map[string]*pb.WorkerCapacity),
+	skews:    make(map[string]time.Duration),
+	features: make(map[string][]string),
 }
 
 type RingNode struct {
@@ -44,21 +56,45 @@ type GatewayState struct {
 	ringMutex   sync.RWMutex
 	ring        HashRing
 	lastSeen    map[string]int64            // worker id (vnode-independent) -> last seen timestamp
+	draining    map[string]bool             // address -> drained by a rolling restart (no keys routed to it)
+	ringChanged time.Time                   // last time keys moved between workers (a worker joined, left or drained)
 	clients     map[string]*grpc.ClientConn // address -> grpc client connection
 	clientMutex sync.RWMutex
+
+	coverage      map[string]coverageHint  // address -> latest coverage hint from worker heartbeats
+	written       map[string]*writtenCells // address -> cells written to it since its hint before last
+	coverageMutex sync.RWMutex
+
+	versions      map[string]uint32 // address -> api version negotiated from worker heartbeats
+	builds        map[string]string // address -> release announced in worker heartbeats
+	versionsMutex sync.RWMutex
+
+	capacity      map[string]*pb.WorkerCapacity // address -> latest resource sample from worker heartbeats
+	capacityMutex sync.RWMutex
+
+	skews      map[string]time.Duration // address -> worker clock minus gateway clock, from worker heartbeats
+	skewsMutex sync.RWMutex
+
+	features      map[string][]string // address -> features listed in worker heartbeats (none: assumed all)
+	featuresMutex sync.RWMutex
 }
 
 func (g *GatewayState) addNode(workerId string, address string) {
 	g.ringMutex.Lock() // append all vnodes atomically
 	defer g.ringMutex.Unlock()
 
-	// TODO: can addresses change? if same worker (id) sends heartbeat but with different address, that won't be reflected in the ring
-
-	now := time.Now().Unix()
+	now := monotonicNow().Unix()
 	// check if physical node already in the ring
 	if _, exists := g.lastSeen[workerId]; exists {
-		g.lastSeen[workerId] = now // update last seen timestamp
-		return
+		old := g.serverOfLocked(workerId)
+		if old == address {
+			g.lastSeen[workerId] = now // update last seen timestamp
+			return
+		}
+		// same worker id at a new address (a promoted warm standby): move its virtual nodes
+		log.Printf("worker %s moved from %s to %s", workerId, old, address)
+		g.removeNodeLocked(workerId)
+		g.dropServer(old)
 	}
 
 	// new node added: increment metric
@@ -80,12 +116,16 @@ func (g *GatewayState) addNode(workerId string, address string) {
 		buf = append(buf, '#')
 		buf = strconv.AppendInt(buf, int64(i), 10)
 
-		hash := xxh3.HashString(string(buf))
+		hash := ringHash.Hash(string(buf))
 		g.ring = append(g.ring, RingNode{Hash: hash, Server: address})
 	}
 
 	sort.Sort(g.ring)
 	g.lastSeen[workerId] = now
+	g.ringChanged = clock.Now()
+	if WARM_CONNS {
+		go g.warmConn(address) // not under ringMutex
+	}
 }
 
 func (g *GatewayState) removeNode(workerId string) {
@@ -107,7 +147,7 @@ func (g *GatewayState) removeNodeLocked(workerId string) string {
 		buf = append(buf, workerId...)
 		buf = append(buf, '#')
 		buf = strconv.AppendInt(buf, int64(i), 10)
-		hash := xxh3.HashString(string(buf))
+		hash := ringHash.Hash(string(buf))
 		hashesToRemove[hash] = struct{}{}
 	}
 
@@ -127,37 +167,70 @@ func (g *GatewayState) removeNodeLocked(workerId string) string {
 
 	if server != "" {
 		Metrics.workerNodesTotal.Dec()
+		g.ringChanged = clock.Now()
 	}
 
 	return server
 }
 
+// serverOfLocked returns the address of a worker in the ring (by its first virtual node). ringMutex must be held
+func (g *GatewayState) serverOfLocked(workerId string) string {
+	hash := ringHash.Hash(workerId + "#0")
+	index := sort.Search(len(g.ring), func(i int) bool {
+		return g.ring[i].Hash >= hash
+	})
+	if index < len(g.ring) && g.ring[index].Hash == hash {
+		return g.ring[index].Server
+	}
+	return ""
+}
+
+// dropServer closes the connection to a worker address removed from the ring and forgets its state
+func (g *GatewayState) dropServer(server string) {
+	if server == "" {
+		return
+	}
+	g.clientMutex.Lock()
+	conn := g.clients[server]
+	if conn != nil {
+		conn.Close()
+		delete(g.clients, server)
+		deleteWorkerLimiterMetrics(server)
+	}
+	g.clientMutex.Unlock()
+	g.deleteCoverage(server)
+	g.deleteAPIVersion(server)
+	g.deleteBuildVersion(server)
+	g.deleteCapacity(server)
+	g.deleteClockSkew(server)
+	g.deleteFeatures(server)
+	forgetWorkerHeartbeats(server)
+	delete(g.draining, server) // ringMutex is held
+	Metrics.workerDraining.DeleteLabelValues(server)
+}
+
 func (g *GatewayState) cleanupDeadNodes(ttl time.Duration, tick_time time.Duration) {
 	ticker := time.NewTicker(tick_time)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		g.ringMutex.Lock()
-
-		now := time.Now().Unix()
-		for workerId, lastSeen := range g.lastSeen {
-			if now-lastSeen > int64(ttl.Seconds()) {
-				// remove node from ring
-				server := g.removeNodeLocked(workerId)
-				// close and delete connection to worker node from pool
-				if server != "" {
-					g.clientMutex.Lock()
-					conn := g.clients[server]
-					if conn != nil {
-						conn.Close()
-						delete(g.clients, server)
-					}
-					g.clientMutex.Unlock()
-				}
-			}
-		}
+		g.expireNodes(ttl)
+	}
+}
+
+// expireNodes removes the workers not seen for longer than ttl from the ring
+func (g *GatewayState) expireNodes(ttl time.Duration) {
+	g.ringMutex.Lock()
+	defer g.ringMutex.Unlock()
 
-		g.ringMutex.Unlock()
+	now := monotonicNow().Unix()
+	for workerId, lastSeen := range g.lastSeen {
+		if now-lastSeen > int64(ttl.Seconds()) {
+			// remove node from ring
+			server := g.removeNodeLocked(workerId)
+			// close and delete connection to worker node from pool
+			g.dropServer(server)
+		}
 	}
 }
 
@@ -178,16 +251,36 @@ func (g *GatewayState) GetConn(address string) (*grpc.ClientConn, error) {
 		return conn, nil
 	}
 
-	newConn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	limiter := newWorkerLimiter(address)
+	target, opts := transport.dial(address)
+	newConn, err := grpc.NewClient(target, rpc.DialOptions(append(opts, grpc.WithChainUnaryInterceptor(gatewayIdUnaryInterceptor, traceUnaryInterceptor, limiter.unaryInterceptor))...)...)
 	if err != nil {
 		log.Printf("failed to create new client connection: %v", err)
 		return nil, err
 	}
 
 	g.clients[address] = newConn
+	go watchConn(newConn)
 	return newConn, nil
 }
 
+// workerServers returns the address of every worker in the ring (once, despite its virtual nodes)
+func (g *GatewayState) workerServers() []string {
+	g.ringMutex.RLock()
+	defer g.ringMutex.RUnlock()
+
+	seenServers := make(map[string]struct{})
+	servers := make([]string, 0, len(g.ring)/NUM_VIRTUAL_NODES+1)
+	for _, node := range g.ring {
+		if _, seen := seenServers[node.Server]; seen {
+			continue
+		}
+		seenServers[node.Server] = struct{}{}
+		servers = append(servers, node.Server)
+	}
+	return servers
+}
+
 func (g *GatewayState) GetNodeAddress(geohash string) string {
 	g.ringMutex.RLock()
 	defer g.ringMutex.RUnlock()
@@ -196,16 +289,74 @@ func (g *GatewayState) GetNodeAddress(geohash string) string {
 		return ""
 	}
 
-	hash := xxh3.HashString(geohash)
+	hash := ringHash.Hash(geohash)
 
 	// binary search O(log n)
 	index := sort.Search(len(g.ring), func(i int) bool {
 		return g.ring[i].Hash >= hash
 	})
-	// wrap around
-	if index == len(g.ring) {
-		index = 0
+	// wrap around, past draining workers (unless all are)
+	for i := 0; i < len(g.ring); i++ {
+		if server := g.ring[(index+i)%len(g.ring)].Server; !g.draining[server] {
+			return server
+		}
 	}
+	return g.ring[index%len(g.ring)].Server
+}
+
+func (g *GatewayState) GetNodeAddresses(geohash string, n int) []string {
+	// returns up to n distinct physical nodes for a key, walking the ring clockwise from its position
+	// the first address is the primary, the rest are replicas (in preference order)
+	g.ringMutex.RLock()
+	defer g.ringMutex.RUnlock()
+
+	if len(g.ring) == 0 || n < 1 {
+		return nil
+	}
+
+	hash := ringHash.Hash(geohash)
+	index := sort.Search(len(g.ring), func(i int) bool {
+		return g.ring[i].Hash >= hash
+	})
 
-	return g.ring[index].Server
+	servers := make([]string, 0, n)
+	for _, skipDraining := range []bool{true, false} { // draining workers only if all are
+		for i := 0; i < len(g.ring) && len(servers) < n; i++ {
+			server := g.ring[(index+i)%len(g.ring)].Server
+			if slices.Contains(servers, server) || (skipDraining && g.draining[server]) {
+				continue // another vnode of an already selected physical node
+			}
+			servers = append(servers, server)
+		}
+		if len(servers) > 0 {
+			break
+		}
+	}
+	return servers
+}
+
+// setDraining marks a worker drained by a rolling restart (see registry/rollout.go): keys are routed to the next
+// worker on the ring, while broadcast queries keep reading the TTL window it holds
+func (g *GatewayState) setDraining(address string, draining bool) {
+	g.ringMutex.Lock()
+	defer g.ringMutex.Unlock()
+	if g.draining[address] == draining {
+		return
+	}
+	g.ringChanged = clock.Now()
+	if draining {
+		g.draining[address] = true
+		Metrics.workerDraining.WithLabelValues(address).Set(1)
+		log.Printf("worker %s draining", address)
+		return
+	}
+	delete(g.draining, address)
+	Metrics.workerDraining.WithLabelValues(address).Set(0)
+	log.Printf("worker %s no longer draining", address)
+}
+
+func (g *GatewayState) isDraining(address string) bool {
+	g.ringMutex.RLock()
+	defer g.ringMutex.RUnlock()
+	return g.draining[address]
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/xxh3"
+)
+
+// ring hash function: shard keys and virtual nodes are placed on the ring by a 64-bit hash, xxh3 (the default), xxhash
+// (XXH64) or fnv (FNV-1a), with an optional seed to reshuffle the placement. every gateway has to place keys the same
+// way or they route a key to different workers (split routing), so both are only set by the registry's cluster
+// settings (CLUSTER_RING_HASH, CLUSTER_RING_SEED), never per gateway, and like the other settings a change applies
+// when the gateways restart
+type RingHasher interface {
+	Hash(key string) uint64
+	Name() string
+}
+
+var ringHash RingHasher = xxh3Hasher{}
+
+type xxh3Hasher struct{ seed uint64 }
+
+func (h xxh3Hasher) Hash(key string) uint64 {
+	if h.seed == 0 {
+		return xxh3.HashString(key) // the placement from before seeds
+	}
+	return xxh3.HashStringSeed(key, h.seed)
+}
+
+func (h xxh3Hasher) Name() string { return "xxh3" }
+
+type xxhashHasher struct{ seed uint64 }
+
+func (h xxhashHasher) Hash(key string) uint64 {
+	if h.seed == 0 {
+		return xxhash.Sum64String(key)
+	}
+	d := xxhash.NewWithSeed(h.seed)
+	d.WriteString(key)
+	return d.Sum64()
+}
+
+func (h xxhashHasher) Name() string { return "xxhash" }
+
+type fnvHasher struct{ seed uint64 }
+
+// Hash is FNV-1a over the seed (little endian, if any) then the key, without the allocations of hash/fnv, finished with
+// murmur3's fmix64: plain FNV-1a barely spreads keys differing in their last bytes, like the virtual nodes of a worker
+func (h fnvHasher) Hash(key string) uint64 {
+	const offset64, prime64 = 14695981039346656037, 1099511628211
+	sum := uint64(offset64)
+	if h.seed != 0 {
+		for i := 0; i < 8; i++ {
+			sum ^= (h.seed >> (8 * i)) & 0xff
+			sum *= prime64
+		}
+	}
+	for i := 0; i < len(key); i++ {
+		sum ^= uint64(key[i])
+		sum *= prime64
+	}
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	sum *= 0xc4ceb9fe1a85ec53
+	sum ^= sum >> 33
+	return sum
+}
+
+func (h fnvHasher) Name() string { return "fnv" }
+
+// newRingHasher returns a ring hash function by name ("" = xxh3)
+func newRingHasher(name string, seed uint64) (RingHasher, error) {
+	switch name {
+	case "", "xxh3":
+		return xxh3Hasher{seed}, nil
+	case "xxhash":
+		return xxhashHasher{seed}, nil
+	case "fnv":
+		return fnvHasher{seed}, nil
+	}
+	return nil, fmt.Errorf("unknown ring hash %q (xxh3, xxhash or fnv)", name)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/xxh3"
+)
+
+func withRingHash(t *testing.T, h RingHasher) {
+	previous := ringHash
+	ringHash = h
+	t.Cleanup(func() { ringHash = previous })
+}
+
+func TestDefaultRingHashKeepsThePlacement(t *testing.T) {
+	h, err := newRingHasher("", 0)
+	if err != nil || h.Hash("ezjmgtw") != xxh3.HashString("ezjmgtw") {
+		t.Fatalf("the default ring hash moved keys (err %v)", err)
+	}
+	if _, err := newRingHasher("crc32", 0); err == nil {
+		t.Fatal("unknown ring hash accepted")
+	}
+}
+
+func TestRingHashesSpreadKeys(t *testing.T) {
+	for _, name := range []string{"xxh3", "xxhash", "fnv"} {
+		for _, seed := range []uint64{0, 42} {
+			t.Run(fmt.Sprintf("%s/%d", name, seed), func(t *testing.T) {
+				h, err := newRingHasher(name, seed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if seed != 0 && h.Hash("ezjmgtw") == (mustRingHasher(t, name, 0)).Hash("ezjmgtw") {
+					t.Fatal("the seed doesn't change the placement")
+				}
+				withRingHash(t, h)
+				g := newTestGatewayState(t)
+				for i := range 4 {
+					g.addNode(fmt.Sprintf("%08x-7c1e-4d5a-9b8e-2f6a1c3d4e5f", i), fmt.Sprintf("w%d:50051", i))
+				}
+				counts := make(map[string]int)
+				const keys = 20000
+				for i := range keys {
+					counts[g.GetNodeAddresses(fmt.Sprintf("key%d", i), 1)[0]]++
+				}
+				for addr, n := range counts {
+					if share := float64(n) / keys; share < 0.15 || share > 0.35 {
+						t.Fatalf("%s got %.0f%% of the keys, want about 25%%", addr, share*100)
+					}
+				}
+			})
+		}
+	}
+}
+
+func mustRingHasher(t *testing.T, name string, seed uint64) RingHasher {
+	h, err := newRingHasher(name, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"geostreamdb/geo"
+)
+
+// GET /admin/route?lat=&lng=: how this gateway routes a coordinate, to answer "why did my ping go there?" in one call:
+// its max precision geohash, the shard key (geohash prefix at the sharding precision) and the key's hash, the virtual
+// node it lands on and the workers it resolves to (primary first, past draining ones), with their connection state.
+// during a sharding migration the coordinate has a shard at both precisions, both read and one written to
+type routeShard struct {
+	Key       string        `json:"key"`
+	Precision int           `json:"precision"`
+	RingHash  string        `json:"ringHash"`        // ring hash of the key (see ringhash.go), hex
+	VNode     *routeVNode   `json:"vnode,omitempty"` // first virtual node at or after the hash, nil with an empty ring
+	Write     bool          `json:"write"`           // pings are written to this shard (all are read)
+	Workers   []routeWorker `json:"workers"`
+}
+
+type routeVNode struct {
+	Hash   string `json:"hash"` // hex
+	Server string `json:"server"`
+}
+
+type routeWorker struct {
+	Address    string  `json:"address"`
+	Role       string  `json:"role"` // primary or replica
+	WorkerID   string  `json:"workerId,omitempty"`
+	APIVersion uint32  `json:"apiVersion"`
+	Build      string  `json:"build,omitempty"`
+	Draining   bool    `json:"draining,omitempty"`
+	RingShare  float64 `json:"ringShare"`
+	Conn       string  `json:"conn"` // grpc connectivity state, none if never connected
+}
+
+// vnodeOf returns the first virtual node at or after a hash (wrapping around), false with an empty ring
+func (g *GatewayState) vnodeOf(hash uint64) (RingNode, bool) {
+	g.ringMutex.RLock()
+	defer g.ringMutex.RUnlock()
+	if len(g.ring) == 0 {
+		return RingNode{}, false
+	}
+	index := sort.Search(len(g.ring), func(i int) bool {
+		return g.ring[i].Hash >= hash
+	})
+	return g.ring[index%len(g.ring)], true
+}
+
+// workerIdOf returns the id a worker address is in the ring with, "" if none
+func (g *GatewayState) workerIdOf(address string) string {
+	g.ringMutex.RLock()
+	defer g.ringMutex.RUnlock()
+	for workerId := range g.lastSeen {
+		if g.serverOfLocked(workerId) == address {
+			return workerId
+		}
+	}
+	return ""
+}
+
+// connState returns the state of the connection to a worker, without connecting to it
+func (g *GatewayState) connState(address string) string {
+	g.clientMutex.RLock()
+	defer g.clientMutex.RUnlock()
+	if conn, ok := g.clients[address]; ok {
+		return conn.GetState().String()
+	}
+	return "none"
+}
+
+func getRoute(w http.ResponseWriter, r *http.Request) {
+	lat, errLat := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lng, errLng := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+	if errLat != nil || errLng != nil || math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid lat or lng"))
+		return
+	}
+
+	gh := geo.Encode(lat, lng, MAX_GH_PRECISION)
+	s, now := sharding.Load(), time.Now().UnixMilli()
+	writeKey := s.writeKey(gh, now) // one of the keys read
+	keys := s.readKeys(gh, now)
+
+	shares := state.ringShares()
+	shards := make([]routeShard, 0, len(keys))
+	for _, key := range keys {
+		hash := ringHash.Hash(key)
+		shard := routeShard{
+			Key:       key,
+			Precision: len(key),
+			RingHash:  fmt.Sprintf("%016x", hash),
+			Write:     key == writeKey,
+			Workers:   make([]routeWorker, 0, REPLICATION_FACTOR),
+		}
+		if vnode, ok := state.vnodeOf(hash); ok {
+			shard.VNode = &routeVNode{Hash: fmt.Sprintf("%016x", vnode.Hash), Server: vnode.Server}
+		}
+		for j, addr := range state.GetNodeAddresses(key, REPLICATION_FACTOR) {
+			worker := routeWorker{
+				Address:    addr,
+				Role:       "replica",
+				WorkerID:   state.workerIdOf(addr),
+				APIVersion: state.apiVersion(addr),
+				Build:      state.buildVersionOf(addr),
+				Draining:   state.isDraining(addr),
+				RingShare:  shares[addr],
+				Conn:       state.connState(addr),
+			}
+			if j == 0 {
+				worker.Role = "primary"
+			}
+			shard.Workers = append(shard.Workers, worker)
+		}
+		shards = append(shards, shard)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"geohash":           gh,
+		"shardingPrecision": s.precision,
+		"replicationFactor": REPLICATION_FACTOR,
+		"migrating":         s.migrating(now),
+		"shards":            shards,
+	})
+}
@@ -1,13 +1,15 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -15,25 +17,39 @@ import (
 
 	pb "geostreamdb/proto"
 
+	"geostreamdb/geo"
 	"github.com/felixge/httpsnoop"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zeebo/xxh3"
 )
 
 type gpsPing struct {
 	Latitude  *float64 `json:"lat"`
 	Longitude *float64 `json:"lng"`
+	DeviceID  string   `json:"deviceId,omitempty"` // optional, kept by workers with raw retention
+	Seq       uint64   `json:"seq,omitempty"`      // optional per-device sequence number, repeats are dropped by the worker
+	SentAt    int64    `json:"sentAt,omitempty"`   // optional client send time (unix ms), for end-to-end latency (see freshness.go)
+	Speed     *float64 `json:"speed,omitempty"`    // optional, meters per second (see movement.go)
+	Heading   *float64 `json:"heading,omitempty"`  // optional, degrees clockwise from north, with a speed
+	Floor     *int32   `json:"floor,omitempty"`    // optional vertical bucket, or:
+	Altitude  *float64 `json:"altitude,omitempty"` // optional, meters (see floors.go)
+	Accuracy  *float64 `json:"accuracy,omitempty"` // optional radius of the fix, meters (see accuracy.go)
 }
 
 var MAX_GH_PRECISION = 8
+
+const maxPingBody = 1 << 12 // bytes, for POST /ping
 var MAX_PINGAREA_GEOHASHES = int64(5000)
 var SHARDING_PRECISION = 7
+var MAX_SMOOTH_WINDOWS = 60 // workers further cap smooth=N at half their TTL window
 
 // <middleware>
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, If-None-Match, X-Request-ID, traceparent, X-Consistency, X-Read-Token, X-Ping-Timestamp, X-Ping-Nonce, X-Ping-Signature")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID, traceparent, X-Precision-Used, X-Consistency-Acks, X-Consistency-Achieved, X-Read-Token, X-Data-As-Of, X-Staleness-Ms")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -51,28 +67,106 @@ func metricsMiddleware(next http.Handler) http.Handler {
 
 		Metrics.httpRequestsTotal.WithLabelValues(endpoint, status).Inc()
 		Metrics.httpLatency.WithLabelValues(endpoint).Observe(m.Duration.Seconds())
+		observeSLO(endpoint, m.Code, m.Duration)
+		logAccess(r, endpoint, m)
 	})
 }
 
 // </middleware>
 
 func setup_router() *chi.Mux {
+	// ingest/query routes moved to a dedicated listener (see listeners.go) are not served here
+	groups := make([]*routeGroup, 0, 2)
+	for _, g := range []*routeGroup{ingestGroup, queryGroup} {
+		if g.port == "" {
+			groups = append(groups, g)
+		}
+	}
+	router := newRouter(groups...)
+
+	router.Route("/admin", func(admin chi.Router) {
+		admin.Use(adminAuthMiddleware)
+		admin.Get("/usage", getUsage)
+		admin.Get("/workers", getWorkers)
+		admin.Get("/route", getRoute)
+		admin.Get("/slo", getSLO)
+		admin.Get("/capacity", getCapacity)
+		admin.Get("/zones", getZoneSets)
+		admin.Get("/zones/{set}", getZoneSet)
+		admin.Put("/zones/{set}", putZoneSet)
+		admin.Delete("/zones/{set}", deleteZoneSet)
+		admin.Get("/retention", getRetentionPolicies)
+		admin.Get("/retention/{tenant}", getRetentionPolicy)
+		admin.Put("/retention/{tenant}", putRetentionPolicy)
+		admin.Delete("/retention/{tenant}", deleteRetentionPolicy)
+		admin.Get("/tenants", getTenants)
+		admin.Get("/tenants/{name}", getTenant)
+		admin.Put("/tenants/{name}", putTenant)
+		admin.Delete("/tenants/{name}", deleteTenant)
+		admin.Get("/acls", getACLs)
+		admin.Get("/acls/{tenant}", getACL)
+		admin.Put("/acls/{tenant}", putACL)
+		admin.Delete("/acls/{tenant}", deleteACL)
+		admin.Get("/store", getStore)
+		admin.Get("/state", getGatewayState)
+		admin.Post("/state", postGatewayState)
+		admin.Post("/generate", postGenerate)
+		admin.Delete("/generate", deleteGenerate)
+		admin.Delete("/device/{id}", deleteDevice)
+	})
+
+	// Prometheus metrics endpoint
+	router.Handle("/metrics", promhttp.Handler())
+	router.Get("/version", getVersion)
+	router.Get("/healthz", getHealthz)
+
+	return router
+}
+
+func newRouter(groups ...*routeGroup) *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(corsMiddleware)
+	router.Use(traceMiddleware)
 	router.Use(metricsMiddleware)
 	if os.Getenv("DEBUG") == "true" {
 		router.Use(middleware.Logger)
 	}
 
-	router.Get("/ping", getPing)
-	router.Post("/ping", postPing)
+	for _, g := range groups {
+		router.Group(func(r chi.Router) {
+			r.Use(g.middleware, shedMiddleware, usageMiddleware)
+			g.routes(r)
+		})
+	}
 
-	router.Get("/pingArea", getPingArea)
+	return router
+}
 
-	// Prometheus metrics endpoint
-	router.Handle("/metrics", promhttp.Handler())
+func ingestRoutes(r chi.Router) {
+	r.Post("/ping", postPing)
+}
 
-	return router
+func queryRoutes(r chi.Router) {
+	r.Get("/ping", getPing)
+	r.Get("/pings", getPings)
+	r.Get("/pingArea", getPingArea)
+	r.Get("/pingArea/byZone", getPingAreaByZone)
+	r.Get("/pingArea/stream", getPingAreaStream)
+	r.Get("/pingArea/frames", getPingAreaFrames)
+	r.Get("/nearest", getNearest)
+	r.Get("/clusters", getClusters)
+	r.Get("/pingPolygon", getPingPolygon)
+	r.Get("/device/{id}/pings", getDevicePings)
+	r.Get("/stats/global", getGlobalStats)
+	r.Get("/stats/byRegion", getStatsByRegion)
+	r.Get("/anomalies", getAnomalies)
+	r.Get("/forecast", getForecast)
+	r.Post("/jobs/areaQuery", postAreaQueryJob)
+	r.Get("/jobs/{id}", getJob)
+	r.Get("/jobs/{id}/result", getJobResult)
+	r.Delete("/jobs/{id}", deleteJob)
+	grafanaRoutes(r)
+	uiRoutes(r)
 }
 
 func observeGRPC(method string, worker string, err error, start time.Time) {
@@ -85,9 +179,23 @@ func observeGRPC(method string, worker string, err error, start time.Time) {
 }
 
 func postPing(w http.ResponseWriter, r *http.Request) {
+	if reason := filterIngestAddr(requestClientIP(r)); reason != "" {
+		denyIngest(w, reason)
+		return
+	}
+
 	var newGpsPing gpsPing
 
-	if err := json.NewDecoder(r.Body).Decode(&newGpsPing); err != nil {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPingBody)) // kept for the signature (DEVICE_KEYS_FILE)
+	if tooLarge := (*http.MaxBytesError)(nil); errors.As(err, &tooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte("Request body too large"))
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(body, &newGpsPing)
+	}
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Invalid request body"))
 		return
@@ -114,38 +222,116 @@ func postPing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	gh := geohashEncodeWithPrecision(lat, lng, MAX_GH_PRECISION)
-	truncatedGh := gh[:SHARDING_PRECISION] // truncate to sharding precision
+	if len(newGpsPing.DeviceID) > MAX_DEVICE_ID_LENGTH {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Device id too long"))
+		return
+	}
 
-	// get the address of the worker node responsible for this geohash
-	targetAddr := state.GetNodeAddress(truncatedGh)
-	if targetAddr == "" {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte("No workers available"))
+	if newGpsPing.Seq != 0 && newGpsPing.DeviceID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("seq requires a deviceId"))
 		return
 	}
 
-	// Track geohash request routing
-	Metrics.geohashRequestsTotal.WithLabelValues(targetAddr, "routed").Inc()
+	motion, msg := parseMotion(newGpsPing.Speed, newGpsPing.Heading)
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(msg))
+		return
+	}
 
-	// get a connection to the worker node (pool of connections, do not close)
-	conn, err := state.GetConn(targetAddr)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to connect to worker"))
+	floor, msg := parseFloor(newGpsPing.Floor, newGpsPing.Altitude)
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(msg))
+		return
+	}
+
+	if msg := checkAccuracy(newGpsPing.Accuracy); msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(msg))
+		return
+	}
+
+	if !admitSignature(w, r, body, newGpsPing.DeviceID) {
+		return
+	}
+
+	ack, level, ok := parseAck(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid ack (none, leader or all) or consistency (ONE, QUORUM or ALL)"))
+		return
+	}
+
+	t := tenantFor(r)
+	deviceID, seq, sentAt, accuracy := newGpsPing.DeviceID, newGpsPing.Seq, newGpsPing.SentAt, newGpsPing.Accuracy
+	if len(hooksFor(t)) > 0 {
+		p := newHookPing(t, "http", requestClientIP(r), lat, lng, deviceID)
+		p.Seq, p.SentAt, p.Speed, p.Heading, p.Floor, p.Accuracy = seq, sentAt, newGpsPing.Speed, newGpsPing.Heading, floor, accuracy
+		dropped, msg := runIngestHooks(t, p)
+		if dropped {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Ping dropped by an ingest hook"))
+			return
+		}
+		if msg != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(msg))
+			return
+		}
+		lat, lng, deviceID, seq, sentAt, floor, accuracy = p.Lat, p.Lng, p.DeviceID, p.Seq, p.SentAt, p.Floor, p.Accuracy
+		motion, _ = parseMotion(p.Speed, p.Heading)
+	}
+
+	if reason := filterIngestPoint(lat, lng); reason != "" {
+		denyIngest(w, reason)
 		return
 	}
 
-	client := pb.NewWorkerClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+	lat, lng = privacyFor(t).coarsen(applyAccuracy(lat, lng, accuracy))
+	ingestedAt := monotonicNow().UnixMilli() // workers bucket the ping by this time (every replica in the same second)
+	gh := geo.Encode(lat, lng, MAX_GH_PRECISION)
+	sentAt = checkSentAt(sentAt, ingestedAt)
+
+	if !aclFor(t).allowsPoint(lat, lng) {
+		denyACL(w, t)
+		return
+	}
+	if !admitUsage(w, r, unitPings, 1) {
+		return
+	}
+
+	if ack == ackNone {
+		if !enqueuePing(gh, ingestedAt, t, deviceID, seq, sentAt, motion, floor) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Ingest queue full"))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Ping queued, geohash: " + gh))
+		return
+	}
 
-	start := time.Now()
-	_, err = client.SendPing(ctx, &pb.PingRequest{Geohash: gh})
-	observeGRPC("SendPing", targetAddr, err, start)
+	p := asyncPing{gh: gh, ingestedAt: ingestedAt, tenant: t, deviceID: deviceID, seq: seq, sentAt: sentAt, motion: motion, floor: floor}
+	acks, primary, exceeded, err := writePingWithin(r.Context(), p, level)
+	if exceeded {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Ping accepted, write completing in the background, geohash: " + gh))
+		return
+	}
+	writeConsistencyHeaders(w, acks)
+	if token, ok := tokenOf(primary); ok {
+		w.Header().Set("X-Read-Token", token.String())
+	}
+	if errors.Is(err, errDuplicatePing) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Duplicate ping ignored, geohash: " + gh))
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to contact worker"))
+		writeError(w, err) // with errConsistency, the ping may be stored by some replicas
 		return
 	}
 
@@ -179,47 +365,231 @@ func getPing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	gh := geohashEncodeWithPrecision(lat, lng, MAX_GH_PRECISION)
-	truncatedGh := gh[:SHARDING_PRECISION] // truncate to sharding precision
+	if math.IsNaN(lat) || math.IsNaN(lng) || math.IsInf(lat, 0) || math.IsInf(lng, 0) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid lat or lng value"))
+		return
+	}
 
-	// get the address of the worker node responsible for this geohash
-	targetAddr := state.GetNodeAddress(truncatedGh)
-	if targetAddr == "" {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte("No workers available"))
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Latitude or longitude out of bounds"))
 		return
 	}
 
-	// Track geohash request routing
-	Metrics.geohashRequestsTotal.WithLabelValues(targetAddr, "routed").Inc()
+	level, ok := parseConsistency(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid consistency (ONE, QUORUM or ALL)"))
+		return
+	}
+	token, hasToken, err := requestReadToken(r)
+	if err != nil || (hasToken && level != consistencyOne) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid read token (or token with a consistency level)"))
+		return
+	}
+	staleOk := query.Get("staleOk") == "true"
+	if staleOk && hasToken {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("staleOk can't be used with a read token"))
+		return
+	}
+
+	gh := geo.Encode(lat, lng, MAX_GH_PRECISION)
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(geo.Bbox{MinLat: lat, MaxLat: lat, MinLng: lng, MaxLng: lng}, MAX_GH_PRECISION) {
+		denyACL(w, t)
+		return
+	}
+	if !admitUsage(w, r, unitCells, 1) {
+		return
+	}
 
-	// get a connection to the worker node (pool of connections, do not close)
-	conn, err := state.GetConn(targetAddr)
+	var v *pb.GetPingsResponse
+	if hasToken {
+		v, err = readAfter(r.Context(), gh, token)
+	} else {
+		var acks int
+		v, acks, err = readPoint(r.Context(), gh, level)
+		writeConsistencyHeaders(w, acks)
+	}
+	var asOf time.Time
+	if staleOk {
+		v, asOf, err = stalePoint(gh, v, err)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to connect to worker"))
+		writeError(w, err)
 		return
 	}
 
-	client := pb.NewWorkerClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+	resp := map[string]int64{"count": privacyFor(t).suppressCount(t, v.Count), "timestamp": v.Timestamp}
+	if staleOk {
+		resp["dataAsOf"], resp["staleness_ms"] = writeFreshness(w, asOf)
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func getPingArea(w http.ResponseWriter, r *http.Request) {
+	q, status, msg := parsePingAreaQuery(r.URL.Query())
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+		return
+	}
+
+	compareOffset, ok := parseCompare(r.URL.Query())
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid compare (1d or 1w)"))
+		return
+	}
+
+	format, ok := parseFormat(r.URL.Query(), formatCSV)
+	if !ok || (format != formatJSON && !exportable(r.URL.Query())) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid format (json or csv, which only exports counts)"))
+		return
+	}
+
+	grid, status, msg := parseGrid(r.URL.Query(), q.bbox())
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+		return
+	}
+
+	placeFilter := r.URL.Query().Get("place")
+	withPlace := placeFilter != "" || r.URL.Query().Get("places") == "true"
+	if withPlace && geocoder == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write([]byte("Places require reverse geocoding (REVERSE_GEOCODER)"))
+		return
+	}
 
-	start := time.Now()
-	v, err := client.GetPings(ctx, &pb.GetPingsRequest{Geohash: gh})
-	observeGRPC("GetPings", targetAddr, err, start)
+	plan, err := planner.PlanWithinBudget(retentionFor(tenantFor(r)).limit(q))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("X-Precision-Used", strconv.Itoa(plan.query.precision))
+
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(plan.query.bbox(), plan.query.precision) {
+		denyACL(w, t)
+		return
+	}
+	if !retentionFor(t).allowsHistory(compareOffset) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("Comparison beyond the history retention of this API key"))
+		return
+	}
+	cells := plan.query.estimated
+	if compareOffset > 0 {
+		cells *= 2 // the baseline is a second query
+	}
+	if !admitUsage(w, r, unitCells, cells) {
+		return
+	}
+
+	counts := plan.Execute(r.Context())
+	logSlowQuery(r, plan)
+	var asOf time.Time
+	staleOk := r.URL.Query().Get("staleOk") == "true"
+	if staleOk {
+		counts, asOf = staleArea(plan, counts)
+	}
+	combined := privacyFor(t).suppress(t, counts)
+	if withPlace {
+		combined = withPlaces(combined, placeFilter)
+	}
+	if format == formatCSV {
+		if compareOffset == 0 {
+			writeTable(w, format, "pingArea", countsTable(combined))
+			return
+		}
+		_, baseline := queryBaseline(r.Context(), t, plan, compareOffset)
+		if placeFilter != "" {
+			baseline = withPlaces(baseline, placeFilter)
+		}
+		writeTable(w, format, "pingArea", comparedTable(compareCounts(combined, baseline)))
+		return
+	}
+
+	// grid re-bins the counts (see grid.go). grid, compare, explain, autoPrecision and staleOk wrap the counts with the
+	// grid, the baseline, the plan, the precisions and/or the freshness
+	var result any = combined
+	if grid != nil {
+		result = grid.bin(combined)
+	}
+	explain, autoPrecision := r.URL.Query().Get("explain") == "true", r.URL.Query().Get("autoPrecision") == "true"
+	if grid != nil || compareOffset > 0 || explain || autoPrecision || staleOk {
+		wrapped := map[string]any{"counts": result}
+		if grid != nil {
+			wrapped["grid"] = grid
+		}
+		if compareOffset > 0 {
+			baselinePlan, baseline := queryBaseline(r.Context(), t, plan, compareOffset)
+			if placeFilter != "" {
+				baseline = withPlaces(baseline, placeFilter)
+			}
+			wrapped["compare"] = r.URL.Query().Get("compare")
+			wrapped["counts"] = compareCounts(combined, baseline)
+			if explain {
+				wrapped["baselinePlan"] = baselinePlan.explain()
+			}
+		}
+		if explain {
+			wrapped["plan"] = plan.explain()
+		}
+		if autoPrecision {
+			wrapped["requestedPrecision"], _ = strconv.Atoi(r.URL.Query().Get("precision"))
+			wrapped["usedPrecision"] = plan.query.precision
+		}
+		result = wrapped
+	}
+
+	// polling clients revalidate with If-None-Match and get a 304 while the merged result is unchanged
+	body, err := json.Marshal(result) // map keys are sorted, so equal results encode identically
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Failed to get pings from worker"))
+		w.Write([]byte("Failed to encode response"))
 		return
 	}
+	etag := fmt.Sprintf(`"%016x"`, xxh3.Hash(body))
+	w.Header().Set("ETag", etag)
+	var dataAsOf, staleness int64
+	if staleOk { // the freshness is left out of the ETag, which would change on every poll (a 304 has it in its headers)
+		dataAsOf, staleness = writeFreshness(w, asOf)
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if staleOk {
+		wrapped := result.(map[string]any)
+		wrapped["dataAsOf"], wrapped["staleness_ms"] = dataAsOf, staleness
+		if body, err = json.Marshal(wrapped); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to encode response"))
+			return
+		}
+	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]int64{"count": v.Count, "timestamp": v.Timestamp})
+	w.Write(append(body, '\n'))
 }
 
-func getPingArea(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
+// parsePingAreaQuery validates the /pingArea parameters. on failure, returns the status and message to answer with
+func parsePingAreaQuery(query url.Values) (pingAreaQuery, int, string) {
+	return parsePingAreaQueryWithin(query, MAX_PINGAREA_GEOHASHES)
+}
+
+// parsePingAreaQueryWithin is parsePingAreaQuery with another bound than MAX_PINGAREA_GEOHASHES on the cells (see
+// jobs.go)
+func parsePingAreaQueryWithin(query url.Values, maxCells int64) (pingAreaQuery, int, string) {
 	minLatQ := query.Get("minLat")
 	maxLatQ := query.Get("maxLat")
 	minLngQ := query.Get("minLng")
@@ -227,203 +597,83 @@ func getPingArea(w http.ResponseWriter, r *http.Request) {
 	precisionQ := query.Get("precision")
 
 	if minLatQ == "" || maxLatQ == "" || minLngQ == "" || maxLngQ == "" || precisionQ == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Missing query parameters"))
-		return
+		return pingAreaQuery{}, http.StatusBadRequest, "Missing query parameters"
 	}
 
 	// parse query parameters
 	minLat, err := strconv.ParseFloat(minLatQ, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid minimum latitude"))
-		return
+		return pingAreaQuery{}, http.StatusBadRequest, "Invalid minimum latitude"
 	}
 	maxLat, err := strconv.ParseFloat(maxLatQ, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid maximum latitude"))
-		return
+		return pingAreaQuery{}, http.StatusBadRequest, "Invalid maximum latitude"
 	}
 	minLng, err := strconv.ParseFloat(minLngQ, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid minimum longitude"))
-		return
+		return pingAreaQuery{}, http.StatusBadRequest, "Invalid minimum longitude"
 	}
 	maxLng, err := strconv.ParseFloat(maxLngQ, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid maximum longitude"))
-		return
+		return pingAreaQuery{}, http.StatusBadRequest, "Invalid maximum longitude"
 	}
 	precision, err := strconv.Atoi(precisionQ)
 	if err != nil || precision < 1 || precision > MAX_GH_PRECISION {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid precision"))
-		return
+		return pingAreaQuery{}, http.StatusBadRequest, "Invalid precision"
 	}
 
-	if minLat < -90 || maxLat > 90 || minLat > maxLat || minLng < -180 || maxLng > 180 || minLng > maxLng {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Invalid bounding box"))
-		return
+	// NaN compares false against every bound below
+	for _, v := range []float64{minLat, maxLat, minLng, maxLng} {
+		if math.IsNaN(v) {
+			return pingAreaQuery{}, http.StatusBadRequest, "Invalid bounding box"
+		}
 	}
 
-	// safety check: bound how many cells the query precision would create for this bbox
-	estimated, _, _ := estimateGeohashCoverCount(minLat, maxLat, minLng, maxLng, precision)
-	if estimated > MAX_PINGAREA_GEOHASHES {
-		w.WriteHeader(http.StatusRequestEntityTooLarge)
-		w.Write([]byte("Requested area too large for precision"))
-		return
+	if minLat < -90 || maxLat > 90 || minLat > maxLat || minLng < -180 || maxLng > 180 || minLng > maxLng {
+		return pingAreaQuery{}, http.StatusBadRequest, "Invalid bounding box"
 	}
 
-	precUsed, _, _, ok := chooseAggregatedPrecision(precision, minLat, maxLat, minLng, maxLng)
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Bounding box too small for available precisions"))
-		return
+	// smooth=N averages the counts over the last N windows (see worker-node/smooth.go)
+	smooth := 0
+	if smoothQ := query.Get("smooth"); smoothQ != "" {
+		smooth, err = strconv.Atoi(smoothQ)
+		if err != nil || smooth < 1 || smooth > MAX_SMOOTH_WINDOWS {
+			return pingAreaQuery{}, http.StatusBadRequest, "Invalid smooth"
+		}
 	}
 
-	cover := geohashCoverSet(minLat, maxLat, minLng, maxLng, precUsed)
-
-	// TEST: to color geohash by server
-	type ExtendedGetPingAreaResponse struct {
-		*pb.GetPingAreaResponse
-		Server string
+	plan := planner.QueryWithin
+	if query.Get("autoPrecision") == "true" {
+		plan = planner.QueryFinestFitting
 	}
-
-	var results []*ExtendedGetPingAreaResponse
-	var resultsMu sync.Mutex
-
-	if precUsed >= SHARDING_PRECISION {
-		// we can find shards responsible for these geohashes. find and group them
-
-		// group geohashes by shard
-		grouped := make(map[string][]string)
-		for _, geohash := range cover {
-			tarGh := geohash[:SHARDING_PRECISION]
-			targetAddr := state.GetNodeAddress(tarGh)
-			if targetAddr == "" {
-				continue
-			}
-			grouped[targetAddr] = append(grouped[targetAddr], geohash)
-
-			Metrics.geohashRequestsTotal.WithLabelValues(targetAddr, "routed").Inc()
-		}
-
-		// parallel gRPC calls to workers
-		var wg sync.WaitGroup
-		for targetAddr, geohashes := range grouped {
-			wg.Add(1)
-			go func(addr string, ghs []string) {
-				defer wg.Done()
-
-				conn, err := state.GetConn(addr)
-				if err != nil {
-					return
-				}
-
-				client := pb.NewWorkerClient(conn)
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
-
-				start := time.Now()
-				v, err := client.GetPingArea(ctx, &pb.GetPingAreaRequest{
-					Precision:    int32(precision),
-					AggPrecision: int32(precUsed),
-					MinLat:       minLat,
-					MaxLat:       maxLat,
-					MinLng:       minLng,
-					MaxLng:       maxLng,
-					Geohashes:    ghs,
-				})
-				observeGRPC("GetPingArea", addr, err, start)
-
-				if err != nil {
-					return // skip failed worker, return partial response
-				}
-
-				resultsMu.Lock()
-				results = append(results, &ExtendedGetPingAreaResponse{GetPingAreaResponse: v, Server: addr})
-				resultsMu.Unlock()
-			}(targetAddr, geohashes)
-		}
-		wg.Wait()
-	} else {
-		// geohashes will be spread across multiple shards. broadcast query to all nodes
-
-		// first: collect unique servers (avoid repetition because of virtual nodes)
-		state.ringMutex.RLock()
-		seenServers := make(map[string]struct{})
-		servers := make([]string, 0, len(state.ring)/NUM_VIRTUAL_NODES+1)
-		for _, node := range state.ring {
-			if _, seen := seenServers[node.Server]; seen {
-				continue
-			}
-			seenServers[node.Server] = struct{}{}
-			servers = append(servers, node.Server)
+	q, err := plan(minLat, maxLat, minLng, maxLng, precision, maxCells)
+	if err != nil {
+		s := statusOf(err)
+		return q, s.http, s.message
+	}
+	// floor=N only counts the pings of a floor (see floors.go)
+	if floorQ := query.Get("floor"); floorQ != "" {
+		floor, err := strconv.Atoi(floorQ)
+		if err != nil || floor < -maxFloor || floor > maxFloor {
+			return pingAreaQuery{}, http.StatusBadRequest, "Invalid floor"
 		}
-		state.ringMutex.RUnlock()
-
-		// then: parallel broadcast to all workers
-		var wg sync.WaitGroup
-		for _, server := range servers {
-			Metrics.geohashRequestsTotal.WithLabelValues(server, "broadcast").Inc()
-
-			wg.Add(1)
-			go func(addr string) {
-				defer wg.Done()
-
-				conn, err := state.GetConn(addr)
-				if err != nil {
-					return
-				}
-
-				client := pb.NewWorkerClient(conn)
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
-
-				start := time.Now()
-				v, err := client.GetPingArea(ctx, &pb.GetPingAreaRequest{
-					Precision:    int32(precision),
-					AggPrecision: int32(precUsed),
-					MinLat:       minLat,
-					MaxLat:       maxLat,
-					MinLng:       minLng,
-					MaxLng:       maxLng,
-					Geohashes:    cover,
-				})
-				observeGRPC("GetPingArea", addr, err, start)
-
-				if err != nil {
-					return // skip failed worker, return partial response
-				}
-
-				resultsMu.Lock()
-				results = append(results, &ExtendedGetPingAreaResponse{GetPingAreaResponse: v, Server: addr})
-				resultsMu.Unlock()
-			}(server)
+		if query.Get("compare") != "" || query.Get("metrics") != "" {
+			return pingAreaQuery{}, http.StatusBadRequest, "floor only covers the live window counts (not with compare or metrics)"
 		}
-		wg.Wait()
-	}
-
-	type ExtendedPingAreaCount struct {
-		Count  int64
-		Server string
+		q.hasFloor, q.floor = true, int32(floor)
 	}
 
-	// combine all results into a single map of geohash -> count
-	combined := make(map[string]*ExtendedPingAreaCount)
-	for _, result := range results {
-		for _, count := range result.Counts {
-			if _, exists := combined[count.Geohash]; !exists {
-				combined[count.Geohash] = &ExtendedPingAreaCount{Count: 0, Server: result.Server}
-			}
-			combined[count.Geohash].Count += count.Count
+	// metrics=speed adds the movement of each cell (see movement.go)
+	switch query.Get("metrics") {
+	case "":
+	case "speed":
+		if smooth > 0 || query.Get("compare") != "" {
+			return pingAreaQuery{}, http.StatusBadRequest, "metrics=speed only covers the live window (not with smooth or compare)"
 		}
+		q.movement = true
+	default:
+		return pingAreaQuery{}, http.StatusBadRequest, "Invalid metrics (speed)"
 	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(combined)
+	q.smooth = smooth
+	return q, http.StatusOK, ""
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// HTTP servers (PORT and the dedicated INGEST_PORT/QUERY_PORT listeners): bounded header/body reads, response writes
+// and idle keep-alives, so slow or stalled clients can't pin connections, and HTTP/2 over cleartext (h2c, prior
+// knowledge) next to HTTP/1.1 for gRPC-Web proxies and multiplexing clients. on SIGINT/SIGTERM the listeners stop
+// accepting, open streams are ended and in-flight requests get HTTP_SHUTDOWN_TIMEOUT to finish
+var HTTP_READ_HEADER_TIMEOUT = env.Duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second)
+var HTTP_READ_TIMEOUT = env.Duration("HTTP_READ_TIMEOUT", 30*time.Second)   // headers and body
+var HTTP_WRITE_TIMEOUT = env.Duration("HTTP_WRITE_TIMEOUT", 30*time.Second) // event streams are exempt
+var HTTP_IDLE_TIMEOUT = env.Duration("HTTP_IDLE_TIMEOUT", 2*time.Minute)
+var HTTP_SHUTDOWN_TIMEOUT = env.Duration("HTTP_SHUTDOWN_TIMEOUT", 15*time.Second)
+var HTTP_H2C = env.Bool("HTTP_H2C", true)
+
+var httpServers struct {
+	sync.Mutex
+	servers []*http.Server
+}
+
+// shuttingDown is closed once shutdown starts, for handlers that never finish on their own (event streams)
+var shuttingDown = make(chan struct{})
+
+// serveHTTP serves handler on port until shutdown, exiting the process if the listener fails
+func serveHTTP(name string, port string, handler http.Handler) {
+	s := &http.Server{
+		Addr:              ":" + port,
+		Handler:           handler,
+		ReadHeaderTimeout: HTTP_READ_HEADER_TIMEOUT,
+		ReadTimeout:       HTTP_READ_TIMEOUT,
+		WriteTimeout:      HTTP_WRITE_TIMEOUT,
+		IdleTimeout:       HTTP_IDLE_TIMEOUT,
+	}
+	if HTTP_H2C {
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		s.Protocols = &protocols
+	}
+	httpServers.Lock()
+	httpServers.servers = append(httpServers.servers, s)
+	httpServers.Unlock()
+
+	log.Printf("HTTP %s listening on port %s", name, port)
+	if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("failed to serve %s: %v", name, err)
+	}
+}
+
+// waitForShutdown blocks until SIGINT/SIGTERM, then shuts every HTTP server down gracefully
+func waitForShutdown() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	log.Printf("shutting down (waiting up to %s for in-flight requests)", HTTP_SHUTDOWN_TIMEOUT)
+	close(shuttingDown)
+
+	ctx, cancel := context.WithTimeout(context.Background(), HTTP_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	httpServers.Lock()
+	servers := httpServers.servers
+	httpServers.Unlock()
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Printf("HTTP shutdown on %s: %v", s.Addr, err)
+				s.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// the routing layer behind the handlers and listeners: which workers a ping is written to, a point is read from or an
+// area query asks (through its QueryPlanner), and the broadcasts of unsharded data. it only reaches the cluster through
+// a Ring (shard owners, coverage hints) and WorkerClients, both the GatewayState in production, so that it can run
+// against fakes. handlers parse, authorize and account a request, call the service and answer its errors with
+// writeError (see errors.go)
+type Ring interface {
+	GetNodeAddresses(key string, n int) []string // owners of a shard key, primary first
+	workerServers() []string
+	mayHoldAny(address string, geohashes []string) bool // coverage hint
+	wroteTo(address string, key string)                 // a primary write, newer than the coverage hint
+}
+
+type WorkerClients interface {
+	WorkerClient(address string) (pb.WorkerClient, error)
+}
+
+type GatewayService struct {
+	ring    Ring
+	workers WorkerClients
+	planner QueryPlanner
+}
+
+func newGatewayService(ring Ring, workers WorkerClients) *GatewayService {
+	return &GatewayService{ring: ring, workers: workers, planner: QueryPlanner{ring: ring, workers: workers}}
+}
+
+var service = newGatewayService(state, state)
+
+var planner = service.planner
+
+// WorkerClient returns the client of a worker over its pooled connection (do not close)
+func (g *GatewayState) WorkerClient(address string) (pb.WorkerClient, error) {
+	conn, err := g.GetConn(address)
+	if err != nil {
+		return nil, err
+	}
+	return pb.NewWorkerClient(conn), nil
+}
+
+// RoutePing stores a ping (max precision geohash) on its primary worker and, best-effort, on its replicas, returning
+// the primary's acknowledgment (with the write's read token). the device id ("" if unknown) only goes to replicas along
+// with a seq (0 if none) for dedup, they don't retain raw pings. errDuplicatePing if the primary dropped it as a repeat
+func (s *GatewayService) RoutePing(ctx context.Context, gh string, ingestedAt int64, deviceID string, seq uint64) (*pb.PingResponse, error) {
+	truncatedGh := shardKey(gh) // truncate to sharding precision
+
+	// get the address of the worker node responsible for this geohash (and its replicas, if any)
+	targetAddrs := s.ring.GetNodeAddresses(truncatedGh, REPLICATION_FACTOR)
+	if len(targetAddrs) == 0 {
+		return nil, errNoWorkers
+	}
+	targetAddr := targetAddrs[0]
+	teleport, err := checkTeleport(ctx, gh, ingestedAt, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Track geohash request routing
+	Metrics.geohashRequestsTotal.WithLabelValues(targetAddr, "routed", reasonShardOwner).Inc()
+	shadowPing(gh, ingestedAt, deviceID, seq)
+	enrichPing(gh)
+
+	client, err := s.workers.WorkerClient(targetAddr)
+	if err != nil {
+		return nil, errWorkerConnect
+	}
+	s.ring.wroteTo(targetAddr, truncatedGh)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	// replica writes are best-effort and don't hold up the response (nor are cancelled with it)
+	for _, replicaAddr := range targetAddrs[1:] {
+		go s.sendReplicaPing(context.WithoutCancel(ctx), replicaAddr, gh, ingestedAt, deviceID, seq)
+	}
+
+	start := time.Now()
+	req := &pb.PingRequest{Geohash: gh, Timestamp: ingestedAt, DeviceId: deviceID, Tenant: tenantOf(ctx), Seq: seq, Teleport: teleport, Motion: motionOf(ctx), ClientSentAt: state.sentAtFor(ctx, targetAddr), ApiVersion: state.apiVersion(targetAddr)}
+	setFloor(ctx, req)
+	resp, err := client.SendPing(ctx, req)
+	observeGRPC("SendPing", targetAddr, err, start)
+	if err == nil && resp.Duplicate {
+		return resp, errDuplicatePing
+	}
+	return resp, shardError(err)
+}
+
+func (s *GatewayService) sendReplicaPing(ctx context.Context, addr string, gh string, ingestedAt int64, deviceID string, seq uint64) {
+	client, err := s.workers.WorkerClient(addr)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	start := time.Now()
+	req := &pb.PingRequest{Geohash: gh, Replica: true, Timestamp: ingestedAt, Motion: motionOf(ctx), ApiVersion: state.apiVersion(addr)}
+	setFloor(ctx, req)
+	if seq != 0 {
+		req.DeviceId, req.Seq = deviceID, seq
+	}
+	_, err = client.SendPing(ctx, req)
+	observeGRPC("SendPing", addr, err, start)
+}
+
+// QueryPoint gets the count of a max precision geohash from its primary worker (hedged to its replicas). during a
+// sharding migration, from those at both precisions, adding up their counts
+func (s *GatewayService) QueryPoint(ctx context.Context, gh string) (*pb.GetPingsResponse, error) {
+	// get the address of the worker nodes responsible for this geohash (and their replicas, if any)
+	chains := sharding.Load().ownerChains(s.ring, gh, time.Now().UnixMilli())
+	if len(chains) == 0 {
+		return nil, errNoWorkers
+	}
+
+	var out *pb.GetPingsResponse
+	for _, targetAddrs := range chains {
+		// Track geohash request routing
+		Metrics.geohashRequestsTotal.WithLabelValues(targetAddrs[0], "routed", reasonShardOwner).Inc()
+
+		v, _, err := hedgedCall(ctx, "GetPings", targetAddrs, s.pingsFrom(gh))
+		if err != nil {
+			return nil, err
+		}
+		out = addPings(out, v)
+	}
+	return out, nil
+}
+
+// addPings adds up the counts of a geohash from the owners at both precisions of a sharding migration
+func addPings(a, b *pb.GetPingsResponse) *pb.GetPingsResponse {
+	if a == nil {
+		return b
+	}
+	return &pb.GetPingsResponse{Count: a.Count + b.Count, Timestamp: max(a.Timestamp, b.Timestamp), TokenReached: a.TokenReached || b.TokenReached}
+}
+
+// pingsFrom is the GetPings call for a geohash to one of its replicas
+func (s *GatewayService) pingsFrom(gh string) func(ctx context.Context, addr string, replica bool) (*pb.GetPingsResponse, error) {
+	return func(ctx context.Context, addr string, replica bool) (*pb.GetPingsResponse, error) {
+		client, err := s.workers.WorkerClient(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		v, err := client.GetPings(ctx, &pb.GetPingsRequest{Geohash: gh, Replica: replica, ApiVersion: state.apiVersion(addr)})
+		observeGRPC("GetPings", addr, err, start)
+		return v, err
+	}
+}
+
+// Broadcast calls fn on every worker, returning the first error
+func (s *GatewayService) Broadcast(ctx context.Context, method string, fn func(ctx context.Context, addr string, client pb.WorkerClient) error) error {
+	servers := s.ring.workerServers()
+	if len(servers) == 0 {
+		observeRouting(planBroadcast, reasonRingEmpty, 0)
+		return errNoWorkers
+	}
+	observeRouting(planBroadcast, reasonUnsharded, len(servers))
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+	for i, addr := range servers {
+		Metrics.geohashRequestsTotal.WithLabelValues(addr, "broadcast", reasonUnsharded).Inc()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := s.workers.WorkerClient(addr)
+			if err != nil {
+				errs[i] = errWorkerConnect
+				return
+			}
+			ctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+
+			start := time.Now()
+			err = fn(ctx, addr, client)
+			observeGRPC(method, addr, err, start)
+			errs[i] = shardError(err)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the GatewayService against a fake ring and fake workers
+
+type fakeRing struct {
+	owners  []string        // of every key, primary first
+	servers []string        // in the ring
+	holds   map[string]bool // coverage hints (absent: may hold anything)
+}
+
+func (r fakeRing) GetNodeAddresses(key string, n int) []string {
+	return r.owners[:min(n, len(r.owners))]
+}
+
+func (r fakeRing) workerServers() []string {
+	return r.servers
+}
+
+func (r fakeRing) mayHoldAny(address string, geohashes []string) bool {
+	hold, ok := r.holds[address]
+	return !ok || hold
+}
+
+func (r fakeRing) wroteTo(address string, key string) {}
+
+type fakeWorker struct {
+	pb.WorkerClient // the calls not faked panic
+
+	mu        sync.Mutex
+	pings     []*pb.PingRequest
+	count     int64
+	err       error
+	areaCalls int
+	now       int64 // worker clock of its frames (unix seconds)
+}
+
+func (w *fakeWorker) SendPing(ctx context.Context, in *pb.PingRequest, opts ...grpc.CallOption) (*pb.PingResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return nil, w.err
+	}
+	w.pings = append(w.pings, in)
+	return &pb.PingResponse{}, nil
+}
+
+func (w *fakeWorker) GetPings(ctx context.Context, in *pb.GetPingsRequest, opts ...grpc.CallOption) (*pb.GetPingsResponse, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return &pb.GetPingsResponse{Count: w.count}, nil
+}
+
+func (w *fakeWorker) GetPingArea(ctx context.Context, in *pb.GetPingAreaRequest, opts ...grpc.CallOption) (*pb.GetPingAreaResponse, error) {
+	w.mu.Lock()
+	w.areaCalls++
+	w.mu.Unlock()
+	resp := &pb.GetPingAreaResponse{}
+	for _, gh := range in.Geohashes {
+		resp.Counts = append(resp.Counts, &pb.PingAreaCount{Geohash: gh, Count: w.count})
+	}
+	if in.FrameSeconds > 0 {
+		for i := range in.Frames {
+			end := w.now - int64(in.Frames-1-i)*in.FrameSeconds
+			resp.Frames = append(resp.Frames, &pb.PingAreaFrame{End: end, Counts: resp.Counts})
+		}
+		resp.Counts = nil
+	}
+	return resp, nil
+}
+
+func (w *fakeWorker) GetTotal(ctx context.Context, in *pb.GetTotalRequest, opts ...grpc.CallOption) (*pb.GetTotalResponse, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	return &pb.GetTotalResponse{Count: w.count, Timestamp: 1000}, nil
+}
+
+func (w *fakeWorker) received() []*pb.PingRequest {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*pb.PingRequest(nil), w.pings...)
+}
+
+type fakeWorkers map[string]*fakeWorker
+
+func (f fakeWorkers) WorkerClient(address string) (pb.WorkerClient, error) {
+	if w, ok := f[address]; ok {
+		return w, nil
+	}
+	return nil, errors.New("unknown worker")
+}
+
+func withReplicationFactor(t *testing.T, n int) {
+	previous := REPLICATION_FACTOR
+	REPLICATION_FACTOR = n
+	t.Cleanup(func() { REPLICATION_FACTOR = previous })
+}
+
+func TestRoutePingWritesPrimaryAndReplicas(t *testing.T) {
+	withReplicationFactor(t, 2)
+	workers := fakeWorkers{"a": {}, "b": {}}
+	s := newGatewayService(fakeRing{owners: []string{"a", "b"}, servers: []string{"a", "b"}}, workers)
+
+	if _, err := s.RoutePing(context.Background(), "u4pruydq", 1000, "device", 7); err != nil {
+		t.Fatalf("RoutePing: %v", err)
+	}
+	primary := workers["a"].received()
+	if len(primary) != 1 || primary[0].Replica || primary[0].DeviceId != "device" {
+		t.Fatalf("primary got %v, want one non-replica ping with its device", primary)
+	}
+	deadline := time.Now().Add(time.Second) // replica writes are asynchronous
+	for len(workers["b"].received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	replica := workers["b"].received()
+	if len(replica) != 1 || !replica[0].Replica || replica[0].Seq != 7 {
+		t.Fatalf("replica got %v, want one replica ping with its seq", replica)
+	}
+}
+
+func TestRoutePingSendsSentAtOnTheWorkerClock(t *testing.T) {
+	workers := fakeWorkers{"a": {}}
+	s := newGatewayService(fakeRing{owners: []string{"a"}}, workers)
+	state.setClockSkew("a", 2*time.Second) // worker clock ahead
+	t.Cleanup(func() { state.deleteClockSkew("a") })
+
+	ctx := withSentAt(context.Background(), checkSentAt(9_000, 10_000))
+	if _, err := s.RoutePing(ctx, "u4pruydq", 10_000, "", 0); err != nil {
+		t.Fatalf("RoutePing: %v", err)
+	}
+	if got := workers["a"].received()[0].ClientSentAt; got != 11_000 {
+		t.Fatalf("got sentAt %d, want 11000 (9000 + the worker's 2s skew)", got)
+	}
+	if checkSentAt(20_000, 10_000) != 0 || checkSentAt(1, 10_000+MAX_SENT_AT_AGE.Milliseconds()) != 0 {
+		t.Fatal("implausible sentAt (from the future, too old) not ignored")
+	}
+}
+
+func TestRoutePingSendsTheTenantWithTheDevice(t *testing.T) {
+	workers := fakeWorkers{"a": {}}
+	s := newGatewayService(fakeRing{owners: []string{"a"}}, workers)
+	for _, ctx := range []context.Context{
+		withTenant(context.Background(), &tenant{name: "acme"}),
+		withTenant(context.Background(), anonymous),
+		context.Background(),
+	} {
+		if _, err := s.RoutePing(ctx, "u4pruydq", 1000, "truck-42", 0); err != nil {
+			t.Fatalf("RoutePing: %v", err)
+		}
+	}
+	var got []string
+	for _, p := range workers["a"].received() {
+		got = append(got, p.Tenant)
+	}
+	if !slices.Equal(got, []string{"acme", "", ""}) {
+		t.Fatalf("got tenants %q, want acme then anonymous (\"\") twice", got)
+	}
+}
+
+func TestRoutePingErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ring   fakeRing
+		worker *fakeWorker
+		want   error
+		status int
+	}{
+		{"empty ring", fakeRing{}, &fakeWorker{}, errNoWorkers, http.StatusServiceUnavailable},
+		{"unknown worker", fakeRing{owners: []string{"gone"}}, &fakeWorker{}, errWorkerConnect, http.StatusServiceUnavailable},
+		{"timeout", fakeRing{owners: []string{"a"}}, &fakeWorker{err: status.Error(codes.DeadlineExceeded, "slow")}, errShardTimeout, http.StatusGatewayTimeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newGatewayService(tc.ring, fakeWorkers{"a": tc.worker})
+			_, err := s.RoutePing(context.Background(), "u4pruydq", 1000, "", 0)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if got := statusOf(err).http; got != tc.status {
+				t.Fatalf("status %d, want %d", got, tc.status)
+			}
+		})
+	}
+}
+
+func TestQueryPoint(t *testing.T) {
+	s := newGatewayService(fakeRing{owners: []string{"a"}}, fakeWorkers{"a": {count: 3}})
+	v, err := s.QueryPoint(context.Background(), "u4pruydq")
+	if err != nil || v.Count != 3 {
+		t.Fatalf("got %v, %v, want a count of 3", v, err)
+	}
+}
+
+func TestPlanRoutesFineCellsToTheirOwners(t *testing.T) {
+	workers := fakeWorkers{"a": {count: 1}, "b": {count: 1}}
+	s := newGatewayService(fakeRing{owners: []string{"a"}, servers: []string{"a", "b"}}, workers)
+
+	q, err := s.planner.Query(42.23, 42.231, -8.73, -8.729, MAX_GH_PRECISION)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	q.precUsed = SHARDING_PRECISION
+	plan := s.planner.Plan(q)
+	if plan.Mode != planRouted || plan.Reason != reasonShardOwner || len(plan.Shards) != 1 {
+		t.Fatalf("got a %s plan (%s) of %d shards, want one routed shard", plan.Mode, plan.Reason, len(plan.Shards))
+	}
+	counts := plan.Execute(context.Background())
+	if len(counts) != len(plan.cover) || workers["b"].areaCalls != 0 {
+		t.Fatalf("got %d counts for a cover of %d (b asked %d times)", len(counts), len(plan.cover), workers["b"].areaCalls)
+	}
+}
+
+func TestPlanBroadcastPrunesByCoverageHint(t *testing.T) {
+	previous := BROADCAST_PRUNING
+	BROADCAST_PRUNING = true
+	t.Cleanup(func() { BROADCAST_PRUNING = previous })
+	workers := fakeWorkers{"a": {count: 2}, "b": {count: 5}}
+	ring := fakeRing{owners: []string{"a"}, servers: []string{"a", "b"}, holds: map[string]bool{"b": false}}
+	s := newGatewayService(ring, workers)
+
+	q, err := s.planner.Query(42, 43, -9, -8, 3)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	plan := s.planner.Plan(q)
+	if plan.Mode != planBroadcast || plan.Reason != reasonAggPrecision || len(plan.Shards) != 2 || !plan.Shards[1].Pruned {
+		t.Fatalf("got a %s plan (%s), want a broadcast with b pruned", plan.Mode, plan.Reason)
+	}
+	for gh, c := range plan.Execute(context.Background()) {
+		if c.Count != 2 || c.Server != "a" {
+			t.Fatalf("%s: got %d from %s, want 2 from a only", gh, c.Count, c.Server)
+		}
+	}
+	if workers["b"].areaCalls != 0 {
+		t.Fatalf("pruned worker asked %d times", workers["b"].areaCalls)
+	}
+}
+
+func TestGlobalTotalAddsUpTheWorkersThatAnswered(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "down")
+	workers := fakeWorkers{"a": {count: 3}, "b": {count: 4}, "c": {err: unavailable}}
+	s := newGatewayService(fakeRing{servers: []string{"a", "b"}}, workers)
+
+	total, err := s.GlobalTotal(context.Background())
+	if err != nil || total != (globalTotal{Count: 7, Workers: 2, Complete: true, Timestamp: 1000}) {
+		t.Fatalf("got %+v (%v), want the 7 pings of both workers", total, err)
+	}
+
+	s = newGatewayService(fakeRing{servers: []string{"a", "b", "c"}}, workers)
+	if total, err = s.GlobalTotal(context.Background()); err != nil || total.Count != 7 || total.Complete {
+		t.Fatalf("got %+v (%v), want an incomplete 7 without the failed worker", total, err)
+	}
+
+	s = newGatewayService(fakeRing{servers: []string{"c"}}, workers)
+	if _, err = s.GlobalTotal(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Fatalf("got %v, want the error of the only worker", err)
+	}
+	s = newGatewayService(fakeRing{}, workers)
+	if _, err = s.GlobalTotal(context.Background()); !errors.Is(err, errNoWorkers) {
+		t.Fatalf("got %v on an empty ring, want errNoWorkers", err)
+	}
+}
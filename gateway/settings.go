@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cluster settings distributed by the registry (see registry/settings.go): fetched at startup, before anything reads
+// them, they override SHARDING_PRECISION and REPLICATION_FACTOR and set the ring hash (see ringhash.go). a registry not
+// answering within CLUSTER_SETTINGS_WAIT leaves the gateway's own. later changes only apply on restart: until then the gateway is flagged as stale. sharding
+// migrations are the exception, applied as soon as a heartbeat response carries them (see sharding.go)
+var CLUSTER_SETTINGS_WAIT = env.Duration("CLUSTER_SETTINGS_WAIT", 10*time.Second)
+
+var settingsVersion uint64 // of the settings applied (at startup, or by a sharding migration), 0 = none from the registry
+
+var settingsReplicationFactor uint32 // the registry's replication factor applied at startup (0 = none)
+
+var settingsRingHash, settingsRingSeed = "", uint64(0) // the registry's ring hash applied at startup (see ringhash.go)
+
+var staleSettingsVersion uint64 // last version reported as stale (heartbeat loop only)
+
+// fetchClusterSettings applies the registry's settings, retrying for up to CLUSTER_SETTINGS_WAIT
+func fetchClusterSettings(client pb.RegistryClient) {
+	deadline := time.Now().Add(CLUSTER_SETTINGS_WAIT)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		s, err := client.GetClusterSettings(ctx, &pb.GetClusterSettingsRequest{})
+		cancel()
+		if err == nil {
+			applyClusterSettings(s)
+			return
+		}
+		if status.Code(err) == codes.Unimplemented || time.Now().After(deadline) { // older registry, or none reachable
+			log.Printf("no cluster settings from the registry (%v): using this gateway's own", err)
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func applyClusterSettings(s *pb.ClusterSettings) {
+	if s.Version == 0 {
+		return
+	}
+	if p := int(s.ShardingPrecision); p >= 2 && p <= 7 {
+		if p != SHARDING_PRECISION {
+			log.Printf("sharding precision %d set by the registry (was %d)", p, SHARDING_PRECISION)
+		}
+		SHARDING_PRECISION = p
+		setSharding(p, s.ShardingMigration)
+	}
+	if r := int(s.ReplicationFactor); r > 0 {
+		if r != REPLICATION_FACTOR {
+			log.Printf("REPLICATION_FACTOR=%d overridden by the registry's %d", REPLICATION_FACTOR, r)
+		}
+		REPLICATION_FACTOR = r
+	}
+	if s.RingHash != "" || s.RingSeed != 0 {
+		h, err := newRingHasher(s.RingHash, s.RingSeed)
+		if err != nil {
+			log.Fatalf("cluster settings from the registry: %v", err) // routing differently from the other gateways
+		}
+		ringHash = h
+		log.Printf("ring hash %s (seed %d) set by the registry", h.Name(), s.RingSeed)
+	}
+	settingsReplicationFactor, settingsRingHash, settingsRingSeed = s.ReplicationFactor, s.RingHash, s.RingSeed
+	settingsVersion = s.Version
+	log.Printf("running with cluster settings %x", s.Version)
+}
+
+// checkClusterSettings compares the settings of a heartbeat response with the ones applied, starting the sharding
+// migration they carry if that is their only change
+func checkClusterSettings(s *pb.ClusterSettings) {
+	version := s.GetVersion()
+	if m := s.GetShardingMigration(); version != settingsVersion && m != nil && int(m.FromPrecision) == shardingPrecision() && s.ReplicationFactor == settingsReplicationFactor && s.RingHash == settingsRingHash && s.RingSeed == settingsRingSeed {
+		setSharding(int(m.ToPrecision), m)
+		settingsVersion = version
+		log.Printf("sharding migration from precision %d to %d: writes switch at %s, dual reads until %s", m.FromPrecision, m.ToPrecision, time.UnixMilli(m.SwitchAt).Format(time.RFC3339), time.UnixMilli(m.Until).Format(time.RFC3339))
+	}
+	if sharding.Load().migrating(time.Now().UnixMilli()) {
+		Metrics.shardingMigration.Set(1)
+	} else {
+		Metrics.shardingMigration.Set(0)
+	}
+	if version == settingsVersion {
+		Metrics.settingsStale.Set(0)
+		return
+	}
+	Metrics.settingsStale.Set(1)
+	if version != staleSettingsVersion {
+		staleSettingsVersion = version
+		log.Printf("the registry distributes cluster settings %x, this gateway runs with %x: restart it to apply them", version, settingsVersion)
+	}
+}
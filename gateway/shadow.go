@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
+
+	"github.com/zeebo/xxh3"
+	"google.golang.org/grpc"
+)
+
+// shadow traffic: with SHADOW_WORKERS set, SHADOW_PERCENT of the shards (sharding precision geohashes) have every ping
+// written to them also sent to one of these canary workers, e.g. running a new storage engine build, to validate it
+// against production traffic. sampling whole shards keeps the canary's counts comparable to the ring's for the cells
+// it gets. canaries are outside the ring (start them with SHADOW=true so they don't heartbeat) and never read;
+// mirroring is asynchronous and best-effort: pings that don't fit in SHADOW_QUEUE are dropped and counted
+var SHADOW_WORKERS = env.String("SHADOW_WORKERS", "") // comma-separated host:port
+var SHADOW_PERCENT = env.Float("SHADOW_PERCENT", 10)  // of the shards, 0 to 100
+var SHADOW_QUEUE = env.Int("SHADOW_QUEUE", 65536)     // pings
+var SHADOW_SENDERS = env.Int("SHADOW_SENDERS", 8)
+
+type shadowedPing struct {
+	addr       string
+	client     pb.WorkerClient
+	gh         string
+	ingestedAt int64
+	deviceID   string
+	seq        uint64
+}
+
+type shadowWorker struct {
+	addr   string
+	client pb.WorkerClient
+}
+
+var shadowWorkers []shadowWorker
+var shadowQueue chan shadowedPing
+
+// startShadowing connects to SHADOW_WORKERS and starts the senders draining the shadow queue
+func startShadowing() {
+	for _, addr := range strings.Split(SHADOW_WORKERS, ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		target, opts := transport.dial(addr)
+		conn, err := grpc.NewClient(target, rpc.DialOptions(append(opts, grpc.WithChainUnaryInterceptor(traceUnaryInterceptor))...)...)
+		if err != nil {
+			log.Fatalf("failed to dial shadow worker %s: %v", addr, err)
+		}
+		shadowWorkers = append(shadowWorkers, shadowWorker{addr: addr, client: pb.NewWorkerClient(conn)})
+	}
+	if len(shadowWorkers) == 0 || SHADOW_PERCENT <= 0 {
+		return
+	}
+	log.Printf("mirroring %g%% of the shards to %d shadow workers", min(SHADOW_PERCENT, 100), len(shadowWorkers))
+
+	shadowQueue = make(chan shadowedPing, max(1, SHADOW_QUEUE))
+	for i := 0; i < max(1, SHADOW_SENDERS); i++ {
+		go func() {
+			for p := range shadowQueue {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				req := &pb.PingRequest{Geohash: p.gh, Timestamp: p.ingestedAt, DeviceId: p.deviceID, Seq: p.seq, ApiVersion: pb.API_VERSION}
+				_, err := p.client.SendPing(ctx, req)
+				cancel()
+				if err != nil {
+					Metrics.shadowPingsTotal.WithLabelValues(p.addr, "failed").Inc()
+					continue
+				}
+				Metrics.shadowPingsTotal.WithLabelValues(p.addr, "sent").Inc()
+			}
+		}()
+	}
+}
+
+// shadowPing queues a copy of a ping for the canary of its shard, if the shard is sampled
+func shadowPing(gh string, ingestedAt int64, deviceID string, seq uint64) {
+	if shadowQueue == nil {
+		return
+	}
+	hash := xxh3.HashString(shardKey(gh))
+	if float64(hash%10000) >= SHADOW_PERCENT*100 {
+		return
+	}
+	w := shadowWorkers[(hash>>32)%uint64(len(shadowWorkers))]
+	select {
+	case shadowQueue <- shadowedPing{addr: w.addr, client: w.client, gh: gh, ingestedAt: ingestedAt, deviceID: deviceID, seq: seq}:
+	default:
+		Metrics.shadowPingsTotal.WithLabelValues(w.addr, "dropped").Inc()
+	}
+}
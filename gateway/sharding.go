@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// the precision keys are sharded at. SHARDING_PRECISION (or the registry's, see settings.go) from startup, changed at
+// runtime by a sharding migration (see registry/migration.go): writes go to the owners at the old precision until the
+// migration's switch, then to those at the new one, and until it ends reads go to both and add up their counts
+type shardingState struct {
+	precision int   // after the switch
+	previous  int   // before the switch (= precision outside of migrations)
+	switchAt  int64 // unix ms
+	until     int64 // unix ms, end of the dual reads
+}
+
+var sharding atomic.Pointer[shardingState]
+
+func init() {
+	sharding.Store(&shardingState{precision: SHARDING_PRECISION, previous: SHARDING_PRECISION})
+}
+
+// setSharding shards at precision from now on, or per the migration m if it leads to it and isn't over
+func setSharding(precision int, m *pb.ShardingMigration) {
+	next := &shardingState{precision: precision, previous: precision}
+	if m != nil && int(m.ToPrecision) == precision && int(m.FromPrecision) != precision && time.Now().UnixMilli() < m.Until {
+		next.previous, next.switchAt, next.until = int(m.FromPrecision), m.SwitchAt, m.Until
+	}
+	sharding.Store(next)
+}
+
+// migrating reports whether reads go to the owners at both precisions
+func (s *shardingState) migrating(now int64) bool {
+	return s.previous != s.precision && now < s.until
+}
+
+// shardingPrecision returns the precision keys are sharded at (the new one during a migration)
+func shardingPrecision() int {
+	return sharding.Load().precision
+}
+
+// shardKey returns the key a ping (max precision geohash) is written under
+func shardKey(gh string) string {
+	return sharding.Load().writeKey(gh, time.Now().UnixMilli())
+}
+
+// ownerChains returns the distinct replica chains holding pings of a max precision geohash
+func ownerChains(gh string) [][]string {
+	return sharding.Load().ownerChains(state, gh, time.Now().UnixMilli())
+}
+
+// routedPrecision returns the shortest geohashes that have a key at every precision in use
+func (s *shardingState) routedPrecision(now int64) int {
+	if s.migrating(now) {
+		return max(s.previous, s.precision)
+	}
+	return s.precision
+}
+
+func (s *shardingState) writeKey(gh string, now int64) string {
+	if now < s.switchAt {
+		return gh[:s.previous]
+	}
+	return gh[:s.precision]
+}
+
+// readKeys returns the keys pings of a geohash (at least routedPrecision long) may be stored under: at both
+// precisions during a migration
+func (s *shardingState) readKeys(gh string, now int64) []string {
+	if s.migrating(now) {
+		return []string{gh[:s.precision], gh[:s.previous]}
+	}
+	return []string{gh[:s.precision]}
+}
+
+// ownerChains returns the distinct replica chains holding pings of a geohash (at least routedPrecision long): those
+// of its keys at both precisions during a migration
+func (s *shardingState) ownerChains(ring Ring, gh string, now int64) [][]string {
+	var chains [][]string
+	var seen string
+	for _, key := range s.readKeys(gh, now) {
+		addrs := ring.GetNodeAddresses(key, REPLICATION_FACTOR)
+		if len(addrs) == 0 {
+			continue
+		}
+		if chain := strings.Join(addrs, ","); chain != seen {
+			seen = chain
+			chains = append(chains, addrs)
+		}
+	}
+	return chains
+}
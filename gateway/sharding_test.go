@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// layoutRing owns keys by their length: the ring layout at each sharding precision
+type layoutRing map[int][]string
+
+func (r layoutRing) GetNodeAddresses(key string, n int) []string {
+	owners := r[len(key)]
+	return owners[:min(n, len(owners))]
+}
+
+func (r layoutRing) workerServers() []string {
+	var servers []string
+	for _, owners := range r {
+		for _, addr := range owners {
+			if !slices.Contains(servers, addr) {
+				servers = append(servers, addr)
+			}
+		}
+	}
+	return servers
+}
+
+func (r layoutRing) mayHoldAny(address string, geohashes []string) bool {
+	return true
+}
+
+func (r layoutRing) wroteTo(address string, key string) {}
+
+func withSharding(t *testing.T, s *shardingState) {
+	previous := sharding.Load()
+	sharding.Store(s)
+	t.Cleanup(func() { sharding.Store(previous) })
+}
+
+const migratedGh = "u4pruydqqvj"
+
+func TestShardingWritesSwitchAtTheMigrationsSwitch(t *testing.T) {
+	s := &shardingState{precision: 5, previous: 4, switchAt: 1_000, until: 2_000}
+	for _, tt := range []struct {
+		now  int64
+		want string
+	}{
+		{999, "u4pr"},
+		{1_000, "u4pru"},
+		{2_000, "u4pru"},
+	} {
+		if got := s.writeKey(migratedGh, tt.now); got != tt.want {
+			t.Errorf("writeKey at %d = %q, want %q", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestShardingReadsBothLayoutsUntilTheMigrationEnds(t *testing.T) {
+	withReplicationFactor(t, 2)
+	ring := layoutRing{4: {"a", "b"}, 5: {"c", "a"}}
+	s := &shardingState{precision: 5, previous: 4, switchAt: 1_000, until: 2_000}
+
+	// before and after the switch: both layouts, the new one first
+	for _, now := range []int64{500, 1_500} {
+		chains := s.ownerChains(ring, migratedGh, now)
+		if len(chains) != 2 || !slices.Equal(chains[0], []string{"c", "a"}) || !slices.Equal(chains[1], []string{"a", "b"}) {
+			t.Errorf("at %d: got chains %q, want the new layout's then the old one's", now, chains)
+		}
+		if got := s.routedPrecision(now); got != 5 {
+			t.Errorf("at %d: routed at precision %d, want 5", now, got)
+		}
+	}
+	chains := s.ownerChains(ring, migratedGh, 2_000)
+	if len(chains) != 1 || !slices.Equal(chains[0], []string{"c", "a"}) {
+		t.Errorf("got chains %q once the migration ended, want the new layout's only", chains)
+	}
+
+	// a key owned by the same chain at both precisions is read once
+	same := layoutRing{4: {"a", "b"}, 5: {"a", "b"}}
+	if chains := s.ownerChains(same, migratedGh, 1_500); len(chains) != 1 {
+		t.Errorf("got chains %q, want one for a key that didn't move", chains)
+	}
+	// a layout without workers is skipped
+	if chains := s.ownerChains(layoutRing{5: {"c"}}, migratedGh, 1_500); len(chains) != 1 || chains[0][0] != "c" {
+		t.Errorf("got chains %q, want the new layout's only", chains)
+	}
+}
+
+func TestShardingCoarserMigrationRoutesAtTheFinerPrecision(t *testing.T) {
+	s := &shardingState{precision: 4, previous: 6, switchAt: 1_000, until: 2_000}
+	if got := s.routedPrecision(1_500); got != 6 {
+		t.Errorf("routed at precision %d during the migration, want 6", got)
+	}
+	if got := s.readKeys(migratedGh, 1_500); !slices.Equal(got, []string{"u4pr", "u4pruy"}) {
+		t.Errorf("got read keys %q", got)
+	}
+	if got := s.routedPrecision(2_000); got != 4 {
+		t.Errorf("routed at precision %d once the migration ended, want 4", got)
+	}
+}
+
+func TestSetShardingStartsOnlyTheMigrationLeadingToThePrecision(t *testing.T) {
+	withSharding(t, sharding.Load())
+	now := time.Now().UnixMilli()
+	for _, tt := range []struct {
+		name      string
+		m         *pb.ShardingMigration
+		migrating bool
+	}{
+		{"none", nil, false},
+		{"running", &pb.ShardingMigration{FromPrecision: 4, ToPrecision: 5, SwitchAt: now, Until: now + 60_000}, true},
+		{"to another precision", &pb.ShardingMigration{FromPrecision: 4, ToPrecision: 6, SwitchAt: now, Until: now + 60_000}, false},
+		{"from the same precision", &pb.ShardingMigration{FromPrecision: 5, ToPrecision: 5, SwitchAt: now, Until: now + 60_000}, false},
+		{"over", &pb.ShardingMigration{FromPrecision: 4, ToPrecision: 5, SwitchAt: now - 60_000, Until: now - 1}, false},
+	} {
+		setSharding(5, tt.m)
+		s := sharding.Load()
+		if s.precision != 5 || s.migrating(now) != tt.migrating {
+			t.Errorf("%s: got %+v, want precision 5, migrating %v", tt.name, s, tt.migrating)
+		}
+		if tt.migrating && (s.previous != 4 || s.switchAt != tt.m.SwitchAt || s.until != tt.m.Until) {
+			t.Errorf("%s: got %+v, want the migration's", tt.name, s)
+		}
+	}
+}
+
+func TestCheckClusterSettingsStartsAMigrationOnlyIfItIsTheOnlyChange(t *testing.T) {
+	withSharding(t, &shardingState{precision: 4, previous: 4})
+	previousVersion, previousStale := settingsVersion, staleSettingsVersion
+	previousR, previousHash, previousSeed := settingsReplicationFactor, settingsRingHash, settingsRingSeed
+	t.Cleanup(func() {
+		settingsVersion, staleSettingsVersion = previousVersion, previousStale
+		settingsReplicationFactor, settingsRingHash, settingsRingSeed = previousR, previousHash, previousSeed
+	})
+	settingsVersion, settingsReplicationFactor, settingsRingHash, settingsRingSeed = 1, 2, "fnv", 0
+
+	now := time.Now().UnixMilli()
+	m := &pb.ShardingMigration{FromPrecision: 4, ToPrecision: 5, SwitchAt: now + 60_000, Until: now + 120_000}
+	checkClusterSettings(&pb.ClusterSettings{Version: 2, ReplicationFactor: 3, RingHash: "fnv", ShardingMigration: m})
+	if s := sharding.Load(); s.precision != 4 || settingsVersion != 1 {
+		t.Fatalf("migration started along with another change: %+v", s)
+	}
+
+	checkClusterSettings(&pb.ClusterSettings{Version: 3, ReplicationFactor: 2, RingHash: "fnv", ShardingMigration: m})
+	s := sharding.Load()
+	if s.precision != 5 || s.previous != 4 || !s.migrating(now) || settingsVersion != 3 {
+		t.Fatalf("got %+v at settings %d, want the migration to 5 started at settings 3", s, settingsVersion)
+	}
+	if got := s.writeKey(migratedGh, now); got != "u4pr" {
+		t.Errorf("writing under %q before the switch, want the old layout's key", got)
+	}
+
+	// a migration from another precision (a gateway that missed one) is left for a restart
+	m = &pb.ShardingMigration{FromPrecision: 4, ToPrecision: 6, SwitchAt: now, Until: now + 60_000}
+	checkClusterSettings(&pb.ClusterSettings{Version: 4, ReplicationFactor: 2, RingHash: "fnv", ShardingMigration: m})
+	if s := sharding.Load(); s.precision != 5 || settingsVersion != 3 {
+		t.Errorf("got %+v at settings %d, want the running migration kept", s, settingsVersion)
+	}
+}
+
+func TestMigrationMovesWritesAndAddsUpReadsAcrossLayouts(t *testing.T) {
+	withReplicationFactor(t, 1)
+	workers := fakeWorkers{"old": {count: 3}, "new": {count: 4}}
+	s := newGatewayService(layoutRing{4: {"old"}, 5: {"new"}}, workers)
+	now := time.Now().UnixMilli()
+
+	// before the switch pings still go to the old layout, reads add up both
+	withSharding(t, &shardingState{precision: 5, previous: 4, switchAt: now + 60_000, until: now + 120_000})
+	if _, err := s.RoutePing(context.Background(), migratedGh, now, "", 0); err != nil {
+		t.Fatalf("RoutePing: %v", err)
+	}
+	if len(workers["old"].received()) != 1 || len(workers["new"].received()) != 0 {
+		t.Fatalf("ping written to the new layout before the switch")
+	}
+	if got, err := s.QueryPoint(context.Background(), migratedGh); err != nil || got.Count != 7 {
+		t.Fatalf("got %v, %v, want 3 + 4 pings", got, err)
+	}
+
+	// after it they go to the new one
+	sharding.Store(&shardingState{precision: 5, previous: 4, switchAt: now - 1, until: now + 60_000})
+	if _, err := s.RoutePing(context.Background(), migratedGh, now, "", 0); err != nil {
+		t.Fatalf("RoutePing: %v", err)
+	}
+	if len(workers["old"].received()) != 1 || len(workers["new"].received()) != 1 {
+		t.Fatalf("ping not written to the new layout after the switch")
+	}
+
+	// once the dual reads end only the new layout is read
+	sharding.Store(&shardingState{precision: 5, previous: 4, switchAt: now - 60_000, until: now - 1})
+	if got, err := s.QueryPoint(context.Background(), migratedGh); err != nil || got.Count != 4 {
+		t.Fatalf("got %v, %v, want the new layout's 4 pings", got, err)
+	}
+
+	// a failing layout fails the read rather than undercounting it
+	sharding.Store(&shardingState{precision: 5, previous: 4, switchAt: now - 1, until: now + 60_000})
+	workers["old"].err = errWorkerConnect
+	if _, err := s.QueryPoint(context.Background(), migratedGh); err == nil {
+		t.Errorf("read succeeded without the old layout's counts")
+	}
+}
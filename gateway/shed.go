@@ -0,0 +1,232 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// load shedding: at most SHED_MAX_INFLIGHT requests of the ingest and query routes run at once, the next ones wait in
+// a queue by priority (up to SHED_MAX_QUEUE), instead of every request getting its goroutine and worker calls while
+// the gateway falls behind. priority comes from the endpoint (ingest, then point reads, then area queries) and the
+// tier of the caller (API key holders before anonymous requests), and decides which request gets the next free slot,
+// and which one is dropped when the queue is full.
+//
+// the queue is managed like CoDel: a queue that drains fast is fine however long, but while the requests leaving it
+// have waited more than SHED_TARGET for a whole SHED_INTERVAL, the lowest priority still admitted is shed (the waiting
+// ones and the new ones answer 503 with Retry-After), then the next one each further interval, down to anonymous
+// ingest. a request leaving the queue within the target stops the shedding. /pingArea/stream, /ui, /admin and
+// /metrics are never shed
+var SHED_MAX_INFLIGHT = env.Int("SHED_MAX_INFLIGHT", 1024) // 0 = disabled
+var SHED_MAX_QUEUE = env.Int("SHED_MAX_QUEUE", 1024)
+var SHED_TARGET = env.Duration("SHED_TARGET", 10*time.Millisecond)
+var SHED_INTERVAL = env.Duration("SHED_INTERVAL", 100*time.Millisecond)
+
+// priorities, most important first
+const (
+	shedIngestKeyed = iota
+	shedIngestAnonymous
+	shedPointKeyed
+	shedPointAnonymous
+	shedAreaKeyed
+	shedAreaAnonymous
+	shedPriorities
+)
+
+var shedClasses = [shedPriorities][2]string{ // class and tier, for the metrics
+	{"ingest", "keyed"}, {"ingest", "anonymous"},
+	{"point", "keyed"}, {"point", "anonymous"},
+	{"area", "keyed"}, {"area", "anonymous"},
+}
+
+type shedWaiter struct {
+	enqueued time.Time
+	granted  chan bool // buffered: true = slot handed over, false = shed
+	decided  bool      // under shedder.mu
+}
+
+type loadShedder struct {
+	mu         sync.Mutex
+	inflight   int
+	queues     [shedPriorities][]*shedWaiter
+	queued     int
+	firstAbove time.Time // CoDel: when the sojourn time has been above target for an interval
+	shedFrom   int       // priorities from this one on are shed (shedPriorities = none)
+}
+
+var shedder = &loadShedder{shedFrom: shedPriorities}
+
+// shedPriority returns the priority of a request, -1 if it is never shed
+func shedPriority(r *http.Request) int {
+	path := r.URL.Path
+	var priority int
+	switch {
+	case path == "/pingArea/stream" || strings.HasPrefix(path, "/ui"):
+		return -1
+	case r.Method == http.MethodPost && path == "/ping", r.Method == http.MethodDelete:
+		priority = shedIngestKeyed
+	case strings.HasPrefix(path, "/pingArea") || path == "/clusters" || path == "/pingPolygon" || strings.HasPrefix(path, "/grafana"):
+		priority = shedAreaKeyed
+	default:
+		priority = shedPointKeyed
+	}
+	if tenantFor(r) == anonymous {
+		priority++
+	}
+	return priority
+}
+
+// shedMiddleware admits the requests of a route group through the shedder
+func shedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority := -1
+		if SHED_MAX_INFLIGHT > 0 {
+			priority = shedPriority(r)
+		}
+		if priority < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !shedder.acquire(r, priority) {
+			Metrics.shedTotal.WithLabelValues(shedClasses[priority][0], shedClasses[priority][1]).Inc()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Overloaded, retry later"))
+			return
+		}
+		defer shedder.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire waits for a slot, false if the request is shed (or its client gone)
+func (s *loadShedder) acquire(r *http.Request, priority int) bool {
+	s.mu.Lock()
+	if s.inflight < SHED_MAX_INFLIGHT && s.queued == 0 {
+		s.inflight++
+		s.mu.Unlock()
+		Metrics.shedInflight.Inc()
+		return true
+	}
+	if priority >= s.shedFrom {
+		s.mu.Unlock()
+		return false
+	}
+	if s.queued >= SHED_MAX_QUEUE {
+		// the least important request waiting makes room, unless it is this one
+		worst := s.worstLocked()
+		if worst <= priority {
+			s.mu.Unlock()
+			return false
+		}
+		s.dropLocked(worst, len(s.queues[worst])-1)
+	}
+	waiter := &shedWaiter{enqueued: clock.Now(), granted: make(chan bool, 1)}
+	s.queues[priority] = append(s.queues[priority], waiter)
+	s.queued++
+	Metrics.shedQueued.Inc()
+	s.mu.Unlock()
+
+	select {
+	case ok := <-waiter.granted:
+		return ok
+	case <-r.Context().Done():
+		s.mu.Lock()
+		if !waiter.decided {
+			for i, w := range s.queues[priority] {
+				if w == waiter {
+					s.queues[priority] = append(s.queues[priority][:i], s.queues[priority][i+1:]...)
+					break
+				}
+			}
+			s.queued--
+			Metrics.shedQueued.Dec()
+			s.mu.Unlock()
+			return false
+		}
+		s.mu.Unlock()
+		if <-waiter.granted {
+			s.release() // handed over meanwhile
+		}
+		return false
+	}
+}
+
+// release hands the slot over to the most important waiting request, if any
+func (s *loadShedder) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+// releaseLocked is release with s.mu held
+func (s *loadShedder) releaseLocked() {
+	for priority := range s.queues {
+		if len(s.queues[priority]) == 0 {
+			continue
+		}
+		waiter := s.queues[priority][0]
+		s.queues[priority] = s.queues[priority][1:]
+		s.queued--
+		Metrics.shedQueued.Dec()
+		waiter.decided = true
+		waiter.granted <- true
+		s.observeSojournLocked(clock.Now().Sub(waiter.enqueued))
+		return
+	}
+	s.inflight--
+	Metrics.shedInflight.Dec()
+	s.observeSojournLocked(0) // the queue is empty
+}
+
+// observeSojournLocked updates the shedding with the time a request waited. s.mu must be held
+func (s *loadShedder) observeSojournLocked(sojourn time.Duration) {
+	now := clock.Now()
+	if sojourn < SHED_TARGET {
+		s.firstAbove = time.Time{}
+		if s.shedFrom != shedPriorities {
+			s.shedFrom = shedPriorities
+			Metrics.shedLevel.Set(0)
+		}
+		return
+	}
+	if s.firstAbove.IsZero() {
+		s.firstAbove = now.Add(SHED_INTERVAL)
+		return
+	}
+	if now.Before(s.firstAbove) || s.shedFrom <= shedIngestAnonymous {
+		return
+	}
+	// still above target a whole interval later: shed one more priority, waiting requests included
+	s.shedFrom--
+	s.firstAbove = now.Add(SHED_INTERVAL)
+	Metrics.shedLevel.Set(float64(shedPriorities - s.shedFrom))
+	for priority := s.shedFrom; priority < shedPriorities; priority++ {
+		for len(s.queues[priority]) > 0 {
+			s.dropLocked(priority, 0)
+		}
+	}
+}
+
+// worstLocked returns the least important priority with waiting requests. s.mu must be held
+func (s *loadShedder) worstLocked() int {
+	for priority := shedPriorities - 1; priority > 0; priority-- {
+		if len(s.queues[priority]) > 0 {
+			return priority
+		}
+	}
+	return 0
+}
+
+// dropLocked sheds a waiting request. s.mu must be held
+func (s *loadShedder) dropLocked(priority, i int) {
+	waiter := s.queues[priority][i]
+	s.queues[priority] = append(s.queues[priority][:i], s.queues[priority][i+1:]...)
+	s.queued--
+	Metrics.shedQueued.Dec()
+	waiter.decided = true
+	waiter.granted <- false
+}
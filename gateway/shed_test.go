@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestShedder returns an empty shedder admitting maxInflight requests and queueing maxQueue more, on a fake clock
+func newTestShedder(t *testing.T, maxInflight, maxQueue int) (*loadShedder, *fakeClock) {
+	previousInflight, previousQueue := SHED_MAX_INFLIGHT, SHED_MAX_QUEUE
+	SHED_MAX_INFLIGHT, SHED_MAX_QUEUE = maxInflight, maxQueue
+	t.Cleanup(func() { SHED_MAX_INFLIGHT, SHED_MAX_QUEUE = previousInflight, previousQueue })
+	return &loadShedder{shedFrom: shedPriorities}, withFakeClock(t)
+}
+
+// waitAcquire runs acquire in the background, returning its result once the request is queued
+func waitAcquire(t *testing.T, s *loadShedder, ctx context.Context, priority int) <-chan bool {
+	s.mu.Lock()
+	queued := len(s.queues[priority])
+	s.mu.Unlock()
+
+	out := make(chan bool, 1)
+	go func() { out <- s.acquire(httptest.NewRequest("GET", "/ping", nil).WithContext(ctx), priority) }()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		n := len(s.queues[priority])
+		s.mu.Unlock()
+		if n > queued {
+			return out
+		}
+	}
+	t.Fatalf("request of priority %d not queued", priority)
+	return nil
+}
+
+func acquireNow(s *loadShedder, priority int) bool {
+	return s.acquire(httptest.NewRequest("GET", "/ping", nil), priority)
+}
+
+func result(t *testing.T, ch <-chan bool) bool {
+	select {
+	case ok := <-ch:
+		return ok
+	case <-time.After(time.Second):
+		t.Fatalf("acquire still waiting")
+		return false
+	}
+}
+
+func (s *loadShedder) counts() (inflight, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inflight, s.queued
+}
+
+func TestShedderQueuesBeyondMaxInflight(t *testing.T) {
+	s, _ := newTestShedder(t, 2, 8)
+	if !acquireNow(s, shedAreaAnonymous) || !acquireNow(s, shedAreaAnonymous) {
+		t.Fatalf("requests within SHED_MAX_INFLIGHT waited")
+	}
+	waiting := waitAcquire(t, s, context.Background(), shedPointKeyed)
+	if inflight, queued := s.counts(); inflight != 2 || queued != 1 {
+		t.Fatalf("got %d in flight, %d queued", inflight, queued)
+	}
+
+	s.release() // the slot goes to the waiting request
+	if !result(t, waiting) {
+		t.Fatalf("waiting request shed")
+	}
+	if inflight, queued := s.counts(); inflight != 2 || queued != 0 {
+		t.Errorf("got %d in flight, %d queued after the handover", inflight, queued)
+	}
+	s.release()
+	s.release()
+	if inflight, _ := s.counts(); inflight != 0 {
+		t.Errorf("got %d in flight after releasing every slot", inflight)
+	}
+}
+
+func TestShedderHandsSlotsOverByPriority(t *testing.T) {
+	s, _ := newTestShedder(t, 1, 8)
+	acquireNow(s, shedIngestKeyed)
+	area := waitAcquire(t, s, context.Background(), shedAreaAnonymous)
+	point := waitAcquire(t, s, context.Background(), shedPointAnonymous)
+	ingest := waitAcquire(t, s, context.Background(), shedIngestKeyed)
+
+	for _, next := range []<-chan bool{ingest, point, area} {
+		s.release()
+		if !result(t, next) {
+			t.Fatalf("waiting request shed")
+		}
+	}
+}
+
+func TestShedderFullQueueDropsTheLeastImportant(t *testing.T) {
+	s, _ := newTestShedder(t, 1, 1)
+	acquireNow(s, shedIngestKeyed)
+	area := waitAcquire(t, s, context.Background(), shedAreaAnonymous)
+
+	// a more important request takes its place
+	point := waitAcquire(t, s, context.Background(), shedPointKeyed)
+	if result(t, area) {
+		t.Errorf("dropped request admitted")
+	}
+	// a less (or as) important one is turned away
+	if acquireNow(s, shedAreaKeyed) || acquireNow(s, shedPointKeyed) {
+		t.Errorf("request admitted past a full queue")
+	}
+	s.release()
+	if !result(t, point) {
+		t.Errorf("queued request shed")
+	}
+}
+
+func TestShedderShedsAPriorityPerIntervalAboveTarget(t *testing.T) {
+	s, fake := newTestShedder(t, 1, 8)
+	acquireNow(s, shedIngestKeyed)
+	area := waitAcquire(t, s, context.Background(), shedAreaAnonymous)
+	point := waitAcquire(t, s, context.Background(), shedPointAnonymous)
+
+	s.mu.Lock()
+	s.observeSojournLocked(SHED_TARGET) // above target: the interval starts
+	fake.advance(SHED_INTERVAL - time.Millisecond)
+	s.observeSojournLocked(SHED_TARGET)
+	shedFrom := s.shedFrom
+	s.mu.Unlock()
+	if shedFrom != shedPriorities {
+		t.Fatalf("shedding before a whole interval above target")
+	}
+
+	fake.advance(time.Millisecond)
+	s.mu.Lock()
+	s.observeSojournLocked(SHED_TARGET)
+	shedFrom = s.shedFrom
+	s.mu.Unlock()
+	if shedFrom != shedAreaAnonymous || result(t, area) {
+		t.Fatalf("got shedFrom %d, want anonymous area queries shed, waiting ones included", shedFrom)
+	}
+	if acquireNow(s, shedAreaAnonymous) {
+		t.Errorf("shed priority admitted")
+	}
+
+	// one more priority per interval, never ingest from API key holders
+	for want := shedAreaKeyed; want >= shedIngestAnonymous; want-- {
+		fake.advance(SHED_INTERVAL)
+		s.mu.Lock()
+		s.observeSojournLocked(time.Second)
+		shedFrom = s.shedFrom
+		s.mu.Unlock()
+		if shedFrom != want {
+			t.Fatalf("got shedFrom %d, want %d", shedFrom, want)
+		}
+	}
+	if result(t, point) {
+		t.Errorf("waiting anonymous point read admitted")
+	}
+	fake.advance(SHED_INTERVAL)
+	s.mu.Lock()
+	s.observeSojournLocked(time.Second)
+	shedFrom = s.shedFrom
+	s.mu.Unlock()
+	if shedFrom != shedIngestAnonymous {
+		t.Errorf("got shedFrom %d, keyed ingest shed", shedFrom)
+	}
+
+	// a request leaving the queue within target stops the shedding
+	s.release()
+	s.mu.Lock()
+	shedFrom = s.shedFrom
+	s.mu.Unlock()
+	if shedFrom != shedPriorities {
+		t.Errorf("got shedFrom %d once the queue drained", shedFrom)
+	}
+	if !acquireNow(s, shedAreaAnonymous) {
+		t.Errorf("request shed once the queue drained")
+	}
+}
+
+func TestShedderForgetsWaitersWhoseClientLeft(t *testing.T) {
+	s, _ := newTestShedder(t, 1, 8)
+	acquireNow(s, shedIngestKeyed)
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := waitAcquire(t, s, ctx, shedPointKeyed)
+	cancel()
+	if result(t, waiting) {
+		t.Fatalf("cancelled request admitted")
+	}
+	if inflight, queued := s.counts(); inflight != 1 || queued != 0 {
+		t.Fatalf("got %d in flight, %d queued", inflight, queued)
+	}
+	s.release()
+	if inflight, _ := s.counts(); inflight != 0 {
+		t.Errorf("slot still taken by the cancelled request")
+	}
+}
+
+// a waiter cancelled while its slot is being handed over (decided, but it is already leaving) passes the slot on
+func TestShedderPassesOnSlotsHandedToCancelledWaiters(t *testing.T) {
+	s, _ := newTestShedder(t, 1, 8)
+	acquireNow(s, shedIngestKeyed)
+	for range 50 {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelled := waitAcquire(t, s, ctx, shedIngestKeyed)
+		next := waitAcquire(t, s, context.Background(), shedPointKeyed)
+
+		// cancelled before its handover: whichever of the two it sees first, it ends up shed and the slot with next
+		s.mu.Lock()
+		cancel()
+		time.Sleep(100 * time.Microsecond)
+		s.releaseLocked()
+		s.mu.Unlock()
+
+		if result(t, cancelled) {
+			s.release() // admitted before it saw the cancellation: done with it
+		}
+		if !result(t, next) {
+			t.Fatalf("slot lost")
+		}
+		if inflight, queued := s.counts(); inflight != 1 || queued != 0 {
+			t.Fatalf("got %d in flight, %d queued", inflight, queued)
+		}
+	}
+}
+
+func TestShedderUnderConcurrentLoad(t *testing.T) {
+	s, _ := newTestShedder(t, 4, 16)
+	var wg sync.WaitGroup
+	for i := range 200 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%5)*time.Millisecond)
+			defer cancel()
+			if s.acquire(httptest.NewRequest("GET", "/ping", nil).WithContext(ctx), i%shedPriorities) {
+				time.Sleep(100 * time.Microsecond)
+				s.release()
+			}
+		}()
+	}
+	wg.Wait()
+	if inflight, queued := s.counts(); inflight != 0 || queued != 0 {
+		t.Errorf("got %d in flight, %d queued once every request finished", inflight, queued)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// signed ingest, against location spoofing from untrusted networks. devices listed in DEVICE_KEYS_FILE (JSON, device id
+// -> secret: {"truck-42": "s3cr3t"}) must sign their POST /ping:
+//
+//	X-Ping-Timestamp: unix seconds when signed
+//	X-Ping-Nonce:     8 to 64 characters, unique per ping
+//	X-Ping-Signature: hex HMAC-SHA256 of "<timestamp>\n<nonce>\n<body>" keyed with the device's secret
+//
+// timestamps more than PING_SIGNATURE_WINDOW away from the gateway clock are refused, and every nonce is remembered
+// until its timestamp leaves the window, so a captured ping can't be replayed to the same gateway (across gateways,
+// workers drop repeated seqs). with REQUIRE_SIGNED_PINGS, every ping must be signed by a listed device: UDP, CoAP and
+// RESP ingest, which can't carry a signature, are refused. otherwise unlisted devices ingest as before, and only the
+// listed ones are refused over those
+var DEVICE_KEYS_FILE = os.Getenv("DEVICE_KEYS_FILE")
+var REQUIRE_SIGNED_PINGS = env.Bool("REQUIRE_SIGNED_PINGS", false)
+var PING_SIGNATURE_WINDOW = env.Duration("PING_SIGNATURE_WINDOW", 30*time.Second)
+
+var deviceKeys = loadDeviceKeys(DEVICE_KEYS_FILE) // device id -> secret
+
+func loadDeviceKeys(path string) map[string][]byte {
+	out := make(map[string][]byte)
+	if path == "" {
+		return out
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("failed to read DEVICE_KEYS_FILE: %v", err)
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		log.Fatalf("failed to parse DEVICE_KEYS_FILE: %v", err)
+	}
+	for deviceID, secret := range raw {
+		if deviceID == "" || len(deviceID) > MAX_DEVICE_ID_LENGTH || secret == "" {
+			log.Fatalf("invalid DEVICE_KEYS_FILE entry for %q", deviceID)
+		}
+		out[deviceID] = []byte(secret)
+	}
+	log.Printf("loaded signing keys for %d devices", len(out))
+	return out
+}
+
+// nonces seen, per device, until their timestamp leaves the window
+var seenNonces = struct {
+	sync.Mutex
+	m         map[string]int64 // device id + "\x00" + nonce -> expiry (unix seconds)
+	nextSweep int64
+}{m: make(map[string]int64)}
+
+// unsignedPingAllowed reports whether a ping of the device (empty if anonymous) may be stored without a signature
+func unsignedPingAllowed(deviceID string) bool {
+	if REQUIRE_SIGNED_PINGS {
+		return false
+	}
+	_, listed := deviceKeys[deviceID]
+	return !listed
+}
+
+// admitSignature checks the signature of a POST /ping, writing the error response if it isn't admitted
+func admitSignature(w http.ResponseWriter, r *http.Request, body []byte, deviceID string) bool {
+	key, listed := deviceKeys[deviceID]
+	if !listed {
+		if REQUIRE_SIGNED_PINGS {
+			return rejectSignature(w, "unknown_device", "Signed ping required (unknown device)")
+		}
+		Metrics.signedPingsTotal.WithLabelValues("unsigned").Inc()
+		return true
+	}
+
+	timestamp, nonce, signature := r.Header.Get("X-Ping-Timestamp"), r.Header.Get("X-Ping-Nonce"), r.Header.Get("X-Ping-Signature")
+	if timestamp == "" || signature == "" || len(nonce) < 8 || len(nonce) > 64 {
+		return rejectSignature(w, "missing", "Missing or invalid X-Ping-Timestamp, X-Ping-Nonce or X-Ping-Signature")
+	}
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	now := monotonicNow().Unix()
+	window := int64(PING_SIGNATURE_WINDOW.Seconds())
+	if err != nil || signedAt < now-window || signedAt > now+window {
+		return rejectSignature(w, "expired", "Signature timestamp outside the accepted window")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	given, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(given, mac.Sum(nil)) {
+		return rejectSignature(w, "invalid", "Invalid signature")
+	}
+
+	// only valid signatures take a nonce, so forged requests can't burn a device's future nonces
+	seenNonces.Lock()
+	defer seenNonces.Unlock()
+	if now >= seenNonces.nextSweep {
+		for k, expiry := range seenNonces.m {
+			if expiry < now {
+				delete(seenNonces.m, k)
+			}
+		}
+		seenNonces.nextSweep = now + window
+	}
+	k := deviceID + "\x00" + nonce
+	if _, seen := seenNonces.m[k]; seen {
+		return rejectSignature(w, "replayed", "Replayed ping (nonce already used)")
+	}
+	seenNonces.m[k] = signedAt + window
+	Metrics.signedPingsTotal.WithLabelValues("valid").Inc()
+	return true
+}
+
+func rejectSignature(w http.ResponseWriter, result string, msg string) bool {
+	Metrics.signedPingsTotal.WithLabelValues(result).Inc()
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(msg))
+	return false
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func withDeviceKeys(t *testing.T, keys map[string][]byte, requireSigned bool) {
+	previousKeys, previousRequire := deviceKeys, REQUIRE_SIGNED_PINGS
+	deviceKeys, REQUIRE_SIGNED_PINGS = keys, requireSigned
+	seenNonces.Lock()
+	previousNonces, previousSweep := seenNonces.m, seenNonces.nextSweep
+	seenNonces.m, seenNonces.nextSweep = make(map[string]int64), 0
+	seenNonces.Unlock()
+	t.Cleanup(func() {
+		deviceKeys, REQUIRE_SIGNED_PINGS = previousKeys, previousRequire
+		seenNonces.Lock()
+		seenNonces.m, seenNonces.nextSweep = previousNonces, previousSweep
+		seenNonces.Unlock()
+	})
+}
+
+func signedPingRequest(key []byte, timestamp int64, nonce string, body string) *http.Request {
+	ts := strconv.FormatInt(timestamp, 10)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ts + "\n" + nonce + "\n" + body))
+	r := httptest.NewRequest(http.MethodPost, "/ping", strings.NewReader(body))
+	r.Header.Set("X-Ping-Timestamp", ts)
+	r.Header.Set("X-Ping-Nonce", nonce)
+	r.Header.Set("X-Ping-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestAdmitSignature(t *testing.T) {
+	fake := withFakeClock(t)
+	key := []byte("s3cr3t")
+	withDeviceKeys(t, map[string][]byte{"truck-42": key}, false)
+	now := fake.Now().Unix()
+	window := int64(PING_SIGNATURE_WINDOW.Seconds())
+	body := `{"lat":42.23,"lng":-8.72,"deviceId":"truck-42"}`
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		deviceID string
+		admitted bool
+	}{
+		{"valid", signedPingRequest(key, now, "nonce-0001", body), "truck-42", true},
+		{"wrong key", signedPingRequest([]byte("other"), now, "nonce-0002", body), "truck-42", false},
+		{"too old", signedPingRequest(key, now-window-1, "nonce-0003", body), "truck-42", false},
+		{"from the future", signedPingRequest(key, now+window+1, "nonce-0004", body), "truck-42", false},
+		{"short nonce", signedPingRequest(key, now, "nonce", body), "truck-42", false},
+		{"unsigned listed device", httptest.NewRequest(http.MethodPost, "/ping", strings.NewReader(body)), "truck-42", false},
+		{"unsigned unlisted device", httptest.NewRequest(http.MethodPost, "/ping", strings.NewReader(body)), "car-7", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if got := admitSignature(rec, tt.req, []byte(body), tt.deviceID); got != tt.admitted {
+				t.Fatalf("admitted %t, want %t (%d %s)", got, tt.admitted, rec.Code, rec.Body)
+			}
+			if !tt.admitted && rec.Code != http.StatusUnauthorized {
+				t.Fatalf("got %d, want 401", rec.Code)
+			}
+		})
+	}
+
+	// the body is part of the signature
+	rec := httptest.NewRecorder()
+	if admitSignature(rec, signedPingRequest(key, now, "nonce-0005", body), []byte(`{"lat":0,"lng":0}`), "truck-42") {
+		t.Fatal("admitted a signature over another body")
+	}
+}
+
+func TestAdmitSignatureRefusesReplayedNonces(t *testing.T) {
+	fake := withFakeClock(t)
+	key := []byte("s3cr3t")
+	withDeviceKeys(t, map[string][]byte{"truck-42": key, "truck-43": key}, false)
+	body := `{"lat":42.23,"lng":-8.72}`
+	signedAt := fake.Now().Unix()
+	admit := func(deviceID string, nonce string) bool {
+		return admitSignature(httptest.NewRecorder(), signedPingRequest(key, signedAt, nonce, body), []byte(body), deviceID)
+	}
+
+	if !admit("truck-42", "nonce-0001") {
+		t.Fatal("first use of the nonce refused")
+	}
+	if admit("truck-42", "nonce-0001") {
+		t.Fatal("replayed nonce admitted")
+	}
+	if !admit("truck-43", "nonce-0001") {
+		t.Fatal("another device's nonce refused: nonces are per device")
+	}
+
+	// a forged request doesn't take the nonce
+	forged := signedPingRequest([]byte("other"), signedAt, "nonce-0002", body)
+	if admitSignature(httptest.NewRecorder(), forged, []byte(body), "truck-42") {
+		t.Fatal("forged signature admitted")
+	}
+	if !admit("truck-42", "nonce-0002") {
+		t.Fatal("the nonce of a forged request can't be used by the device")
+	}
+
+	// remembered while the timestamp is in the window, the window refuses it after that, and the sweep forgets it
+	fake.advance(PING_SIGNATURE_WINDOW)
+	if admit("truck-42", "nonce-0001") {
+		t.Fatal("replayed nonce admitted at the end of the window")
+	}
+	fake.advance(2 * PING_SIGNATURE_WINDOW)
+	if admit("truck-42", "nonce-0003") {
+		t.Fatal("admitted a timestamp outside the window")
+	}
+	signedAt = fake.Now().Unix()
+	if !admit("truck-42", "nonce-0004") {
+		t.Fatal("fresh signed ping refused")
+	}
+	seenNonces.Lock()
+	_, kept := seenNonces.m["truck-42\x00nonce-0001"]
+	seenNonces.Unlock()
+	if kept {
+		t.Error("nonce kept after its timestamp left the window")
+	}
+}
+
+func TestAdmitSignatureRequireSigned(t *testing.T) {
+	withFakeClock(t)
+	withDeviceKeys(t, map[string][]byte{}, true)
+	rec := httptest.NewRecorder()
+	if admitSignature(rec, httptest.NewRequest(http.MethodPost, "/ping", nil), nil, "car-7") {
+		t.Fatal("unlisted device admitted with REQUIRE_SIGNED_PINGS")
+	}
+	if unsignedPingAllowed("") {
+		t.Fatal("anonymous unsigned ping allowed with REQUIRE_SIGNED_PINGS")
+	}
+}
+
+func TestPostPingRefusesOversizedBodies(t *testing.T) {
+	body := `{"lat":42.23,"lng":-8.72,"deviceId":"` + strings.Repeat("x", maxPingBody) + `"}`
+	rec := httptest.NewRecorder()
+	postPing(rec, httptest.NewRequest(http.MethodPost, "/ping", strings.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d, want 413", rec.Code)
+	}
+}
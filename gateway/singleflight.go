@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"geostreamdb/env"
+)
+
+// identical area queries running at the same time (a popular dashboard refreshed by many viewers, every gateway
+// stream of the same area) share one execution: the first one makes the worker calls, the others wait for it and get
+// a copy of its counts and shard timings. keyed by the normalized query (bbox, requested and aggregated precision),
+// so any route planning the same query joins in. results are not kept once the execution is done
+var AREA_SINGLEFLIGHT = env.Bool("AREA_SINGLEFLIGHT", true)
+
+type areaFlight struct {
+	done   chan struct{}
+	counts map[string]*ExtendedPingAreaCount
+	mode   string
+	reason string
+	shards []*PlannedCall // read-only once done
+	took   float64
+}
+
+var areaFlights = struct {
+	sync.Mutex
+	m map[string]*areaFlight
+}{m: make(map[string]*areaFlight)}
+
+// key normalizes a query: floats in their shortest exact form
+func (q pingAreaQuery) key() string {
+	var b strings.Builder
+	for _, f := range []float64{q.minLat, q.maxLat, q.minLng, q.maxLng} {
+		b.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		b.WriteByte(',')
+	}
+	b.WriteString(strconv.Itoa(q.precision))
+	b.WriteByte(',')
+	b.WriteString(strconv.Itoa(q.precUsed))
+	b.WriteByte(',')
+	b.WriteString(strconv.Itoa(q.smooth))
+	b.WriteByte(',')
+	b.WriteString(strconv.FormatInt(q.historyOffset, 10))
+	b.WriteByte(',')
+	b.WriteString(strconv.FormatInt(q.window, 10))
+	b.WriteByte(',')
+	b.WriteString(strconv.FormatInt(q.historyGranularity, 10))
+	b.WriteByte(',')
+	b.WriteString(strconv.FormatBool(q.movement))
+	if q.hasFloor {
+		b.WriteString(",floor=")
+		b.WriteString(strconv.Itoa(int(q.floor)))
+	}
+	return b.String()
+}
+
+// Execute makes the planned calls in parallel and merges their counts (partial if some workers fail), or waits for an
+// identical query already doing so. the map returned is the caller's own
+func (plan *QueryPlan) Execute(ctx context.Context) map[string]*ExtendedPingAreaCount {
+	if !AREA_SINGLEFLIGHT {
+		return plan.execute(ctx)
+	}
+
+	key := plan.query.key()
+	areaFlights.Lock()
+	if f, ok := areaFlights.m[key]; ok {
+		areaFlights.Unlock()
+		Metrics.areaSingleflight.WithLabelValues("shared").Inc()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return make(map[string]*ExtendedPingAreaCount) // the caller is gone
+		}
+		plan.Mode, plan.Reason, plan.Shards, plan.Took = f.mode, f.reason, f.shards, f.took
+		return copyCounts(f.counts)
+	}
+	f := &areaFlight{done: make(chan struct{})}
+	areaFlights.m[key] = f
+	areaFlights.Unlock()
+	Metrics.areaSingleflight.WithLabelValues("executed").Inc()
+
+	// not cancelled with this caller: others may be waiting (every call has its own timeout)
+	f.counts = plan.execute(context.WithoutCancel(ctx))
+	f.mode, f.reason, f.shards, f.took = plan.Mode, plan.Reason, plan.Shards, plan.Took
+
+	areaFlights.Lock()
+	delete(areaFlights.m, key)
+	areaFlights.Unlock()
+	close(f.done)
+	return copyCounts(f.counts)
+}
+
+func copyCounts(counts map[string]*ExtendedPingAreaCount) map[string]*ExtendedPingAreaCount {
+	out := make(map[string]*ExtendedPingAreaCount, len(counts))
+	for gh, c := range counts {
+		copied := *c
+		out[gh] = &copied
+	}
+	return out
+}
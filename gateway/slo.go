@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// service level objectives, computed by the gateway from the requests it serves on SLO_ENDPOINTS:
+//   - availability (SLO_AVAILABILITY, e.g. 0.999): share of requests not answered with a 5xx
+//   - latency (SLO_LATENCY_TARGET of the requests, e.g. 0.99, answered within SLO_LATENCY): among those not failed
+//
+// counted per minute over SLO_PERIOD (since the gateway started, if more recent). the burn rate of a window is its
+// share of bad requests over the error budget (1 - target): 1 spends the budget exactly over the period. exported as
+// gateway_slo_burn_rate{slo,window} and gateway_slo_error_budget_remaining{slo}, and served by GET /admin/slo with the
+// multiwindow alerts firing (see prometheus/alerts.yml for the same rules on the gauges). per gateway: aggregate them
+// for the cluster
+var SLO_AVAILABILITY = env.Float("SLO_AVAILABILITY", 0.999) // 0 = no availability objective
+var SLO_LATENCY = env.Duration("SLO_LATENCY", 300*time.Millisecond)
+var SLO_LATENCY_TARGET = env.Float("SLO_LATENCY_TARGET", 0.99) // 0 = no latency objective
+var SLO_PERIOD = env.Duration("SLO_PERIOD", 30*24*time.Hour)
+var SLO_ENDPOINTS = strings.Split(env.String("SLO_ENDPOINTS", "/ping,/pings,/pingArea,/pingArea/byZone,/nearest,/clusters,/pingPolygon,/device/{id}/pings"), ",")
+
+const sloRefresh = 15 * time.Second
+
+// burn rate windows, and the multiwindow alerts on them: both the long and the short window above the threshold
+var sloWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"5m", 5 * time.Minute}, {"30m", 30 * time.Minute}, {"1h", time.Hour}, {"2h", 2 * time.Hour},
+	{"6h", 6 * time.Hour}, {"1d", 24 * time.Hour}, {"3d", 72 * time.Hour},
+}
+
+type sloAlert struct {
+	Severity  string  `json:"severity"`
+	Long      string  `json:"long"`
+	Short     string  `json:"short"`
+	Threshold float64 `json:"threshold"`
+}
+
+var sloAlerts = []sloAlert{
+	{"page", "1h", "5m", 14.4}, // 2% of a 30 day budget in an hour
+	{"page", "6h", "30m", 6},   // 5% in 6 hours
+	{"ticket", "1d", "2h", 3},  // 10% in a day
+	{"ticket", "3d", "6h", 1},  // 10% in 3 days
+}
+
+type sloMinute struct {
+	minute    int64 // unix minutes, to tell stale buckets
+	total     uint64
+	bad       uint64
+	slowTotal uint64 // requests the latency objective applies to
+	slow      uint64
+}
+
+var slo = struct {
+	sync.Mutex
+	minutes []sloMinute // ring, one per minute of SLO_PERIOD
+	started int64       // unix minutes
+}{}
+
+func startSLO() {
+	if SLO_AVAILABILITY <= 0 && SLO_LATENCY_TARGET <= 0 {
+		return
+	}
+	slo.minutes = make([]sloMinute, max(int(SLO_PERIOD/time.Minute), 1))
+	slo.started = time.Now().Unix() / 60
+	go func() {
+		for range time.Tick(sloRefresh) {
+			for _, s := range sloSummaries() {
+				for window, rate := range s.BurnRates {
+					Metrics.sloBurnRate.WithLabelValues(s.name, window).Set(rate)
+				}
+				Metrics.sloErrorBudget.WithLabelValues(s.name).Set(s.ErrorBudgetRemaining)
+			}
+		}
+	}()
+}
+
+// observeSLO counts a served request for the objectives
+func observeSLO(endpoint string, code int, d time.Duration) {
+	if slo.minutes == nil || !slices.Contains(SLO_ENDPOINTS, endpoint) {
+		return
+	}
+	minute := time.Now().Unix() / 60
+	slo.Lock()
+	defer slo.Unlock()
+	b := &slo.minutes[minute%int64(len(slo.minutes))]
+	if b.minute != minute {
+		*b = sloMinute{minute: minute}
+	}
+	b.total++
+	if code >= 500 {
+		b.bad++
+		return
+	}
+	b.slowTotal++
+	if d > SLO_LATENCY {
+		b.slow++
+	}
+}
+
+// sloCounts sums the buckets of the last d (at most SLO_PERIOD)
+func sloCounts(now int64, d time.Duration) (total, bad, slowTotal, slow uint64) {
+	n := min(int64(d/time.Minute), int64(len(slo.minutes)))
+	for m := now - n + 1; m <= now; m++ {
+		if b := slo.minutes[m%int64(len(slo.minutes))]; b.minute == m {
+			total, bad, slowTotal, slow = total+b.total, bad+b.bad, slowTotal+b.slowTotal, slow+b.slow
+		}
+	}
+	return
+}
+
+type sloSummary struct {
+	name                 string
+	Target               float64            `json:"target"`
+	Threshold            string             `json:"threshold,omitempty"` // latency objective
+	Requests             uint64             `json:"requests"`            // over the period
+	Bad                  uint64             `json:"bad"`
+	ErrorBudgetRemaining float64            `json:"errorBudgetRemaining"` // share of the period's budget, negative once overspent
+	BurnRates            map[string]float64 `json:"burnRates"`
+	Alerts               []sloAlert         `json:"alerts"` // firing
+}
+
+// sloSummaries computes the objectives over the period and their burn rates
+func sloSummaries() []*sloSummary {
+	if slo.minutes == nil {
+		return nil
+	}
+	now := time.Now().Unix() / 60
+	slo.Lock()
+	defer slo.Unlock()
+
+	var out []*sloSummary
+	for _, objective := range []struct {
+		name   string
+		target float64
+		counts func(total, bad, slowTotal, slow uint64) (uint64, uint64)
+	}{
+		{"availability", SLO_AVAILABILITY, func(total, bad, _, _ uint64) (uint64, uint64) { return total, bad }},
+		{"latency", SLO_LATENCY_TARGET, func(_, _, slowTotal, slow uint64) (uint64, uint64) { return slowTotal, slow }},
+	} {
+		if objective.target <= 0 || objective.target >= 1 {
+			continue
+		}
+		budget := 1 - objective.target
+		burn := func(d time.Duration) (float64, uint64, uint64) {
+			total, bad := objective.counts(sloCounts(now, d))
+			if total == 0 {
+				return 0, 0, 0
+			}
+			return float64(bad) / float64(total) / budget, total, bad
+		}
+
+		s := &sloSummary{name: objective.name, Target: objective.target, BurnRates: make(map[string]float64), Alerts: []sloAlert{}}
+		if objective.name == "latency" {
+			s.Threshold = SLO_LATENCY.String()
+		}
+		var spent float64
+		spent, s.Requests, s.Bad = burn(SLO_PERIOD)
+		s.ErrorBudgetRemaining = 1 - spent
+		for _, w := range sloWindows {
+			s.BurnRates[w.name], _, _ = burn(w.d)
+		}
+		for _, a := range sloAlerts {
+			if s.BurnRates[a.Long] > a.Threshold && s.BurnRates[a.Short] > a.Threshold {
+				s.Alerts = append(s.Alerts, a)
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func getSLO(w http.ResponseWriter, r *http.Request) {
+	summaries := make(map[string]*sloSummary)
+	for _, s := range sloSummaries() {
+		summaries[s.name] = s
+	}
+	since := time.Now().Add(-SLO_PERIOD)
+	if slo.minutes != nil {
+		since = time.Unix(max(slo.started*60, since.Unix()), 0)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"period": SLO_PERIOD.String(), "since": since.UnixMilli(), "slos": summaries})
+}
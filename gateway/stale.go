@@ -0,0 +1,137 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// bounded-staleness reads: GET /ping and GET /pingArea take staleOk=true from clients that would rather render slightly
+// old data than an error while workers churn (dashboards during a partial outage). the gateway keeps the last answer
+// the workers gave to such reads (an LRU of STALE_CACHE_SIZE entries, only filled by staleOk reads) and serves it
+// instead when they can't answer: a point read failing with a retryable error, or an area read missing a shard. answers
+// older than MAX_STALENESS are not served (the read fails, or the area is partial, as without staleOk). staleOk
+// responses carry dataAsOf (unix ms, when the workers answered) and staleness_ms, also in the X-Data-As-Of and
+// X-Staleness-Ms headers, and are counted in gateway_stale_reads_total{endpoint,result=fresh|stale|miss}
+var STALE_CACHE_SIZE = env.Int("STALE_CACHE_SIZE", 1024) // 0 disables the fallback
+var MAX_STALENESS = env.Duration("MAX_STALENESS", 5*time.Minute)
+
+type staleEntry struct {
+	key   string
+	value any // *pb.GetPingsResponse or area counts, never modified once stored
+	at    time.Time
+}
+
+type staleCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // front = most recently used
+	items    map[string]*list.Element
+}
+
+var staleReads = newStaleCache(STALE_CACHE_SIZE)
+
+func newStaleCache(capacity int) *staleCache {
+	return &staleCache{capacity: capacity, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *staleCache) put(key string, value any, at time.Time) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value = &staleEntry{key: key, value: value, at: at}
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&staleEntry{key: key, value: value, at: at})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*staleEntry).key)
+	}
+}
+
+// get returns the last answer stored under key, unless older than MAX_STALENESS
+func (c *staleCache) get(key string) (any, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	entry := el.Value.(*staleEntry)
+	if monotonicNow().Sub(entry.at) > MAX_STALENESS {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, time.Time{}, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.value, entry.at, true
+}
+
+// stalePoint returns the result of a staleOk point read, falling back to the last answer for gh on retryable errors,
+// and when its data was read
+func stalePoint(gh string, v *pb.GetPingsResponse, err error) (*pb.GetPingsResponse, time.Time, error) {
+	key := "ping:" + gh
+	if err == nil {
+		now := monotonicNow()
+		staleReads.put(key, v, now)
+		Metrics.staleReadsTotal.WithLabelValues("ping", "fresh").Inc()
+		return v, now, nil
+	}
+	if !statusOf(err).retryable() {
+		return nil, time.Time{}, err
+	}
+	if cached, at, ok := staleReads.get(key); ok {
+		Metrics.staleReadsTotal.WithLabelValues("ping", "stale").Inc()
+		return cached.(*pb.GetPingsResponse), at, nil
+	}
+	Metrics.staleReadsTotal.WithLabelValues("ping", "miss").Inc()
+	return nil, time.Time{}, err
+}
+
+// staleArea returns the counts of a staleOk area read, the last complete ones for the query if a shard failed this
+// time, and when they were read. the counts returned are the caller's to modify
+func staleArea(plan *QueryPlan, counts map[string]*ExtendedPingAreaCount) (map[string]*ExtendedPingAreaCount, time.Time) {
+	key := "area:" + plan.query.key()
+	now := monotonicNow()
+	if !plan.partial() {
+		staleReads.put(key, copyCounts(counts), now)
+		Metrics.staleReadsTotal.WithLabelValues("pingArea", "fresh").Inc()
+		return counts, now
+	}
+	if cached, at, ok := staleReads.get(key); ok {
+		Metrics.staleReadsTotal.WithLabelValues("pingArea", "stale").Inc()
+		return copyCounts(cached.(map[string]*ExtendedPingAreaCount)), at
+	}
+	Metrics.staleReadsTotal.WithLabelValues("pingArea", "miss").Inc()
+	return counts, now // partial, but what the workers have now
+}
+
+// partial reports whether a shard of an executed plan failed
+func (plan *QueryPlan) partial() bool {
+	for _, call := range plan.Shards {
+		if call.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFreshness sets the freshness headers of a staleOk response and returns its dataAsOf and staleness_ms
+func writeFreshness(w http.ResponseWriter, asOf time.Time) (int64, int64) {
+	dataAsOf, staleness := asOf.UnixMilli(), monotonicNow().Sub(asOf).Milliseconds()
+	w.Header().Set("X-Data-As-Of", strconv.FormatInt(dataAsOf, 10))
+	w.Header().Set("X-Staleness-Ms", strconv.FormatInt(staleness, 10))
+	return dataAsOf, staleness
+}
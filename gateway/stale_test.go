@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+func TestStalePointServesTheLastAnswerUpToMaxStaleness(t *testing.T) {
+	fake := withFakeClock(t)
+	previous := staleReads
+	staleReads = newStaleCache(8)
+	t.Cleanup(func() { staleReads = previous })
+
+	fresh := &pb.GetPingsResponse{Count: 3, Timestamp: 42}
+	v, asOf, err := stalePoint("u4pruyd", fresh, nil)
+	if err != nil || v != fresh || !asOf.Equal(fake.Now()) {
+		t.Fatalf("fresh read: got %v at %v (%v)", v, asOf, err)
+	}
+	readAt := asOf
+
+	fake.advance(MAX_STALENESS / 2)
+	v, asOf, err = stalePoint("u4pruyd", nil, errNoWorkers)
+	if err != nil || v.Count != 3 || !asOf.Equal(readAt) {
+		t.Fatalf("during an outage: got %v at %v (%v), want the answer read at %v", v, asOf, err, readAt)
+	}
+	if _, _, err := stalePoint("u4pruyd", nil, errAreaTooLarge); !errors.Is(err, errAreaTooLarge) {
+		t.Fatalf("a non retryable error was answered from the cache (%v)", err)
+	}
+
+	fake.advance(MAX_STALENESS/2 + time.Second)
+	if _, _, err := stalePoint("u4pruyd", nil, errNoWorkers); !errors.Is(err, errNoWorkers) {
+		t.Fatalf("an answer older than MAX_STALENESS was served (%v)", err)
+	}
+}
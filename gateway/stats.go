@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+)
+
+// GET /stats/global: the pings of the TTL window across the whole cluster, {"count", "workers", "complete",
+// "timestamp"}, the "system heartbeat" number of a dashboard. every worker adds up the root count of its primary slots
+// (GetTotal, replicas left out so nothing is counted twice) and the gateway sums them. the total is kept for
+// GLOBAL_STATS_TTL, so any number of dashboards polling it cost the workers one fan-out per interval. workers that
+// failed to answer leave it incomplete. it always covers the whole window (tenant retention windows don't apply) and the
+// whole world, so only tenants whose ACL allows every location may read it
+var GLOBAL_STATS_TTL = env.Duration("GLOBAL_STATS_TTL", time.Second)
+
+type globalTotal struct {
+	Count     int64 `json:"count"`
+	Workers   int   `json:"workers"`   // that answered
+	Complete  bool  `json:"complete"`  // every worker of the ring answered
+	Timestamp int64 `json:"timestamp"` // latest worker second
+}
+
+var globalStats struct {
+	sync.Mutex // held during a refresh: concurrent requests wait for it
+	total      globalTotal
+	at         time.Time
+}
+
+func getGlobalStats(w http.ResponseWriter, r *http.Request) {
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(geo.Bbox{MinLat: -90, MaxLat: 90, MinLng: -180, MaxLng: 180}, 1) {
+		denyACL(w, t)
+		return
+	}
+	if !admitUsage(w, r, unitCells, 1) {
+		return
+	}
+
+	total, err := cachedGlobalTotal(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	total.Count = privacyFor(t).suppressCount(t, total.Count)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(total)
+}
+
+// cachedGlobalTotal returns the cluster total, asking the workers again if it is older than GLOBAL_STATS_TTL
+func cachedGlobalTotal(ctx context.Context) (globalTotal, error) {
+	globalStats.Lock()
+	defer globalStats.Unlock()
+
+	if !globalStats.at.IsZero() && time.Since(globalStats.at) < GLOBAL_STATS_TTL {
+		Metrics.globalStatsTotal.WithLabelValues("cached").Inc()
+		return globalStats.total, nil
+	}
+	// not cancelled with this caller: others may be waiting (every call has its own timeout)
+	total, err := service.GlobalTotal(context.WithoutCancel(ctx))
+	if err != nil {
+		Metrics.globalStatsTotal.WithLabelValues("failed").Inc()
+		return globalTotal{}, err
+	}
+	Metrics.globalStatsTotal.WithLabelValues("refreshed").Inc()
+	globalStats.total, globalStats.at = total, time.Now()
+	return total, nil
+}
+
+// GlobalTotal adds up the primary pings of every worker. errNoWorkers if the ring is empty, the error of a worker if
+// none answered
+func (s *GatewayService) GlobalTotal(ctx context.Context) (globalTotal, error) {
+	var total globalTotal
+	var mu sync.Mutex
+	err := s.Broadcast(ctx, "GetTotal", func(ctx context.Context, addr string, client pb.WorkerClient) error {
+		v, err := client.GetTotal(ctx, &pb.GetTotalRequest{ApiVersion: state.apiVersion(addr)})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		total.Count += v.Count
+		total.Workers++
+		total.Timestamp = max(total.Timestamp, v.Timestamp)
+		mu.Unlock()
+		return nil
+	})
+	if errors.Is(err, errNoWorkers) || (err != nil && total.Workers == 0) {
+		return globalTotal{}, err
+	}
+	total.Complete = err == nil
+	return total, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// embedded store for the objects created through the admin API. with STORE_FILE set (a bbolt database), zone sets,
+// retention policies, API keys (/admin/tenants) and ACLs (/admin/acls) are kept in it, one bucket each holding the
+// objects JSON-encoded by name, and loaded from it at startup. it replaces ZONES_FILE and RETENTION_FILE: they, and
+// TENANTS / ACL_FILE / RETENTION, only seed a bucket that is still empty, after which the store wins.
+//
+// a bbolt file is locked by the process that opened it, so every gateway has its own. GET /admin/store streams a
+// consistent copy of it, to back it up or to start another gateway with (as its STORE_FILE)
+var STORE_FILE = os.Getenv("STORE_FILE")
+
+const (
+	storeZones     = "zones"
+	storeRetention = "retention"
+	storeTenants   = "tenants"
+	storeACLs      = "acls"
+)
+
+type objectStore struct {
+	db *bbolt.DB
+}
+
+var store *objectStore // nil without STORE_FILE
+
+// openStore opens STORE_FILE at startup, before the objects are loaded
+func openStore() {
+	if STORE_FILE == "" {
+		return
+	}
+	db, err := bbolt.Open(STORE_FILE, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Fatalf("failed to open STORE_FILE: %v", err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range []string{storeZones, storeRetention, storeTenants, storeACLs} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("failed to initialize STORE_FILE: %v", err)
+	}
+	store = &objectStore{db: db}
+	log.Printf("opened store %s", STORE_FILE)
+}
+
+// storeLoad decodes the objects of a bucket, nil if it is empty
+func storeLoad[T any](bucket string) (map[string]T, error) {
+	var out map[string]T
+	err := store.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			var obj T
+			if err := json.Unmarshal(v, &obj); err != nil {
+				return err
+			}
+			if out == nil {
+				out = make(map[string]T)
+			}
+			out[string(k)] = obj
+			return nil
+		})
+	})
+	return out, err
+}
+
+// storeReplace rewrites a bucket with the objects, in a single transaction
+func storeReplace[T any](bucket string, objects map[string]T) error {
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(bucket)); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		for name, obj := range objects {
+			data, err := json.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(name), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadObjects returns the objects to start with: those of the bucket, or while it is still empty those of file (a
+// JSON object of the same objects by name, nil if unset or missing), with seed set so the caller saves what it keeps
+// of them into the store. env names file in errors
+func loadObjects[T any](bucket, file, env string) (objects map[string]T, source string, seed bool) {
+	if store != nil {
+		objects, err := storeLoad[T](bucket)
+		if err != nil {
+			log.Fatalf("failed to read %s from STORE_FILE: %v", bucket, err)
+		}
+		if objects != nil {
+			return objects, STORE_FILE, false
+		}
+	}
+	seed = store != nil
+	if file == "" {
+		return nil, env, seed
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, file, seed
+	}
+	if err != nil {
+		log.Fatalf("failed to read %s: %v", env, err)
+	}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		log.Fatalf("failed to parse %s: %v", env, err)
+	}
+	return objects, file, seed
+}
+
+func getStore(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No STORE_FILE configured"))
+		return
+	}
+	err := store.db.View(func(tx *bbolt.Tx) error {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(tx.Size(), 10))
+		_, err := tx.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to stream store: %v", err)
+	}
+}
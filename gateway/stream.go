@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"geostreamdb/env"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/zeebo/xxh3"
+)
+
+// live area counts as server-sent events: GET /pingArea/stream takes the /pingArea parameters and re-runs the query
+// every ?interval= (STREAM_INTERVAL, at least STREAM_MIN_INTERVAL), sending a "data:" event with
+// {"usedPrecision": ..., "counts": ...} whenever the result changed (and a comment line now and then to keep proxies
+// from closing an idle stream). every round is accounted, checked against the ACLs and suppressed like GET /pingArea;
+// a round that can't be served ends the stream with an "error" event. STREAM_MAX_CLIENTS bounds the open streams per
+// gateway (503 beyond)
+var STREAM_INTERVAL = env.Duration("STREAM_INTERVAL", 2*time.Second)
+var STREAM_MIN_INTERVAL = env.Duration("STREAM_MIN_INTERVAL", 500*time.Millisecond)
+var STREAM_MAX_CLIENTS = int64(env.Int("STREAM_MAX_CLIENTS", 256))
+
+const streamKeepalive = 15 * time.Second
+
+var streamClients atomic.Int64
+
+type streamEvent struct {
+	UsedPrecision int                               `json:"usedPrecision"`
+	Counts        map[string]*ExtendedPingAreaCount `json:"counts"`
+}
+
+func getPingAreaStream(w http.ResponseWriter, r *http.Request) {
+	q, status, msg := parsePingAreaQuery(r.URL.Query())
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+		return
+	}
+	interval := STREAM_INTERVAL
+	if intervalQ := r.URL.Query().Get("interval"); intervalQ != "" {
+		d, err := time.ParseDuration(intervalQ)
+		if err != nil || d <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid interval"))
+			return
+		}
+		interval = d
+	}
+	interval = max(interval, STREAM_MIN_INTERVAL)
+
+	// the first round answers with a status like GET /pingArea, so clients get 4xx before the stream opens
+	plan, err := planner.PlanWithinBudget(retentionFor(tenantFor(r)).limit(q))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(plan.query.bbox(), plan.query.precision) {
+		denyACL(w, t)
+		return
+	}
+	open := streamClients.Add(1)
+	defer func() { Metrics.streamClients.Set(float64(streamClients.Add(-1))) }()
+	if open > STREAM_MAX_CLIENTS {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("Too many open streams"))
+		return
+	}
+	Metrics.streamClients.Set(float64(open))
+	if !admitUsage(w, r, unitCells, plan.query.estimated) {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{}) // open-ended, unlike HTTP_WRITE_TIMEOUT
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx (loadbalancer) would otherwise buffer the stream
+	w.WriteHeader(http.StatusOK)
+
+	endpoint := r.Method + " " + chi.RouteContext(r.Context()).RoutePattern()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastHash uint64
+	lastWrite := time.Now()
+	for {
+		body, err := json.Marshal(streamEvent{UsedPrecision: plan.query.precision, Counts: privacyFor(t).suppress(t, plan.Execute(r.Context()))})
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: Failed to encode response\n\n")
+			rc.Flush()
+			return
+		}
+
+		var werr error
+		if hash := xxh3.Hash(body); hash != lastHash {
+			lastHash = hash
+			_, werr = fmt.Fprintf(w, "data: %s\n\n", body)
+			lastWrite = time.Now()
+		} else if time.Since(lastWrite) >= streamKeepalive {
+			_, werr = fmt.Fprintf(w, ": keepalive\n\n")
+			lastWrite = time.Now()
+		}
+		if werr != nil || rc.Flush() != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown:
+			return // clients reconnect, to another gateway
+		case <-ticker.C:
+		}
+
+		// later rounds are planned, checked and accounted again (the budget may coarsen differently)
+		plan, err = planner.PlanWithinBudget(retentionFor(t).limit(q))
+		switch {
+		case err != nil:
+			msg = statusOf(err).message
+		case !aclFor(t).allowsArea(plan.query.bbox(), plan.query.precision):
+			Metrics.aclDeniedTotal.WithLabelValues(t.name).Inc()
+			msg = "Location not allowed for this API key"
+		case !reserveUsage(t, endpoint, unitCells, plan.query.estimated):
+			msg = "Monthly " + string(unitCells) + " quota exceeded"
+		}
+		if msg != "" {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", msg)
+			rc.Flush()
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// teleport detection for pings with a device id (every ingest path): before routing, the worker owning the device (ring
+// lookup of the device id, so all of a device's pings meet there whatever shard they land in) compares the ping with
+// the device's last plausible position. faster than TELEPORT_MAX_SPEED (m/s, default a bit above airliner speed) is a
+// physically impossible jump, handled per TELEPORT_ACTION:
+//   - drop: not stored (POST /ping answers 422)
+//   - flag: stored, logged and counted
+//   - tag: stored and kept marked (raw retention: "teleport": true in GET /device/{id}/pings)
+//
+// unset (default) skips the check. when the owner can't be asked (down, older worker), the ping is stored unchecked
+var TELEPORT_ACTION = parseTeleportAction(env.String("TELEPORT_ACTION", ""))
+var TELEPORT_MAX_SPEED = env.Float("TELEPORT_MAX_SPEED", 300)
+var TELEPORT_CHECK_TIMEOUT = env.Duration("TELEPORT_CHECK_TIMEOUT", 200*time.Millisecond)
+
+const (
+	teleportDrop = "drop"
+	teleportFlag = "flag"
+	teleportTag  = "tag"
+)
+
+var errTeleport = errors.New("impossible jump from the device's last position")
+
+func parseTeleportAction(v string) string {
+	switch v {
+	case "", teleportDrop, teleportFlag, teleportTag:
+		return v
+	}
+	log.Fatalf("invalid TELEPORT_ACTION=%q (drop, flag or tag)", v)
+	return ""
+}
+
+// checkTeleport asks the device's owner about the ping: errTeleport if it is to be dropped, otherwise whether it is
+// to be stored tagged
+func checkTeleport(ctx context.Context, gh string, ingestedAt int64, deviceID string) (bool, error) {
+	if TELEPORT_ACTION == "" || deviceID == "" {
+		return false, nil
+	}
+	owners := state.GetNodeAddresses(deviceID, 1)
+	if len(owners) == 0 {
+		return false, nil // nothing to route to either
+	}
+	conn, err := state.GetConn(owners[0])
+	if err != nil {
+		Metrics.teleportChecksTotal.WithLabelValues("unchecked").Inc()
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, TELEPORT_CHECK_TIMEOUT)
+	defer cancel()
+	start := time.Now()
+	v, err := pb.NewWorkerClient(conn).CheckMotion(ctx, &pb.CheckMotionRequest{DeviceId: deviceID, Geohash: gh, Timestamp: ingestedAt, MaxSpeed: TELEPORT_MAX_SPEED, ApiVersion: state.apiVersion(owners[0])})
+	observeGRPC("CheckMotion", owners[0], err, start)
+	if err != nil {
+		Metrics.teleportChecksTotal.WithLabelValues("unchecked").Inc()
+		return false, nil
+	}
+	if !v.Teleport {
+		Metrics.teleportChecksTotal.WithLabelValues("plausible").Inc()
+		return false, nil
+	}
+
+	Metrics.teleportChecksTotal.WithLabelValues(TELEPORT_ACTION).Inc()
+	switch TELEPORT_ACTION {
+	case teleportDrop:
+		return false, errTeleport
+	case teleportFlag:
+		log.Printf("teleport: device %q jumped to %s at %.0f m/s", deviceID, gh, v.Speed)
+		return false, nil
+	}
+	return true, nil
+}
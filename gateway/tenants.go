@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// API keys managed at runtime. tenants other than anonymous (whose quotas only TENANTS sets) are created, changed and
+// deleted with
+//
+//	GET /admin/tenants, GET /admin/tenants/{name}, DELETE /admin/tenants/{name}
+//	PUT /admin/tenants/{name} {"keys": ["..."], "pingQuota": 0, "cellQuota": 0}
+//
+// a PUT replaces all the keys and quotas of the tenant. keys are never served back (GET answers how many a tenant has).
+// persisted in STORE_FILE if set (seeded from TENANTS), in memory only otherwise
+const (
+	maxTenantKeys       = 16
+	maxTenantKeyLength  = 256
+	maxTenantNameLength = 128
+)
+
+// tenantJSON is a tenant as accepted by PUT /admin/tenants/{name} and kept in the store
+type tenantJSON struct {
+	Keys      []string `json:"keys"`
+	PingQuota int64    `json:"pingQuota"`
+	CellQuota int64    `json:"cellQuota"`
+}
+
+// tenantInfo is a tenant as served by GET /admin/tenants
+type tenantInfo struct {
+	Keys      int   `json:"keys"`
+	PingQuota int64 `json:"pingQuota"`
+	CellQuota int64 `json:"cellQuota"`
+}
+
+// validTenant returns why the tenant can't be set, "" if it can
+func validTenant(name string, v tenantJSON) string {
+	if name == "" || len(name) > maxTenantNameLength {
+		return "Invalid tenant name"
+	}
+	if name == anonymousTenant {
+		return "The anonymous tenant is configured by TENANTS"
+	}
+	if len(v.Keys) == 0 || len(v.Keys) > maxTenantKeys {
+		return "A tenant needs 1 to " + strconv.Itoa(maxTenantKeys) + " keys"
+	}
+	for _, key := range v.Keys {
+		if key == "" || len(key) > maxTenantKeyLength {
+			return "Invalid key"
+		}
+	}
+	if v.PingQuota < 0 || v.CellQuota < 0 {
+		return "Invalid quota"
+	}
+	return ""
+}
+
+// keyConflictLocked reports whether one of the keys belongs to another tenant. tenants must be locked
+func keyConflictLocked(name string, keys []string) bool {
+	for _, key := range keys {
+		if t, ok := tenants.byKey[key]; ok && t.name != name {
+			return true
+		}
+	}
+	return false
+}
+
+// setTenantLocked replaces the keys of a tenant (deletes it if v is nil). tenants must be locked
+func setTenantLocked(name string, v *tenantJSON) {
+	for key, t := range tenants.byKey {
+		if t.name == name {
+			delete(tenants.byKey, key)
+		}
+	}
+	if v == nil {
+		return
+	}
+	t := &tenant{name: name, pingQuota: v.PingQuota, cellQuota: v.CellQuota}
+	for _, key := range v.Keys {
+		tenants.byKey[key] = t
+	}
+}
+
+// tenantsSnapshotLocked groups the keys by tenant, anonymous excluded. tenants must be locked
+func tenantsSnapshotLocked() map[string]tenantJSON {
+	out := make(map[string]tenantJSON)
+	for key, t := range tenants.byKey {
+		if t.name == anonymousTenant {
+			continue
+		}
+		v := out[t.name]
+		v.Keys = append(v.Keys, key)
+		v.PingQuota, v.CellQuota = t.pingQuota, t.cellQuota
+		out[t.name] = v
+	}
+	for _, v := range out {
+		sort.Strings(v.Keys)
+	}
+	return out
+}
+
+// loadTenants replaces the TENANTS keys with those of STORE_FILE at startup (anonymous stays as configured)
+func loadTenants() {
+	objects, source, seed := loadObjects[tenantJSON](storeTenants, "", "TENANTS")
+	tenants.Lock()
+	defer tenants.Unlock()
+	if objects != nil {
+		for name := range tenantsSnapshotLocked() {
+			setTenantLocked(name, nil)
+		}
+		for name, v := range objects {
+			if msg := validTenant(name, v); msg != "" || keyConflictLocked(name, v.Keys) {
+				log.Printf("invalid tenant %q in %s, ignoring", name, source)
+				continue
+			}
+			setTenantLocked(name, &v)
+		}
+		log.Printf("loaded %d tenants from %s", len(tenantsSnapshotLocked()), source)
+	}
+	if seed {
+		if err := saveTenantsLocked(); err != nil {
+			log.Fatalf("failed to save tenants to STORE_FILE: %v", err)
+		}
+	}
+}
+
+// saveTenantsLocked saves the tenants to STORE_FILE if set. tenants must be locked
+func saveTenantsLocked() error {
+	if store == nil {
+		return nil
+	}
+	return storeReplace(storeTenants, tenantsSnapshotLocked())
+}
+
+func getTenants(w http.ResponseWriter, r *http.Request) {
+	tenants.RLock()
+	out := make(map[string]tenantInfo)
+	for name, v := range tenantsSnapshotLocked() {
+		out[name] = tenantInfo{Keys: len(v.Keys), PingQuota: v.PingQuota, CellQuota: v.CellQuota}
+	}
+	tenants.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+}
+
+func getTenant(w http.ResponseWriter, r *http.Request) {
+	tenants.RLock()
+	v, ok := tenantsSnapshotLocked()[chi.URLParam(r, "name")]
+	tenants.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown tenant"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tenantInfo{Keys: len(v.Keys), PingQuota: v.PingQuota, CellQuota: v.CellQuota})
+}
+
+func putTenant(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	var v tenantJSON
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16384)).Decode(&v); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+	if msg := validTenant(name, v); msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(msg))
+		return
+	}
+
+	tenants.Lock()
+	defer tenants.Unlock()
+	if keyConflictLocked(name, v.Keys) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Key in use by another tenant"))
+		return
+	}
+	prev, existed := tenantsSnapshotLocked()[name]
+	setTenantLocked(name, &v)
+	if err := saveTenantsLocked(); err != nil {
+		if existed {
+			setTenantLocked(name, &prev)
+		} else {
+			setTenantLocked(name, nil)
+		}
+		log.Printf("failed to save tenants: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to save tenant"))
+		return
+	}
+	if existed {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func deleteTenant(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	tenants.Lock()
+	defer tenants.Unlock()
+	prev, ok := tenantsSnapshotLocked()[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown tenant"))
+		return
+	}
+	setTenantLocked(name, nil)
+	if err := saveTenantsLocked(); err != nil {
+		setTenantLocked(name, &prev)
+		log.Printf("failed to save tenants: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to save tenants"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
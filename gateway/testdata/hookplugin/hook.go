@@ -0,0 +1,21 @@
+// an ingest hook built as a plugin by TestIngestPlugin, not compiled into the gateway
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"geostreamdb/ingesthook"
+)
+
+func init() {
+	ingesthook.Register("test-plugin-fleet-only", func(json.RawMessage) (ingesthook.Hook, error) {
+		return ingesthook.HookFunc(func(p *ingesthook.Ping) error {
+			if p.DeviceID == "" {
+				return errors.New("fleet devices only")
+			}
+			p.DeviceID = "fleet-" + p.DeviceID
+			return nil
+		}), nil
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// request correlation without a tracing backend: every HTTP request gets a request id (X-Request-ID, generated unless
+// the client sent a valid one) and a W3C trace context (traceparent, started here unless the client sent a valid one).
+// both are echoed in the response headers (errors included), written to the access and slow query logs and sent to the
+// workers as gRPC metadata. workers receive the gateway as parent (same trace id, the gateway's span id)
+const maxRequestIDLength = 128
+
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+type traceInfo struct {
+	requestID   string
+	traceparent string // as received (or started here), echoed to the client
+	traceID     string
+	spanID      string // the gateway's, parent of the worker calls
+	flags       string
+}
+
+type traceContextKey struct{}
+
+func traceFrom(ctx context.Context) traceInfo {
+	t, _ := ctx.Value(traceContextKey{}).(traceInfo)
+	return t
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e { // visible ASCII
+			return false
+		}
+	}
+	return true
+}
+
+// newTrace reads (or starts) the request id and trace context of an incoming request
+func newTrace(requestID string, traceparent string) traceInfo {
+	t := traceInfo{requestID: requestID, spanID: randomHex(8)}
+	if !validRequestID(t.requestID) {
+		t.requestID = randomHex(16)
+	}
+	// version ff and all-zero ids are invalid (W3C trace context)
+	if m := traceparentPattern.FindStringSubmatch(traceparent); m != nil && traceparent[:2] != "ff" && m[1] != "00000000000000000000000000000000" && m[2] != "0000000000000000" {
+		t.traceparent, t.traceID, t.flags = traceparent, m[1], m[3]
+	} else {
+		t.traceID, t.flags = randomHex(16), "00"
+		t.traceparent = "00-" + t.traceID + "-" + t.spanID + "-" + t.flags
+	}
+	return t
+}
+
+func traceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := newTrace(r.Header.Get("X-Request-ID"), r.Header.Get("traceparent"))
+		w.Header().Set("X-Request-ID", t.requestID)
+		w.Header().Set("traceparent", t.traceparent)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), traceContextKey{}, t)))
+	})
+}
+
+// traceUnaryInterceptor sends the request's trace to the worker as gRPC metadata
+func traceUnaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if t := traceFrom(ctx); t.requestID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			"x-request-id", t.requestID,
+			"traceparent", "00-"+t.traceID+"-"+t.spanID+"-"+t.flags,
+		)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
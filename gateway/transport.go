@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// worker transport: how the gateway's connections to workers (GetConn, the shadow workers) reach them. tcp dials their
+// address; in-process reaches gRPC servers running in this process, through bufconn listeners registered under the
+// addresses they stand for, so that embedders and tests go through the real client and server stacks (interceptors,
+// per-worker limiter, status codes, deadlines) from the routing down to a worker implementation without sockets.
+// swapped like clock and ids
+type workerTransport interface {
+	// dial returns the target and the dial options of a connection to a worker address
+	dial(address string) (string, []grpc.DialOption)
+}
+
+var transport workerTransport = tcpTransport{}
+
+type tcpTransport struct{}
+
+func (tcpTransport) dial(address string) (string, []grpc.DialOption) {
+	return address, nil
+}
+
+const inProcessBufferSize = 1 << 20
+
+type inProcessTransport struct {
+	mu        sync.Mutex
+	listeners map[string]*bufconn.Listener // by worker address
+}
+
+func newInProcessTransport() *inProcessTransport {
+	return &inProcessTransport{listeners: make(map[string]*bufconn.Listener)}
+}
+
+// listen returns the listener of a worker address, for a gRPC server to serve. closing it makes the worker unreachable
+func (t *inProcessTransport) listen(address string) net.Listener {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := bufconn.Listen(inProcessBufferSize)
+	t.listeners[address] = l
+	return l
+}
+
+func (t *inProcessTransport) dial(address string) (string, []grpc.DialOption) {
+	// passthrough: the address names a listener, not a host to resolve
+	return "passthrough:///" + address, []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		t.mu.Lock()
+		l := t.listeners[addr]
+		t.mu.Unlock()
+		if l == nil {
+			return nil, fmt.Errorf("no in-process worker at %s", addr)
+		}
+		return l.DialContext(ctx)
+	})}
+}
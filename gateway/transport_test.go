@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "geostreamdb/proto"
+)
+
+// inProcessWorker is a worker gRPC server answering GetPings with a fixed count
+type inProcessWorker struct {
+	pb.UnimplementedWorkerServer
+
+	mu       sync.Mutex
+	count    int64
+	gateways []string // x-gateway-id of the calls
+}
+
+func (w *inProcessWorker) GetPings(ctx context.Context, in *pb.GetPingsRequest) (*pb.GetPingsResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.gateways = append(w.gateways, md.Get("x-gateway-id")...)
+	return &pb.GetPingsResponse{Count: w.count}, nil
+}
+
+// withInProcessWorkers serves the workers in this process under their addresses for the test
+func withInProcessWorkers(t *testing.T, workers map[string]pb.WorkerServer) {
+	previous := transport
+	inProcess := newInProcessTransport()
+	transport = inProcess
+	t.Cleanup(func() { transport = previous })
+	for address, w := range workers {
+		s := grpc.NewServer(grpcInterceptors.ServerOptions()...)
+		pb.RegisterWorkerServer(s, w)
+		go s.Serve(inProcess.listen(address))
+		t.Cleanup(s.Stop)
+	}
+}
+
+func TestRoutingReachesInProcessWorkersThroughGRPC(t *testing.T) {
+	worker := &inProcessWorker{count: 4}
+	withInProcessWorkers(t, map[string]pb.WorkerServer{"worker-a:50051": worker})
+	g := newTestGatewayState(t)
+	t.Cleanup(func() {
+		for _, conn := range g.clients {
+			conn.Close()
+		}
+	})
+
+	s := newGatewayService(fakeRing{owners: []string{"worker-a:50051"}}, g)
+	v, err := s.QueryPoint(context.Background(), "u4pruydq")
+	if err != nil || v.Count != 4 {
+		t.Fatalf("got %v, %v, want a count of 4", v, err)
+	}
+	worker.mu.Lock()
+	gateways := worker.gateways
+	worker.mu.Unlock()
+	if len(gateways) != 1 || gateways[0] != gatewayId {
+		t.Errorf("the worker saw gateway ids %v, want this gateway's %s", gateways, gatewayId)
+	}
+
+	s = newGatewayService(fakeRing{owners: []string{"worker-b:50051"}}, g) // not served
+	if _, err := s.QueryPoint(context.Background(), "u4pruydq"); !statusOf(err).retryable() {
+		t.Fatalf("unreachable worker: got %v (%d), want a retryable status", err, statusOf(err).http)
+	}
+}
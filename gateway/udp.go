@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// optional UDP ingest for battery/bandwidth-constrained trackers. each datagram carries one or more fixed-size records
+// (big-endian, no framing between records):
+//
+//	0      version (1)
+//	1..4   latitude  (int32, degrees * 1e7)
+//	5..8   longitude (int32, degrees * 1e7)
+//	9..16  device id (uint64, passed on in decimal: teleport detection, raw retention)
+//	17..20 CRC-32 (IEEE) of bytes 0..16
+//
+// valid records go through the same routing as POST /ping (and the ingest rate limit), accounted to the anonymous
+// tenant and its pings quota. there is no reply: malformed or dropped records (hooked_out: dropped or rejected by an
+// ingest hook) are only visible in gateway_udp_pings_total
+var UDP_PORT = os.Getenv("UDP_PORT") // unset = disabled
+var UDP_WORKERS = env.Int("UDP_WORKERS", 64)
+
+const (
+	udpRecordVersion = 1
+	udpRecordSize    = 21
+	udpCoordScale    = 1e7
+)
+
+type udpPing struct {
+	lat, lng   float64
+	deviceID   string    // in decimal
+	reservedAt time.Time // of its usage, refunded if it isn't stored
+}
+
+const udpUsageEndpoint = "UDP ping"
+
+// decodeUDPRecord parses a single record, returning the metric result on failure
+func decodeUDPRecord(rec []byte) (udpPing, string) {
+	if len(rec) != udpRecordSize || rec[0] != udpRecordVersion {
+		return udpPing{}, "malformed"
+	}
+	if crc32.ChecksumIEEE(rec[:17]) != binary.BigEndian.Uint32(rec[17:21]) {
+		return udpPing{}, "bad_checksum"
+	}
+	p := udpPing{
+		lat:      float64(int32(binary.BigEndian.Uint32(rec[1:5]))) / udpCoordScale,
+		lng:      float64(int32(binary.BigEndian.Uint32(rec[5:9]))) / udpCoordScale,
+		deviceID: strconv.FormatUint(binary.BigEndian.Uint64(rec[9:17]), 10),
+	}
+	if p.lat < -90 || p.lat > 90 || p.lng < -180 || p.lng > 180 {
+		return udpPing{}, "out_of_bounds"
+	}
+	return p, ""
+}
+
+func setup_udp_listener() {
+	if UDP_PORT == "" {
+		return
+	}
+	conn, err := net.ListenPacket("udp", ":"+UDP_PORT)
+	if err != nil {
+		log.Fatalf("failed to listen on udp: %v", err)
+	}
+	log.Printf("UDP ingest listening at %v", conn.LocalAddr())
+
+	// bounded worker pool: records arriving faster than the workers drain them are dropped, not queued without limit
+	queue := make(chan udpPing, max(UDP_WORKERS, 1)*16)
+	for i := 0; i < max(UDP_WORKERS, 1); i++ {
+		go func() {
+			for p := range queue {
+				ingestedAt := monotonicNow().UnixMilli()
+				if _, err := service.RoutePing(context.Background(), geo.Encode(p.lat, p.lng, MAX_GH_PRECISION), ingestedAt, p.deviceID, 0); err != nil {
+					if statusOf(err).http >= http.StatusInternalServerError {
+						refundUsage(anonymous, udpUsageEndpoint, unitPings, 1, p.reservedAt)
+					}
+					Metrics.udpPingsTotal.WithLabelValues("failed").Inc()
+					continue
+				}
+				Metrics.udpPingsTotal.WithLabelValues("accepted").Inc()
+			}
+		}()
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("udp read error: %v", err)
+			continue
+		}
+		if n == 0 || n%udpRecordSize != 0 {
+			Metrics.udpPingsTotal.WithLabelValues("malformed").Inc()
+			continue
+		}
+		if reason := filterIngestAddr(netAddrIP(addr)); reason != "" {
+			countFiltered(reason)
+			Metrics.udpPingsTotal.WithLabelValues("forbidden").Add(float64(n / udpRecordSize))
+			continue
+		}
+		for off := 0; off < n; off += udpRecordSize {
+			p, result := decodeUDPRecord(buf[off : off+udpRecordSize])
+			if result != "" {
+				Metrics.udpPingsTotal.WithLabelValues(result).Inc()
+				continue
+			}
+			if len(hooksFor(anonymous)) > 0 {
+				hooked := newHookPing(anonymous, "udp", netAddrIP(addr), p.lat, p.lng, p.deviceID)
+				if dropped, msg := runIngestHooks(anonymous, hooked); dropped || msg != "" {
+					Metrics.udpPingsTotal.WithLabelValues("hooked_out").Inc()
+					continue
+				}
+				p.lat, p.lng, p.deviceID = hooked.Lat, hooked.Lng, hooked.DeviceID
+			}
+			if reason := filterIngestPoint(p.lat, p.lng); reason != "" {
+				countFiltered(reason)
+				Metrics.udpPingsTotal.WithLabelValues("forbidden").Inc()
+				continue
+			}
+			if !unsignedPingAllowed(p.deviceID) {
+				Metrics.udpPingsTotal.WithLabelValues("unsigned").Inc()
+				continue
+			}
+			p.lat, p.lng = privacyFor(anonymous).coarsen(p.lat, p.lng)
+			if !aclFor(anonymous).allowsPoint(p.lat, p.lng) {
+				Metrics.udpPingsTotal.WithLabelValues("forbidden").Inc()
+				continue
+			}
+			if !ingestGroup.limiter.allow() {
+				Metrics.udpPingsTotal.WithLabelValues("rate_limited").Inc()
+				continue
+			}
+			p.reservedAt = time.Now()
+			if !reserveUsage(anonymous, udpUsageEndpoint, unitPings, 1) {
+				Metrics.udpPingsTotal.WithLabelValues("quota_exceeded").Inc()
+				continue
+			}
+			select {
+			case queue <- p:
+			default:
+				refundUsage(anonymous, udpUsageEndpoint, unitPings, 1, p.reservedAt)
+				Metrics.udpPingsTotal.WithLabelValues("dropped").Inc()
+			}
+		}
+	}
+}
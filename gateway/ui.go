@@ -0,0 +1,32 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"geostreamdb/env"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// demo map served at /ui with UI_ENABLED: a Leaflet heatmap of the visible area, kept live by GET /pingArea/stream
+// (right click sends a ping). the page is embedded in the binary and loads Leaflet from unpkg. served with the query
+// routes, so it calls the API on its own origin; EventSource can't send headers, so the stream is read as the
+// anonymous tenant and QUERY_TOKEN can't be used with it. in DEMO_MODE, it starts over DEMO_CITY (/ui/demo.json)
+var UI_ENABLED = env.Bool("UI_ENABLED", false)
+
+//go:embed ui
+var uiFiles embed.FS
+
+func uiRoutes(r chi.Router) {
+	if !UI_ENABLED {
+		return
+	}
+	files, _ := fs.Sub(uiFiles, "ui")
+	r.Get("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently).ServeHTTP)
+	if DEMO_MODE {
+		r.Get("/ui/demo.json", getDemoView)
+	}
+	r.Handle("/ui/*", http.StripPrefix("/ui", http.FileServer(http.FS(files))))
+}
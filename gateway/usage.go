@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/go-chi/chi/v5"
+)
+
+// per-tenant usage accounting with optional monthly quotas. tenants are identified by the X-API-Key header; requests
+// without a configured key are accounted as "anonymous" (accounting only, not authentication). usage is kept per
+// gateway replica, so with N gateways a tenant can use up to N times its quota (sum the Prometheus counters for totals)
+//
+// TENANTS="name:key:pingQuota:cellQuota,..." (quotas per calendar month, UTC. 0 = unlimited). a tenant named
+// "anonymous" (any key) sets the quota for unidentified requests. tenants other than anonymous can be changed at
+// runtime (see tenants.go)
+var tenants = struct {
+	sync.RWMutex
+	byKey map[string]*tenant // api key -> tenant
+}{byKey: parseTenants(os.Getenv("TENANTS"))}
+var QUOTA_EXCEEDED_STATUS = env.Int("QUOTA_EXCEEDED_STATUS", http.StatusTooManyRequests) // or 402 (Payment Required)
+
+const anonymousTenant = "anonymous"
+
+type tenant struct {
+	name      string
+	pingQuota int64
+	cellQuota int64
+}
+
+func parseTenants(v string) map[string]*tenant { // api key -> tenant
+	tenants := make(map[string]*tenant)
+	for _, entry := range strings.Split(v, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 4 || parts[0] == "" || parts[1] == "" {
+			log.Printf("invalid TENANTS entry %q, ignoring", entry)
+			continue
+		}
+		pings, err1 := strconv.ParseInt(parts[2], 10, 64)
+		cells, err2 := strconv.ParseInt(parts[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			log.Printf("invalid TENANTS quota in %q, ignoring", entry)
+			continue
+		}
+		tenants[parts[1]] = &tenant{name: parts[0], pingQuota: pings, cellQuota: cells}
+	}
+	return tenants
+}
+
+var anonymous = func() *tenant {
+	for _, t := range tenants.byKey {
+		if t.name == anonymousTenant {
+			return t
+		}
+	}
+	return &tenant{name: anonymousTenant}
+}()
+
+func tenantFor(r *http.Request) *tenant {
+	if t, ok := tenantByKey(r.Header.Get("X-API-Key")); ok {
+		return t
+	}
+	return anonymous
+}
+
+func tenantByKey(key string) (*tenant, bool) {
+	tenants.RLock()
+	defer tenants.RUnlock()
+	t, ok := tenants.byKey[key]
+	return t, ok
+}
+
+type usageUnit string
+
+const (
+	unitPings usageUnit = "pings" // pings ingested
+	unitCells usageUnit = "cells" // cells queried (at the requested precision)
+)
+
+type endpointUsage struct {
+	Requests int64 `json:"requests"`
+	Units    int64 `json:"units"`
+}
+
+type tenantUsage struct {
+	Pings     int64                     `json:"pings"`
+	Cells     int64                     `json:"cells"`
+	PingQuota int64                     `json:"pingQuota"` // 0 = unlimited
+	CellQuota int64                     `json:"cellQuota"`
+	Endpoints map[string]*endpointUsage `json:"endpoints"`
+}
+
+type usageTracker struct {
+	mu      sync.Mutex
+	month   string // UTC "2006-01"; usage resets when it changes
+	tenants map[string]*tenantUsage
+}
+
+var usage = &usageTracker{}
+
+func (u *usageTracker) rollMonthLocked(now time.Time) {
+	if month := now.UTC().Format("2006-01"); month != u.month {
+		u.month = month
+		u.tenants = make(map[string]*tenantUsage)
+	}
+}
+
+// reserve accounts units of a request against the tenant's monthly quota. returns false (and accounts nothing) if they
+// would exceed it
+func (u *usageTracker) reserve(t *tenant, endpoint string, unit usageUnit, units int64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollMonthLocked(time.Now())
+
+	tu := u.tenants[t.name]
+	if tu == nil {
+		tu = &tenantUsage{Endpoints: make(map[string]*endpointUsage)}
+		u.tenants[t.name] = tu
+	}
+	tu.PingQuota, tu.CellQuota = t.pingQuota, t.cellQuota // changed through /admin/tenants
+
+	used, quota := &tu.Pings, t.pingQuota
+	if unit == unitCells {
+		used, quota = &tu.Cells, t.cellQuota
+	}
+	if quota > 0 && *used+units > quota {
+		return false
+	}
+	*used += units
+
+	eu := tu.Endpoints[endpoint]
+	if eu == nil {
+		eu = &endpointUsage{}
+		tu.Endpoints[endpoint] = eu
+	}
+	eu.Requests++
+	eu.Units += units
+	return true
+}
+
+// refund gives back units reserved at reservedAt for a request that failed, unless the month rolled over meanwhile
+func (u *usageTracker) refund(t *tenant, endpoint string, unit usageUnit, units int64, reservedAt time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollMonthLocked(time.Now())
+	if reservedAt.UTC().Format("2006-01") != u.month {
+		return false
+	}
+
+	tu := u.tenants[t.name]
+	if tu == nil {
+		return false
+	}
+	used := &tu.Pings
+	if unit == unitCells {
+		used = &tu.Cells
+	}
+	*used = max(*used-units, 0)
+	if eu := tu.Endpoints[endpoint]; eu != nil {
+		eu.Units = max(eu.Units-units, 0)
+	}
+	return true
+}
+
+type usageSnapshot struct {
+	Month   string                  `json:"month"`
+	Tenants map[string]*tenantUsage `json:"tenants"`
+}
+
+func (u *usageTracker) snapshot() usageSnapshot {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollMonthLocked(time.Now())
+
+	out := usageSnapshot{Month: u.month, Tenants: make(map[string]*tenantUsage, len(u.tenants))}
+	for name, tu := range u.tenants {
+		c := *tu
+		c.Endpoints = make(map[string]*endpointUsage, len(tu.Endpoints))
+		for e, eu := range tu.Endpoints {
+			v := *eu
+			c.Endpoints[e] = &v
+		}
+		out.Tenants[name] = &c
+	}
+	return out
+}
+
+// merge adds the usage of a snapshot (of another gateway, see handoff.go) if it is of the current month. quotas are
+// the importer's
+func (u *usageTracker) merge(s usageSnapshot) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollMonthLocked(time.Now())
+	if s.Month != u.month {
+		return
+	}
+
+	for name, su := range s.Tenants {
+		tu := u.tenants[name]
+		if tu == nil {
+			tu = &tenantUsage{Endpoints: make(map[string]*endpointUsage)}
+			tenants.RLock()
+			for _, t := range tenants.byKey {
+				if t.name == name {
+					tu.PingQuota, tu.CellQuota = t.pingQuota, t.cellQuota
+				}
+			}
+			tenants.RUnlock()
+			u.tenants[name] = tu
+		}
+		tu.Pings += su.Pings
+		tu.Cells += su.Cells
+		for endpoint, se := range su.Endpoints {
+			eu := tu.Endpoints[endpoint]
+			if eu == nil {
+				eu = &endpointUsage{}
+				tu.Endpoints[endpoint] = eu
+			}
+			eu.Requests += se.Requests
+			eu.Units += se.Units
+		}
+	}
+}
+
+// usage reserved by the requests of a route group, refunded by usageMiddleware if the request fails
+type usageReservations struct {
+	mu   sync.Mutex
+	list []usageReservation
+}
+
+type usageReservation struct {
+	tenant   *tenant
+	endpoint string
+	unit     usageUnit
+	units    int64
+	at       time.Time
+}
+
+type usageReservationsKey struct{}
+
+// usageMiddleware refunds the usage a request reserved (see admitUsage) when it fails with a 5xx: no worker, a failed
+// or timed out call, a full ingest queue. a tenant is charged for what it was served, not for the cluster's failures
+func usageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reservations := &usageReservations{}
+		code := http.StatusOK
+		next.ServeHTTP(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(c int) {
+					code = c
+					next(c)
+				}
+			},
+		}), r.WithContext(context.WithValue(r.Context(), usageReservationsKey{}, reservations)))
+
+		if code < http.StatusInternalServerError {
+			return
+		}
+		reservations.mu.Lock()
+		defer reservations.mu.Unlock()
+		for _, v := range reservations.list {
+			refundUsage(v.tenant, v.endpoint, v.unit, v.units, v.at)
+		}
+	})
+}
+
+// admitUsage accounts a request against its tenant's quota, writing the quota error response if it is exceeded. the
+// units are refunded if the request then fails (see usageMiddleware)
+func admitUsage(w http.ResponseWriter, r *http.Request, unit usageUnit, units int64) bool {
+	t := tenantFor(r)
+	endpoint := r.Method + " " + chi.RouteContext(r.Context()).RoutePattern()
+
+	at := time.Now()
+	if !reserveUsage(t, endpoint, unit, units) {
+		if QUOTA_EXCEEDED_STATUS == http.StatusTooManyRequests {
+			now := time.Now().UTC()
+			nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			w.Header().Set("Retry-After", strconv.Itoa(int(nextMonth.Sub(now).Seconds())+1))
+		}
+		w.WriteHeader(QUOTA_EXCEEDED_STATUS)
+		w.Write([]byte("Monthly " + string(unit) + " quota exceeded"))
+		return false
+	}
+	if reservations, ok := r.Context().Value(usageReservationsKey{}).(*usageReservations); ok {
+		reservations.mu.Lock()
+		reservations.list = append(reservations.list, usageReservation{t, endpoint, unit, units, at})
+		reservations.mu.Unlock()
+	}
+	return true
+}
+
+// reserveUsage accounts units against a tenant's quota and records the usage metrics (shared by the HTTP, CoAP and RESP
+// front ends)
+func reserveUsage(t *tenant, endpoint string, unit usageUnit, units int64) bool {
+	if !usage.reserve(t, endpoint, unit, units) {
+		Metrics.quotaRejectedTotal.WithLabelValues(t.name, endpoint).Inc()
+		return false
+	}
+	Metrics.tenantUsageTotal.WithLabelValues(t.name, endpoint, string(unit)).Add(float64(units))
+	return true
+}
+
+// refundUsage gives back units reserved at reservedAt by reserveUsage for a request that failed
+func refundUsage(t *tenant, endpoint string, unit usageUnit, units int64, reservedAt time.Time) {
+	if units > 0 && usage.refund(t, endpoint, unit, units, reservedAt) {
+		Metrics.tenantRefundedTotal.WithLabelValues(t.name, endpoint, string(unit)).Add(float64(units))
+	}
+}
+
+func getUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(usage.snapshot())
+}
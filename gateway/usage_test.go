@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestUsageIsRefundedWhenTheRequestFails(t *testing.T) {
+	previousUsage, previousTenants := usage, tenants.byKey
+	usage = &usageTracker{}
+	tenants.Lock()
+	tenants.byKey = map[string]*tenant{"key": {name: "acme", pingQuota: 1}}
+	tenants.Unlock()
+	t.Cleanup(func() {
+		usage = previousUsage
+		tenants.Lock()
+		tenants.byKey = previousTenants
+		tenants.Unlock()
+	})
+
+	status := http.StatusServiceUnavailable
+	router := chi.NewRouter()
+	router.Use(usageMiddleware)
+	router.Post("/ping", func(w http.ResponseWriter, r *http.Request) {
+		if admitUsage(w, r, unitPings, 1) {
+			w.WriteHeader(status)
+		}
+	})
+	post := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/ping", nil)
+		req.Header.Set("X-API-Key", "key")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// failed: given back, so the quota of 1 still admits the next ping
+	for range 3 {
+		if code := post(); code != http.StatusServiceUnavailable {
+			t.Fatalf("got %d, want the handler's 503", code)
+		}
+	}
+	if got := usage.snapshot().Tenants["acme"].Pings; got != 0 {
+		t.Fatalf("%d pings accounted after failed requests", got)
+	}
+
+	status = http.StatusCreated
+	if code := post(); code != http.StatusCreated {
+		t.Fatalf("got %d, want 201", code)
+	}
+	if code := post(); code != QUOTA_EXCEEDED_STATUS {
+		t.Fatalf("got %d once the quota is used, want %d", code, QUOTA_EXCEEDED_STATUS)
+	}
+	if got := usage.snapshot().Tenants["acme"].Pings; got != 1 {
+		t.Fatalf("%d pings accounted, want 1", got)
+	}
+}
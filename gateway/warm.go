@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"geostreamdb/env"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// warm connections: the gateway dials a worker as soon as it joins the ring instead of on the first request routed to
+// it, which would otherwise pay the TCP and HTTP/2 (and TLS) handshakes in its latency. the pooled connection is the
+// one GetConn hands out, so requests arriving while it is still connecting wait on it rather than dialing again. a
+// worker whose connection isn't ready within WARM_CONN_TIMEOUT is logged (gateway_warm_connections_total by result),
+// and gateway_worker_channels{state} counts the pooled connections per state (ready, connecting, idle,
+// transient_failure) until they are closed
+var WARM_CONNS = env.Bool("WARM_CONNS", true)
+var WARM_CONN_TIMEOUT = env.Duration("WARM_CONN_TIMEOUT", 5*time.Second)
+
+// warmConn dials a worker that just joined the ring and waits for its connection to be ready
+func (g *GatewayState) warmConn(address string) {
+	conn, err := g.GetConn(address)
+	if err != nil {
+		Metrics.warmConnsTotal.WithLabelValues("failed").Inc()
+		return
+	}
+	conn.Connect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), WARM_CONN_TIMEOUT)
+	defer cancel()
+	for s := conn.GetState(); s != connectivity.Ready; s = conn.GetState() {
+		if s == connectivity.Shutdown || !conn.WaitForStateChange(ctx, s) {
+			Metrics.warmConnsTotal.WithLabelValues("timeout").Inc()
+			log.Printf("connection to worker %s not ready after %s (%s)", address, WARM_CONN_TIMEOUT, channelState(s))
+			return
+		}
+	}
+	Metrics.warmConnsTotal.WithLabelValues("ready").Inc()
+}
+
+// watchConn keeps gateway_worker_channels up to date with the state of a pooled connection until it is closed
+func watchConn(conn *grpc.ClientConn) {
+	s := conn.GetState()
+	Metrics.workerChannels.WithLabelValues(channelState(s)).Inc()
+	for {
+		conn.WaitForStateChange(context.Background(), s)
+		next := conn.GetState()
+		Metrics.workerChannels.WithLabelValues(channelState(s)).Dec()
+		if next == connectivity.Shutdown {
+			return
+		}
+		Metrics.workerChannels.WithLabelValues(channelState(next)).Inc()
+		s = next
+	}
+}
+
+func channelState(s connectivity.State) string {
+	return strings.ToLower(s.String())
+}
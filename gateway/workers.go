@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/status"
+)
+
+// GET /admin/workers: every worker's GetInfo (build and protocol versions, uptime, settings, slot occupancy, the prefixes it holds data
+// for) next to what this gateway knows of it (negotiated api version, share of the hash ring), for cluster
+// introspection at a glance. a worker that doesn't answer is listed with its error
+type workerDetail struct {
+	WorkerID          string            `json:"workerId,omitempty"`
+	BuildVersion      string            `json:"buildVersion,omitempty"`
+	BuildCommit       string            `json:"buildCommit,omitempty"`
+	BuildCompatible   bool              `json:"buildCompatible"`             // with this gateway's release
+	APIVersion        uint32            `json:"apiVersion"`                  // negotiated from heartbeats
+	WorkerAPIVersions []uint32          `json:"workerApiVersions,omitempty"` // [min, max] supported
+	RingShare         float64           `json:"ringShare"`                   // fraction of the shard keys routed to it as primary
+	Draining          bool              `json:"draining,omitempty"`          // routed around by a rolling restart
+	Features          []string          `json:"features,omitempty"`          // listed in its heartbeats (none: an older build)
+	StartedAt         int64             `json:"startedAt,omitempty"`         // unix ms
+	UptimeSeconds     int64             `json:"uptimeSeconds,omitempty"`
+	StandbyFor        string            `json:"standbyFor,omitempty"`
+	Config            map[string]string `json:"config,omitempty"`
+	Slots             []workerSlots     `json:"slots,omitempty"`
+	OwnedPrefixes     []string          `json:"ownedPrefixes,omitempty"`
+	PrefixesTruncated bool              `json:"prefixesTruncated,omitempty"`
+	Error             string            `json:"error,omitempty"`
+}
+
+type workerSlots struct {
+	Buffer    string `json:"buffer"`
+	Slots     int32  `json:"slots"`
+	Occupied  int32  `json:"occupied"`
+	TrieNodes int64  `json:"trieNodes"`
+	TrieDepth int32  `json:"trieDepth"`
+}
+
+func getWorkers(w http.ResponseWriter, r *http.Request) {
+	prefixLength := 0 // worker default
+	if lengthQ := r.URL.Query().Get("prefixLength"); lengthQ != "" {
+		var err error
+		if prefixLength, err = strconv.Atoi(lengthQ); err != nil || prefixLength < 1 || prefixLength >= shardingPrecision() {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid prefixLength (1 to " + strconv.Itoa(shardingPrecision()-1) + ")"))
+			return
+		}
+	}
+
+	shares := state.ringShares()
+	workers := make(map[string]*workerDetail)
+	var mu sync.Mutex
+	err := service.Broadcast(r.Context(), "GetInfo", func(ctx context.Context, addr string, client pb.WorkerClient) error {
+		detail := &workerDetail{APIVersion: state.apiVersion(addr), RingShare: shares[addr], Draining: state.isDraining(addr), BuildVersion: state.buildVersionOf(addr), Features: state.featuresOf(addr)}
+		v, err := client.GetInfo(ctx, &pb.GetInfoRequest{ApiVersion: state.apiVersion(addr), PrefixLength: int32(prefixLength)})
+		if err != nil {
+			detail.Error = status.Convert(err).Message()
+		} else {
+			detail.WorkerID = v.WorkerId
+			detail.BuildVersion, detail.BuildCommit = v.BuildVersion, v.BuildCommit
+			detail.WorkerAPIVersions = []uint32{v.MinApiVersion, v.ApiVersion}
+			detail.StartedAt = v.StartedAt
+			detail.UptimeSeconds = int64(time.Since(time.UnixMilli(v.StartedAt)).Seconds())
+			detail.StandbyFor = v.StandbyFor
+			detail.Config = v.Config
+			for _, s := range v.Slots {
+				detail.Slots = append(detail.Slots, workerSlots{Buffer: s.Buffer, Slots: s.Slots, Occupied: s.Occupied, TrieNodes: s.TrieNodes, TrieDepth: s.TrieDepth})
+			}
+			detail.OwnedPrefixes = v.OwnedPrefixes
+			detail.PrefixesTruncated = v.PrefixesTruncated
+		}
+		detail.BuildCompatible = buildsCompatible(build.Version, detail.BuildVersion)
+		mu.Lock()
+		workers[addr] = detail
+		mu.Unlock()
+		return err
+	})
+	if errors.Is(err, errNoWorkers) {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"workers": workers})
+}
+
+// ringShares returns the fraction of the hash space each worker owns as primary (a key goes to the first virtual node
+// at or after its hash of a worker not draining)
+func (g *GatewayState) ringShares() map[string]float64 {
+	g.ringMutex.RLock()
+	defer g.ringMutex.RUnlock()
+
+	shares := make(map[string]float64)
+	if len(g.ring) == 1 {
+		shares[g.ring[0].Server] = 1
+	}
+	if len(g.ring) <= 1 {
+		return shares
+	}
+	for i, node := range g.ring {
+		prev := g.ring[(i+len(g.ring)-1)%len(g.ring)].Hash
+		owner := node.Server
+		for j := 1; g.draining[owner] && j < len(g.ring); j++ { // a draining worker's keys go to the next one
+			owner = g.ring[(i+j)%len(g.ring)].Server
+		}
+		shares[owner] += float64(node.Hash-prev) / math.MaxUint64 // wraps around for the first node
+	}
+	return shares
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// write latency budget: with WRITE_BUDGET set (e.g. 50ms), a POST /ping (ack=leader or all) whose write hasn't been
+// acknowledged within it is answered 202 instead of waiting, so that devices see predictable ingest latency when a
+// worker is slow. the write carries on in the background; if it then fails without having been sent to any worker
+// (see neverSent), the ping goes to the ack=none senders, which retry it up to WRITE_BUDGET_RETRIES times (backing
+// off from WRITE_BUDGET_BACKOFF) with consistency ONE. any other failure (a timeout, an unavailable worker) may come
+// after the worker stored the ping, so it isn't sent again: without a seq it would be counted twice. like ack=none,
+// such a ping is lost if the gateway stops meanwhile. counted in gateway_write_budget_total{result}: within (answered
+// in time), exceeded (answered 202), then late (the background write succeeded), requeued or failed
+var WRITE_BUDGET = env.Duration("WRITE_BUDGET", 0) // 0 disables the budget
+var WRITE_BUDGET_RETRIES = env.Int("WRITE_BUDGET_RETRIES", 3)
+var WRITE_BUDGET_BACKOFF = env.Duration("WRITE_BUDGET_BACKOFF", 200*time.Millisecond)
+
+type writeResult struct {
+	acks    int
+	primary *pb.PingResponse
+	err     error
+}
+
+// writePingWithin is writePing bounded by WRITE_BUDGET: exceeded is true if it ran out, the write being left to
+// complete in the background
+func writePingWithin(ctx context.Context, p asyncPing, level string) (acks int, primary *pb.PingResponse, exceeded bool, err error) {
+	write := func(ctx context.Context) writeResult {
+		acks, primary, err := writePing(withTenant(withFloor(withMotion(withSentAt(ctx, p.sentAt), p.motion), p.floor), p.tenant), p.gh, p.ingestedAt, p.deviceID, p.seq, level)
+		return writeResult{acks, primary, err}
+	}
+	if WRITE_BUDGET <= 0 {
+		v := write(ctx)
+		return v.acks, v.primary, false, v.err
+	}
+
+	done := make(chan writeResult, 1)
+	go func() { done <- write(context.WithoutCancel(ctx)) }() // outlives the request once the budget is exceeded
+	timer := time.NewTimer(WRITE_BUDGET)
+	defer timer.Stop()
+	select {
+	case v := <-done:
+		Metrics.writeBudgetTotal.WithLabelValues("within").Inc()
+		return v.acks, v.primary, false, v.err
+	case <-timer.C:
+	}
+
+	Metrics.writeBudgetTotal.WithLabelValues("exceeded").Inc()
+	go func() {
+		v := <-done
+		switch {
+		case v.err == nil || errors.Is(v.err, errDuplicatePing):
+			Metrics.writeBudgetTotal.WithLabelValues("late").Inc()
+		case v.acks == 0 && neverSent(v.err) && WRITE_BUDGET_RETRIES > 0:
+			// stored nowhere, so it can be sent again without counting it twice
+			p.retries = WRITE_BUDGET_RETRIES
+			if queuePing(p) {
+				Metrics.writeBudgetTotal.WithLabelValues("requeued").Inc()
+				return
+			}
+			Metrics.writeBudgetTotal.WithLabelValues("failed").Inc()
+		default:
+			Metrics.writeBudgetTotal.WithLabelValues("failed").Inc()
+		}
+	}()
+	return 0, nil, true, nil
+}
+
+// neverSent reports whether a write failed before reaching any worker: no worker to send it to, no connection, or
+// turned away by the per-worker limiter (workers themselves don't answer ResourceExhausted). a quorum write's error
+// stands for one of its calls only, the others may have stored the ping
+func neverSent(err error) bool {
+	if errors.Is(err, errConsistency) {
+		return false
+	}
+	return errors.Is(err, errNoWorkers) || errors.Is(err, errWorkerConnect) || status.Code(err) == codes.ResourceExhausted
+}
+
+// retryAsync sends a ping the senders failed to route again after a backoff, if it has retries left and the failed
+// send can't have stored it
+func retryAsync(p asyncPing, err error) bool {
+	if p.retries <= 0 || !neverSent(err) {
+		return false
+	}
+	p.retries--
+	backoff := WRITE_BUDGET_BACKOFF << (WRITE_BUDGET_RETRIES - 1 - p.retries)
+	time.AfterFunc(backoff, func() { queuePing(p) }) // counted as rejected with a full queue
+	return true
+}
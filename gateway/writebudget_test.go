@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type slowWorker struct {
+	fakeWorker
+	delay time.Duration
+	calls int
+}
+
+func (w *slowWorker) SendPing(ctx context.Context, in *pb.PingRequest, opts ...grpc.CallOption) (*pb.PingResponse, error) {
+	time.Sleep(w.delay)
+	w.mu.Lock()
+	w.calls++
+	w.mu.Unlock()
+	return w.fakeWorker.SendPing(ctx, in, opts...)
+}
+
+// waitCalls waits for the background writes to reach the worker
+func (w *slowWorker) waitCalls(t *testing.T, n int) {
+	calls := 0
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		w.mu.Lock()
+		calls = w.calls
+		w.mu.Unlock()
+		if calls >= n {
+			return
+		}
+	}
+	t.Fatalf("%d writes reached the worker, want %d", calls, n)
+}
+
+func withWriteBudget(t *testing.T, budget time.Duration, worker pb.WorkerClient) {
+	previousBudget, previousService, previousQueue := WRITE_BUDGET, service, asyncQueue
+	WRITE_BUDGET = budget
+	service = newGatewayService(fakeRing{owners: []string{"a"}, servers: []string{"a"}}, fakeWorkersOf{"a": worker})
+	asyncQueue = make(chan asyncPing, 4)
+	t.Cleanup(func() { WRITE_BUDGET, service, asyncQueue = previousBudget, previousService, previousQueue })
+}
+
+func TestWritePingWithinBudget(t *testing.T) {
+	w := &slowWorker{delay: time.Millisecond}
+	withWriteBudget(t, time.Second, w)
+	_, _, exceeded, err := writePingWithin(context.Background(), asyncPing{gh: "ezjmgtwq"}, consistencyOne)
+	if exceeded || err != nil || len(w.received()) != 1 {
+		t.Errorf("got exceeded %v, %v, %d pings stored", exceeded, err, len(w.received()))
+	}
+}
+
+func TestWritePingOverBudgetCompletesInTheBackground(t *testing.T) {
+	w := &slowWorker{delay: 100 * time.Millisecond}
+	withWriteBudget(t, 10*time.Millisecond, w)
+	start := time.Now()
+	_, _, exceeded, err := writePingWithin(context.Background(), asyncPing{gh: "ezjmgtwq"}, consistencyOne)
+	if !exceeded || err != nil || time.Since(start) > 80*time.Millisecond {
+		t.Fatalf("got exceeded %v, %v after %v", exceeded, err, time.Since(start))
+	}
+	w.waitCalls(t, 1)
+	if len(w.received()) != 1 {
+		t.Errorf("the late write wasn't stored")
+	}
+}
+
+func TestWritePingOverBudgetRequeuesUnsentPings(t *testing.T) {
+	// the per-worker limiter turned the write away: the worker never saw it
+	w := &slowWorker{delay: 50 * time.Millisecond, fakeWorker: fakeWorker{err: status.Error(codes.ResourceExhausted, "worker queue full")}}
+	withWriteBudget(t, 10*time.Millisecond, w)
+	if _, _, exceeded, _ := writePingWithin(context.Background(), asyncPing{gh: "ezjmgtwq", deviceID: "d1"}, consistencyOne); !exceeded {
+		t.Fatalf("budget not exceeded")
+	}
+	select {
+	case p := <-asyncQueue:
+		if p.gh != "ezjmgtwq" || p.deviceID != "d1" || p.retries != WRITE_BUDGET_RETRIES {
+			t.Errorf("got %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("failed write not requeued")
+	}
+
+	// a non-retryable failure isn't
+	w.mu.Lock()
+	w.err = status.Error(codes.InvalidArgument, "bad geohash")
+	w.mu.Unlock()
+	writePingWithin(context.Background(), asyncPing{gh: "ezjmgtwq"}, consistencyOne)
+	w.waitCalls(t, 2)
+	select {
+	case p := <-asyncQueue:
+		t.Errorf("requeued %+v", p)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWritePingOverBudgetDoesNotRequeueWritesTheWorkerMayHaveStored(t *testing.T) {
+	for _, err := range []error{
+		status.Error(codes.DeadlineExceeded, "deadline exceeded"), // errShardTimeout: the worker may have stored it and answered late
+		status.Error(codes.Unavailable, "connection reset"),
+	} {
+		w := &slowWorker{delay: 50 * time.Millisecond, fakeWorker: fakeWorker{err: err}}
+		withWriteBudget(t, 10*time.Millisecond, w)
+		if _, _, exceeded, _ := writePingWithin(context.Background(), asyncPing{gh: "ezjmgtwq"}, consistencyOne); !exceeded {
+			t.Fatalf("budget not exceeded")
+		}
+		w.waitCalls(t, 1)
+		select {
+		case p := <-asyncQueue:
+			t.Errorf("%v: requeued %+v, would be counted twice", err, p)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+func TestNeverSent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errNoWorkers, true},
+		{errWorkerConnect, true},
+		{status.Error(codes.ResourceExhausted, "worker queue full"), true},
+		{shardError(status.Error(codes.DeadlineExceeded, "deadline exceeded")), false},
+		{status.Error(codes.Unavailable, "down"), false},
+		{errors.Join(errConsistency, errWorkerConnect), false}, // the other replicas may have stored it
+		{status.Error(codes.InvalidArgument, "bad geohash"), false},
+	}
+	for _, tt := range tests {
+		if got := neverSent(tt.err); got != tt.want {
+			t.Errorf("neverSent(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
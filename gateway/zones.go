@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"geostreamdb/geo"
+	"github.com/go-chi/chi/v5"
+)
+
+// named polygon sets (neighborhoods, delivery zones, ...) uploaded through the admin API and kept by each gateway.
+// GET /pingArea/byZone?set=..&precision=.. counts the pings per zone: the /pingArea cells covering the set are assigned
+// to every zone containing their center. with several gateways, upload the set to each of them (or share ZONES_FILE)
+//
+//	PUT /admin/zones/{set} {"zoneName": [[lat, lng], ...], ...}
+//	GET /admin/zones, GET /admin/zones/{set}, DELETE /admin/zones/{set}
+var ZONES_FILE = os.Getenv("ZONES_FILE") // persisted across restarts if set (unless STORE_FILE is)
+
+const (
+	maxZonesPerSet    = 1000
+	maxZoneVertices   = 1024
+	maxZoneSetBody    = 8 << 20 // bytes
+	maxZoneNameLength = 128
+)
+
+type zone struct {
+	name     string
+	vertices [][2]float64 // lat, lng
+	bbox     geo.Bbox
+}
+
+type zoneSet struct {
+	zones []*zone  // sorted by name
+	bbox  geo.Bbox // union of the zones
+}
+
+var zones = struct {
+	mu   sync.RWMutex
+	sets map[string]*zoneSet
+}{sets: make(map[string]*zoneSet)}
+
+func newZoneSet(raw map[string][][2]float64) (*zoneSet, string) {
+	if len(raw) == 0 || len(raw) > maxZonesPerSet {
+		return nil, "A zone set needs 1 to " + strconv.Itoa(maxZonesPerSet) + " zones"
+	}
+	set := &zoneSet{bbox: geo.Bbox{MinLat: 90, MaxLat: -90, MinLng: 180, MaxLng: -180}}
+	for name, vertices := range raw {
+		if name == "" || len(name) > maxZoneNameLength {
+			return nil, "Invalid zone name"
+		}
+		if len(vertices) < 3 || len(vertices) > maxZoneVertices {
+			return nil, "Zone " + strconv.Quote(name) + " needs 3 to " + strconv.Itoa(maxZoneVertices) + " vertices"
+		}
+		z := &zone{name: name, vertices: vertices, bbox: geo.Bbox{MinLat: 90, MaxLat: -90, MinLng: 180, MaxLng: -180}}
+		for _, v := range vertices {
+			lat, lng := v[0], v[1]
+			if math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+				return nil, "Zone " + strconv.Quote(name) + " has an invalid vertex"
+			}
+			z.bbox.MinLat, z.bbox.MaxLat = min(z.bbox.MinLat, lat), max(z.bbox.MaxLat, lat)
+			z.bbox.MinLng, z.bbox.MaxLng = min(z.bbox.MinLng, lng), max(z.bbox.MaxLng, lng)
+		}
+		set.bbox.MinLat, set.bbox.MaxLat = min(set.bbox.MinLat, z.bbox.MinLat), max(set.bbox.MaxLat, z.bbox.MaxLat)
+		set.bbox.MinLng, set.bbox.MaxLng = min(set.bbox.MinLng, z.bbox.MinLng), max(set.bbox.MaxLng, z.bbox.MaxLng)
+		set.zones = append(set.zones, z)
+	}
+	sort.Slice(set.zones, func(i, j int) bool { return set.zones[i].name < set.zones[j].name })
+	return set, ""
+}
+
+func (s *zoneSet) raw() map[string][][2]float64 {
+	out := make(map[string][][2]float64, len(s.zones))
+	for _, z := range s.zones {
+		out[z.name] = z.vertices
+	}
+	return out
+}
+
+// contains is the even-odd rule (ray casting along the latitude axis)
+func (z *zone) contains(lat, lng float64) bool {
+	if !z.bbox.Contains(lat, lng) {
+		return false
+	}
+	inside := false
+	for i, j := 0, len(z.vertices)-1; i < len(z.vertices); j, i = i, i+1 {
+		a, b := z.vertices[i], z.vertices[j]
+		if (a[0] > lat) != (b[0] > lat) && lng < (b[1]-a[1])*(lat-a[0])/(b[0]-a[0])+a[1] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// containsBbox reports whether the box lies within the zone: its center does and no edge of the zone crosses its
+// interior (edges along its sides are fine)
+func (z *zone) containsBbox(b geo.Bbox) bool {
+	if !z.contains(b.Center()) {
+		return false
+	}
+	for i, j := 0, len(z.vertices)-1; i < len(z.vertices); j, i = i, i+1 {
+		if segmentCrossesBbox(z.vertices[j], z.vertices[i], b) {
+			return false
+		}
+	}
+	return true
+}
+
+// segmentCrossesBbox reports whether the segment a-b passes through the open interior of the box (Liang-Barsky clipping)
+func segmentCrossesBbox(a, b [2]float64, box geo.Bbox) bool {
+	t0, t1 := 0.0, 1.0
+	dLat, dLng := b[0]-a[0], b[1]-a[1]
+	for _, edge := range [4][2]float64{
+		{-dLat, a[0] - box.MinLat},
+		{dLat, box.MaxLat - a[0]},
+		{-dLng, a[1] - box.MinLng},
+		{dLng, box.MaxLng - a[1]},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q <= 0 {
+				return false // parallel to the side and not inside it
+			}
+			continue
+		}
+		if t := q / p; p < 0 {
+			t0 = max(t0, t)
+		} else {
+			t1 = min(t1, t)
+		}
+	}
+	return t0 < t1
+}
+
+// loadZones reads the zone sets at startup from STORE_FILE, or ZONES_FILE (a missing file is an empty store)
+func loadZones() {
+	raw, source, seed := loadObjects[map[string][][2]float64](storeZones, ZONES_FILE, "ZONES_FILE")
+	zones.mu.Lock()
+	defer zones.mu.Unlock()
+	for name, r := range raw {
+		set, msg := newZoneSet(r)
+		if set == nil {
+			log.Printf("invalid zone set %q in %s, ignoring: %s", name, source, msg)
+			continue
+		}
+		zones.sets[name] = set
+	}
+	if raw != nil {
+		log.Printf("loaded %d zone sets from %s", len(zones.sets), source)
+	}
+	if seed {
+		if err := saveZonesLocked(); err != nil {
+			log.Fatalf("failed to save zone sets to STORE_FILE: %v", err)
+		}
+	}
+}
+
+// saveZonesLocked saves the zone sets to STORE_FILE, or rewrites ZONES_FILE (write + rename, so a crash leaves the
+// previous version). zones.mu must be held
+func saveZonesLocked() error {
+	if store == nil && ZONES_FILE == "" {
+		return nil
+	}
+	raw := make(map[string]map[string][][2]float64, len(zones.sets))
+	for name, set := range zones.sets {
+		raw[name] = set.raw()
+	}
+	if store != nil {
+		return storeReplace(storeZones, raw)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	tmp := ZONES_FILE + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ZONES_FILE)
+}
+
+func putZoneSet(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "set")
+	var raw map[string][][2]float64
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxZoneSetBody)).Decode(&raw); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request body"))
+		return
+	}
+	set, msg := newZoneSet(raw)
+	if set == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(msg))
+		return
+	}
+
+	zones.mu.Lock()
+	defer zones.mu.Unlock()
+	prev, existed := zones.sets[name]
+	zones.sets[name] = set
+	if err := saveZonesLocked(); err != nil {
+		if existed {
+			zones.sets[name] = prev
+		} else {
+			delete(zones.sets, name)
+		}
+		log.Printf("failed to save zones: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to save zone set"))
+		return
+	}
+	if existed {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func deleteZoneSet(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "set")
+	zones.mu.Lock()
+	defer zones.mu.Unlock()
+	prev, ok := zones.sets[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown zone set"))
+		return
+	}
+	delete(zones.sets, name)
+	if err := saveZonesLocked(); err != nil {
+		zones.sets[name] = prev
+		log.Printf("failed to save zones: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("Failed to save zone sets"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getZoneSets(w http.ResponseWriter, r *http.Request) {
+	zones.mu.RLock()
+	out := make(map[string]int, len(zones.sets)) // set -> zones
+	for name, set := range zones.sets {
+		out[name] = len(set.zones)
+	}
+	zones.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+}
+
+func getZoneSet(w http.ResponseWriter, r *http.Request) {
+	zones.mu.RLock()
+	set, ok := zones.sets[chi.URLParam(r, "set")]
+	zones.mu.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown zone set"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(set.raw())
+}
+
+func getPingAreaByZone(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("set")
+	zones.mu.RLock()
+	set, ok := zones.sets[name]
+	zones.mu.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Unknown zone set"))
+		return
+	}
+
+	// the area query covers the set's bounding box
+	params := url.Values{}
+	params.Set("minLat", strconv.FormatFloat(set.bbox.MinLat, 'f', -1, 64))
+	params.Set("maxLat", strconv.FormatFloat(set.bbox.MaxLat, 'f', -1, 64))
+	params.Set("minLng", strconv.FormatFloat(set.bbox.MinLng, 'f', -1, 64))
+	params.Set("maxLng", strconv.FormatFloat(set.bbox.MaxLng, 'f', -1, 64))
+	params.Set("precision", r.URL.Query().Get("precision"))
+	q, status, msg := parsePingAreaQuery(params)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		w.Write([]byte(msg))
+		return
+	}
+
+	t := tenantFor(r)
+	if !aclFor(t).allowsArea(q.bbox(), q.precision) {
+		denyACL(w, t)
+		return
+	}
+	if !admitUsage(w, r, unitCells, q.estimated) {
+		return
+	}
+
+	counts := make(map[string]int64, len(set.zones))
+	for _, z := range set.zones {
+		counts[z.name] = 0
+	}
+	unzoned := int64(0)
+	for gh, c := range privacyFor(t).suppress(t, queryPingArea(r.Context(), retentionFor(t).limit(q))) {
+		cell, ok := geo.Decode(gh)
+		if !ok {
+			continue
+		}
+		lat, lng := cell.Center()
+		zoned := false
+		for _, z := range set.zones {
+			if z.contains(lat, lng) {
+				counts[z.name] += c.Count
+				zoned = true
+			}
+		}
+		if !zoned {
+			unzoned += c.Count
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"set": name, "zones": counts, "unzoned": unzoned})
+}
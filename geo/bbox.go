@@ -0,0 +1,87 @@
+package geo
+
+import "math"
+
+// Bbox is a latitude/longitude box (also a geohash cell, see Decode)
+type Bbox struct {
+	MinLat, MaxLat float64
+	MinLng, MaxLng float64
+}
+
+// Valid reports whether every bound is a number within [-90, 90] / [-180, 180] and min <= max
+func (b Bbox) Valid() bool {
+	// NaN compares false against every bound
+	return b.MinLat >= -90 && b.MaxLat <= 90 && b.MinLat <= b.MaxLat &&
+		b.MinLng >= -180 && b.MaxLng <= 180 && b.MinLng <= b.MaxLng
+}
+
+// Normalized swaps inverted bounds and clamps them to the valid ranges. NaN bounds are left as they are (still invalid)
+func (b Bbox) Normalized() Bbox {
+	if b.MinLat > b.MaxLat {
+		b.MinLat, b.MaxLat = b.MaxLat, b.MinLat
+	}
+	if b.MinLng > b.MaxLng {
+		b.MinLng, b.MaxLng = b.MaxLng, b.MinLng
+	}
+	clamp := func(v, limit float64) float64 {
+		if math.IsNaN(v) {
+			return v
+		}
+		return min(max(v, -limit), limit)
+	}
+	return Bbox{MinLat: clamp(b.MinLat, 90), MaxLat: clamp(b.MaxLat, 90), MinLng: clamp(b.MinLng, 180), MaxLng: clamp(b.MaxLng, 180)}
+}
+
+// Intersects reports whether two boxes overlap. max bounds are exclusive, so boxes that only touch at an edge (such as
+// neighboring geohash cells) don't
+func (b Bbox) Intersects(o Bbox) bool {
+	return b.MinLat < o.MaxLat && b.MaxLat > o.MinLat && b.MinLng < o.MaxLng && b.MaxLng > o.MinLng
+}
+
+// Contains reports whether a point lies in the box (bounds included)
+func (b Bbox) Contains(lat, lng float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lng >= b.MinLng && lng <= b.MaxLng
+}
+
+// Center returns the center of the box
+func (b Bbox) Center() (lat, lng float64) {
+	return (b.MinLat + b.MaxLat) / 2, (b.MinLng + b.MaxLng) / 2
+}
+
+// LatForMaxWidth returns the latitude in [minLat, maxLat] closest to the equator, where a degree of longitude is widest
+func LatForMaxWidth(minLat, maxLat float64) float64 {
+	if (minLat <= 0 && maxLat >= 0) || (maxLat <= 0 && minLat >= 0) {
+		return 0
+	}
+	if math.Abs(minLat) < math.Abs(maxLat) {
+		return minLat
+	}
+	return maxLat
+}
+
+// LatForMinWidth returns the latitude in [minLat, maxLat] farthest from the equator, where a degree of longitude is
+// narrowest (which maximizes the number of longitudinal cells needed to cover a box)
+func LatForMinWidth(minLat, maxLat float64) float64 {
+	if math.Abs(minLat) > math.Abs(maxLat) {
+		return minLat
+	}
+	return maxLat
+}
+
+// DimsMeters returns the width (along the parallel closest to the equator) and height of the box. MinLng > MaxLng is
+// taken as a box crossing the antimeridian
+func (b Bbox) DimsMeters() (widthMeters, heightMeters float64) {
+	latForWidth := LatForMaxWidth(b.MinLat, b.MaxLat)
+
+	// width is computed as arc length along the latitude circle (matches CellDimsMeters). the great-circle distance is
+	// shorter except at the equator, which would make exact geohash cell boxes look too small
+	lngSpan := b.MaxLng - b.MinLng
+	if lngSpan < 0 {
+		lngSpan += 360
+	}
+	widthMeters = Deg2Rad(lngSpan) * EarthRadiusMeters * math.Cos(Deg2Rad(latForWidth))
+
+	midLng := (b.MinLng + b.MaxLng) / 2 // distance north/south doesn't depend on longitude, so midpoint is stable and simple
+	heightMeters = HaversineMeters(b.MinLat, midLng, b.MaxLat, midLng)
+	return widthMeters, heightMeters
+}
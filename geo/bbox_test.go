@@ -0,0 +1,131 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBboxValid(t *testing.T) {
+	nan := math.NaN()
+	for _, tc := range []struct {
+		b    Bbox
+		want bool
+	}{
+		{Bbox{-90, 90, -180, 180}, true},
+		{Bbox{1, 1, 2, 2}, true},
+		{Bbox{2, 1, 0, 1}, false},
+		{Bbox{0, 1, 2, 1}, false},
+		{Bbox{-91, 0, 0, 1}, false},
+		{Bbox{0, 90.1, 0, 1}, false},
+		{Bbox{0, 1, -180.1, 1}, false},
+		{Bbox{0, 1, 0, 181}, false},
+		{Bbox{nan, 1, 0, 1}, false},
+		{Bbox{0, nan, 0, 1}, false},
+		{Bbox{0, 1, nan, 1}, false},
+		{Bbox{0, 1, 0, nan}, false},
+		{Bbox{math.Inf(-1), 1, 0, 1}, false},
+	} {
+		if got := tc.b.Valid(); got != tc.want {
+			t.Errorf("%+v.Valid() = %v, want %v", tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestBboxNormalized(t *testing.T) {
+	for _, tc := range []struct{ in, want Bbox }{
+		{Bbox{1, 2, 3, 4}, Bbox{1, 2, 3, 4}},
+		{Bbox{2, 1, 4, 3}, Bbox{1, 2, 3, 4}},
+		{Bbox{-100, 100, -200, 200}, Bbox{-90, 90, -180, 180}},
+		{Bbox{math.Inf(1), math.Inf(-1), 0, 1}, Bbox{-90, 90, 0, 1}},
+	} {
+		got := tc.in.Normalized()
+		if got != tc.want || !got.Valid() {
+			t.Errorf("%+v.Normalized() = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+	if n := (Bbox{math.NaN(), 1, 0, 1}).Normalized(); n.Valid() {
+		t.Errorf("NaN bound normalized to a valid box %+v", n)
+	}
+}
+
+func TestBboxIntersects(t *testing.T) {
+	a := Bbox{0, 10, 0, 10}
+	for _, tc := range []struct {
+		b    Bbox
+		want bool
+	}{
+		{Bbox{5, 15, 5, 15}, true},
+		{Bbox{2, 3, 2, 3}, true},     // inside
+		{Bbox{-5, 15, -5, 15}, true}, // around
+		{Bbox{10, 20, 0, 10}, false}, // touches the north edge
+		{Bbox{0, 10, 10, 20}, false}, // touches the east edge
+		{Bbox{-10, 0, 0, 10}, false}, // touches the south edge
+		{Bbox{20, 30, 20, 30}, false},
+	} {
+		if got := a.Intersects(tc.b); got != tc.want {
+			t.Errorf("%+v.Intersects(%+v) = %v, want %v", a, tc.b, got, tc.want)
+		}
+		if got := tc.b.Intersects(a); got != tc.want {
+			t.Errorf("%+v.Intersects(%+v) = %v, want %v", tc.b, a, got, tc.want)
+		}
+	}
+
+	// neighboring geohash cells don't intersect
+	c1, _ := Decode("ezs42")
+	c2, _ := Decode("ezs43")
+	if c1.Intersects(c2) {
+		t.Error("neighboring cells intersect")
+	}
+}
+
+func TestBboxContainsAndCenter(t *testing.T) {
+	b := Bbox{0, 10, 20, 40}
+	if lat, lng := b.Center(); lat != 5 || lng != 30 {
+		t.Errorf("Center() = %v, %v", lat, lng)
+	}
+	for _, p := range [][2]float64{{0, 20}, {10, 40}, {5, 30}} {
+		if !b.Contains(p[0], p[1]) {
+			t.Errorf("Contains(%v) = false", p)
+		}
+	}
+	for _, p := range [][2]float64{{-0.1, 30}, {5, 40.1}, {math.NaN(), 30}} {
+		if b.Contains(p[0], p[1]) {
+			t.Errorf("Contains(%v) = true", p)
+		}
+	}
+}
+
+func TestLatForWidth(t *testing.T) {
+	for _, tc := range []struct{ minLat, maxLat, maxWidth, minWidth float64 }{
+		{10, 20, 10, 20},
+		{-20, -10, -10, -20},
+		{-10, 30, 0, 30},
+		{-30, 10, 0, -30},
+		{0, 0, 0, 0},
+	} {
+		if got := LatForMaxWidth(tc.minLat, tc.maxLat); got != tc.maxWidth {
+			t.Errorf("LatForMaxWidth(%v, %v) = %v, want %v", tc.minLat, tc.maxLat, got, tc.maxWidth)
+		}
+		if got := LatForMinWidth(tc.minLat, tc.maxLat); got != tc.minWidth {
+			t.Errorf("LatForMinWidth(%v, %v) = %v, want %v", tc.minLat, tc.maxLat, got, tc.minWidth)
+		}
+	}
+}
+
+func TestBboxDimsMeters(t *testing.T) {
+	// a geohash cell measures its cell dimensions (width at the latitude closest to the equator)
+	for _, gh := range []string{"s0", "ezs42", "u4pruyd", "00", "zz"} {
+		cell, _ := Decode(gh)
+		w, h := cell.DimsMeters()
+		cw, ch := CellDimsMeters(len(gh), LatForMaxWidth(cell.MinLat, cell.MaxLat))
+		if math.Abs(w-cw) > 1e-6*cw || math.Abs(h-ch) > 1e-6*ch {
+			t.Errorf("%s: DimsMeters() = %v, %v, want %v, %v", gh, w, h, cw, ch)
+		}
+	}
+
+	// crossing the antimeridian
+	w, _ := Bbox{0, 1, 179, -179}.DimsMeters()
+	if want := Deg2Rad(2) * EarthRadiusMeters; math.Abs(w-want) > 1e-6 {
+		t.Errorf("antimeridian width = %v, want %v", w, want)
+	}
+}
@@ -0,0 +1,143 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// CoverCount estimates how many geohash cells at the given precision cover the box (and how many wide and high). the
+// estimate is made in degrees (geohash grid space): meter-based estimates explode near the poles (cos(lat) -> 0). MinLng
+// > MaxLng is taken as a box crossing the antimeridian
+func (b Bbox) CoverCount(precision int) (count int64, cellsWide int64, cellsHigh int64) {
+	if precision <= 0 {
+		return 0, 0, 0
+	}
+	lngStepDeg, latStepDeg := CellDimsDegrees(precision)
+	if lngStepDeg <= 0 || latStepDeg <= 0 {
+		return 0, 0, 0
+	}
+
+	lngSpan := b.MaxLng - b.MinLng
+	if lngSpan < 0 {
+		lngSpan += 360
+	}
+	latSpan := math.Abs(b.MaxLat - b.MinLat)
+
+	w := max(int64(math.Ceil(lngSpan/lngStepDeg)), 1)
+	h := max(int64(math.Ceil(latSpan/latStepDeg)), 1)
+	return w * h, w, h
+}
+
+// AggregatedPrecision returns the precision (and its cell size) to compute the cover set of the box at for a query at
+// the requested precision: the coarsest one within requested-2..requested whose cells still fit in the box, otherwise
+// the first finer one up to maxPrecision that does. ok is false if no cell up to maxPrecision fits
+func (b Bbox) AggregatedPrecision(requested, maxPrecision int) (precisionUsed int, cellWidthMeters, cellHeightMeters float64, ok bool) {
+	bboxWidth, bboxHeight := b.DimsMeters()
+	latWidth := LatForMaxWidth(b.MinLat, b.MaxLat)
+
+	// prefer coarser precision (bigger cells) but only within requested-2..requested, if possible
+	start := max(requested-2, 1) // TODO: unbounded or keep a hardcoded bound? need to review amount of leaf nodes searched at worker node if unbounded
+	for p := start; p <= requested; p++ {
+		wm, hm := CellDimsMeters(p, latWidth)
+		if wm <= bboxWidth && hm <= bboxHeight {
+			return p, wm, hm, true
+		}
+	}
+
+	// if bbox is smaller than requested cell, fall back to finer precisions until it fits
+	for p := requested + 1; p <= maxPrecision; p++ {
+		wm, hm := CellDimsMeters(p, latWidth)
+		if wm <= bboxWidth && hm <= bboxHeight {
+			return p, wm, hm, true
+		}
+	}
+
+	return 0, 0, 0, false
+}
+
+// Cover returns the sorted geohashes at the given precision whose cells intersect the box
+func (b Bbox) Cover(precision int) []string {
+	// seed from the bbox center, then flood-fill neighbors whose cell bbox intersects query
+	seedLat, seedLng := b.Center()
+	seed := Encode(seedLat, seedLng, precision)
+	if seed == "" {
+		return nil
+	}
+
+	lngStepDeg, latStepDeg := CellDimsDegrees(precision)
+	if lngStepDeg <= 0 || latStepDeg <= 0 {
+		return nil
+	}
+
+	// BFS to find all geohashes that intersect with the query bbox
+	// pre-size maps with estimated capacity to reduce rehashing costs
+	estCount, _, _ := b.CoverCount(precision)
+	initCap := min(int(estCount)+16, 4096) // + buffer, capped to avoid over-allocation for huge queries
+	visited := make(map[string]struct{}, initCap)
+	inSet := make(map[string]struct{}, initCap)
+	queue := make([]string, 1, initCap)
+
+	queue[0] = seed
+	qHead := 0 // index-based dequeue avoids slice[1:] garbage, tracks the front of the queue
+
+	for qHead < len(queue) {
+		gh := queue[qHead]
+		qHead++
+
+		if _, ok := visited[gh]; ok {
+			continue
+		}
+		visited[gh] = struct{}{}
+
+		cell, ok := Decode(gh)
+		if !ok || !cell.Intersects(b) {
+			continue
+		}
+
+		inSet[gh] = struct{}{}
+
+		// enqueue 8 neighbors by shifting the cell center by 1 cell in each direction
+		cLat, cLng := cell.Center()
+		for _, dLat := range []float64{-1, 0, 1} {
+			for _, dLng := range []float64{-1, 0, 1} {
+				if dLat == 0 && dLng == 0 {
+					continue
+				}
+				nLat := cLat + dLat*latStepDeg
+				nLng := cLng + dLng*lngStepDeg
+				if nLat < -90 || nLat > 90 || nLng < -180 || nLng > 180 {
+					continue
+				}
+				ngh := Encode(nLat, nLng, precision)
+				if ngh == "" {
+					continue
+				}
+				if _, ok := visited[ngh]; ok {
+					continue
+				}
+				queue = append(queue, ngh)
+			}
+		}
+	}
+
+	out := make([]string, 0, len(inSet))
+	for gh := range inSet {
+		out = append(out, gh)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Snapped returns the box grown to the geohash grid at the given precision: the union of the cells Cover returns
+func (b Bbox) Snapped(precision int) Bbox {
+	lngStepDeg, latStepDeg := CellDimsDegrees(precision)
+	snap := func(v, origin, step float64, round func(float64) float64) float64 {
+		return origin + round((v-origin)/step)*step
+	}
+	return Bbox{
+		MinLat: snap(b.MinLat, -90, latStepDeg, math.Floor),
+		MaxLat: snap(b.MaxLat, -90, latStepDeg, math.Ceil),
+		MinLng: snap(b.MinLng, -180, lngStepDeg, math.Floor),
+		MaxLng: snap(b.MaxLng, -180, lngStepDeg, math.Ceil),
+	}
+}
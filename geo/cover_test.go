@@ -0,0 +1,119 @@
+package geo
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestCoverCount(t *testing.T) {
+	for _, tc := range []struct {
+		b         Bbox
+		precision int
+		count     int64
+		wide      int64
+		high      int64
+	}{
+		{Bbox{-90, 90, -180, 180}, 1, 32, 8, 4},
+		{Bbox{-90, 90, -180, 180}, 2, 1024, 32, 32},
+		{Bbox{1, 1, 1, 1}, 5, 1, 1, 1}, // a point is one cell
+		{Bbox{0, 45, 0, 45}, 1, 1, 1, 1},
+		{Bbox{0, 45, 0, 46}, 1, 2, 2, 1},
+		{Bbox{0, 1, 179, -179}, 3, 2, 2, 1}, // crossing the antimeridian
+		{Bbox{0, 1, 0, 1}, 0, 0, 0, 0},
+	} {
+		count, wide, high := tc.b.CoverCount(tc.precision)
+		if count != tc.count || wide != tc.wide || high != tc.high {
+			t.Errorf("%+v.CoverCount(%d) = %d, %d, %d, want %d, %d, %d", tc.b, tc.precision, count, wide, high, tc.count, tc.wide, tc.high)
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		precision := 1 + rng.Intn(6)
+		lngDeg, latDeg := CellDimsDegrees(precision)
+		lat, lng := rng.Float64()*170-85, rng.Float64()*350-175
+		b := Bbox{MinLat: lat, MaxLat: min(90, lat+rng.Float64()*6*latDeg), MinLng: lng, MaxLng: min(180, lng+rng.Float64()*6*lngDeg)}
+
+		cover := b.Cover(precision)
+		if !sort.StringsAreSorted(cover) {
+			t.Fatalf("%+v: cover not sorted", b)
+		}
+		in := make(map[string]bool, len(cover))
+		for _, gh := range cover {
+			if in[gh] {
+				t.Fatalf("%+v: %q covered twice", b, gh)
+			}
+			in[gh] = true
+			cell, ok := Decode(gh)
+			if !ok || len(gh) != precision || !cell.Intersects(b) {
+				t.Fatalf("%+v: covered cell %q doesn't intersect", b, gh)
+			}
+		}
+
+		// every point strictly inside the box is in a covered cell
+		for j := 0; j < 200; j++ {
+			pLat := b.MinLat + (b.MaxLat-b.MinLat)*(0.001+0.998*rng.Float64())
+			pLng := b.MinLng + (b.MaxLng-b.MinLng)*(0.001+0.998*rng.Float64())
+			if gh := Encode(pLat, pLng, precision); !in[gh] && b.MaxLat > b.MinLat && b.MaxLng > b.MinLng {
+				t.Fatalf("%+v: point %v,%v in %q, not covered", b, pLat, pLng, gh)
+			}
+		}
+
+		if count, _, _ := b.CoverCount(precision); int64(len(cover)) > (count+1)*4 {
+			t.Fatalf("%+v: %d cells covered, estimated %d", b, len(cover), count)
+		}
+
+		// the snapped box is the union of the covered cells
+		snapped := b.Snapped(precision)
+		union := Bbox{MinLat: 90, MaxLat: -90, MinLng: 180, MaxLng: -180}
+		for _, gh := range cover {
+			cell, _ := Decode(gh)
+			union = Bbox{min(union.MinLat, cell.MinLat), max(union.MaxLat, cell.MaxLat), min(union.MinLng, cell.MinLng), max(union.MaxLng, cell.MaxLng)}
+		}
+		if snapped != union {
+			t.Fatalf("%+v.Snapped(%d) = %+v, cells span %+v", b, precision, snapped, union)
+		}
+	}
+
+	if cover := (Bbox{0, 1, 0, 1}).Cover(0); cover != nil {
+		t.Errorf("Cover(0) = %v", cover)
+	}
+	if cover := (Bbox{0, 45, 0, 45}).Cover(1); len(cover) != 1 || cover[0] != "s" {
+		t.Errorf("cover of cell s = %v", cover)
+	}
+}
+
+func TestAggregatedPrecision(t *testing.T) {
+	// the coarsest precision (within requested-2) whose cell fits in the box
+	b := Bbox{42, 42.2, -6, -5.8}
+	for requested := 1; requested <= 8; requested++ {
+		p, w, h, ok := b.AggregatedPrecision(requested, 8)
+		if !ok {
+			t.Fatalf("requested %d: no precision", requested)
+		}
+		bw, bh := b.DimsMeters()
+		if w > bw || h > bh {
+			t.Fatalf("requested %d: cell %vx%v larger than the box %vx%v", requested, w, h, bw, bh)
+		}
+		if p < max(requested-2, 1) {
+			t.Fatalf("requested %d: precision %d below requested-2", requested, p)
+		}
+		if p > requested && p > 1 {
+			if cw, ch := CellDimsMeters(p-1, LatForMaxWidth(b.MinLat, b.MaxLat)); cw <= bw && ch <= bh {
+				t.Fatalf("requested %d: precision %d while %d fits", requested, p, p-1)
+			}
+		}
+	}
+
+	// smaller than any cell up to maxPrecision
+	if _, _, _, ok := (Bbox{42, 42.00001, -6, -5.99999}).AggregatedPrecision(5, 8); ok {
+		t.Error("tiny box got a precision")
+	}
+	// a point
+	if _, _, _, ok := (Bbox{42, 42, -6, -6}).AggregatedPrecision(5, 8); ok {
+		t.Error("point got a precision")
+	}
+}
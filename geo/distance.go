@@ -0,0 +1,23 @@
+package geo
+
+import "math"
+
+// EarthRadiusMeters is the mean Earth radius (IUGG)
+const EarthRadiusMeters = 6371008.8
+
+// Deg2Rad converts degrees to radians
+func Deg2Rad(deg float64) float64 { return deg * math.Pi / 180 }
+
+// HaversineMeters returns the great-circle distance between two points
+func HaversineMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	lat1r := Deg2Rad(lat1)
+	lat2r := Deg2Rad(lat2)
+	dlat := Deg2Rad(lat2 - lat1)
+	dlng := Deg2Rad(lng2 - lng1)
+
+	sinDLat := math.Sin(dlat / 2)
+	sinDLng := math.Sin(dlng / 2)
+	a := sinDLat*sinDLat + math.Cos(lat1r)*math.Cos(lat2r)*sinDLng*sinDLng
+	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return EarthRadiusMeters * c
+}
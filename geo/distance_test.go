@@ -0,0 +1,35 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHaversineMeters(t *testing.T) {
+	for _, tc := range []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want, tolerance        float64
+	}{
+		{"same point", 42.6, -5.57, 42.6, -5.57, 0, 0},
+		{"equator to pole", 0, 0, 90, 0, math.Pi / 2 * EarthRadiusMeters, 1e-6},
+		{"antipodes", 0, 0, 0, 180, math.Pi * EarthRadiusMeters, 1e-6},
+		{"one degree of longitude at the equator", 0, 0, 0, 1, Deg2Rad(1) * EarthRadiusMeters, 1e-6},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, Deg2Rad(1) * EarthRadiusMeters, 1e-6},
+		{"paris to london", 48.8566, 2.3522, 51.5074, -0.1278, 343_500, 1_000},
+	} {
+		got := HaversineMeters(tc.lat1, tc.lng1, tc.lat2, tc.lng2)
+		if math.Abs(got-tc.want) > tc.tolerance {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+		if back := HaversineMeters(tc.lat2, tc.lng2, tc.lat1, tc.lng1); math.Abs(back-got) > 1e-6 {
+			t.Errorf("%s: not symmetric (%v, %v)", tc.name, got, back)
+		}
+	}
+}
+
+func TestDeg2Rad(t *testing.T) {
+	if Deg2Rad(180) != math.Pi || Deg2Rad(-90) != -math.Pi/2 || Deg2Rad(0) != 0 {
+		t.Error("Deg2Rad")
+	}
+}
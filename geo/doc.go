@@ -0,0 +1,6 @@
+// Package geo holds the geohash and bounding box math shared by the gateway and the workers: geohash encoding and cell
+// decoding, cell sizes, cover sets of a bounding box (and their size estimate) and great-circle distances.
+//
+// Latitudes and longitudes are in degrees (WGS 84), distances in meters. A Bbox never wraps around the antimeridian:
+// MinLng > MaxLng is treated as a box crossing it only by the estimates (CoverCount, DimsMeters), see their docs.
+package geo
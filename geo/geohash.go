@@ -0,0 +1,117 @@
+package geo
+
+import (
+	"math"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+// Base32 is the geohash alphabet: the index of a character is its 5 bit value
+const Base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// pre-computed lookup table for geohash base32 decoding (avoids allocation per call)
+var base32Index [256]byte
+
+func init() {
+	for i := range base32Index {
+		base32Index[i] = 0xFF
+	}
+	for i := 0; i < len(Base32); i++ {
+		base32Index[Base32[i]] = byte(i)
+		if c := Base32[i]; c >= 'a' && c <= 'z' {
+			base32Index[c-('a'-'A')] = byte(i) // uppercase is accepted too
+		}
+	}
+}
+
+// CharIndex returns the 5 bit value of a geohash character (either case), or -1 if it isn't one
+func CharIndex(c byte) int {
+	if v := base32Index[c]; v != 0xFF {
+		return int(v)
+	}
+	return -1
+}
+
+// Valid reports whether gh is a non-empty geohash (either case)
+func Valid(gh string) bool {
+	if gh == "" {
+		return false
+	}
+	for i := 0; i < len(gh); i++ {
+		if base32Index[gh[i]] == 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode returns the geohash of a point at the given precision (characters), "" if precision < 1
+func Encode(lat, lng float64, precision int) string {
+	if precision <= 0 {
+		return ""
+	}
+	return geohash.EncodeWithPrecision(lat, lng, uint(precision))
+}
+
+// Decode returns the cell of a geohash (either case). ok is false for an empty or invalid geohash
+func Decode(gh string) (cell Bbox, ok bool) {
+	if gh == "" {
+		return Bbox{}, false
+	}
+
+	minLat, maxLat := -90.0, 90.0
+	minLng, maxLng := -180.0, 180.0
+	isLng := true // geohash bits start with longitude
+
+	for i := 0; i < len(gh); i++ {
+		v := base32Index[gh[i]] // base32 char -> [0, 31]
+		if v == 0xFF {
+			return Bbox{}, false
+		}
+		for bit := 4; bit >= 0; bit-- { // a geohash character is 5 bits (base 32). check high -> low and halve the range interleaving lon/lat
+			mask := byte(1 << uint(bit))
+			if isLng {
+				// halve lng range
+				mid := (minLng + maxLng) / 2
+				if v&mask != 0 {
+					minLng = mid // keep upper half
+				} else {
+					maxLng = mid // keep lower half
+				}
+			} else {
+				// halve lat range
+				mid := (minLat + maxLat) / 2
+				if v&mask != 0 {
+					minLat = mid // keep upper half
+				} else {
+					maxLat = mid // keep lower half
+				}
+			}
+			isLng = !isLng // alternate lon/lat
+		}
+	}
+
+	// the remaining values are the geohash cell box
+	return Bbox{MinLat: minLat, MaxLat: maxLat, MinLng: minLng, MaxLng: maxLng}, true
+}
+
+// CellDimsDegrees returns the width and height of a geohash cell in degrees at a given precision
+func CellDimsDegrees(precision int) (lngDeg, latDeg float64) {
+	// each geohash character is base32 => 5 bits. bits alternate lon/lat starting with lon
+	bits := precision * 5
+	lngBits := (bits + 1) / 2 // starts at lon, so lon gets the extra bit
+	latBits := bits / 2
+
+	lngDeg = 360.0 / float64(uint64(1)<<uint(lngBits)) // 360º / 2^(lngBits) -> degrees per longitude cell
+	latDeg = 180.0 / float64(uint64(1)<<uint(latBits)) // 180º / 2^(latBits) -> degrees per latitude cell
+	return lngDeg, latDeg
+}
+
+// CellDimsMeters returns the width and height of a geohash cell in meters at a given precision, the width measured
+// along the parallel at latForWidth
+func CellDimsMeters(precision int, latForWidth float64) (widthMeters, heightMeters float64) {
+	lngDeg, latDeg := CellDimsDegrees(precision)
+	heightMeters = Deg2Rad(latDeg) * EarthRadiusMeters
+	widthMeters = Deg2Rad(lngDeg) * EarthRadiusMeters * math.Cos(Deg2Rad(latForWidth))
+	return widthMeters, heightMeters
+}
@@ -0,0 +1,146 @@
+package geo
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestEncodeKnown(t *testing.T) {
+	for _, tc := range []struct {
+		lat, lng  float64
+		precision int
+		want      string
+	}{
+		{57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{42.605, -5.603, 5, "ezs42"},
+		{0, 0, 1, "s"},
+		{-90, -180, 3, "000"},
+		{89.99, 179.99, 3, "zzz"},
+		{1, 1, 0, ""},
+		{1, 1, -1, ""},
+	} {
+		if got := Encode(tc.lat, tc.lng, tc.precision); got != tc.want {
+			t.Errorf("Encode(%v, %v, %d) = %q, want %q", tc.lat, tc.lng, tc.precision, got, tc.want)
+		}
+	}
+}
+
+func TestDecodeContainsEncodedPoint(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		lat, lng := rng.Float64()*180-90, rng.Float64()*360-180
+		for precision := 1; precision <= 12; precision++ {
+			gh := Encode(lat, lng, precision)
+			cell, ok := Decode(gh)
+			if !ok {
+				t.Fatalf("Decode(%q) failed", gh)
+			}
+			if !cell.Contains(lat, lng) {
+				t.Fatalf("cell %+v of %q doesn't contain %v,%v", cell, gh, lat, lng)
+			}
+			lngDeg, latDeg := CellDimsDegrees(precision)
+			if math.Abs(cell.MaxLng-cell.MinLng-lngDeg) > 1e-9 || math.Abs(cell.MaxLat-cell.MinLat-latDeg) > 1e-9 {
+				t.Fatalf("cell %+v of %q isn't %vx%v degrees", cell, gh, lngDeg, latDeg)
+			}
+			if centerLat, centerLng := cell.Center(); Encode(centerLat, centerLng, precision) != gh {
+				t.Fatalf("center of %q re-encodes to %q", gh, Encode(centerLat, centerLng, precision))
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cell, ok := Decode("ezs42")
+	want := Bbox{MinLat: 42.5830078125, MaxLat: 42.626953125, MinLng: -5.625, MaxLng: -5.5810546875}
+	if !ok || cell != want {
+		t.Errorf("Decode(ezs42) = %+v, %v, want %+v", cell, ok, want)
+	}
+	if upper, ok := Decode("EZS42"); !ok || upper != cell {
+		t.Errorf("Decode(EZS42) = %+v, %v, want %+v", upper, ok, cell)
+	}
+	if whole, ok := Decode("0"); !ok || whole != (Bbox{MinLat: -90, MaxLat: -45, MinLng: -180, MaxLng: -135}) {
+		t.Errorf("Decode(0) = %+v, %v", whole, ok)
+	}
+	for _, invalid := range []string{"", "a", "ezs4i", "ezl", "o", "ez s", "ezs42!", "ü"} {
+		if _, ok := Decode(invalid); ok {
+			t.Errorf("Decode(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestCharIndexAndValid(t *testing.T) {
+	for i := 0; i < len(Base32); i++ {
+		c := Base32[i]
+		if CharIndex(c) != i {
+			t.Errorf("CharIndex(%q) = %d, want %d", c, CharIndex(c), i)
+		}
+		if upper := strings.ToUpper(string(c))[0]; CharIndex(upper) != i {
+			t.Errorf("CharIndex(%q) = %d, want %d", upper, CharIndex(upper), i)
+		}
+	}
+	for _, c := range []byte{'a', 'i', 'l', 'o', 'A', 'I', 'L', 'O', ' ', 0, 0xff} {
+		if CharIndex(c) != -1 {
+			t.Errorf("CharIndex(%q) = %d, want -1", c, CharIndex(c))
+		}
+	}
+	for gh, want := range map[string]bool{"ezs42": true, "EZS42": true, "": false, "ezs4a": false, "0123456789bcdefghjkmnpqrstuvwxyz": true} {
+		if Valid(gh) != want {
+			t.Errorf("Valid(%q) = %v, want %v", gh, !want, want)
+		}
+	}
+}
+
+func TestCellDims(t *testing.T) {
+	for _, tc := range []struct {
+		precision      int
+		lngDeg, latDeg float64
+	}{
+		{1, 45, 45},
+		{2, 11.25, 5.625},
+		{3, 1.40625, 1.40625},
+		{8, 360.0 / (1 << 20), 180.0 / (1 << 20)},
+	} {
+		lngDeg, latDeg := CellDimsDegrees(tc.precision)
+		if lngDeg != tc.lngDeg || latDeg != tc.latDeg {
+			t.Errorf("CellDimsDegrees(%d) = %v, %v, want %v, %v", tc.precision, lngDeg, latDeg, tc.lngDeg, tc.latDeg)
+		}
+	}
+
+	// a degree is ~111.2 km; cells narrow with latitude
+	w, h := CellDimsMeters(1, 0)
+	if math.Abs(w-45*111195) > 100 || math.Abs(h-45*111195) > 100 {
+		t.Errorf("CellDimsMeters(1, 0) = %v, %v", w, h)
+	}
+	w60, h60 := CellDimsMeters(1, 60)
+	if math.Abs(w60-w/2) > 1 || h60 != h {
+		t.Errorf("CellDimsMeters(1, 60) = %v, %v, want %v, %v", w60, h60, w/2, h)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{"ezjm", "EZJM", "0", "zzzzzzzzzzzz", "a", "ezjm!", "", "s00000000000000000000000000"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, gh string) {
+		b, ok := Decode(gh)
+		if ok != Valid(gh) {
+			t.Fatalf("%q: Decode ok = %v, Valid = %v", gh, ok, Valid(gh))
+		}
+		if !ok {
+			return
+		}
+		if !b.Valid() {
+			t.Fatalf("%q: invalid bbox %+v", gh, b)
+		}
+		// re-encoding the cell center gives back the (lowercased) geohash
+		if len(gh) <= 12 {
+			lat, lng := b.Center()
+			if center := Encode(lat, lng, len(gh)); center != strings.ToLower(gh) {
+				t.Fatalf("%q: center re-encodes to %q", gh, center)
+			}
+		}
+	})
+}
@@ -0,0 +1,5 @@
+module geostreamdb/geo
+
+go 1.25.4
+
+require github.com/mmcloughlin/geohash v0.10.0
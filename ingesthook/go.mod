@@ -0,0 +1,3 @@
+module geostreamdb/ingesthook
+
+go 1.25.4
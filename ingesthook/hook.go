@@ -0,0 +1,104 @@
+// Package ingesthook is the interface of the gateway's ingest hooks: filters and transforms run on every incoming ping
+// before it is checked and routed, to drop it, reject it or change it (custom validation, enrichment) without forking
+// the gateway.
+//
+// A hook is registered under a name by the init function of the package defining it, either compiled into the gateway
+// (a file or a blank import added to its main package) or built as a Go plugin (go build -buildmode=plugin) listed in
+// the gateway's INGEST_PLUGINS. The gateway's INGEST_HOOKS_FILE then chains registered hooks per tenant, each with its
+// own configuration:
+//
+//	func init() {
+//		ingesthook.Register("require-device", func(config json.RawMessage) (ingesthook.Hook, error) {
+//			return ingesthook.HookFunc(func(p *ingesthook.Ping) error {
+//				if p.DeviceID == "" {
+//					return errors.New("a deviceId is required")
+//				}
+//				return nil
+//			}), nil
+//		})
+//	}
+//
+// A plugin must be built with the toolchain and the versions of this module the gateway was built with.
+package ingesthook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+)
+
+// Ping is an incoming ping as seen by the hooks. Tenant, Protocol and Client are informative; changes to the other
+// fields are kept (and validated again) once every hook of the chain has run. Seq, SentAt, Speed, Heading, Floor and
+// Accuracy are only carried over HTTP: they are always empty on the other protocols, and ignored there if a hook sets them.
+type Ping struct {
+	Tenant   string     // name of the tenant sending the ping ("anonymous" without a known API key)
+	Protocol string     // "http", "udp", "coap" or "resp"
+	Client   netip.Addr // address of the client, invalid if unknown
+	Lat, Lng float64    // degrees
+	DeviceID string     // empty if anonymous
+	Seq      uint64     // per-device sequence number, 0 if absent
+	SentAt   int64      // client send time (unix ms), 0 if absent
+	Speed    *float64   // meters per second
+	Heading  *float64   // degrees clockwise from north, with a speed
+	Floor    *int32     // vertical bucket
+	Accuracy *float64   // radius of the fix, meters
+}
+
+// ErrDrop, returned by a hook (possibly wrapped), drops the ping: the client is answered as if it was stored, and the
+// rest of the chain doesn't run.
+var ErrDrop = errors.New("ping dropped")
+
+// Hook filters or transforms pings. Apply may change p; an error other than ErrDrop rejects the ping, its message
+// answered to the client. Apply is called concurrently and on the ingest path: it should be fast.
+type Hook interface {
+	Apply(p *Ping) error
+}
+
+// HookFunc is a function used as a Hook.
+type HookFunc func(p *Ping) error
+
+func (f HookFunc) Apply(p *Ping) error {
+	return f(p)
+}
+
+// Factory builds a hook from its configuration in INGEST_HOOKS_FILE (null if it has none).
+type Factory func(config json.RawMessage) (Hook, error)
+
+var registry = struct {
+	sync.Mutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+// Register makes a hook available under name. It is meant to be called from an init function, and panics if the name
+// is already taken.
+func Register(name string, f Factory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.factories[name]; ok {
+		panic(fmt.Sprintf("ingesthook: hook %q registered twice", name))
+	}
+	registry.factories[name] = f
+}
+
+// Lookup returns the factory registered under name.
+func Lookup(name string) (Factory, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	f, ok := registry.factories[name]
+	return f, ok
+}
+
+// Names returns the names of the registered hooks, sorted.
+func Names() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,36 @@
+package ingesthook
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func init() {
+	Register("test-noop", func(json.RawMessage) (Hook, error) {
+		return HookFunc(func(p *Ping) error { return nil }), nil
+	})
+}
+
+func TestRegisterAndLookup(t *testing.T) {
+	f, ok := Lookup("test-noop")
+	if !ok {
+		t.Fatal("registered hook not found")
+	}
+	if h, err := f(nil); err != nil || h.Apply(&Ping{}) != nil {
+		t.Errorf("got %v, %v", h, err)
+	}
+	if _, ok := Lookup("test-missing"); ok {
+		t.Error("found a hook never registered")
+	}
+	if !slices.Contains(Names(), "test-noop") {
+		t.Errorf("Names: %v", Names())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("second registration under the same name accepted")
+		}
+	}()
+	Register("test-noop", nil)
+}
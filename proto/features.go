@@ -0,0 +1,16 @@
+package proto
+
+// worker features: heartbeats list the optional capabilities a worker's build and configuration support, so gateways
+// route the requests needing one to the workers that have it (a replica that does, or a clear error) instead of
+// failing RPCs against the others during a mixed-version rollout or a partial reconfiguration. a worker sending no
+// list predates them and is assumed to support everything, as it did before.
+//
+// add a feature here with any new capability a worker may lack, and list it from the worker builds that support it
+const (
+	FeatureFrames    = "frames"    // per-second area counts: frames and smoothing (storage engine)
+	FeatureHistory   = "history"   // history tier: history offsets, compare, frames beyond the live window (HISTORY_RETENTION)
+	FeatureMovement  = "movement"  // speed and heading of pings (storage engine)
+	FeatureFloors    = "floors"    // floors of pings (storage engine)
+	FeatureRaw       = "raw"       // raw pings: polygon counts, device pings (RAW_RETENTION)
+	FeatureAnomalies = "anomalies" // anomaly detection (ANOMALY_INTERVAL)
+)
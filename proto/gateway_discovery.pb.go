@@ -22,11 +22,17 @@ const (
 )
 
 type RegistryHeartbeatRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	GatewayId     string                 `protobuf:"bytes,1,opt,name=gateway_id,json=gatewayId,proto3" json:"gateway_id,omitempty"`
-	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	GatewayId       string                 `protobuf:"bytes,1,opt,name=gateway_id,json=gatewayId,proto3" json:"gateway_id,omitempty"`
+	Address         string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	ApiVersion      uint32                 `protobuf:"varint,3,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`                // newest protocol version the gateway speaks (0 = sent before versioning, see version.go)
+	MinApiVersion   uint32                 `protobuf:"varint,4,opt,name=min_api_version,json=minApiVersion,proto3" json:"min_api_version,omitempty"`     // oldest protocol version the gateway still understands
+	BuildVersion    string                 `protobuf:"bytes,5,opt,name=build_version,json=buildVersion,proto3" json:"build_version,omitempty"`           // release of the gateway build (set at link time, "dev" otherwise)
+	SettingsVersion uint64                 `protobuf:"varint,6,opt,name=settings_version,json=settingsVersion,proto3" json:"settings_version,omitempty"` // version of the cluster settings the gateway runs with (0 = none from the registry)
+	Seq             uint64                 `protobuf:"varint,7,opt,name=seq,proto3" json:"seq,omitempty"`                                                // heartbeat sequence number and its epoch, as in worker heartbeats
+	SeqEpoch        int64                  `protobuf:"varint,8,opt,name=seq_epoch,json=seqEpoch,proto3" json:"seq_epoch,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *RegistryHeartbeatRequest) Reset() {
@@ -73,9 +79,54 @@ func (x *RegistryHeartbeatRequest) GetAddress() string {
 	return ""
 }
 
+func (x *RegistryHeartbeatRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *RegistryHeartbeatRequest) GetMinApiVersion() uint32 {
+	if x != nil {
+		return x.MinApiVersion
+	}
+	return 0
+}
+
+func (x *RegistryHeartbeatRequest) GetBuildVersion() string {
+	if x != nil {
+		return x.BuildVersion
+	}
+	return ""
+}
+
+func (x *RegistryHeartbeatRequest) GetSettingsVersion() uint64 {
+	if x != nil {
+		return x.SettingsVersion
+	}
+	return 0
+}
+
+func (x *RegistryHeartbeatRequest) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *RegistryHeartbeatRequest) GetSeqEpoch() int64 {
+	if x != nil {
+		return x.SeqEpoch
+	}
+	return 0
+}
+
 type RegistryHeartbeatResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Acknowledged  bool                   `protobuf:"varint,1,opt,name=acknowledged,proto3" json:"acknowledged,omitempty"`
+	ApiVersion    uint32                 `protobuf:"varint,2,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"` // range supported by the registry
+	MinApiVersion uint32                 `protobuf:"varint,3,opt,name=min_api_version,json=minApiVersion,proto3" json:"min_api_version,omitempty"`
+	Settings      *ClusterSettings       `protobuf:"bytes,4,opt,name=settings,proto3" json:"settings,omitempty"` // current cluster settings (unset if the registry distributes none)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -117,19 +168,869 @@ func (x *RegistryHeartbeatResponse) GetAcknowledged() bool {
 	return false
 }
 
-var File_proto_gateway_discovery_proto protoreflect.FileDescriptor
+func (x *RegistryHeartbeatResponse) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
 
-const file_proto_gateway_discovery_proto_rawDesc = "" +
-	"\n" +
-	"\x1dproto/gateway_discovery.proto\x12\vgeostreamdb\"S\n" +
-	"\x18RegistryHeartbeatRequest\x12\x1d\n" +
-	"\n" +
-	"gateway_id\x18\x01 \x01(\tR\tgatewayId\x12\x18\n" +
-	"\aaddress\x18\x02 \x01(\tR\aaddress\"?\n" +
-	"\x19RegistryHeartbeatResponse\x12\"\n" +
-	"\facknowledged\x18\x01 \x01(\bR\facknowledged2h\n" +
+func (x *RegistryHeartbeatResponse) GetMinApiVersion() uint32 {
+	if x != nil {
+		return x.MinApiVersion
+	}
+	return 0
+}
+
+func (x *RegistryHeartbeatResponse) GetSettings() *ClusterSettings {
+	if x != nil {
+		return x.Settings
+	}
+	return nil
+}
+
+type GetClusterSettingsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetClusterSettingsRequest) Reset() {
+	*x = GetClusterSettingsRequest{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetClusterSettingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetClusterSettingsRequest) ProtoMessage() {}
+
+func (x *GetClusterSettingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetClusterSettingsRequest.ProtoReflect.Descriptor instead.
+func (*GetClusterSettingsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{2}
+}
+
+// settings the registry distributes instead of every binary's env vars (0 = not distributed: each node's own applies)
+type ClusterSettings struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Version           uint64                 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`                                              // hash of the settings below, changes whenever one of them does
+	ShardingPrecision uint32                 `protobuf:"varint,2,opt,name=sharding_precision,json=shardingPrecision,proto3" json:"sharding_precision,omitempty"` // gateways: geohash precision keys are sharded at (2 to 7)
+	ReplicationFactor uint32                 `protobuf:"varint,3,opt,name=replication_factor,json=replicationFactor,proto3" json:"replication_factor,omitempty"` // gateways: workers holding each shard
+	PingTtl           int64                  `protobuf:"varint,4,opt,name=ping_ttl,json=pingTtl,proto3" json:"ping_ttl,omitempty"`                               // workers: TTL window in seconds
+	ShardingMigration *ShardingMigration     `protobuf:"bytes,5,opt,name=sharding_migration,json=shardingMigration,proto3" json:"sharding_migration,omitempty"`  // last sharding precision change (not part of the version)
+	RingHash          string                 `protobuf:"bytes,6,opt,name=ring_hash,json=ringHash,proto3" json:"ring_hash,omitempty"`                             // gateways: hash function placing keys on the ring (xxh3, xxhash or fnv, "" = xxh3)
+	RingSeed          uint64                 `protobuf:"varint,7,opt,name=ring_seed,json=ringSeed,proto3" json:"ring_seed,omitempty"`                            // gateways: seed of ring_hash (0 = unseeded)
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ClusterSettings) Reset() {
+	*x = ClusterSettings{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClusterSettings) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClusterSettings) ProtoMessage() {}
+
+func (x *ClusterSettings) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClusterSettings.ProtoReflect.Descriptor instead.
+func (*ClusterSettings) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ClusterSettings) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *ClusterSettings) GetShardingPrecision() uint32 {
+	if x != nil {
+		return x.ShardingPrecision
+	}
+	return 0
+}
+
+func (x *ClusterSettings) GetReplicationFactor() uint32 {
+	if x != nil {
+		return x.ReplicationFactor
+	}
+	return 0
+}
+
+func (x *ClusterSettings) GetPingTtl() int64 {
+	if x != nil {
+		return x.PingTtl
+	}
+	return 0
+}
+
+func (x *ClusterSettings) GetShardingMigration() *ShardingMigration {
+	if x != nil {
+		return x.ShardingMigration
+	}
+	return nil
+}
+
+func (x *ClusterSettings) GetRingHash() string {
+	if x != nil {
+		return x.RingHash
+	}
+	return ""
+}
+
+func (x *ClusterSettings) GetRingSeed() uint64 {
+	if x != nil {
+		return x.RingSeed
+	}
+	return 0
+}
+
+type StartShardingMigrationRequest struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ShardingPrecision uint32                 `protobuf:"varint,1,opt,name=sharding_precision,json=shardingPrecision,proto3" json:"sharding_precision,omitempty"` // new sharding precision (2 to 7)
+	WindowSeconds     int32                  `protobuf:"varint,2,opt,name=window_seconds,json=windowSeconds,proto3" json:"window_seconds,omitempty"`             // dual reads after the switch, at least the workers' PING_TTL (0 = registry default)
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *StartShardingMigrationRequest) Reset() {
+	*x = StartShardingMigrationRequest{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartShardingMigrationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartShardingMigrationRequest) ProtoMessage() {}
+
+func (x *StartShardingMigrationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartShardingMigrationRequest.ProtoReflect.Descriptor instead.
+func (*StartShardingMigrationRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StartShardingMigrationRequest) GetShardingPrecision() uint32 {
+	if x != nil {
+		return x.ShardingPrecision
+	}
+	return 0
+}
+
+func (x *StartShardingMigrationRequest) GetWindowSeconds() int32 {
+	if x != nil {
+		return x.WindowSeconds
+	}
+	return 0
+}
+
+type GetShardingMigrationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetShardingMigrationRequest) Reset() {
+	*x = GetShardingMigrationRequest{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetShardingMigrationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetShardingMigrationRequest) ProtoMessage() {}
+
+func (x *GetShardingMigrationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetShardingMigrationRequest.ProtoReflect.Descriptor instead.
+func (*GetShardingMigrationRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{5}
+}
+
+type ShardingMigration struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FromPrecision uint32                 `protobuf:"varint,1,opt,name=from_precision,json=fromPrecision,proto3" json:"from_precision,omitempty"`
+	ToPrecision   uint32                 `protobuf:"varint,2,opt,name=to_precision,json=toPrecision,proto3" json:"to_precision,omitempty"`
+	SwitchAt      int64                  `protobuf:"varint,3,opt,name=switch_at,json=switchAt,proto3" json:"switch_at,omitempty"` // unix ms: gateways write at to_precision from then on
+	Until         int64                  `protobuf:"varint,4,opt,name=until,proto3" json:"until,omitempty"`                       // unix ms: reads go to the owners at both precisions until then
+	State         string                 `protobuf:"bytes,5,opt,name=state,proto3" json:"state,omitempty"`                        // pending (before the switch), dual_read or done. none without a migration
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShardingMigration) Reset() {
+	*x = ShardingMigration{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShardingMigration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShardingMigration) ProtoMessage() {}
+
+func (x *ShardingMigration) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShardingMigration.ProtoReflect.Descriptor instead.
+func (*ShardingMigration) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ShardingMigration) GetFromPrecision() uint32 {
+	if x != nil {
+		return x.FromPrecision
+	}
+	return 0
+}
+
+func (x *ShardingMigration) GetToPrecision() uint32 {
+	if x != nil {
+		return x.ToPrecision
+	}
+	return 0
+}
+
+func (x *ShardingMigration) GetSwitchAt() int64 {
+	if x != nil {
+		return x.SwitchAt
+	}
+	return 0
+}
+
+func (x *ShardingMigration) GetUntil() int64 {
+	if x != nil {
+		return x.Until
+	}
+	return 0
+}
+
+func (x *ShardingMigration) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+type StartRollingRestartRequest struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Addresses            []string               `protobuf:"bytes,1,rep,name=addresses,proto3" json:"addresses,omitempty"`                                                      // workers to restart, in order (empty = every live worker, by address)
+	DrainSeconds         int32                  `protobuf:"varint,2,opt,name=drain_seconds,json=drainSeconds,proto3" json:"drain_seconds,omitempty"`                           // time a worker is drained before its restart (0 = registry default)
+	RejoinTimeoutSeconds int32                  `protobuf:"varint,3,opt,name=rejoin_timeout_seconds,json=rejoinTimeoutSeconds,proto3" json:"rejoin_timeout_seconds,omitempty"` // time a restarted worker has to rejoin before the rollout fails (0 = registry default)
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *StartRollingRestartRequest) Reset() {
+	*x = StartRollingRestartRequest{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StartRollingRestartRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartRollingRestartRequest) ProtoMessage() {}
+
+func (x *StartRollingRestartRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartRollingRestartRequest.ProtoReflect.Descriptor instead.
+func (*StartRollingRestartRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *StartRollingRestartRequest) GetAddresses() []string {
+	if x != nil {
+		return x.Addresses
+	}
+	return nil
+}
+
+func (x *StartRollingRestartRequest) GetDrainSeconds() int32 {
+	if x != nil {
+		return x.DrainSeconds
+	}
+	return 0
+}
+
+func (x *StartRollingRestartRequest) GetRejoinTimeoutSeconds() int32 {
+	if x != nil {
+		return x.RejoinTimeoutSeconds
+	}
+	return 0
+}
+
+type GetRollingRestartRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRollingRestartRequest) Reset() {
+	*x = GetRollingRestartRequest{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRollingRestartRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRollingRestartRequest) ProtoMessage() {}
+
+func (x *GetRollingRestartRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRollingRestartRequest.ProtoReflect.Descriptor instead.
+func (*GetRollingRestartRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{8}
+}
+
+type AbortRollingRestartRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AbortRollingRestartRequest) Reset() {
+	*x = AbortRollingRestartRequest{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AbortRollingRestartRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AbortRollingRestartRequest) ProtoMessage() {}
+
+func (x *AbortRollingRestartRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AbortRollingRestartRequest.ProtoReflect.Descriptor instead.
+func (*AbortRollingRestartRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{9}
+}
+
+type RollingRestartStatus struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	State         string                  `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"` // idle, running, done, failed or aborted
+	Workers       []*RollingRestartWorker `protobuf:"bytes,2,rep,name=workers,proto3" json:"workers,omitempty"`
+	StartedAt     int64                   `protobuf:"varint,3,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`    // unix ms
+	FinishedAt    int64                   `protobuf:"varint,4,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"` // unix ms, 0 while running
+	Error         string                  `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RollingRestartStatus) Reset() {
+	*x = RollingRestartStatus{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RollingRestartStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RollingRestartStatus) ProtoMessage() {}
+
+func (x *RollingRestartStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RollingRestartStatus.ProtoReflect.Descriptor instead.
+func (*RollingRestartStatus) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *RollingRestartStatus) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *RollingRestartStatus) GetWorkers() []*RollingRestartWorker {
+	if x != nil {
+		return x.Workers
+	}
+	return nil
+}
+
+func (x *RollingRestartStatus) GetStartedAt() int64 {
+	if x != nil {
+		return x.StartedAt
+	}
+	return 0
+}
+
+func (x *RollingRestartStatus) GetFinishedAt() int64 {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return 0
+}
+
+func (x *RollingRestartStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type RollingRestartWorker struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Phase         string                 `protobuf:"bytes,2,opt,name=phase,proto3" json:"phase,omitempty"`                                  // pending, draining, restarting, rejoined or failed
+	WorkerId      string                 `protobuf:"bytes,3,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`            // before the restart
+	NewWorkerId   string                 `protobuf:"bytes,4,opt,name=new_worker_id,json=newWorkerId,proto3" json:"new_worker_id,omitempty"` // once rejoined
+	UpdatedAt     int64                  `protobuf:"varint,5,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`        // unix ms of the last phase change
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RollingRestartWorker) Reset() {
+	*x = RollingRestartWorker{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RollingRestartWorker) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RollingRestartWorker) ProtoMessage() {}
+
+func (x *RollingRestartWorker) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RollingRestartWorker.ProtoReflect.Descriptor instead.
+func (*RollingRestartWorker) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *RollingRestartWorker) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *RollingRestartWorker) GetPhase() string {
+	if x != nil {
+		return x.Phase
+	}
+	return ""
+}
+
+func (x *RollingRestartWorker) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *RollingRestartWorker) GetNewWorkerId() string {
+	if x != nil {
+		return x.NewWorkerId
+	}
+	return ""
+}
+
+func (x *RollingRestartWorker) GetUpdatedAt() int64 {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return 0
+}
+
+type ListWorkersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWorkersRequest) Reset() {
+	*x = ListWorkersRequest{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWorkersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWorkersRequest) ProtoMessage() {}
+
+func (x *ListWorkersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWorkersRequest.ProtoReflect.Descriptor instead.
+func (*ListWorkersRequest) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{12}
+}
+
+type ListWorkersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Workers       []*RegisteredWorker    `protobuf:"bytes,1,rep,name=workers,proto3" json:"workers,omitempty"` // by address
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWorkersResponse) Reset() {
+	*x = ListWorkersResponse{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWorkersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWorkersResponse) ProtoMessage() {}
+
+func (x *ListWorkersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWorkersResponse.ProtoReflect.Descriptor instead.
+func (*ListWorkersResponse) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ListWorkersResponse) GetWorkers() []*RegisteredWorker {
+	if x != nil {
+		return x.Workers
+	}
+	return nil
+}
+
+type RegisteredWorker struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Address       string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	WorkerId      string                 `protobuf:"bytes,2,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	LastSeen      int64                  `protobuf:"varint,3,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`      // unix ms, last heartbeat
+	Live          bool                   `protobuf:"varint,4,opt,name=live,proto3" json:"live,omitempty"`                              // heard from within the gateways' worker TTL
+	StandbyFor    string                 `protobuf:"bytes,5,opt,name=standby_for,json=standbyFor,proto3" json:"standby_for,omitempty"` // warm standbys: address of their primary
+	Replaced      bool                   `protobuf:"varint,6,opt,name=replaced,proto3" json:"replaced,omitempty"`                      // a primary whose standby took over its worker id
+	BuildVersion  string                 `protobuf:"bytes,7,opt,name=build_version,json=buildVersion,proto3" json:"build_version,omitempty"`
+	ApiVersion    uint32                 `protobuf:"varint,8,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"` // newest the worker speaks
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisteredWorker) Reset() {
+	*x = RegisteredWorker{}
+	mi := &file_proto_gateway_discovery_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisteredWorker) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisteredWorker) ProtoMessage() {}
+
+func (x *RegisteredWorker) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_gateway_discovery_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisteredWorker.ProtoReflect.Descriptor instead.
+func (*RegisteredWorker) Descriptor() ([]byte, []int) {
+	return file_proto_gateway_discovery_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *RegisteredWorker) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *RegisteredWorker) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *RegisteredWorker) GetLastSeen() int64 {
+	if x != nil {
+		return x.LastSeen
+	}
+	return 0
+}
+
+func (x *RegisteredWorker) GetLive() bool {
+	if x != nil {
+		return x.Live
+	}
+	return false
+}
+
+func (x *RegisteredWorker) GetStandbyFor() string {
+	if x != nil {
+		return x.StandbyFor
+	}
+	return ""
+}
+
+func (x *RegisteredWorker) GetReplaced() bool {
+	if x != nil {
+		return x.Replaced
+	}
+	return false
+}
+
+func (x *RegisteredWorker) GetBuildVersion() string {
+	if x != nil {
+		return x.BuildVersion
+	}
+	return ""
+}
+
+func (x *RegisteredWorker) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+var File_proto_gateway_discovery_proto protoreflect.FileDescriptor
+
+const file_proto_gateway_discovery_proto_rawDesc = "" +
+	"\n" +
+	"\x1dproto/gateway_discovery.proto\x12\vgeostreamdb\"\x9b\x02\n" +
+	"\x18RegistryHeartbeatRequest\x12\x1d\n" +
+	"\n" +
+	"gateway_id\x18\x01 \x01(\tR\tgatewayId\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\x12\x1f\n" +
+	"\vapi_version\x18\x03 \x01(\rR\n" +
+	"apiVersion\x12&\n" +
+	"\x0fmin_api_version\x18\x04 \x01(\rR\rminApiVersion\x12#\n" +
+	"\rbuild_version\x18\x05 \x01(\tR\fbuildVersion\x12)\n" +
+	"\x10settings_version\x18\x06 \x01(\x04R\x0fsettingsVersion\x12\x10\n" +
+	"\x03seq\x18\a \x01(\x04R\x03seq\x12\x1b\n" +
+	"\tseq_epoch\x18\b \x01(\x03R\bseqEpoch\"\xc2\x01\n" +
+	"\x19RegistryHeartbeatResponse\x12\"\n" +
+	"\facknowledged\x18\x01 \x01(\bR\facknowledged\x12\x1f\n" +
+	"\vapi_version\x18\x02 \x01(\rR\n" +
+	"apiVersion\x12&\n" +
+	"\x0fmin_api_version\x18\x03 \x01(\rR\rminApiVersion\x128\n" +
+	"\bsettings\x18\x04 \x01(\v2\x1c.geostreamdb.ClusterSettingsR\bsettings\"\x1b\n" +
+	"\x19GetClusterSettingsRequest\"\xad\x02\n" +
+	"\x0fClusterSettings\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\x04R\aversion\x12-\n" +
+	"\x12sharding_precision\x18\x02 \x01(\rR\x11shardingPrecision\x12-\n" +
+	"\x12replication_factor\x18\x03 \x01(\rR\x11replicationFactor\x12\x19\n" +
+	"\bping_ttl\x18\x04 \x01(\x03R\apingTtl\x12M\n" +
+	"\x12sharding_migration\x18\x05 \x01(\v2\x1e.geostreamdb.ShardingMigrationR\x11shardingMigration\x12\x1b\n" +
+	"\tring_hash\x18\x06 \x01(\tR\bringHash\x12\x1b\n" +
+	"\tring_seed\x18\a \x01(\x04R\bringSeed\"u\n" +
+	"\x1dStartShardingMigrationRequest\x12-\n" +
+	"\x12sharding_precision\x18\x01 \x01(\rR\x11shardingPrecision\x12%\n" +
+	"\x0ewindow_seconds\x18\x02 \x01(\x05R\rwindowSeconds\"\x1d\n" +
+	"\x1bGetShardingMigrationRequest\"\xa6\x01\n" +
+	"\x11ShardingMigration\x12%\n" +
+	"\x0efrom_precision\x18\x01 \x01(\rR\rfromPrecision\x12!\n" +
+	"\fto_precision\x18\x02 \x01(\rR\vtoPrecision\x12\x1b\n" +
+	"\tswitch_at\x18\x03 \x01(\x03R\bswitchAt\x12\x14\n" +
+	"\x05until\x18\x04 \x01(\x03R\x05until\x12\x14\n" +
+	"\x05state\x18\x05 \x01(\tR\x05state\"\x95\x01\n" +
+	"\x1aStartRollingRestartRequest\x12\x1c\n" +
+	"\taddresses\x18\x01 \x03(\tR\taddresses\x12#\n" +
+	"\rdrain_seconds\x18\x02 \x01(\x05R\fdrainSeconds\x124\n" +
+	"\x16rejoin_timeout_seconds\x18\x03 \x01(\x05R\x14rejoinTimeoutSeconds\"\x1a\n" +
+	"\x18GetRollingRestartRequest\"\x1c\n" +
+	"\x1aAbortRollingRestartRequest\"\xbf\x01\n" +
+	"\x14RollingRestartStatus\x12\x14\n" +
+	"\x05state\x18\x01 \x01(\tR\x05state\x12;\n" +
+	"\aworkers\x18\x02 \x03(\v2!.geostreamdb.RollingRestartWorkerR\aworkers\x12\x1d\n" +
+	"\n" +
+	"started_at\x18\x03 \x01(\x03R\tstartedAt\x12\x1f\n" +
+	"\vfinished_at\x18\x04 \x01(\x03R\n" +
+	"finishedAt\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"\xa6\x01\n" +
+	"\x14RollingRestartWorker\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x14\n" +
+	"\x05phase\x18\x02 \x01(\tR\x05phase\x12\x1b\n" +
+	"\tworker_id\x18\x03 \x01(\tR\bworkerId\x12\"\n" +
+	"\rnew_worker_id\x18\x04 \x01(\tR\vnewWorkerId\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\x05 \x01(\x03R\tupdatedAt\"\x14\n" +
+	"\x12ListWorkersRequest\"N\n" +
+	"\x13ListWorkersResponse\x127\n" +
+	"\aworkers\x18\x01 \x03(\v2\x1d.geostreamdb.RegisteredWorkerR\aworkers\"\xfd\x01\n" +
+	"\x10RegisteredWorker\x12\x18\n" +
+	"\aaddress\x18\x01 \x01(\tR\aaddress\x12\x1b\n" +
+	"\tworker_id\x18\x02 \x01(\tR\bworkerId\x12\x1b\n" +
+	"\tlast_seen\x18\x03 \x01(\x03R\blastSeen\x12\x12\n" +
+	"\x04live\x18\x04 \x01(\bR\x04live\x12\x1f\n" +
+	"\vstandby_for\x18\x05 \x01(\tR\n" +
+	"standbyFor\x12\x1a\n" +
+	"\breplaced\x18\x06 \x01(\bR\breplaced\x12#\n" +
+	"\rbuild_version\x18\a \x01(\tR\fbuildVersion\x12\x1f\n" +
+	"\vapi_version\x18\b \x01(\rR\n" +
+	"apiVersion2\x91\x06\n" +
 	"\bRegistry\x12\\\n" +
-	"\tHeartbeat\x12%.geostreamdb.RegistryHeartbeatRequest\x1a&.geostreamdb.RegistryHeartbeatResponse\"\x00B\x13Z\x11geostreamdb/protob\x06proto3"
+	"\tHeartbeat\x12%.geostreamdb.RegistryHeartbeatRequest\x1a&.geostreamdb.RegistryHeartbeatResponse\"\x00\x12c\n" +
+	"\x13StartRollingRestart\x12'.geostreamdb.StartRollingRestartRequest\x1a!.geostreamdb.RollingRestartStatus\"\x00\x12_\n" +
+	"\x11GetRollingRestart\x12%.geostreamdb.GetRollingRestartRequest\x1a!.geostreamdb.RollingRestartStatus\"\x00\x12c\n" +
+	"\x13AbortRollingRestart\x12'.geostreamdb.AbortRollingRestartRequest\x1a!.geostreamdb.RollingRestartStatus\"\x00\x12\\\n" +
+	"\x12GetClusterSettings\x12&.geostreamdb.GetClusterSettingsRequest\x1a\x1c.geostreamdb.ClusterSettings\"\x00\x12f\n" +
+	"\x16StartShardingMigration\x12*.geostreamdb.StartShardingMigrationRequest\x1a\x1e.geostreamdb.ShardingMigration\"\x00\x12b\n" +
+	"\x14GetShardingMigration\x12(.geostreamdb.GetShardingMigrationRequest\x1a\x1e.geostreamdb.ShardingMigration\"\x00\x12R\n" +
+	"\vListWorkers\x12\x1f.geostreamdb.ListWorkersRequest\x1a .geostreamdb.ListWorkersResponse\"\x00B\x13Z\x11geostreamdb/protob\x06proto3"
 
 var (
 	file_proto_gateway_discovery_proto_rawDescOnce sync.Once
@@ -143,19 +1044,50 @@ func file_proto_gateway_discovery_proto_rawDescGZIP() []byte {
 	return file_proto_gateway_discovery_proto_rawDescData
 }
 
-var file_proto_gateway_discovery_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_gateway_discovery_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
 var file_proto_gateway_discovery_proto_goTypes = []any{
-	(*RegistryHeartbeatRequest)(nil),  // 0: geostreamdb.RegistryHeartbeatRequest
-	(*RegistryHeartbeatResponse)(nil), // 1: geostreamdb.RegistryHeartbeatResponse
+	(*RegistryHeartbeatRequest)(nil),      // 0: geostreamdb.RegistryHeartbeatRequest
+	(*RegistryHeartbeatResponse)(nil),     // 1: geostreamdb.RegistryHeartbeatResponse
+	(*GetClusterSettingsRequest)(nil),     // 2: geostreamdb.GetClusterSettingsRequest
+	(*ClusterSettings)(nil),               // 3: geostreamdb.ClusterSettings
+	(*StartShardingMigrationRequest)(nil), // 4: geostreamdb.StartShardingMigrationRequest
+	(*GetShardingMigrationRequest)(nil),   // 5: geostreamdb.GetShardingMigrationRequest
+	(*ShardingMigration)(nil),             // 6: geostreamdb.ShardingMigration
+	(*StartRollingRestartRequest)(nil),    // 7: geostreamdb.StartRollingRestartRequest
+	(*GetRollingRestartRequest)(nil),      // 8: geostreamdb.GetRollingRestartRequest
+	(*AbortRollingRestartRequest)(nil),    // 9: geostreamdb.AbortRollingRestartRequest
+	(*RollingRestartStatus)(nil),          // 10: geostreamdb.RollingRestartStatus
+	(*RollingRestartWorker)(nil),          // 11: geostreamdb.RollingRestartWorker
+	(*ListWorkersRequest)(nil),            // 12: geostreamdb.ListWorkersRequest
+	(*ListWorkersResponse)(nil),           // 13: geostreamdb.ListWorkersResponse
+	(*RegisteredWorker)(nil),              // 14: geostreamdb.RegisteredWorker
 }
 var file_proto_gateway_discovery_proto_depIdxs = []int32{
-	0, // 0: geostreamdb.Registry.Heartbeat:input_type -> geostreamdb.RegistryHeartbeatRequest
-	1, // 1: geostreamdb.Registry.Heartbeat:output_type -> geostreamdb.RegistryHeartbeatResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	3,  // 0: geostreamdb.RegistryHeartbeatResponse.settings:type_name -> geostreamdb.ClusterSettings
+	6,  // 1: geostreamdb.ClusterSettings.sharding_migration:type_name -> geostreamdb.ShardingMigration
+	11, // 2: geostreamdb.RollingRestartStatus.workers:type_name -> geostreamdb.RollingRestartWorker
+	14, // 3: geostreamdb.ListWorkersResponse.workers:type_name -> geostreamdb.RegisteredWorker
+	0,  // 4: geostreamdb.Registry.Heartbeat:input_type -> geostreamdb.RegistryHeartbeatRequest
+	7,  // 5: geostreamdb.Registry.StartRollingRestart:input_type -> geostreamdb.StartRollingRestartRequest
+	8,  // 6: geostreamdb.Registry.GetRollingRestart:input_type -> geostreamdb.GetRollingRestartRequest
+	9,  // 7: geostreamdb.Registry.AbortRollingRestart:input_type -> geostreamdb.AbortRollingRestartRequest
+	2,  // 8: geostreamdb.Registry.GetClusterSettings:input_type -> geostreamdb.GetClusterSettingsRequest
+	4,  // 9: geostreamdb.Registry.StartShardingMigration:input_type -> geostreamdb.StartShardingMigrationRequest
+	5,  // 10: geostreamdb.Registry.GetShardingMigration:input_type -> geostreamdb.GetShardingMigrationRequest
+	12, // 11: geostreamdb.Registry.ListWorkers:input_type -> geostreamdb.ListWorkersRequest
+	1,  // 12: geostreamdb.Registry.Heartbeat:output_type -> geostreamdb.RegistryHeartbeatResponse
+	10, // 13: geostreamdb.Registry.StartRollingRestart:output_type -> geostreamdb.RollingRestartStatus
+	10, // 14: geostreamdb.Registry.GetRollingRestart:output_type -> geostreamdb.RollingRestartStatus
+	10, // 15: geostreamdb.Registry.AbortRollingRestart:output_type -> geostreamdb.RollingRestartStatus
+	3,  // 16: geostreamdb.Registry.GetClusterSettings:output_type -> geostreamdb.ClusterSettings
+	6,  // 17: geostreamdb.Registry.StartShardingMigration:output_type -> geostreamdb.ShardingMigration
+	6,  // 18: geostreamdb.Registry.GetShardingMigration:output_type -> geostreamdb.ShardingMigration
+	13, // 19: geostreamdb.Registry.ListWorkers:output_type -> geostreamdb.ListWorkersResponse
+	12, // [12:20] is the sub-list for method output_type
+	4,  // [4:12] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_proto_gateway_discovery_proto_init() }
@@ -169,7 +1101,7 @@ func file_proto_gateway_discovery_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_gateway_discovery_proto_rawDesc), len(file_proto_gateway_discovery_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   15,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,7 +19,14 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Registry_Heartbeat_FullMethodName = "/geostreamdb.Registry/Heartbeat"
+	Registry_Heartbeat_FullMethodName              = "/geostreamdb.Registry/Heartbeat"
+	Registry_StartRollingRestart_FullMethodName    = "/geostreamdb.Registry/StartRollingRestart"
+	Registry_GetRollingRestart_FullMethodName      = "/geostreamdb.Registry/GetRollingRestart"
+	Registry_AbortRollingRestart_FullMethodName    = "/geostreamdb.Registry/AbortRollingRestart"
+	Registry_GetClusterSettings_FullMethodName     = "/geostreamdb.Registry/GetClusterSettings"
+	Registry_StartShardingMigration_FullMethodName = "/geostreamdb.Registry/StartShardingMigration"
+	Registry_GetShardingMigration_FullMethodName   = "/geostreamdb.Registry/GetShardingMigration"
+	Registry_ListWorkers_FullMethodName            = "/geostreamdb.Registry/ListWorkers"
 )
 
 // RegistryClient is the client API for Registry service.
@@ -27,6 +34,17 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type RegistryClient interface {
 	Heartbeat(ctx context.Context, in *RegistryHeartbeatRequest, opts ...grpc.CallOption) (*RegistryHeartbeatResponse, error)
+	// rolling restart of the workers, one at a time (admin, see registry/rollout.go)
+	StartRollingRestart(ctx context.Context, in *StartRollingRestartRequest, opts ...grpc.CallOption) (*RollingRestartStatus, error)
+	GetRollingRestart(ctx context.Context, in *GetRollingRestartRequest, opts ...grpc.CallOption) (*RollingRestartStatus, error)
+	AbortRollingRestart(ctx context.Context, in *AbortRollingRestartRequest, opts ...grpc.CallOption) (*RollingRestartStatus, error)
+	// cluster-wide settings, fetched by gateways and workers at startup (see registry/settings.go)
+	GetClusterSettings(ctx context.Context, in *GetClusterSettingsRequest, opts ...grpc.CallOption) (*ClusterSettings, error)
+	// coordinated change of the sharding precision (admin, see registry/migration.go)
+	StartShardingMigration(ctx context.Context, in *StartShardingMigrationRequest, opts ...grpc.CallOption) (*ShardingMigration, error)
+	GetShardingMigration(ctx context.Context, in *GetShardingMigrationRequest, opts ...grpc.CallOption) (*ShardingMigration, error)
+	// workers the registry heard from, standbys included (admin, see registry/membership.go)
+	ListWorkers(ctx context.Context, in *ListWorkersRequest, opts ...grpc.CallOption) (*ListWorkersResponse, error)
 }
 
 type registryClient struct {
@@ -47,11 +65,92 @@ func (c *registryClient) Heartbeat(ctx context.Context, in *RegistryHeartbeatReq
 	return out, nil
 }
 
+func (c *registryClient) StartRollingRestart(ctx context.Context, in *StartRollingRestartRequest, opts ...grpc.CallOption) (*RollingRestartStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RollingRestartStatus)
+	err := c.cc.Invoke(ctx, Registry_StartRollingRestart_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) GetRollingRestart(ctx context.Context, in *GetRollingRestartRequest, opts ...grpc.CallOption) (*RollingRestartStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RollingRestartStatus)
+	err := c.cc.Invoke(ctx, Registry_GetRollingRestart_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) AbortRollingRestart(ctx context.Context, in *AbortRollingRestartRequest, opts ...grpc.CallOption) (*RollingRestartStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RollingRestartStatus)
+	err := c.cc.Invoke(ctx, Registry_AbortRollingRestart_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) GetClusterSettings(ctx context.Context, in *GetClusterSettingsRequest, opts ...grpc.CallOption) (*ClusterSettings, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClusterSettings)
+	err := c.cc.Invoke(ctx, Registry_GetClusterSettings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) StartShardingMigration(ctx context.Context, in *StartShardingMigrationRequest, opts ...grpc.CallOption) (*ShardingMigration, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShardingMigration)
+	err := c.cc.Invoke(ctx, Registry_StartShardingMigration_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) GetShardingMigration(ctx context.Context, in *GetShardingMigrationRequest, opts ...grpc.CallOption) (*ShardingMigration, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShardingMigration)
+	err := c.cc.Invoke(ctx, Registry_GetShardingMigration_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryClient) ListWorkers(ctx context.Context, in *ListWorkersRequest, opts ...grpc.CallOption) (*ListWorkersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListWorkersResponse)
+	err := c.cc.Invoke(ctx, Registry_ListWorkers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RegistryServer is the server API for Registry service.
 // All implementations must embed UnimplementedRegistryServer
 // for forward compatibility.
 type RegistryServer interface {
 	Heartbeat(context.Context, *RegistryHeartbeatRequest) (*RegistryHeartbeatResponse, error)
+	// rolling restart of the workers, one at a time (admin, see registry/rollout.go)
+	StartRollingRestart(context.Context, *StartRollingRestartRequest) (*RollingRestartStatus, error)
+	GetRollingRestart(context.Context, *GetRollingRestartRequest) (*RollingRestartStatus, error)
+	AbortRollingRestart(context.Context, *AbortRollingRestartRequest) (*RollingRestartStatus, error)
+	// cluster-wide settings, fetched by gateways and workers at startup (see registry/settings.go)
+	GetClusterSettings(context.Context, *GetClusterSettingsRequest) (*ClusterSettings, error)
+	// coordinated change of the sharding precision (admin, see registry/migration.go)
+	StartShardingMigration(context.Context, *StartShardingMigrationRequest) (*ShardingMigration, error)
+	GetShardingMigration(context.Context, *GetShardingMigrationRequest) (*ShardingMigration, error)
+	// workers the registry heard from, standbys included (admin, see registry/membership.go)
+	ListWorkers(context.Context, *ListWorkersRequest) (*ListWorkersResponse, error)
 	mustEmbedUnimplementedRegistryServer()
 }
 
@@ -65,6 +164,27 @@ type UnimplementedRegistryServer struct{}
 func (UnimplementedRegistryServer) Heartbeat(context.Context, *RegistryHeartbeatRequest) (*RegistryHeartbeatResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method Heartbeat not implemented")
 }
+func (UnimplementedRegistryServer) StartRollingRestart(context.Context, *StartRollingRestartRequest) (*RollingRestartStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method StartRollingRestart not implemented")
+}
+func (UnimplementedRegistryServer) GetRollingRestart(context.Context, *GetRollingRestartRequest) (*RollingRestartStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetRollingRestart not implemented")
+}
+func (UnimplementedRegistryServer) AbortRollingRestart(context.Context, *AbortRollingRestartRequest) (*RollingRestartStatus, error) {
+	return nil, status.Error(codes.Unimplemented, "method AbortRollingRestart not implemented")
+}
+func (UnimplementedRegistryServer) GetClusterSettings(context.Context, *GetClusterSettingsRequest) (*ClusterSettings, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetClusterSettings not implemented")
+}
+func (UnimplementedRegistryServer) StartShardingMigration(context.Context, *StartShardingMigrationRequest) (*ShardingMigration, error) {
+	return nil, status.Error(codes.Unimplemented, "method StartShardingMigration not implemented")
+}
+func (UnimplementedRegistryServer) GetShardingMigration(context.Context, *GetShardingMigrationRequest) (*ShardingMigration, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetShardingMigration not implemented")
+}
+func (UnimplementedRegistryServer) ListWorkers(context.Context, *ListWorkersRequest) (*ListWorkersResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListWorkers not implemented")
+}
 func (UnimplementedRegistryServer) mustEmbedUnimplementedRegistryServer() {}
 func (UnimplementedRegistryServer) testEmbeddedByValue()                  {}
 
@@ -104,6 +224,132 @@ func _Registry_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Registry_StartRollingRestart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartRollingRestartRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).StartRollingRestart(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_StartRollingRestart_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).StartRollingRestart(ctx, req.(*StartRollingRestartRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_GetRollingRestart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRollingRestartRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).GetRollingRestart(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_GetRollingRestart_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).GetRollingRestart(ctx, req.(*GetRollingRestartRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_AbortRollingRestart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbortRollingRestartRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).AbortRollingRestart(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_AbortRollingRestart_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).AbortRollingRestart(ctx, req.(*AbortRollingRestartRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_GetClusterSettings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetClusterSettingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).GetClusterSettings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_GetClusterSettings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).GetClusterSettings(ctx, req.(*GetClusterSettingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_StartShardingMigration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartShardingMigrationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).StartShardingMigration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_StartShardingMigration_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).StartShardingMigration(ctx, req.(*StartShardingMigrationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_GetShardingMigration_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetShardingMigrationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).GetShardingMigration(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_GetShardingMigration_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).GetShardingMigration(ctx, req.(*GetShardingMigrationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Registry_ListWorkers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWorkersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServer).ListWorkers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Registry_ListWorkers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServer).ListWorkers(ctx, req.(*ListWorkersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Registry_ServiceDesc is the grpc.ServiceDesc for Registry service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,6 +361,34 @@ var Registry_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Heartbeat",
 			Handler:    _Registry_Heartbeat_Handler,
 		},
+		{
+			MethodName: "StartRollingRestart",
+			Handler:    _Registry_StartRollingRestart_Handler,
+		},
+		{
+			MethodName: "GetRollingRestart",
+			Handler:    _Registry_GetRollingRestart_Handler,
+		},
+		{
+			MethodName: "AbortRollingRestart",
+			Handler:    _Registry_AbortRollingRestart_Handler,
+		},
+		{
+			MethodName: "GetClusterSettings",
+			Handler:    _Registry_GetClusterSettings_Handler,
+		},
+		{
+			MethodName: "StartShardingMigration",
+			Handler:    _Registry_StartShardingMigration_Handler,
+		},
+		{
+			MethodName: "GetShardingMigration",
+			Handler:    _Registry_GetShardingMigration_Handler,
+		},
+		{
+			MethodName: "ListWorkers",
+			Handler:    _Registry_ListWorkers_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/gateway_discovery.proto",
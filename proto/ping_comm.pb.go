@@ -24,6 +24,19 @@ const (
 type PingRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Geohash       string                 `protobuf:"bytes,1,opt,name=geohash,proto3" json:"geohash,omitempty"`
+	Replica       bool                   `protobuf:"varint,2,opt,name=replica,proto3" json:"replica,omitempty"`                                  // stored apart from primary data so broadcast queries don't double count
+	Timestamp     int64                  `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`                              // gateway ingest time (unix ms). 0 = use the worker's clock
+	DeviceId      string                 `protobuf:"bytes,4,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`                 // optional, only kept by workers with raw retention
+	ApiVersion    uint32                 `protobuf:"varint,5,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`          // negotiated from heartbeats (0 = sent before versioning, see version.go)
+	Mirror        bool                   `protobuf:"varint,6,opt,name=mirror,proto3" json:"mirror,omitempty"`                                    // mirrored from the primary this worker is a warm standby of (stored as primary data, not mirrored further)
+	Seq           uint64                 `protobuf:"varint,7,opt,name=seq,proto3" json:"seq,omitempty"`                                          // optional per-device sequence number (0 = none): repeats of a recent one for the device_id are dropped
+	WriteSeq      uint64                 `protobuf:"varint,8,opt,name=write_seq,json=writeSeq,proto3" json:"write_seq,omitempty"`                // mirrored pings: the primary's write_seq for it (a promoted standby carries on from it)
+	Teleport      bool                   `protobuf:"varint,9,opt,name=teleport,proto3" json:"teleport,omitempty"`                                // tagged as an impossible jump from the device's previous position (kept with raw retention)
+	ClientSentAt  int64                  `protobuf:"varint,10,opt,name=client_sent_at,json=clientSentAt,proto3" json:"client_sent_at,omitempty"` // primary pings: client send time (unix ms) moved to the worker's clock by the gateway, 0 = none
+	Motion        *Motion                `protobuf:"bytes,11,opt,name=motion,proto3" json:"motion,omitempty"`                                    // optional speed and heading reported by the device, aggregated per cell (see worker-node/movement.go)
+	HasFloor      bool                   `protobuf:"varint,12,opt,name=has_floor,json=hasFloor,proto3" json:"has_floor,omitempty"`
+	Floor         int32                  `protobuf:"zigzag32,13,opt,name=floor,proto3" json:"floor,omitempty"` // with has_floor: vertical bucket (floor or altitude band), counted per floor too (see worker-node/floors.go)
+	Tenant        string                 `protobuf:"bytes,14,opt,name=tenant,proto3" json:"tenant,omitempty"`  // with device_id: the tenant that ingested the ping ("" = anonymous), the only one its device track is served to
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -65,28 +78,1893 @@ func (x *PingRequest) GetGeohash() string {
 	return ""
 }
 
+func (x *PingRequest) GetReplica() bool {
+	if x != nil {
+		return x.Replica
+	}
+	return false
+}
+
+func (x *PingRequest) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *PingRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *PingRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *PingRequest) GetMirror() bool {
+	if x != nil {
+		return x.Mirror
+	}
+	return false
+}
+
+func (x *PingRequest) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *PingRequest) GetWriteSeq() uint64 {
+	if x != nil {
+		return x.WriteSeq
+	}
+	return 0
+}
+
+func (x *PingRequest) GetTeleport() bool {
+	if x != nil {
+		return x.Teleport
+	}
+	return false
+}
+
+func (x *PingRequest) GetClientSentAt() int64 {
+	if x != nil {
+		return x.ClientSentAt
+	}
+	return 0
+}
+
+func (x *PingRequest) GetMotion() *Motion {
+	if x != nil {
+		return x.Motion
+	}
+	return nil
+}
+
+func (x *PingRequest) GetHasFloor() bool {
+	if x != nil {
+		return x.HasFloor
+	}
+	return false
+}
+
+func (x *PingRequest) GetFloor() int32 {
+	if x != nil {
+		return x.Floor
+	}
+	return 0
+}
+
+func (x *PingRequest) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+type Motion struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Speed         float64                `protobuf:"fixed64,1,opt,name=speed,proto3" json:"speed,omitempty"` // meters per second
+	HasHeading    bool                   `protobuf:"varint,2,opt,name=has_heading,json=hasHeading,proto3" json:"has_heading,omitempty"`
+	Heading       float64                `protobuf:"fixed64,3,opt,name=heading,proto3" json:"heading,omitempty"` // degrees clockwise from north, [0, 360)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Motion) Reset() {
+	*x = Motion{}
+	mi := &file_proto_ping_comm_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Motion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Motion) ProtoMessage() {}
+
+func (x *Motion) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Motion.ProtoReflect.Descriptor instead.
+func (*Motion) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Motion) GetSpeed() float64 {
+	if x != nil {
+		return x.Speed
+	}
+	return 0
+}
+
+func (x *Motion) GetHasHeading() bool {
+	if x != nil {
+		return x.HasHeading
+	}
+	return false
+}
+
+func (x *Motion) GetHeading() float64 {
+	if x != nil {
+		return x.Heading
+	}
+	return 0
+}
+
 type PingResponse struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Success   bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Duplicate bool                   `protobuf:"varint,2,opt,name=duplicate,proto3" json:"duplicate,omitempty"` // dropped as a repeat of a recent (device_id, seq), nothing stored
+	// primary writes: the read token of the write, for GET /ping?token= (see gateway/readtoken.go)
+	WorkerId      string `protobuf:"bytes,3,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Slot          int64  `protobuf:"varint,4,opt,name=slot,proto3" json:"slot,omitempty"`                         // second the ping is bucketed in
+	WriteSeq      uint64 `protobuf:"varint,5,opt,name=write_seq,json=writeSeq,proto3" json:"write_seq,omitempty"` // primary pings stored by the worker so far
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PingResponse) Reset() {
+	*x = PingResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingResponse) ProtoMessage() {}
+
+func (x *PingResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingResponse.ProtoReflect.Descriptor instead.
+func (*PingResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PingResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *PingResponse) GetDuplicate() bool {
+	if x != nil {
+		return x.Duplicate
+	}
+	return false
+}
+
+func (x *PingResponse) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *PingResponse) GetSlot() int64 {
+	if x != nil {
+		return x.Slot
+	}
+	return 0
+}
+
+func (x *PingResponse) GetWriteSeq() uint64 {
+	if x != nil {
+		return x.WriteSeq
+	}
+	return 0
+}
+
+type GetPingsRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	Geohash    string                 `protobuf:"bytes,1,opt,name=geohash,proto3" json:"geohash,omitempty"`
+	Replica    bool                   `protobuf:"varint,2,opt,name=replica,proto3" json:"replica,omitempty"`
+	ApiVersion uint32                 `protobuf:"varint,3,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	// optional read token: answer whether the write it names is visible
+	TokenWorkerId string `protobuf:"bytes,4,opt,name=token_worker_id,json=tokenWorkerId,proto3" json:"token_worker_id,omitempty"`
+	TokenSlot     int64  `protobuf:"varint,5,opt,name=token_slot,json=tokenSlot,proto3" json:"token_slot,omitempty"`
+	TokenWriteSeq uint64 `protobuf:"varint,6,opt,name=token_write_seq,json=tokenWriteSeq,proto3" json:"token_write_seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPingsRequest) Reset() {
+	*x = GetPingsRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPingsRequest) ProtoMessage() {}
+
+func (x *GetPingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPingsRequest.ProtoReflect.Descriptor instead.
+func (*GetPingsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetPingsRequest) GetGeohash() string {
+	if x != nil {
+		return x.Geohash
+	}
+	return ""
+}
+
+func (x *GetPingsRequest) GetReplica() bool {
+	if x != nil {
+		return x.Replica
+	}
+	return false
+}
+
+func (x *GetPingsRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *GetPingsRequest) GetTokenWorkerId() string {
+	if x != nil {
+		return x.TokenWorkerId
+	}
+	return ""
+}
+
+func (x *GetPingsRequest) GetTokenSlot() int64 {
+	if x != nil {
+		return x.TokenSlot
+	}
+	return 0
+}
+
+func (x *GetPingsRequest) GetTokenWriteSeq() uint64 {
+	if x != nil {
+		return x.TokenWriteSeq
+	}
+	return 0
+}
+
+type GetPingsResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Count         int64                  `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	TokenReached  bool                   `protobuf:"varint,3,opt,name=token_reached,json=tokenReached,proto3" json:"token_reached,omitempty"` // the token's write is counted (or has already left the TTL window)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *PingResponse) Reset() {
-	*x = PingResponse{}
-	mi := &file_proto_ping_comm_proto_msgTypes[1]
+func (x *GetPingsResponse) Reset() {
+	*x = GetPingsResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPingsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPingsResponse) ProtoMessage() {}
+
+func (x *GetPingsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPingsResponse.ProtoReflect.Descriptor instead.
+func (*GetPingsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetPingsResponse) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *GetPingsResponse) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *GetPingsResponse) GetTokenReached() bool {
+	if x != nil {
+		return x.TokenReached
+	}
+	return false
+}
+
+type GetPingsBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Geohashes     []string               `protobuf:"bytes,1,rep,name=geohashes,proto3" json:"geohashes,omitempty"`
+	Replica       bool                   `protobuf:"varint,2,opt,name=replica,proto3" json:"replica,omitempty"`
+	ApiVersion    uint32                 `protobuf:"varint,3,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPingsBatchRequest) Reset() {
+	*x = GetPingsBatchRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPingsBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPingsBatchRequest) ProtoMessage() {}
+
+func (x *GetPingsBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPingsBatchRequest.ProtoReflect.Descriptor instead.
+func (*GetPingsBatchRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetPingsBatchRequest) GetGeohashes() []string {
+	if x != nil {
+		return x.Geohashes
+	}
+	return nil
+}
+
+func (x *GetPingsBatchRequest) GetReplica() bool {
+	if x != nil {
+		return x.Replica
+	}
+	return false
+}
+
+func (x *GetPingsBatchRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+type GetPingsBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Counts        []int64                `protobuf:"varint,1,rep,packed,name=counts,proto3" json:"counts,omitempty"` // in the order of the geohashes
+	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPingsBatchResponse) Reset() {
+	*x = GetPingsBatchResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPingsBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPingsBatchResponse) ProtoMessage() {}
+
+func (x *GetPingsBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPingsBatchResponse.ProtoReflect.Descriptor instead.
+func (*GetPingsBatchResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetPingsBatchResponse) GetCounts() []int64 {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+func (x *GetPingsBatchResponse) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+type GetTotalRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ApiVersion    uint32                 `protobuf:"varint,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTotalRequest) Reset() {
+	*x = GetTotalRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTotalRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTotalRequest) ProtoMessage() {}
+
+func (x *GetTotalRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTotalRequest.ProtoReflect.Descriptor instead.
+func (*GetTotalRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetTotalRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+type GetTotalResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Count         int64                  `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"` // primary pings in the TTL window (replicas left out, so the workers' counts add up)
+	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTotalResponse) Reset() {
+	*x = GetTotalResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTotalResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTotalResponse) ProtoMessage() {}
+
+func (x *GetTotalResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTotalResponse.ProtoReflect.Descriptor instead.
+func (*GetTotalResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetTotalResponse) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *GetTotalResponse) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+type GetPingAreaRequest struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Precision          int32                  `protobuf:"varint,1,opt,name=precision,proto3" json:"precision,omitempty"`
+	AggPrecision       int32                  `protobuf:"varint,2,opt,name=aggPrecision,proto3" json:"aggPrecision,omitempty"`
+	MinLat             float64                `protobuf:"fixed64,3,opt,name=minLat,proto3" json:"minLat,omitempty"`
+	MaxLat             float64                `protobuf:"fixed64,4,opt,name=maxLat,proto3" json:"maxLat,omitempty"`
+	MinLng             float64                `protobuf:"fixed64,5,opt,name=minLng,proto3" json:"minLng,omitempty"`
+	MaxLng             float64                `protobuf:"fixed64,6,opt,name=maxLng,proto3" json:"maxLng,omitempty"`
+	Geohashes          []string               `protobuf:"bytes,7,rep,name=geohashes,proto3" json:"geohashes,omitempty"`
+	Replica            bool                   `protobuf:"varint,8,opt,name=replica,proto3" json:"replica,omitempty"`
+	ApiVersion         uint32                 `protobuf:"varint,9,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	Smooth             int32                  `protobuf:"varint,10,opt,name=smooth,proto3" json:"smooth,omitempty"`                                                   // > 1: counts averaged over that many windows, ending at each of the last seconds
+	HistoryOffset      int64                  `protobuf:"varint,11,opt,name=history_offset,json=historyOffset,proto3" json:"history_offset,omitempty"`                // > 0: the TTL window that ended that many seconds ago, from the history tier (smooth ignored)
+	Window             int64                  `protobuf:"varint,12,opt,name=window,proto3" json:"window,omitempty"`                                                   // > 0: only the newest that many seconds of the TTL window are counted (per-tenant retention)
+	HistoryGranularity int64                  `protobuf:"varint,13,opt,name=history_granularity,json=historyGranularity,proto3" json:"history_granularity,omitempty"` // history queries: seconds the window is averaged over, if coarser than the worker's buckets
+	Movement           bool                   `protobuf:"varint,14,opt,name=movement,proto3" json:"movement,omitempty"`                                               // also return the movement of each cell (live window only, not with smooth)
+	HasFloor           bool                   `protobuf:"varint,15,opt,name=has_floor,json=hasFloor,proto3" json:"has_floor,omitempty"`                               // only count the pings of a floor (live window only, not with movement)
+	Floor              int32                  `protobuf:"zigzag32,16,opt,name=floor,proto3" json:"floor,omitempty"`
+	FrameSeconds       int64                  `protobuf:"varint,17,opt,name=frame_seconds,json=frameSeconds,proto3" json:"frame_seconds,omitempty"` // > 0: the pings of consecutive frames of that many seconds ending now, not the window's
+	Frames             int32                  `protobuf:"varint,18,opt,name=frames,proto3" json:"frames,omitempty"`
+	FramesHistory      bool                   `protobuf:"varint,19,opt,name=frames_history,json=framesHistory,proto3" json:"frames_history,omitempty"` // frames older than the live window may be read from the history tier
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *GetPingAreaRequest) Reset() {
+	*x = GetPingAreaRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPingAreaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPingAreaRequest) ProtoMessage() {}
+
+func (x *GetPingAreaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPingAreaRequest.ProtoReflect.Descriptor instead.
+func (*GetPingAreaRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetPingAreaRequest) GetPrecision() int32 {
+	if x != nil {
+		return x.Precision
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetAggPrecision() int32 {
+	if x != nil {
+		return x.AggPrecision
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetMinLat() float64 {
+	if x != nil {
+		return x.MinLat
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetMaxLat() float64 {
+	if x != nil {
+		return x.MaxLat
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetMinLng() float64 {
+	if x != nil {
+		return x.MinLng
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetMaxLng() float64 {
+	if x != nil {
+		return x.MaxLng
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetGeohashes() []string {
+	if x != nil {
+		return x.Geohashes
+	}
+	return nil
+}
+
+func (x *GetPingAreaRequest) GetReplica() bool {
+	if x != nil {
+		return x.Replica
+	}
+	return false
+}
+
+func (x *GetPingAreaRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetSmooth() int32 {
+	if x != nil {
+		return x.Smooth
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetHistoryOffset() int64 {
+	if x != nil {
+		return x.HistoryOffset
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetWindow() int64 {
+	if x != nil {
+		return x.Window
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetHistoryGranularity() int64 {
+	if x != nil {
+		return x.HistoryGranularity
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetMovement() bool {
+	if x != nil {
+		return x.Movement
+	}
+	return false
+}
+
+func (x *GetPingAreaRequest) GetHasFloor() bool {
+	if x != nil {
+		return x.HasFloor
+	}
+	return false
+}
+
+func (x *GetPingAreaRequest) GetFloor() int32 {
+	if x != nil {
+		return x.Floor
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetFrameSeconds() int64 {
+	if x != nil {
+		return x.FrameSeconds
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetFrames() int32 {
+	if x != nil {
+		return x.Frames
+	}
+	return 0
+}
+
+func (x *GetPingAreaRequest) GetFramesHistory() bool {
+	if x != nil {
+		return x.FramesHistory
+	}
+	return false
+}
+
+type GetPingAreaResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Counts        []*PingAreaCount       `protobuf:"bytes,1,rep,name=counts,proto3" json:"counts,omitempty"`
+	Frames        []*PingAreaFrame       `protobuf:"bytes,2,rep,name=frames,proto3" json:"frames,omitempty"` // frame_seconds > 0: oldest first
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPingAreaResponse) Reset() {
+	*x = GetPingAreaResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPingAreaResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPingAreaResponse) ProtoMessage() {}
+
+func (x *GetPingAreaResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPingAreaResponse.ProtoReflect.Descriptor instead.
+func (*GetPingAreaResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetPingAreaResponse) GetCounts() []*PingAreaCount {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+func (x *GetPingAreaResponse) GetFrames() []*PingAreaFrame {
+	if x != nil {
+		return x.Frames
+	}
+	return nil
+}
+
+type PingAreaFrame struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	End           int64                  `protobuf:"varint,1,opt,name=end,proto3" json:"end,omitempty"` // unix seconds, last second of the frame
+	Counts        []*PingAreaCount       `protobuf:"bytes,2,rep,name=counts,proto3" json:"counts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PingAreaFrame) Reset() {
+	*x = PingAreaFrame{}
+	mi := &file_proto_ping_comm_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingAreaFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingAreaFrame) ProtoMessage() {}
+
+func (x *PingAreaFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingAreaFrame.ProtoReflect.Descriptor instead.
+func (*PingAreaFrame) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *PingAreaFrame) GetEnd() int64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *PingAreaFrame) GetCounts() []*PingAreaCount {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+type PingAreaCount struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Geohash       string                 `protobuf:"bytes,1,opt,name=geohash,proto3" json:"geohash,omitempty"`
+	Count         int64                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+	Movement      *Movement              `protobuf:"bytes,3,opt,name=movement,proto3" json:"movement,omitempty"` // GetPingAreaRequest.movement: set for cells holding pings with a speed
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PingAreaCount) Reset() {
+	*x = PingAreaCount{}
+	mi := &file_proto_ping_comm_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingAreaCount) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingAreaCount) ProtoMessage() {}
+
+func (x *PingAreaCount) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingAreaCount.ProtoReflect.Descriptor instead.
+func (*PingAreaCount) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *PingAreaCount) GetGeohash() string {
+	if x != nil {
+		return x.Geohash
+	}
+	return ""
+}
+
+func (x *PingAreaCount) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *PingAreaCount) GetMovement() *Movement {
+	if x != nil {
+		return x.Movement
+	}
+	return nil
+}
+
+// the pings of a cell that came with a speed. sums, so that the cells of several workers add up
+type Movement struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Moving        int64                  `protobuf:"varint,1,opt,name=moving,proto3" json:"moving,omitempty"` // at STATIONARY_SPEED or faster
+	Stationary    int64                  `protobuf:"varint,2,opt,name=stationary,proto3" json:"stationary,omitempty"`
+	SpeedSum      float64                `protobuf:"fixed64,3,opt,name=speed_sum,json=speedSum,proto3" json:"speed_sum,omitempty"` // meters per second, of the moving and stationary pings
+	HeadingX      float64                `protobuf:"fixed64,4,opt,name=heading_x,json=headingX,proto3" json:"heading_x,omitempty"` // sums of the unit vectors (x east, y north) of the moving pings' headings
+	HeadingY      float64                `protobuf:"fixed64,5,opt,name=heading_y,json=headingY,proto3" json:"heading_y,omitempty"`
+	Headed        int64                  `protobuf:"varint,6,opt,name=headed,proto3" json:"headed,omitempty"` // moving pings with a heading
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Movement) Reset() {
+	*x = Movement{}
+	mi := &file_proto_ping_comm_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Movement) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Movement) ProtoMessage() {}
+
+func (x *Movement) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Movement.ProtoReflect.Descriptor instead.
+func (*Movement) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Movement) GetMoving() int64 {
+	if x != nil {
+		return x.Moving
+	}
+	return 0
+}
+
+func (x *Movement) GetStationary() int64 {
+	if x != nil {
+		return x.Stationary
+	}
+	return 0
+}
+
+func (x *Movement) GetSpeedSum() float64 {
+	if x != nil {
+		return x.SpeedSum
+	}
+	return 0
+}
+
+func (x *Movement) GetHeadingX() float64 {
+	if x != nil {
+		return x.HeadingX
+	}
+	return 0
+}
+
+func (x *Movement) GetHeadingY() float64 {
+	if x != nil {
+		return x.HeadingY
+	}
+	return 0
+}
+
+func (x *Movement) GetHeaded() int64 {
+	if x != nil {
+		return x.Headed
+	}
+	return 0
+}
+
+type LatLng struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lat           float64                `protobuf:"fixed64,1,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lng           float64                `protobuf:"fixed64,2,opt,name=lng,proto3" json:"lng,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LatLng) Reset() {
+	*x = LatLng{}
+	mi := &file_proto_ping_comm_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LatLng) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LatLng) ProtoMessage() {}
+
+func (x *LatLng) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LatLng.ProtoReflect.Descriptor instead.
+func (*LatLng) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *LatLng) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *LatLng) GetLng() float64 {
+	if x != nil {
+		return x.Lng
+	}
+	return 0
+}
+
+type CountInPolygonRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Vertices      []*LatLng              `protobuf:"bytes,1,rep,name=vertices,proto3" json:"vertices,omitempty"` // simple polygon, implicitly closed
+	ApiVersion    uint32                 `protobuf:"varint,2,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CountInPolygonRequest) Reset() {
+	*x = CountInPolygonRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CountInPolygonRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CountInPolygonRequest) ProtoMessage() {}
+
+func (x *CountInPolygonRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CountInPolygonRequest.ProtoReflect.Descriptor instead.
+func (*CountInPolygonRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *CountInPolygonRequest) GetVertices() []*LatLng {
+	if x != nil {
+		return x.Vertices
+	}
+	return nil
+}
+
+func (x *CountInPolygonRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+type CountInPolygonResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Count         int64                  `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"` // primary pings in the TTL window whose geohash center is inside the polygon
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CountInPolygonResponse) Reset() {
+	*x = CountInPolygonResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CountInPolygonResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CountInPolygonResponse) ProtoMessage() {}
+
+func (x *CountInPolygonResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CountInPolygonResponse.ProtoReflect.Descriptor instead.
+func (*CountInPolygonResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *CountInPolygonResponse) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type GetDevicePingsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Limit         int32                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"` // most recent pings returned (0 = worker default)
+	ApiVersion    uint32                 `protobuf:"varint,3,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	Tenant        string                 `protobuf:"bytes,4,opt,name=tenant,proto3" json:"tenant,omitempty"` // only the pings this tenant ingested for the device ("" = anonymous)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDevicePingsRequest) Reset() {
+	*x = GetDevicePingsRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDevicePingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDevicePingsRequest) ProtoMessage() {}
+
+func (x *GetDevicePingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDevicePingsRequest.ProtoReflect.Descriptor instead.
+func (*GetDevicePingsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GetDevicePingsRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *GetDevicePingsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *GetDevicePingsRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *GetDevicePingsRequest) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+type GetDevicePingsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pings         []*RawPing             `protobuf:"bytes,1,rep,name=pings,proto3" json:"pings,omitempty"` // oldest first
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDevicePingsResponse) Reset() {
+	*x = GetDevicePingsResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDevicePingsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDevicePingsResponse) ProtoMessage() {}
+
+func (x *GetDevicePingsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDevicePingsResponse.ProtoReflect.Descriptor instead.
+func (*GetDevicePingsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GetDevicePingsResponse) GetPings() []*RawPing {
+	if x != nil {
+		return x.Pings
+	}
+	return nil
+}
+
+type DeleteDeviceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	ApiVersion    uint32                 `protobuf:"varint,2,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	Forwarded     bool                   `protobuf:"varint,3,opt,name=forwarded,proto3" json:"forwarded,omitempty"` // sent by the primary to its warm standby (not forwarded further)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteDeviceRequest) Reset() {
+	*x = DeleteDeviceRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteDeviceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDeviceRequest) ProtoMessage() {}
+
+func (x *DeleteDeviceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDeviceRequest.ProtoReflect.Descriptor instead.
+func (*DeleteDeviceRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *DeleteDeviceRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *DeleteDeviceRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *DeleteDeviceRequest) GetForwarded() bool {
+	if x != nil {
+		return x.Forwarded
+	}
+	return false
+}
+
+type DeleteDeviceResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	RawPings        int64                  `protobuf:"varint,1,opt,name=raw_pings,json=rawPings,proto3" json:"raw_pings,omitempty"`                      // retained pings unlinked from the device (kept as anonymous pings)
+	DedupWindows    int64                  `protobuf:"varint,2,opt,name=dedup_windows,json=dedupWindows,proto3" json:"dedup_windows,omitempty"`          // sequence windows dropped
+	TombstonedUntil int64                  `protobuf:"varint,3,opt,name=tombstoned_until,json=tombstonedUntil,proto3" json:"tombstoned_until,omitempty"` // unix seconds: until then pings with the device id are stored without it
+	Standby         string                 `protobuf:"bytes,4,opt,name=standby,proto3" json:"standby,omitempty"`                                         // the warm standby's purge: "" (no standby), "purged" or the error
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *DeleteDeviceResponse) Reset() {
+	*x = DeleteDeviceResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteDeviceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDeviceResponse) ProtoMessage() {}
+
+func (x *DeleteDeviceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDeviceResponse.ProtoReflect.Descriptor instead.
+func (*DeleteDeviceResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *DeleteDeviceResponse) GetRawPings() int64 {
+	if x != nil {
+		return x.RawPings
+	}
+	return 0
+}
+
+func (x *DeleteDeviceResponse) GetDedupWindows() int64 {
+	if x != nil {
+		return x.DedupWindows
+	}
+	return 0
+}
+
+func (x *DeleteDeviceResponse) GetTombstonedUntil() int64 {
+	if x != nil {
+		return x.TombstonedUntil
+	}
+	return 0
+}
+
+func (x *DeleteDeviceResponse) GetStandby() string {
+	if x != nil {
+		return x.Standby
+	}
+	return ""
+}
+
+type RawPing struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Geohash       string                 `protobuf:"bytes,1,opt,name=geohash,proto3" json:"geohash,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // unix ms
+	Teleport      bool                   `protobuf:"varint,3,opt,name=teleport,proto3" json:"teleport,omitempty"`   // see PingRequest.teleport
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RawPing) Reset() {
+	*x = RawPing{}
+	mi := &file_proto_ping_comm_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RawPing) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RawPing) ProtoMessage() {}
+
+func (x *RawPing) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RawPing.ProtoReflect.Descriptor instead.
+func (*RawPing) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *RawPing) GetGeohash() string {
+	if x != nil {
+		return x.Geohash
+	}
+	return ""
+}
+
+func (x *RawPing) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *RawPing) GetTeleport() bool {
+	if x != nil {
+		return x.Teleport
+	}
+	return false
+}
+
+type CheckMotionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Geohash       string                 `protobuf:"bytes,2,opt,name=geohash,proto3" json:"geohash,omitempty"`                     // the ping's position (cell center)
+	Timestamp     int64                  `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`                // gateway ingest time (unix ms)
+	MaxSpeed      float64                `protobuf:"fixed64,4,opt,name=max_speed,json=maxSpeed,proto3" json:"max_speed,omitempty"` // meters per second: faster than this from the last position is a teleport
+	ApiVersion    uint32                 `protobuf:"varint,5,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckMotionRequest) Reset() {
+	*x = CheckMotionRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckMotionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckMotionRequest) ProtoMessage() {}
+
+func (x *CheckMotionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckMotionRequest.ProtoReflect.Descriptor instead.
+func (*CheckMotionRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *CheckMotionRequest) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *CheckMotionRequest) GetGeohash() string {
+	if x != nil {
+		return x.Geohash
+	}
+	return ""
+}
+
+func (x *CheckMotionRequest) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *CheckMotionRequest) GetMaxSpeed() float64 {
+	if x != nil {
+		return x.MaxSpeed
+	}
+	return 0
+}
+
+func (x *CheckMotionRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+type CheckMotionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Teleport      bool                   `protobuf:"varint,1,opt,name=teleport,proto3" json:"teleport,omitempty"` // the last position isn't updated, so the next ping is checked against the last plausible one
+	Speed         float64                `protobuf:"fixed64,2,opt,name=speed,proto3" json:"speed,omitempty"`      // meters per second from the last position (0 for the device's first ping)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckMotionResponse) Reset() {
+	*x = CheckMotionResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckMotionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckMotionResponse) ProtoMessage() {}
+
+func (x *CheckMotionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckMotionResponse.ProtoReflect.Descriptor instead.
+func (*CheckMotionResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *CheckMotionResponse) GetTeleport() bool {
+	if x != nil {
+		return x.Teleport
+	}
+	return false
+}
+
+func (x *CheckMotionResponse) GetSpeed() float64 {
+	if x != nil {
+		return x.Speed
+	}
+	return 0
+}
+
+type GetInfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ApiVersion    uint32                 `protobuf:"varint,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	PrefixLength  int32                  `protobuf:"varint,2,opt,name=prefix_length,json=prefixLength,proto3" json:"prefix_length,omitempty"` // length of the owned prefixes listed (0 = worker default)
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetInfoRequest) Reset() {
+	*x = GetInfoRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInfoRequest) ProtoMessage() {}
+
+func (x *GetInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInfoRequest.ProtoReflect.Descriptor instead.
+func (*GetInfoRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *GetInfoRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *GetInfoRequest) GetPrefixLength() int32 {
+	if x != nil {
+		return x.PrefixLength
+	}
+	return 0
+}
+
+type GetInfoResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	WorkerId          string                 `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`        // as announced in heartbeats (the taken over id once a standby is promoted)
+	ApiVersion        uint32                 `protobuf:"varint,2,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"` // range supported by the worker
+	MinApiVersion     uint32                 `protobuf:"varint,3,opt,name=min_api_version,json=minApiVersion,proto3" json:"min_api_version,omitempty"`
+	StartedAt         int64                  `protobuf:"varint,4,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`                                                   // unix ms
+	Config            map[string]string      `protobuf:"bytes,5,rep,name=config,proto3" json:"config,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // effective settings (environment variable -> value)
+	Slots             []*SlotOccupancy       `protobuf:"bytes,6,rep,name=slots,proto3" json:"slots,omitempty"`                                                                             // per buffer, empty for engines without in-memory slots
+	OwnedPrefixes     []string               `protobuf:"bytes,7,rep,name=owned_prefixes,json=ownedPrefixes,proto3" json:"owned_prefixes,omitempty"`                                        // prefixes of prefix_length holding primary data in the TTL window, sorted
+	PrefixesTruncated bool                   `protobuf:"varint,8,opt,name=prefixes_truncated,json=prefixesTruncated,proto3" json:"prefixes_truncated,omitempty"`                           // more prefixes than listed
+	StandbyFor        string                 `protobuf:"bytes,9,opt,name=standby_for,json=standbyFor,proto3" json:"standby_for,omitempty"`                                                 // primary address while a warm standby
+	BuildVersion      string                 `protobuf:"bytes,10,opt,name=build_version,json=buildVersion,proto3" json:"build_version,omitempty"`
+	BuildCommit       string                 `protobuf:"bytes,11,opt,name=build_commit,json=buildCommit,proto3" json:"build_commit,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *GetInfoResponse) Reset() {
+	*x = GetInfoResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInfoResponse) ProtoMessage() {}
+
+func (x *GetInfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInfoResponse.ProtoReflect.Descriptor instead.
+func (*GetInfoResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *GetInfoResponse) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *GetInfoResponse) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *GetInfoResponse) GetMinApiVersion() uint32 {
+	if x != nil {
+		return x.MinApiVersion
+	}
+	return 0
+}
+
+func (x *GetInfoResponse) GetStartedAt() int64 {
+	if x != nil {
+		return x.StartedAt
+	}
+	return 0
+}
+
+func (x *GetInfoResponse) GetConfig() map[string]string {
+	if x != nil {
+		return x.Config
+	}
+	return nil
+}
+
+func (x *GetInfoResponse) GetSlots() []*SlotOccupancy {
+	if x != nil {
+		return x.Slots
+	}
+	return nil
+}
+
+func (x *GetInfoResponse) GetOwnedPrefixes() []string {
+	if x != nil {
+		return x.OwnedPrefixes
+	}
+	return nil
+}
+
+func (x *GetInfoResponse) GetPrefixesTruncated() bool {
+	if x != nil {
+		return x.PrefixesTruncated
+	}
+	return false
+}
+
+func (x *GetInfoResponse) GetStandbyFor() string {
+	if x != nil {
+		return x.StandbyFor
+	}
+	return ""
+}
+
+func (x *GetInfoResponse) GetBuildVersion() string {
+	if x != nil {
+		return x.BuildVersion
+	}
+	return ""
+}
+
+func (x *GetInfoResponse) GetBuildCommit() string {
+	if x != nil {
+		return x.BuildCommit
+	}
+	return ""
+}
+
+type SlotOccupancy struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Buffer        string                 `protobuf:"bytes,1,opt,name=buffer,proto3" json:"buffer,omitempty"`                         // primary or replica
+	Slots         int32                  `protobuf:"varint,2,opt,name=slots,proto3" json:"slots,omitempty"`                          // (TTL second, shard) slots
+	Occupied      int32                  `protobuf:"varint,3,opt,name=occupied,proto3" json:"occupied,omitempty"`                    // slots holding pings of the TTL window
+	TrieNodes     int64                  `protobuf:"varint,4,opt,name=trie_nodes,json=trieNodes,proto3" json:"trie_nodes,omitempty"` // nodes of the occupied slots' tries
+	TrieDepth     int32                  `protobuf:"varint,5,opt,name=trie_depth,json=trieDepth,proto3" json:"trie_depth,omitempty"` // deepest of them
+	TrieBytes     int64                  `protobuf:"varint,6,opt,name=trie_bytes,json=trieBytes,proto3" json:"trie_bytes,omitempty"` // estimated memory of those tries
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SlotOccupancy) Reset() {
+	*x = SlotOccupancy{}
+	mi := &file_proto_ping_comm_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SlotOccupancy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SlotOccupancy) ProtoMessage() {}
+
+func (x *SlotOccupancy) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SlotOccupancy.ProtoReflect.Descriptor instead.
+func (*SlotOccupancy) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *SlotOccupancy) GetBuffer() string {
+	if x != nil {
+		return x.Buffer
+	}
+	return ""
+}
+
+func (x *SlotOccupancy) GetSlots() int32 {
+	if x != nil {
+		return x.Slots
+	}
+	return 0
+}
+
+func (x *SlotOccupancy) GetOccupied() int32 {
+	if x != nil {
+		return x.Occupied
+	}
+	return 0
+}
+
+func (x *SlotOccupancy) GetTrieNodes() int64 {
+	if x != nil {
+		return x.TrieNodes
+	}
+	return 0
+}
+
+func (x *SlotOccupancy) GetTrieDepth() int32 {
+	if x != nil {
+		return x.TrieDepth
+	}
+	return 0
+}
+
+func (x *SlotOccupancy) GetTrieBytes() int64 {
+	if x != nil {
+		return x.TrieBytes
+	}
+	return 0
+}
+
+type GenerateRequest struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	ApiVersion      uint32                 `protobuf:"varint,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	Stop            bool                   `protobuf:"varint,2,opt,name=stop,proto3" json:"stop,omitempty"`                    // stops the running generator (the other fields are ignored)
+	Distribution    string                 `protobuf:"bytes,3,opt,name=distribution,proto3" json:"distribution,omitempty"`     // uniform, hotspots or front
+	MinLat          float64                `protobuf:"fixed64,4,opt,name=min_lat,json=minLat,proto3" json:"min_lat,omitempty"` // area the pings fall in
+	MaxLat          float64                `protobuf:"fixed64,5,opt,name=max_lat,json=maxLat,proto3" json:"max_lat,omitempty"`
+	MinLng          float64                `protobuf:"fixed64,6,opt,name=min_lng,json=minLng,proto3" json:"min_lng,omitempty"`
+	MaxLng          float64                `protobuf:"fixed64,7,opt,name=max_lng,json=maxLng,proto3" json:"max_lng,omitempty"`
+	Rate            int64                  `protobuf:"varint,8,opt,name=rate,proto3" json:"rate,omitempty"` // pings per second
+	DurationSeconds int64                  `protobuf:"varint,9,opt,name=duration_seconds,json=durationSeconds,proto3" json:"duration_seconds,omitempty"`
+	Seed            uint64                 `protobuf:"varint,10,opt,name=seed,proto3" json:"seed,omitempty"`                                        // of the hotspots and fronts, so that workers given the same seed draw the same ones
+	Hotspots        int32                  `protobuf:"varint,11,opt,name=hotspots,proto3" json:"hotspots,omitempty"`                                // hotspots: how many (0 = worker default)
+	PeriodSeconds   int64                  `protobuf:"varint,12,opt,name=period_seconds,json=periodSeconds,proto3" json:"period_seconds,omitempty"` // front: time to cross the area (0 = duration)
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *GenerateRequest) Reset() {
+	*x = GenerateRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[27]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *PingResponse) String() string {
+func (x *GenerateRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*PingResponse) ProtoMessage() {}
+func (*GenerateRequest) ProtoMessage() {}
 
-func (x *PingResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_ping_comm_proto_msgTypes[1]
+func (x *GenerateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[27]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -97,40 +1975,120 @@ func (x *PingResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use PingResponse.ProtoReflect.Descriptor instead.
-func (*PingResponse) Descriptor() ([]byte, []int) {
-	return file_proto_ping_comm_proto_rawDescGZIP(), []int{1}
+// Deprecated: Use GenerateRequest.ProtoReflect.Descriptor instead.
+func (*GenerateRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{27}
 }
 
-func (x *PingResponse) GetSuccess() bool {
+func (x *GenerateRequest) GetApiVersion() uint32 {
 	if x != nil {
-		return x.Success
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetStop() bool {
+	if x != nil {
+		return x.Stop
 	}
 	return false
 }
 
-type GetPingsRequest struct {
+func (x *GenerateRequest) GetDistribution() string {
+	if x != nil {
+		return x.Distribution
+	}
+	return ""
+}
+
+func (x *GenerateRequest) GetMinLat() float64 {
+	if x != nil {
+		return x.MinLat
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetMaxLat() float64 {
+	if x != nil {
+		return x.MaxLat
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetMinLng() float64 {
+	if x != nil {
+		return x.MinLng
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetMaxLng() float64 {
+	if x != nil {
+		return x.MaxLng
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetRate() int64 {
+	if x != nil {
+		return x.Rate
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetDurationSeconds() int64 {
+	if x != nil {
+		return x.DurationSeconds
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetSeed() uint64 {
+	if x != nil {
+		return x.Seed
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetHotspots() int32 {
+	if x != nil {
+		return x.Hotspots
+	}
+	return 0
+}
+
+func (x *GenerateRequest) GetPeriodSeconds() int64 {
+	if x != nil {
+		return x.PeriodSeconds
+	}
+	return 0
+}
+
+type GenerateResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Geohash       string                 `protobuf:"bytes,1,opt,name=geohash,proto3" json:"geohash,omitempty"`
+	Running       bool                   `protobuf:"varint,1,opt,name=running,proto3" json:"running,omitempty"`
+	Distribution  string                 `protobuf:"bytes,2,opt,name=distribution,proto3" json:"distribution,omitempty"`
+	Generated     int64                  `protobuf:"varint,3,opt,name=generated,proto3" json:"generated,omitempty"`         // pings written by the current (or last) generator
+	EndsAt        int64                  `protobuf:"varint,4,opt,name=ends_at,json=endsAt,proto3" json:"ends_at,omitempty"` // unix ms
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GetPingsRequest) Reset() {
-	*x = GetPingsRequest{}
-	mi := &file_proto_ping_comm_proto_msgTypes[2]
+func (x *GenerateResponse) Reset() {
+	*x = GenerateResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[28]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GetPingsRequest) String() string {
+func (x *GenerateResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetPingsRequest) ProtoMessage() {}
+func (*GenerateResponse) ProtoMessage() {}
 
-func (x *GetPingsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_ping_comm_proto_msgTypes[2]
+func (x *GenerateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[28]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -141,41 +2099,62 @@ func (x *GetPingsRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetPingsRequest.ProtoReflect.Descriptor instead.
-func (*GetPingsRequest) Descriptor() ([]byte, []int) {
-	return file_proto_ping_comm_proto_rawDescGZIP(), []int{2}
+// Deprecated: Use GenerateResponse.ProtoReflect.Descriptor instead.
+func (*GenerateResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{28}
 }
 
-func (x *GetPingsRequest) GetGeohash() string {
+func (x *GenerateResponse) GetRunning() bool {
 	if x != nil {
-		return x.Geohash
+		return x.Running
+	}
+	return false
+}
+
+func (x *GenerateResponse) GetDistribution() string {
+	if x != nil {
+		return x.Distribution
 	}
 	return ""
 }
 
-type GetPingsResponse struct {
+func (x *GenerateResponse) GetGenerated() int64 {
+	if x != nil {
+		return x.Generated
+	}
+	return 0
+}
+
+func (x *GenerateResponse) GetEndsAt() int64 {
+	if x != nil {
+		return x.EndsAt
+	}
+	return 0
+}
+
+type GetAnomaliesRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Count         int64                  `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
-	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	ApiVersion    uint32                 `protobuf:"varint,1,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	Since         int64                  `protobuf:"varint,2,opt,name=since,proto3" json:"since,omitempty"` // unix ms: only the anomalies of intervals ending after it
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GetPingsResponse) Reset() {
-	*x = GetPingsResponse{}
-	mi := &file_proto_ping_comm_proto_msgTypes[3]
+func (x *GetAnomaliesRequest) Reset() {
+	*x = GetAnomaliesRequest{}
+	mi := &file_proto_ping_comm_proto_msgTypes[29]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GetPingsResponse) String() string {
+func (x *GetAnomaliesRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetPingsResponse) ProtoMessage() {}
+func (*GetAnomaliesRequest) ProtoMessage() {}
 
-func (x *GetPingsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_ping_comm_proto_msgTypes[3]
+func (x *GetAnomaliesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[29]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -186,53 +2165,53 @@ func (x *GetPingsResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetPingsResponse.ProtoReflect.Descriptor instead.
-func (*GetPingsResponse) Descriptor() ([]byte, []int) {
-	return file_proto_ping_comm_proto_rawDescGZIP(), []int{3}
+// Deprecated: Use GetAnomaliesRequest.ProtoReflect.Descriptor instead.
+func (*GetAnomaliesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{29}
 }
 
-func (x *GetPingsResponse) GetCount() int64 {
+func (x *GetAnomaliesRequest) GetApiVersion() uint32 {
 	if x != nil {
-		return x.Count
+		return x.ApiVersion
 	}
 	return 0
 }
 
-func (x *GetPingsResponse) GetTimestamp() int64 {
+func (x *GetAnomaliesRequest) GetSince() int64 {
 	if x != nil {
-		return x.Timestamp
+		return x.Since
 	}
 	return 0
 }
 
-type GetPingAreaRequest struct {
+type Anomaly struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Precision     int32                  `protobuf:"varint,1,opt,name=precision,proto3" json:"precision,omitempty"`
-	AggPrecision  int32                  `protobuf:"varint,2,opt,name=aggPrecision,proto3" json:"aggPrecision,omitempty"`
-	MinLat        float64                `protobuf:"fixed64,3,opt,name=minLat,proto3" json:"minLat,omitempty"`
-	MaxLat        float64                `protobuf:"fixed64,4,opt,name=maxLat,proto3" json:"maxLat,omitempty"`
-	MinLng        float64                `protobuf:"fixed64,5,opt,name=minLng,proto3" json:"minLng,omitempty"`
-	MaxLng        float64                `protobuf:"fixed64,6,opt,name=maxLng,proto3" json:"maxLng,omitempty"`
-	Geohashes     []string               `protobuf:"bytes,7,rep,name=geohashes,proto3" json:"geohashes,omitempty"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`       // geohash prefix, at the worker's ANOMALY_PRECISION
+	Kind          string                 `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`           // spike or drop
+	Start         int64                  `protobuf:"varint,3,opt,name=start,proto3" json:"start,omitempty"`        // unix ms, of the interval
+	End           int64                  `protobuf:"varint,4,opt,name=end,proto3" json:"end,omitempty"`            // unix ms
+	Observed      int64                  `protobuf:"varint,5,opt,name=observed,proto3" json:"observed,omitempty"`  // pings in the interval
+	Expected      float64                `protobuf:"fixed64,6,opt,name=expected,proto3" json:"expected,omitempty"` // baseline
+	Score         float64                `protobuf:"fixed64,7,opt,name=score,proto3" json:"score,omitempty"`       // deviations from the baseline (negative for drops)
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GetPingAreaRequest) Reset() {
-	*x = GetPingAreaRequest{}
-	mi := &file_proto_ping_comm_proto_msgTypes[4]
+func (x *Anomaly) Reset() {
+	*x = Anomaly{}
+	mi := &file_proto_ping_comm_proto_msgTypes[30]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GetPingAreaRequest) String() string {
+func (x *Anomaly) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetPingAreaRequest) ProtoMessage() {}
+func (*Anomaly) ProtoMessage() {}
 
-func (x *GetPingAreaRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_ping_comm_proto_msgTypes[4]
+func (x *Anomaly) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[30]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -243,82 +2222,86 @@ func (x *GetPingAreaRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetPingAreaRequest.ProtoReflect.Descriptor instead.
-func (*GetPingAreaRequest) Descriptor() ([]byte, []int) {
-	return file_proto_ping_comm_proto_rawDescGZIP(), []int{4}
+// Deprecated: Use Anomaly.ProtoReflect.Descriptor instead.
+func (*Anomaly) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{30}
 }
 
-func (x *GetPingAreaRequest) GetPrecision() int32 {
+func (x *Anomaly) GetPrefix() string {
 	if x != nil {
-		return x.Precision
+		return x.Prefix
 	}
-	return 0
+	return ""
 }
 
-func (x *GetPingAreaRequest) GetAggPrecision() int32 {
+func (x *Anomaly) GetKind() string {
 	if x != nil {
-		return x.AggPrecision
+		return x.Kind
 	}
-	return 0
+	return ""
 }
 
-func (x *GetPingAreaRequest) GetMinLat() float64 {
+func (x *Anomaly) GetStart() int64 {
 	if x != nil {
-		return x.MinLat
+		return x.Start
 	}
 	return 0
 }
 
-func (x *GetPingAreaRequest) GetMaxLat() float64 {
+func (x *Anomaly) GetEnd() int64 {
 	if x != nil {
-		return x.MaxLat
+		return x.End
 	}
 	return 0
 }
 
-func (x *GetPingAreaRequest) GetMinLng() float64 {
+func (x *Anomaly) GetObserved() int64 {
 	if x != nil {
-		return x.MinLng
+		return x.Observed
 	}
 	return 0
 }
 
-func (x *GetPingAreaRequest) GetMaxLng() float64 {
+func (x *Anomaly) GetExpected() float64 {
 	if x != nil {
-		return x.MaxLng
+		return x.Expected
 	}
 	return 0
 }
 
-func (x *GetPingAreaRequest) GetGeohashes() []string {
+func (x *Anomaly) GetScore() float64 {
 	if x != nil {
-		return x.Geohashes
+		return x.Score
 	}
-	return nil
+	return 0
 }
 
-type GetPingAreaResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Counts        []*PingAreaCount       `protobuf:"bytes,1,rep,name=counts,proto3" json:"counts,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+type GetAnomaliesResponse struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Enabled         bool                   `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	IntervalSeconds int64                  `protobuf:"varint,2,opt,name=interval_seconds,json=intervalSeconds,proto3" json:"interval_seconds,omitempty"`
+	Precision       int32                  `protobuf:"varint,3,opt,name=precision,proto3" json:"precision,omitempty"`
+	Baseline        string                 `protobuf:"bytes,4,opt,name=baseline,proto3" json:"baseline,omitempty"`   // ewma or seasonal
+	Anomalies       []*Anomaly             `protobuf:"bytes,5,rep,name=anomalies,proto3" json:"anomalies,omitempty"` // oldest first
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
-func (x *GetPingAreaResponse) Reset() {
-	*x = GetPingAreaResponse{}
-	mi := &file_proto_ping_comm_proto_msgTypes[5]
+func (x *GetAnomaliesResponse) Reset() {
+	*x = GetAnomaliesResponse{}
+	mi := &file_proto_ping_comm_proto_msgTypes[31]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GetPingAreaResponse) String() string {
+func (x *GetAnomaliesResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetPingAreaResponse) ProtoMessage() {}
+func (*GetAnomaliesResponse) ProtoMessage() {}
 
-func (x *GetPingAreaResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_ping_comm_proto_msgTypes[5]
+func (x *GetAnomaliesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_ping_comm_proto_msgTypes[31]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -329,84 +2312,106 @@ func (x *GetPingAreaResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetPingAreaResponse.ProtoReflect.Descriptor instead.
-func (*GetPingAreaResponse) Descriptor() ([]byte, []int) {
-	return file_proto_ping_comm_proto_rawDescGZIP(), []int{5}
+// Deprecated: Use GetAnomaliesResponse.ProtoReflect.Descriptor instead.
+func (*GetAnomaliesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_ping_comm_proto_rawDescGZIP(), []int{31}
 }
 
-func (x *GetPingAreaResponse) GetCounts() []*PingAreaCount {
+func (x *GetAnomaliesResponse) GetEnabled() bool {
 	if x != nil {
-		return x.Counts
+		return x.Enabled
 	}
-	return nil
-}
-
-type PingAreaCount struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Geohash       string                 `protobuf:"bytes,1,opt,name=geohash,proto3" json:"geohash,omitempty"`
-	Count         int64                  `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
-}
-
-func (x *PingAreaCount) Reset() {
-	*x = PingAreaCount{}
-	mi := &file_proto_ping_comm_proto_msgTypes[6]
-	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-	ms.StoreMessageInfo(mi)
-}
-
-func (x *PingAreaCount) String() string {
-	return protoimpl.X.MessageStringOf(x)
+	return false
 }
 
-func (*PingAreaCount) ProtoMessage() {}
-
-func (x *PingAreaCount) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_ping_comm_proto_msgTypes[6]
+func (x *GetAnomaliesResponse) GetIntervalSeconds() int64 {
 	if x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
+		return x.IntervalSeconds
 	}
-	return mi.MessageOf(x)
+	return 0
 }
 
-// Deprecated: Use PingAreaCount.ProtoReflect.Descriptor instead.
-func (*PingAreaCount) Descriptor() ([]byte, []int) {
-	return file_proto_ping_comm_proto_rawDescGZIP(), []int{6}
+func (x *GetAnomaliesResponse) GetPrecision() int32 {
+	if x != nil {
+		return x.Precision
+	}
+	return 0
 }
 
-func (x *PingAreaCount) GetGeohash() string {
+func (x *GetAnomaliesResponse) GetBaseline() string {
 	if x != nil {
-		return x.Geohash
+		return x.Baseline
 	}
 	return ""
 }
 
-func (x *PingAreaCount) GetCount() int64 {
+func (x *GetAnomaliesResponse) GetAnomalies() []*Anomaly {
 	if x != nil {
-		return x.Count
+		return x.Anomalies
 	}
-	return 0
+	return nil
 }
 
 var File_proto_ping_comm_proto protoreflect.FileDescriptor
 
 const file_proto_ping_comm_proto_rawDesc = "" +
 	"\n" +
-	"\x15proto/ping_comm.proto\x12\vgeostreamdb\"'\n" +
+	"\x15proto/ping_comm.proto\x12\vgeostreamdb\"\x9e\x03\n" +
 	"\vPingRequest\x12\x18\n" +
-	"\ageohash\x18\x01 \x01(\tR\ageohash\"(\n" +
+	"\ageohash\x18\x01 \x01(\tR\ageohash\x12\x18\n" +
+	"\areplica\x18\x02 \x01(\bR\areplica\x12\x1c\n" +
+	"\ttimestamp\x18\x03 \x01(\x03R\ttimestamp\x12\x1b\n" +
+	"\tdevice_id\x18\x04 \x01(\tR\bdeviceId\x12\x1f\n" +
+	"\vapi_version\x18\x05 \x01(\rR\n" +
+	"apiVersion\x12\x16\n" +
+	"\x06mirror\x18\x06 \x01(\bR\x06mirror\x12\x10\n" +
+	"\x03seq\x18\a \x01(\x04R\x03seq\x12\x1b\n" +
+	"\twrite_seq\x18\b \x01(\x04R\bwriteSeq\x12\x1a\n" +
+	"\bteleport\x18\t \x01(\bR\bteleport\x12$\n" +
+	"\x0eclient_sent_at\x18\n" +
+	" \x01(\x03R\fclientSentAt\x12+\n" +
+	"\x06motion\x18\v \x01(\v2\x13.geostreamdb.MotionR\x06motion\x12\x1b\n" +
+	"\thas_floor\x18\f \x01(\bR\bhasFloor\x12\x14\n" +
+	"\x05floor\x18\r \x01(\x11R\x05floor\x12\x16\n" +
+	"\x06tenant\x18\x0e \x01(\tR\x06tenant\"Y\n" +
+	"\x06Motion\x12\x14\n" +
+	"\x05speed\x18\x01 \x01(\x01R\x05speed\x12\x1f\n" +
+	"\vhas_heading\x18\x02 \x01(\bR\n" +
+	"hasHeading\x12\x18\n" +
+	"\aheading\x18\x03 \x01(\x01R\aheading\"\x94\x01\n" +
 	"\fPingResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\"+\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x1c\n" +
+	"\tduplicate\x18\x02 \x01(\bR\tduplicate\x12\x1b\n" +
+	"\tworker_id\x18\x03 \x01(\tR\bworkerId\x12\x12\n" +
+	"\x04slot\x18\x04 \x01(\x03R\x04slot\x12\x1b\n" +
+	"\twrite_seq\x18\x05 \x01(\x04R\bwriteSeq\"\xd5\x01\n" +
 	"\x0fGetPingsRequest\x12\x18\n" +
-	"\ageohash\x18\x01 \x01(\tR\ageohash\"F\n" +
+	"\ageohash\x18\x01 \x01(\tR\ageohash\x12\x18\n" +
+	"\areplica\x18\x02 \x01(\bR\areplica\x12\x1f\n" +
+	"\vapi_version\x18\x03 \x01(\rR\n" +
+	"apiVersion\x12&\n" +
+	"\x0ftoken_worker_id\x18\x04 \x01(\tR\rtokenWorkerId\x12\x1d\n" +
+	"\n" +
+	"token_slot\x18\x05 \x01(\x03R\ttokenSlot\x12&\n" +
+	"\x0ftoken_write_seq\x18\x06 \x01(\x04R\rtokenWriteSeq\"k\n" +
 	"\x10GetPingsResponse\x12\x14\n" +
 	"\x05count\x18\x01 \x01(\x03R\x05count\x12\x1c\n" +
-	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"\xd4\x01\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12#\n" +
+	"\rtoken_reached\x18\x03 \x01(\bR\ftokenReached\"o\n" +
+	"\x14GetPingsBatchRequest\x12\x1c\n" +
+	"\tgeohashes\x18\x01 \x03(\tR\tgeohashes\x12\x18\n" +
+	"\areplica\x18\x02 \x01(\bR\areplica\x12\x1f\n" +
+	"\vapi_version\x18\x03 \x01(\rR\n" +
+	"apiVersion\"M\n" +
+	"\x15GetPingsBatchResponse\x12\x16\n" +
+	"\x06counts\x18\x01 \x03(\x03R\x06counts\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"2\n" +
+	"\x0fGetTotalRequest\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\rR\n" +
+	"apiVersion\"F\n" +
+	"\x10GetTotalResponse\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x03R\x05count\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\"\xca\x04\n" +
 	"\x12GetPingAreaRequest\x12\x1c\n" +
 	"\tprecision\x18\x01 \x01(\x05R\tprecision\x12\"\n" +
 	"\faggPrecision\x18\x02 \x01(\x05R\faggPrecision\x12\x16\n" +
@@ -414,16 +2419,165 @@ const file_proto_ping_comm_proto_rawDesc = "" +
 	"\x06maxLat\x18\x04 \x01(\x01R\x06maxLat\x12\x16\n" +
 	"\x06minLng\x18\x05 \x01(\x01R\x06minLng\x12\x16\n" +
 	"\x06maxLng\x18\x06 \x01(\x01R\x06maxLng\x12\x1c\n" +
-	"\tgeohashes\x18\a \x03(\tR\tgeohashes\"I\n" +
+	"\tgeohashes\x18\a \x03(\tR\tgeohashes\x12\x18\n" +
+	"\areplica\x18\b \x01(\bR\areplica\x12\x1f\n" +
+	"\vapi_version\x18\t \x01(\rR\n" +
+	"apiVersion\x12\x16\n" +
+	"\x06smooth\x18\n" +
+	" \x01(\x05R\x06smooth\x12%\n" +
+	"\x0ehistory_offset\x18\v \x01(\x03R\rhistoryOffset\x12\x16\n" +
+	"\x06window\x18\f \x01(\x03R\x06window\x12/\n" +
+	"\x13history_granularity\x18\r \x01(\x03R\x12historyGranularity\x12\x1a\n" +
+	"\bmovement\x18\x0e \x01(\bR\bmovement\x12\x1b\n" +
+	"\thas_floor\x18\x0f \x01(\bR\bhasFloor\x12\x14\n" +
+	"\x05floor\x18\x10 \x01(\x11R\x05floor\x12#\n" +
+	"\rframe_seconds\x18\x11 \x01(\x03R\fframeSeconds\x12\x16\n" +
+	"\x06frames\x18\x12 \x01(\x05R\x06frames\x12%\n" +
+	"\x0eframes_history\x18\x13 \x01(\bR\rframesHistory\"}\n" +
 	"\x13GetPingAreaResponse\x122\n" +
-	"\x06counts\x18\x01 \x03(\v2\x1a.geostreamdb.PingAreaCountR\x06counts\"?\n" +
+	"\x06counts\x18\x01 \x03(\v2\x1a.geostreamdb.PingAreaCountR\x06counts\x122\n" +
+	"\x06frames\x18\x02 \x03(\v2\x1a.geostreamdb.PingAreaFrameR\x06frames\"U\n" +
+	"\rPingAreaFrame\x12\x10\n" +
+	"\x03end\x18\x01 \x01(\x03R\x03end\x122\n" +
+	"\x06counts\x18\x02 \x03(\v2\x1a.geostreamdb.PingAreaCountR\x06counts\"r\n" +
 	"\rPingAreaCount\x12\x18\n" +
 	"\ageohash\x18\x01 \x01(\tR\ageohash\x12\x14\n" +
-	"\x05count\x18\x02 \x01(\x03R\x05count2\xea\x01\n" +
+	"\x05count\x18\x02 \x01(\x03R\x05count\x121\n" +
+	"\bmovement\x18\x03 \x01(\v2\x15.geostreamdb.MovementR\bmovement\"\xb1\x01\n" +
+	"\bMovement\x12\x16\n" +
+	"\x06moving\x18\x01 \x01(\x03R\x06moving\x12\x1e\n" +
+	"\n" +
+	"stationary\x18\x02 \x01(\x03R\n" +
+	"stationary\x12\x1b\n" +
+	"\tspeed_sum\x18\x03 \x01(\x01R\bspeedSum\x12\x1b\n" +
+	"\theading_x\x18\x04 \x01(\x01R\bheadingX\x12\x1b\n" +
+	"\theading_y\x18\x05 \x01(\x01R\bheadingY\x12\x16\n" +
+	"\x06headed\x18\x06 \x01(\x03R\x06headed\",\n" +
+	"\x06LatLng\x12\x10\n" +
+	"\x03lat\x18\x01 \x01(\x01R\x03lat\x12\x10\n" +
+	"\x03lng\x18\x02 \x01(\x01R\x03lng\"i\n" +
+	"\x15CountInPolygonRequest\x12/\n" +
+	"\bvertices\x18\x01 \x03(\v2\x13.geostreamdb.LatLngR\bvertices\x12\x1f\n" +
+	"\vapi_version\x18\x02 \x01(\rR\n" +
+	"apiVersion\".\n" +
+	"\x16CountInPolygonResponse\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x03R\x05count\"\x83\x01\n" +
+	"\x15GetDevicePingsRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\x12\x1f\n" +
+	"\vapi_version\x18\x03 \x01(\rR\n" +
+	"apiVersion\x12\x16\n" +
+	"\x06tenant\x18\x04 \x01(\tR\x06tenant\"D\n" +
+	"\x16GetDevicePingsResponse\x12*\n" +
+	"\x05pings\x18\x01 \x03(\v2\x14.geostreamdb.RawPingR\x05pings\"q\n" +
+	"\x13DeleteDeviceRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x1f\n" +
+	"\vapi_version\x18\x02 \x01(\rR\n" +
+	"apiVersion\x12\x1c\n" +
+	"\tforwarded\x18\x03 \x01(\bR\tforwarded\"\x9d\x01\n" +
+	"\x14DeleteDeviceResponse\x12\x1b\n" +
+	"\traw_pings\x18\x01 \x01(\x03R\brawPings\x12#\n" +
+	"\rdedup_windows\x18\x02 \x01(\x03R\fdedupWindows\x12)\n" +
+	"\x10tombstoned_until\x18\x03 \x01(\x03R\x0ftombstonedUntil\x12\x18\n" +
+	"\astandby\x18\x04 \x01(\tR\astandby\"]\n" +
+	"\aRawPing\x12\x18\n" +
+	"\ageohash\x18\x01 \x01(\tR\ageohash\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x1a\n" +
+	"\bteleport\x18\x03 \x01(\bR\bteleport\"\xa7\x01\n" +
+	"\x12CheckMotionRequest\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12\x18\n" +
+	"\ageohash\x18\x02 \x01(\tR\ageohash\x12\x1c\n" +
+	"\ttimestamp\x18\x03 \x01(\x03R\ttimestamp\x12\x1b\n" +
+	"\tmax_speed\x18\x04 \x01(\x01R\bmaxSpeed\x12\x1f\n" +
+	"\vapi_version\x18\x05 \x01(\rR\n" +
+	"apiVersion\"G\n" +
+	"\x13CheckMotionResponse\x12\x1a\n" +
+	"\bteleport\x18\x01 \x01(\bR\bteleport\x12\x14\n" +
+	"\x05speed\x18\x02 \x01(\x01R\x05speed\"V\n" +
+	"\x0eGetInfoRequest\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\rR\n" +
+	"apiVersion\x12#\n" +
+	"\rprefix_length\x18\x02 \x01(\x05R\fprefixLength\"\x84\x04\n" +
+	"\x0fGetInfoResponse\x12\x1b\n" +
+	"\tworker_id\x18\x01 \x01(\tR\bworkerId\x12\x1f\n" +
+	"\vapi_version\x18\x02 \x01(\rR\n" +
+	"apiVersion\x12&\n" +
+	"\x0fmin_api_version\x18\x03 \x01(\rR\rminApiVersion\x12\x1d\n" +
+	"\n" +
+	"started_at\x18\x04 \x01(\x03R\tstartedAt\x12@\n" +
+	"\x06config\x18\x05 \x03(\v2(.geostreamdb.GetInfoResponse.ConfigEntryR\x06config\x120\n" +
+	"\x05slots\x18\x06 \x03(\v2\x1a.geostreamdb.SlotOccupancyR\x05slots\x12%\n" +
+	"\x0eowned_prefixes\x18\a \x03(\tR\rownedPrefixes\x12-\n" +
+	"\x12prefixes_truncated\x18\b \x01(\bR\x11prefixesTruncated\x12\x1f\n" +
+	"\vstandby_for\x18\t \x01(\tR\n" +
+	"standbyFor\x12#\n" +
+	"\rbuild_version\x18\n" +
+	" \x01(\tR\fbuildVersion\x12!\n" +
+	"\fbuild_commit\x18\v \x01(\tR\vbuildCommit\x1a9\n" +
+	"\vConfigEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xb6\x01\n" +
+	"\rSlotOccupancy\x12\x16\n" +
+	"\x06buffer\x18\x01 \x01(\tR\x06buffer\x12\x14\n" +
+	"\x05slots\x18\x02 \x01(\x05R\x05slots\x12\x1a\n" +
+	"\boccupied\x18\x03 \x01(\x05R\boccupied\x12\x1d\n" +
+	"\n" +
+	"trie_nodes\x18\x04 \x01(\x03R\ttrieNodes\x12\x1d\n" +
+	"\n" +
+	"trie_depth\x18\x05 \x01(\x05R\ttrieDepth\x12\x1d\n" +
+	"\n" +
+	"trie_bytes\x18\x06 \x01(\x03R\ttrieBytes\"\xe4\x02\n" +
+	"\x0fGenerateRequest\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\rR\n" +
+	"apiVersion\x12\x12\n" +
+	"\x04stop\x18\x02 \x01(\bR\x04stop\x12\"\n" +
+	"\fdistribution\x18\x03 \x01(\tR\fdistribution\x12\x17\n" +
+	"\amin_lat\x18\x04 \x01(\x01R\x06minLat\x12\x17\n" +
+	"\amax_lat\x18\x05 \x01(\x01R\x06maxLat\x12\x17\n" +
+	"\amin_lng\x18\x06 \x01(\x01R\x06minLng\x12\x17\n" +
+	"\amax_lng\x18\a \x01(\x01R\x06maxLng\x12\x12\n" +
+	"\x04rate\x18\b \x01(\x03R\x04rate\x12)\n" +
+	"\x10duration_seconds\x18\t \x01(\x03R\x0fdurationSeconds\x12\x12\n" +
+	"\x04seed\x18\n" +
+	" \x01(\x04R\x04seed\x12\x1a\n" +
+	"\bhotspots\x18\v \x01(\x05R\bhotspots\x12%\n" +
+	"\x0eperiod_seconds\x18\f \x01(\x03R\rperiodSeconds\"\x87\x01\n" +
+	"\x10GenerateResponse\x12\x18\n" +
+	"\arunning\x18\x01 \x01(\bR\arunning\x12\"\n" +
+	"\fdistribution\x18\x02 \x01(\tR\fdistribution\x12\x1c\n" +
+	"\tgenerated\x18\x03 \x01(\x03R\tgenerated\x12\x17\n" +
+	"\aends_at\x18\x04 \x01(\x03R\x06endsAt\"L\n" +
+	"\x13GetAnomaliesRequest\x12\x1f\n" +
+	"\vapi_version\x18\x01 \x01(\rR\n" +
+	"apiVersion\x12\x14\n" +
+	"\x05since\x18\x02 \x01(\x03R\x05since\"\xab\x01\n" +
+	"\aAnomaly\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x14\n" +
+	"\x05start\x18\x03 \x01(\x03R\x05start\x12\x10\n" +
+	"\x03end\x18\x04 \x01(\x03R\x03end\x12\x1a\n" +
+	"\bobserved\x18\x05 \x01(\x03R\bobserved\x12\x1a\n" +
+	"\bexpected\x18\x06 \x01(\x01R\bexpected\x12\x14\n" +
+	"\x05score\x18\a \x01(\x01R\x05score\"\xc9\x01\n" +
+	"\x14GetAnomaliesResponse\x12\x18\n" +
+	"\aenabled\x18\x01 \x01(\bR\aenabled\x12)\n" +
+	"\x10interval_seconds\x18\x02 \x01(\x03R\x0fintervalSeconds\x12\x1c\n" +
+	"\tprecision\x18\x03 \x01(\x05R\tprecision\x12\x1a\n" +
+	"\bbaseline\x18\x04 \x01(\tR\bbaseline\x122\n" +
+	"\tanomalies\x18\x05 \x03(\v2\x14.geostreamdb.AnomalyR\tanomalies2\xde\a\n" +
 	"\x06Worker\x12A\n" +
 	"\bSendPing\x12\x18.geostreamdb.PingRequest\x1a\x19.geostreamdb.PingResponse\"\x00\x12I\n" +
-	"\bGetPings\x12\x1c.geostreamdb.GetPingsRequest\x1a\x1d.geostreamdb.GetPingsResponse\"\x00\x12R\n" +
-	"\vGetPingArea\x12\x1f.geostreamdb.GetPingAreaRequest\x1a .geostreamdb.GetPingAreaResponse\"\x00B\x13Z\x11geostreamdb/protob\x06proto3"
+	"\bGetPings\x12\x1c.geostreamdb.GetPingsRequest\x1a\x1d.geostreamdb.GetPingsResponse\"\x00\x12X\n" +
+	"\rGetPingsBatch\x12!.geostreamdb.GetPingsBatchRequest\x1a\".geostreamdb.GetPingsBatchResponse\"\x00\x12I\n" +
+	"\bGetTotal\x12\x1c.geostreamdb.GetTotalRequest\x1a\x1d.geostreamdb.GetTotalResponse\"\x00\x12R\n" +
+	"\vGetPingArea\x12\x1f.geostreamdb.GetPingAreaRequest\x1a .geostreamdb.GetPingAreaResponse\"\x00\x12[\n" +
+	"\x0eCountInPolygon\x12\".geostreamdb.CountInPolygonRequest\x1a#.geostreamdb.CountInPolygonResponse\"\x00\x12[\n" +
+	"\x0eGetDevicePings\x12\".geostreamdb.GetDevicePingsRequest\x1a#.geostreamdb.GetDevicePingsResponse\"\x00\x12U\n" +
+	"\fDeleteDevice\x12 .geostreamdb.DeleteDeviceRequest\x1a!.geostreamdb.DeleteDeviceResponse\"\x00\x12F\n" +
+	"\aGetInfo\x12\x1b.geostreamdb.GetInfoRequest\x1a\x1c.geostreamdb.GetInfoResponse\"\x00\x12R\n" +
+	"\vCheckMotion\x12\x1f.geostreamdb.CheckMotionRequest\x1a .geostreamdb.CheckMotionResponse\"\x00\x12I\n" +
+	"\bGenerate\x12\x1c.geostreamdb.GenerateRequest\x1a\x1d.geostreamdb.GenerateResponse\"\x00\x12U\n" +
+	"\fGetAnomalies\x12 .geostreamdb.GetAnomaliesRequest\x1a!.geostreamdb.GetAnomaliesResponse\"\x00B\x13Z\x11geostreamdb/protob\x06proto3"
 
 var (
 	file_proto_ping_comm_proto_rawDescOnce sync.Once
@@ -437,29 +2591,82 @@ func file_proto_ping_comm_proto_rawDescGZIP() []byte {
 	return file_proto_ping_comm_proto_rawDescData
 }
 
-var file_proto_ping_comm_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_proto_ping_comm_proto_msgTypes = make([]protoimpl.MessageInfo, 33)
 var file_proto_ping_comm_proto_goTypes = []any{
-	(*PingRequest)(nil),         // 0: geostreamdb.PingRequest
-	(*PingResponse)(nil),        // 1: geostreamdb.PingResponse
-	(*GetPingsRequest)(nil),     // 2: geostreamdb.GetPingsRequest
-	(*GetPingsResponse)(nil),    // 3: geostreamdb.GetPingsResponse
-	(*GetPingAreaRequest)(nil),  // 4: geostreamdb.GetPingAreaRequest
-	(*GetPingAreaResponse)(nil), // 5: geostreamdb.GetPingAreaResponse
-	(*PingAreaCount)(nil),       // 6: geostreamdb.PingAreaCount
+	(*PingRequest)(nil),            // 0: geostreamdb.PingRequest
+	(*Motion)(nil),                 // 1: geostreamdb.Motion
+	(*PingResponse)(nil),           // 2: geostreamdb.PingResponse
+	(*GetPingsRequest)(nil),        // 3: geostreamdb.GetPingsRequest
+	(*GetPingsResponse)(nil),       // 4: geostreamdb.GetPingsResponse
+	(*GetPingsBatchRequest)(nil),   // 5: geostreamdb.GetPingsBatchRequest
+	(*GetPingsBatchResponse)(nil),  // 6: geostreamdb.GetPingsBatchResponse
+	(*GetTotalRequest)(nil),        // 7: geostreamdb.GetTotalRequest
+	(*GetTotalResponse)(nil),       // 8: geostreamdb.GetTotalResponse
+	(*GetPingAreaRequest)(nil),     // 9: geostreamdb.GetPingAreaRequest
+	(*GetPingAreaResponse)(nil),    // 10: geostreamdb.GetPingAreaResponse
+	(*PingAreaFrame)(nil),          // 11: geostreamdb.PingAreaFrame
+	(*PingAreaCount)(nil),          // 12: geostreamdb.PingAreaCount
+	(*Movement)(nil),               // 13: geostreamdb.Movement
+	(*LatLng)(nil),                 // 14: geostreamdb.LatLng
+	(*CountInPolygonRequest)(nil),  // 15: geostreamdb.CountInPolygonRequest
+	(*CountInPolygonResponse)(nil), // 16: geostreamdb.CountInPolygonResponse
+	(*GetDevicePingsRequest)(nil),  // 17: geostreamdb.GetDevicePingsRequest
+	(*GetDevicePingsResponse)(nil), // 18: geostreamdb.GetDevicePingsResponse
+	(*DeleteDeviceRequest)(nil),    // 19: geostreamdb.DeleteDeviceRequest
+	(*DeleteDeviceResponse)(nil),   // 20: geostreamdb.DeleteDeviceResponse
+	(*RawPing)(nil),                // 21: geostreamdb.RawPing
+	(*CheckMotionRequest)(nil),     // 22: geostreamdb.CheckMotionRequest
+	(*CheckMotionResponse)(nil),    // 23: geostreamdb.CheckMotionResponse
+	(*GetInfoRequest)(nil),         // 24: geostreamdb.GetInfoRequest
+	(*GetInfoResponse)(nil),        // 25: geostreamdb.GetInfoResponse
+	(*SlotOccupancy)(nil),          // 26: geostreamdb.SlotOccupancy
+	(*GenerateRequest)(nil),        // 27: geostreamdb.GenerateRequest
+	(*GenerateResponse)(nil),       // 28: geostreamdb.GenerateResponse
+	(*GetAnomaliesRequest)(nil),    // 29: geostreamdb.GetAnomaliesRequest
+	(*Anomaly)(nil),                // 30: geostreamdb.Anomaly
+	(*GetAnomaliesResponse)(nil),   // 31: geostreamdb.GetAnomaliesResponse
+	nil,                            // 32: geostreamdb.GetInfoResponse.ConfigEntry
 }
 var file_proto_ping_comm_proto_depIdxs = []int32{
-	6, // 0: geostreamdb.GetPingAreaResponse.counts:type_name -> geostreamdb.PingAreaCount
-	0, // 1: geostreamdb.Worker.SendPing:input_type -> geostreamdb.PingRequest
-	2, // 2: geostreamdb.Worker.GetPings:input_type -> geostreamdb.GetPingsRequest
-	4, // 3: geostreamdb.Worker.GetPingArea:input_type -> geostreamdb.GetPingAreaRequest
-	1, // 4: geostreamdb.Worker.SendPing:output_type -> geostreamdb.PingResponse
-	3, // 5: geostreamdb.Worker.GetPings:output_type -> geostreamdb.GetPingsResponse
-	5, // 6: geostreamdb.Worker.GetPingArea:output_type -> geostreamdb.GetPingAreaResponse
-	4, // [4:7] is the sub-list for method output_type
-	1, // [1:4] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1,  // 0: geostreamdb.PingRequest.motion:type_name -> geostreamdb.Motion
+	12, // 1: geostreamdb.GetPingAreaResponse.counts:type_name -> geostreamdb.PingAreaCount
+	11, // 2: geostreamdb.GetPingAreaResponse.frames:type_name -> geostreamdb.PingAreaFrame
+	12, // 3: geostreamdb.PingAreaFrame.counts:type_name -> geostreamdb.PingAreaCount
+	13, // 4: geostreamdb.PingAreaCount.movement:type_name -> geostreamdb.Movement
+	14, // 5: geostreamdb.CountInPolygonRequest.vertices:type_name -> geostreamdb.LatLng
+	21, // 6: geostreamdb.GetDevicePingsResponse.pings:type_name -> geostreamdb.RawPing
+	32, // 7: geostreamdb.GetInfoResponse.config:type_name -> geostreamdb.GetInfoResponse.ConfigEntry
+	26, // 8: geostreamdb.GetInfoResponse.slots:type_name -> geostreamdb.SlotOccupancy
+	30, // 9: geostreamdb.GetAnomaliesResponse.anomalies:type_name -> geostreamdb.Anomaly
+	0,  // 10: geostreamdb.Worker.SendPing:input_type -> geostreamdb.PingRequest
+	3,  // 11: geostreamdb.Worker.GetPings:input_type -> geostreamdb.GetPingsRequest
+	5,  // 12: geostreamdb.Worker.GetPingsBatch:input_type -> geostreamdb.GetPingsBatchRequest
+	7,  // 13: geostreamdb.Worker.GetTotal:input_type -> geostreamdb.GetTotalRequest
+	9,  // 14: geostreamdb.Worker.GetPingArea:input_type -> geostreamdb.GetPingAreaRequest
+	15, // 15: geostreamdb.Worker.CountInPolygon:input_type -> geostreamdb.CountInPolygonRequest
+	17, // 16: geostreamdb.Worker.GetDevicePings:input_type -> geostreamdb.GetDevicePingsRequest
+	19, // 17: geostreamdb.Worker.DeleteDevice:input_type -> geostreamdb.DeleteDeviceRequest
+	24, // 18: geostreamdb.Worker.GetInfo:input_type -> geostreamdb.GetInfoRequest
+	22, // 19: geostreamdb.Worker.CheckMotion:input_type -> geostreamdb.CheckMotionRequest
+	27, // 20: geostreamdb.Worker.Generate:input_type -> geostreamdb.GenerateRequest
+	29, // 21: geostreamdb.Worker.GetAnomalies:input_type -> geostreamdb.GetAnomaliesRequest
+	2,  // 22: geostreamdb.Worker.SendPing:output_type -> geostreamdb.PingResponse
+	4,  // 23: geostreamdb.Worker.GetPings:output_type -> geostreamdb.GetPingsResponse
+	6,  // 24: geostreamdb.Worker.GetPingsBatch:output_type -> geostreamdb.GetPingsBatchResponse
+	8,  // 25: geostreamdb.Worker.GetTotal:output_type -> geostreamdb.GetTotalResponse
+	10, // 26: geostreamdb.Worker.GetPingArea:output_type -> geostreamdb.GetPingAreaResponse
+	16, // 27: geostreamdb.Worker.CountInPolygon:output_type -> geostreamdb.CountInPolygonResponse
+	18, // 28: geostreamdb.Worker.GetDevicePings:output_type -> geostreamdb.GetDevicePingsResponse
+	20, // 29: geostreamdb.Worker.DeleteDevice:output_type -> geostreamdb.DeleteDeviceResponse
+	25, // 30: geostreamdb.Worker.GetInfo:output_type -> geostreamdb.GetInfoResponse
+	23, // 31: geostreamdb.Worker.CheckMotion:output_type -> geostreamdb.CheckMotionResponse
+	28, // 32: geostreamdb.Worker.Generate:output_type -> geostreamdb.GenerateResponse
+	31, // 33: geostreamdb.Worker.GetAnomalies:output_type -> geostreamdb.GetAnomaliesResponse
+	22, // [22:34] is the sub-list for method output_type
+	10, // [10:22] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_proto_ping_comm_proto_init() }
@@ -473,7 +2680,7 @@ func file_proto_ping_comm_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_ping_comm_proto_rawDesc), len(file_proto_ping_comm_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   7,
+			NumMessages:   33,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
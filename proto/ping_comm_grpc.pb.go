@@ -19,9 +19,18 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Worker_SendPing_FullMethodName    = "/geostreamdb.Worker/SendPing"
-	Worker_GetPings_FullMethodName    = "/geostreamdb.Worker/GetPings"
-	Worker_GetPingArea_FullMethodName = "/geostreamdb.Worker/GetPingArea"
+	Worker_SendPing_FullMethodName       = "/geostreamdb.Worker/SendPing"
+	Worker_GetPings_FullMethodName       = "/geostreamdb.Worker/GetPings"
+	Worker_GetPingsBatch_FullMethodName  = "/geostreamdb.Worker/GetPingsBatch"
+	Worker_GetTotal_FullMethodName       = "/geostreamdb.Worker/GetTotal"
+	Worker_GetPingArea_FullMethodName    = "/geostreamdb.Worker/GetPingArea"
+	Worker_CountInPolygon_FullMethodName = "/geostreamdb.Worker/CountInPolygon"
+	Worker_GetDevicePings_FullMethodName = "/geostreamdb.Worker/GetDevicePings"
+	Worker_DeleteDevice_FullMethodName   = "/geostreamdb.Worker/DeleteDevice"
+	Worker_GetInfo_FullMethodName        = "/geostreamdb.Worker/GetInfo"
+	Worker_CheckMotion_FullMethodName    = "/geostreamdb.Worker/CheckMotion"
+	Worker_Generate_FullMethodName       = "/geostreamdb.Worker/Generate"
+	Worker_GetAnomalies_FullMethodName   = "/geostreamdb.Worker/GetAnomalies"
 )
 
 // WorkerClient is the client API for Worker service.
@@ -30,7 +39,16 @@ const (
 type WorkerClient interface {
 	SendPing(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
 	GetPings(ctx context.Context, in *GetPingsRequest, opts ...grpc.CallOption) (*GetPingsResponse, error)
+	GetPingsBatch(ctx context.Context, in *GetPingsBatchRequest, opts ...grpc.CallOption) (*GetPingsBatchResponse, error)
+	GetTotal(ctx context.Context, in *GetTotalRequest, opts ...grpc.CallOption) (*GetTotalResponse, error)
 	GetPingArea(ctx context.Context, in *GetPingAreaRequest, opts ...grpc.CallOption) (*GetPingAreaResponse, error)
+	CountInPolygon(ctx context.Context, in *CountInPolygonRequest, opts ...grpc.CallOption) (*CountInPolygonResponse, error)
+	GetDevicePings(ctx context.Context, in *GetDevicePingsRequest, opts ...grpc.CallOption) (*GetDevicePingsResponse, error)
+	DeleteDevice(ctx context.Context, in *DeleteDeviceRequest, opts ...grpc.CallOption) (*DeleteDeviceResponse, error)
+	GetInfo(ctx context.Context, in *GetInfoRequest, opts ...grpc.CallOption) (*GetInfoResponse, error)
+	CheckMotion(ctx context.Context, in *CheckMotionRequest, opts ...grpc.CallOption) (*CheckMotionResponse, error)
+	Generate(ctx context.Context, in *GenerateRequest, opts ...grpc.CallOption) (*GenerateResponse, error)
+	GetAnomalies(ctx context.Context, in *GetAnomaliesRequest, opts ...grpc.CallOption) (*GetAnomaliesResponse, error)
 }
 
 type workerClient struct {
@@ -61,6 +79,26 @@ func (c *workerClient) GetPings(ctx context.Context, in *GetPingsRequest, opts .
 	return out, nil
 }
 
+func (c *workerClient) GetPingsBatch(ctx context.Context, in *GetPingsBatchRequest, opts ...grpc.CallOption) (*GetPingsBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetPingsBatchResponse)
+	err := c.cc.Invoke(ctx, Worker_GetPingsBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerClient) GetTotal(ctx context.Context, in *GetTotalRequest, opts ...grpc.CallOption) (*GetTotalResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTotalResponse)
+	err := c.cc.Invoke(ctx, Worker_GetTotal_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *workerClient) GetPingArea(ctx context.Context, in *GetPingAreaRequest, opts ...grpc.CallOption) (*GetPingAreaResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(GetPingAreaResponse)
@@ -71,13 +109,92 @@ func (c *workerClient) GetPingArea(ctx context.Context, in *GetPingAreaRequest,
 	return out, nil
 }
 
+func (c *workerClient) CountInPolygon(ctx context.Context, in *CountInPolygonRequest, opts ...grpc.CallOption) (*CountInPolygonResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CountInPolygonResponse)
+	err := c.cc.Invoke(ctx, Worker_CountInPolygon_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerClient) GetDevicePings(ctx context.Context, in *GetDevicePingsRequest, opts ...grpc.CallOption) (*GetDevicePingsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetDevicePingsResponse)
+	err := c.cc.Invoke(ctx, Worker_GetDevicePings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerClient) DeleteDevice(ctx context.Context, in *DeleteDeviceRequest, opts ...grpc.CallOption) (*DeleteDeviceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteDeviceResponse)
+	err := c.cc.Invoke(ctx, Worker_DeleteDevice_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerClient) GetInfo(ctx context.Context, in *GetInfoRequest, opts ...grpc.CallOption) (*GetInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetInfoResponse)
+	err := c.cc.Invoke(ctx, Worker_GetInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerClient) CheckMotion(ctx context.Context, in *CheckMotionRequest, opts ...grpc.CallOption) (*CheckMotionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CheckMotionResponse)
+	err := c.cc.Invoke(ctx, Worker_CheckMotion_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerClient) Generate(ctx context.Context, in *GenerateRequest, opts ...grpc.CallOption) (*GenerateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GenerateResponse)
+	err := c.cc.Invoke(ctx, Worker_Generate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerClient) GetAnomalies(ctx context.Context, in *GetAnomaliesRequest, opts ...grpc.CallOption) (*GetAnomaliesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetAnomaliesResponse)
+	err := c.cc.Invoke(ctx, Worker_GetAnomalies_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // WorkerServer is the server API for Worker service.
 // All implementations must embed UnimplementedWorkerServer
 // for forward compatibility.
 type WorkerServer interface {
 	SendPing(context.Context, *PingRequest) (*PingResponse, error)
 	GetPings(context.Context, *GetPingsRequest) (*GetPingsResponse, error)
+	GetPingsBatch(context.Context, *GetPingsBatchRequest) (*GetPingsBatchResponse, error)
+	GetTotal(context.Context, *GetTotalRequest) (*GetTotalResponse, error)
 	GetPingArea(context.Context, *GetPingAreaRequest) (*GetPingAreaResponse, error)
+	CountInPolygon(context.Context, *CountInPolygonRequest) (*CountInPolygonResponse, error)
+	GetDevicePings(context.Context, *GetDevicePingsRequest) (*GetDevicePingsResponse, error)
+	DeleteDevice(context.Context, *DeleteDeviceRequest) (*DeleteDeviceResponse, error)
+	GetInfo(context.Context, *GetInfoRequest) (*GetInfoResponse, error)
+	CheckMotion(context.Context, *CheckMotionRequest) (*CheckMotionResponse, error)
+	Generate(context.Context, *GenerateRequest) (*GenerateResponse, error)
+	GetAnomalies(context.Context, *GetAnomaliesRequest) (*GetAnomaliesResponse, error)
 	mustEmbedUnimplementedWorkerServer()
 }
 
@@ -94,9 +211,36 @@ func (UnimplementedWorkerServer) SendPing(context.Context, *PingRequest) (*PingR
 func (UnimplementedWorkerServer) GetPings(context.Context, *GetPingsRequest) (*GetPingsResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method GetPings not implemented")
 }
+func (UnimplementedWorkerServer) GetPingsBatch(context.Context, *GetPingsBatchRequest) (*GetPingsBatchResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPingsBatch not implemented")
+}
+func (UnimplementedWorkerServer) GetTotal(context.Context, *GetTotalRequest) (*GetTotalResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetTotal not implemented")
+}
 func (UnimplementedWorkerServer) GetPingArea(context.Context, *GetPingAreaRequest) (*GetPingAreaResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method GetPingArea not implemented")
 }
+func (UnimplementedWorkerServer) CountInPolygon(context.Context, *CountInPolygonRequest) (*CountInPolygonResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method CountInPolygon not implemented")
+}
+func (UnimplementedWorkerServer) GetDevicePings(context.Context, *GetDevicePingsRequest) (*GetDevicePingsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetDevicePings not implemented")
+}
+func (UnimplementedWorkerServer) DeleteDevice(context.Context, *DeleteDeviceRequest) (*DeleteDeviceResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method DeleteDevice not implemented")
+}
+func (UnimplementedWorkerServer) GetInfo(context.Context, *GetInfoRequest) (*GetInfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetInfo not implemented")
+}
+func (UnimplementedWorkerServer) CheckMotion(context.Context, *CheckMotionRequest) (*CheckMotionResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method CheckMotion not implemented")
+}
+func (UnimplementedWorkerServer) Generate(context.Context, *GenerateRequest) (*GenerateResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Generate not implemented")
+}
+func (UnimplementedWorkerServer) GetAnomalies(context.Context, *GetAnomaliesRequest) (*GetAnomaliesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetAnomalies not implemented")
+}
 func (UnimplementedWorkerServer) mustEmbedUnimplementedWorkerServer() {}
 func (UnimplementedWorkerServer) testEmbeddedByValue()                {}
 
@@ -154,6 +298,42 @@ func _Worker_GetPings_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Worker_GetPingsBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPingsBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).GetPingsBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_GetPingsBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).GetPingsBatch(ctx, req.(*GetPingsBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Worker_GetTotal_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTotalRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).GetTotal(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_GetTotal_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).GetTotal(ctx, req.(*GetTotalRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Worker_GetPingArea_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetPingAreaRequest)
 	if err := dec(in); err != nil {
@@ -172,6 +352,132 @@ func _Worker_GetPingArea_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Worker_CountInPolygon_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountInPolygonRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).CountInPolygon(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_CountInPolygon_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).CountInPolygon(ctx, req.(*CountInPolygonRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Worker_GetDevicePings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDevicePingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).GetDevicePings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_GetDevicePings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).GetDevicePings(ctx, req.(*GetDevicePingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Worker_DeleteDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).DeleteDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_DeleteDevice_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).DeleteDevice(ctx, req.(*DeleteDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Worker_GetInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).GetInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_GetInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).GetInfo(ctx, req.(*GetInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Worker_CheckMotion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckMotionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).CheckMotion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_CheckMotion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).CheckMotion(ctx, req.(*CheckMotionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Worker_Generate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenerateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).Generate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_Generate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).Generate(ctx, req.(*GenerateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Worker_GetAnomalies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAnomaliesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServer).GetAnomalies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Worker_GetAnomalies_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServer).GetAnomalies(ctx, req.(*GetAnomaliesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Worker_ServiceDesc is the grpc.ServiceDesc for Worker service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -187,10 +493,46 @@ var Worker_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetPings",
 			Handler:    _Worker_GetPings_Handler,
 		},
+		{
+			MethodName: "GetPingsBatch",
+			Handler:    _Worker_GetPingsBatch_Handler,
+		},
+		{
+			MethodName: "GetTotal",
+			Handler:    _Worker_GetTotal_Handler,
+		},
 		{
 			MethodName: "GetPingArea",
 			Handler:    _Worker_GetPingArea_Handler,
 		},
+		{
+			MethodName: "CountInPolygon",
+			Handler:    _Worker_CountInPolygon_Handler,
+		},
+		{
+			MethodName: "GetDevicePings",
+			Handler:    _Worker_GetDevicePings_Handler,
+		},
+		{
+			MethodName: "DeleteDevice",
+			Handler:    _Worker_DeleteDevice_Handler,
+		},
+		{
+			MethodName: "GetInfo",
+			Handler:    _Worker_GetInfo_Handler,
+		},
+		{
+			MethodName: "CheckMotion",
+			Handler:    _Worker_CheckMotion_Handler,
+		},
+		{
+			MethodName: "Generate",
+			Handler:    _Worker_Generate_Handler,
+		},
+		{
+			MethodName: "GetAnomalies",
+			Handler:    _Worker_GetAnomalies_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/ping_comm.proto",
@@ -0,0 +1,24 @@
+package proto
+
+// protocol versioning across gateway, worker and registry builds. heartbeats carry the range of versions the sender
+// understands and their responses the receiver's, so peers agree on the newest common version (or refuse each other)
+// instead of silently misreading fields during a rolling upgrade. worker RPC requests carry the version negotiated with
+// that worker. 0 is a peer from before versioning (fields unset)
+//
+// bump API_VERSION with any change a peer has to understand, and raise MIN_API_VERSION when dropping support for one
+const (
+	API_VERSION     uint32 = 1
+	MIN_API_VERSION uint32 = 0
+)
+
+// NegotiateAPIVersion returns the newest version both this build and a peer supporting [peerMin, peerMax] speak.
+// ok is false if the ranges don't overlap
+func NegotiateAPIVersion(peerMin, peerMax uint32) (version uint32, ok bool) {
+	version = min(API_VERSION, peerMax)
+	return version, version >= max(MIN_API_VERSION, peerMin)
+}
+
+// SupportsAPIVersion reports whether a request sent at version v is understood by this build
+func SupportsAPIVersion(v uint32) bool {
+	return v >= MIN_API_VERSION && v <= API_VERSION
+}
@@ -22,9 +22,26 @@ const (
 )
 
 type HeartbeatRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	WorkerId      string                 `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
-	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	WorkerId        string                 `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Address         string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	CoverageBloom   []byte                 `protobuf:"bytes,3,opt,name=coverage_bloom,json=coverageBloom,proto3" json:"coverage_bloom,omitempty"`         // bloom filter of geohash prefixes (below sharding precision) with data in the current TTL window
+	CoverageHashes  uint32                 `protobuf:"varint,4,opt,name=coverage_hashes,json=coverageHashes,proto3" json:"coverage_hashes,omitempty"`     // number of hash functions used by coverage_bloom
+	SentAt          int64                  `protobuf:"varint,5,opt,name=sent_at,json=sentAt,proto3" json:"sent_at,omitempty"`                             // worker clock when sent (unix ms), used by gateways to estimate clock skew
+	ApiVersion      uint32                 `protobuf:"varint,6,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`                 // newest protocol version the worker speaks (0 = sent before versioning, see version.go)
+	MinApiVersion   uint32                 `protobuf:"varint,7,opt,name=min_api_version,json=minApiVersion,proto3" json:"min_api_version,omitempty"`      // oldest protocol version the worker still understands
+	StandbyFor      string                 `protobuf:"bytes,8,opt,name=standby_for,json=standbyFor,proto3" json:"standby_for,omitempty"`                  // address of the primary this worker is a warm standby of (not forwarded to gateways until promoted)
+	BuildVersion    string                 `protobuf:"bytes,9,opt,name=build_version,json=buildVersion,proto3" json:"build_version,omitempty"`            // release of the worker build (set at link time, "dev" otherwise)
+	Draining        bool                   `protobuf:"varint,10,opt,name=draining,proto3" json:"draining,omitempty"`                                      // set by the registry during a rolling restart: gateways stop routing to the worker
+	SettingsVersion uint64                 `protobuf:"varint,11,opt,name=settings_version,json=settingsVersion,proto3" json:"settings_version,omitempty"` // version of the cluster settings the worker runs with (0 = none from the registry)
+	Capacity        *WorkerCapacity        `protobuf:"bytes,12,opt,name=capacity,proto3" json:"capacity,omitempty"`                                       // latest resource sample of the worker (unset before the first one)
+	// one more per heartbeat sent (0 = sent before sequencing), so receivers see missed and reordered beats. restarts
+	// at 1 with a new seq_epoch (the process start, unix ms)
+	Seq      uint64 `protobuf:"varint,13,opt,name=seq,proto3" json:"seq,omitempty"`
+	SeqEpoch int64  `protobuf:"varint,14,opt,name=seq_epoch,json=seqEpoch,proto3" json:"seq_epoch,omitempty"`
+	// optional capabilities of the worker's build and configuration (see features.go). empty from workers sent before
+	// feature lists, which gateways assume support everything
+	Features      []string `protobuf:"bytes,15,rep,name=features,proto3" json:"features,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -73,16 +90,224 @@ func (x *HeartbeatRequest) GetAddress() string {
 	return ""
 }
 
+func (x *HeartbeatRequest) GetCoverageBloom() []byte {
+	if x != nil {
+		return x.CoverageBloom
+	}
+	return nil
+}
+
+func (x *HeartbeatRequest) GetCoverageHashes() uint32 {
+	if x != nil {
+		return x.CoverageHashes
+	}
+	return 0
+}
+
+func (x *HeartbeatRequest) GetSentAt() int64 {
+	if x != nil {
+		return x.SentAt
+	}
+	return 0
+}
+
+func (x *HeartbeatRequest) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *HeartbeatRequest) GetMinApiVersion() uint32 {
+	if x != nil {
+		return x.MinApiVersion
+	}
+	return 0
+}
+
+func (x *HeartbeatRequest) GetStandbyFor() string {
+	if x != nil {
+		return x.StandbyFor
+	}
+	return ""
+}
+
+func (x *HeartbeatRequest) GetBuildVersion() string {
+	if x != nil {
+		return x.BuildVersion
+	}
+	return ""
+}
+
+func (x *HeartbeatRequest) GetDraining() bool {
+	if x != nil {
+		return x.Draining
+	}
+	return false
+}
+
+func (x *HeartbeatRequest) GetSettingsVersion() uint64 {
+	if x != nil {
+		return x.SettingsVersion
+	}
+	return 0
+}
+
+func (x *HeartbeatRequest) GetCapacity() *WorkerCapacity {
+	if x != nil {
+		return x.Capacity
+	}
+	return nil
+}
+
+func (x *HeartbeatRequest) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *HeartbeatRequest) GetSeqEpoch() int64 {
+	if x != nil {
+		return x.SeqEpoch
+	}
+	return 0
+}
+
+func (x *HeartbeatRequest) GetFeatures() []string {
+	if x != nil {
+		return x.Features
+	}
+	return nil
+}
+
+// resources a worker uses, sampled every CAPACITY_INTERVAL (see worker-node/capacity.go)
+type WorkerCapacity struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	SampledAt          int64                  `protobuf:"varint,1,opt,name=sampled_at,json=sampledAt,proto3" json:"sampled_at,omitempty"`                        // unix ms
+	ResidentBytes      uint64                 `protobuf:"varint,2,opt,name=resident_bytes,json=residentBytes,proto3" json:"resident_bytes,omitempty"`            // process resident memory (0 where /proc isn't available)
+	HeapBytes          uint64                 `protobuf:"varint,3,opt,name=heap_bytes,json=heapBytes,proto3" json:"heap_bytes,omitempty"`                        // Go heap in use
+	MemoryLimitBytes   uint64                 `protobuf:"varint,4,opt,name=memory_limit_bytes,json=memoryLimitBytes,proto3" json:"memory_limit_bytes,omitempty"` // GOMEMLIMIT (0 = none)
+	CpuCores           float64                `protobuf:"fixed64,5,opt,name=cpu_cores,json=cpuCores,proto3" json:"cpu_cores,omitempty"`                          // CPU time used per second since the previous sample
+	MaxProcs           uint32                 `protobuf:"varint,6,opt,name=max_procs,json=maxProcs,proto3" json:"max_procs,omitempty"`                           // GOMAXPROCS
+	Goroutines         uint32                 `protobuf:"varint,7,opt,name=goroutines,proto3" json:"goroutines,omitempty"`
+	BufferBytes        uint64                 `protobuf:"varint,8,opt,name=buffer_bytes,json=bufferBytes,proto3" json:"buffer_bytes,omitempty"`                        // estimated memory of the time buffers' live tries (primary and replica)
+	ReplicaBufferBytes uint64                 `protobuf:"varint,9,opt,name=replica_buffer_bytes,json=replicaBufferBytes,proto3" json:"replica_buffer_bytes,omitempty"` // of which the replica buffer
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *WorkerCapacity) Reset() {
+	*x = WorkerCapacity{}
+	mi := &file_proto_worker_discovery_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkerCapacity) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerCapacity) ProtoMessage() {}
+
+func (x *WorkerCapacity) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_worker_discovery_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerCapacity.ProtoReflect.Descriptor instead.
+func (*WorkerCapacity) Descriptor() ([]byte, []int) {
+	return file_proto_worker_discovery_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *WorkerCapacity) GetSampledAt() int64 {
+	if x != nil {
+		return x.SampledAt
+	}
+	return 0
+}
+
+func (x *WorkerCapacity) GetResidentBytes() uint64 {
+	if x != nil {
+		return x.ResidentBytes
+	}
+	return 0
+}
+
+func (x *WorkerCapacity) GetHeapBytes() uint64 {
+	if x != nil {
+		return x.HeapBytes
+	}
+	return 0
+}
+
+func (x *WorkerCapacity) GetMemoryLimitBytes() uint64 {
+	if x != nil {
+		return x.MemoryLimitBytes
+	}
+	return 0
+}
+
+func (x *WorkerCapacity) GetCpuCores() float64 {
+	if x != nil {
+		return x.CpuCores
+	}
+	return 0
+}
+
+func (x *WorkerCapacity) GetMaxProcs() uint32 {
+	if x != nil {
+		return x.MaxProcs
+	}
+	return 0
+}
+
+func (x *WorkerCapacity) GetGoroutines() uint32 {
+	if x != nil {
+		return x.Goroutines
+	}
+	return 0
+}
+
+func (x *WorkerCapacity) GetBufferBytes() uint64 {
+	if x != nil {
+		return x.BufferBytes
+	}
+	return 0
+}
+
+func (x *WorkerCapacity) GetReplicaBufferBytes() uint64 {
+	if x != nil {
+		return x.ReplicaBufferBytes
+	}
+	return 0
+}
+
 type HeartbeatResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Acknowledged  bool                   `protobuf:"varint,1,opt,name=acknowledged,proto3" json:"acknowledged,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Acknowledged    bool                   `protobuf:"varint,1,opt,name=acknowledged,proto3" json:"acknowledged,omitempty"`
+	ApiVersion      uint32                 `protobuf:"varint,2,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"` // range supported by the receiver
+	MinApiVersion   uint32                 `protobuf:"varint,3,opt,name=min_api_version,json=minApiVersion,proto3" json:"min_api_version,omitempty"`
+	PromoteAs       string                 `protobuf:"bytes,4,opt,name=promote_as,json=promoteAs,proto3" json:"promote_as,omitempty"`                     // set by the registry once a standby's primary misses heartbeats: the worker id to take over
+	Draining        bool                   `protobuf:"varint,5,opt,name=draining,proto3" json:"draining,omitempty"`                                       // the worker is being drained for a rolling restart
+	Restart         bool                   `protobuf:"varint,6,opt,name=restart,proto3" json:"restart,omitempty"`                                         // drained: the worker should exit, to be restarted by its supervisor
+	GatewayIds      []string               `protobuf:"bytes,7,rep,name=gateway_ids,json=gatewayIds,proto3" json:"gateway_ids,omitempty"`                  // gateways registered with the registry, the callers a worker with WORKER_AUTH accepts
+	PrimaryWorkerId string                 `protobuf:"bytes,8,opt,name=primary_worker_id,json=primaryWorkerId,proto3" json:"primary_worker_id,omitempty"` // to a standby: the worker id of its primary, accepted as the caller mirroring pings
+	Settings        *ClusterSettings       `protobuf:"bytes,9,opt,name=settings,proto3" json:"settings,omitempty"`                                        // current cluster settings (unset if the registry distributes none)
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
 }
 
 func (x *HeartbeatResponse) Reset() {
 	*x = HeartbeatResponse{}
-	mi := &file_proto_worker_discovery_proto_msgTypes[1]
+	mi := &file_proto_worker_discovery_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -94,7 +319,7 @@ func (x *HeartbeatResponse) String() string {
 func (*HeartbeatResponse) ProtoMessage() {}
 
 func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_worker_discovery_proto_msgTypes[1]
+	mi := &file_proto_worker_discovery_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -107,7 +332,7 @@ func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use HeartbeatResponse.ProtoReflect.Descriptor instead.
 func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
-	return file_proto_worker_discovery_proto_rawDescGZIP(), []int{1}
+	return file_proto_worker_discovery_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *HeartbeatResponse) GetAcknowledged() bool {
@@ -117,16 +342,113 @@ func (x *HeartbeatResponse) GetAcknowledged() bool {
 	return false
 }
 
+func (x *HeartbeatResponse) GetApiVersion() uint32 {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return 0
+}
+
+func (x *HeartbeatResponse) GetMinApiVersion() uint32 {
+	if x != nil {
+		return x.MinApiVersion
+	}
+	return 0
+}
+
+func (x *HeartbeatResponse) GetPromoteAs() string {
+	if x != nil {
+		return x.PromoteAs
+	}
+	return ""
+}
+
+func (x *HeartbeatResponse) GetDraining() bool {
+	if x != nil {
+		return x.Draining
+	}
+	return false
+}
+
+func (x *HeartbeatResponse) GetRestart() bool {
+	if x != nil {
+		return x.Restart
+	}
+	return false
+}
+
+func (x *HeartbeatResponse) GetGatewayIds() []string {
+	if x != nil {
+		return x.GatewayIds
+	}
+	return nil
+}
+
+func (x *HeartbeatResponse) GetPrimaryWorkerId() string {
+	if x != nil {
+		return x.PrimaryWorkerId
+	}
+	return ""
+}
+
+func (x *HeartbeatResponse) GetSettings() *ClusterSettings {
+	if x != nil {
+		return x.Settings
+	}
+	return nil
+}
+
 var File_proto_worker_discovery_proto protoreflect.FileDescriptor
 
 const file_proto_worker_discovery_proto_rawDesc = "" +
 	"\n" +
-	"\x1cproto/worker_discovery.proto\x12\vgeostreamdb\"I\n" +
+	"\x1cproto/worker_discovery.proto\x12\vgeostreamdb\x1a\x1dproto/gateway_discovery.proto\"\x8c\x04\n" +
 	"\x10HeartbeatRequest\x12\x1b\n" +
 	"\tworker_id\x18\x01 \x01(\tR\bworkerId\x12\x18\n" +
-	"\aaddress\x18\x02 \x01(\tR\aaddress\"7\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\x12%\n" +
+	"\x0ecoverage_bloom\x18\x03 \x01(\fR\rcoverageBloom\x12'\n" +
+	"\x0fcoverage_hashes\x18\x04 \x01(\rR\x0ecoverageHashes\x12\x17\n" +
+	"\asent_at\x18\x05 \x01(\x03R\x06sentAt\x12\x1f\n" +
+	"\vapi_version\x18\x06 \x01(\rR\n" +
+	"apiVersion\x12&\n" +
+	"\x0fmin_api_version\x18\a \x01(\rR\rminApiVersion\x12\x1f\n" +
+	"\vstandby_for\x18\b \x01(\tR\n" +
+	"standbyFor\x12#\n" +
+	"\rbuild_version\x18\t \x01(\tR\fbuildVersion\x12\x1a\n" +
+	"\bdraining\x18\n" +
+	" \x01(\bR\bdraining\x12)\n" +
+	"\x10settings_version\x18\v \x01(\x04R\x0fsettingsVersion\x127\n" +
+	"\bcapacity\x18\f \x01(\v2\x1b.geostreamdb.WorkerCapacityR\bcapacity\x12\x10\n" +
+	"\x03seq\x18\r \x01(\x04R\x03seq\x12\x1b\n" +
+	"\tseq_epoch\x18\x0e \x01(\x03R\bseqEpoch\x12\x1a\n" +
+	"\bfeatures\x18\x0f \x03(\tR\bfeatures\"\xd2\x02\n" +
+	"\x0eWorkerCapacity\x12\x1d\n" +
+	"\n" +
+	"sampled_at\x18\x01 \x01(\x03R\tsampledAt\x12%\n" +
+	"\x0eresident_bytes\x18\x02 \x01(\x04R\rresidentBytes\x12\x1d\n" +
+	"\n" +
+	"heap_bytes\x18\x03 \x01(\x04R\theapBytes\x12,\n" +
+	"\x12memory_limit_bytes\x18\x04 \x01(\x04R\x10memoryLimitBytes\x12\x1b\n" +
+	"\tcpu_cores\x18\x05 \x01(\x01R\bcpuCores\x12\x1b\n" +
+	"\tmax_procs\x18\x06 \x01(\rR\bmaxProcs\x12\x1e\n" +
+	"\n" +
+	"goroutines\x18\a \x01(\rR\n" +
+	"goroutines\x12!\n" +
+	"\fbuffer_bytes\x18\b \x01(\x04R\vbufferBytes\x120\n" +
+	"\x14replica_buffer_bytes\x18\t \x01(\x04R\x12replicaBufferBytes\"\xdc\x02\n" +
 	"\x11HeartbeatResponse\x12\"\n" +
-	"\facknowledged\x18\x01 \x01(\bR\facknowledged2W\n" +
+	"\facknowledged\x18\x01 \x01(\bR\facknowledged\x12\x1f\n" +
+	"\vapi_version\x18\x02 \x01(\rR\n" +
+	"apiVersion\x12&\n" +
+	"\x0fmin_api_version\x18\x03 \x01(\rR\rminApiVersion\x12\x1d\n" +
+	"\n" +
+	"promote_as\x18\x04 \x01(\tR\tpromoteAs\x12\x1a\n" +
+	"\bdraining\x18\x05 \x01(\bR\bdraining\x12\x18\n" +
+	"\arestart\x18\x06 \x01(\bR\arestart\x12\x1f\n" +
+	"\vgateway_ids\x18\a \x03(\tR\n" +
+	"gatewayIds\x12*\n" +
+	"\x11primary_worker_id\x18\b \x01(\tR\x0fprimaryWorkerId\x128\n" +
+	"\bsettings\x18\t \x01(\v2\x1c.geostreamdb.ClusterSettingsR\bsettings2W\n" +
 	"\aGateway\x12L\n" +
 	"\tHeartbeat\x12\x1d.geostreamdb.HeartbeatRequest\x1a\x1e.geostreamdb.HeartbeatResponse\"\x00B\x13Z\x11geostreamdb/protob\x06proto3"
 
@@ -142,19 +464,23 @@ func file_proto_worker_discovery_proto_rawDescGZIP() []byte {
 	return file_proto_worker_discovery_proto_rawDescData
 }
 
-var file_proto_worker_discovery_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_worker_discovery_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_proto_worker_discovery_proto_goTypes = []any{
 	(*HeartbeatRequest)(nil),  // 0: geostreamdb.HeartbeatRequest
-	(*HeartbeatResponse)(nil), // 1: geostreamdb.HeartbeatResponse
+	(*WorkerCapacity)(nil),    // 1: geostreamdb.WorkerCapacity
+	(*HeartbeatResponse)(nil), // 2: geostreamdb.HeartbeatResponse
+	(*ClusterSettings)(nil),   // 3: geostreamdb.ClusterSettings
 }
 var file_proto_worker_discovery_proto_depIdxs = []int32{
-	0, // 0: geostreamdb.Gateway.Heartbeat:input_type -> geostreamdb.HeartbeatRequest
-	1, // 1: geostreamdb.Gateway.Heartbeat:output_type -> geostreamdb.HeartbeatResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: geostreamdb.HeartbeatRequest.capacity:type_name -> geostreamdb.WorkerCapacity
+	3, // 1: geostreamdb.HeartbeatResponse.settings:type_name -> geostreamdb.ClusterSettings
+	0, // 2: geostreamdb.Gateway.Heartbeat:input_type -> geostreamdb.HeartbeatRequest
+	2, // 3: geostreamdb.Gateway.Heartbeat:output_type -> geostreamdb.HeartbeatResponse
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_proto_worker_discovery_proto_init() }
@@ -162,13 +488,14 @@ func file_proto_worker_discovery_proto_init() {
 	if File_proto_worker_discovery_proto != nil {
 		return
 	}
+	file_proto_gateway_discovery_proto_init()
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_worker_discovery_proto_rawDesc), len(file_proto_worker_discovery_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
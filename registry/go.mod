@@ -2,10 +2,16 @@ module registry
 
 go 1.25.4
 
-replace geostreamdb/proto => ../proto
+replace (
+	geostreamdb/env => ../env
+	geostreamdb/proto => ../proto
+	geostreamdb/rpc => ../rpc
+)
 
 require (
+	geostreamdb/env v0.0.0
 	geostreamdb/proto v0.0.0-00010101000000-000000000000
+	geostreamdb/rpc v0.0.0
 	github.com/prometheus/client_golang v1.23.2
 	google.golang.org/grpc v1.72.1
 )
@@ -5,16 +5,10 @@ import (
 	pb "geostreamdb/proto"
 	"log"
 	"time"
-)
 
-func observeGRPC(method string, err error, start time.Time) {
-	result := "success"
-	if err != nil {
-		result = "failure"
-	}
-	Metrics.gRPCRequestsTotal.WithLabelValues(method, result).Inc()
-	Metrics.gRPCLatency.WithLabelValues(method).Observe(time.Since(start).Seconds())
-}
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
 
 type gatewayHeartbeatServer struct {
 	pb.UnimplementedGatewayServer
@@ -25,6 +19,21 @@ func (s *gatewayHeartbeatServer) Heartbeat(ctx context.Context, req *pb.Heartbea
 
 	// log.Printf("received worker heartbeat from: %s (worker id: %s)", req.Address, req.WorkerId)
 
+	resp := &pb.HeartbeatResponse{Acknowledged: true, ApiVersion: pb.API_VERSION, MinApiVersion: pb.MIN_API_VERSION, Settings: currentSettings()}
+	observeSettingsVersion("worker", req.SettingsVersion)
+	observeHeartbeat("worker", req.Address, req.SeqEpoch, req.Seq)
+	if req.StandbyFor != "" {
+		recordWorker(req) // only listed (ListWorkers)
+		resp.PromoteAs, _ = standbyPromotion(req.StandbyFor, req.Address)
+		resp.PrimaryWorkerId = workerIdAt(req.StandbyFor)
+		return resp, nil // standbys stay out of the rings until promoted
+	}
+	if !recordWorker(req) {
+		return nil, status.Error(codes.FailedPrecondition, "replaced by its standby, restart to rejoin")
+	}
+	resp.Draining, resp.Restart = rollout.heartbeat(req.Address, req.WorkerId, req.SeqEpoch)
+	req.Draining = resp.Draining // forwarded: gateways route around a draining worker
+
 	connections := registryState.getAllConnections()
 	for _, conn := range connections {
 		client := pb.NewGatewayClient(conn)
@@ -33,12 +42,13 @@ func (s *gatewayHeartbeatServer) Heartbeat(ctx context.Context, req *pb.Heartbea
 		start := time.Now()
 		_, err := client.Heartbeat(timeoutCtx, req)
 		cancel()
-		observeGRPC("Gateway.Heartbeat", err, start)
+		grpcInterceptors.Observe("Gateway.Heartbeat.forward", err, start) // client side, "Gateway.Heartbeat" being the worker heartbeats served
 		if err != nil {
 			log.Printf("failed to forward heartbeat to gateway: %v", err)
 		}
 		// log.Printf("heartbeat forwarded to gateway: %s (worker id: %s)", conn.Target(), req.WorkerId)
 	}
 
-	return &pb.HeartbeatResponse{Acknowledged: true}, nil
+	resp.GatewayIds = registryState.getGatewayIds() // after forwarding: gateways newly knowing the worker are listed
+	return resp, nil
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// heartbeat sequence numbers: workers and gateways number their heartbeats (restarting at 1 with a new epoch when the
+// process restarts), so that the registry sees beats lost or reordered as they happen instead of only when a TTL
+// expires. gaps are logged, and counted with the reorderings per kind (worker/gateway) in
+// registry_heartbeats_missed_total/_out_of_order_total; registry_heartbeat_loss_ratio is the share missed per node over
+// about the last heartbeatLossWindow beats. same tracker as gateway/heartbeatseq.go, which sees the worker beats after
+// the registry forwarded them
+const heartbeatLossWindow = 100 // beats
+
+type heartbeatSeqs struct {
+	mu      sync.Mutex
+	senders map[string]*heartbeatSeq // by address
+}
+
+type heartbeatSeq struct {
+	epoch    int64
+	last     uint64
+	expected float64 // beats expected and missed, decayed past heartbeatLossWindow
+	missed   float64
+}
+
+type heartbeatGap struct {
+	missed     uint64 // beats skipped just before this one
+	outOfOrder bool   // at or below the last seq seen (late or repeated)
+	lossRatio  float64
+}
+
+func newHeartbeatSeqs() *heartbeatSeqs {
+	return &heartbeatSeqs{senders: make(map[string]*heartbeatSeq)}
+}
+
+// observe records beat seq of epoch from a sender, false for unsequenced beats (older builds)
+func (t *heartbeatSeqs) observe(sender string, epoch int64, seq uint64) (heartbeatGap, bool) {
+	if seq == 0 {
+		return heartbeatGap{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.senders[sender]
+	if !ok || s.epoch != epoch { // first beat seen, or the sender restarted: nothing to compare with
+		t.senders[sender] = &heartbeatSeq{epoch: epoch, last: seq, expected: 1}
+		return heartbeatGap{}, true
+	}
+	var gap heartbeatGap
+	if seq <= s.last {
+		gap.outOfOrder = true
+	} else {
+		gap.missed = seq - s.last - 1
+		s.last = seq
+		s.expected += float64(gap.missed + 1)
+		s.missed += float64(gap.missed)
+		if s.expected > heartbeatLossWindow {
+			scale := heartbeatLossWindow / s.expected
+			s.expected, s.missed = heartbeatLossWindow, s.missed*scale
+		}
+	}
+	gap.lossRatio = s.missed / s.expected
+	return gap, true
+}
+
+func (t *heartbeatSeqs) forget(sender string) {
+	t.mu.Lock()
+	delete(t.senders, sender)
+	t.mu.Unlock()
+}
+
+var heartbeatSeqsOf = map[string]*heartbeatSeqs{"worker": newHeartbeatSeqs(), "gateway": newHeartbeatSeqs()}
+
+// observeHeartbeat checks the sequence of a heartbeat from a node of a kind (worker/gateway)
+func observeHeartbeat(kind string, address string, epoch int64, seq uint64) {
+	gap, ok := heartbeatSeqsOf[kind].observe(address, epoch, seq)
+	if !ok {
+		return
+	}
+	if gap.missed > 0 {
+		Metrics.heartbeatsMissed.WithLabelValues(kind).Add(float64(gap.missed))
+		log.Printf("missed %d heartbeat(s) of %s %s before seq %d", gap.missed, kind, address, seq)
+	}
+	if gap.outOfOrder {
+		Metrics.heartbeatsOutOfOrder.WithLabelValues(kind).Inc()
+		log.Printf("out of order heartbeat of %s %s: seq %d", kind, address, seq)
+	}
+	Metrics.heartbeatLoss.WithLabelValues(kind, address).Set(gap.lossRatio)
+}
+
+func forgetHeartbeats(kind string, address string) {
+	heartbeatSeqsOf[kind].forget(address)
+	Metrics.heartbeatLoss.DeleteLabelValues(kind, address)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
@@ -24,11 +25,15 @@ func main() {
 	}
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
+		http.HandleFunc("/version", getVersion)
 		log.Fatal(http.ListenAndServe(":"+metricsPort, nil))
 	}()
 
+	log.Printf("registry %s (commit %s)", build.Version, build.Commit)
+
 	// (grpc server) worker heartbeat and gateway registration receiver
 	go registryState.cleanupDeadGateways(GATEWAY_CLEANUP_TTL, GATEWAY_CLEANUP_TICK_TIME)
+	go forgetWorkers()
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "50051"
@@ -38,9 +43,10 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpcInterceptors.ServerOptions()...)
 	pb.RegisterGatewayServer(s, &gatewayHeartbeatServer{}) // worker heartbeat receiver
 	pb.RegisterRegistryServer(s, &registryServer{})        // gateway registration receiver
+	rpc.RegisterDebugServices(s)
 	log.Printf("grpc server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// worker membership: besides the gateways it registers, the registry keeps the workers whose heartbeats it forwards
+// (standbys included, see standby.go), so that one scrape of its /metrics shows the whole cluster: registry_workers
+// counts the live primaries and registry_standby_workers the live standbys, and with WORKER_VISIBILITY each worker's
+// last heartbeat is exported as registry_worker_last_seen_timestamp_seconds{address,worker_id} (one series per
+// worker: turn it off for large clusters). the ListWorkers admin RPC lists them all
+var WORKER_VISIBILITY = env.Bool("WORKER_VISIBILITY", true)
+
+// observeWorkerSeen exports the heartbeat of a worker, previously known as previous (nil if new). workers.mu is held
+func observeWorkerSeen(address string, previous *workerEntry, w *workerEntry) {
+	if !WORKER_VISIBILITY {
+		return
+	}
+	if previous != nil && previous.workerId != w.workerId { // restarted: a new worker id
+		Metrics.workerLastSeen.DeleteLabelValues(address, previous.workerId)
+	}
+	Metrics.workerLastSeen.WithLabelValues(address, w.workerId).Set(float64(w.lastSeen.UnixMilli()) / 1000)
+}
+
+func forgetWorkerSeen(address string) {
+	Metrics.workerLastSeen.DeletePartialMatch(map[string]string{"address": address})
+}
+
+// countLiveWorkers returns the live primaries, or standbys
+func countLiveWorkers(standbys bool) int {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	n := 0
+	for _, w := range workers.byAddress {
+		if !w.promoted && (w.standbyFor != "") == standbys && time.Since(w.lastSeen) < workerLiveAfter {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *registryServer) ListWorkers(ctx context.Context, req *pb.ListWorkersRequest) (*pb.ListWorkersResponse, error) {
+	if err := checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	workers.mu.Lock()
+	resp := &pb.ListWorkersResponse{Workers: make([]*pb.RegisteredWorker, 0, len(workers.byAddress))}
+	for address, w := range workers.byAddress {
+		resp.Workers = append(resp.Workers, &pb.RegisteredWorker{
+			Address:      address,
+			WorkerId:     w.workerId,
+			LastSeen:     w.lastSeen.UnixMilli(),
+			Live:         !w.promoted && time.Since(w.lastSeen) < workerLiveAfter,
+			StandbyFor:   w.standbyFor,
+			Replaced:     w.promoted,
+			BuildVersion: w.buildVersion,
+			ApiVersion:   w.apiVersion,
+		})
+	}
+	workers.mu.Unlock()
+	sort.Slice(resp.Workers, func(i, j int) bool { return resp.Workers[i].Address < resp.Workers[j].Address })
+	return resp, nil
+}
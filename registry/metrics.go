@@ -1,14 +1,28 @@
 package main
 
 import (
+	"geostreamdb/rpc"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type metrics struct {
-	registeredGatewaysTotal prometheus.Gauge
-	gRPCRequestsTotal       *prometheus.CounterVec   // per method and result (success/failure)
-	gRPCLatency             *prometheus.HistogramVec // per method
+	registeredGatewaysTotal   prometheus.Gauge
+	incompatibleGateways      prometheus.Counter
+	standbyPromotionsTotal    prometheus.Counter
+	buildInfo                 *prometheus.GaugeVec   // per version, commit and go version (always 1)
+	rolloutRestartsTotal      *prometheus.CounterVec // per result (rejoined/failed)
+	staleSettingsHeartbeats   *prometheus.CounterVec // per node kind (gateway/worker)
+	shardingMigrationsTotal   prometheus.Counter
+	heartbeatsMissed          *prometheus.CounterVec // per node kind (gateway/worker)
+	heartbeatsOutOfOrder      *prometheus.CounterVec // per node kind
+	heartbeatLoss             *prometheus.GaugeVec   // per node kind and address
+	workers                   prometheus.GaugeFunc
+	standbyWorkers            prometheus.GaugeFunc
+	workerLastSeen            *prometheus.GaugeVec   // per address and worker id (WORKER_VISIBILITY)
+	gatewayRegistrationsTotal *prometheus.CounterVec // per kind (new/reregistered)
 }
 
 var Metrics = metrics{
@@ -16,13 +30,66 @@ var Metrics = metrics{
 		Name: "registry_registered_gateways_total",
 		Help: "Total count of registered gateways",
 	}),
-	gRPCRequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "registry_grpc_requests_total",
-		Help: "Total count of gRPC requests by method and result (success/failure)",
-	}, []string{"method", "result"}),
-	gRPCLatency: promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "registry_grpc_request_duration_seconds",
-		Help:    "gRPC request latency in seconds by method",
-		Buckets: prometheus.DefBuckets,
-	}, []string{"method"}),
+	incompatibleGateways: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "registry_incompatible_gateway_heartbeats_total",
+		Help: "Total count of gateway heartbeats refused for an api version range not overlapping the registry's",
+	}),
+	standbyPromotionsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "registry_standby_promotions_total",
+		Help: "Total count of warm standby workers promoted after their primary missed heartbeats",
+	}),
+	buildInfo: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "registry_build_info",
+		Help: "Build of this registry (always 1)",
+	}, []string{"version", "commit", "goversion"}),
+	rolloutRestartsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_rolling_restart_workers_total",
+		Help: "Workers restarted by rolling restarts, per result (rejoined/failed)",
+	}, []string{"result"}),
+	staleSettingsHeartbeats: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_stale_settings_heartbeats_total",
+		Help: "Heartbeats of gateways and workers running with other cluster settings than the registry's, per kind",
+	}, []string{"kind"}),
+	shardingMigrationsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "registry_sharding_migrations_total",
+		Help: "Sharding precision migrations started",
+	}),
+	heartbeatsMissed: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_heartbeats_missed_total",
+		Help: "Heartbeats of gateways and workers lost on the way (gaps in their sequence numbers), per kind",
+	}, []string{"kind"}),
+	heartbeatsOutOfOrder: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_heartbeats_out_of_order_total",
+		Help: "Heartbeats of gateways and workers received after a later one (or repeated), per kind",
+	}, []string{"kind"}),
+	heartbeatLoss: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "registry_heartbeat_loss_ratio",
+		Help: "Share of a node's heartbeats missed over about its last 100, per kind and address",
+	}, []string{"kind", "address"}),
+	workers: promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "registry_workers",
+		Help: "Primary workers heard from within the gateways' worker TTL",
+	}, func() float64 { return float64(countLiveWorkers(false)) }),
+	standbyWorkers: promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "registry_standby_workers",
+		Help: "Warm standby workers heard from within the gateways' worker TTL",
+	}, func() float64 { return float64(countLiveWorkers(true)) }),
+	workerLastSeen: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "registry_worker_last_seen_timestamp_seconds",
+		Help: "Unix time of the last heartbeat of each worker, per address and worker id (forgotten after an hour of silence)",
+	}, []string{"address", "worker_id"}),
+	gatewayRegistrationsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_gateway_registrations_total",
+		Help: "Gateway registrations, per kind (new id, or reregistered: a known id back after expiring or restarted)",
+	}, []string{"kind"}),
+}
+
+// the calls served (registry_grpc_requests_total, registry_grpc_request_duration_seconds per service and method, the
+// registry serving two services), the heartbeats forwarded to gateways counted in as Gateway.Heartbeat.forward
+var grpcInterceptors = rpc.NewInterceptors("registry_grpc", rpc.ServiceMethod)
+
+// Go scheduler and GC runtime metrics, as in the other services (see worker-node/metrics.go)
+func init() {
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsScheduler, collectors.MetricsGC)))
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sharding precision migrations. changing the precision gateways shard at moves most keys to other workers, and with
+// gateways restarted one by one they would disagree on where a ping lives. StartShardingMigration changes it for the
+// whole cluster instead (admin RPC, like the rolling restarts): the registry sets the new precision in the cluster
+// settings along with the migration, which every gateway gets with its next heartbeat:
+//   - until switch_at (now + SHARDING_MIGRATION_LEAD, so every gateway has heard of it) writes stay at the old
+//     precision, from then on they go to the owners at the new one
+//   - as soon as a gateway knows of it and until `until` (switch_at + the window), reads go to the owners at both
+//     precisions and add up their counts, so the pings written before the switch are counted until they leave the TTL
+//     window. workers need nothing: they store and count whatever they are sent
+//
+// the migration isn't persisted: set CLUSTER_SHARDING_PRECISION to the new precision before restarting the registry
+var SHARDING_MIGRATION_LEAD = env.Duration("SHARDING_MIGRATION_LEAD", 10*time.Second)
+var SHARDING_MIGRATION_WINDOW = env.Duration("SHARDING_MIGRATION_WINDOW", time.Minute)
+
+const defaultShardingPrecision = 7 // the gateways', if not distributed
+
+const (
+	migrationNone     = "none"
+	migrationPending  = "pending"
+	migrationDualRead = "dual_read"
+	migrationDone     = "done"
+)
+
+func (s *registryServer) StartShardingMigration(ctx context.Context, req *pb.StartShardingMigrationRequest) (*pb.ShardingMigration, error) {
+	if err := checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.ShardingPrecision < 2 || req.ShardingPrecision > 7 {
+		return nil, status.Error(codes.InvalidArgument, "sharding_precision must be between 2 and 7")
+	}
+	window := SHARDING_MIGRATION_WINDOW
+	if req.WindowSeconds != 0 {
+		window = time.Duration(req.WindowSeconds) * time.Second
+	}
+
+	settings.Lock()
+	defer settings.Unlock()
+	current := settings.current
+	if current == nil {
+		current = &pb.ClusterSettings{}
+	}
+	if window <= 0 || (current.PingTtl > 0 && window < time.Duration(current.PingTtl)*time.Second) {
+		return nil, status.Error(codes.InvalidArgument, "window_seconds must cover the workers' PING_TTL")
+	}
+	now := time.Now()
+	if m := current.ShardingMigration; m != nil && now.UnixMilli() < m.Until {
+		return nil, status.Error(codes.FailedPrecondition, "a sharding migration is already running")
+	}
+	from := current.ShardingPrecision
+	if from == 0 {
+		from = defaultShardingPrecision
+	}
+	if from == req.ShardingPrecision {
+		return nil, status.Errorf(codes.FailedPrecondition, "the cluster already shards at precision %d", from)
+	}
+
+	switchAt := now.Add(SHARDING_MIGRATION_LEAD)
+	m := &pb.ShardingMigration{
+		FromPrecision: from,
+		ToPrecision:   req.ShardingPrecision,
+		SwitchAt:      switchAt.UnixMilli(),
+		Until:         switchAt.Add(window).UnixMilli(),
+	}
+	next := &pb.ClusterSettings{
+		ShardingPrecision: req.ShardingPrecision,
+		ReplicationFactor: current.ReplicationFactor,
+		PingTtl:           current.PingTtl,
+		RingHash:          current.RingHash,
+		RingSeed:          current.RingSeed,
+		ShardingMigration: m,
+	}
+	next.Version = settingsVersion(next)
+	settings.current = next
+	Metrics.shardingMigrationsTotal.Inc()
+	log.Printf("sharding migration from precision %d to %d: writes switch at %s, dual reads until %s", from, req.ShardingPrecision, switchAt.Format(time.RFC3339), time.UnixMilli(m.Until).Format(time.RFC3339))
+	return migrationStatus(m, now), nil
+}
+
+func (s *registryServer) GetShardingMigration(ctx context.Context, req *pb.GetShardingMigrationRequest) (*pb.ShardingMigration, error) {
+	if err := checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return migrationStatus(currentSettings().GetShardingMigration(), time.Now()), nil
+}
+
+// migrationStatus returns a copy of the migration with its state at now
+func migrationStatus(m *pb.ShardingMigration, now time.Time) *pb.ShardingMigration {
+	if m == nil {
+		return &pb.ShardingMigration{State: migrationNone}
+	}
+	out := &pb.ShardingMigration{FromPrecision: m.FromPrecision, ToPrecision: m.ToPrecision, SwitchAt: m.SwitchAt, Until: m.Until}
+	switch ms := now.UnixMilli(); {
+	case ms < m.SwitchAt:
+		out.State = migrationPending
+	case ms < m.Until:
+		out.State = migrationDualRead
+	default:
+		out.State = migrationDone
+	}
+	return out
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
+	"log"
 	"sync"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type registryServer struct {
@@ -20,21 +23,34 @@ type RegistryState struct {
 	Clients     map[string]*grpc.ClientConn
 	ClientMutex sync.RWMutex
 	lastSeen    map[string]int64
+	known       map[string]knownGateway // gateway id -> latest heartbeat, kept gatewayForgetAfter past its registration
 }
 
-var registryState = &RegistryState{Gateways: make(map[string]string), Clients: make(map[string]*grpc.ClientConn), lastSeen: make(map[string]int64)}
+// gateway registrations are counted in registry_gateway_registrations_total by kind: new for a gateway id this registry
+// hasn't heard from within gatewayForgetAfter, reregistered for a known one, back after its registration expired or
+// restarted (a new heartbeat epoch) with the id saved in its GATEWAY_ID_FILE. a new id heartbeating from the address of
+// a registered gateway replaces it right away (a gateway restarted without a saved id), instead of both being kept
+// until the old one's heartbeats expire
+const gatewayForgetAfter = time.Hour
+
+type knownGateway struct {
+	seqEpoch int64
+	lastSeen time.Time
+}
+
+var registryState = &RegistryState{Gateways: make(map[string]string), Clients: make(map[string]*grpc.ClientConn), lastSeen: make(map[string]int64), known: make(map[string]knownGateway)}
 
 func (s *registryServer) Heartbeat(ctx context.Context, req *pb.RegistryHeartbeatRequest) (*pb.RegistryHeartbeatResponse, error) {
 	// gateway heartbeats
 
-	start := time.Now()
-	var err error // for error handling, not implemented yet
-	defer func() {
-		observeGRPC("Registry.Heartbeat", err, start)
-	}()
-
 	// log.Printf("received gateway heartbeat from: %s (gateway id: %s)", req.Address, req.GatewayId)
 
+	// the registry forwards worker heartbeats to its gateways, so it only registers gateways speaking a common version
+	if _, ok := pb.NegotiateAPIVersion(req.MinApiVersion, req.ApiVersion); !ok {
+		Metrics.incompatibleGateways.Inc()
+		return nil, status.Errorf(codes.FailedPrecondition, "unsupported api versions %d to %d (registry supports %d to %d)", req.MinApiVersion, req.ApiVersion, pb.MIN_API_VERSION, pb.API_VERSION)
+	}
+
 	registryState.Mutex.RLock()
 	v, gExists := registryState.Gateways[req.GatewayId]
 	registryState.Mutex.RUnlock()
@@ -54,7 +70,7 @@ func (s *registryServer) Heartbeat(ctx context.Context, req *pb.RegistryHeartbea
 		_, ngExists := registryState.Clients[req.Address]
 		registryState.ClientMutex.RUnlock()
 		if !ngExists {
-			conn, err := grpc.NewClient(req.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			conn, err := grpc.NewClient(req.Address, rpc.DialOptions()...)
 			if err != nil {
 				return nil, err
 			}
@@ -70,16 +86,46 @@ func (s *registryServer) Heartbeat(ctx context.Context, req *pb.RegistryHeartbea
 	}
 
 	registryState.Mutex.Lock()
+	registration := registryState.registrationLocked(req)
 	registryState.Gateways[req.GatewayId] = req.Address
 	registryState.lastSeen[req.GatewayId] = time.Now().Unix()
 	registryState.Mutex.Unlock()
+	if registration != "" {
+		Metrics.gatewayRegistrationsTotal.WithLabelValues(registration).Inc()
+		log.Printf("gateway %s registered at %s (%s)", req.GatewayId, req.Address, registration)
+	}
 
 	// track registered gateways (only additions, not updates)
 	if !gExists {
 		Metrics.registeredGatewaysTotal.Inc()
 	}
 
-	return &pb.RegistryHeartbeatResponse{Acknowledged: true}, err
+	observeSettingsVersion("gateway", req.SettingsVersion)
+	observeHeartbeat("gateway", req.Address, req.SeqEpoch, req.Seq)
+	return &pb.RegistryHeartbeatResponse{Acknowledged: true, ApiVersion: pb.API_VERSION, MinApiVersion: pb.MIN_API_VERSION, Settings: currentSettings()}, nil
+}
+
+// registrationLocked returns the kind of registration a gateway heartbeat is (new/reregistered), "" for the beats of a
+// registered gateway, replacing the registration of another id at its address
+func (g *RegistryState) registrationLocked(req *pb.RegistryHeartbeatRequest) string {
+	_, registered := g.Gateways[req.GatewayId]
+	previous, known := g.known[req.GatewayId]
+	g.known[req.GatewayId] = knownGateway{seqEpoch: req.SeqEpoch, lastSeen: time.Now()}
+	if registered && (req.SeqEpoch == 0 || previous.seqEpoch == req.SeqEpoch) {
+		return ""
+	}
+	if known {
+		return "reregistered"
+	}
+	for gatewayId, address := range g.Gateways {
+		if address == req.Address && gatewayId != req.GatewayId {
+			delete(g.Gateways, gatewayId) // the connection to the address is kept
+			delete(g.lastSeen, gatewayId)
+			Metrics.registeredGatewaysTotal.Dec()
+			log.Printf("gateway %s replaced by %s at %s", gatewayId, req.GatewayId, address)
+		}
+	}
+	return "new"
 }
 
 func (g *RegistryState) cleanupDeadGateways(ttl time.Duration, tick_time time.Duration) {
@@ -93,6 +139,7 @@ func (g *RegistryState) cleanupDeadGateways(ttl time.Duration, tick_time time.Du
 		for gatewayId, lastSeen := range g.lastSeen {
 			if now-lastSeen > int64(ttl.Seconds()) {
 				server := g.Gateways[gatewayId]
+				forgetHeartbeats("gateway", server)
 				delete(g.Gateways, gatewayId)
 				delete(g.lastSeen, gatewayId)
 
@@ -111,6 +158,11 @@ func (g *RegistryState) cleanupDeadGateways(ttl time.Duration, tick_time time.Du
 				Metrics.registeredGatewaysTotal.Dec()
 			}
 		}
+		for gatewayId, k := range g.known {
+			if _, registered := g.Gateways[gatewayId]; !registered && time.Since(k.lastSeen) > gatewayForgetAfter {
+				delete(g.known, gatewayId)
+			}
+		}
 
 		g.Mutex.Unlock()
 	}
@@ -128,7 +180,7 @@ func (g *RegistryState) getAllConnections() []*grpc.ClientConn {
 
 		if !exists || conn == nil {
 			// TODO: skip instead of creating new connection?
-			conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			conn, err := grpc.NewClient(address, rpc.DialOptions()...)
 			if err != nil {
 				continue
 			}
@@ -149,3 +201,15 @@ func (g *RegistryState) getAllConnections() []*grpc.ClientConn {
 
 	return connections
 }
+
+// getGatewayIds returns the ids of the registered gateways, sent to the workers as the callers they accept
+func (g *RegistryState) getGatewayIds() []string {
+	g.Mutex.RLock()
+	defer g.Mutex.RUnlock()
+
+	ids := make([]string, 0, len(g.Gateways))
+	for gatewayId := range g.Gateways {
+		ids = append(ids, gatewayId)
+	}
+	return ids
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// rolling restarts, so clusters can be upgraded without manual coordination. StartRollingRestart takes the workers
+// through, one at a time:
+//   - draining: its heartbeats are forwarded to the gateways marked draining, so they route pings and routed reads to
+//     the next worker on the ring (broadcast area queries still read it) while the TTL window it holds expires, for
+//     drain_seconds (ROLLOUT_DRAIN, at least PING_TTL plus a heartbeat interval)
+//   - restarting: its heartbeat responses ask it to exit, for its supervisor (Kubernetes, a Compose restart policy) to
+//     start it again
+//   - rejoined: a heartbeat from the same address with a new worker id or heartbeat epoch (a fresh process, which may
+//     keep its worker id with WORKER_ID_FILE). then the next worker
+//
+// a worker not back within rejoin_timeout_seconds (ROLLOUT_REJOIN_TIMEOUT) fails the rollout, leaving the others as
+// they are. standbys are not restarted (restart them first, see README), and a restarting primary isn't failed over
+// to its standby. the admin RPCs require "authorization: Bearer <ADMIN_TOKEN>" metadata, and are refused without
+// ADMIN_TOKEN unless ADMIN_INSECURE opts in to leaving them open
+var ROLLOUT_DRAIN = env.Duration("ROLLOUT_DRAIN", 15*time.Second)
+var ROLLOUT_REJOIN_TIMEOUT = env.Duration("ROLLOUT_REJOIN_TIMEOUT", 2*time.Minute)
+var ADMIN_TOKEN = os.Getenv("ADMIN_TOKEN")
+var ADMIN_INSECURE = env.Bool("ADMIN_INSECURE", false)
+
+const (
+	rolloutIdle    = "idle"
+	rolloutRunning = "running"
+	rolloutDone    = "done"
+	rolloutFailed  = "failed"
+	rolloutAborted = "aborted"
+
+	phasePending    = "pending"
+	phaseDraining   = "draining"
+	phaseRestarting = "restarting"
+	phaseRejoined   = "rejoined"
+	phaseFailed     = "failed"
+
+	workerLiveAfter = 10 * time.Second // the gateways' worker TTL
+)
+
+type rolloutWorker struct {
+	address     string
+	phase       string
+	workerId    string
+	newWorkerId string
+	seqEpoch    int64 // of the heartbeats before the restart (0: from before sequencing)
+	updatedAt   time.Time
+}
+
+type rolloutState struct {
+	mu         sync.Mutex
+	state      string
+	workers    []*rolloutWorker
+	startedAt  time.Time
+	finishedAt time.Time
+	err        string
+	abort      chan struct{}
+}
+
+var rollout = &rolloutState{state: rolloutIdle}
+
+func checkAdmin(ctx context.Context) error {
+	if ADMIN_TOKEN == "" {
+		if ADMIN_INSECURE {
+			return nil
+		}
+		return status.Error(codes.PermissionDenied, "admin RPCs need ADMIN_TOKEN (or ADMIN_INSECURE=true)")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(v), []byte("Bearer "+ADMIN_TOKEN)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "admin token required")
+}
+
+// liveWorkers returns the addresses of the primaries heard from recently, sorted
+func liveWorkers() []string {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	var addresses []string
+	for address, w := range workers.byAddress {
+		if !w.promoted && w.standbyFor == "" && time.Since(w.lastSeen) < workerLiveAfter {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+func workerIdAt(address string) string {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	if w, ok := workers.byAddress[address]; ok {
+		return w.workerId
+	}
+	return ""
+}
+
+func (s *registryServer) StartRollingRestart(ctx context.Context, req *pb.StartRollingRestartRequest) (*pb.RollingRestartStatus, error) {
+	if err := checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	live := liveWorkers()
+	addresses := req.Addresses
+	if len(addresses) == 0 {
+		addresses = live
+	}
+	pending := make(map[string]bool, len(live))
+	for _, address := range live {
+		pending[address] = true
+	}
+	for _, address := range addresses {
+		if !pending[address] {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not a live worker (or listed twice)", address)
+		}
+		pending[address] = false
+	}
+	if len(addresses) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "no live workers")
+	}
+	drain, rejoinTimeout := ROLLOUT_DRAIN, ROLLOUT_REJOIN_TIMEOUT
+	if req.DrainSeconds > 0 {
+		drain = time.Duration(req.DrainSeconds) * time.Second
+	}
+	if req.RejoinTimeoutSeconds > 0 {
+		rejoinTimeout = time.Duration(req.RejoinTimeoutSeconds) * time.Second
+	}
+
+	rollout.mu.Lock()
+	defer rollout.mu.Unlock()
+	if rollout.state == rolloutRunning {
+		return nil, status.Error(codes.FailedPrecondition, "a rolling restart is already running")
+	}
+	rollout.state, rollout.err = rolloutRunning, ""
+	rollout.startedAt, rollout.finishedAt = time.Now(), time.Time{}
+	rollout.abort = make(chan struct{})
+	rollout.workers = make([]*rolloutWorker, 0, len(addresses))
+	for _, address := range addresses {
+		rollout.workers = append(rollout.workers, &rolloutWorker{address: address, phase: phasePending, updatedAt: rollout.startedAt})
+	}
+	log.Printf("rolling restart of %d workers started (drain %s, rejoin timeout %s)", len(addresses), drain, rejoinTimeout)
+	go rollout.run(rollout.workers, drain, rejoinTimeout, rollout.abort)
+	return rollout.statusLocked(), nil
+}
+
+func (s *registryServer) GetRollingRestart(ctx context.Context, req *pb.GetRollingRestartRequest) (*pb.RollingRestartStatus, error) {
+	if err := checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rollout.mu.Lock()
+	defer rollout.mu.Unlock()
+	return rollout.statusLocked(), nil
+}
+
+func (s *registryServer) AbortRollingRestart(ctx context.Context, req *pb.AbortRollingRestartRequest) (*pb.RollingRestartStatus, error) {
+	if err := checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	rollout.mu.Lock()
+	defer rollout.mu.Unlock()
+	if rollout.state == rolloutRunning {
+		close(rollout.abort)
+		rollout.finishLocked(rolloutAborted, "aborted by an operator")
+	}
+	return rollout.statusLocked(), nil
+}
+
+// run restarts the workers in order, until done, failed or aborted
+func (r *rolloutState) run(workers []*rolloutWorker, drain time.Duration, rejoinTimeout time.Duration, abort chan struct{}) {
+	for _, w := range workers {
+		workerId := workerIdAt(w.address) // not under r.mu: standbyPromotion locks them the other way around
+		r.mu.Lock()
+		w.workerId = workerId
+		r.setPhaseLocked(w, phaseDraining)
+		r.mu.Unlock()
+
+		select {
+		case <-abort:
+			return
+		case <-time.After(drain):
+		}
+
+		r.mu.Lock()
+		r.setPhaseLocked(w, phaseRestarting)
+		r.mu.Unlock()
+
+		deadline := time.After(rejoinTimeout)
+		ticker := time.NewTicker(500 * time.Millisecond)
+		for rejoined := false; !rejoined; {
+			select {
+			case <-abort:
+				ticker.Stop()
+				return
+			case <-deadline:
+				ticker.Stop()
+				r.mu.Lock()
+				r.setPhaseLocked(w, phaseFailed)
+				r.finishLocked(rolloutFailed, w.address+" did not rejoin within "+rejoinTimeout.String())
+				r.mu.Unlock()
+				return
+			case <-ticker.C:
+				r.mu.Lock()
+				rejoined = w.phase == phaseRejoined
+				r.mu.Unlock()
+			}
+		}
+		ticker.Stop()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-abort:
+	default:
+		r.finishLocked(rolloutDone, "")
+	}
+}
+
+func (r *rolloutState) setPhaseLocked(w *rolloutWorker, phase string) {
+	w.phase, w.updatedAt = phase, time.Now()
+	switch phase {
+	case phaseRejoined, phaseFailed:
+		Metrics.rolloutRestartsTotal.WithLabelValues(phase).Inc()
+	}
+	log.Printf("rolling restart: %s %s", w.address, phase)
+}
+
+func (r *rolloutState) finishLocked(state string, err string) {
+	r.state, r.err, r.finishedAt = state, err, time.Now()
+	for _, w := range r.workers {
+		if w.phase == phaseDraining || w.phase == phaseRestarting {
+			w.phase, w.updatedAt = phasePending, r.finishedAt // no longer drained (a worker told to exit still comes back)
+		}
+	}
+	if err != "" {
+		log.Printf("rolling restart %s: %s", state, err)
+		return
+	}
+	log.Printf("rolling restart %s", state)
+}
+
+// heartbeat tells whether the worker at address is being drained and should exit, and notices restarted workers
+func (r *rolloutState) heartbeat(address string, workerId string, seqEpoch int64) (draining bool, restart bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != rolloutRunning {
+		return false, false
+	}
+	for _, w := range r.workers {
+		if w.address != address {
+			continue
+		}
+		switch w.phase {
+		case phaseDraining:
+			w.seqEpoch = seqEpoch
+			return true, false
+		case phaseRestarting:
+			if workerId == w.workerId && (seqEpoch == 0 || w.seqEpoch == 0 || seqEpoch == w.seqEpoch) {
+				return true, true
+			}
+			w.newWorkerId = workerId
+			r.setPhaseLocked(w, phaseRejoined)
+		}
+		return false, false
+	}
+	return false, false
+}
+
+// restarting reports whether the worker at address was told to exit (so its standby isn't promoted meanwhile)
+func (r *rolloutState) restarting(address string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range r.workers {
+		if w.address == address {
+			return r.state == rolloutRunning && w.phase == phaseRestarting
+		}
+	}
+	return false
+}
+
+func (r *rolloutState) statusLocked() *pb.RollingRestartStatus {
+	out := &pb.RollingRestartStatus{State: r.state, Error: r.err}
+	if !r.startedAt.IsZero() {
+		out.StartedAt = r.startedAt.UnixMilli()
+	}
+	if !r.finishedAt.IsZero() {
+		out.FinishedAt = r.finishedAt.UnixMilli()
+	}
+	for _, w := range r.workers {
+		out.Workers = append(out.Workers, &pb.RollingRestartWorker{
+			Address:     w.address,
+			Phase:       w.phase,
+			WorkerId:    w.workerId,
+			NewWorkerId: w.newWorkerId,
+			UpdatedAt:   w.updatedAt.UnixMilli(),
+		})
+	}
+	return out
+}
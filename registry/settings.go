@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// cluster-wide settings. instead of every binary's env vars having to agree by convention, the registry holds the
+// settings that must be the same across the cluster and hands them out:
+//   - CLUSTER_SHARDING_PRECISION (2 to 7): geohash precision the gateways shard keys at
+//   - CLUSTER_REPLICATION_FACTOR: workers holding each shard (every gateway's REPLICATION_FACTOR)
+//   - CLUSTER_PING_TTL: TTL window of every worker in seconds (PING_TTL)
+//   - CLUSTER_RING_HASH (xxh3, xxhash or fnv) / CLUSTER_RING_SEED: how the gateways place keys on the ring (see
+//     gateway/ringhash.go), only set here so that no two gateways route a key differently
+//
+// unset (0) = not distributed, each node keeps its own. gateways and workers fetch them with GetClusterSettings at
+// startup, before building any state, so changing one means restarting the registry with the new value, then the
+// nodes (workers with a rolling restart). meanwhile every heartbeat response carries the current settings and their
+// version: nodes running with another version log it and flag themselves (gateway_cluster_settings_stale,
+// worker_cluster_settings_stale), and their heartbeats are counted in registry_stale_settings_heartbeats_total. the
+// sharding precision is the exception: a sharding migration (see migration.go) changes it at runtime
+var settings = struct {
+	sync.RWMutex
+	current *pb.ClusterSettings // nil = none distributed
+}{current: loadClusterSettings()}
+
+// currentSettings returns the distributed settings (nil if none). never modified: replaced as a whole
+func currentSettings() *pb.ClusterSettings {
+	settings.RLock()
+	defer settings.RUnlock()
+	return settings.current
+}
+
+func loadClusterSettings() *pb.ClusterSettings {
+	precision := env.Int("CLUSTER_SHARDING_PRECISION", 0)
+	replication := env.Int("CLUSTER_REPLICATION_FACTOR", 0)
+	ttl := env.Int("CLUSTER_PING_TTL", 0)
+	ringHash := os.Getenv("CLUSTER_RING_HASH")
+	ringSeed, err := strconv.ParseUint(cmp.Or(os.Getenv("CLUSTER_RING_SEED"), "0"), 10, 64)
+	if err != nil {
+		log.Fatalf("invalid CLUSTER_RING_SEED: %v", err)
+	}
+	if !slices.Contains([]string{"", "xxh3", "xxhash", "fnv"}, ringHash) {
+		log.Fatalf("CLUSTER_RING_HASH must be xxh3, xxhash or fnv (got %q)", ringHash)
+	}
+	if precision != 0 && (precision < 2 || precision > 7) {
+		log.Fatalf("CLUSTER_SHARDING_PRECISION must be between 2 and 7 (got %d)", precision)
+	}
+	if replication < 0 || ttl < 0 {
+		log.Fatalf("CLUSTER_REPLICATION_FACTOR and CLUSTER_PING_TTL can't be negative")
+	}
+	if precision == 0 && replication == 0 && ttl == 0 && ringHash == "" && ringSeed == 0 {
+		return nil
+	}
+
+	s := &pb.ClusterSettings{ShardingPrecision: uint32(precision), ReplicationFactor: uint32(replication), PingTtl: int64(ttl), RingHash: ringHash, RingSeed: ringSeed}
+	s.Version = settingsVersion(s)
+	log.Printf("distributing cluster settings %x: sharding precision %d, replication factor %d, ping ttl %d (0 = each node's own), ring hash %q seed %d", s.Version, precision, replication, ttl, ringHash, ringSeed)
+	return s
+}
+
+// settingsVersion hashes the settings (the sharding migration left out)
+func settingsVersion(s *pb.ClusterSettings) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%d/%d", s.ShardingPrecision, s.ReplicationFactor, s.PingTtl)
+	if s.RingHash != "" || s.RingSeed != 0 { // versions from before ring hashes stay the same
+		fmt.Fprintf(h, "/%s/%d", s.RingHash, s.RingSeed)
+	}
+	return max(h.Sum64(), 1) // 0 is "none from the registry"
+}
+
+func (s *registryServer) GetClusterSettings(ctx context.Context, req *pb.GetClusterSettingsRequest) (*pb.ClusterSettings, error) {
+	if current := currentSettings(); current != nil {
+		return current, nil
+	}
+	return &pb.ClusterSettings{}, nil
+}
+
+// observeSettingsVersion counts the heartbeats of nodes running with other settings than the current ones
+func observeSettingsVersion(kind string, version uint64) {
+	if current := currentSettings(); current != nil && version != current.Version {
+		Metrics.staleSettingsHeartbeats.WithLabelValues(kind).Inc()
+	}
+}
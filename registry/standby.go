@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// warm standby promotion: the registry remembers the worker id and last heartbeat of every worker address. a standby
+// (heartbeats with standby_for set) is not forwarded to the gateways; once its primary has missed heartbeats for
+// STANDBY_PROMOTE_AFTER, the standby is told to take over the primary's worker id
+var STANDBY_PROMOTE_AFTER = env.Duration("STANDBY_PROMOTE_AFTER", 6*time.Second) // 2 heartbeat intervals
+
+const workerForgetAfter = time.Hour
+
+type workerEntry struct {
+	workerId     string
+	lastSeen     time.Time
+	promoted     bool   // a standby took over
+	standbyFor   string // a standby: address of its primary
+	buildVersion string
+	apiVersion   uint32
+}
+
+var workers = struct {
+	mu        sync.Mutex
+	byAddress map[string]*workerEntry
+}{byAddress: make(map[string]*workerEntry)}
+
+// recordWorker notes a worker heartbeat. it returns false for a primary whose standby took over its worker id (back
+// after a partition, it would otherwise take the id's shards back and the rings would flap between the two)
+func recordWorker(req *pb.HeartbeatRequest) bool {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+	if w, ok := workers.byAddress[req.Address]; ok && w.promoted && w.workerId == req.WorkerId {
+		return false
+	}
+	previous := workers.byAddress[req.Address]
+	w := &workerEntry{workerId: req.WorkerId, lastSeen: time.Now(), standbyFor: req.StandbyFor, buildVersion: req.BuildVersion, apiVersion: req.ApiVersion}
+	workers.byAddress[req.Address] = w
+	observeWorkerSeen(req.Address, previous, w)
+	return true
+}
+
+// standbyPromotion returns the worker id a standby of primary should take over, if that primary is down
+func standbyPromotion(primary string, standby string) (string, bool) {
+	workers.mu.Lock()
+	defer workers.mu.Unlock()
+
+	w, ok := workers.byAddress[primary]
+	if !ok || time.Since(w.lastSeen) < STANDBY_PROMOTE_AFTER {
+		return "", false // unknown (e.g. registry restarted since) or alive
+	}
+	if rollout.restarting(primary) {
+		return "", false // told to exit by a rolling restart, coming back
+	}
+	if !w.promoted {
+		w.promoted = true
+		Metrics.standbyPromotionsTotal.Inc()
+		log.Printf("worker %s (worker id %s) missed heartbeats for %s: promoting its standby %s", primary, w.workerId, time.Since(w.lastSeen).Round(time.Second), standby)
+	}
+	return w.workerId, true
+}
+
+// forgetWorkers drops workers not seen for workerForgetAfter
+func forgetWorkers() {
+	ticker := time.NewTicker(workerForgetAfter / 4)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		workers.mu.Lock()
+		for address, w := range workers.byAddress {
+			if time.Since(w.lastSeen) > workerForgetAfter {
+				delete(workers.byAddress, address)
+				forgetHeartbeats("worker", address)
+				forgetWorkerSeen(address)
+			}
+		}
+		workers.mu.Unlock()
+	}
+}
@@ -0,0 +1,92 @@
+// Package rpc holds the gRPC settings shared by every client and server of the cluster (gateways, workers and the
+// registry), so that they can't drift apart between the services.
+package rpc
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"geostreamdb/env"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	channelzservice "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+)
+
+// settings shared by every gRPC client and server of the cluster (set them alike on the gateways, workers and registry):
+//   - keepalive pings every GRPC_KEEPALIVE_TIME on idle connections, closed if unanswered within GRPC_KEEPALIVE_TIMEOUT,
+//     so connections to dead peers are noticed without waiting for a call. servers accept pings down to half that
+//   - messages up to GRPC_MAX_MSG_SIZE bytes both ways (grpc's 4 MiB receive default is too small for large GetPingArea
+//     responses and batches)
+//   - reconnects backing off exponentially from GRPC_BACKOFF_BASE to GRPC_BACKOFF_MAX, each attempt given at least
+//     GRPC_CONNECT_TIMEOUT
+//   - with GRPC_AUTH_TOKEN, every call carries it as x-cluster-token metadata, for servers to check
+//   - with GRPC_DEBUG, servers also serve reflection and channelz, so that operators can list, describe and invoke the
+//     RPCs with grpcurl during incidents without the proto files at hand, and see the server's channels and sockets.
+//     calls to them go through the interceptors like any other: with GRPC_AUTH_TOKEN, pass it as x-cluster-token
+//     (grpcurl -H "x-cluster-token: ...")
+var GRPC_KEEPALIVE_TIME = env.Duration("GRPC_KEEPALIVE_TIME", 30*time.Second)
+var GRPC_KEEPALIVE_TIMEOUT = env.Duration("GRPC_KEEPALIVE_TIMEOUT", 10*time.Second)
+var GRPC_MAX_MSG_SIZE = env.Int("GRPC_MAX_MSG_SIZE", 64<<20)
+var GRPC_BACKOFF_BASE = env.Duration("GRPC_BACKOFF_BASE", time.Second)
+var GRPC_BACKOFF_MAX = env.Duration("GRPC_BACKOFF_MAX", 30*time.Second)
+var GRPC_CONNECT_TIMEOUT = env.Duration("GRPC_CONNECT_TIMEOUT", 5*time.Second)
+var GRPC_DEBUG = env.Bool("GRPC_DEBUG", false)
+var GRPC_AUTH_TOKEN = os.Getenv("GRPC_AUTH_TOKEN")
+
+const clusterTokenKey = "x-cluster-token"
+
+// DialOptions returns the options of every client connection, followed by extra
+func DialOptions(extra ...grpc.DialOption) []grpc.DialOption {
+	backoffConfig := backoff.DefaultConfig
+	backoffConfig.BaseDelay, backoffConfig.MaxDelay = GRPC_BACKOFF_BASE, GRPC_BACKOFF_MAX
+	return append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: GRPC_KEEPALIVE_TIME, Timeout: GRPC_KEEPALIVE_TIMEOUT, PermitWithoutStream: true}),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(GRPC_MAX_MSG_SIZE), grpc.MaxCallSendMsgSize(GRPC_MAX_MSG_SIZE)),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: backoffConfig, MinConnectTimeout: GRPC_CONNECT_TIMEOUT}),
+		grpc.WithChainUnaryInterceptor(clusterTokenUnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(clusterTokenStreamClientInterceptor),
+	}, extra...)
+}
+
+// ServerOptions returns the options of every server, followed by extra (see Interceptors.ServerOptions)
+func ServerOptions(extra ...grpc.ServerOption) []grpc.ServerOption {
+	return append([]grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: GRPC_KEEPALIVE_TIME, Timeout: GRPC_KEEPALIVE_TIMEOUT}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: GRPC_KEEPALIVE_TIME / 2, PermitWithoutStream: true}),
+		grpc.MaxRecvMsgSize(GRPC_MAX_MSG_SIZE),
+		grpc.MaxSendMsgSize(GRPC_MAX_MSG_SIZE),
+	}, extra...)
+}
+
+// RegisterDebugServices registers the debug services on a server with GRPC_DEBUG
+func RegisterDebugServices(s *grpc.Server) {
+	if !GRPC_DEBUG {
+		return
+	}
+	reflection.Register(s)
+	channelzservice.RegisterChannelzServiceToServer(s)
+	log.Printf("grpc debug services enabled (reflection, channelz)")
+}
+
+// client side: every call carries GRPC_AUTH_TOKEN
+func clusterTokenUnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if GRPC_AUTH_TOKEN != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, clusterTokenKey, GRPC_AUTH_TOKEN)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func clusterTokenStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if GRPC_AUTH_TOKEN != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, clusterTokenKey, GRPC_AUTH_TOKEN)
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
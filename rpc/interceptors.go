@@ -0,0 +1,181 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"geostreamdb/env"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// every gRPC server call goes through the same interceptors (see Interceptors.ServerOptions), outermost first:
+//   - observe: <prefix>_requests_total and <prefix>_request_duration_seconds per method, and a JSON log line
+//     ("log": "grpc") for every failed call, with the request id and W3C traceparent gateways send as metadata, so a
+//     client-side failure can be followed to the server that caused it. GRPC_LOG_SAMPLE also logs that fraction of
+//     the successful calls
+//   - recovery: a panicking handler fails its call with Internal (logged with its stack) instead of the process
+//   - auth: with GRPC_AUTH_TOKEN set, calls must carry it as x-cluster-token metadata, which every client of the
+//     cluster sends (see DialOptions)
+//
+// unary and stream calls alike
+var GRPC_LOG_SAMPLE = env.Float("GRPC_LOG_SAMPLE", 0) // of the successful calls, failed ones are always logged
+
+var grpcLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("log", "grpc")
+
+// Interceptors are the server interceptors of a service, observing its calls under its metric prefix
+type Interceptors struct {
+	requests *prometheus.CounterVec   // per method and result (success/failure)
+	latency  *prometheus.HistogramVec // per method
+	label    func(fullMethod string) string
+}
+
+// NewInterceptors registers the metrics of a service's calls (e.g. prefix "worker_grpc": worker_grpc_requests_total
+// and worker_grpc_request_duration_seconds), labelled with label(fullMethod)
+func NewInterceptors(prefix string, label func(fullMethod string) string) *Interceptors {
+	return &Interceptors{
+		requests: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "_requests_total",
+			Help: "Total count of gRPC requests by method and result (success/failure)",
+		}, []string{"method", "result"}),
+		latency: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prefix + "_request_duration_seconds",
+			Help:    "gRPC request latency in seconds by method",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		label: label,
+	}
+}
+
+// MethodName labels a method with its name, for a server of a single service ("/geostreamdb.Worker/SendPing" ->
+// "SendPing")
+func MethodName(fullMethod string) string {
+	return fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+}
+
+// ServiceMethod labels a method with its service and name ("/geostreamdb.Registry/Heartbeat" -> "Registry.Heartbeat")
+func ServiceMethod(fullMethod string) string {
+	return strings.Replace(strings.TrimPrefix(fullMethod, "/geostreamdb."), "/", ".", 1)
+}
+
+// ServerOptions returns the options of every server (see ServerOptions) with the interceptors, followed by extra
+func (i *Interceptors) ServerOptions(extra ...grpc.ServerOption) []grpc.ServerOption {
+	return ServerOptions(append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(i.observeUnary, recoveryUnaryInterceptor, authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(i.observeStream, recoveryStreamInterceptor, authStreamInterceptor),
+	}, extra...)...)
+}
+
+// Observe counts a call under method, for the calls a service makes itself as well
+func (i *Interceptors) Observe(method string, err error, start time.Time) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	i.requests.WithLabelValues(method, result).Inc()
+	i.latency.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (i *Interceptors) observeUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	i.observeCall(ctx, info.FullMethod, err, start)
+	return resp, err
+}
+
+func (i *Interceptors) observeStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	i.observeCall(ss.Context(), info.FullMethod, err, start)
+	return err
+}
+
+func (i *Interceptors) observeCall(ctx context.Context, fullMethod string, err error, start time.Time) {
+	i.Observe(i.label(fullMethod), err, start)
+	if err == nil && (GRPC_LOG_SAMPLE <= 0 || (GRPC_LOG_SAMPLE < 1 && rand.Float64() >= GRPC_LOG_SAMPLE)) {
+		return
+	}
+
+	requestID, traceparent, remote := "", "", ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("x-request-id"); len(v) > 0 {
+			requestID = v[0]
+		}
+		if v := md.Get("traceparent"); len(v) > 0 {
+			traceparent = v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	grpcLogger.Log(ctx, level, "call",
+		"method", fullMethod,
+		"code", status.Code(err).String(),
+		"error", status.Convert(err).Message(),
+		"durationMs", float64(time.Since(start).Microseconds())/1000,
+		"remote", remote,
+		"requestId", requestID,
+		"traceparent", traceparent,
+	)
+}
+
+func recoveryUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer recoverCall(info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+func recoveryStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverCall(info.FullMethod, &err)
+	return handler(srv, ss)
+}
+
+func recoverCall(fullMethod string, err *error) {
+	if p := recover(); p != nil {
+		log.Printf("panic in %s: %v\n%s", fullMethod, p, debug.Stack())
+		*err = status.Error(codes.Internal, "internal error")
+	}
+}
+
+func authUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := checkClusterToken(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func authStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := checkClusterToken(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func checkClusterToken(ctx context.Context) error {
+	if GRPC_AUTH_TOKEN == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(clusterTokenKey) {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(GRPC_AUTH_TOKEN)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "cluster token required")
+}
@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestInterceptorsObserveRecoverAndAuthenticate(t *testing.T) {
+	previous := GRPC_AUTH_TOKEN
+	GRPC_AUTH_TOKEN = "secret"
+	t.Cleanup(func() { GRPC_AUTH_TOKEN = previous })
+
+	i := NewInterceptors("rpc_test_grpc", ServiceMethod)
+	info := &grpc.UnaryServerInfo{FullMethod: "/geostreamdb.Worker/SendPing"}
+	call := func(ctx context.Context, handler grpc.UnaryHandler) error {
+		_, err := i.observeUnary(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+			return recoveryUnaryInterceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return authUnaryInterceptor(ctx, req, info, handler)
+			})
+		})
+		return err
+	}
+	withToken := metadata.NewIncomingContext(context.Background(), metadata.Pairs(clusterTokenKey, "secret"))
+
+	if err := call(context.Background(), func(context.Context, any) (any, error) { return nil, nil }); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("without the cluster token: %v", err)
+	}
+	if err := call(withToken, func(context.Context, any) (any, error) { panic("boom") }); status.Code(err) != codes.Internal {
+		t.Fatalf("panicking handler: %v", err)
+	}
+	if err := call(withToken, func(context.Context, any) (any, error) { return nil, nil }); err != nil {
+		t.Fatalf("with the cluster token: %v", err)
+	}
+	i.Observe("Gateway.Heartbeat", errors.New("unreachable"), time.Now())
+
+	for _, c := range []struct {
+		method, result string
+		want           float64
+	}{{"Worker.SendPing", "failure", 2}, {"Worker.SendPing", "success", 1}, {"Gateway.Heartbeat", "failure", 1}} {
+		if got := testutil.ToFloat64(i.requests.WithLabelValues(c.method, c.result)); got != c.want {
+			t.Errorf("%s %s: %v calls, want %v", c.method, c.result, got, c.want)
+		}
+	}
+}
+
+func TestMethodLabels(t *testing.T) {
+	if got := MethodName("/geostreamdb.Worker/SendPing"); got != "SendPing" {
+		t.Errorf("MethodName = %q", got)
+	}
+	if got := ServiceMethod("/geostreamdb.Registry/Heartbeat"); got != "Registry.Heartbeat" {
+		t.Errorf("ServiceMethod = %q", got)
+	}
+}
@@ -0,0 +1,200 @@
+//go:build integration
+
+package integration
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var binDir string // service binaries, built once per run (no containers: the suite only needs a Go toolchain)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "geostreamdb-integration")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	binDir = dir
+
+	for _, service := range []string{"registry", "gateway", "worker-node"} {
+		cmd := exec.Command("go", "build", "-o", filepath.Join(binDir, service), ".")
+		cmd.Dir = filepath.Join("..", "..", service)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to build %s: %v\n", service, err)
+			os.RemoveAll(binDir)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	os.RemoveAll(binDir)
+	os.Exit(code)
+}
+
+type process struct {
+	name string
+	env  []string
+	cmd  *exec.Cmd
+	log  string
+}
+
+func (p *process) start(t *testing.T) {
+	t.Helper()
+	logFile, err := os.Create(filepath.Join(t.TempDir(), p.name+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := strings.TrimRight(p.name, "0123456789")
+	p.cmd = exec.Command(filepath.Join(binDir, service))
+	p.cmd.Env = append(os.Environ(), p.env...)
+	p.cmd.Stdout, p.cmd.Stderr = logFile, logFile
+	p.log = logFile.Name()
+	if err := p.cmd.Start(); err != nil {
+		t.Fatalf("failed to start %s: %v", p.name, err)
+	}
+}
+
+func (p *process) stop() {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return
+	}
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.cmd = nil
+}
+
+type cluster struct {
+	t        *testing.T
+	registry *process
+	gateways []*process
+	workers  []*process
+	urls     []string // gateway HTTP base URLs
+	udpAddrs []string // gateway UDP ingest addresses
+}
+
+// startCluster brings up a registry, the given number of gateways and workers, and waits until every gateway routes
+// to every worker
+func startCluster(t *testing.T, gateways, workers int) *cluster {
+	t.Helper()
+	c := &cluster{t: t}
+
+	registryPort := freePort(t)
+	registryAddress := "127.0.0.1:" + registryPort
+	c.registry = &process{name: "registry", env: []string{"PORT=" + registryPort, "METRICS_PORT=" + freePort(t)}}
+
+	for i := 0; i < gateways; i++ {
+		httpPort, heartbeatPort, udpPort := freePort(t), freePort(t), freeUDPPort(t)
+		c.gateways = append(c.gateways, &process{name: "gateway" + strconv.Itoa(i), env: []string{
+			"PORT=" + httpPort,
+			"UDP_PORT=" + udpPort,
+			"HEARTBEAT_PORT=" + heartbeatPort,
+			"GATEWAY_ADDRESS=127.0.0.1",
+			"GATEWAY_PORT=" + heartbeatPort,
+			"REGISTRY_ADDRESS=" + registryAddress,
+		}})
+		c.urls = append(c.urls, "http://127.0.0.1:"+httpPort)
+		c.udpAddrs = append(c.udpAddrs, "127.0.0.1:"+udpPort)
+	}
+	for i := 0; i < workers; i++ {
+		c.workers = append(c.workers, &process{name: "worker-node" + strconv.Itoa(i), env: []string{
+			"PORT=" + freePort(t),
+			"METRICS_PORT=" + freePort(t),
+			"WORKER_ADDRESS=127.0.0.1",
+			"REGISTRY_ADDRESS=" + registryAddress,
+		}})
+	}
+
+	t.Cleanup(func() {
+		for _, p := range c.all() {
+			p.stop()
+		}
+		if t.Failed() {
+			for _, p := range c.all() {
+				t.Logf("%s log: %s", p.name, p.log)
+			}
+		}
+	})
+	for _, p := range c.all() {
+		p.start(t)
+	}
+
+	c.waitForWorkers(workers, 30*time.Second)
+	return c
+}
+
+func (c *cluster) all() []*process {
+	return append(append([]*process{c.registry}, c.gateways...), c.workers...)
+}
+
+// waitForWorkers waits until every gateway's ring holds exactly n workers
+func (c *cluster) waitForWorkers(n int, timeout time.Duration) {
+	c.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		ready := true
+		for _, url := range c.urls {
+			if v, ok := metricValue(url, "gateway_worker_nodes_total"); !ok || v != float64(n) {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("gateways did not converge on %d workers within %s", n, timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)
+}
+
+func freeUDPPort(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// metricValue reads an unlabeled metric from a Prometheus text endpoint
+func metricValue(baseURL string, name string) (float64, bool) {
+	resp, err := http.Get(baseURL + "/metrics")
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), name+" ")
+		if !found {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		return v, err == nil
+	}
+	return 0, false
+}
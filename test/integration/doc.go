@@ -0,0 +1,5 @@
+// Package integration runs registry, gateways and workers as local processes and checks the distributed paths end to end
+// (ingest, point and area queries, TTL expiry, membership churn). the tests are behind the integration build tag:
+//
+//	go test -tags integration -v ./...
+package integration
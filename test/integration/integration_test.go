@@ -0,0 +1,224 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const pingTTL = 10 * time.Second // worker PING_TTL
+
+type point struct {
+	lat, lng float64
+	pings    int
+}
+
+// deterministic ping set: a few cells around Vigo (inside areaBbox) plus one far outside it
+var pingSet = []point{
+	{42.2317, -8.7263, 5},
+	{42.2406, -8.7207, 3},
+	{42.2185, -8.7387, 2},
+	{42.1990, -8.7011, 4},
+	{40.4168, -3.7038, 6}, // Madrid
+}
+
+var areaBbox = [4]float64{42.15, 42.30, -8.80, -8.65} // minLat, maxLat, minLng, maxLng
+
+func postPing(t *testing.T, baseURL string, lat, lng float64) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]float64{"lat": lat, "lng": lng})
+	resp, err := http.Post(baseURL+"/ping", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("POST /ping (%v, %v): got %d %s", lat, lng, resp.StatusCode, msg)
+	}
+}
+
+func getCount(t *testing.T, baseURL string, lat, lng float64) int64 {
+	t.Helper()
+	resp, err := http.Get(fmt.Sprintf("%s/ping?lat=%v&lng=%v", baseURL, lat, lng))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET /ping (%v, %v): got %d %s", lat, lng, resp.StatusCode, msg)
+	}
+	var out struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out.Count
+}
+
+func getAreaTotal(t *testing.T, baseURL string, bbox [4]float64, precision int) int64 {
+	t.Helper()
+	resp, err := http.Get(fmt.Sprintf("%s/pingArea?minLat=%v&maxLat=%v&minLng=%v&maxLng=%v&precision=%d", baseURL, bbox[0], bbox[1], bbox[2], bbox[3], precision))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET /pingArea: got %d %s", resp.StatusCode, msg)
+	}
+	var out map[string]struct{ Count int64 }
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	total := int64(0)
+	for _, c := range out {
+		total += c.Count
+	}
+	return total
+}
+
+func ingest(t *testing.T, c *cluster, points []point) {
+	t.Helper()
+	// alternate gateways so reads on either one depend on shared routing, not local state
+	n := 0
+	for _, p := range points {
+		for i := 0; i < p.pings; i++ {
+			postPing(t, c.urls[n%len(c.urls)], p.lat, p.lng)
+			n++
+		}
+	}
+}
+
+func TestPingCounts(t *testing.T) {
+	c := startCluster(t, 2, 3)
+	ingest(t, c, pingSet)
+
+	for _, url := range c.urls {
+		for _, p := range pingSet {
+			if got := getCount(t, url, p.lat, p.lng); got != int64(p.pings) {
+				t.Errorf("%s: count at (%v, %v) = %d, want %d", url, p.lat, p.lng, got, p.pings)
+			}
+		}
+	}
+}
+
+func TestPingArea(t *testing.T) {
+	c := startCluster(t, 2, 3)
+	ingest(t, c, pingSet)
+
+	want := int64(0)
+	for _, p := range pingSet {
+		if p.lat >= areaBbox[0] && p.lat <= areaBbox[1] && p.lng >= areaBbox[2] && p.lng <= areaBbox[3] {
+			want += int64(p.pings)
+		}
+	}
+
+	// precision 5 is routed to the owning workers, precision 3 is broadcast to all of them
+	for _, precision := range []int{5, 3} {
+		for _, url := range c.urls {
+			if got := getAreaTotal(t, url, areaBbox, precision); got != want {
+				t.Errorf("%s: area total at precision %d = %d, want %d", url, precision, got, want)
+			}
+		}
+	}
+}
+
+func TestTTLExpiry(t *testing.T) {
+	c := startCluster(t, 2, 3)
+	p := pingSet[0]
+	ingest(t, c, []point{p})
+	if got := getCount(t, c.urls[0], p.lat, p.lng); got != int64(p.pings) {
+		t.Fatalf("count before expiry = %d, want %d", got, p.pings)
+	}
+
+	time.Sleep(pingTTL + 2*time.Second)
+
+	for _, url := range c.urls {
+		if got := getCount(t, url, p.lat, p.lng); got != 0 {
+			t.Errorf("%s: count after TTL = %d, want 0", url, got)
+		}
+		if got := getAreaTotal(t, url, areaBbox, 5); got != 0 {
+			t.Errorf("%s: area total after TTL = %d, want 0", url, got)
+		}
+	}
+}
+
+func TestMembershipChurn(t *testing.T) {
+	c := startCluster(t, 2, 3)
+
+	// a dead worker is dropped by every gateway (heartbeat TTL), and the remaining ones serve all writes and reads
+	c.workers[0].stop()
+	c.waitForWorkers(2, 30*time.Second)
+
+	ingest(t, c, pingSet)
+	for _, url := range c.urls {
+		for _, p := range pingSet {
+			if got := getCount(t, url, p.lat, p.lng); got != int64(p.pings) {
+				t.Errorf("%s (2 workers): count at (%v, %v) = %d, want %d", url, p.lat, p.lng, got, p.pings)
+			}
+		}
+	}
+
+	// a restarted worker rejoins every ring
+	c.workers[0].start(t)
+	c.waitForWorkers(3, 30*time.Second)
+
+	p := point{lat: 48.8566, lng: 2.3522, pings: 3} // Paris: not written before
+	ingest(t, c, []point{p})
+	for _, url := range c.urls {
+		if got := getCount(t, url, p.lat, p.lng); got != int64(p.pings) {
+			t.Errorf("%s (rejoined): count at (%v, %v) = %d, want %d", url, p.lat, p.lng, got, p.pings)
+		}
+	}
+}
+
+func TestUDPIngest(t *testing.T) {
+	c := startCluster(t, 2, 3)
+	p := point{lat: 51.5074, lng: -0.1278, pings: 4} // London
+
+	conn, err := net.Dial("udp", c.udpAddrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// one datagram with all records plus a corrupted one, which must be dropped
+	var datagram []byte
+	for i := 0; i < p.pings+1; i++ {
+		rec := make([]byte, 21)
+		rec[0] = 1
+		binary.BigEndian.PutUint32(rec[1:5], uint32(int32(math.Round(p.lat*1e7))))
+		binary.BigEndian.PutUint32(rec[5:9], uint32(int32(math.Round(p.lng*1e7))))
+		binary.BigEndian.PutUint64(rec[9:17], uint64(i))
+		binary.BigEndian.PutUint32(rec[17:21], crc32.ChecksumIEEE(rec[:17]))
+		if i == p.pings {
+			rec[20] ^= 0xFF
+		}
+		datagram = append(datagram, rec...)
+	}
+	if _, err := conn.Write(datagram); err != nil {
+		t.Fatal(err)
+	}
+
+	// ingest is asynchronous: wait for the count to settle
+	deadline := time.Now().Add(5 * time.Second)
+	for getCount(t, c.urls[1], p.lat, p.lng) < int64(p.pings) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := getCount(t, c.urls[1], p.lat, p.lng); got != int64(p.pings) {
+		t.Fatalf("count after UDP ingest = %d, want %d", got, p.pings)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// anomaly detection: with ANOMALY_INTERVAL set, the primary pings leaving the TTL window (like the history tier's) are
+// counted per geohash prefix of ANOMALY_PRECISION and, at the end of each interval, compared to a baseline per prefix:
+//   - ewma (default): exponentially weighted moving average and variance of the prefix's past intervals
+//   - seasonal: the same interval ANOMALY_SEASON ago, from the history tier (HISTORY_RETENTION must cover the season,
+//     HISTORY_PRECISION the prefixes), the variance still an average of past deviations. the ewma is used where the
+//     history holds nothing yet
+//
+// an interval deviating by ANOMALY_THRESHOLD standard deviations or more (at least the baseline's square root, as for
+// counts of random arrivals) is a spike or a drop, once the detector has seen a few intervals and if either count
+// reaches ANOMALY_MIN_COUNT. a prefix seen for the first time has a baseline of 0. anomalies are counted in
+// worker_anomalies_total{kind}, the latest kept for GetAnomalies (the gateway's GET /anomalies) and, with
+// ANOMALY_WEBHOOK_URL, POSTed as JSON after each interval that has any. detection lags the pings by PING_TTL, and sees
+// the synthetic pings of Generate like any other
+var ANOMALY_INTERVAL = env.Duration("ANOMALY_INTERVAL", 0) // 0 disables anomaly detection
+var ANOMALY_PRECISION = clampPrecision(env.Int("ANOMALY_PRECISION", 4))
+var ANOMALY_BASELINE = env.String("ANOMALY_BASELINE", "ewma")
+var ANOMALY_SEASON = env.Duration("ANOMALY_SEASON", 24*time.Hour)
+var ANOMALY_THRESHOLD = env.Float("ANOMALY_THRESHOLD", 4)
+var ANOMALY_MIN_COUNT = int64(env.Int("ANOMALY_MIN_COUNT", 20))
+var ANOMALY_WEBHOOK_URL = env.String("ANOMALY_WEBHOOK_URL", "")
+
+const (
+	anomalyAlpha       = 0.1 // weight of the newest interval in the ewma
+	anomalyWarmup      = 5   // intervals seen before any anomaly is flagged
+	maxAnomalyPrefixes = 1 << 16
+	maxRecentAnomalies = 1024
+	anomalyWebhookWait = 5 * time.Second
+	anomalySpike       = "spike"
+	anomalyDrop        = "drop"
+)
+
+type anomaly struct {
+	Prefix   string  `json:"prefix"`
+	Kind     string  `json:"kind"`
+	Start    int64   `json:"start"` // unix ms
+	End      int64   `json:"end"`
+	Observed int64   `json:"observed"`
+	Expected float64 `json:"expected"`
+	Score    float64 `json:"score"`
+}
+
+// prefixBaseline is the ewma of a prefix's counts per interval, and of the squared deviations from its baseline (of the
+// intervals not anomalous)
+type prefixBaseline struct {
+	mean, variance float64
+}
+
+type anomalyDetector struct {
+	mu        sync.Mutex
+	interval  int64 // seconds
+	precision int
+	seasonal  bool
+	open      int64            // start of the interval being counted (unix seconds), 0 before the first ping
+	counts    map[string]int64 // of the open interval, per prefix
+	baselines map[string]*prefixBaseline
+	closed    int // intervals compared so far
+	recent    []anomaly
+	webhook   chan []anomaly
+}
+
+var anomalies = newAnomalyDetector(ANOMALY_INTERVAL) // nil = disabled
+
+func newAnomalyDetector(interval time.Duration) *anomalyDetector {
+	if interval <= 0 {
+		return nil
+	}
+	d := &anomalyDetector{
+		interval:  max(int64(interval.Seconds()), 1),
+		precision: ANOMALY_PRECISION,
+		counts:    make(map[string]int64),
+		baselines: make(map[string]*prefixBaseline),
+	}
+	switch ANOMALY_BASELINE {
+	case "ewma":
+	case "seasonal":
+		if history == nil || int64(ANOMALY_SEASON.Seconds())+d.interval > history.retention || HISTORY_PRECISION < d.precision {
+			log.Fatalf("ANOMALY_BASELINE=seasonal needs HISTORY_RETENTION of at least ANOMALY_SEASON plus ANOMALY_INTERVAL, and HISTORY_PRECISION of at least ANOMALY_PRECISION")
+		}
+		d.seasonal = true
+	default:
+		log.Fatalf("invalid ANOMALY_BASELINE %q (ewma or seasonal)", ANOMALY_BASELINE)
+	}
+	if ANOMALY_WEBHOOK_URL != "" {
+		d.webhook = make(chan []anomaly, 16)
+		go d.notify(ANOMALY_WEBHOOK_URL)
+	}
+	return d
+}
+
+// record counts the primary pings of a second that left the TTL window
+func (d *anomalyDetector) record(exp ExpiredSecond) {
+	if exp.Replica || isStandby() {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.open == 0 {
+		d.open = exp.Second - exp.Second%d.interval
+	}
+	for gh, c := range exp.Counts {
+		if len(gh) > d.precision {
+			gh = gh[:d.precision]
+		}
+		d.counts[gh] += c // a second older than the open interval (late expiry) is counted in it
+	}
+}
+
+// advance closes the intervals ending by second, every second up to it having been recorded
+func (d *anomalyDetector) advance(second int64) {
+	d.mu.Lock()
+	var flagged []anomaly
+	for d.open != 0 && d.open+d.interval-1 <= second {
+		flagged = append(flagged, d.closeLocked()...)
+	}
+	d.mu.Unlock()
+
+	if len(flagged) > 0 && d.webhook != nil {
+		select {
+		case d.webhook <- flagged:
+		default:
+			Metrics.anomalyWebhooksTotal.WithLabelValues("dropped").Inc()
+		}
+	}
+}
+
+// closeLocked compares the open interval to the baselines, updates them and opens the next interval
+func (d *anomalyDetector) closeLocked() []anomaly {
+	start, end := d.open, d.open+d.interval
+	var seasonal map[string]float64
+	covered := false
+	if d.seasonal {
+		season := int64(ANOMALY_SEASON.Seconds())
+		seasonal, covered = history.prefixCounts(start-season, end-1-season, d.precision)
+	}
+
+	var flagged []anomaly
+	check := func(prefix string, observed int64) {
+		b := d.baselines[prefix]
+		fresh := b == nil
+		if fresh {
+			if observed == 0 || len(d.baselines) >= maxAnomalyPrefixes {
+				return
+			}
+			b = &prefixBaseline{}
+			d.baselines[prefix] = b
+		}
+		expected := b.mean
+		if covered {
+			expected = seasonal[prefix]
+		}
+		deviation := float64(observed) - expected
+		sigma := math.Sqrt(max(b.variance, expected, 1))
+		score := deviation / sigma
+		anomalous := d.closed >= anomalyWarmup && math.Abs(score) >= ANOMALY_THRESHOLD && max(float64(observed), expected) >= float64(ANOMALY_MIN_COUNT)
+		if anomalous {
+			kind := anomalySpike
+			if score < 0 {
+				kind = anomalyDrop
+			}
+			flagged = append(flagged, anomaly{Prefix: prefix, Kind: kind, Start: start * 1000, End: end * 1000, Observed: observed, Expected: math.Round(expected*100) / 100, Score: math.Round(score*100) / 100})
+			Metrics.anomaliesTotal.WithLabelValues(kind).Inc()
+		}
+
+		// ewma of the counts (seeded by the first one), and of the squared deviations from the baseline used. anomalous
+		// intervals only move the mean, so that a spike doesn't hide the anomalies after it
+		switch {
+		case fresh:
+			b.mean = float64(observed)
+		case anomalous:
+			b.mean += anomalyAlpha * (float64(observed) - b.mean)
+		default:
+			b.variance = (1 - anomalyAlpha) * (b.variance + anomalyAlpha*deviation*deviation)
+			b.mean += anomalyAlpha * (float64(observed) - b.mean)
+		}
+		if observed == 0 && b.mean < 0.5 {
+			delete(d.baselines, prefix) // faded away
+		}
+	}
+	for prefix, observed := range d.counts {
+		check(prefix, observed)
+	}
+	for prefix := range d.baselines {
+		if _, ok := d.counts[prefix]; !ok {
+			check(prefix, 0)
+		}
+	}
+
+	d.closed++
+	d.open = end
+	clear(d.counts)
+	sort.Slice(flagged, func(i, j int) bool { return flagged[i].Prefix < flagged[j].Prefix })
+	d.recent = append(d.recent, flagged...)
+	if extra := len(d.recent) - maxRecentAnomalies; extra > 0 {
+		d.recent = append(d.recent[:0], d.recent[extra:]...)
+	}
+	Metrics.anomalyPrefixes.Set(float64(len(d.baselines)))
+	return flagged
+}
+
+// notify POSTs the anomalies of each interval to url, one request per interval
+func (d *anomalyDetector) notify(url string) {
+	client := &http.Client{Timeout: anomalyWebhookWait}
+	for flagged := range d.webhook {
+		body, _ := json.Marshal(map[string]any{"workerId": announcedWorkerId(), "anomalies": flagged})
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		if err != nil {
+			log.Printf("anomaly webhook failed: %v", err)
+			Metrics.anomalyWebhooksTotal.WithLabelValues("failed").Inc()
+			continue
+		}
+		Metrics.anomalyWebhooksTotal.WithLabelValues("sent").Inc()
+	}
+}
+
+func (s *grpcServer) GetAnomalies(ctx context.Context, req *pb.GetAnomaliesRequest) (*pb.GetAnomaliesResponse, error) {
+	d := anomalies
+	if d == nil {
+		return &pb.GetAnomaliesResponse{}, nil
+	}
+	resp := &pb.GetAnomaliesResponse{Enabled: true, IntervalSeconds: d.interval, Precision: int32(d.precision), Baseline: ANOMALY_BASELINE}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, a := range d.recent {
+		if a.End > req.Since {
+			resp.Anomalies = append(resp.Anomalies, &pb.Anomaly{Prefix: a.Prefix, Kind: a.Kind, Start: a.Start, End: a.End, Observed: a.Observed, Expected: a.Expected, Score: a.Score})
+		}
+	}
+	return resp, nil
+}
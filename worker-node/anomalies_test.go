@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// feed records one second per interval of the given counts, and closes the interval
+func feed(d *anomalyDetector, second int64, counts map[string]int64) []anomaly {
+	d.record(ExpiredSecond{Second: second, Counts: counts})
+	d.record(ExpiredSecond{Second: second, Replica: true, Counts: map[string]int64{"u4pruydq": 1000}}) // ignored
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closeLocked()
+}
+
+func TestAnomalyDetectorFlagsSpikesAndDrops(t *testing.T) {
+	d := newAnomalyDetector(time.Minute)
+	second := int64(60_000)
+	steady := map[string]int64{"ezjmgtwq": 95, "ezjmgtwr": 5, "u4pruydq": 3}
+	for i := 0; i < 20; i++ {
+		if flagged := feed(d, second, steady); len(flagged) != 0 {
+			t.Fatalf("interval %d: steady traffic flagged %v", i, flagged)
+		}
+		second += 60
+	}
+
+	flagged := feed(d, second, map[string]int64{"ezjm": 400, "u4pr": 10, "s000": 50})
+	if len(flagged) != 2 {
+		t.Fatalf("got %v, want a spike in ezjm and in the new prefix s000", flagged)
+	}
+	if a := flagged[0]; a.Prefix != "ezjm" || a.Kind != anomalySpike || a.Observed != 400 || a.Expected != 100 || a.Start != second*1000 || a.End != (second+60)*1000 {
+		t.Errorf("got %+v", a)
+	}
+	if a := flagged[1]; a.Prefix != "s000" || a.Kind != anomalySpike {
+		t.Errorf("got %+v", a)
+	}
+	second += 60
+
+	// the spike didn't widen ezjm's baseline: back to nothing is a drop (not u4pr, under ANOMALY_MIN_COUNT)
+	flagged = feed(d, second, map[string]int64{"u4pruydq": 3})
+	if len(flagged) != 2 || flagged[0].Prefix != "ezjm" || flagged[0].Kind != anomalyDrop || flagged[0].Score >= 0 || flagged[1].Prefix != "s000" {
+		t.Errorf("got %v, want drops in ezjm and s000", flagged)
+	}
+}
+
+func TestAnomalyDetectorWarmsUp(t *testing.T) {
+	d := newAnomalyDetector(time.Minute)
+	for i := int64(0); i < anomalyWarmup; i++ {
+		if flagged := feed(d, 60*i, map[string]int64{"ezjm": 1000 * (i % 2)}); len(flagged) != 0 {
+			t.Fatalf("interval %d: flagged %v while warming up", i, flagged)
+		}
+	}
+}
+
+func TestAnomalyDetectorAdvancesWithoutPings(t *testing.T) {
+	d := newAnomalyDetector(time.Minute)
+	d.record(ExpiredSecond{Second: 125, Counts: map[string]int64{"ezjm": 10}})
+	d.advance(178)
+	if d.closed != 0 {
+		t.Fatalf("interval closed before its end")
+	}
+	d.advance(659)
+	if d.closed != 9 || d.open != 660 || len(d.counts) != 0 {
+		t.Errorf("got %d intervals closed, open at %d, counts %v", d.closed, d.open, d.counts)
+	}
+}
+
+func TestGetAnomaliesSince(t *testing.T) {
+	previous := anomalies
+	anomalies = newAnomalyDetector(time.Minute)
+	t.Cleanup(func() { anomalies = previous })
+	anomalies.recent = []anomaly{{Prefix: "ezjm", Kind: anomalySpike, Start: 0, End: 60_000}, {Prefix: "u4pr", Kind: anomalyDrop, Start: 60_000, End: 120_000}}
+
+	resp, err := (&grpcServer{}).GetAnomalies(context.Background(), &pb.GetAnomaliesRequest{Since: 60_000})
+	if err != nil || !resp.Enabled || resp.IntervalSeconds != 60 || len(resp.Anomalies) != 1 || resp.Anomalies[0].Prefix != "u4pr" {
+		t.Errorf("got %v, %v", resp, err)
+	}
+}
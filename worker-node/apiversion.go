@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// requests are refused if sent at a protocol version this build doesn't understand (see proto/version.go), rather than
+// answered with fields misread. gateways send the version negotiated from this worker's heartbeats
+func apiVersionUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if r, ok := req.(interface{ GetApiVersion() uint32 }); ok && !pb.SupportsAPIVersion(r.GetApiVersion()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "unsupported api version %d (supported %d to %d)", r.GetApiVersion(), pb.MIN_API_VERSION, pb.API_VERSION)
+	}
+	return handler(ctx, req)
+}
+
+var registryIncompatible atomic.Bool
+
+// checkRegistryAPIVersion logs when the registry's advertised versions stop (or start again) overlapping with ours
+func checkRegistryAPIVersion(resp *pb.HeartbeatResponse) {
+	_, ok := pb.NegotiateAPIVersion(resp.MinApiVersion, resp.ApiVersion)
+	if registryIncompatible.Swap(!ok) == !ok {
+		return
+	}
+	if !ok {
+		log.Printf("registry speaks api versions %d to %d, this worker %d to %d: upgrade one of them", resp.MinApiVersion, resp.ApiVersion, pb.MIN_API_VERSION, pb.API_VERSION)
+	} else {
+		log.Printf("registry api versions compatible again")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	pb "geostreamdb/proto"
+)
+
+// build identification, set at link time:
+//
+//	go build -ldflags "-X main.buildVersion=v1.2.0 -X main.buildCommit=$(git rev-parse --short HEAD)"
+//
+// without it the version reads "dev" and the commit is taken from the VCS stamp of builds inside a git checkout.
+// served on /version (METRICS_PORT), sent in heartbeats and exposed as worker_build_info
+var buildVersion = "dev"
+var buildCommit = ""
+
+type buildInfo struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	GoVersion     string `json:"goVersion"`
+	APIVersion    uint32 `json:"apiVersion"`
+	MinAPIVersion uint32 `json:"minApiVersion"`
+}
+
+var build = readBuildInfo()
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: buildVersion, Commit: buildCommit, GoVersion: runtime.Version(), APIVersion: pb.API_VERSION, MinAPIVersion: pb.MIN_API_VERSION}
+	if bi, ok := debug.ReadBuildInfo(); ok && info.Commit == "" {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				info.Commit = s.Value
+			}
+		}
+	}
+	Metrics.buildInfo.WithLabelValues(info.Version, info.Commit, info.GoVersion).Set(1)
+	return info
+}
+
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(build)
+}
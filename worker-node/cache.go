@@ -0,0 +1,109 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"geostreamdb/env"
+)
+
+// small LRU of (geohash, second) -> count for GetPings, so dashboards polling the same coordinate don't traverse every
+// slot trie (and take every slot read lock) on each request. SendPing invalidates the entries its ping affects
+var GETPINGS_CACHE_SIZE = env.Int("GETPINGS_CACHE_SIZE", 1024) // 0 disables the cache
+
+type countCacheKey struct {
+	geohash string
+	second  int64
+	replica bool
+}
+
+type countCacheEntry struct {
+	key     countCacheKey
+	count   int64
+	token   uint64 // identifies the lookup that is filling a pending entry
+	pending bool   // reserved on a miss, count not known yet
+}
+
+type countCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // front = most recently used
+	items    map[countCacheKey]*list.Element
+	tokens   uint64
+}
+
+var pingsCache = newCountCache(GETPINGS_CACHE_SIZE)
+
+func newCountCache(capacity int) *countCache {
+	return &countCache{capacity: capacity, ll: list.New(), items: make(map[countCacheKey]*list.Element)}
+}
+
+// get returns the cached count. on a miss it reserves a pending entry and returns the token to fill it with put
+func (c *countCache) get(key countCacheKey) (count int64, token uint64, ok bool) {
+	if c.capacity <= 0 {
+		return 0, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, exists := c.items[key]; exists {
+		entry := el.Value.(*countCacheEntry)
+		c.ll.MoveToFront(el)
+		if !entry.pending {
+			Metrics.getPingsCacheTotal.WithLabelValues("hit").Inc()
+			return entry.count, 0, true
+		}
+		Metrics.getPingsCacheTotal.WithLabelValues("miss").Inc()
+		return 0, entry.token, false // another lookup is computing it, either may fill it
+	}
+
+	Metrics.getPingsCacheTotal.WithLabelValues("miss").Inc()
+	c.tokens++
+	c.items[key] = c.ll.PushFront(&countCacheEntry{key: key, token: c.tokens, pending: true})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*countCacheEntry).key)
+	}
+	return 0, c.tokens, false
+}
+
+// put fills the pending entry reserved by get. it is dropped if a write invalidated the entry in between,
+// as the count may not include that write
+func (c *countCache) put(key countCacheKey, count int64, token uint64) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return
+	}
+	entry := el.Value.(*countCacheEntry)
+	if entry.pending && entry.token == token {
+		entry.count = count
+		entry.pending = false
+	}
+}
+
+// invalidate drops the cached counts of every prefix of geohash for the given second (the ones a new ping changes)
+func (c *countCache) invalidate(geohash string, second int64, replica bool) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.items) == 0 {
+		return
+	}
+	for i := 1; i <= len(geohash); i++ {
+		key := countCacheKey{geohash: geohash[:i], second: second, replica: replica}
+		if el, ok := c.items[key]; ok {
+			c.ll.Remove(el)
+			delete(c.items, key)
+		}
+	}
+}
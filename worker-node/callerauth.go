@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// with WORKER_AUTH, Worker RPCs are only served to the cluster: gateways registered with the registry, which send their
+// gateway id as x-gateway-id metadata, and (on a standby) the primary mirroring its pings, which sends its worker id as
+// x-worker-id. the registry lists both in its heartbeat responses. a gateway no longer listed is still accepted for
+// WORKER_AUTH_GRACE, so a registry restart (gateways registering again) doesn't cut the traffic, and the last list is
+// kept while the registry is unreachable. ids are random and only travel inside the cluster; combine it with
+// GRPC_AUTH_TOKEN for a shared secret as well. SHADOW workers hear from no registry and can't use it
+var WORKER_AUTH = env.Bool("WORKER_AUTH", false)
+var WORKER_AUTH_GRACE = env.Duration("WORKER_AUTH_GRACE", 30*time.Second)
+
+const gatewayIdKey = "x-gateway-id"
+const workerIdKey = "x-worker-id"
+
+var callers = struct {
+	sync.RWMutex
+	listed    bool                 // a heartbeat response was received
+	gateways  map[string]time.Time // gateway id -> last listed
+	primaryId string
+}{gateways: make(map[string]time.Time)}
+
+// setCallers records the callers listed in a heartbeat response
+func setCallers(resp *pb.HeartbeatResponse) {
+	now := monotonicNow()
+	callers.Lock()
+	defer callers.Unlock()
+	callers.listed = true
+	for _, id := range resp.GatewayIds {
+		callers.gateways[id] = now
+	}
+	for id, listedAt := range callers.gateways {
+		if now.Sub(listedAt) > WORKER_AUTH_GRACE {
+			delete(callers.gateways, id)
+		}
+	}
+	callers.primaryId = resp.PrimaryWorkerId
+}
+
+func callerAuthUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !WORKER_AUTH {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	callers.RLock()
+	listed, ok := callers.listed, false
+	for _, id := range md.Get(gatewayIdKey) {
+		if _, found := callers.gateways[id]; found {
+			ok = true
+		}
+	}
+	for _, id := range md.Get(workerIdKey) {
+		ok = ok || (callers.primaryId != "" && id == callers.primaryId)
+	}
+	callers.RUnlock()
+
+	switch {
+	case ok:
+		return handler(ctx, req)
+	case !listed:
+		Metrics.callersRejectedTotal.WithLabelValues("not_listed_yet").Inc()
+		return nil, status.Error(codes.Unavailable, "waiting for the registry's list of gateways")
+	case len(md.Get(gatewayIdKey)) == 0 && len(md.Get(workerIdKey)) == 0:
+		Metrics.callersRejectedTotal.WithLabelValues("anonymous").Inc()
+		return nil, status.Error(codes.Unauthenticated, "gateway id required")
+	default:
+		Metrics.callersRejectedTotal.WithLabelValues("unknown").Inc()
+		return nil, status.Error(codes.PermissionDenied, "not a registered gateway")
+	}
+}
+
+// workerIdUnaryInterceptor identifies a primary to its standby
+func workerIdUnaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(metadata.AppendToOutgoingContext(ctx, workerIdKey, announcedWorkerId()), method, req, reply, cc, opts...)
+}
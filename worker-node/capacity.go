@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// capacity self-reporting: every CAPACITY_INTERVAL the worker estimates the memory of its time buffers (the tries of
+// the live slots, exported as worker_time_buffer_bytes) and samples its process from the collectors /metrics exports
+// (resident memory, CPU time, Go heap, goroutines). the latest sample rides on the heartbeats, so that gateways can
+// sum up the cluster's capacity (GET /admin/capacity) without scraping every worker
+var CAPACITY_INTERVAL = env.Duration("CAPACITY_INTERVAL", 15*time.Second) // 0 = disabled
+
+var capacity atomic.Pointer[pb.WorkerCapacity] // nil before the first sample
+
+func startCapacity() {
+	if CAPACITY_INTERVAL <= 0 {
+		return
+	}
+	go func() {
+		var prevCPU float64
+		prevAt := time.Now()
+		for {
+			c, cpu := sampleCapacity()
+			now := time.Now()
+			if prevCPU > 0 && cpu >= prevCPU {
+				c.CpuCores = (cpu - prevCPU) / now.Sub(prevAt).Seconds()
+			}
+			prevCPU, prevAt = cpu, now
+			capacity.Store(c)
+			time.Sleep(CAPACITY_INTERVAL)
+		}
+	}()
+}
+
+// sampleCapacity measures the time buffers and the process, and returns the CPU seconds used so far (CpuCores is left
+// to the caller, from two samples)
+func sampleCapacity() (*pb.WorkerCapacity, float64) {
+	c := &pb.WorkerCapacity{
+		SampledAt:  monotonicNow().UnixMilli(),
+		MaxProcs:   uint32(runtime.GOMAXPROCS(0)),
+		Goroutines: uint32(runtime.NumGoroutine()),
+	}
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		c.MemoryLimitBytes = uint64(limit)
+	}
+
+	if reporter, ok := engine.(slotReporter); ok {
+		now := monotonicNow().Unix()
+		for _, replica := range []bool{false, true} {
+			buffer := "primary"
+			if replica {
+				buffer = "replica"
+			}
+			bytes := reporter.SlotUsage(now, replica).trieBytes
+			Metrics.timeBufferBytes.WithLabelValues(buffer).Set(float64(bytes))
+			c.BufferBytes += uint64(bytes)
+			if replica {
+				c.ReplicaBufferBytes = uint64(bytes)
+			}
+		}
+	}
+
+	var cpu float64
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		log.Printf("failed to gather process metrics: %v", err) // partial results are still used
+	}
+	for _, family := range families {
+		if len(family.GetMetric()) == 0 {
+			continue
+		}
+		m := family.GetMetric()[0]
+		switch family.GetName() {
+		case "process_resident_memory_bytes":
+			c.ResidentBytes = uint64(m.GetGauge().GetValue())
+		case "process_cpu_seconds_total":
+			cpu = m.GetCounter().GetValue()
+		case "go_memstats_heap_inuse_bytes":
+			c.HeapBytes = uint64(m.GetGauge().GetValue())
+		}
+	}
+	return c, cpu
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+
+	"geostreamdb/env"
+
+	"github.com/google/uuid"
+)
+
+// the time slots, TTL cleanup and heartbeats read and the ids the worker generates go through clock and ids, so that
+// tests can swap in a fake clock (expiry without sleeping) and predictable ids
+type Clock interface {
+	Now() time.Time
+}
+
+type IDGen interface {
+	NewID() string
+}
+
+var (
+	clock Clock = newMonotonicClock()
+	ids   IDGen = uuidGen{}
+)
+
+// wall clock anchored at startup and advanced with the monotonic clock, so TTL buckets never jump when the system clock
+// is stepped
+type monotonicClock struct {
+	base time.Time
+	wall time.Time // wall reading only
+}
+
+func newMonotonicClock() monotonicClock {
+	base := time.Now()
+	return monotonicClock{base: base, wall: base.Round(0)}
+}
+
+func (c monotonicClock) Now() time.Time {
+	return c.wall.Add(time.Since(c.base))
+}
+
+func monotonicNow() time.Time {
+	return clock.Now()
+}
+
+type uuidGen struct{}
+
+func (uuidGen) NewID() string {
+	return uuid.New().String()
+}
+
+// pings are bucketed by the gateway ingest time when it is within MAX_CLOCK_SKEW of the worker clock, so that a ping
+// lands in the same second on every replica. anything further off is treated as a clock problem and uses the worker clock
+var MAX_CLOCK_SKEW = env.Duration("MAX_CLOCK_SKEW", 2*time.Second)
+
+// ingestSecond returns the time buffer second for a ping stamped at timestampMs (unix ms, 0 = unstamped)
+func ingestSecond(timestampMs int64, now time.Time) int64 {
+	if timestampMs <= 0 {
+		return now.Unix()
+	}
+	skew := now.Sub(time.UnixMilli(timestampMs))
+	if skew > MAX_CLOCK_SKEW || skew < -MAX_CLOCK_SKEW {
+		Metrics.clockSkewRejectedTotal.Inc()
+		return now.Unix()
+	}
+	// a slot for a future second still holds live data from PING_TTL seconds ago
+	return min(timestampMs/1000, now.Unix())
+}
+
+// observeIngestLatency records the end-to-end latency of a committed primary ping sent by its client at clientSentAt
+// (unix ms, already moved to this worker's clock by the gateway, 0 = unknown). a client clock slightly ahead (within
+// what the gateway tolerates) counts as no latency
+func observeIngestLatency(clientSentAt int64) {
+	if clientSentAt <= 0 {
+		return
+	}
+	latency := monotonicNow().Sub(time.UnixMilli(clientSentAt))
+	Metrics.ingestLatency.Observe(max(latency, 0).Seconds())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// fakeClock only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// withFakeClock swaps in a fake clock an hour back (clear of the slots other tests write at the real time)
+func withFakeClock(t *testing.T) *fakeClock {
+	previous := clock
+	fake := &fakeClock{now: previous.Now().Add(-time.Hour).Truncate(time.Second)}
+	clock = fake
+	t.Cleanup(func() { clock = previous })
+	return fake
+}
+
+func TestPingsExpireAfterTTL(t *testing.T) {
+	fake := withFakeClock(t)
+	s := &grpcServer{}
+	const gh = "u4pruydq"
+	sent := fake.Now()
+	if _, err := s.SendPing(context.Background(), &pb.PingRequest{Geohash: gh}); err != nil {
+		t.Fatalf("SendPing: %v", err)
+	}
+
+	for _, step := range []struct {
+		after time.Duration
+		want  int64
+	}{
+		{0, 1},
+		{time.Duration(PING_TTL-1) * time.Second, 1},
+		{time.Duration(PING_TTL+1) * time.Second, 0},
+	} {
+		fake.advance(sent.Add(step.after).Sub(fake.Now()))
+		resp, err := s.GetPings(context.Background(), &pb.GetPingsRequest{Geohash: gh})
+		if err != nil || resp.Count != step.want {
+			t.Fatalf("%s after the ping: got %d (err %v), want %d", step.after, resp.GetCount(), err, step.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"hash/fnv"
+
+	"geostreamdb/env"
+)
+
+// coverage hints: a bloom filter of every geohash prefix (precision 1 to SHARDING_PRECISION-1) this worker holds primary
+// data for in the current TTL window. sent with each heartbeat so gateways can skip workers that demonstrably hold
+// nothing relevant for a broadcast query (a negative is exact, a positive may be false)
+var COVERAGE_BLOOM_BITS = env.Int("COVERAGE_BLOOM_BITS", 1<<16) // 0 disables coverage hints
+var COVERAGE_BLOOM_HASHES = env.Int("COVERAGE_BLOOM_HASHES", 4)
+
+func bloomPositions(key []byte, m uint64, k int, fn func(pos uint64)) {
+	// double hashing (Kirsch-Mitzenmacher) over a single 64-bit FNV-1a hash. must match the gateway implementation
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1 := sum & 0xFFFFFFFF
+	h2 := (sum >> 32) | 1
+	for i := 0; i < k; i++ {
+		fn((h1 + uint64(i)*h2) % m)
+	}
+}
+
+func buildCoverageBloom() ([]byte, uint32) {
+	if COVERAGE_BLOOM_BITS <= 0 || COVERAGE_BLOOM_HASHES <= 0 {
+		return nil, 0
+	}
+	bits := make([]byte, (COVERAGE_BLOOM_BITS+7)/8)
+	m := uint64(len(bits) * 8)
+	k := COVERAGE_BLOOM_HASHES
+
+	cov, ok := engine.(prefixCoverage)
+	if !ok {
+		return nil, 0
+	}
+	// broadcast queries only aggregate below the sharding precision
+	cov.CoveredPrefixes(SHARDING_PRECISION-1, func(prefix []byte) {
+		bloomPositions(prefix, m, k, func(pos uint64) {
+			bits[pos/8] |= 1 << (pos % 8)
+		})
+	})
+
+	return bits, uint32(k)
+}
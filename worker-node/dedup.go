@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// ingest dedup: pings carrying a device id and a sequence number (seq > 0) are checked against a sliding window of the
+// last DEDUP_WINDOW sequence numbers seen for that device, so a retry sent through another gateway isn't counted twice.
+// the window follows the newest seq; one DEDUP_WINDOW or more behind it is taken as a device counter reset and
+// restarts the window. devices not heard from for DEDUP_TTL are forgotten. replica pings keep their own windows (a
+// standby shares the mirrored pings' window with the primary pings it takes over)
+var DEDUP_WINDOW = min(env.Int("DEDUP_WINDOW", 64), 64) // sequence numbers per device, 0 disables dedup
+var DEDUP_TTL = env.Duration("DEDUP_TTL", 10*time.Minute)
+
+type dedupKey struct {
+	deviceID string
+	replica  bool
+}
+
+type seqWindow struct {
+	newest   uint64
+	seen     uint64 // bit i: newest-i was seen
+	lastSeen int64  // unix seconds
+}
+
+type dedupTable struct {
+	mu      sync.Mutex
+	windows map[dedupKey]*seqWindow
+}
+
+var dedup = &dedupTable{windows: make(map[dedupKey]*seqWindow)}
+
+// duplicate records seq for the device and reports whether it was already seen
+func (d *dedupTable) duplicate(deviceID string, seq uint64, replica bool, now int64) bool {
+	if DEDUP_WINDOW <= 0 || deviceID == "" || seq == 0 {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := dedupKey{deviceID: deviceID, replica: replica}
+	w := d.windows[key]
+	if w == nil {
+		d.windows[key] = &seqWindow{newest: seq, seen: 1, lastSeen: now}
+		Metrics.dedupDevices.Set(float64(len(d.windows)))
+		return false
+	}
+	w.lastSeen = now
+
+	switch behind := w.newest - seq; {
+	case seq > w.newest:
+		if shift := seq - w.newest; shift < 64 {
+			w.seen = w.seen<<shift | 1
+		} else {
+			w.seen = 1
+		}
+		w.newest = seq
+	case behind >= uint64(DEDUP_WINDOW): // counter reset
+		w.newest, w.seen = seq, 1
+	case w.seen&(1<<behind) != 0:
+		Metrics.duplicatePingsTotal.Inc()
+		return true
+	default:
+		w.seen |= 1 << behind
+	}
+	return false
+}
+
+// forgetDevice drops the device's windows, returning how many there were
+func (d *dedupTable) forgetDevice(deviceID string) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := int64(0)
+	for _, replica := range []bool{false, true} {
+		if _, ok := d.windows[dedupKey{deviceID: deviceID, replica: replica}]; ok {
+			delete(d.windows, dedupKey{deviceID: deviceID, replica: replica})
+			n++
+		}
+	}
+	Metrics.dedupDevices.Set(float64(len(d.windows)))
+	return n
+}
+
+// forget drops the windows of devices not seen since cutoff (unix seconds)
+func (d *dedupTable) forget(cutoff int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, w := range d.windows {
+		if w.lastSeen < cutoff {
+			delete(d.windows, key)
+		}
+	}
+	Metrics.dedupDevices.Set(float64(len(d.windows)))
+}
+
+func dedupCleanupLoop() {
+	if DEDUP_WINDOW <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(DEDUP_TTL/2, time.Second))
+	defer ticker.Stop()
+	for range ticker.C {
+		dedup.forget(monotonicNow().Add(-DEDUP_TTL).Unix())
+	}
+}
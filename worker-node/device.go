@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// device deletion (DELETE /admin/device/{id} at the gateway, broadcast to every worker): the device's retained raw pings
+// are unlinked from it (kept as anonymous pings, so polygon counts still match the aggregated ones), its dedup windows
+// are dropped and the id is tombstoned for PING_TTL seconds, so pings still in flight are stored without it. a primary
+// forwards the deletion to its warm standby, which holds mirrored copies. tombstones are only kept in memory: a worker
+// restarting within PING_TTL of a deletion forgets them and links the device's in-flight pings again (its raw pings
+// didn't survive the restart either, repeat the deletion if that matters)
+var tombstones = struct {
+	mu    sync.Mutex
+	until map[string]int64 // device id -> unix seconds
+}{until: make(map[string]int64)}
+
+func tombstoned(deviceID string, now int64) bool {
+	tombstones.mu.Lock()
+	defer tombstones.mu.Unlock()
+	until, ok := tombstones.until[deviceID]
+	return ok && until >= now
+}
+
+func tombstone(deviceID string, now int64) int64 {
+	tombstones.mu.Lock()
+	defer tombstones.mu.Unlock()
+	for id, until := range tombstones.until { // expired ones are swept here, deletions are rare
+		if until < now {
+			delete(tombstones.until, id)
+		}
+	}
+	tombstones.until[deviceID] = now + PING_TTL
+	return now + PING_TTL
+}
+
+// unlinkRaw removes the device, as ingested by any tenant, from the retained raw pings, returning how many were linked
+// to it
+func unlinkRaw(deviceID string) int64 {
+	n := int64(0)
+	for _, chunk := range rawBuffer {
+		chunk.mu.Lock()
+		for key, idx := range chunk.deviceIdx {
+			if key.id != deviceID {
+				continue
+			}
+			for i, device := range chunk.devices {
+				if device == idx {
+					chunk.devices[i] = 0
+					n++
+				}
+			}
+			chunk.deviceIDs[idx] = rawDevice{}
+			delete(chunk.deviceIdx, key)
+		}
+		chunk.mu.Unlock()
+	}
+	return n
+}
+
+func (s *grpcServer) DeleteDevice(ctx context.Context, req *pb.DeleteDeviceRequest) (*pb.DeleteDeviceResponse, error) {
+	if req.DeviceId == "" || len(req.DeviceId) > rawMaxDeviceID {
+		return nil, status.Error(codes.InvalidArgument, "invalid device id")
+	}
+
+	// tombstoned first so pings arriving meanwhile aren't linked again
+	resp := &pb.DeleteDeviceResponse{TombstonedUntil: tombstone(req.DeviceId, monotonicNow().Unix())}
+	resp.RawPings = unlinkRaw(req.DeviceId)
+	resp.DedupWindows = dedup.forgetDevice(req.DeviceId)
+	motion.forgetDevice(req.DeviceId)
+
+	if standbyClient != nil && !req.Forwarded {
+		_, standbyErr := standbyClient.DeleteDevice(ctx, &pb.DeleteDeviceRequest{DeviceId: req.DeviceId, ApiVersion: pb.API_VERSION, Forwarded: true})
+		resp.Standby = "purged"
+		if standbyErr != nil {
+			resp.Standby = standbyErr.Error()
+		}
+	}
+	Metrics.devicesDeletedTotal.Inc()
+	return resp, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+)
+
+// storage engines hold the ping counts of the TTL window. the gRPC handlers only validate requests, apply the stored
+// precision and cache counts; how (and where) counts are kept is up to the engine selected with STORAGE:
+//   - trie (default): in-memory per-second tries (see trie_engine.go)
+//   - pebble: on-disk counters (see pebble_engine.go), for TTL windows too long to keep in memory
+//   - tiered: the newest seconds in memory, older ones in compressed on-disk blocks (see tiered_engine.go)
+var STORAGE = os.Getenv("STORAGE")
+var PING_TTL = int64(env.Int("PING_TTL", 10)) // seconds
+
+type StorageEngine interface {
+	// Ingest counts one ping in the given second. geohash is valid and already cut to the stored precision
+	Ingest(geohash string, second int64, replica bool)
+	// QueryPoint returns the pings under a geohash prefix in the TTL window ending at now ("" counts every ping)
+	QueryPoint(geohash string, now int64, replica bool) int64
+	// QueryArea returns the count per cell at q.Precision of the cells in the query bbox, in the TTL window ending at now
+	QueryArea(q AreaQuery, now int64, replica bool) map[string]int64
+	// SnapshotExpired is called at every second boundary: it makes room for the new second and, if fn is not nil,
+	// passes it the data that left the TTL window
+	SnapshotExpired(second int64, fn func(ExpiredSecond))
+}
+
+// AreaQuery is a GetPingArea request, adjusted to the stored precision
+type AreaQuery struct {
+	Precision    int32    // precision of the returned cells
+	AggPrecision int32    // precision of the covering geohashes
+	Geohashes    []string // covering geohashes, at AggPrecision
+	MinLat       float64
+	MaxLat       float64
+	MinLng       float64
+	MaxLng       float64
+	Window       int64 // > 0: only the newest Window seconds of the TTL window are counted (per-tenant retention)
+	ByFloor      bool  // only the pings of Floor are counted (engines implementing floorStore)
+	Floor        int32
+}
+
+// cutoff returns the oldest second counted by the query in a window of ttl seconds ending at now
+func (q AreaQuery) cutoff(now, ttl int64) int64 {
+	if q.Window > 0 && q.Window < ttl {
+		return now - q.Window
+	}
+	return now - ttl
+}
+
+// ExpiredSecond holds the counts of one second that left the TTL window
+type ExpiredSecond struct {
+	Second  int64
+	Replica bool
+	Counts  map[string]int64 // geohash -> pings stored at exactly that geohash
+}
+
+// optional engine capabilities. an engine without them simply sends no coverage hints / is not truncated on rollup /
+// can't answer smoothed area queries / doesn't keep the movement or floor of pings
+type prefixCoverage interface {
+	// CoveredPrefixes calls fn with every geohash prefix, up to maxLen characters, of the primary data in the window
+	CoveredPrefixes(maxLen int, fn func(prefix []byte))
+}
+
+type truncatable interface {
+	// Truncate drops the detail finer than precision from the data in the window (counts of coarser cells are kept)
+	Truncate(precision int)
+}
+
+type pointBatcher interface {
+	// QueryPoints returns the QueryPoint count of every (non-empty) geohash, looking each slot up once
+	QueryPoints(geohashes []string, now int64, replica bool) []int64
+}
+
+type areaBySecond interface {
+	// QueryAreaBySecond returns the QueryArea counts of each second of the TTL window ending at now, indexed by age in
+	// seconds (0 = now). seconds without data are nil
+	QueryAreaBySecond(q AreaQuery, now int64, replica bool) []map[string]int64
+}
+
+type movementStore interface {
+	// IngestMovement adds the speed and heading of a ping counted by Ingest
+	IngestMovement(geohash string, second int64, replica bool, m *pb.Motion)
+	// QueryAreaMovement returns the movement of the QueryArea cells holding pings with a speed
+	QueryAreaMovement(q AreaQuery, now int64, replica bool) map[string]*pb.Movement
+}
+
+type slotReporter interface {
+	// SlotUsage returns the occupancy of the in-memory slots of a buffer in the TTL window ending at now
+	SlotUsage(now int64, replica bool) slotUsage
+}
+
+type slotUsage struct {
+	slots     int
+	occupied  int   // slots holding data of the window
+	trieNodes int64 // nodes of the occupied slots' tries
+	trieBytes int64 // estimated memory of those tries
+	trieDepth int
+}
+
+var engine StorageEngine // opened by openStorage, once the cluster settings are known
+
+// openStorage opens the engine and the raw ping buffer, both sized by PING_TTL
+func openStorage() {
+	engine = newStorageEngine(STORAGE)
+	rawBuffer = newRawBuffer()
+}
+
+// closeStorage closes the engine if it holds files (pebble), before the worker exits
+func closeStorage() {
+	if c, ok := engine.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("failed to close storage: %v", err)
+		}
+	}
+}
+
+func newStorageEngine(name string) StorageEngine {
+	switch name {
+	case "", "trie":
+		return newTrieEngine(PING_TTL)
+	case "pebble":
+		e, err := newPebbleEngine(STORAGE_DIR)
+		if err != nil {
+			log.Fatalf("failed to open pebble storage at %s: %v", STORAGE_DIR, err)
+		}
+		return e
+	case "tiered":
+		e, err := newTieredEngine(STORAGE_DIR)
+		if err != nil {
+			log.Fatalf("failed to open tiered storage at %s: %v", STORAGE_DIR, err)
+		}
+		return e
+	}
+	log.Fatalf("unknown STORAGE engine %q", name)
+	return nil
+}
+
+// rotateStorage calls the engine's SnapshotExpired at each second boundary, handing the expired seconds to the history
+// tier and the anomaly detector if enabled
+func rotateStorage() {
+	var expired func(ExpiredSecond)
+	switch {
+	case history != nil && anomalies != nil:
+		expired = func(exp ExpiredSecond) {
+			history.record(exp)
+			anomalies.record(exp)
+		}
+	case history != nil:
+		expired = history.record
+	case anomalies != nil:
+		expired = anomalies.record
+	}
+	for {
+		now := monotonicNow()
+		time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+
+		second := monotonicNow().Unix()
+		engine.SnapshotExpired(second, expired)
+		if anomalies != nil {
+			anomalies.advance(second - PING_TTL)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import pb "geostreamdb/proto"
+
+// workerFeatures lists the optional capabilities of this worker (see the proto's features.go), sent in every heartbeat:
+// those of its storage engine and of the tiers its configuration enables
+func workerFeatures() []string {
+	var features []string
+	if _, ok := engine.(areaBySecond); ok {
+		features = append(features, pb.FeatureFrames)
+	}
+	if history != nil {
+		features = append(features, pb.FeatureHistory)
+	}
+	if _, ok := engine.(movementStore); ok {
+		features = append(features, pb.FeatureMovement)
+	}
+	if _, ok := engine.(floorStore); ok {
+		features = append(features, pb.FeatureFloors)
+	}
+	if RAW_RETENTION {
+		features = append(features, pb.FeatureRaw)
+	}
+	if anomalies != nil {
+		features = append(features, pb.FeatureAnomalies)
+	}
+	return features
+}
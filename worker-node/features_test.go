@@ -0,0 +1,29 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+func TestWorkerFeaturesFollowEngineAndConfig(t *testing.T) {
+	previousEngine, previousHistory, previousAnomalies, previousRaw := engine, history, anomalies, RAW_RETENTION
+	t.Cleanup(func() {
+		engine, history, anomalies, RAW_RETENTION = previousEngine, previousHistory, previousAnomalies, previousRaw
+	})
+
+	engine, history, anomalies, RAW_RETENTION = newTrieEngine(PING_TTL), nil, nil, false
+	if got, want := workerFeatures(), []string{pb.FeatureFrames, pb.FeatureMovement, pb.FeatureFloors}; !slices.Equal(got, want) {
+		t.Errorf("trie engine: %v, want %v", got, want)
+	}
+
+	history, anomalies, RAW_RETENTION = newHistoryTier(time.Hour, time.Second), newAnomalyDetector(time.Minute), true
+	got := workerFeatures()
+	for _, f := range []string{pb.FeatureHistory, pb.FeatureRaw, pb.FeatureAnomalies} {
+		if !slices.Contains(got, f) {
+			t.Errorf("%s missing from %v", f, got)
+		}
+	}
+}
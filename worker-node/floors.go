@@ -0,0 +1,64 @@
+package main
+
+import pb "geostreamdb/proto"
+
+// floors: pings may carry a vertical bucket (a venue's floor, or an altitude band picked by the gateway), so that indoor
+// analytics don't stack every level of a building onto one 2D cell. the trie engine counts them twice: in the slot's
+// trie like every ping (2D queries are unchanged) and in a trie of their floor, next to it. GetPingArea with has_floor
+// only reads the tries of that floor. the other engines and the history tier don't keep floors
+
+// maxFloors bounds the floor tries of a slot (levels beyond are only counted in 2D)
+const maxFloors = 256
+
+type floorStore interface {
+	// IngestFloor counts a ping counted by Ingest in the trie of its floor
+	IngestFloor(geohash string, second int64, replica bool, floor int32)
+}
+
+// floorOf returns the floor of a ping, nil if it has none
+func floorOf(req *pb.PingRequest) *int32 {
+	if !req.HasFloor {
+		return nil
+	}
+	return &req.Floor
+}
+
+// root returns the trie a query reads: the slot's, or that of the query's floor (nil if no ping was on it)
+func (data *TimeBufferElement) root(q AreaQuery) *TrieNode {
+	if !q.ByFloor {
+		return data.TrieRoot
+	}
+	if floors := data.Floors.Load(); floors != nil {
+		return (*floors)[q.Floor]
+	}
+	return nil
+}
+
+func (e *trieEngine) IngestFloor(geohash string, second int64, replica bool, floor int32) {
+	slot := e.buffer(replica)[int(second%e.ttl)*TIME_BUFFER_SHARDS+shardIndex(geohash)]
+
+	slot.Mutex.Lock()
+	defer slot.Mutex.Unlock()
+
+	data := slot.current(second)
+	var floors map[int32]*TrieNode
+	if p := data.Floors.Load(); p != nil {
+		floors = *p
+	}
+	t, ok := floors[floor]
+	if !ok {
+		if len(floors) >= maxFloors {
+			Metrics.floorsDroppedTotal.Inc()
+			return
+		}
+		// copied on write: readers load the map without the lock
+		next := make(map[int32]*TrieNode, len(floors)+1)
+		for f, root := range floors {
+			next[f] = root
+		}
+		t = newTrieNode()
+		next[floor] = t
+		data.Floors.Store(&next)
+	}
+	t.Increment(geohash)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+func TestTrieEngineCountsFloorsApart(t *testing.T) {
+	e := newTrieEngine(10)
+	now := int64(1000)
+	gh := geo.Encode(48.85, 2.35, MAX_GH_PRECISION)
+	for _, floor := range []int32{0, 3, 3, -1} {
+		e.Ingest(gh, now, false)
+		e.IngestFloor(gh, now, false, floor)
+	}
+	e.Ingest(gh, now, false) // no floor: only counted in 2D
+
+	q := AreaQuery{Precision: 6, AggPrecision: 5, Geohashes: []string{gh[:5]}, MinLat: 48, MaxLat: 49, MinLng: 2, MaxLng: 3}
+	for _, tc := range []struct {
+		byFloor bool
+		floor   int32
+		want    int64
+	}{{false, 0, 5}, {true, 3, 2}, {true, -1, 1}, {true, 0, 1}, {true, 7, 0}} {
+		q.ByFloor, q.Floor = tc.byFloor, tc.floor
+		if got := e.QueryArea(q, now, false)[gh[:6]]; got != tc.want {
+			t.Errorf("floor %d (%v): %d, want %d", tc.floor, tc.byFloor, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sort"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// frames: GetPingArea with frame_seconds returns, instead of the counts of the window, those of the pings that arrived
+// in each of `frames` consecutive frames of frame_seconds ending now, oldest first, for the gateway's GET
+// /pingArea/frames (heatmap animations). frames within the live window are added up from its seconds (engines
+// implementing areaBySecond); older ones come from the history tier, prorated from its buckets like history queries,
+// if the request allows it (frames_history) and HISTORY_RETENTION reaches that far. a request needing the history tier
+// is answered at HISTORY_PRECISION at most, every frame alike
+const maxFrames = 3600
+
+// framesNeedHistory reports whether the frames of a request reach past its live window (the TTL window, or the
+// request's shorter one)
+func framesNeedHistory(req *pb.GetPingAreaRequest) bool {
+	live := PING_TTL
+	if req.Window > 0 && req.Window < live {
+		live = req.Window
+	}
+	return req.FrameSeconds*int64(req.Frames) > live
+}
+
+// checkFrames validates the frames of a request against what this worker holds
+func checkFrames(req *pb.GetPingAreaRequest) error {
+	if req.HistoryOffset > 0 || req.Smooth > 1 || req.Movement {
+		return status.Error(codes.InvalidArgument, "frames are not combined with history_offset, smooth or movement")
+	}
+	if req.Frames < 1 || req.Frames > maxFrames {
+		return status.Errorf(codes.InvalidArgument, "frames must be 1 to %d", maxFrames)
+	}
+	if _, ok := engine.(areaBySecond); !ok {
+		return status.Error(codes.FailedPrecondition, "frames are not supported by this storage engine")
+	}
+	if !framesNeedHistory(req) {
+		return nil
+	}
+	if req.HasFloor {
+		return status.Error(codes.InvalidArgument, "floors are only kept for the live window")
+	}
+	if !req.FramesHistory || history == nil || !history.covers(req.FrameSeconds*int64(req.Frames)-PING_TTL) {
+		return status.Error(codes.FailedPrecondition, "frames beyond the live window need the history tier (HISTORY_RETENTION) reaching that far")
+	}
+	return nil
+}
+
+// queryFrames returns the frames of a request checked by checkFrames, q being adjusted to the stored precision
+func queryFrames(q AreaQuery, req *pb.GetPingAreaRequest, now int64) []*pb.PingAreaFrame {
+	bySecond := engine.(areaBySecond).QueryAreaBySecond(q, now, req.Replica)
+	live := int64(len(bySecond)) - 1 // ages 0 to live-1: the oldest second of QueryAreaBySecond is normally in history already
+
+	n, size := int64(req.Frames), req.FrameSeconds
+	out := make([]*pb.PingAreaFrame, n)
+	for i := int64(0); i < n; i++ {
+		newest, oldest := i*size, (i+1)*size-1 // ages of the frame's seconds
+		counts := make(map[string]int64)
+		for age := newest; age <= min(oldest, live-1); age++ {
+			for gh, c := range bySecond[age] {
+				counts[gh] += c
+			}
+		}
+		if oldest >= live && req.FramesHistory && history != nil {
+			for gh, c := range history.queryRange(q, now-oldest, now-max(newest, live), 1, req.Replica) {
+				counts[gh] += c
+			}
+		}
+		out[n-1-i] = &pb.PingAreaFrame{End: now - newest, Counts: sortedAreaCounts(counts)}
+	}
+	return out
+}
+
+func sortedAreaCounts(counts map[string]int64) []*pb.PingAreaCount {
+	keys := make([]string, 0, len(counts))
+	for gh := range counts {
+		keys = append(keys, gh)
+	}
+	sort.Strings(keys)
+	out := make([]*pb.PingAreaCount, 0, len(keys))
+	for _, gh := range keys {
+		out = append(out, &pb.PingAreaCount{Geohash: gh, Count: counts[gh]})
+	}
+	return out
+}
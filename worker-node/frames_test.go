@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+)
+
+func TestQueryFramesSplitsTheLiveWindowAndReachesIntoHistory(t *testing.T) {
+	previousEngine, previousHistory := engine, history
+	engine, history = newTrieEngine(PING_TTL), newHistoryTier(time.Hour, time.Second)
+	t.Cleanup(func() { engine, history = previousEngine, previousHistory })
+
+	gh := geo.Encode(48.85, 2.35, MAX_GH_PRECISION)
+	now := int64(1_000_000)
+	for age := int64(0); age < 2*PING_TTL; age++ {
+		second := now - 2*PING_TTL + 1 + age
+		for range age%3 + 1 { // 1, 2 or 3 pings a second
+			engine.Ingest(gh, second, false)
+		}
+		if second < now {
+			engine.SnapshotExpired(second+1, history.record)
+		}
+	}
+
+	req := &pb.GetPingAreaRequest{FrameSeconds: 2, Frames: int32(PING_TTL), FramesHistory: true}
+	if err := checkFrames(req); err != nil {
+		t.Fatal(err)
+	}
+	q := AreaQuery{Precision: 5, AggPrecision: 5, Geohashes: []string{gh[:5]}, MinLat: 48, MaxLat: 49, MinLng: 2, MaxLng: 3}
+	frames := queryFrames(q, req, now)
+	if len(frames) != int(PING_TTL) || frames[len(frames)-1].End != now || frames[0].End != now-2*PING_TTL+2 {
+		t.Fatalf("got %d frames ending %v", len(frames), frames)
+	}
+	for i, f := range frames {
+		// the frame's two seconds, oldest first
+		first := now - 2*PING_TTL + 1 + int64(2*i)
+		want := (first-(now-2*PING_TTL+1))%3 + 1 + (first+1-(now-2*PING_TTL+1))%3 + 1
+		if len(f.Counts) != 1 || f.Counts[0].Count != want {
+			t.Errorf("frame %d ending %d: %v, want %d pings", i, f.End, f.Counts, want)
+		}
+	}
+
+	req.FramesHistory = false
+	if err := checkFrames(req); err == nil {
+		t.Errorf("frames beyond the live window accepted without the history tier")
+	}
+}
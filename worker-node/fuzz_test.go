@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMain(m *testing.M) {
+	openStorage()
+	os.Exit(m.Run())
+}
+
+// go test -fuzz FuzzXxx -fuzztime 30s (the seed corpus runs as part of go test)
+
+func FuzzSendPing(f *testing.F) {
+	f.Add("ezjmgtwq", false, int64(0))
+	f.Add("EZJMGTWQ", true, int64(1))
+	f.Add("", false, int64(-1))
+	f.Add("ezjm", false, int64(1<<62))
+	f.Add("ezjmgtwqzzzzzzzz", true, int64(0))
+	f.Add("ezj\x00", false, int64(0))
+	f.Add("a", false, int64(0))
+	s := &grpcServer{}
+
+	f.Fuzz(func(t *testing.T, geohash string, replica bool, timestamp int64) {
+		_, err := s.SendPing(context.Background(), &pb.PingRequest{Geohash: geohash, Replica: replica, Timestamp: timestamp})
+		if !validGeohash(geohash) {
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("%q: accepted invalid geohash (err %v)", geohash, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", geohash, err)
+		}
+		resp, err := s.GetPings(context.Background(), &pb.GetPingsRequest{Geohash: geohash, Replica: replica})
+		if err != nil || resp.Count < 1 {
+			t.Fatalf("%q: stored ping not counted (count %d, err %v)", geohash, resp.GetCount(), err)
+		}
+	})
+}
+
+func FuzzGetPingArea(f *testing.F) {
+	f.Add(int32(6), int32(4), 42.13, 42.33, -8.82, -8.62, "ezjm,ezjq", false)
+	f.Add(int32(8), int32(8), -90.0, 90.0, -180.0, 180.0, "ezjmgtwq", false)
+	f.Add(int32(2), int32(5), 0.0, 0.0, 0.0, 0.0, "s0000", true)
+	f.Add(int32(-1), int32(0), 1.0, -1.0, 1.0, -1.0, "", false)
+	f.Add(int32(1<<30), int32(1<<30), 0.0, 1.0, 0.0, 1.0, "zzzzzzzzzzzzzzzzzzzz", false)
+	f.Add(int32(7), int32(3), -90.0, 90.0, -180.0, 180.0, "EZJ,!!,", true)
+	s := &grpcServer{}
+	// some data for the queries to walk through
+	for _, gh := range []string{"ezjmgtwq", "ezjmgtwr", "ezjqxxxx", "s0000000", "zzzzzzzz"} {
+		s.SendPing(context.Background(), &pb.PingRequest{Geohash: gh})
+	}
+
+	f.Fuzz(func(t *testing.T, precision, aggPrecision int32, minLat, maxLat, minLng, maxLng float64, geohashes string, replica bool) {
+		resp, err := s.GetPingArea(context.Background(), &pb.GetPingAreaRequest{
+			Precision: precision, AggPrecision: aggPrecision,
+			MinLat: minLat, MaxLat: maxLat, MinLng: minLng, MaxLng: maxLng,
+			Geohashes: strings.Split(geohashes, ","),
+			Replica:   replica,
+		})
+		if err != nil {
+			return
+		}
+		for _, c := range resp.Counts {
+			if c.Count < 0 {
+				t.Fatalf("negative count %d for %q", c.Count, c.Geohash)
+			}
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// synthetic data for benchmarks: Generate (the gateway's POST /admin/generate) starts a generator writing `rate` pings
+// per second into this worker's primary buffer for `duration_seconds`, so that query performance and the heatmaps can
+// be exercised without an external load generator. the pings fall in the request's area following a distribution:
+//   - uniform: anywhere
+//   - hotspots: around `hotspots` centers, the first ones busier (zipf-like weights)
+//   - front: in a band sweeping across the area, in a direction picked by the seed, in period_seconds; its pings carry
+//     the front's speed and heading (see movement.go)
+//
+// the hotspots and fronts depend on the seed only, so workers given the same one draw the same. the pings are this
+// worker's wherever they fall (they don't go through the ring nor to replicas and standbys): broadcast queries count
+// them all, queries routed by shard key only those in the shards the worker owns. one generator runs at a time; a new
+// request replaces it
+const (
+	maxGenerateRate     = 1000000
+	maxGenerateDuration = 24 * 60 * 60
+	maxGenerateHotspots = 1000
+	defaultHotspots     = 16
+	generateTick        = 100 * time.Millisecond
+)
+
+type generation struct {
+	distribution string
+	endsAt       time.Time
+	generated    atomic.Int64
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+var generator struct {
+	sync.Mutex
+	current *generation // running or last
+}
+
+// pingSource picks the location (and motion) of a synthetic ping at now
+type pingSource interface {
+	next(rng *rand.Rand, now time.Time) (lat, lng float64, motion *pb.Motion)
+}
+
+func (s *grpcServer) Generate(ctx context.Context, req *pb.GenerateRequest) (*pb.GenerateResponse, error) {
+	if req.Stop {
+		generator.Lock()
+		defer generator.Unlock()
+		if g := generator.current; g != nil {
+			g.cancel()
+			<-g.done
+		}
+		return generationStatus(generator.current), nil
+	}
+
+	source, err := newPingSource(req)
+	if err != nil {
+		return nil, err
+	}
+	if isStandby() || SHADOW {
+		return nil, status.Error(codes.FailedPrecondition, "standby and shadow workers don't generate pings")
+	}
+
+	generator.Lock()
+	defer generator.Unlock()
+	if g := generator.current; g != nil {
+		g.cancel()
+		<-g.done
+	}
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	genCtx, cancel := context.WithTimeout(context.Background(), duration)
+	g := &generation{distribution: req.Distribution, endsAt: time.Now().Add(duration), cancel: cancel, done: make(chan struct{})}
+	generator.current = g
+	log.Printf("generating %d %s pings per second for %s", req.Rate, req.Distribution, duration)
+	go g.run(genCtx, source, req.Rate)
+	return generationStatus(g), nil
+}
+
+func generationStatus(g *generation) *pb.GenerateResponse {
+	if g == nil {
+		return &pb.GenerateResponse{}
+	}
+	running := true
+	select {
+	case <-g.done:
+		running = false
+	default:
+	}
+	return &pb.GenerateResponse{Running: running, Distribution: g.distribution, Generated: g.generated.Load(), EndsAt: g.endsAt.UnixMilli()}
+}
+
+func (g *generation) run(ctx context.Context, source pingSource, rate int64) {
+	defer close(g.done)
+	defer g.cancel()
+	rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())) // every worker samples its own pings
+	ticker := time.NewTicker(generateTick)
+	defer ticker.Stop()
+
+	perTick, owed := float64(rate)*generateTick.Seconds(), 0.0
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("generator stopped after %d pings", g.generated.Load())
+			return
+		case <-ticker.C:
+		}
+		nowTime := monotonicNow()
+		second := nowTime.Unix()
+		n := int64(0)
+		for owed += perTick; owed >= 1; owed-- {
+			lat, lng, motion := source.next(rng, nowTime)
+			gh := truncateToStored(geo.Encode(lat, lng, MAX_GH_PRECISION))
+			engine.Ingest(gh, second, false)
+			if store, ok := engine.(movementStore); ok && motion != nil {
+				store.IngestMovement(gh, second, false, motion)
+			}
+			pingsCache.invalidate(gh, second, false)
+			n++
+		}
+		g.generated.Add(n)
+		Metrics.generatedPingsTotal.Add(float64(n))
+	}
+}
+
+// newPingSource checks a request and returns the distribution it asks for
+func newPingSource(req *pb.GenerateRequest) (pingSource, error) {
+	area := geo.Bbox{MinLat: req.MinLat, MaxLat: req.MaxLat, MinLng: req.MinLng, MaxLng: req.MaxLng}
+	if !area.Valid() || area.MinLat == area.MaxLat || area.MinLng == area.MaxLng {
+		return nil, status.Error(codes.InvalidArgument, "invalid area")
+	}
+	if req.Rate < 1 || req.Rate > maxGenerateRate {
+		return nil, status.Errorf(codes.InvalidArgument, "rate must be 1 to %d pings per second", maxGenerateRate)
+	}
+	if req.DurationSeconds < 1 || req.DurationSeconds > maxGenerateDuration {
+		return nil, status.Errorf(codes.InvalidArgument, "duration must be 1 to %d seconds", maxGenerateDuration)
+	}
+	layout := rand.New(rand.NewPCG(req.Seed, req.Seed^0x9e3779b97f4a7c15))
+
+	switch req.Distribution {
+	case "uniform":
+		return uniformSource{area}, nil
+	case "hotspots":
+		n := int(req.Hotspots)
+		if n == 0 {
+			n = defaultHotspots
+		}
+		if n < 1 || n > maxGenerateHotspots {
+			return nil, status.Errorf(codes.InvalidArgument, "hotspots must be 1 to %d", maxGenerateHotspots)
+		}
+		return newHotspotSource(area, n, layout), nil
+	case "front":
+		period := req.PeriodSeconds
+		if period == 0 {
+			period = req.DurationSeconds
+		}
+		if period < 1 || period > maxGenerateDuration {
+			return nil, status.Errorf(codes.InvalidArgument, "period must be 1 to %d seconds", maxGenerateDuration)
+		}
+		return newFrontSource(area, time.Duration(period)*time.Second, layout), nil
+	}
+	return nil, status.Error(codes.InvalidArgument, "distribution must be uniform, hotspots or front")
+}
+
+type uniformSource struct {
+	area geo.Bbox
+}
+
+func (s uniformSource) next(rng *rand.Rand, now time.Time) (float64, float64, *pb.Motion) {
+	return s.area.MinLat + rng.Float64()*(s.area.MaxLat-s.area.MinLat), s.area.MinLng + rng.Float64()*(s.area.MaxLng-s.area.MinLng), nil
+}
+
+type hotspotSource struct {
+	area    geo.Bbox
+	centers [][2]float64
+	spread  [][2]float64 // degrees of latitude and longitude (standard deviation)
+	cumul   []float64    // cumulative weights
+}
+
+func newHotspotSource(area geo.Bbox, n int, layout *rand.Rand) *hotspotSource {
+	s := &hotspotSource{area: area}
+	uniform := uniformSource{area}
+	total := 0.0
+	for i := 0; i < n; i++ {
+		lat, lng, _ := uniform.next(layout, time.Time{})
+		scale := 0.005 + 0.02*layout.Float64() // of the area
+		s.centers = append(s.centers, [2]float64{lat, lng})
+		s.spread = append(s.spread, [2]float64{scale * (area.MaxLat - area.MinLat), scale * (area.MaxLng - area.MinLng)})
+		total += 1 / float64(i+1)
+		s.cumul = append(s.cumul, total)
+	}
+	return s
+}
+
+func (s *hotspotSource) next(rng *rand.Rand, now time.Time) (float64, float64, *pb.Motion) {
+	pick := rng.Float64() * s.cumul[len(s.cumul)-1]
+	i := 0
+	for i < len(s.cumul)-1 && s.cumul[i] <= pick {
+		i++
+	}
+	lat := s.centers[i][0] + rng.NormFloat64()*s.spread[i][0]
+	lng := s.centers[i][1] + rng.NormFloat64()*s.spread[i][1]
+	return clamp(lat, s.area.MinLat, s.area.MaxLat), clamp(lng, s.area.MinLng, s.area.MaxLng), nil
+}
+
+// frontSource: a band sweeping across the area, in local kilometers around its center
+type frontSource struct {
+	area                 geo.Bbox
+	centerLat, centerLng float64
+	kmPerLng             float64
+	dirX, dirY           float64 // unit direction of travel (east, north)
+	length, width        float64 // km: extent of the area along and across the direction
+	band                 float64 // km: standard deviation of the band
+	period               time.Duration
+	motion               *pb.Motion
+}
+
+const kmPerDegree = 111.32
+
+func newFrontSource(area geo.Bbox, period time.Duration, layout *rand.Rand) *frontSource {
+	heading := layout.Float64() * 360
+	s := &frontSource{
+		area:      area,
+		centerLat: (area.MinLat + area.MaxLat) / 2,
+		centerLng: (area.MinLng + area.MaxLng) / 2,
+		period:    period,
+	}
+	s.kmPerLng = kmPerDegree * math.Max(math.Cos(s.centerLat*math.Pi/180), 0.01)
+	s.dirX, s.dirY = math.Sin(heading*math.Pi/180), math.Cos(heading*math.Pi/180)
+	halfW, halfH := (area.MaxLng-area.MinLng)/2*s.kmPerLng, (area.MaxLat-area.MinLat)/2*kmPerDegree
+	s.length = 2 * (halfW*math.Abs(s.dirX) + halfH*math.Abs(s.dirY))
+	s.width = 2 * (halfW*math.Abs(s.dirY) + halfH*math.Abs(s.dirX))
+	s.band = s.length / 40
+	s.motion = &pb.Motion{Speed: s.length * 1000 / period.Seconds(), HasHeading: true, Heading: heading}
+	return s
+}
+
+func (s *frontSource) next(rng *rand.Rand, now time.Time) (float64, float64, *pb.Motion) {
+	progress := float64(now.UnixNano()%int64(s.period)) / float64(s.period)
+	for attempt := 0; ; attempt++ {
+		along := (progress-0.5)*s.length + rng.NormFloat64()*s.band
+		across := (rng.Float64() - 0.5) * s.width
+		east, north := along*s.dirX+across*s.dirY, along*s.dirY-across*s.dirX
+		lat, lng := s.centerLat+north/kmPerDegree, s.centerLng+east/s.kmPerLng
+		inside := lat >= s.area.MinLat && lat <= s.area.MaxLat && lng >= s.area.MinLng && lng <= s.area.MaxLng
+		if inside || attempt == 8 { // the corners of a diagonal band fall outside
+			return clamp(lat, s.area.MinLat, s.area.MaxLat), clamp(lng, s.area.MinLng, s.area.MaxLng), s.motion
+		}
+	}
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var vigo = geo.Bbox{MinLat: 42.13, MaxLat: 42.33, MinLng: -8.82, MaxLng: -8.62}
+
+func generateRequest(distribution string) *pb.GenerateRequest {
+	return &pb.GenerateRequest{
+		Distribution: distribution,
+		MinLat:       vigo.MinLat, MaxLat: vigo.MaxLat, MinLng: vigo.MinLng, MaxLng: vigo.MaxLng,
+		Rate: 100, DurationSeconds: 60, Seed: 7,
+	}
+}
+
+func TestPingSourcesStayInTheArea(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	now := time.Unix(1000, 0)
+	for _, distribution := range []string{"uniform", "hotspots", "front"} {
+		source, err := newPingSource(generateRequest(distribution))
+		if err != nil {
+			t.Fatalf("%s: %v", distribution, err)
+		}
+		for i := 0; i < 10000; i++ {
+			lat, lng, _ := source.next(rng, now.Add(time.Duration(i)*time.Millisecond))
+			if lat < vigo.MinLat || lat > vigo.MaxLat || lng < vigo.MinLng || lng > vigo.MaxLng {
+				t.Fatalf("%s: %f,%f outside the area", distribution, lat, lng)
+			}
+		}
+	}
+}
+
+func TestHotspotsDependOnTheSeed(t *testing.T) {
+	a, _ := newPingSource(generateRequest("hotspots"))
+	b, _ := newPingSource(generateRequest("hotspots"))
+	if a.(*hotspotSource).centers[0] != b.(*hotspotSource).centers[0] {
+		t.Errorf("same seed, different hotspots")
+	}
+	other := generateRequest("hotspots")
+	other.Seed++
+	c, _ := newPingSource(other)
+	if a.(*hotspotSource).centers[0] == c.(*hotspotSource).centers[0] {
+		t.Errorf("different seeds, same hotspots")
+	}
+}
+
+func TestFrontMovesAlongItsHeading(t *testing.T) {
+	source, err := newPingSource(generateRequest("front"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := source.(*frontSource)
+	rng := rand.New(rand.NewPCG(1, 2))
+	// mean position along the heading, in km from the center
+	along := func(now time.Time) float64 {
+		sum := 0.0
+		for i := 0; i < 2000; i++ {
+			lat, lng, motion := front.next(rng, now)
+			if motion == nil || !motion.HasHeading {
+				t.Fatalf("front ping without motion")
+			}
+			sum += (lng-front.centerLng)*front.kmPerLng*front.dirX + (lat-front.centerLat)*kmPerDegree*front.dirY
+		}
+		return sum / 2000
+	}
+	start := time.Unix(0, 0).Add(front.period / 4)
+	if before, after := along(start), along(start.Add(front.period/2)); after-before < front.length/4 {
+		t.Errorf("front went from %.2f to %.2f km (length %.2f)", before, after, front.length)
+	}
+	if math.Abs(front.motion.Speed-front.length*1000/60) > 1e-6 {
+		t.Errorf("speed %f m/s, want the area's length over the period", front.motion.Speed)
+	}
+}
+
+func TestGenerateRejectsInvalidRequests(t *testing.T) {
+	s := &grpcServer{}
+	for name, edit := range map[string]func(*pb.GenerateRequest){
+		"distribution": func(r *pb.GenerateRequest) { r.Distribution = "gaussian" },
+		"empty area":   func(r *pb.GenerateRequest) { r.MaxLat = r.MinLat },
+		"rate":         func(r *pb.GenerateRequest) { r.Rate = 0 },
+		"duration":     func(r *pb.GenerateRequest) { r.DurationSeconds = maxGenerateDuration + 1 },
+		"hotspots":     func(r *pb.GenerateRequest) { r.Distribution, r.Hotspots = "hotspots", maxGenerateHotspots+1 },
+	} {
+		req := generateRequest("uniform")
+		edit(req)
+		if _, err := s.Generate(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: got %v, want InvalidArgument", name, err)
+		}
+	}
+}
+
+func TestGenerateWritesPingsUntilStopped(t *testing.T) {
+	s := &grpcServer{}
+	req := generateRequest("uniform")
+	req.Rate = 1000
+	resp, err := s.Generate(context.Background(), req)
+	if err != nil || !resp.Running || resp.Distribution != "uniform" {
+		t.Fatalf("got %v, %v", resp, err)
+	}
+	time.Sleep(3 * generateTick)
+
+	resp, err = s.Generate(context.Background(), &pb.GenerateRequest{Stop: true})
+	if err != nil || resp.Running || resp.Generated < 100 {
+		t.Fatalf("stopped: got %v, %v", resp, err)
+	}
+	count, err := s.GetPingArea(context.Background(), &pb.GetPingAreaRequest{
+		Precision: 4, AggPrecision: 4, Geohashes: vigo.Cover(4),
+		MinLat: vigo.MinLat, MaxLat: vigo.MaxLat, MinLng: vigo.MinLng, MaxLng: vigo.MaxLng,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := int64(0)
+	for _, c := range count.Counts {
+		total += c.Count
+	}
+	if total < resp.Generated {
+		t.Errorf("counted %d of %d generated pings", total, resp.Generated)
+	}
+}
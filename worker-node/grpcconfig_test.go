@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
+
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+)
+
+func TestDebugServicesListTheWorkerService(t *testing.T) {
+	previous := rpc.GRPC_DEBUG
+	rpc.GRPC_DEBUG = true
+	t.Cleanup(func() { rpc.GRPC_DEBUG = previous })
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := grpc.NewServer(grpcInterceptors.ServerOptions()...)
+	pb.RegisterWorkerServer(s, &grpcServer{})
+	rpc.RegisterDebugServices(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), rpc.DialOptions()...)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatalf("reflection: %v", err)
+	}
+	stream.Send(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_ListServices{}})
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("reflection: %v", err)
+	}
+	names := map[string]bool{}
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		names[s.Name] = true
+	}
+	if !names["geostreamdb.Worker"] || !names["grpc.channelz.v1.Channelz"] {
+		t.Errorf("got services %v", names)
+	}
+}
@@ -2,26 +2,49 @@ package main
 
 import (
 	"context"
+	"geostreamdb/env"
 	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
 	"log"
 	"os"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func new_grpc_client(gatewayAddress string) (*grpc.ClientConn, pb.GatewayClient) {
-	conn, err := grpc.NewClient(gatewayAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(gatewayAddress, rpc.DialOptions()...)
 	if err != nil {
 		log.Fatalf("failed to dial: %v", err)
 	}
 	return conn, pb.NewGatewayClient(conn)
 }
 
+// canary workers fed by a gateway's SHADOW_WORKERS don't heartbeat, so no gateway routes to or reads from them
+var SHADOW = env.Bool("SHADOW", false)
+
+// exit code when the registry asks for a restart (non-zero, so "on-failure" restart policies start the worker again)
+const restartExitCode = 3
+
+var draining atomic.Bool
+
+// setDraining tracks whether a rolling restart is draining this worker (gateways route around it meanwhile)
+func setDraining(d bool) {
+	if draining.Swap(d) == d {
+		return
+	}
+	if d {
+		Metrics.draining.Set(1)
+		log.Printf("draining for a rolling restart")
+	} else {
+		Metrics.draining.Set(0)
+	}
+}
+
 func send_heartbeat(client pb.GatewayClient) {
-	workerId := uuid.New().String()
 	// use pod IP if available (Kubernetes), otherwise use hostname (Docker Compose)
 	address := os.Getenv("WORKER_ADDRESS")
 	if address == "" {
@@ -37,16 +60,64 @@ func send_heartbeat(client pb.GatewayClient) {
 	ticker := time.NewTicker(3 * time.Second)
 	defer ticker.Stop()
 
-	for ; ; <-ticker.C {
+	seq := uint64(0) // epoch: startedAt
+	for {
+		seq++
+		bloom, hashes := buildCoverageBloom()
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		start := time.Now()
-		_, err := client.Heartbeat(ctx, &pb.HeartbeatRequest{WorkerId: workerId, Address: fullAddress})
-		observeGRPC("Gateway.Heartbeat", err, start)
+		id, standbyFor := announcedWorkerId(), ""
+		if isStandby() {
+			standbyFor = STANDBY_FOR
+		}
+		resp, err := client.Heartbeat(ctx, &pb.HeartbeatRequest{
+			WorkerId:        id,
+			Address:         fullAddress,
+			CoverageBloom:   bloom,
+			CoverageHashes:  hashes,
+			SentAt:          monotonicNow().UnixMilli(),
+			ApiVersion:      pb.API_VERSION,
+			MinApiVersion:   pb.MIN_API_VERSION,
+			StandbyFor:      standbyFor,
+			BuildVersion:    build.Version,
+			SettingsVersion: settingsVersion.Load(),
+			Capacity:        capacity.Load(),
+			Seq:             seq,
+			SeqEpoch:        startedAt.UnixMilli(),
+			Features:        workerFeatures(),
+		})
+		grpcInterceptors.Observe("Gateway.Heartbeat", err, start)
 		if err != nil {
 			log.Printf("failed to send heartbeat: %v", err)
+			if status.Code(err) == codes.FailedPrecondition { // replaced by its standby
+				dropSavedWorkerId()
+			}
+		} else {
+			checkRegistryAPIVersion(resp)
+			checkClusterSettings(resp.Settings)
+			setCallers(resp)
+			setDraining(resp.Draining)
+			if resp.Restart {
+				log.Printf("drained for a rolling restart: exiting")
+				closeStorage()
+				os.Exit(restartExitCode)
+			}
+			if resp.PromoteAs != "" && promote(resp.PromoteAs) {
+				cancel()
+				continue // announce the taken over worker id right away
+			}
 		}
 		// log.Printf("heartbeat sent")
 
 		cancel()
+		<-ticker.C
+	}
+}
+
+// announcedWorkerId returns the id sent in heartbeats: the primary's once promoted from standby
+func announcedWorkerId() string {
+	if promoted := promotedAs.Load(); promoted != nil {
+		return *promoted
 	}
+	return workerId
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// history tier: with HISTORY_RETENTION set, every second leaving the TTL window (whatever the engine) is added to a
+// bucket of HISTORY_GRANULARITY, at HISTORY_PRECISION at most, and kept for HISTORY_RETENTION. GetPingArea with a
+// history_offset answers from it: the counts of a TTL window that ended that long ago, prorated from the buckets it
+// overlaps (so a 10s window inside a 1m bucket gets a sixth of its pings). held in memory only: a restarted worker
+// starts with an empty history, and after a rebalance the history of a shard stays with its previous owner
+var HISTORY_RETENTION = env.Duration("HISTORY_RETENTION", 0) // 0 disables the history tier
+var HISTORY_GRANULARITY = env.Duration("HISTORY_GRANULARITY", time.Minute)
+var HISTORY_PRECISION = clampPrecision(env.Int("HISTORY_PRECISION", 6))
+
+type historyKey struct {
+	start   int64 // unix seconds, a multiple of the granularity
+	replica bool
+}
+
+type historyBucket struct {
+	counts  map[string]int64 // geohash -> pings stored at exactly that geohash
+	entries []historyEntry   // counts sorted by geohash, rebuilt on the first query after a change
+	dirty   bool
+}
+
+type historyEntry struct {
+	geohash string
+	count   int64
+}
+
+type historyTier struct {
+	mu          sync.Mutex
+	granularity int64 // seconds
+	retention   int64 // seconds
+	buckets     map[historyKey]*historyBucket
+}
+
+var history = newHistoryTier(HISTORY_RETENTION, HISTORY_GRANULARITY) // nil = disabled
+
+func newHistoryTier(retention, granularity time.Duration) *historyTier {
+	if retention <= 0 {
+		return nil
+	}
+	return &historyTier{
+		granularity: max(int64(granularity.Seconds()), 1),
+		retention:   int64(retention.Seconds()),
+		buckets:     make(map[historyKey]*historyBucket),
+	}
+}
+
+// record adds a second that left the TTL window (the SnapshotExpired callback)
+func (h *historyTier) record(exp ExpiredSecond) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := historyKey{start: exp.Second - exp.Second%h.granularity, replica: exp.Replica}
+	b := h.buckets[key]
+	if b == nil {
+		b = &historyBucket{counts: make(map[string]int64)}
+		h.buckets[key] = b
+	}
+	for gh, c := range exp.Counts {
+		if len(gh) > HISTORY_PRECISION {
+			gh = gh[:HISTORY_PRECISION]
+		}
+		b.counts[gh] += c
+	}
+	b.dirty = true
+
+	// buckets whose last second left the retention
+	cutoff := exp.Second - h.retention
+	entries := 0
+	for k, b := range h.buckets {
+		if k.start+h.granularity <= cutoff {
+			delete(h.buckets, k)
+			continue
+		}
+		entries += len(b.counts)
+	}
+	Metrics.historyBuckets.Set(float64(len(h.buckets)))
+	Metrics.historyEntries.Set(float64(entries))
+}
+
+// covers reports whether the window ending offset seconds before now is still within the retention
+func (h *historyTier) covers(offset int64) bool {
+	return offset > 0 && offset+PING_TTL <= h.retention
+}
+
+// queryArea returns the counts of the query cells in the TTL window (or q.Window) ending at end, prorated from the
+// buckets it overlaps. with a granularity coarser than the tier's, the window gets its share of the whole
+// granularity-aligned span around it instead, as if only buckets of that granularity were kept. q must not be finer
+// than HISTORY_PRECISION
+func (h *historyTier) queryArea(q AreaQuery, end int64, granularity int64, replica bool) map[string]int64 {
+	if q.Precision < 1 || q.AggPrecision < 1 {
+		return nil
+	}
+	window := end - q.cutoff(end, PING_TTL)
+	from, factor := end-window+1, 1.0
+	if granularity > h.granularity {
+		from = end - end%granularity
+		end = from + granularity - 1
+		factor = float64(window) / float64(granularity)
+	}
+	return h.queryRange(q, from, end, factor, replica)
+}
+
+// queryRange returns factor times the counts of the query cells in the seconds from to end (inclusive), prorated from
+// the buckets they overlap
+func (h *historyTier) queryRange(q AreaQuery, from, end int64, factor float64, replica bool) map[string]int64 {
+	if q.Precision < 1 || q.AggPrecision < 1 {
+		return nil
+	}
+	queryBbox := geo.Bbox{MinLat: q.MinLat, MaxLat: q.MaxLat, MinLng: q.MinLng, MaxLng: q.MaxLng}
+	sums := make(map[string]float64)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for start := from - from%h.granularity; start <= end; start += h.granularity {
+		b := h.buckets[historyKey{start: start, replica: replica}]
+		if b == nil {
+			continue
+		}
+		overlap := min(end, start+h.granularity-1) - max(from, start) + 1
+		share := float64(overlap) / float64(h.granularity) * factor
+		entries := b.sorted()
+
+		for _, geohash := range q.Geohashes {
+			if len(geohash) < int(q.AggPrecision) || !validGeohash(geohash) {
+				continue
+			}
+			aggCellGh := geohash[:q.AggPrecision]
+			coarser := q.Precision <= q.AggPrecision
+			if coarser {
+				// the covered cell's count goes to its prefix (see TrieNode.GetAreaCount)
+				cell, ok := geo.Decode(aggCellGh)
+				if !ok || !cell.Intersects(queryBbox) {
+					continue
+				}
+			}
+
+			var lastCell string
+			lastIntersects := false
+			i := sort.Search(len(entries), func(i int) bool { return entries[i].geohash >= aggCellGh })
+			for ; i < len(entries) && strings.HasPrefix(entries[i].geohash, aggCellGh); i++ {
+				en := entries[i]
+				if coarser {
+					sums[aggCellGh[:q.Precision]] += share * float64(en.count)
+					continue
+				}
+				if len(en.geohash) < int(q.Precision) {
+					continue
+				}
+				if cellGh := en.geohash[:q.Precision]; cellGh != lastCell {
+					lastCell = cellGh
+					cell, ok := geo.Decode(cellGh)
+					lastIntersects = ok && cell.Intersects(queryBbox)
+				}
+				if lastIntersects {
+					sums[lastCell] += share * float64(en.count)
+				}
+			}
+		}
+	}
+
+	out := make(map[string]int64, len(sums))
+	for gh, sum := range sums {
+		if c := int64(math.Round(sum)); c > 0 {
+			out[gh] = c
+		}
+	}
+	return out
+}
+
+// prefixCounts returns the primary pings per prefix of precision in the seconds from to end (inclusive), prorated from
+// the buckets they overlap. covered is false if no bucket overlaps them (nothing recorded that long ago)
+func (h *historyTier) prefixCounts(from, end int64, precision int) (counts map[string]float64, covered bool) {
+	counts = make(map[string]float64)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for start := from - from%h.granularity; start <= end; start += h.granularity {
+		b := h.buckets[historyKey{start: start}]
+		if b == nil {
+			continue
+		}
+		covered = true
+		overlap := min(end, start+h.granularity-1) - max(from, start) + 1
+		share := float64(overlap) / float64(h.granularity)
+		for gh, c := range b.counts {
+			if len(gh) > precision {
+				gh = gh[:precision]
+			}
+			counts[gh] += share * float64(c)
+		}
+	}
+	return counts, covered
+}
+
+// sorted returns the bucket's counts sorted by geohash. must be called with the tier mutex held
+func (b *historyBucket) sorted() []historyEntry {
+	if !b.dirty {
+		return b.entries
+	}
+	b.entries = b.entries[:0]
+	for gh, c := range b.counts {
+		b.entries = append(b.entries, historyEntry{geohash: gh, count: c})
+	}
+	sort.Slice(b.entries, func(i, j int) bool { return b.entries[i].geohash < b.entries[j].geohash })
+	b.dirty = false
+	return b.entries
+}
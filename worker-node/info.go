@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	pb "geostreamdb/proto"
+)
+
+// GetInfo describes the worker for the gateway's /admin/workers: identity, protocol versions, uptime, effective
+// settings, slot occupancy and the prefixes it holds primary data for
+const (
+	infoPrefixLength    = 2
+	infoMaxPrefixes     = 1024
+	infoMaxPrefixLength = SHARDING_PRECISION - 1
+)
+
+var startedAt = time.Now()
+
+// infoConfig returns the effective settings, as the environment variables they come from
+func infoConfig() map[string]string {
+	storage := STORAGE
+	if storage == "" {
+		storage = "trie"
+	}
+	config := map[string]string{
+		"STORAGE":             storage,
+		"PING_TTL":            strconv.FormatInt(PING_TTL, 10),
+		"STORAGE_PRECISION":   strconv.Itoa(STORAGE_PRECISION),
+		"ROLLUP_WINDOW":       ROLLUP_WINDOW.String(),
+		"RAW_RETENTION":       strconv.FormatBool(RAW_RETENTION),
+		"DEDUP_WINDOW":        strconv.Itoa(DEDUP_WINDOW),
+		"GETPINGS_CACHE_SIZE": strconv.Itoa(GETPINGS_CACHE_SIZE),
+		"COVERAGE_BLOOM_BITS": strconv.Itoa(COVERAGE_BLOOM_BITS),
+		"MAX_CLOCK_SKEW":      MAX_CLOCK_SKEW.String(),
+		"STANDBY_ADDRESS":     STANDBY_ADDRESS,
+		"STANDBY_FOR":         STANDBY_FOR,
+		"SHADOW":              strconv.FormatBool(SHADOW),
+		"CLUSTER_SETTINGS":    strconv.FormatUint(settingsVersion.Load(), 16),
+		"WORKER_AUTH":         strconv.FormatBool(WORKER_AUTH),
+		"HISTORY_RETENTION":   HISTORY_RETENTION.String(),
+		"STATIONARY_SPEED":    strconv.FormatFloat(STATIONARY_SPEED, 'g', -1, 64),
+		"ANOMALY_INTERVAL":    ANOMALY_INTERVAL.String(),
+	}
+	if storage != "trie" {
+		config["STORAGE_DIR"] = STORAGE_DIR
+	}
+	if history != nil {
+		config["HISTORY_GRANULARITY"] = HISTORY_GRANULARITY.String()
+		config["HISTORY_PRECISION"] = strconv.Itoa(HISTORY_PRECISION)
+	}
+	if anomalies != nil {
+		config["ANOMALY_PRECISION"] = strconv.Itoa(ANOMALY_PRECISION)
+		config["ANOMALY_BASELINE"] = ANOMALY_BASELINE
+	}
+	if storage == "tiered" {
+		config["SPILL_AFTER"] = strconv.FormatInt(SPILL_AFTER, 10)
+		config["SPILL_BLOCK_SPAN"] = strconv.FormatInt(SPILL_BLOCK_SPAN, 10)
+	}
+	return config
+}
+
+func (s *grpcServer) GetInfo(ctx context.Context, req *pb.GetInfoRequest) (*pb.GetInfoResponse, error) {
+	resp := &pb.GetInfoResponse{
+		WorkerId:      announcedWorkerId(),
+		ApiVersion:    pb.API_VERSION,
+		MinApiVersion: pb.MIN_API_VERSION,
+		StartedAt:     startedAt.UnixMilli(),
+		Config:        infoConfig(),
+		BuildVersion:  build.Version,
+		BuildCommit:   build.Commit,
+	}
+	if isStandby() {
+		resp.StandbyFor = STANDBY_FOR
+	}
+
+	if reporter, ok := engine.(slotReporter); ok {
+		now := monotonicNow().Unix()
+		for _, replica := range []bool{false, true} {
+			usage := reporter.SlotUsage(now, replica)
+			buffer := "primary"
+			if replica {
+				buffer = "replica"
+			}
+			resp.Slots = append(resp.Slots, &pb.SlotOccupancy{
+				Buffer:    buffer,
+				Slots:     int32(usage.slots),
+				Occupied:  int32(usage.occupied),
+				TrieNodes: usage.trieNodes,
+				TrieDepth: int32(usage.trieDepth),
+				TrieBytes: usage.trieBytes,
+			})
+		}
+	}
+
+	if cov, ok := engine.(prefixCoverage); ok {
+		length := int(req.PrefixLength)
+		if length <= 0 {
+			length = infoPrefixLength
+		}
+		length = min(length, infoMaxPrefixLength)
+		prefixes := make(map[string]struct{})
+		cov.CoveredPrefixes(length, func(prefix []byte) {
+			if len(prefix) == length {
+				prefixes[string(prefix)] = struct{}{}
+			}
+		})
+		for prefix := range prefixes {
+			resp.OwnedPrefixes = append(resp.OwnedPrefixes, prefix)
+		}
+		sort.Strings(resp.OwnedPrefixes)
+		if len(resp.OwnedPrefixes) > infoMaxPrefixes {
+			resp.OwnedPrefixes = resp.OwnedPrefixes[:infoMaxPrefixes]
+			resp.PrefixesTruncated = true
+		}
+	}
+	return resp, nil
+}
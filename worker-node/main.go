@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
@@ -20,6 +24,7 @@ func main() {
 	}
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
+		http.HandleFunc("/version", getVersion)
 		log.Fatal(http.ListenAndServe(":"+metricsPort, nil))
 	}()
 
@@ -28,12 +33,31 @@ func main() {
 	if registryAddress == "" {
 		registryAddress = "registry:50051"
 	}
+	log.Printf("worker %s (commit %s)", build.Version, build.Commit)
+	if STANDBY_FOR != "" {
+		Metrics.standby.Set(1)
+		log.Printf("warm standby of %s", STANDBY_FOR)
+	}
 	conn, client := new_grpc_client(registryAddress)
 	defer conn.Close()
-	go send_heartbeat(client)
+	fetchClusterSettings(pb.NewRegistryClient(conn))
+	openStorage()
+	startCapacity()
+	startMirroring()
+	if SHADOW && WORKER_AUTH {
+		log.Fatalf("WORKER_AUTH needs the registry's heartbeat responses, which shadow workers don't get")
+	}
+	if SHADOW {
+		log.Printf("shadow worker: not heartbeating (stays out of the ring)")
+	} else {
+		go send_heartbeat(client)
+	}
 
 	// (grpc server) ping communication
-	go cleanupTimeBuffer()
+	go rotateStorage()
+	go rollupLoop()
+	go dedupCleanupLoop()
+	go motionCleanupLoop()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -44,10 +68,24 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpcInterceptors.ServerOptions(grpc.ChainUnaryInterceptor(callerAuthUnaryInterceptor, apiVersionUnaryInterceptor))...)
 	pb.RegisterWorkerServer(s, &grpcServer{})
+	rpc.RegisterDebugServices(s)
+	go func() {
+		waitForShutdown()
+		s.GracefulStop() // in-flight RPCs finish, then Serve returns
+	}()
 	log.Printf("grpc server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	closeStorage()
+}
+
+// waitForShutdown blocks until SIGINT/SIGTERM
+func waitForShutdown() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	log.Printf("shutting down")
 }
@@ -1,14 +1,50 @@
 package main
 
 import (
+	"geostreamdb/rpc"
+
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type metrics struct {
-	pingsStoredTotal  *prometheus.CounterVec   // per geohash prefix (precision 2, max 1024 labels) (TTL must be taken into account externally)
-	gRPCRequestsTotal *prometheus.CounterVec   // per method and result (success/failure)
-	gRPCLatency       *prometheus.HistogramVec // per method
+	pingsStoredTotal       *prometheus.CounterVec // per geohash prefix (precision 2, max 1024 labels) (TTL must be taken into account externally)
+	getPingsCacheTotal     *prometheus.CounterVec // per result (hit/miss)
+	storedPrecision        prometheus.Gauge
+	clockSkewRejectedTotal prometheus.Counter
+	storageErrorsTotal     *prometheus.CounterVec // per operation (read/write/expire/spill/compact)
+	spillBlocks            prometheus.Gauge
+	spillBytes             prometheus.Gauge
+	spillCompactionsTotal  prometheus.Counter
+	rawDroppedTotal        prometheus.Counter
+	trieOpLatency          *prometheus.HistogramVec // per operation (increment/get_count/area), sampled
+	trieSlotNodes          *prometheus.HistogramVec // per buffer (primary/replica), observed when a slot expires
+	trieSecondNodes        *prometheus.GaugeVec     // per buffer
+	trieDepth              *prometheus.GaugeVec     // per buffer
+	mirroredPingsTotal     *prometheus.CounterVec   // per result (sent/failed/dropped)
+	callersRejectedTotal   *prometheus.CounterVec   // per reason (not_listed_yet/anonymous/unknown)
+	standby                prometheus.Gauge
+	duplicatePingsTotal    prometheus.Counter
+	dedupDevices           prometheus.Gauge
+	motionDevices          prometheus.Gauge
+	teleportsTotal         prometheus.Counter
+	historyBuckets         prometheus.Gauge
+	historyEntries         prometheus.Gauge
+	devicesDeletedTotal    prometheus.Counter
+	buildInfo              *prometheus.GaugeVec // per version, commit and go version (always 1)
+	draining               prometheus.Gauge
+	settingsStale          prometheus.Gauge
+	trieSlotBytes          *prometheus.HistogramVec // per buffer, observed when a slot expires
+	trieSecondBytes        *prometheus.GaugeVec     // per buffer
+	timeBufferBytes        *prometheus.GaugeVec     // per buffer, live tries
+	ingestLatency          prometheus.Histogram     // client send to commit, primary pings with a sentAt
+	floorsDroppedTotal     prometheus.Counter
+	generatedPingsTotal    prometheus.Counter
+	anomaliesTotal         *prometheus.CounterVec
+	anomalyPrefixes        prometheus.Gauge
+	anomalyWebhooksTotal   *prometheus.CounterVec
+	restarts               prometheus.Gauge
 }
 
 var Metrics = metrics{
@@ -16,13 +52,159 @@ var Metrics = metrics{
 		Name: "worker_pings_stored_total",
 		Help: "Total count of pings stored by geohash prefix (precision 2)",
 	}, []string{"gh_prefix"}),
-	gRPCRequestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "worker_grpc_requests_total",
-		Help: "Total count of gRPC requests by method and result (success/failure)",
-	}, []string{"method", "result"}),
-	gRPCLatency: promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "worker_grpc_request_duration_seconds",
-		Help:    "gRPC request latency in seconds by method",
-		Buckets: prometheus.DefBuckets,
-	}, []string{"method"}),
+	getPingsCacheTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_getpings_cache_total",
+		Help: "GetPings count cache lookups by result (hit/miss)",
+	}, []string{"result"}),
+	storedPrecision: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_stored_precision",
+		Help: "Effective geohash precision stored in the trie (lowered by rollup)",
+	}),
+	clockSkewRejectedTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_clock_skew_rejected_total",
+		Help: "Pings whose gateway timestamp was further than MAX_CLOCK_SKEW from the worker clock (bucketed by the worker clock instead)",
+	}),
+	storageErrorsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_storage_errors_total",
+		Help: "Storage engine errors by operation (read/write/expire/spill/compact)",
+	}, []string{"operation"}),
+	spillBlocks: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_spill_blocks",
+		Help: "On-disk blocks held by the tiered storage engine",
+	}),
+	spillBytes: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_spill_bytes",
+		Help: "Size of the on-disk blocks held by the tiered storage engine",
+	}),
+	spillCompactionsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_spill_compactions_total",
+		Help: "Block merges done by the tiered storage engine compactor",
+	}),
+	rawDroppedTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_raw_dropped_total",
+		Help: "Raw ping rows not retained because RAW_MAX_PER_SECOND was reached",
+	}),
+	trieOpLatency: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "worker_trie_operation_duration_seconds",
+		Help:    "Duration of trie operations (1 in TRIE_TIMING_SAMPLE sampled) by operation (increment/get_count/area, per shard trie)",
+		Buckets: prometheus.ExponentialBuckets(100e-9, 4, 12), // 100ns to ~0.4s
+	}, []string{"op"}),
+	trieSlotNodes: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "worker_trie_slot_nodes",
+		Help:    "Nodes (cells with pings) of a (second, shard) trie when it expires, by buffer (primary/replica)",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 12),
+	}, []string{"buffer"}),
+	trieSecondNodes: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "worker_trie_second_nodes",
+		Help: "Nodes of the tries of the last expired second, by buffer (times PING_TTL for the live size)",
+	}, []string{"buffer"}),
+	trieDepth: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "worker_trie_depth",
+		Help: "Deepest trie level of the last expired second, by buffer",
+	}, []string{"buffer"}),
+	mirroredPingsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_mirrored_pings_total",
+		Help: "Primary pings mirrored to the warm standby (STANDBY_ADDRESS) per result (sent/failed/dropped)",
+	}, []string{"result"}),
+	standby: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_standby",
+		Help: "1 while this worker is a warm standby (STANDBY_FOR) not promoted yet",
+	}),
+	duplicatePingsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_duplicate_pings_total",
+		Help: "Pings dropped as a repeat of a recent (device id, seq)",
+	}),
+	dedupDevices: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_dedup_devices",
+		Help: "Device sequence windows kept for dedup (primary and replica)",
+	}),
+	devicesDeletedTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_devices_deleted_total",
+		Help: "DeleteDevice requests handled (per-device data purged and the id tombstoned)",
+	}),
+	buildInfo: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "worker_build_info",
+		Help: "Build of this worker (always 1)",
+	}, []string{"version", "commit", "goversion"}),
+	draining: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_draining",
+		Help: "1 while a rolling restart drains this worker (gateways route around it)",
+	}),
+	callersRejectedTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_callers_rejected_total",
+		Help: "Worker RPCs refused by WORKER_AUTH per reason (not_listed_yet: no registry heartbeat answered yet, anonymous: no gateway id, unknown: not a registered gateway)",
+	}, []string{"reason"}),
+	motionDevices: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_motion_devices",
+		Help: "Devices whose last position is tracked for teleport detection (owned by this worker)",
+	}),
+	teleportsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_teleports_total",
+		Help: "Pings found faster than the gateway's TELEPORT_MAX_SPEED from their device's last position",
+	}),
+	historyBuckets: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_history_buckets",
+		Help: "HISTORY_GRANULARITY buckets held by the history tier (primary and replica)",
+	}),
+	historyEntries: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_history_entries",
+		Help: "Geohash counts held by the history tier, over all buckets",
+	}),
+	settingsStale: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_cluster_settings_stale",
+		Help: "1 while the registry distributes other cluster settings than the ones this worker started with (restart to apply)",
+	}),
+	trieSlotBytes: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "worker_trie_slot_bytes",
+		Help:    "Estimated memory of a (second, shard) trie when it expires, by buffer (primary/replica)",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 12),
+	}, []string{"buffer"}),
+	trieSecondBytes: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "worker_trie_second_bytes",
+		Help: "Estimated memory of the tries of the last expired second, by buffer",
+	}, []string{"buffer"}),
+	timeBufferBytes: promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "worker_time_buffer_bytes",
+		Help: "Estimated memory of the live tries of the TTL window, by buffer (sampled every CAPACITY_INTERVAL)",
+	}, []string{"buffer"}),
+	ingestLatency: promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "worker_ingest_e2e_latency_seconds",
+		Help:    "Time from a client sending a primary ping (its sentAt, moved to this worker's clock by the gateway) to its commit here",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}),
+	floorsDroppedTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_floors_dropped_total",
+		Help: "Pings with a floor only counted in 2D, their slot already holding the tries of 256 other floors",
+	}),
+	generatedPingsTotal: promauto.NewCounter(prometheus.CounterOpts{
+		Name: "worker_generated_pings_total",
+		Help: "Synthetic pings written by the benchmark generator (see Generate)",
+	}),
+	anomaliesTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_anomalies_total",
+		Help: "Intervals of a prefix flagged as anomalous, per kind (spike/drop), see ANOMALY_INTERVAL",
+	}, []string{"kind"}),
+	anomalyPrefixes: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_anomaly_prefixes",
+		Help: "Prefixes with a baseline in the anomaly detector",
+	}),
+	anomalyWebhooksTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_anomaly_webhooks_total",
+		Help: "Anomaly notifications to ANOMALY_WEBHOOK_URL per result (sent/failed/dropped: queue full)",
+	}, []string{"result"}),
+	restarts: promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "worker_restarts",
+		Help: "Starts of this worker under its saved worker id (WORKER_ID_FILE) after the first",
+	}),
+}
+
+// the calls served (worker_grpc_requests_total, worker_grpc_request_duration_seconds per method), the heartbeats sent
+// to the registry counted in as Gateway.Heartbeat
+var grpcInterceptors = rpc.NewInterceptors("worker_grpc", rpc.MethodName)
+
+// the default registry's Go collector only exports runtime.MemStats: replaced by one adding the scheduler and GC
+// runtime metrics (go_sched_*, go_gc_*), the same in every service, next to the default process collector
+func init() {
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsScheduler, collectors.MetricsGC)))
 }
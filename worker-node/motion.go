@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// teleport detection: gateways with TELEPORT_ACTION set ask the worker owning a device (the ring node of its id, not
+// of its position) to check each of its pings against the device's last plausible position. a ping implying a speed
+// above the gateway's max_speed is a teleport and doesn't move the device, so one bad fix can't make the next good one
+// look like a jump back. pings less than a second apart are checked as a second apart (GPS jitter). devices not heard
+// from for MOTION_TTL are forgotten
+var MOTION_TTL = env.Duration("MOTION_TTL", 10*time.Minute)
+
+type lastPosition struct {
+	lat, lng    float64
+	timestampMs int64
+	lastSeen    int64 // unix seconds
+}
+
+type motionTable struct {
+	mu        sync.Mutex
+	positions map[string]*lastPosition
+}
+
+var motion = &motionTable{positions: make(map[string]*lastPosition)}
+
+// check returns the speed from the device's last position (m/s) and whether it exceeds maxSpeed, moving the device
+// unless it does
+func (m *motionTable) check(deviceID string, lat, lng float64, timestampMs int64, maxSpeed float64, now int64) (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := m.positions[deviceID]
+	if p == nil {
+		m.positions[deviceID] = &lastPosition{lat: lat, lng: lng, timestampMs: timestampMs, lastSeen: now}
+		Metrics.motionDevices.Set(float64(len(m.positions)))
+		return 0, false
+	}
+	p.lastSeen = now
+	elapsed := max(math.Abs(float64(timestampMs-p.timestampMs))/1000, 1) // out of order pings are checked alike
+	speed := geo.HaversineMeters(p.lat, p.lng, lat, lng) / elapsed
+	if speed > maxSpeed {
+		return speed, true
+	}
+	if timestampMs > p.timestampMs {
+		p.lat, p.lng, p.timestampMs = lat, lng, timestampMs
+	}
+	return speed, false
+}
+
+// forgetDevice drops the device's position, reporting whether there was one
+func (m *motionTable) forgetDevice(deviceID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.positions[deviceID]
+	delete(m.positions, deviceID)
+	Metrics.motionDevices.Set(float64(len(m.positions)))
+	return ok
+}
+
+// forget drops the positions of devices not seen since cutoff (unix seconds)
+func (m *motionTable) forget(cutoff int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for deviceID, p := range m.positions {
+		if p.lastSeen < cutoff {
+			delete(m.positions, deviceID)
+		}
+	}
+	Metrics.motionDevices.Set(float64(len(m.positions)))
+}
+
+func motionCleanupLoop() {
+	ticker := time.NewTicker(max(MOTION_TTL/2, time.Second))
+	defer ticker.Stop()
+	for range ticker.C {
+		motion.forget(monotonicNow().Add(-MOTION_TTL).Unix())
+	}
+}
+
+func (s *grpcServer) CheckMotion(ctx context.Context, req *pb.CheckMotionRequest) (*pb.CheckMotionResponse, error) {
+	if req.DeviceId == "" || len(req.DeviceId) > rawMaxDeviceID {
+		return nil, status.Error(codes.InvalidArgument, "invalid device id")
+	}
+	cell, ok := geo.Decode(req.Geohash)
+	if !ok || req.Geohash == "" {
+		return nil, status.Error(codes.InvalidArgument, "invalid geohash")
+	}
+	if !(req.MaxSpeed > 0) {
+		return nil, status.Error(codes.InvalidArgument, "max speed must be positive")
+	}
+	lat, lng := cell.Center()
+	speed, teleport := motion.check(req.DeviceId, lat, lng, req.Timestamp, req.MaxSpeed, monotonicNow().Unix())
+	if teleport {
+		Metrics.teleportsTotal.Inc()
+	}
+	return &pb.CheckMotionResponse{Teleport: teleport, Speed: speed}, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+)
+
+// movement: pings may carry the speed (and heading) reported by their device. the trie engine aggregates them per cell
+// in a parallel trie next to the counts of each slot, allocated on the slot's first ping with a speed: pings at
+// STATIONARY_SPEED or faster count as moving, slower ones as stationary, and their speeds and the unit vectors of the
+// moving ones' headings are summed, so that the cells of several slots and workers add up (the gateway turns the sums
+// into an average speed and a mean heading). GetPingArea returns them with movement set. only the live window has
+// them: the history tier, smoothed queries and the other engines don't
+var STATIONARY_SPEED = env.Float("STATIONARY_SPEED", 0.5) // meters per second
+
+// fixed point of the sums, so that nodes only hold atomic integers
+const movementScale = 1000
+
+// movement trie nodes are written under the slot mutex and read lock-free, like TrieNode. they are left to the GC when
+// their slot expires (pings with a speed are a fraction of the traffic, so they aren't pooled)
+type movementNode struct {
+	children   atomic.Pointer[[32]atomic.Pointer[movementNode]]
+	moving     atomic.Int64
+	stationary atomic.Int64
+	headed     atomic.Int64
+	speedSum   atomic.Int64 // speed * movementScale
+	headingX   atomic.Int64 // sin(heading) * movementScale
+	headingY   atomic.Int64 // cos(heading) * movementScale
+}
+
+// validMotion reports whether a ping's motion is in range (speed finite and not negative, heading in [0, 360))
+func validMotion(m *pb.Motion) bool {
+	if math.IsNaN(m.Speed) || math.IsInf(m.Speed, 0) || m.Speed < 0 {
+		return false
+	}
+	return !m.HasHeading || (m.Heading >= 0 && m.Heading < 360)
+}
+
+// add counts the motion of a ping at every node from the root to its geohash (already valid and cut to the stored
+// precision)
+func (t *movementNode) add(geohash string, m *pb.Motion) {
+	moving := m.Speed >= STATIONARY_SPEED
+	speed := int64(math.Round(m.Speed * movementScale))
+	var x, y int64
+	if moving && m.HasHeading {
+		sin, cos := math.Sincos(m.Heading * math.Pi / 180)
+		x, y = int64(math.Round(sin*movementScale)), int64(math.Round(cos*movementScale))
+	}
+
+	current := t
+	for i := 0; ; i++ {
+		if moving {
+			current.moving.Add(1)
+		} else {
+			current.stationary.Add(1)
+		}
+		current.speedSum.Add(speed)
+		if moving && m.HasHeading {
+			current.headed.Add(1)
+			current.headingX.Add(x)
+			current.headingY.Add(y)
+		}
+		if i == len(geohash) {
+			return
+		}
+
+		children := current.children.Load()
+		if children == nil {
+			children = &[32]atomic.Pointer[movementNode]{}
+			current.children.Store(children)
+		}
+		idx := geohashCharToIndex[geohash[i]]
+		child := children[idx].Load()
+		if child == nil {
+			child = &movementNode{}
+			children[idx].Store(child)
+		}
+		current = child
+	}
+}
+
+// find returns the node of a geohash, nil if no ping with a speed is under it
+func (t *movementNode) find(geohash string) *movementNode {
+	current := t
+	for i := 0; i < len(geohash) && current != nil; i++ {
+		children := current.children.Load()
+		idx := geohashCharToIndex[geohash[i]]
+		if children == nil || idx < 0 {
+			return nil
+		}
+		current = children[idx].Load()
+	}
+	return current
+}
+
+// addTo adds the sums of the node to the movement of a cell
+func (t *movementNode) addTo(out map[string]*pb.Movement, cell string) {
+	m := out[cell]
+	if m == nil {
+		m = &pb.Movement{}
+		out[cell] = m
+	}
+	m.Moving += t.moving.Load()
+	m.Stationary += t.stationary.Load()
+	m.Headed += t.headed.Load()
+	m.SpeedSum += float64(t.speedSum.Load()) / movementScale
+	m.HeadingX += float64(t.headingX.Load()) / movementScale
+	m.HeadingY += float64(t.headingY.Load()) / movementScale
+}
+
+// area adds the movement of the cells at precision under the covering geohashes (at aggPrecision) that intersect the
+// bbox, as TrieNode.GetAreaCount does for counts
+func (t *movementNode) area(q AreaQuery, geohashes []string, out map[string]*pb.Movement) {
+	queryBbox := geo.Bbox{MinLat: q.MinLat, MaxLat: q.MaxLat, MinLng: q.MinLng, MaxLng: q.MaxLng}
+	for _, geohash := range geohashes {
+		if len(geohash) < int(q.AggPrecision) {
+			continue
+		}
+		aggCell := geohash[:q.AggPrecision]
+		node := t.find(aggCell)
+		if node == nil {
+			continue
+		}
+		if q.Precision <= q.AggPrecision {
+			if cell, ok := geo.Decode(aggCell); ok && cell.Intersects(queryBbox) {
+				node.addTo(out, geohash[:q.Precision])
+			}
+			continue
+		}
+
+		type stackItem struct {
+			node   *movementNode
+			prefix string
+		}
+		stack := []stackItem{{node: node, prefix: aggCell}}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(n.prefix) == int(q.Precision) {
+				n.node.addTo(out, n.prefix)
+				continue
+			}
+			children := n.node.children.Load()
+			if children == nil {
+				continue
+			}
+			for idx := range children {
+				child := children[idx].Load()
+				if child == nil {
+					continue
+				}
+				prefix := n.prefix + string(geo.Base32[idx])
+				if cell, ok := geo.Decode(prefix); ok && cell.Intersects(queryBbox) {
+					stack = append(stack, stackItem{node: child, prefix: prefix})
+				}
+			}
+		}
+	}
+}
+
+// truncate drops the nodes finer than precision (the sums of coarser ones already include them)
+func (t *movementNode) truncate(precision int) {
+	if t == nil {
+		return
+	}
+	children := t.children.Load()
+	if children == nil {
+		return
+	}
+	if precision <= 0 {
+		t.children.Store(nil)
+		return
+	}
+	for idx := range children {
+		children[idx].Load().truncate(precision - 1)
+	}
+}
+
+func (e *trieEngine) IngestMovement(geohash string, second int64, replica bool, m *pb.Motion) {
+	slot := e.buffer(replica)[int(second%e.ttl)*TIME_BUFFER_SHARDS+shardIndex(geohash)]
+
+	slot.Mutex.Lock()
+	defer slot.Mutex.Unlock()
+
+	data := slot.current(second)
+	root := data.Movement.Load()
+	if root == nil {
+		root = &movementNode{}
+		data.Movement.Store(root)
+	}
+	root.add(geohash, m)
+}
+
+func (e *trieEngine) QueryAreaMovement(q AreaQuery, now int64, replica bool) map[string]*pb.Movement {
+	cutoff := q.cutoff(now, e.ttl)
+	out := make(map[string]*pb.Movement)
+	buffer := e.buffer(replica)
+
+	var byShard [TIME_BUFFER_SHARDS][]string
+	for _, gh := range q.Geohashes {
+		if gh == "" || geohashCharToIndex[gh[0]] < 0 {
+			continue
+		}
+		shard := shardIndex(gh)
+		byShard[shard] = append(byShard[shard], gh)
+	}
+
+	for i := 0; i < int(e.ttl); i++ {
+		for shard, shardGeohashes := range byShard {
+			if len(shardGeohashes) == 0 {
+				continue
+			}
+			data := buffer[i*TIME_BUFFER_SHARDS+shard].Data.Load()
+			if data == nil || data.Timestamp < cutoff {
+				continue
+			}
+			if root := data.Movement.Load(); root != nil {
+				root.area(q, shardGeohashes, out)
+			}
+		}
+	}
+	return out
+}
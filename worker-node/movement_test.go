@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+)
+
+func TestTrieEngineAggregatesMovementPerCell(t *testing.T) {
+	e := newTrieEngine(10)
+	now := int64(1000)
+	gh := geo.Encode(48.85, 2.35, MAX_GH_PRECISION)
+	for _, m := range []*pb.Motion{{Speed: 10, HasHeading: true, Heading: 90}, {Speed: 20}, {Speed: 0.1}} {
+		e.Ingest(gh, now, false)
+		e.IngestMovement(gh, now, false, m)
+	}
+	e.Ingest(gh, now-1, false) // no speed: counted, but not in the movement
+
+	q := AreaQuery{Precision: 6, AggPrecision: 5, Geohashes: []string{gh[:5]}, MinLat: 48, MaxLat: 49, MinLng: 2, MaxLng: 3}
+	got := e.QueryAreaMovement(q, now, false)[gh[:6]]
+	if got == nil || got.Moving != 2 || got.Stationary != 1 || got.Headed != 1 || got.SpeedSum != 30.1 || got.HeadingX != 1 {
+		t.Fatalf("got %v", got)
+	}
+	if count := e.QueryArea(q, now, false)[gh[:6]]; count != 4 {
+		t.Errorf("count %d, want 4", count)
+	}
+
+	q.Precision = 4
+	if got := e.QueryAreaMovement(q, now, false)[gh[:4]]; got == nil || got.Moving != 2 {
+		t.Errorf("coarser than the cover: got %v", got)
+	}
+	if got := e.QueryAreaMovement(q, now+11, false); len(got) != 0 {
+		t.Errorf("expired movement returned: %v", got)
+	}
+}
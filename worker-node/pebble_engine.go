@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+	"github.com/cockroachdb/pebble"
+)
+
+// disk-backed engine (STORAGE=pebble): one merge-operator counter per (geohash prefix, second), so the TTL window can
+// span hours without holding it in memory. keys (all integers big-endian):
+//
+//	'n' replica(1) len(1) geohash second(8) -> pings under that prefix in that second (every prefix of a ping is counted,
+//	                                          like the trie node counts, so a cell is one short range scan)
+//	't' second(8) replica(1) geohash        -> pings stored at exactly that geohash in that second (expiry index)
+//
+// writes are not fsynced: a process crash loses nothing, a machine crash may lose the last writes. the worker closes the
+// database on SIGINT/SIGTERM and before a rolling restart; calls after that are no-ops
+var STORAGE_DIR = env.String("STORAGE_DIR", "/data")
+
+const (
+	pebbleNodePrefix  = 'n'
+	pebbleIndexPrefix = 't'
+)
+
+var errBadCounter = errors.New("invalid counter value")
+
+// counterMerger sums 8-byte big-endian counters
+var counterMerger = &pebble.Merger{
+	Name: "geostreamdb.counter.v1",
+	Merge: func(key, value []byte) (pebble.ValueMerger, error) {
+		m := &counterValueMerger{}
+		return m, m.MergeNewer(value)
+	},
+}
+
+type counterValueMerger struct {
+	sum int64
+}
+
+func (m *counterValueMerger) MergeNewer(value []byte) error {
+	if len(value) != 8 {
+		return errBadCounter
+	}
+	m.sum += int64(binary.BigEndian.Uint64(value))
+	return nil
+}
+
+func (m *counterValueMerger) MergeOlder(value []byte) error { return m.MergeNewer(value) }
+
+func (m *counterValueMerger) Finish(includesBase bool) ([]byte, io.Closer, error) {
+	return binary.BigEndian.AppendUint64(nil, uint64(m.sum)), nil, nil
+}
+
+var counterOne = binary.BigEndian.AppendUint64(nil, 1)
+
+type pebbleEngine struct {
+	db *pebble.DB
+
+	mu     sync.RWMutex // held for reading by every call, for writing by Close
+	closed bool
+}
+
+func newPebbleEngine(dir string) (*pebbleEngine, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	db, err := pebble.Open(dir, &pebble.Options{Merger: counterMerger})
+	if err != nil {
+		return nil, err
+	}
+	e := &pebbleEngine{db: db}
+	// seconds that expired while the worker was down
+	e.expire(monotonicNow().Unix()-PING_TTL+1, nil)
+	return e, nil
+}
+
+// Close flushes the memtable and closes the database. rotation or a late RPC may still call the engine meanwhile: they
+// wait for Close, then find nothing
+func (e *pebbleEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if err := e.db.Flush(); err != nil {
+		log.Printf("pebble flush failed: %v", err)
+	}
+	return e.db.Close()
+}
+
+// open read-locks the engine, false once it is closed. callers unlock it when true
+func (e *pebbleEngine) open() bool {
+	e.mu.RLock()
+	if e.closed {
+		e.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
+func pebbleReplicaByte(replica bool) byte {
+	if replica {
+		return 1
+	}
+	return 0
+}
+
+// nodeKeyPrefix returns the key prefix of a geohash (prefix) node, without the second
+func nodeKeyPrefix(geohash string, replica bool) []byte {
+	k := make([]byte, 0, 3+len(geohash)+8)
+	k = append(k, pebbleNodePrefix, pebbleReplicaByte(replica), byte(len(geohash)))
+	return append(k, geohash...)
+}
+
+func indexKeyPrefix(second int64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{pebbleIndexPrefix}, uint64(second))
+}
+
+func decodeCounter(v []byte) int64 {
+	if len(v) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+// prefixUpperBound returns the smallest key greater than every key starting with prefix
+func prefixUpperBound(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil // prefix is all 0xff: no upper bound
+}
+
+func (e *pebbleEngine) Ingest(geohash string, second int64, replica bool) {
+	if !e.open() {
+		return
+	}
+	defer e.mu.RUnlock()
+
+	b := e.db.NewBatch()
+	defer b.Close()
+
+	for l := 0; l <= len(geohash); l++ {
+		b.Merge(binary.BigEndian.AppendUint64(nodeKeyPrefix(geohash[:l], replica), uint64(second)), counterOne, nil)
+	}
+	index := append(append(indexKeyPrefix(second), pebbleReplicaByte(replica)), geohash...)
+	b.Merge(index, counterOne, nil)
+
+	if err := b.Commit(pebble.NoSync); err != nil {
+		log.Printf("pebble write failed: %v", err)
+		Metrics.storageErrorsTotal.WithLabelValues("write").Inc()
+	}
+}
+
+// sumWindow sums the counters of a node over the seconds from cutoff on
+func (e *pebbleEngine) sumWindow(prefix []byte, cutoff int64) int64 {
+	iter, err := e.db.NewIter(&pebble.IterOptions{
+		LowerBound: binary.BigEndian.AppendUint64(bytes.Clone(prefix), uint64(max(cutoff, 0))),
+		UpperBound: prefixUpperBound(prefix),
+	})
+	if err != nil {
+		Metrics.storageErrorsTotal.WithLabelValues("read").Inc()
+		return 0
+	}
+	defer iter.Close()
+
+	total := int64(0)
+	for iter.First(); iter.Valid(); iter.Next() {
+		total += decodeCounter(iter.Value())
+	}
+	return total
+}
+
+func (e *pebbleEngine) QueryPoint(geohash string, now int64, replica bool) int64 {
+	if !e.open() {
+		return 0
+	}
+	defer e.mu.RUnlock()
+	return e.sumWindow(nodeKeyPrefix(geohash, replica), now-PING_TTL+1) // the oldest second expire keeps
+}
+
+func (e *pebbleEngine) QueryArea(q AreaQuery, now int64, replica bool) map[string]int64 {
+	if q.Precision < 1 || q.AggPrecision < 1 || !e.open() {
+		return nil
+	}
+	defer e.mu.RUnlock()
+	cutoff := q.cutoff(now, PING_TTL) + 1 // the newest Window (or PING_TTL) seconds, now included
+	queryBbox := geo.Bbox{MinLat: q.MinLat, MaxLat: q.MaxLat, MinLng: q.MinLng, MaxLng: q.MaxLng}
+	combined := make(map[string]int64)
+
+	for _, geohash := range q.Geohashes {
+		if len(geohash) < int(q.AggPrecision) || !validGeohash(geohash) {
+			continue
+		}
+		aggCellGh := geohash[:q.AggPrecision]
+
+		// coarser (or equal) precision: the covered cell's count goes to its prefix (see TrieNode.GetAreaCount)
+		if q.Precision <= q.AggPrecision {
+			cell, ok := geo.Decode(aggCellGh)
+			if !ok || !cell.Intersects(queryBbox) {
+				continue
+			}
+			if c := e.sumWindow(nodeKeyPrefix(aggCellGh, replica), cutoff); c > 0 {
+				combined[aggCellGh[:q.Precision]] += c
+			}
+			continue
+		}
+
+		// finer precision: scan the nodes at the requested precision under the covered cell
+		prefix := nodeKeyPrefix(aggCellGh, replica)
+		prefix[2] = byte(q.Precision)
+		iter, err := e.db.NewIter(&pebble.IterOptions{LowerBound: prefix, UpperBound: prefixUpperBound(prefix)})
+		if err != nil {
+			Metrics.storageErrorsTotal.WithLabelValues("read").Inc()
+			continue
+		}
+		cellStart := 3
+		cellEnd := cellStart + int(q.Precision)
+		var lastCell string
+		lastIntersects := false
+		for iter.First(); iter.Valid(); iter.Next() {
+			key := iter.Key()
+			if len(key) != cellEnd+8 || int64(binary.BigEndian.Uint64(key[cellEnd:])) < cutoff {
+				continue
+			}
+			if string(key[cellStart:cellEnd]) != lastCell {
+				lastCell = string(key[cellStart:cellEnd])
+				cell, ok := geo.Decode(lastCell)
+				lastIntersects = ok && cell.Intersects(queryBbox)
+			}
+			if lastIntersects {
+				combined[lastCell] += decodeCounter(iter.Value())
+			}
+		}
+		iter.Close()
+	}
+	return combined
+}
+
+// SnapshotExpired deletes every second up to second-PING_TTL (the one leaving the window, plus any left over)
+func (e *pebbleEngine) SnapshotExpired(second int64, fn func(ExpiredSecond)) {
+	if !e.open() {
+		return
+	}
+	defer e.mu.RUnlock()
+	e.expire(second-PING_TTL+1, fn)
+}
+
+// expire deletes the data of every second before cutoff, walking the expiry index
+func (e *pebbleEngine) expire(cutoff int64, fn func(ExpiredSecond)) {
+	end := indexKeyPrefix(max(cutoff, 0))
+	iter, err := e.db.NewIter(&pebble.IterOptions{LowerBound: []byte{pebbleIndexPrefix}, UpperBound: end})
+	if err != nil {
+		Metrics.storageErrorsTotal.WithLabelValues("read").Inc()
+		return
+	}
+
+	b := e.db.NewBatch()
+	defer b.Close()
+
+	var current *[2]ExpiredSecond // primary, replica of the second being walked
+	flush := func() {
+		if current == nil || fn == nil {
+			return
+		}
+		for _, exp := range current {
+			if len(exp.Counts) > 0 {
+				fn(exp)
+			}
+		}
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if len(key) < 10 {
+			continue
+		}
+		second := int64(binary.BigEndian.Uint64(key[1:9]))
+		replica := key[9] == 1
+		geohash := string(key[10:])
+
+		if current == nil || current[0].Second != second {
+			flush()
+			current = &[2]ExpiredSecond{
+				{Second: second, Counts: make(map[string]int64)},
+				{Second: second, Replica: true, Counts: make(map[string]int64)},
+			}
+		}
+		if replica {
+			current[1].Counts[geohash] += decodeCounter(iter.Value())
+		} else {
+			current[0].Counts[geohash] += decodeCounter(iter.Value())
+		}
+
+		// the node counters this geohash contributed to (prefixes shared by several geohashes are deleted more than once)
+		for l := 0; l <= len(geohash); l++ {
+			b.Delete(binary.BigEndian.AppendUint64(nodeKeyPrefix(geohash[:l], replica), uint64(second)), nil)
+		}
+	}
+	flush()
+	iter.Close()
+
+	if b.Empty() {
+		return
+	}
+	b.DeleteRange([]byte{pebbleIndexPrefix}, end, nil)
+	if err := b.Commit(pebble.NoSync); err != nil {
+		log.Printf("pebble expiry failed: %v", err)
+		Metrics.storageErrorsTotal.WithLabelValues("expire").Inc()
+	}
+}
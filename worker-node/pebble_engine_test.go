@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"geostreamdb/geo"
+	"github.com/cockroachdb/pebble"
+)
+
+func newTestPebbleEngine(t *testing.T, dir string) *pebbleEngine {
+	e, err := newPebbleEngine(dir)
+	if err != nil {
+		t.Fatalf("newPebbleEngine: %v", err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func counter(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+func TestCounterMergerSumsCounters(t *testing.T) {
+	m, err := counterMerger.Merge([]byte("key"), counter(3))
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if err := m.MergeNewer(counter(4)); err != nil {
+		t.Fatalf("MergeNewer: %v", err)
+	}
+	if err := m.MergeOlder(counter(-2)); err != nil {
+		t.Fatalf("MergeOlder: %v", err)
+	}
+	v, closer, err := m.Finish(true)
+	if err != nil || closer != nil || decodeCounter(v) != 5 {
+		t.Fatalf("Finish: got %d (%v, %v), want 5", decodeCounter(v), closer, err)
+	}
+
+	for _, bad := range [][]byte{nil, {1, 2, 3}, make([]byte, 9)} {
+		if _, err := counterMerger.Merge([]byte("key"), bad); err != errBadCounter {
+			t.Errorf("Merge(%v): got %v, want errBadCounter", bad, err)
+		}
+		if err := m.MergeNewer(bad); err != errBadCounter {
+			t.Errorf("MergeNewer(%v): got %v, want errBadCounter", bad, err)
+		}
+	}
+}
+
+func TestPrefixUpperBound(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   []byte
+	}{
+		{[]byte("ab"), []byte("ac")},
+		{[]byte{'n', 0, 4, 'e', 'z', 'j', 'm'}, []byte{'n', 0, 4, 'e', 'z', 'j', 'n'}},
+		{[]byte{1, 0xff}, []byte{2}},
+		{[]byte{1, 0xff, 0xff}, []byte{2}},
+		{[]byte{0xff, 0xff}, nil},
+		{[]byte{}, nil},
+	}
+	for _, tt := range tests {
+		got := prefixUpperBound(tt.prefix)
+		if !bytes.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("prefixUpperBound(%v) = %v, want %v", tt.prefix, got, tt.want)
+		}
+		// every key under the prefix sorts before the bound
+		if got != nil && bytes.Compare(append(bytes.Clone(tt.prefix), 0xff, 0xff), got) >= 0 {
+			t.Errorf("prefixUpperBound(%v) = %v is not above the prefix's keys", tt.prefix, got)
+		}
+	}
+	// the input is left alone
+	prefix := []byte{1, 0xff}
+	prefixUpperBound(prefix)
+	if !bytes.Equal(prefix, []byte{1, 0xff}) {
+		t.Errorf("prefix changed to %v", prefix)
+	}
+}
+
+func TestPebbleEngineCountsEveryPrefixInTheWindow(t *testing.T) {
+	previousTTL := PING_TTL
+	PING_TTL = 60
+	t.Cleanup(func() { PING_TTL = previousTTL })
+	e := newTestPebbleEngine(t, t.TempDir())
+	now := monotonicNow().Unix()
+	gh := geo.Encode(42.23, -8.72, MAX_GH_PRECISION)
+
+	for _, s := range []int64{now - PING_TTL, now - PING_TTL + 1, now - 20, now - 19, now} {
+		e.Ingest(gh, s, false)
+	}
+	e.Ingest(gh, now, true)
+
+	for _, prefix := range []string{"", gh[:1], gh[:4], gh} {
+		if got := e.QueryPoint(prefix, now, false); got != 4 {
+			t.Errorf("QueryPoint(%q) = %d, want 4 (the second PING_TTL ago is out of the window)", prefix, got)
+		}
+	}
+	if got := e.QueryPoint(gh, now, true); got != 1 {
+		t.Errorf("replica QueryPoint = %d, want 1", got)
+	}
+
+	bbox, _ := geo.Decode(gh[:4])
+	query := AreaQuery{Precision: 6, AggPrecision: 4, Geohashes: []string{gh[:4]}, MinLat: bbox.MinLat, MaxLat: bbox.MaxLat, MinLng: bbox.MinLng, MaxLng: bbox.MaxLng}
+	for _, tt := range []struct {
+		window int64
+		want   int64
+	}{
+		{0, 4},
+		{21, 3}, // now-20 on
+		{20, 2}, // now-19 on
+		{1, 1},  // now only
+	} {
+		query.Window = tt.window
+		if got := e.QueryArea(query, now, false)[gh[:6]]; got != tt.want {
+			t.Errorf("window %d: QueryArea = %d, want %d", tt.window, got, tt.want)
+		}
+	}
+	query.Precision, query.Window = 3, 0
+	if got := e.QueryArea(query, now, false); got[gh[:3]] != 4 || len(got) != 1 {
+		t.Errorf("coarser QueryArea = %v, want %s: 4", got, gh[:3])
+	}
+}
+
+func TestPebbleEngineExpiresThroughTheIndex(t *testing.T) {
+	previousTTL := PING_TTL
+	PING_TTL = 60
+	t.Cleanup(func() { PING_TTL = previousTTL })
+	e := newTestPebbleEngine(t, t.TempDir())
+	now := monotonicNow().Unix()
+	gh := geo.Encode(42.23, -8.72, MAX_GH_PRECISION)
+	other := geo.Encode(48.85, 2.35, MAX_GH_PRECISION)
+
+	e.Ingest(gh, now-PING_TTL, false)
+	e.Ingest(gh, now-PING_TTL, false)
+	e.Ingest(other, now-PING_TTL, true)
+	e.Ingest(gh, now-PING_TTL+1, false)
+
+	var expired []ExpiredSecond
+	e.SnapshotExpired(now, func(exp ExpiredSecond) { expired = append(expired, exp) })
+	if len(expired) != 2 {
+		t.Fatalf("got %d expired seconds, want primary and replica", len(expired))
+	}
+	if exp := expired[0]; exp.Second != now-PING_TTL || exp.Replica || exp.Counts[gh] != 2 || len(exp.Counts) != 1 {
+		t.Errorf("got %+v, want 2 pings of %s", exp, gh)
+	}
+	if exp := expired[1]; exp.Second != now-PING_TTL || !exp.Replica || exp.Counts[other] != 1 || len(exp.Counts) != 1 {
+		t.Errorf("got %+v, want 1 replica ping of %s", exp, other)
+	}
+
+	// the second's node counters and index entries are gone, the next second's are kept
+	iter, err := e.db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	keys := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		second := int64(binary.BigEndian.Uint64(key[len(key)-8:]))
+		if key[0] == pebbleIndexPrefix {
+			second = int64(binary.BigEndian.Uint64(key[1:9]))
+		}
+		if second != now-PING_TTL+1 {
+			t.Errorf("key %q of second %d left", key, second-now)
+		}
+		keys++
+	}
+	if keys != len(gh)+2 { // a node per prefix, "" included, and the index entry
+		t.Errorf("got %d keys, want %d", keys, len(gh)+2)
+	}
+
+	expired = nil
+	e.SnapshotExpired(now, func(exp ExpiredSecond) { expired = append(expired, exp) })
+	if len(expired) != 0 {
+		t.Errorf("expired %+v again", expired)
+	}
+}
+
+func TestPebbleEngineReopensAfterClose(t *testing.T) {
+	previousTTL := PING_TTL
+	PING_TTL = 60
+	t.Cleanup(func() { PING_TTL = previousTTL })
+	dir := t.TempDir()
+	e := newTestPebbleEngine(t, dir)
+	now := monotonicNow().Unix()
+	gh := geo.Encode(42.23, -8.72, MAX_GH_PRECISION)
+	e.Ingest(gh, now-PING_TTL, false) // expires while the worker is down
+	e.Ingest(gh, now-1, false)
+	e.Ingest(gh, now, false)
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// late calls (rotation, an RPC finishing) find a closed engine rather than a closed database
+	e.Ingest(gh, now, false)
+	e.SnapshotExpired(now, nil)
+	if got := e.QueryPoint(gh, now, false); got != 0 {
+		t.Errorf("QueryPoint = %d after Close, want 0", got)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	reopened := newTestPebbleEngine(t, dir)
+	if got := reopened.QueryPoint(gh, now, false); got != 2 {
+		t.Errorf("QueryPoint = %d after reopening, want 2", got)
+	}
+	if got := reopened.QueryPoint(gh, now+PING_TTL, false); got != 0 {
+		t.Errorf("QueryPoint = %d a window later, want 0", got)
+	}
+}
@@ -2,166 +2,94 @@ package main
 
 import (
 	"context"
+	"geostreamdb/geo"
 	pb "geostreamdb/proto"
 	"sort"
-	"sync"
-	"time"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // TODO: make configurable and shared with gateway
 const SHARDING_PRECISION = 7 // precision at which geohashes are sharded across workers
-const MAX_GH_PRECISION = 8   // maximum geohash precision stored
+const MAX_GH_PRECISION = 8   // maximum geohash precision stored (see STORAGE_PRECISION)
 
 // maps geohash base32 characters to indices 0-31 for dense array lookup
 var geohashCharToIndex [256]int8
 
 func init() {
-	// initialize lookup table with -1 (invalid)
 	for i := range geohashCharToIndex {
-		geohashCharToIndex[i] = -1
-	}
-	// map each geohash base32 character to its index
-	for i := 0; i < len(geohashBase32); i++ {
-		geohashCharToIndex[geohashBase32[i]] = int8(i)
-		// convert uppercase to lowercase
-		if geohashBase32[i] >= 'a' && geohashBase32[i] <= 'z' {
-			geohashCharToIndex[geohashBase32[i]-('a'-'A')] = int8(i)
-		}
+		geohashCharToIndex[i] = int8(geo.CharIndex(byte(i))) // -1 = invalid, uppercase accepted
 	}
 }
 
-type ghBbox struct {
-	minLat float64
-	maxLat float64
-	minLng float64
-	maxLng float64
-}
-
-func (a ghBbox) intersects(b ghBbox) bool {
-	// strict overlap (max bounds are exclusive) to avoid counting boxes that only touch at an edge.
-	return a.minLat < b.maxLat && a.maxLat > b.minLat && a.minLng < b.maxLng && a.maxLng > b.minLng
+// trie nodes are written by a single writer at a time (slot mutex) and read lock-free, so every field is atomic and
+// children/leaf arrays are only published once initialized
+type TrieNode struct {
+	Children    atomic.Pointer[trieChildren] // base32 character index -> child node (used for precision 1 to SHARDING_PRECISION-1). allocated on first child
+	DenseLeaves atomic.Pointer[denseLeaves]  // flattened array for SHARDING_PRECISION-MAX_GH_PRECISION levels (used for memory efficiency)
+	Count       atomic.Int64
 }
 
-var geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
-
-// pre-computed lookup table for geohash base32 decoding (avoids allocation per call)
-var geohashCharmap [256]byte
+type trieChildren [32]atomic.Pointer[TrieNode]
+type denseLeaves [32]atomic.Int64
 
-func init() {
-	for i := range geohashCharmap {
-		geohashCharmap[i] = 0xFF
-	}
-	for i := 0; i < len(geohashBase32); i++ {
-		geohashCharmap[geohashBase32[i]] = byte(i)
-	}
+// a trie swapped out of its slot (or a subtrie truncated away) is simply dropped: lock-free readers may still be
+// traversing it, for as long as their query takes, so it's left to the GC rather than recycled
+func newTrieNode() *TrieNode {
+	return &TrieNode{}
 }
 
-func geohashDecodeBbox(gh string) (ghBbox, bool) {
-	if gh == "" {
-		return ghBbox{}, false
-	}
-
-	minLat, maxLat := -90.0, 90.0
-	minLng, maxLng := -180.0, 180.0
-	isLng := true // geohash bits start with longitude
-
-	for i := 0; i < len(gh); i++ {
-		c := gh[i]
-		// normalize ASCII uppercase -> lowercase (geohash alphabet is lowercase)
-		if c >= 'A' && c <= 'Z' {
-			c = c + ('a' - 'A')
-		}
-		v := geohashCharmap[c] // base32 char -> [0, 31]
-		if v == 0xFF {
-			return ghBbox{}, false
-		}
-		for bit := 4; bit >= 0; bit-- { // 5 bits per geohash char
-			mask := byte(1 << uint(bit))
-			if isLng {
-				mid := (minLng + maxLng) / 2
-				if v&mask != 0 {
-					minLng = mid
-				} else {
-					maxLng = mid
-				}
-			} else {
-				mid := (minLat + maxLat) / 2
-				if v&mask != 0 {
-					minLat = mid
-				} else {
-					maxLat = mid
-				}
-			}
-			isLng = !isLng
+func validGeohash(geohash string) bool {
+	for i := 0; i < len(geohash); i++ {
+		if geohashCharToIndex[geohash[i]] < 0 {
+			return false
 		}
 	}
-
-	return ghBbox{minLat: minLat, maxLat: maxLat, minLng: minLng, maxLng: maxLng}, true
-}
-
-type TimeBufferSlot struct {
-	Mutex sync.RWMutex // Each TTL time slot has its own mutex to allow parallel access
-	Data  *TimeBufferElement
+	return true
 }
 
-type TrieNode struct {
-	Children    map[byte]*TrieNode // character (byte representation) -> child node (used for precision 1 to SHARDING_PRECISION-1)
-	DenseLeaves *[32]int64         // flattened array for SHARDING_PRECISION-MAX_GH_PRECISION levels (used for memory efficiency)
-	Count       int64
-}
-
-type TimeBufferElement struct {
-	Timestamp int64
-	TrieRoot  *TrieNode
-}
-
-var (
-	PING_TTL int64 = 10 // seconds
-
-	timeBuffer = make([]*TimeBufferSlot, PING_TTL)
-)
-
-func init() { // runs automatically before main()
-	// for the mutexes to exist
-	for i := 0; i < int(PING_TTL); i++ {
-		timeBuffer[i] = &TimeBufferSlot{}
+func (t *TrieNode) Increment(geohash string) bool {
+	// geohash must only contain base32 characters (checked upfront so that nothing is counted for an invalid one)
+	if !validGeohash(geohash) {
+		return false
 	}
-}
 
-func (t *TrieNode) Increment(geohash string) {
-	t.Count++ // increment the root count
+	t.Count.Add(1) // increment the root count
 
 	current := t
 	for i := 0; i < len(geohash); i++ {
-		if current.Children == nil {
-			current.Children = make(map[byte]*TrieNode)
+		children := current.Children.Load()
+		if children == nil {
+			children = &trieChildren{}
+			current.Children.Store(children)
 		}
 
-		char := geohash[i]
-		child, exists := current.Children[char]
-		if !exists {
-			child = &TrieNode{Count: 0}
-			current.Children[char] = child
+		idx := geohashCharToIndex[geohash[i]]
+		child := children[idx].Load()
+		if child == nil {
+			child = newTrieNode()
+			children[idx].Store(child)
 		}
-		child.Count++
+		child.Count.Add(1)
 
 		// at P7, store P8 in dense array and return early
 		// TODO: this should be generalized for the gap between SHARDING_PRECISION and MAX_GH_PRECISION
 		depth := i + 1
 		if depth == SHARDING_PRECISION && len(geohash) > SHARDING_PRECISION {
-			if child.DenseLeaves == nil {
-				child.DenseLeaves = &[32]int64{}
+			leaves := child.DenseLeaves.Load()
+			if leaves == nil {
+				leaves = &denseLeaves{}
+				child.DenseLeaves.Store(leaves)
 			}
-			p8Char := geohash[SHARDING_PRECISION]
-			idx := geohashCharToIndex[p8Char]
-			if idx >= 0 && idx < 32 {
-				child.DenseLeaves[idx]++
-			}
-			return
+			leaves[geohashCharToIndex[geohash[SHARDING_PRECISION]]].Add(1)
+			return true
 		}
 
 		current = child
 	}
+	return true
 }
 
 func (t *TrieNode) GetCount(geohash string) int64 {
@@ -171,13 +99,17 @@ func (t *TrieNode) GetCount(geohash string) int64 {
 
 	current := t
 	for i := 0; i < len(geohash); i++ {
-		if current.Children == nil {
+		children := current.Children.Load()
+		if children == nil {
 			return 0
 		}
 
-		char := geohash[i]
-		child, exists := current.Children[char]
-		if !exists {
+		idx := geohashCharToIndex[geohash[i]]
+		if idx < 0 {
+			return 0
+		}
+		child := children[idx].Load()
+		if child == nil {
 			return 0
 		}
 
@@ -185,13 +117,14 @@ func (t *TrieNode) GetCount(geohash string) int64 {
 		// TODO: this should be generalized for the gap between SHARDING_PRECISION and MAX_GH_PRECISION
 		depth := i + 1
 		if depth == SHARDING_PRECISION && len(geohash) > SHARDING_PRECISION {
-			if child.DenseLeaves == nil {
+			leaves := child.DenseLeaves.Load()
+			if leaves == nil {
 				return 0
 			}
 			p8Char := geohash[SHARDING_PRECISION]
 			idx := geohashCharToIndex[p8Char]
 			if idx >= 0 && idx < 32 {
-				return child.DenseLeaves[idx]
+				return leaves[idx].Load()
 			}
 			return 0
 		}
@@ -199,7 +132,7 @@ func (t *TrieNode) GetCount(geohash string) int64 {
 		current = child
 	}
 
-	return current.Count
+	return current.Count.Load()
 }
 
 func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat float64, maxLat float64, minLng float64, maxLng float64, geohashes []string) map[string]int64 {
@@ -213,7 +146,7 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 		return nil
 	}
 
-	queryBbox := ghBbox{minLat: minLat, maxLat: maxLat, minLng: minLng, maxLng: maxLng}
+	queryBbox := geo.Bbox{MinLat: minLat, MaxLat: maxLat, MinLng: minLng, MaxLng: maxLng}
 	counts := make(map[string]int64)
 
 	for _, geohash := range geohashes {
@@ -230,16 +163,16 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 
 		current := t
 		for i := 0; i < traverseDepth; i++ {
-			if current.Children == nil {
+			idx := geohashCharToIndex[geohash[i]]
+			children := current.Children.Load()
+			if children == nil || idx < 0 {
 				current = nil
 				break
 			}
-			child, exists := current.Children[geohash[i]]
-			if !exists {
-				current = nil
+			current = children[idx].Load()
+			if current == nil {
 				break
 			}
-			current = child
 		}
 		if current == nil {
 			continue
@@ -247,7 +180,8 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 
 		// if aggPrecision > SHARDING_PRECISION, we need to look up in DenseLeaves
 		if aggPrecision > int32(SHARDING_PRECISION) {
-			if current.DenseLeaves == nil {
+			leaves := current.DenseLeaves.Load()
+			if leaves == nil {
 				continue
 			}
 			// get the P8 character index
@@ -256,13 +190,13 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 			if idx < 0 || idx >= 32 {
 				continue
 			}
-			count := current.DenseLeaves[idx]
+			count := leaves[idx].Load()
 			if count == 0 {
 				continue
 			}
 			// for P8 aggPrecision, the geohash is the P8 prefix
-			cell, ok := geohashDecodeBbox(geohash)
-			if !ok || !cell.intersects(queryBbox) {
+			cell, ok := geo.Decode(geohash)
+			if !ok || !cell.Intersects(queryBbox) {
 				continue
 			}
 			// if requested precision == aggPrecision (P8), just return the count
@@ -287,8 +221,8 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 			// (a) include pings outside the bbox but within the same coarse prefix, and
 			// (b) double count by adding the same coarse prefix total once per covered cell (looping through all covered cells)
 			aggCellGh := geohash[:aggPrecision]
-			cell, ok := geohashDecodeBbox(aggCellGh)
-			if !ok || !cell.intersects(queryBbox) {
+			cell, ok := geo.Decode(aggCellGh)
+			if !ok || !cell.Intersects(queryBbox) {
 				continue
 			}
 			counts[prefix] += t.GetCount(aggCellGh)
@@ -312,9 +246,9 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 			}
 
 			if n.depth == precision {
-				cell, ok := geohashDecodeBbox(n.prefix)
-				if ok && cell.intersects(queryBbox) {
-					counts[n.prefix] += n.node.Count
+				cell, ok := geo.Decode(n.prefix)
+				if ok && cell.Intersects(queryBbox) {
+					counts[n.prefix] += n.node.Count.Load()
 				}
 				continue
 			}
@@ -322,18 +256,18 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 			// at SHARDING_PRECISION depth, use dense array for P8 level
 			// TODO: this should be generalized for the gap between SHARDING_PRECISION and MAX_GH_PRECISION
 			if n.depth == int32(SHARDING_PRECISION) && precision == int32(MAX_GH_PRECISION) {
-				if n.node.DenseLeaves != nil {
+				if leaves := n.node.DenseLeaves.Load(); leaves != nil {
 					// iterate through all 32 possible P8 characters
 					for idx := 0; idx < 32; idx++ {
-						count := n.node.DenseLeaves[idx]
+						count := leaves[idx].Load()
 						if count == 0 {
 							continue
 						}
 						// reconstruct P8 geohash from P7 prefix and P8 character
-						nextPrefix := n.prefix + string(geohashBase32[idx])
+						nextPrefix := n.prefix + string(geo.Base32[idx])
 						// check if P8 geohash intersects the query bbox, otherwise skip
-						cell, ok := geohashDecodeBbox(nextPrefix)
-						if !ok || !cell.intersects(queryBbox) {
+						cell, ok := geo.Decode(nextPrefix)
+						if !ok || !cell.Intersects(queryBbox) {
 							continue
 						}
 						counts[nextPrefix] += count
@@ -342,15 +276,20 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 				continue
 			}
 
-			if n.node.Children == nil {
+			children := n.node.Children.Load()
+			if children == nil {
 				continue
 			}
 
 			nextDepth := n.depth + 1
-			for ch, child := range n.node.Children {
-				nextPrefix := n.prefix + string(ch)
-				cell, ok := geohashDecodeBbox(nextPrefix)
-				if !ok || !cell.intersects(queryBbox) {
+			for idx := range children {
+				child := children[idx].Load()
+				if child == nil {
+					continue
+				}
+				nextPrefix := n.prefix + string(geo.Base32[idx])
+				cell, ok := geo.Decode(nextPrefix)
+				if !ok || !cell.Intersects(queryBbox) {
 					continue
 				}
 				stack = append(stack, stackItem{node: child, prefix: nextPrefix, depth: nextDepth})
@@ -361,39 +300,6 @@ func (t *TrieNode) GetAreaCount(precision int32, aggPrecision int32, minLat floa
 	return counts
 }
 
-func cleanupTimeBuffer() {
-	interval := (5 * PING_TTL) / 2
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		now := time.Now().Unix()
-		cutoff := now - PING_TTL
-
-		// check all slots for stale data (older than cutoff)
-		for i := 0; i < int(PING_TTL); i++ {
-			slot := timeBuffer[i]
-
-			slot.Mutex.Lock()
-			if slot.Data != nil && slot.Data.Timestamp < cutoff {
-				// remove the stale slot. GC will handle the rest
-				slot.Data = nil
-				// log.Printf("removed stale slot at index %d", i)
-			}
-			slot.Mutex.Unlock()
-		}
-	}
-}
-
-func observeGRPC(method string, err error, start time.Time) {
-	result := "success"
-	if err != nil {
-		result = "failure"
-	}
-	Metrics.gRPCRequestsTotal.WithLabelValues(method, result).Inc()
-	Metrics.gRPCLatency.WithLabelValues(method).Observe(time.Since(start).Seconds())
-}
-
 type grpcServer struct {
 	pb.UnimplementedWorkerServer
 }
@@ -401,28 +307,56 @@ type grpcServer struct {
 func (s *grpcServer) SendPing(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
 	//log.Printf("Received ping request for geohash: %s", req.Geohash)
 
-	start := time.Now()
-	var err error // for error handling, not implemented yet
-	defer func() {
-		observeGRPC("SendPing", err, start)
-	}()
+	// checked in full: characters beyond the stored precision are dropped below but must still be valid
+	if !validGeohash(req.Geohash) {
+		return nil, status.Error(codes.InvalidArgument, "invalid geohash")
+	}
 
-	now := time.Now().Unix()
-	idx := int(now % PING_TTL)
-	slot := timeBuffer[idx]
+	if len(req.DeviceId) > rawMaxDeviceID {
+		return nil, status.Error(codes.InvalidArgument, "device id too long")
+	}
 
-	slot.Mutex.Lock()
-	defer slot.Mutex.Unlock()
+	if req.Motion != nil && !validMotion(req.Motion) {
+		return nil, status.Error(codes.InvalidArgument, "invalid speed or heading")
+	}
 
-	// (re)initialize buffer element if nil or expired
-	if slot.Data == nil || (slot.Data.Timestamp != now) {
-		slot.Data = &TimeBufferElement{
-			Timestamp: now,
-			TrieRoot:  &TrieNode{Count: 0}, // IncrementTrie will initialize the children map if nil
+	nowTime := monotonicNow()
+	now := nowTime.Unix()
+	if req.DeviceId != "" && tombstoned(req.DeviceId, now) {
+		req.DeviceId = "" // deleted: stored as an anonymous ping
+	}
+	if dedup.duplicate(req.DeviceId, req.Seq, req.Replica, now) {
+		resp := &pb.PingResponse{Success: true, Duplicate: true}
+		if !req.Replica { // the original is stored already
+			resp.WorkerId, resp.Slot, resp.WriteSeq = announcedWorkerId(), now, writeSeq.Load()
 		}
+		return resp, nil
+	}
+	second := ingestSecond(req.Timestamp, nowTime)
+	engine.Ingest(truncateToStored(req.Geohash), second, req.Replica)
+	if store, ok := engine.(movementStore); ok && req.Motion != nil {
+		store.IngestMovement(truncateToStored(req.Geohash), second, req.Replica, req.Motion)
+	}
+	if store, ok := engine.(floorStore); ok && req.HasFloor {
+		store.IngestFloor(truncateToStored(req.Geohash), second, req.Replica, req.Floor)
 	}
+	pingsCache.invalidate(req.Geohash, now, req.Replica)
 
-	slot.Data.TrieRoot.Increment(req.Geohash)
+	timestampMs := req.Timestamp
+	if timestampMs/1000 != second { // bucketed by the worker clock instead (see ingestSecond)
+		timestampMs = nowTime.UnixMilli()
+	}
+	if RAW_RETENTION && !req.Replica {
+		appendRaw(req.Geohash, second, timestampMs, req.Tenant, req.DeviceId, req.Teleport)
+	}
+	if req.Replica {
+		return &pb.PingResponse{Success: true}, nil // replica copies are not counted in the stored metric
+	}
+	observeIngestLatency(req.ClientSentAt) // mirrored pings carry none
+	seq := nextWriteSeq(req.WriteSeq)
+	if !req.Mirror {
+		mirrorPing(req.Geohash, timestampMs, req.Tenant, req.DeviceId, req.Seq, seq, req.Teleport, req.Motion, floorOf(req))
+	}
 
 	// track pings stored per geohash prefix (precision 2 for bounded cardinality: 32^2 = 1024 max prefixes)
 	// reduced from precision 3 (32K labels) to avoid memory growth from Prometheus label accumulation
@@ -433,63 +367,151 @@ func (s *grpcServer) SendPing(ctx context.Context, req *pb.PingRequest) (*pb.Pin
 	}
 	Metrics.pingsStoredTotal.WithLabelValues(ghPrefix).Inc()
 
-	return &pb.PingResponse{Success: true}, nil
+	return &pb.PingResponse{Success: true, WorkerId: announcedWorkerId(), Slot: second, WriteSeq: seq}, nil
 }
 
 func (s *grpcServer) GetPings(ctx context.Context, req *pb.GetPingsRequest) (*pb.GetPingsResponse, error) {
 	//log.Printf("Received get pings request")
 
-	start := time.Now()
-	var err error // for error handling, not implemented yet
-	defer func() {
-		observeGRPC("GetPings", err, start)
-	}()
+	observeQueryPrecision(len(req.Geohash))
+	geohash := truncateToStored(req.Geohash) // finer lookups are answered at the stored precision
 
-	now := time.Now().Unix()
-	cutoff := now - PING_TTL
-	total := int64(0)
+	now := monotonicNow().Unix()
+	cacheKey := countCacheKey{geohash: req.Geohash, second: now, replica: req.Replica}
+	reached := req.TokenWorkerId != "" && tokenReached(req.TokenWorkerId, req.TokenSlot, req.TokenWriteSeq, now) // before counting
+	count, cacheToken, ok := pingsCache.get(cacheKey)
+	if ok {
+		return &pb.GetPingsResponse{Count: count, Timestamp: now, TokenReached: reached}, nil
+	}
 
-	for i := 0; i < int(PING_TTL); i++ {
-		slot := timeBuffer[i]
+	total := engine.QueryPoint(geohash, now, req.Replica)
+	pingsCache.put(cacheKey, total, cacheToken)
+	return &pb.GetPingsResponse{Count: total, Timestamp: now, TokenReached: reached}, nil
+}
 
-		slot.Mutex.RLock()
+// maxBatchGeohashes bounds a GetPingsBatch request (the gateway sends at most MAX_BATCH_POINTS per worker)
+const maxBatchGeohashes = 10000
 
-		// avoid stale/nil data
-		if slot.Data != nil && slot.Data.Timestamp >= cutoff {
-			total += slot.Data.TrieRoot.GetCount(req.Geohash)
+func (s *grpcServer) GetPingsBatch(ctx context.Context, req *pb.GetPingsBatchRequest) (*pb.GetPingsBatchResponse, error) {
+	if len(req.Geohashes) > maxBatchGeohashes {
+		return nil, status.Errorf(codes.InvalidArgument, "too many geohashes (max %d)", maxBatchGeohashes)
+	}
+	geohashes := make([]string, len(req.Geohashes))
+	for i, gh := range req.Geohashes {
+		if gh == "" || !validGeohash(gh) {
+			return nil, status.Error(codes.InvalidArgument, "invalid geohash")
 		}
+		observeQueryPrecision(len(gh))
+		geohashes[i] = truncateToStored(gh) // finer lookups are answered at the stored precision
+	}
 
-		slot.Mutex.RUnlock()
+	now := monotonicNow().Unix()
+	var counts []int64
+	if batcher, ok := engine.(pointBatcher); ok {
+		counts = batcher.QueryPoints(geohashes, now, req.Replica)
+	} else {
+		counts = make([]int64, len(geohashes))
+		for i, gh := range geohashes {
+			counts[i] = engine.QueryPoint(gh, now, req.Replica)
+		}
 	}
+	return &pb.GetPingsBatchResponse{Counts: counts, Timestamp: now}, nil
+}
 
-	return &pb.GetPingsResponse{Count: total, Timestamp: now}, nil
+// GetTotal counts the primary pings of the TTL window, for the gateway's GET /stats/global: the trie engine only adds
+// up the root count of each slot
+func (s *grpcServer) GetTotal(ctx context.Context, req *pb.GetTotalRequest) (*pb.GetTotalResponse, error) {
+	now := monotonicNow().Unix()
+	return &pb.GetTotalResponse{Count: engine.QueryPoint("", now, false), Timestamp: now}, nil
 }
 
 func (s *grpcServer) GetPingArea(ctx context.Context, req *pb.GetPingAreaRequest) (*pb.GetPingAreaResponse, error) {
-	start := time.Now()
-	var err error // for error handling, not implemented yet
-	defer func() {
-		observeGRPC("GetPingArea", err, start)
-	}()
-
-	now := time.Now().Unix()
-	cutoff := now - PING_TTL
-	combined := make(map[string]int64)
-
-	for i := 0; i < int(PING_TTL); i++ {
-		slot := timeBuffer[i]
-
-		slot.Mutex.RLock()
-
-		// avoid stale/nil data
-		if slot.Data != nil && slot.Data.Timestamp >= cutoff && slot.Data.TrieRoot != nil {
-			m := slot.Data.TrieRoot.GetAreaCount(req.Precision, req.AggPrecision, req.MinLat, req.MaxLat, req.MinLng, req.MaxLng, req.Geohashes)
-			for gh, c := range m {
-				combined[gh] += c
+	// queries finer than the stored precision (HISTORY_PRECISION for history queries) are answered at the stored precision
+	precision, aggPrecision, geohashes := req.Precision, req.AggPrecision, req.Geohashes
+	stored := storedPrecision.Load()
+	if req.FrameSeconds > 0 {
+		if err := checkFrames(req); err != nil {
+			return nil, err
+		}
+	}
+	if req.HistoryOffset == 0 {
+		observeQueryPrecision(int(req.Precision))
+		if req.FrameSeconds > 0 && framesNeedHistory(req) {
+			stored = min(stored, int32(HISTORY_PRECISION))
+		}
+	} else {
+		if history == nil {
+			return nil, status.Error(codes.FailedPrecondition, "no history tier (HISTORY_RETENTION unset)")
+		}
+		if !history.covers(req.HistoryOffset) {
+			return nil, status.Error(codes.FailedPrecondition, "history offset beyond HISTORY_RETENTION")
+		}
+		stored = int32(HISTORY_PRECISION)
+	}
+	if aggPrecision > stored {
+		precision = min(precision, stored)
+		aggPrecision = stored
+		seen := make(map[string]struct{}, len(geohashes))
+		geohashes = make([]string, 0, len(req.Geohashes))
+		for _, gh := range req.Geohashes {
+			if len(gh) > int(stored) {
+				gh = gh[:stored]
 			}
+			if _, dup := seen[gh]; dup {
+				continue
+			}
+			seen[gh] = struct{}{}
+			geohashes = append(geohashes, gh)
 		}
+	} else if precision > stored {
+		precision = stored
+	}
+
+	if req.Movement && (req.HistoryOffset > 0 || req.Smooth > 1) {
+		return nil, status.Error(codes.InvalidArgument, "movement is only kept for the live window, unsmoothed")
+	}
+	movements, ok := engine.(movementStore)
+	if req.Movement && !ok {
+		return nil, status.Error(codes.FailedPrecondition, "movement is not kept by this storage engine")
+	}
+	if req.HasFloor && (req.HistoryOffset > 0 || req.Movement) {
+		return nil, status.Error(codes.InvalidArgument, "floors are only kept for the live window counts")
+	}
+	if _, ok := engine.(floorStore); req.HasFloor && !ok {
+		return nil, status.Error(codes.FailedPrecondition, "floors are not kept by this storage engine")
+	}
+
+	q := AreaQuery{
+		Precision:    precision,
+		AggPrecision: aggPrecision,
+		Geohashes:    geohashes,
+		MinLat:       req.MinLat,
+		MaxLat:       req.MaxLat,
+		MinLng:       req.MinLng,
+		MaxLng:       req.MaxLng,
+		Window:       req.Window,
+		ByFloor:      req.HasFloor,
+		Floor:        req.Floor,
+	}
+	if req.FrameSeconds > 0 {
+		return &pb.GetPingAreaResponse{Frames: queryFrames(q, req, monotonicNow().Unix())}, nil
+	}
+	var combined map[string]int64
+	if req.HistoryOffset > 0 {
+		combined = history.queryArea(q, monotonicNow().Unix()-req.HistoryOffset, req.HistoryGranularity, req.Replica)
+	} else if req.Smooth > 1 {
+		e, ok := engine.(areaBySecond)
+		if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "smoothing is not supported by this storage engine")
+		}
+		combined = smoothArea(e.QueryAreaBySecond(q, monotonicNow().Unix(), req.Replica), int(req.Smooth))
+	} else {
+		combined = engine.QueryArea(q, monotonicNow().Unix(), req.Replica)
+	}
 
-		slot.Mutex.RUnlock()
+	var movement map[string]*pb.Movement
+	if req.Movement {
+		movement = movements.QueryAreaMovement(q, monotonicNow().Unix(), req.Replica)
 	}
 
 	// convert combined map to response format
@@ -501,7 +523,7 @@ func (s *grpcServer) GetPingArea(ctx context.Context, req *pb.GetPingAreaRequest
 
 	out := make([]*pb.PingAreaCount, 0, len(keys))
 	for _, gh := range keys {
-		out = append(out, &pb.PingAreaCount{Geohash: gh, Count: combined[gh]})
+		out = append(out, &pb.PingAreaCount{Geohash: gh, Count: combined[gh], Movement: movement[gh]})
 	}
 
 	return &pb.GetPingAreaResponse{Counts: out}, nil
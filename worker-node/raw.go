@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// raw retention (RAW_RETENTION=true): besides the aggregated counts, every primary ping is kept as a (geohash,
+// timestamp, device) row for the TTL window, so polygons can be recounted exactly and a device's track can be listed.
+// rows are stored per second in columns: geohashes packed into a uint64 each, unix ms timestamps and devices
+// dictionary-encoded per second (a device usually pings many times in the window). a device is its id within the tenant
+// that ingested it, so GetDevicePings only lists a track to that tenant
+var RAW_RETENTION = env.Bool("RAW_RETENTION", false)
+var RAW_MAX_PER_SECOND = env.Int("RAW_MAX_PER_SECOND", 1<<20) // rows beyond it are dropped (worker_raw_dropped_total)
+var RAW_DEVICE_PINGS_LIMIT = env.Int("RAW_DEVICE_PINGS_LIMIT", 1000)
+
+const (
+	rawMaxPolygonVertices = 1024
+	rawMaxDeviceID        = 128 // bytes
+)
+
+type rawChunk struct {
+	mu        sync.RWMutex
+	second    int64
+	geohashes []uint64    // see packGeohash
+	times     []int64     // unix ms
+	devices   []uint32    // index into deviceIDs
+	teleports []bool      // tagged by the gateway (TELEPORT_ACTION=tag)
+	deviceIDs []rawDevice // deviceIDs[0] = no device
+	deviceIdx map[rawDevice]uint32
+}
+
+type rawDevice struct {
+	tenant string // "" = anonymous
+	id     string
+}
+
+var rawBuffer []*rawChunk // see openStorage
+
+func newRawBuffer() []*rawChunk {
+	chunks := make([]*rawChunk, PING_TTL)
+	for i := range chunks {
+		chunks[i] = &rawChunk{}
+	}
+	return chunks
+}
+
+// packGeohash stores a geohash (up to 12 characters) as 5 bits per character, with its length in the low 4 bits
+func packGeohash(geohash string) uint64 {
+	v := uint64(0)
+	for i := 0; i < len(geohash); i++ {
+		v = v<<5 | uint64(geohashCharToIndex[geohash[i]])
+	}
+	return v<<4 | uint64(len(geohash))
+}
+
+func unpackGeohash(v uint64) string {
+	n := int(v & 0xf)
+	v >>= 4
+	out := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		out[i] = geo.Base32[v&0x1f]
+		v >>= 5
+	}
+	return string(out)
+}
+
+// appendRaw keeps a row for a primary ping. geohash is the full precision one, before truncation to the stored precision
+func appendRaw(geohash string, second int64, timestampMs int64, tenant string, deviceID string, teleport bool) {
+	if len(geohash) > 12 {
+		geohash = geohash[:12]
+	}
+	chunk := rawBuffer[second%PING_TTL]
+	chunk.mu.Lock()
+	defer chunk.mu.Unlock()
+
+	if chunk.second < second { // expired: reused for the new second
+		chunk.second = second
+		chunk.geohashes = chunk.geohashes[:0]
+		chunk.times = chunk.times[:0]
+		chunk.devices = chunk.devices[:0]
+		chunk.teleports = chunk.teleports[:0]
+		chunk.deviceIDs = append(chunk.deviceIDs[:0], rawDevice{})
+		chunk.deviceIdx = make(map[rawDevice]uint32)
+	} else if chunk.second > second {
+		return // a late ping for a second whose slot was already reused
+	}
+	if len(chunk.geohashes) >= RAW_MAX_PER_SECOND {
+		Metrics.rawDroppedTotal.Inc()
+		return
+	}
+
+	device := uint32(0)
+	if deviceID != "" {
+		key := rawDevice{tenant, deviceID}
+		idx, ok := chunk.deviceIdx[key]
+		if !ok {
+			idx = uint32(len(chunk.deviceIDs))
+			chunk.deviceIDs = append(chunk.deviceIDs, key)
+			chunk.deviceIdx[key] = idx
+		}
+		device = idx
+	}
+	chunk.geohashes = append(chunk.geohashes, packGeohash(geohash))
+	chunk.times = append(chunk.times, timestampMs)
+	chunk.devices = append(chunk.devices, device)
+	chunk.teleports = append(chunk.teleports, teleport)
+}
+
+// pointInPolygon is the even-odd rule (ray casting along the latitude axis)
+func pointInPolygon(lat, lng float64, vertices []*pb.LatLng) bool {
+	inside := false
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		a, b := vertices[i], vertices[j]
+		if (a.Lat > lat) != (b.Lat > lat) && lng < (b.Lng-a.Lng)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func (s *grpcServer) CountInPolygon(ctx context.Context, req *pb.CountInPolygonRequest) (*pb.CountInPolygonResponse, error) {
+	if !RAW_RETENTION {
+		return nil, status.Error(codes.FailedPrecondition, "raw retention is disabled")
+	}
+	if len(req.Vertices) < 3 || len(req.Vertices) > rawMaxPolygonVertices {
+		return nil, status.Error(codes.InvalidArgument, "a polygon needs 3 to 1024 vertices")
+	}
+	bbox := geo.Bbox{MinLat: math.Inf(1), MaxLat: math.Inf(-1), MinLng: math.Inf(1), MaxLng: math.Inf(-1)}
+	for _, v := range req.Vertices {
+		if v == nil || math.IsNaN(v.Lat) || math.IsNaN(v.Lng) {
+			return nil, status.Error(codes.InvalidArgument, "invalid vertex")
+		}
+		bbox.MinLat, bbox.MaxLat = min(bbox.MinLat, v.Lat), max(bbox.MaxLat, v.Lat)
+		bbox.MinLng, bbox.MaxLng = min(bbox.MinLng, v.Lng), max(bbox.MaxLng, v.Lng)
+	}
+
+	cutoff := monotonicNow().Unix() - PING_TTL
+	count := int64(0)
+	for _, chunk := range rawBuffer {
+		chunk.mu.RLock()
+		if chunk.second >= cutoff {
+			for _, packed := range chunk.geohashes {
+				cell, ok := geo.Decode(unpackGeohash(packed))
+				if !ok {
+					continue
+				}
+				lat, lng := cell.Center()
+				if !bbox.Contains(lat, lng) {
+					continue
+				}
+				if pointInPolygon(lat, lng, req.Vertices) {
+					count++
+				}
+			}
+		}
+		chunk.mu.RUnlock()
+	}
+	return &pb.CountInPolygonResponse{Count: count}, nil
+}
+
+func (s *grpcServer) GetDevicePings(ctx context.Context, req *pb.GetDevicePingsRequest) (*pb.GetDevicePingsResponse, error) {
+	if !RAW_RETENTION {
+		return nil, status.Error(codes.FailedPrecondition, "raw retention is disabled")
+	}
+	if req.DeviceId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing device id")
+	}
+	limit := RAW_DEVICE_PINGS_LIMIT
+	if req.Limit > 0 && int(req.Limit) < limit {
+		limit = int(req.Limit)
+	}
+
+	cutoff := monotonicNow().Unix() - PING_TTL
+	var pings []*pb.RawPing
+	for _, chunk := range rawBuffer {
+		chunk.mu.RLock()
+		if idx, ok := chunk.deviceIdx[rawDevice{req.Tenant, req.DeviceId}]; ok && chunk.second >= cutoff {
+			for i, device := range chunk.devices {
+				if device == idx {
+					pings = append(pings, &pb.RawPing{Geohash: unpackGeohash(chunk.geohashes[i]), Timestamp: chunk.times[i], Teleport: chunk.teleports[i]})
+				}
+			}
+		}
+		chunk.mu.RUnlock()
+	}
+
+	sort.Slice(pings, func(i, j int) bool { return pings[i].Timestamp < pings[j].Timestamp })
+	if len(pings) > limit {
+		pings = pings[len(pings)-limit:]
+	}
+	return &pb.GetDevicePingsResponse{Pings: pings}, nil
+}
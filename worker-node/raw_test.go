@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	pb "geostreamdb/proto"
+)
+
+func withRawRetention(t *testing.T) {
+	previousRaw, previousBuffer := RAW_RETENTION, rawBuffer
+	RAW_RETENTION, rawBuffer = true, newRawBuffer()
+	t.Cleanup(func() { RAW_RETENTION, rawBuffer = previousRaw, previousBuffer })
+}
+
+func TestDevicePingsAreScopedToTheIngestingTenant(t *testing.T) {
+	withFakeClock(t)
+	withRawRetention(t)
+	s := &grpcServer{}
+	send := func(tenant string, deviceID string) {
+		if _, err := s.SendPing(context.Background(), &pb.PingRequest{Geohash: "u4pruydq", Tenant: tenant, DeviceId: deviceID}); err != nil {
+			t.Fatalf("SendPing: %v", err)
+		}
+	}
+	pings := func(tenant string, deviceID string) int {
+		v, err := s.GetDevicePings(context.Background(), &pb.GetDevicePingsRequest{Tenant: tenant, DeviceId: deviceID})
+		if err != nil {
+			t.Fatalf("GetDevicePings: %v", err)
+		}
+		return len(v.Pings)
+	}
+
+	send("acme", "truck-42")
+	send("acme", "truck-42")
+	send("", "truck-42") // same id, anonymous device
+	if got := pings("acme", "truck-42"); got != 2 {
+		t.Errorf("acme's device: got %d pings, want 2", got)
+	}
+	if got := pings("", "truck-42"); got != 1 {
+		t.Errorf("anonymous device: got %d pings, want 1", got)
+	}
+	if got := pings("globex", "truck-42"); got != 0 {
+		t.Errorf("another tenant read %d of the device's pings", got)
+	}
+
+	// a deletion purges the id whichever tenant ingested it
+	resp, err := s.DeleteDevice(context.Background(), &pb.DeleteDeviceRequest{DeviceId: "truck-42"})
+	if err != nil || resp.RawPings != 3 {
+		t.Fatalf("DeleteDevice: got %v, %v, want 3 pings unlinked", resp, err)
+	}
+	if got := pings("acme", "truck-42") + pings("", "truck-42"); got != 0 {
+		t.Errorf("%d pings still linked to the device after its deletion", got)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "sync/atomic"
+
+// read tokens (see gateway/readtoken.go): every primary ping stored takes the next write sequence number, returned to
+// the gateway with the worker id and the ping's second. a GetPings carrying the token tells whether that write is
+// counted: it is once this worker (by announced id) stored that many primary pings. a standby carries on from the
+// primary's sequence numbers it gets with mirrored pings, so tokens survive a promotion (unless their ping was lost)
+var writeSeq atomic.Uint64
+
+// nextWriteSeq numbers a stored primary ping. mirrored pings keep the primary's number
+func nextWriteSeq(mirrored uint64) uint64 {
+	if mirrored == 0 {
+		return writeSeq.Add(1)
+	}
+	for {
+		current := writeSeq.Load()
+		if current >= mirrored || writeSeq.CompareAndSwap(current, mirrored) {
+			return mirrored
+		}
+	}
+}
+
+// tokenReached reports whether the write a read token names is counted, or already out of the TTL window
+func tokenReached(workerID string, slot int64, seq uint64, now int64) bool {
+	if slot <= now-PING_TTL {
+		return true
+	}
+	return workerID == announcedWorkerId() && writeSeq.Load() >= seq
+}
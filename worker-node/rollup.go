@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"geostreamdb/env"
+)
+
+// precision-bounded storage: pings are stored down to the effective stored precision only (at most STORAGE_PRECISION).
+// with ROLLUP_WINDOW set, the effective precision follows the finest precision actually queried: it is lowered (and the
+// live tries truncated) at the end of a window in which no query needed more, and raised as soon as a finer query arrives.
+// node counts already include all their descendants, so truncating a trie never changes the counts of the kept levels
+var STORAGE_PRECISION = clampPrecision(env.Int("STORAGE_PRECISION", MAX_GH_PRECISION))
+var ROLLUP_WINDOW = env.Duration("ROLLUP_WINDOW", 0) // 0 disables automatic rollup
+var ROLLUP_MIN_PRECISION = clampPrecision(env.Int("ROLLUP_MIN_PRECISION", 1))
+
+var (
+	storedPrecision     atomic.Int32 // effective stored precision
+	maxQueriedPrecision atomic.Int32 // finest precision queried in the current rollup window
+)
+
+func init() {
+	storedPrecision.Store(int32(STORAGE_PRECISION))
+	Metrics.storedPrecision.Set(float64(STORAGE_PRECISION))
+}
+
+func clampPrecision(p int) int {
+	return max(1, min(p, MAX_GH_PRECISION))
+}
+
+// observeQueryPrecision records the precision a query needs, raising the stored precision right away if needed
+// (the extra detail is only available for pings stored from then on)
+func observeQueryPrecision(precision int) {
+	p := int32(min(precision, STORAGE_PRECISION))
+	for {
+		cur := maxQueriedPrecision.Load()
+		if p <= cur || maxQueriedPrecision.CompareAndSwap(cur, p) {
+			break
+		}
+	}
+	if ROLLUP_WINDOW <= 0 {
+		return
+	}
+	for {
+		cur := storedPrecision.Load()
+		if p <= cur {
+			return
+		}
+		if storedPrecision.CompareAndSwap(cur, p) {
+			Metrics.storedPrecision.Set(float64(p))
+			log.Printf("stored precision raised to %d", p)
+			return
+		}
+	}
+}
+
+func rollupLoop() {
+	if ROLLUP_WINDOW <= 0 {
+		return
+	}
+	ticker := time.NewTicker(ROLLUP_WINDOW)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		queried := maxQueriedPrecision.Swap(0)
+		if queried == 0 {
+			continue // no queries in this window: nothing to base a decision on
+		}
+		target := max(queried, int32(ROLLUP_MIN_PRECISION))
+		if target >= storedPrecision.Load() {
+			continue
+		}
+
+		storedPrecision.Store(target)
+		Metrics.storedPrecision.Set(float64(target))
+		log.Printf("stored precision rolled up to %d", target)
+
+		if t, ok := engine.(truncatable); ok {
+			t.Truncate(int(target))
+		}
+	}
+}
+
+// Truncate drops every node deeper than depth (counts at depth and above are kept as is). must be called with the slot
+// mutex held; dropped nodes are left to the GC since lock-free readers may still be traversing them
+func (t *TrieNode) Truncate(depth int) {
+	if t == nil {
+		return
+	}
+	if depth <= 0 {
+		t.Children.Store(nil)
+		t.DenseLeaves.Store(nil)
+		return
+	}
+	children := t.Children.Load()
+	if children == nil {
+		return
+	}
+	for i := range children {
+		children[i].Load().Truncate(depth - 1)
+	}
+}
+
+// truncateToStored cuts a geohash to the effective stored precision
+func truncateToStored(geohash string) string {
+	p := int(storedPrecision.Load())
+	if len(geohash) > p {
+		return geohash[:p]
+	}
+	return geohash
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+// a lock-free reader may still hold a slot's trie long after it was rotated out: its counts must stay as they were,
+// however many times the slot is reused meanwhile
+func TestRotatedTrieKeepsItsCountsForReaders(t *testing.T) {
+	e := newTrieEngine(2)
+	gh := geo.Encode(48.85, 2.35, MAX_GH_PRECISION)
+	second := int64(1_000_000)
+	for range 3 {
+		e.Ingest(gh, second, false)
+	}
+	slot := e.primary[int(second%e.ttl)*TIME_BUFFER_SHARDS+shardIndex(gh)]
+	held := slot.Data.Load().TrieRoot // as a reader walking it would
+
+	for s := second + 1; s < second+10; s++ {
+		e.SnapshotExpired(s, nil)
+		e.Ingest(gh, s, false)
+	}
+	if got := held.GetCount(gh); got != 3 {
+		t.Fatalf("rotated trie counts %d, want 3", got)
+	}
+	if got := held.GetCount(gh[:SHARDING_PRECISION]); got != 3 {
+		t.Fatalf("rotated trie counts %d at precision %d, want 3", got, SHARDING_PRECISION)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cluster settings distributed by the registry (see registry/settings.go): fetched at startup, before the storage is
+// opened, they override PING_TTL. a registry not answering within CLUSTER_SETTINGS_WAIT leaves the worker's own. later
+// changes only apply on restart (a rolling restart picks them up): until then the worker is flagged as stale. a
+// sharding migration isn't a change for workers, they store whatever keys they are sent
+var CLUSTER_SETTINGS_WAIT = env.Duration("CLUSTER_SETTINGS_WAIT", 10*time.Second)
+
+var settingsVersion atomic.Uint64 // of the settings applied (at startup, or carried on by a sharding migration), 0 = none
+
+var settingsPingTtl int64 // the registry's ping ttl applied at startup (0 = none)
+
+var staleSettingsVersion uint64 // last version reported as stale (heartbeat loop only)
+
+// fetchClusterSettings applies the registry's settings, retrying for up to CLUSTER_SETTINGS_WAIT
+func fetchClusterSettings(client pb.RegistryClient) {
+	deadline := time.Now().Add(CLUSTER_SETTINGS_WAIT)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		s, err := client.GetClusterSettings(ctx, &pb.GetClusterSettingsRequest{})
+		cancel()
+		if err == nil {
+			applyClusterSettings(s)
+			return
+		}
+		if status.Code(err) == codes.Unimplemented || time.Now().After(deadline) { // older registry, or none reachable
+			log.Printf("no cluster settings from the registry (%v): using this worker's own", err)
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func applyClusterSettings(s *pb.ClusterSettings) {
+	if s.Version == 0 {
+		return
+	}
+	if s.PingTtl > 0 {
+		if s.PingTtl != PING_TTL {
+			log.Printf("PING_TTL=%d overridden by the registry's %d", PING_TTL, s.PingTtl)
+		}
+		PING_TTL = s.PingTtl
+	}
+	settingsPingTtl = s.PingTtl
+	settingsVersion.Store(s.Version)
+	log.Printf("running with cluster settings %x", s.Version)
+}
+
+// checkClusterSettings compares the settings of a heartbeat response with the ones applied
+func checkClusterSettings(s *pb.ClusterSettings) {
+	version := s.GetVersion()
+	if version != settingsVersion.Load() && s.GetShardingMigration() != nil && s.PingTtl == settingsPingTtl {
+		settingsVersion.Store(version) // only the sharding precision changed
+		log.Printf("sharding migration to precision %d: running with cluster settings %x", s.ShardingPrecision, version)
+	}
+	if version == settingsVersion.Load() {
+		Metrics.settingsStale.Set(0)
+		return
+	}
+	Metrics.settingsStale.Set(1)
+	if version != staleSettingsVersion {
+		staleSettingsVersion = version
+		log.Printf("the registry distributes cluster settings %x, this worker runs with %x: restart it to apply them", version, settingsVersion.Load())
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "math"
+
+// smoothed area queries (GetPingArea smooth > 1) average the counts of the last n windows, ending now, a second ago,
+// ... n-1 seconds ago, so live heatmaps don't flicker as single seconds enter and leave the short TTL window. only the
+// seconds of the TTL window are held (there is no history tier), so the windows averaged are shortened by n-1 seconds
+// to fit in it, and their average scaled back to a full window. n is capped at half the window
+
+// smoothArea averages the per-second counts of QueryAreaBySecond over n windows, rounding to whole pings (cells
+// averaging under half a ping are left out)
+func smoothArea(bySecond []map[string]int64, n int) map[string]int64 {
+	size := len(bySecond)
+	n = min(n, max(size/2, 1))
+	length := size - n + 1 // of each window
+
+	sums := make(map[string]float64)
+	for age, counts := range bySecond {
+		// the windows starting at ages max(0, age-length+1) to min(n-1, age) hold this second
+		weight := min(n-1, age) - max(0, age-length+1) + 1
+		if weight <= 0 {
+			continue
+		}
+		for gh, c := range counts {
+			sums[gh] += float64(weight) * float64(c)
+		}
+	}
+
+	scale := float64(size) / float64(length) / float64(n)
+	out := make(map[string]int64, len(sums))
+	for gh, sum := range sums {
+		if c := int64(math.Round(sum * scale)); c > 0 {
+			out[gh] = c
+		}
+	}
+	return out
+}
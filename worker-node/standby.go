@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"geostreamdb/env"
+	pb "geostreamdb/proto"
+	"geostreamdb/rpc"
+
+	"google.golang.org/grpc"
+)
+
+// warm standby pairs: a primary started with STANDBY_ADDRESS mirrors every primary ping it stores to that worker, which
+// is started with STANDBY_FOR set to the primary's address. the standby stores the mirrored pings as primary data but
+// stays out of the ring (the registry doesn't forward its heartbeats) until the registry sees the primary miss
+// heartbeats and promotes it: the standby then heartbeats with the primary's worker id, so gateways move the primary's
+// virtual nodes (and shards) to it with the TTL window of data already in place. mirroring is best-effort: pings that
+// don't fit in the queue are dropped and counted
+var STANDBY_ADDRESS = env.String("STANDBY_ADDRESS", "")           // primary side: where to mirror to
+var STANDBY_FOR = env.String("STANDBY_FOR", "")                   // standby side: the primary's address
+var STANDBY_MIRROR_QUEUE = env.Int("STANDBY_MIRROR_QUEUE", 65536) // pings
+var STANDBY_MIRROR_WORKERS = env.Int("STANDBY_MIRROR_WORKERS", 4)
+
+type mirroredPing struct {
+	geohash     string
+	timestampMs int64
+	tenant      string
+	deviceID    string
+	seq         uint64
+	writeSeq    uint64
+	teleport    bool
+	motion      *pb.Motion
+	floor       *int32
+}
+
+var mirrorQueue chan mirroredPing
+var standbyClient pb.WorkerClient // nil without STANDBY_ADDRESS
+
+// promotedAs is the worker id taken over from the primary (empty while standby)
+var promotedAs atomic.Pointer[string]
+
+func isStandby() bool {
+	return STANDBY_FOR != "" && promotedAs.Load() == nil
+}
+
+// startMirroring connects to STANDBY_ADDRESS and starts the senders draining the mirror queue
+func startMirroring() {
+	if STANDBY_ADDRESS == "" {
+		return
+	}
+	conn, err := grpc.NewClient(STANDBY_ADDRESS, rpc.DialOptions(grpc.WithChainUnaryInterceptor(workerIdUnaryInterceptor))...)
+	if err != nil {
+		log.Fatalf("failed to dial standby: %v", err)
+	}
+	client := pb.NewWorkerClient(conn)
+	standbyClient = client
+	mirrorQueue = make(chan mirroredPing, max(1, STANDBY_MIRROR_QUEUE))
+	for i := 0; i < max(1, STANDBY_MIRROR_WORKERS); i++ {
+		go func() {
+			for p := range mirrorQueue {
+				req := &pb.PingRequest{Geohash: p.geohash, Timestamp: p.timestampMs, Tenant: p.tenant, DeviceId: p.deviceID, Seq: p.seq, WriteSeq: p.writeSeq, Teleport: p.teleport, Motion: p.motion, Mirror: true, ApiVersion: pb.API_VERSION}
+				if p.floor != nil {
+					req.HasFloor, req.Floor = true, *p.floor
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				_, err := client.SendPing(ctx, req)
+				cancel()
+				if err != nil {
+					Metrics.mirroredPingsTotal.WithLabelValues("failed").Inc()
+					continue
+				}
+				Metrics.mirroredPingsTotal.WithLabelValues("sent").Inc()
+			}
+		}()
+	}
+	log.Printf("mirroring primary pings to standby %s", STANDBY_ADDRESS)
+}
+
+// mirrorPing queues a stored primary ping for the standby (no-op without one)
+func mirrorPing(geohash string, timestampMs int64, tenant string, deviceID string, seq uint64, writeSeq uint64, teleport bool, motion *pb.Motion, floor *int32) {
+	if mirrorQueue == nil {
+		return
+	}
+	select {
+	case mirrorQueue <- mirroredPing{geohash: geohash, timestampMs: timestampMs, tenant: tenant, deviceID: deviceID, seq: seq, writeSeq: writeSeq, teleport: teleport, motion: motion, floor: floor}:
+	default:
+		Metrics.mirroredPingsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// promote takes over the primary's worker id, as told by the registry. false if already promoted
+func promote(workerId string) bool {
+	if !isStandby() {
+		return false
+	}
+	promotedAs.Store(&workerId)
+	Metrics.standby.Set(0)
+	log.Printf("primary %s missed its heartbeats: promoted, taking over worker id %s", STANDBY_FOR, workerId)
+	return true
+}
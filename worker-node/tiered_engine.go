@@ -0,0 +1,626 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"geostreamdb/env"
+	"geostreamdb/geo"
+)
+
+// tiered engine (STORAGE=tiered): the newest SPILL_AFTER seconds live in the in-memory trie engine; every second that
+// leaves it is spilled to a compressed block file in STORAGE_DIR, which queries keep reading until the second leaves
+// PING_TTL. a background compactor merges consecutive blocks into blocks of up to SPILL_BLOCK_SPAN seconds, so a
+// minutes-long window is a handful of files rather than one per second
+var SPILL_AFTER = int64(env.Int("SPILL_AFTER", 10))                                 // seconds kept in memory
+var SPILL_BLOCK_SPAN = int64(env.Int("SPILL_BLOCK_SPAN", 60))                       // seconds per compacted block
+var SPILL_COMPACT_INTERVAL = env.Duration("SPILL_COMPACT_INTERVAL", 30*time.Second) // compactor period
+var SPILL_CACHE_BLOCKS = env.Int("SPILL_CACHE_BLOCKS", 64)                          // decoded blocks kept in memory
+
+const (
+	spillMagic      = "GSB1"
+	spillHeaderSize = 4 + 8 + 8 + 4 // magic, min second, max second, shard mask
+	spillFileSuffix = ".blk"
+
+	// compacted/expired files are only removed after this delay, as a query may have just picked them from the index
+	spillRemoveDelay = 10 * time.Second
+)
+
+var errSpillFormat = errors.New("invalid spill block")
+
+// spillBlock is the index entry of a block file
+type spillBlock struct {
+	path      string
+	replica   bool
+	minSecond int64
+	maxSecond int64
+	shards    uint32 // bit per first geohash character present in the block
+	size      int64
+}
+
+// spillEntry counts the pings stored at exactly geohash in one second. blocks hold them sorted by (geohash, second)
+type spillEntry struct {
+	geohash string
+	second  int64
+	count   int64
+}
+
+type tieredEngine struct {
+	mem *trieEngine
+	dir string
+
+	mu     sync.RWMutex
+	blocks []*spillBlock // sorted by minSecond
+	seq    atomic.Uint64 // makes file names unique
+
+	cache *blockCache
+}
+
+func newTieredEngine(dir string) (*tieredEngine, error) {
+	if SPILL_AFTER < 1 || SPILL_AFTER >= PING_TTL {
+		return nil, fmt.Errorf("SPILL_AFTER must be between 1 and PING_TTL-1 (got %d, PING_TTL %d)", SPILL_AFTER, PING_TTL)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	e := &tieredEngine{mem: newTrieEngine(SPILL_AFTER), dir: dir, cache: newBlockCache(SPILL_CACHE_BLOCKS)}
+
+	// blocks left by a previous run are still valid while they are in the window
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+spillFileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		b, err := readSpillHeader(path)
+		if err != nil {
+			log.Printf("ignoring spill block %s: %v", path, err)
+			continue
+		}
+		e.blocks = append(e.blocks, b)
+	}
+	// a crash between a compaction and the removal of its inputs leaves both behind: the inputs are the blocks whose
+	// seconds are all within another block of the same kind
+	sort.Slice(e.blocks, func(i, j int) bool {
+		return e.blocks[i].maxSecond-e.blocks[i].minSecond > e.blocks[j].maxSecond-e.blocks[j].minSecond
+	})
+	kept := e.blocks[:0]
+	for _, b := range e.blocks {
+		covered := false
+		for _, k := range kept {
+			if k.replica == b.replica && k.minSecond <= b.minSecond && b.maxSecond <= k.maxSecond {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			os.Remove(b.path)
+			continue
+		}
+		kept = append(kept, b)
+	}
+	e.blocks = kept
+	sort.Slice(e.blocks, func(i, j int) bool { return e.blocks[i].minSecond < e.blocks[j].minSecond })
+	e.expireBlocks(monotonicNow().Unix()-PING_TTL+1, nil)
+
+	go e.compactLoop()
+	return e, nil
+}
+
+func (e *tieredEngine) Ingest(geohash string, second int64, replica bool) {
+	e.mem.Ingest(geohash, second, replica)
+}
+
+// candidates returns the blocks that may hold data of the given shards from cutoff on
+func (e *tieredEngine) candidates(replica bool, cutoff int64, shards uint32) []*spillBlock {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var out []*spillBlock
+	for _, b := range e.blocks {
+		if b.replica == replica && b.maxSecond >= cutoff && b.shards&shards != 0 {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+func shardMask(geohash string) uint32 {
+	if geohash == "" {
+		return ^uint32(0)
+	}
+	return 1 << shardIndex(geohash)
+}
+
+func (e *tieredEngine) QueryPoint(geohash string, now int64, replica bool) int64 {
+	total := e.mem.QueryPoint(geohash, now, replica)
+
+	cutoff := now - PING_TTL + 1 // the oldest second still in the window, as expireBlocks keeps them
+	for _, b := range e.candidates(replica, cutoff, shardMask(geohash)) {
+		entries, err := e.load(b)
+		if err != nil {
+			continue
+		}
+		total += sumPrefix(entries, geohash, cutoff)
+	}
+	return total
+}
+
+// sumPrefix sums the entries under a geohash prefix from cutoff on
+func sumPrefix(entries []spillEntry, prefix string, cutoff int64) int64 {
+	total := int64(0)
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].geohash >= prefix })
+	for ; i < len(entries) && strings.HasPrefix(entries[i].geohash, prefix); i++ {
+		if entries[i].second >= cutoff {
+			total += entries[i].count
+		}
+	}
+	return total
+}
+
+func (e *tieredEngine) QueryArea(q AreaQuery, now int64, replica bool) map[string]int64 {
+	combined := e.mem.QueryArea(q, now, replica)
+	if q.Precision < 1 || q.AggPrecision < 1 {
+		return combined
+	}
+
+	cutoff := q.cutoff(now, PING_TTL) + 1 // the newest Window (or PING_TTL) seconds, now included
+	queryBbox := geo.Bbox{MinLat: q.MinLat, MaxLat: q.MaxLat, MinLng: q.MinLng, MaxLng: q.MaxLng}
+	shards := uint32(0)
+	for _, gh := range q.Geohashes {
+		if gh != "" {
+			shards |= shardMask(gh)
+		}
+	}
+
+	for _, b := range e.candidates(replica, cutoff, shards) {
+		entries, err := e.load(b)
+		if err != nil {
+			continue
+		}
+		for _, geohash := range q.Geohashes {
+			if len(geohash) < int(q.AggPrecision) || b.shards&shardMask(geohash) == 0 {
+				continue
+			}
+			aggCellGh := geohash[:q.AggPrecision]
+
+			// coarser (or equal) precision: the covered cell's count goes to its prefix (see TrieNode.GetAreaCount)
+			if q.Precision <= q.AggPrecision {
+				cell, ok := geo.Decode(aggCellGh)
+				if !ok || !cell.Intersects(queryBbox) {
+					continue
+				}
+				if c := sumPrefix(entries, aggCellGh, cutoff); c > 0 {
+					combined[aggCellGh[:q.Precision]] += c
+				}
+				continue
+			}
+
+			// finer precision: every entry under the covered cell counts for its cell at the requested precision
+			var lastCell string
+			lastIntersects := false
+			i := sort.Search(len(entries), func(i int) bool { return entries[i].geohash >= aggCellGh })
+			for ; i < len(entries) && strings.HasPrefix(entries[i].geohash, aggCellGh); i++ {
+				en := entries[i]
+				if en.second < cutoff || len(en.geohash) < int(q.Precision) {
+					continue
+				}
+				if cellGh := en.geohash[:q.Precision]; cellGh != lastCell {
+					lastCell = cellGh
+					cell, ok := geo.Decode(cellGh)
+					lastIntersects = ok && cell.Intersects(queryBbox)
+				}
+				if lastIntersects {
+					combined[lastCell] += en.count
+				}
+			}
+		}
+	}
+	return combined
+}
+
+func (e *tieredEngine) Truncate(precision int) {
+	e.mem.Truncate(precision) // spilled blocks keep their detail: rollup is about memory
+}
+
+// SlotUsage reports the in-memory part (spilled blocks are on worker_spill_* metrics)
+func (e *tieredEngine) SlotUsage(now int64, replica bool) slotUsage {
+	return e.mem.SlotUsage(now, replica)
+}
+
+// SnapshotExpired rotates the in-memory seconds (spilling the one that leaves memory) and drops the blocks whose
+// newest second left PING_TTL
+func (e *tieredEngine) SnapshotExpired(second int64, fn func(ExpiredSecond)) {
+	e.mem.SnapshotExpired(second, func(exp ExpiredSecond) {
+		if err := e.spill(exp); err != nil {
+			log.Printf("failed to spill second %d: %v", exp.Second, err)
+			Metrics.storageErrorsTotal.WithLabelValues("spill").Inc()
+		}
+	})
+	e.expireBlocks(second-PING_TTL+1, fn)
+}
+
+func (e *tieredEngine) spill(exp ExpiredSecond) error {
+	entries := make([]spillEntry, 0, len(exp.Counts))
+	for gh, c := range exp.Counts {
+		entries = append(entries, spillEntry{geohash: gh, second: exp.Second, count: c})
+	}
+	sortSpillEntries(entries)
+	b, err := e.writeBlock(exp.Replica, entries)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.blocks = append(e.blocks, b)
+	sort.SliceStable(e.blocks, func(i, j int) bool { return e.blocks[i].minSecond < e.blocks[j].minSecond })
+	e.mu.Unlock()
+	e.updateMetrics()
+	return nil
+}
+
+// expireBlocks drops the blocks with no second from cutoff on, passing their data to fn if not nil
+func (e *tieredEngine) expireBlocks(cutoff int64, fn func(ExpiredSecond)) {
+	e.mu.Lock()
+	var expired []*spillBlock
+	kept := e.blocks[:0]
+	for _, b := range e.blocks {
+		if b.maxSecond < cutoff {
+			expired = append(expired, b)
+		} else {
+			kept = append(kept, b)
+		}
+	}
+	clear(e.blocks[len(kept):])
+	e.blocks = kept
+	e.mu.Unlock()
+
+	for _, b := range expired {
+		if fn != nil {
+			if entries, err := e.load(b); err == nil {
+				for _, exp := range expiredSeconds(entries, b.replica) {
+					fn(exp)
+				}
+			}
+		}
+		e.remove(b)
+	}
+	if len(expired) > 0 {
+		e.updateMetrics()
+	}
+}
+
+func expiredSeconds(entries []spillEntry, replica bool) []ExpiredSecond {
+	bySecond := make(map[int64]map[string]int64)
+	for _, en := range entries {
+		if bySecond[en.second] == nil {
+			bySecond[en.second] = make(map[string]int64)
+		}
+		bySecond[en.second][en.geohash] += en.count
+	}
+	out := make([]ExpiredSecond, 0, len(bySecond))
+	for s, counts := range bySecond {
+		out = append(out, ExpiredSecond{Second: s, Replica: replica, Counts: counts})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Second < out[j].Second })
+	return out
+}
+
+func (e *tieredEngine) remove(b *spillBlock) {
+	time.AfterFunc(spillRemoveDelay, func() {
+		e.cache.drop(b.path)
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove spill block %s: %v", b.path, err)
+		}
+	})
+}
+
+func (e *tieredEngine) updateMetrics() {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	size := int64(0)
+	for _, b := range e.blocks {
+		size += b.size
+	}
+	Metrics.spillBlocks.Set(float64(len(e.blocks)))
+	Metrics.spillBytes.Set(float64(size))
+}
+
+func (e *tieredEngine) compactLoop() {
+	ticker := time.NewTicker(SPILL_COMPACT_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, replica := range []bool{false, true} {
+			if err := e.compact(replica); err != nil {
+				log.Printf("spill compaction failed: %v", err)
+				Metrics.storageErrorsTotal.WithLabelValues("compact").Inc()
+			}
+		}
+	}
+}
+
+// compact merges runs of consecutive blocks that together span at most SPILL_BLOCK_SPAN seconds
+func (e *tieredEngine) compact(replica bool) error {
+	e.mu.RLock()
+	var runs [][]*spillBlock
+	var run []*spillBlock
+	for _, b := range e.blocks {
+		if b.replica != replica {
+			continue
+		}
+		if len(run) > 0 && b.maxSecond-run[0].minSecond >= SPILL_BLOCK_SPAN {
+			runs = append(runs, run)
+			run = nil
+		}
+		run = append(run, b)
+	}
+	e.mu.RUnlock()
+	// the last run is still growing unless it is already full
+	if len(run) > 0 && run[len(run)-1].maxSecond-run[0].minSecond >= SPILL_BLOCK_SPAN-1 {
+		runs = append(runs, run)
+	}
+
+	for _, run := range runs {
+		if len(run) < 2 {
+			continue
+		}
+		var entries []spillEntry
+		for _, b := range run {
+			blockEntries, err := e.load(b)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, blockEntries...)
+		}
+		sortSpillEntries(entries)
+		merged, err := e.writeBlock(replica, entries)
+		if err != nil {
+			return err
+		}
+
+		// swap the run for the merged block (runs that were expired meanwhile are simply dropped from the new list)
+		inRun := make(map[*spillBlock]bool, len(run))
+		for _, b := range run {
+			inRun[b] = true
+		}
+		e.mu.Lock()
+		kept := make([]*spillBlock, 0, len(e.blocks))
+		found := 0
+		for _, b := range e.blocks {
+			if inRun[b] {
+				found++
+				continue
+			}
+			kept = append(kept, b)
+		}
+		if found == len(run) {
+			kept = append(kept, merged)
+			sort.SliceStable(kept, func(i, j int) bool { return kept[i].minSecond < kept[j].minSecond })
+			e.blocks = kept
+		}
+		e.mu.Unlock()
+
+		if found != len(run) { // part of the run expired meanwhile: keep the originals, drop the merged block
+			e.remove(merged)
+			continue
+		}
+		for _, b := range run {
+			e.remove(b)
+		}
+		Metrics.spillCompactionsTotal.Inc()
+	}
+	e.updateMetrics()
+	return nil
+}
+
+func sortSpillEntries(entries []spillEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].geohash != entries[j].geohash {
+			return entries[i].geohash < entries[j].geohash
+		}
+		return entries[i].second < entries[j].second
+	})
+}
+
+// block file: header (magic, min second, max second, shard mask; big-endian) then, deflated, the entry count and every
+// entry as (geohash prefix shared with the previous entry, rest of the geohash, second - min second, count), as uvarints
+func (e *tieredEngine) writeBlock(replica bool, entries []spillEntry) (*spillBlock, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("empty block")
+	}
+	b := &spillBlock{replica: replica, minSecond: entries[0].second, maxSecond: entries[0].second}
+	for _, en := range entries {
+		b.minSecond = min(b.minSecond, en.second)
+		b.maxSecond = max(b.maxSecond, en.second)
+		b.shards |= shardMask(en.geohash)
+	}
+	kind := "p"
+	if replica {
+		kind = "r"
+	}
+	b.path = filepath.Join(e.dir, fmt.Sprintf("%s-%d-%d-%d%s", kind, b.minSecond, b.maxSecond, e.seq.Add(1), spillFileSuffix))
+
+	var buf bytes.Buffer
+	buf.WriteString(spillMagic)
+	binary.Write(&buf, binary.BigEndian, b.minSecond)
+	binary.Write(&buf, binary.BigEndian, b.maxSecond)
+	binary.Write(&buf, binary.BigEndian, b.shards)
+
+	zw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	var scratch []byte
+	scratch = binary.AppendUvarint(scratch, uint64(len(entries)))
+	prev := ""
+	for _, en := range entries {
+		shared := 0
+		for shared < len(prev) && shared < len(en.geohash) && prev[shared] == en.geohash[shared] {
+			shared++
+		}
+		scratch = binary.AppendUvarint(scratch, uint64(shared))
+		scratch = binary.AppendUvarint(scratch, uint64(len(en.geohash)-shared))
+		scratch = append(scratch, en.geohash[shared:]...)
+		scratch = binary.AppendUvarint(scratch, uint64(en.second-b.minSecond))
+		scratch = binary.AppendUvarint(scratch, uint64(en.count))
+		prev = en.geohash
+	}
+	zw.Write(scratch)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	b.size = int64(buf.Len())
+
+	// written under a temporary name so a crash never leaves a truncated block behind
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return b, nil
+}
+
+func readSpillHeader(path string) (*spillBlock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, spillHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[:4]) != spillMagic {
+		return nil, errSpillFormat
+	}
+	return &spillBlock{
+		path:      path,
+		replica:   strings.HasPrefix(filepath.Base(path), "r-"),
+		minSecond: int64(binary.BigEndian.Uint64(header[4:12])),
+		maxSecond: int64(binary.BigEndian.Uint64(header[12:20])),
+		shards:    binary.BigEndian.Uint32(header[20:24]),
+		size:      info.Size(),
+	}, nil
+}
+
+func (e *tieredEngine) load(b *spillBlock) ([]spillEntry, error) {
+	if entries, ok := e.cache.get(b.path); ok {
+		return entries, nil
+	}
+	entries, err := readSpillBlock(b)
+	if err != nil {
+		log.Printf("failed to read spill block %s: %v", b.path, err)
+		Metrics.storageErrorsTotal.WithLabelValues("read").Inc()
+		return nil, err
+	}
+	e.cache.put(b.path, entries)
+	return entries, nil
+}
+
+func readSpillBlock(b *spillBlock) ([]spillEntry, error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(spillHeaderSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(flate.NewReader(f))
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errSpillFormat
+	}
+	entries := make([]spillEntry, 0, min(n, 1<<20))
+	prev := ""
+	for i := uint64(0); i < n; i++ {
+		shared, err1 := binary.ReadUvarint(r)
+		restLen, err2 := binary.ReadUvarint(r)
+		if err1 != nil || err2 != nil || shared > uint64(len(prev)) || shared+restLen > MAX_GH_PRECISION {
+			return nil, errSpillFormat
+		}
+		rest := make([]byte, restLen)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return nil, errSpillFormat
+		}
+		offset, err1 := binary.ReadUvarint(r)
+		count, err2 := binary.ReadUvarint(r)
+		if err1 != nil || err2 != nil {
+			return nil, errSpillFormat
+		}
+		geohash := prev[:shared] + string(rest)
+		entries = append(entries, spillEntry{geohash: geohash, second: b.minSecond + int64(offset), count: int64(count)})
+		prev = geohash
+	}
+	return entries, nil
+}
+
+// small LRU of decoded blocks (path -> entries), so repeated queries don't re-read and inflate the same files
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // front = most recently used
+	items    map[string]*list.Element
+}
+
+type blockCacheEntry struct {
+	path    string
+	entries []spillEntry
+}
+
+func newBlockCache(capacity int) *blockCache {
+	return &blockCache{capacity: capacity, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *blockCache) get(path string) ([]spillEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[path]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*blockCacheEntry).entries, true
+}
+
+func (c *blockCache) put(path string, entries []spillEntry) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[path]; ok {
+		return
+	}
+	c.items[path] = c.ll.PushFront(&blockCacheEntry{path: path, entries: entries})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*blockCacheEntry).path)
+	}
+}
+
+func (c *blockCache) drop(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[path]; ok {
+		c.ll.Remove(el)
+		delete(c.items, path)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+// newTestTieredEngine opens a tiered engine in a temporary STORAGE_DIR, with a PING_TTL of 60 seconds of which the
+// newest 5 are in memory
+func newTestTieredEngine(t *testing.T, dir string) *tieredEngine {
+	previousTTL, previousAfter, previousSpan := PING_TTL, SPILL_AFTER, SPILL_BLOCK_SPAN
+	PING_TTL, SPILL_AFTER, SPILL_BLOCK_SPAN = 60, 5, 10
+	t.Cleanup(func() { PING_TTL, SPILL_AFTER, SPILL_BLOCK_SPAN = previousTTL, previousAfter, previousSpan })
+
+	e, err := newTieredEngine(dir)
+	if err != nil {
+		t.Fatalf("newTieredEngine: %v", err)
+	}
+	return e
+}
+
+// spillSeconds writes a block per second, count pings of gh each
+func spillSeconds(t *testing.T, e *tieredEngine, gh string, count int64, seconds ...int64) {
+	for _, s := range seconds {
+		if err := e.spill(ExpiredSecond{Second: s, Counts: map[string]int64{gh: count}}); err != nil {
+			t.Fatalf("spill %d: %v", s, err)
+		}
+	}
+}
+
+func TestTieredEngineSpillsSecondsLeavingMemory(t *testing.T) {
+	e := newTestTieredEngine(t, t.TempDir())
+	now := monotonicNow().Unix()
+	gh := geo.Encode(42.23, -8.72, MAX_GH_PRECISION)
+	other := geo.Encode(48.85, 2.35, MAX_GH_PRECISION)
+
+	for s := now - 20; s <= now; s++ {
+		e.SnapshotExpired(s, nil)
+		e.Ingest(gh, s, false)
+		e.Ingest(other, s, true)
+	}
+	if len(e.blocks) == 0 {
+		t.Fatalf("nothing spilled")
+	}
+	for _, b := range e.blocks {
+		if b.maxSecond > now-SPILL_AFTER {
+			t.Errorf("block %d-%d holds a second still in memory", b.minSecond, b.maxSecond)
+		}
+	}
+
+	if got := e.QueryPoint(gh, now, false); got != 21 {
+		t.Errorf("QueryPoint = %d, want 21 (memory and blocks)", got)
+	}
+	if got := e.QueryPoint(gh[:4], now, false); got != 21 {
+		t.Errorf("QueryPoint at precision 4 = %d, want 21", got)
+	}
+	if got := e.QueryPoint(gh, now, true); got != 0 {
+		t.Errorf("replica QueryPoint = %d, want 0", got)
+	}
+	if got := e.QueryPoint(other, now, true); got != 21 {
+		t.Errorf("replica QueryPoint = %d, want 21", got)
+	}
+
+	// the blocks as written decode to the same entries
+	for _, b := range e.blocks {
+		entries, err := readSpillBlock(b)
+		if err != nil {
+			t.Fatalf("readSpillBlock: %v", err)
+		}
+		for _, en := range entries {
+			if en.second < b.minSecond || en.second > b.maxSecond || en.count != 1 {
+				t.Errorf("block %d-%d: got entry %+v", b.minSecond, b.maxSecond, en)
+			}
+		}
+	}
+
+	bbox, _ := geo.Decode(gh[:4])
+	counts := e.QueryArea(AreaQuery{Precision: 6, AggPrecision: 4, Geohashes: []string{gh[:4]}, MinLat: bbox.MinLat, MaxLat: bbox.MaxLat, MinLng: bbox.MinLng, MaxLng: bbox.MaxLng}, now, false)
+	if counts[gh[:6]] != 21 || len(counts) != 1 {
+		t.Errorf("QueryArea = %v, want %s: 21", counts, gh[:6])
+	}
+}
+
+func TestSpillBlockRoundTrip(t *testing.T) {
+	e := newTestTieredEngine(t, t.TempDir())
+	entries := []spillEntry{
+		{geohash: "ezjmgtwq", second: 1000, count: 3},
+		{geohash: "ezjmgtwq", second: 1002, count: 1},
+		{geohash: "ezjmgtwr", second: 1001, count: 1 << 40},
+		{geohash: "u4pruydq", second: 1000, count: 2},
+		{geohash: "u4pr", second: 1001, count: 5}, // rolled up
+	}
+	sortSpillEntries(entries)
+	b, err := e.writeBlock(true, entries)
+	if err != nil {
+		t.Fatalf("writeBlock: %v", err)
+	}
+
+	header, err := readSpillHeader(b.path)
+	if err != nil {
+		t.Fatalf("readSpillHeader: %v", err)
+	}
+	if *header != *b {
+		t.Errorf("got header %+v, want %+v", header, b)
+	}
+	if !header.replica || header.minSecond != 1000 || header.maxSecond != 1002 || header.shards != shardMask("e")|shardMask("u") {
+		t.Errorf("got header %+v", header)
+	}
+	got, err := readSpillBlock(header)
+	if err != nil {
+		t.Fatalf("readSpillBlock: %v", err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("got %d entries, want %d", len(got), len(entries))
+	}
+	for i := range entries {
+		if got[i] != entries[i] {
+			t.Errorf("entry %d: got %+v, want %+v", i, got[i], entries[i])
+		}
+	}
+}
+
+func TestTieredEngineCompactsConsecutiveBlocks(t *testing.T) {
+	e := newTestTieredEngine(t, t.TempDir())
+	now := monotonicNow().Unix()
+	gh := geo.Encode(42.23, -8.72, MAX_GH_PRECISION)
+	first := now - 30
+	for s := first; s < first+25; s++ {
+		spillSeconds(t, e, gh, 1, s)
+	}
+	before := e.QueryPoint(gh, now, false)
+
+	if err := e.compact(false); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	// two full runs of SPILL_BLOCK_SPAN seconds, the last 5 are still growing
+	if len(e.blocks) != 2+5 {
+		t.Fatalf("got %d blocks after compaction, want 7", len(e.blocks))
+	}
+	for i, b := range e.blocks[:2] {
+		if b.minSecond != first+int64(i)*SPILL_BLOCK_SPAN || b.maxSecond != b.minSecond+SPILL_BLOCK_SPAN-1 {
+			t.Errorf("compacted block %d spans %d-%d", i, b.minSecond-first, b.maxSecond-first)
+		}
+	}
+	if got := e.QueryPoint(gh, now, false); got != before || got != 25 {
+		t.Errorf("QueryPoint = %d after compaction, %d before, want 25", got, before)
+	}
+
+	// a restart after a crash between the compaction and the removal of its inputs keeps the merged block only
+	reopened := newTestTieredEngine(t, e.dir)
+	if len(reopened.blocks) != 7 {
+		t.Errorf("got %d blocks after reopening, want 7", len(reopened.blocks))
+	}
+	if got := reopened.QueryPoint(gh, now, false); got != 25 {
+		t.Errorf("QueryPoint = %d after reopening, want 25", got)
+	}
+}
+
+func TestTieredEngineRecoversFromATruncatedBlock(t *testing.T) {
+	dir := t.TempDir()
+	e := newTestTieredEngine(t, dir)
+	now := monotonicNow().Unix()
+	gh := geo.Encode(42.23, -8.72, MAX_GH_PRECISION)
+	spillSeconds(t, e, gh, 1, now-40, now-39, now-38, now-25)
+
+	// the middle block loses its tail, another one its header, and a write was interrupted before its rename
+	truncated := e.blocks[1].path
+	data, err := os.ReadFile(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(truncated, data[:len(data)-4], 0o644)
+	headless := e.blocks[2].path
+	os.WriteFile(headless, data[:spillHeaderSize-1], 0o644)
+	os.WriteFile(filepath.Join(dir, "p-1-1-99"+spillFileSuffix+".tmp"), data, 0o644)
+
+	reopened := newTestTieredEngine(t, dir)
+	if len(reopened.blocks) != 3 {
+		t.Fatalf("got %d blocks, want the intact ones and the truncated one (its header is readable)", len(reopened.blocks))
+	}
+	if got := reopened.QueryPoint(gh, now, false); got != 2 {
+		t.Errorf("QueryPoint = %d, want 2 from the intact blocks", got)
+	}
+	if _, err := readSpillBlock(reopened.blocks[1]); err != errSpillFormat {
+		t.Errorf("reading the truncated block: got %v, want errSpillFormat", err)
+	}
+	// compacting it fails without losing the intact blocks
+	if err := reopened.compact(false); err == nil || len(reopened.blocks) != 3 {
+		t.Errorf("got %v with %d blocks", err, len(reopened.blocks))
+	}
+}
+
+func TestTieredEngineWindowBoundaries(t *testing.T) {
+	e := newTestTieredEngine(t, t.TempDir())
+	now := monotonicNow().Unix()
+	gh := geo.Encode(42.23, -8.72, MAX_GH_PRECISION)
+	spillSeconds(t, e, gh, 1, now-PING_TTL, now-PING_TTL+1, now-20, now-19, now-SPILL_AFTER)
+
+	if got := e.QueryPoint(gh, now, false); got != 4 {
+		t.Errorf("QueryPoint = %d, want 4 (the second PING_TTL ago is out of the window)", got)
+	}
+	bbox, _ := geo.Decode(gh[:4])
+	query := AreaQuery{Precision: 8, AggPrecision: 4, Geohashes: []string{gh[:4]}, MinLat: bbox.MinLat, MaxLat: bbox.MaxLat, MinLng: bbox.MinLng, MaxLng: bbox.MaxLng}
+	for _, tt := range []struct {
+		window int64
+		want   int64
+	}{
+		{0, 4},
+		{PING_TTL, 4},
+		{21, 3}, // now-20 on
+		{20, 2}, // now-19 on: now-20 left it
+		{6, 1},  // now-5 on
+		{5, 0},  // now-4 on: only memory
+		{PING_TTL + 1, 4},
+	} {
+		query.Window = tt.window
+		if got := e.QueryArea(query, now, false)[gh]; got != tt.want {
+			t.Errorf("window %d: QueryArea = %d, want %d", tt.window, got, tt.want)
+		}
+	}
+
+	// the blocks of the seconds leaving the window are dropped, their data passed on
+	for _, second := range []int64{now, now + 1} {
+		var expired []int64
+		e.SnapshotExpired(second, func(exp ExpiredSecond) { expired = append(expired, exp.Second) })
+		if len(expired) != 1 || expired[0] != second-PING_TTL {
+			t.Errorf("at %d: got expired seconds %v, want [%d]", second-now, expired, second-PING_TTL)
+		}
+	}
+	if got := e.QueryPoint(gh, now+1, false); got != 3 {
+		t.Errorf("QueryPoint = %d a second later, want 3", got)
+	}
+}
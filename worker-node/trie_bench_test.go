@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"geostreamdb/geo"
+)
+
+// go test -bench . -benchmem (run before/after trie layout changes)
+
+func benchGeohashes(n int) []string {
+	// pings spread around a few city-sized hotspots, like real traffic
+	rng := rand.New(rand.NewSource(1))
+	centers := [][2]float64{{42.23, -8.72}, {40.41, -3.70}, {48.85, 2.35}, {51.50, -0.12}}
+	out := make([]string, n)
+	for i := range out {
+		c := centers[rng.Intn(len(centers))]
+		out[i] = geo.Encode(c[0]+rng.NormFloat64()*0.05, c[1]+rng.NormFloat64()*0.05, MAX_GH_PRECISION)
+	}
+	return out
+}
+
+func BenchmarkTrieIncrement(b *testing.B) {
+	ghs := benchGeohashes(4096)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	root := newTrieNode()
+	for i := 0; i < b.N; i++ {
+		if i%len(ghs) == 0 {
+			// new slot every pass, like a time buffer rotation
+			root = newTrieNode()
+		}
+		root.Increment(ghs[i%len(ghs)])
+	}
+}
+
+func BenchmarkTrieGetCount(b *testing.B) {
+	ghs := benchGeohashes(4096)
+	root := newTrieNode()
+	for _, gh := range ghs {
+		root.Increment(gh)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		root.GetCount(ghs[i%len(ghs)])
+	}
+}
+
+func BenchmarkTrieGetAreaCount(b *testing.B) {
+	ghs := benchGeohashes(4096)
+	root := newTrieNode()
+	for _, gh := range ghs {
+		root.Increment(gh)
+	}
+	cover := []string{geo.Encode(42.23, -8.72, 4)} // first hotspot
+	if len(root.GetAreaCount(6, 4, 42.13, 42.33, -8.82, -8.62, cover)) == 0 {
+		b.Fatal("empty area result")
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		root.GetAreaCount(6, 4, 42.13, 42.33, -8.82, -8.62, cover)
+	}
+}
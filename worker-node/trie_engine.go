@@ -0,0 +1,362 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"geostreamdb/geo"
+)
+
+// the default engine: one trie per (TTL second, shard) slot, rotated to a fresh trie when its second comes around again
+
+type TimeBufferSlot struct {
+	Mutex   sync.Mutex                        // serializes writers and rotation of each (TTL second, shard) slot. readers never lock
+	Data    atomic.Pointer[TimeBufferElement] // swapped to a fresh element once per second (see current)
+	expired *TimeBufferElement                // replaced element, until SnapshotExpired collects it (guarded by Mutex)
+}
+
+type TimeBufferElement struct {
+	Timestamp int64
+	TrieRoot  *TrieNode
+	Movement  atomic.Pointer[movementNode]        // of the pings with a speed (see movement.go), nil until the first
+	Floors    atomic.Pointer[map[int32]*TrieNode] // of the pings with a floor (see floors.go), copied on write
+}
+
+// each second is split into one sub-trie per first geohash character, so that concurrent writes to different regions
+// don't contend on the same lock
+const TIME_BUFFER_SHARDS = 32
+
+type trieEngine struct {
+	ttl int64 // seconds held (PING_TTL, or the in-memory part of a tiered engine)
+
+	// flattened [second][shard]: slot index = (timestamp % ttl) * TIME_BUFFER_SHARDS + shard
+	primary []*TimeBufferSlot
+	replica []*TimeBufferSlot // pings held on behalf of another primary (gateway REPLICATION_FACTOR > 1)
+}
+
+func newTrieEngine(ttl int64) *trieEngine {
+	e := &trieEngine{
+		ttl:     ttl,
+		primary: make([]*TimeBufferSlot, ttl*TIME_BUFFER_SHARDS),
+		replica: make([]*TimeBufferSlot, ttl*TIME_BUFFER_SHARDS),
+	}
+	for i := range e.primary {
+		e.primary[i] = &TimeBufferSlot{}
+		e.replica[i] = &TimeBufferSlot{}
+	}
+	return e
+}
+
+// shardIndex returns the time buffer shard of a geohash (its first character). empty or invalid geohashes map to shard 0
+func shardIndex(geohash string) int {
+	if geohash == "" {
+		return 0
+	}
+	return max(int(geohashCharToIndex[geohash[0]]), 0)
+}
+
+func (e *trieEngine) buffer(replica bool) []*TimeBufferSlot {
+	// replica data is kept apart so that broadcast area queries (primary data only) don't count a ping twice
+	if replica {
+		return e.replica
+	}
+	return e.primary
+}
+
+// current returns the slot element for the given second, replacing an older one. must be called with the slot mutex
+// held. readers only ever see a complete element, swapped in atomically
+func (slot *TimeBufferSlot) current(second int64) *TimeBufferElement {
+	data := slot.Data.Load()
+	if data != nil && data.Timestamp >= second {
+		return data
+	}
+	next := &TimeBufferElement{Timestamp: second, TrieRoot: newTrieNode()}
+	slot.Data.Store(next)
+	if data != nil {
+		slot.expired = data // replacing one never collected (no rotation for a whole TTL)
+	}
+	return next
+}
+
+func (e *trieEngine) Ingest(geohash string, second int64, replica bool) {
+	slot := e.buffer(replica)[int(second%e.ttl)*TIME_BUFFER_SHARDS+shardIndex(geohash)]
+
+	slot.Mutex.Lock()
+	defer slot.Mutex.Unlock()
+
+	// normally already rotated in by SnapshotExpired; swapped here if this write beat it at the second boundary
+	start := startTrieTiming()
+	slot.current(second).TrieRoot.Increment(geohash)
+	observeTrieTiming(trieOpIncrement, start)
+}
+
+func (e *trieEngine) QueryPoint(geohash string, now int64, replica bool) int64 {
+	cutoff := now - e.ttl
+	total := int64(0)
+	buffer := e.buffer(replica)
+
+	// only the geohash's own shard can hold it (an empty geohash counts every shard)
+	firstShard, lastShard := shardIndex(geohash), shardIndex(geohash)
+	if geohash == "" {
+		firstShard, lastShard = 0, TIME_BUFFER_SHARDS-1
+	}
+
+	for i := 0; i < int(e.ttl); i++ {
+		for shard := firstShard; shard <= lastShard; shard++ {
+			data := buffer[i*TIME_BUFFER_SHARDS+shard].Data.Load()
+
+			// avoid stale/nil data
+			if data != nil && data.Timestamp >= cutoff {
+				start := startTrieTiming()
+				total += data.TrieRoot.GetCount(geohash)
+				observeTrieTiming(trieOpGetCount, start)
+			}
+		}
+	}
+	return total
+}
+
+func (e *trieEngine) QueryPoints(geohashes []string, now int64, replica bool) []int64 {
+	cutoff := now - e.ttl
+	counts := make([]int64, len(geohashes))
+	buffer := e.buffer(replica)
+
+	// each geohash lives in the shard of its first character
+	var byShard [TIME_BUFFER_SHARDS][]int
+	for i, gh := range geohashes {
+		shard := shardIndex(gh)
+		byShard[shard] = append(byShard[shard], i)
+	}
+
+	for i := 0; i < int(e.ttl); i++ {
+		for shard, indices := range byShard {
+			if len(indices) == 0 {
+				continue
+			}
+			data := buffer[i*TIME_BUFFER_SHARDS+shard].Data.Load()
+			if data == nil || data.Timestamp < cutoff {
+				continue
+			}
+			start := startTrieTiming()
+			for _, j := range indices {
+				counts[j] += data.TrieRoot.GetCount(geohashes[j])
+			}
+			observeTrieTiming(trieOpGetCount, start)
+		}
+	}
+	return counts
+}
+
+func (e *trieEngine) QueryArea(q AreaQuery, now int64, replica bool) map[string]int64 {
+	cutoff := q.cutoff(now, e.ttl)
+	combined := make(map[string]int64)
+	buffer := e.buffer(replica)
+
+	// each covered geohash lives in the shard of its first character
+	var byShard [TIME_BUFFER_SHARDS][]string
+	for _, gh := range q.Geohashes {
+		if gh == "" || geohashCharToIndex[gh[0]] < 0 {
+			continue
+		}
+		shard := shardIndex(gh)
+		byShard[shard] = append(byShard[shard], gh)
+	}
+
+	for i := 0; i < int(e.ttl); i++ {
+		for shard, shardGeohashes := range byShard {
+			if len(shardGeohashes) == 0 {
+				continue
+			}
+			data := buffer[i*TIME_BUFFER_SHARDS+shard].Data.Load()
+
+			// avoid stale/nil data
+			if data != nil && data.Timestamp >= cutoff && data.TrieRoot != nil {
+				start := startTrieTiming()
+				m := data.root(q).GetAreaCount(q.Precision, q.AggPrecision, q.MinLat, q.MaxLat, q.MinLng, q.MaxLng, shardGeohashes)
+				observeTrieTiming(trieOpArea, start)
+				for gh, c := range m {
+					combined[gh] += c
+				}
+			}
+		}
+	}
+	return combined
+}
+
+func (e *trieEngine) QueryAreaBySecond(q AreaQuery, now int64, replica bool) []map[string]int64 {
+	cutoff := q.cutoff(now, e.ttl)
+	bySecond := make([]map[string]int64, now-cutoff+1)
+	buffer := e.buffer(replica)
+
+	var byShard [TIME_BUFFER_SHARDS][]string
+	for _, gh := range q.Geohashes {
+		if gh == "" || geohashCharToIndex[gh[0]] < 0 {
+			continue
+		}
+		shard := shardIndex(gh)
+		byShard[shard] = append(byShard[shard], gh)
+	}
+
+	for i := 0; i < int(e.ttl); i++ {
+		for shard, shardGeohashes := range byShard {
+			if len(shardGeohashes) == 0 {
+				continue
+			}
+			data := buffer[i*TIME_BUFFER_SHARDS+shard].Data.Load()
+			if data == nil || data.Timestamp < cutoff || data.TrieRoot == nil {
+				continue
+			}
+			start := startTrieTiming()
+			m := data.root(q).GetAreaCount(q.Precision, q.AggPrecision, q.MinLat, q.MaxLat, q.MinLng, q.MaxLng, shardGeohashes)
+			observeTrieTiming(trieOpArea, start)
+			age := max(now-data.Timestamp, 0)
+			if bySecond[age] == nil {
+				bySecond[age] = make(map[string]int64, len(m))
+			}
+			for gh, c := range m {
+				bySecond[age][gh] += c
+			}
+		}
+	}
+	return bySecond
+}
+
+// SnapshotExpired swaps a fresh trie into every slot of the new second (replacing whatever expired data the slot still
+// held), so readers never see a slot being reinitialized. the replaced tries are walked for fn, then dropped
+func (e *trieEngine) SnapshotExpired(second int64, fn func(ExpiredSecond)) {
+	idx := int(second%e.ttl) * TIME_BUFFER_SHARDS
+	for _, replica := range []bool{false, true} {
+		var expired []*TimeBufferElement
+		for _, slot := range e.buffer(replica)[idx : idx+TIME_BUFFER_SHARDS] {
+			slot.Mutex.Lock()
+			slot.current(second)
+			if slot.expired != nil {
+				expired = append(expired, slot.expired)
+				slot.expired = nil
+			}
+			slot.Mutex.Unlock()
+		}
+
+		if len(expired) > 0 {
+			observeExpiredTries(expired, replica)
+		}
+
+		if fn != nil && len(expired) > 0 {
+			// normally all shards expire the same second; older ones if the slot had no writes for a while
+			bySecond := make(map[int64]map[string]int64)
+			for _, data := range expired {
+				if data.TrieRoot.Count.Load() == 0 {
+					continue
+				}
+				counts := bySecond[data.Timestamp]
+				if counts == nil {
+					counts = make(map[string]int64)
+					bySecond[data.Timestamp] = counts
+				}
+				data.TrieRoot.Walk(func(geohash string, count int64) { counts[geohash] += count })
+			}
+			seconds := make([]int64, 0, len(bySecond))
+			for s := range bySecond {
+				seconds = append(seconds, s)
+			}
+			sort.Slice(seconds, func(i, j int) bool { return seconds[i] < seconds[j] })
+			for _, s := range seconds {
+				fn(ExpiredSecond{Second: s, Replica: replica, Counts: bySecond[s]})
+			}
+		}
+	}
+}
+
+func (e *trieEngine) Truncate(precision int) {
+	for _, buffer := range [][]*TimeBufferSlot{e.primary, e.replica} {
+		for _, slot := range buffer {
+			slot.Mutex.Lock()
+			if data := slot.Data.Load(); data != nil && data.TrieRoot != nil {
+				data.TrieRoot.Truncate(precision)
+				data.Movement.Load().truncate(precision)
+				if floors := data.Floors.Load(); floors != nil {
+					for _, t := range *floors {
+						t.Truncate(precision)
+					}
+				}
+			}
+			slot.Mutex.Unlock()
+		}
+	}
+}
+
+// CoveredPrefixes walks the tries of every live primary slot (replica data is never read by broadcast queries)
+func (e *trieEngine) CoveredPrefixes(maxLen int, fn func(prefix []byte)) {
+	type stackItem struct {
+		node   *TrieNode
+		prefix []byte
+	}
+
+	for _, slot := range e.primary {
+		data := slot.Data.Load()
+		if data == nil || data.TrieRoot == nil {
+			continue
+		}
+		stack := []stackItem{{node: data.TrieRoot}}
+		for len(stack) > 0 {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if len(n.prefix) > 0 {
+				fn(n.prefix)
+			}
+			if len(n.prefix) >= maxLen {
+				continue
+			}
+			children := n.node.Children.Load()
+			if children == nil {
+				continue
+			}
+			for idx := range children {
+				child := children[idx].Load()
+				if child == nil {
+					continue
+				}
+				prefix := make([]byte, len(n.prefix)+1)
+				copy(prefix, n.prefix)
+				prefix[len(n.prefix)] = geo.Base32[idx]
+				stack = append(stack, stackItem{node: child, prefix: prefix})
+			}
+		}
+	}
+}
+
+// Walk calls fn with every geohash that pings were stored at and its count (a node's own pings are its count minus its
+// descendants', e.g. pings stored at a coarser precision before a rollup raised it)
+func (t *TrieNode) Walk(fn func(geohash string, count int64)) {
+	t.walk(nil, fn)
+}
+
+func (t *TrieNode) walk(prefix []byte, fn func(geohash string, count int64)) {
+	if t == nil {
+		return
+	}
+	own := t.Count.Load()
+	if children := t.Children.Load(); children != nil {
+		for idx := range children {
+			child := children[idx].Load()
+			if child == nil {
+				continue
+			}
+			own -= child.Count.Load()
+			child.walk(append(prefix, geo.Base32[idx]), fn)
+		}
+	}
+	if leaves := t.DenseLeaves.Load(); leaves != nil {
+		for idx := range leaves {
+			if count := leaves[idx].Load(); count > 0 {
+				own -= count
+				fn(string(append(prefix, geo.Base32[idx])), count)
+			}
+		}
+	}
+	if own > 0 {
+		fn(string(prefix), own)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+	"unsafe"
+
+	"geostreamdb/env"
+)
+
+// self-instrumentation of the trie engine for capacity planning: sampled latencies of the trie operations and the size
+// of every slot's trie when it expires (the size of one second of data, per shard)
+var TRIE_TIMING_SAMPLE = env.Int("TRIE_TIMING_SAMPLE", 16) // time 1 in N operations (0 disables)
+
+const (
+	trieOpIncrement = "increment"
+	trieOpGetCount  = "get_count"
+	trieOpArea      = "area"
+)
+
+// startTrieTiming returns the start time of a sampled operation (zero if not sampled)
+func startTrieTiming() time.Time {
+	if TRIE_TIMING_SAMPLE <= 0 || (TRIE_TIMING_SAMPLE > 1 && rand.IntN(TRIE_TIMING_SAMPLE) != 0) {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func observeTrieTiming(op string, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	Metrics.trieOpLatency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// memory held by a trie node and the arrays it allocates, for estimates of the time buffers' size
+const (
+	trieNodeBytes     = int64(unsafe.Sizeof(TrieNode{}))
+	trieChildrenBytes = int64(unsafe.Sizeof(trieChildren{}))
+	denseLeavesBytes  = int64(unsafe.Sizeof(denseLeaves{}))
+)
+
+// trieStats returns the nodes of a trie (dense leaves with pings included), its depth and the memory it holds. the
+// trie must no longer be written to
+func trieStats(t *TrieNode) (nodes int64, depth int, bytes int64) {
+	if t == nil {
+		return 0, 0, 0
+	}
+	nodes, bytes = 1, trieNodeBytes
+	if leaves := t.DenseLeaves.Load(); leaves != nil {
+		bytes += denseLeavesBytes
+		for i := range leaves {
+			if leaves[i].Load() != 0 {
+				nodes++
+				depth = 1
+			}
+		}
+	}
+	if children := t.Children.Load(); children != nil {
+		bytes += trieChildrenBytes
+		for i := range children {
+			if child := children[i].Load(); child != nil {
+				n, d, b := trieStats(child)
+				nodes += n
+				depth = max(depth, d+1)
+				bytes += b
+			}
+		}
+	}
+	return nodes, depth, bytes
+}
+
+// observeExpiredTries records the size of the tries of an expired second
+func observeExpiredTries(expired []*TimeBufferElement, replica bool) {
+	buffer := "primary"
+	if replica {
+		buffer = "replica"
+	}
+	total, totalBytes, maxDepth := int64(0), int64(0), 0
+	for _, data := range expired {
+		nodes, depth, bytes := trieStats(data.TrieRoot)
+		Metrics.trieSlotNodes.WithLabelValues(buffer).Observe(float64(nodes))
+		Metrics.trieSlotBytes.WithLabelValues(buffer).Observe(float64(bytes))
+		total += nodes
+		totalBytes += bytes
+		maxDepth = max(maxDepth, depth)
+	}
+	Metrics.trieSecondNodes.WithLabelValues(buffer).Set(float64(total))
+	Metrics.trieSecondBytes.WithLabelValues(buffer).Set(float64(totalBytes))
+	Metrics.trieDepth.WithLabelValues(buffer).Set(float64(maxDepth))
+}
+
+// SlotUsage walks the live tries of a buffer. they may be written to meanwhile, so sizes are approximate
+func (e *trieEngine) SlotUsage(now int64, replica bool) slotUsage {
+	buffer := e.buffer(replica)
+	usage := slotUsage{slots: len(buffer)}
+	for _, slot := range buffer {
+		data := slot.Data.Load()
+		if data == nil || data.Timestamp < now-e.ttl {
+			continue
+		}
+		nodes, depth, bytes := trieStats(data.TrieRoot)
+		if nodes <= 1 {
+			continue // an empty root
+		}
+		usage.occupied++
+		usage.trieNodes += nodes
+		usage.trieBytes += bytes
+		usage.trieDepth = max(usage.trieDepth, depth)
+	}
+	return usage
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"geostreamdb/env"
+)
+
+// worker identity: gateways place a worker on their rings by its worker id, so a worker restarting under a new one is a
+// new ring member (its keys move) while its old entry lingers until its heartbeats expire. WORKER_ID sets the id; with
+// WORKER_ID_FILE (on a volume that survives restarts, one file per worker) the id generated at the first start is saved
+// there and announced again by the next ones, which keep their place on the rings. the starts under the saved id are
+// counted in worker_restarts. a primary replaced by its standby (which took its id over) drops the saved id, rejoining
+// with a new one once restarted
+var WORKER_ID = strings.TrimSpace(env.String("WORKER_ID", ""))
+var WORKER_ID_FILE = env.String("WORKER_ID_FILE", "")
+
+type savedWorkerId struct {
+	WorkerId string `json:"workerId"`
+	Starts   int64  `json:"starts"`
+}
+
+var workerId = loadWorkerId()
+
+// loadWorkerId returns the id this worker announces: WORKER_ID, the one saved in WORKER_ID_FILE or a new one (saved)
+func loadWorkerId() string {
+	if WORKER_ID != "" {
+		return WORKER_ID
+	}
+	if WORKER_ID_FILE == "" {
+		return ids.NewID()
+	}
+
+	var saved savedWorkerId
+	b, err := os.ReadFile(WORKER_ID_FILE)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		log.Fatalf("failed to read WORKER_ID_FILE: %v", err)
+	default:
+		if err := json.Unmarshal(b, &saved); err != nil {
+			log.Fatalf("failed to parse WORKER_ID_FILE: %v", err)
+		}
+	}
+	if saved.WorkerId == "" {
+		saved = savedWorkerId{WorkerId: ids.NewID()}
+	} else {
+		log.Printf("restarting as worker id %s (saved in %s)", saved.WorkerId, WORKER_ID_FILE)
+	}
+	saved.Starts++
+	if err := saveWorkerId(saved); err != nil {
+		log.Fatalf("failed to write WORKER_ID_FILE: %v", err)
+	}
+	Metrics.restarts.Set(float64(saved.Starts - 1))
+	return saved.WorkerId
+}
+
+// saveWorkerId replaces WORKER_ID_FILE atomically
+func saveWorkerId(saved savedWorkerId) error {
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp := WORKER_ID_FILE + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, WORKER_ID_FILE)
+}
+
+// dropSavedWorkerId forgets the saved id, once the registry refused it (taken over by this worker's standby)
+func dropSavedWorkerId() {
+	if WORKER_ID_FILE == "" || WORKER_ID != "" {
+		return
+	}
+	if err := os.Remove(WORKER_ID_FILE); err != nil {
+		if !errors.Is(err, os.ErrNotExist) { // already removed after an earlier refusal otherwise
+			log.Printf("failed to remove WORKER_ID_FILE: %v", err)
+		}
+		return
+	}
+	log.Printf("worker id %s taken over by the standby: removed from %s, restart to rejoin with a new one", workerId, WORKER_ID_FILE)
+}
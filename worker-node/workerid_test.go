@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkerIdIsKeptAcrossRestarts(t *testing.T) {
+	previousId, previousFile := WORKER_ID, WORKER_ID_FILE
+	t.Cleanup(func() { WORKER_ID, WORKER_ID_FILE = previousId, previousFile })
+	WORKER_ID, WORKER_ID_FILE = "", filepath.Join(t.TempDir(), "worker_id")
+
+	first := loadWorkerId()
+	if second := loadWorkerId(); second != first || first == "" {
+		t.Fatalf("restarted as %q, first started as %q", second, first)
+	}
+	b, err := os.ReadFile(WORKER_ID_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved savedWorkerId
+	if err := json.Unmarshal(b, &saved); err != nil || saved != (savedWorkerId{WorkerId: first, Starts: 2}) {
+		t.Fatalf("saved %s (%v), want %q started twice", b, err, first)
+	}
+
+	dropSavedWorkerId() // taken over by a standby
+	if again := loadWorkerId(); again == first {
+		t.Fatal("the id taken over by the standby was announced again")
+	}
+
+	WORKER_ID = "worker-7"
+	if got := loadWorkerId(); got != "worker-7" {
+		t.Fatalf("got %q, want WORKER_ID", got)
+	}
+}